@@ -0,0 +1,157 @@
+package glint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrFieldExists is returned by AppendField when the document already has a top level field of the same name, and
+// by DocumentBuilder.Build for documents built with two fields of the same name
+var ErrFieldExists = errors.New("field already exists in document")
+
+// fieldEncoders holds the encoder built for each field added by AppendField
+var fieldEncoders sync.Map // appendedField -> *encoderImpl
+
+// appendedField identifies the encoder of a field added by AppendField
+type appendedField struct {
+	t    reflect.Type
+	name string
+}
+
+// AppendField adds a top level field named name, holding value, to the end of an encoded document. The schema is
+// rewritten with the new field after the others and its value is written after the rest of the body, leaving the
+// fields already in the document as they are, so a document can be stamped with a trace ID, say, without being
+// decoded. Decoders with a field of the same name read it as they would any other, and those without skip it.
+//
+//	doc, err = glint.AppendField(doc, "trace_id", traceID)
+//
+// value is written as a field of its type would be by an Encoder. Compressed documents are decompressed and
+// compressed again with the codec they were written with, which has to be registered, and documents with a string
+// table keep it, with any dictionary strings of the new value added to the end. Documents written as format
+// version 3 or newer lose the hashes of their field names, and are written as version 2, or as version 4 when
+// either they or value hold a slice of pointers.
+//
+// Documents written without their schema, as in trusted schema mode or with a schema registry, return
+// ErrSchemaNotFound, and documents that already have a field called name return ErrFieldExists. The document
+// passed in is never modified.
+func AppendField(doc []byte, name string, value any) (out []byte, err error) {
+	if value == nil {
+		return nil, errors.New("glint: cannot append a nil value")
+	}
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, ",\"") {
+		return nil, fmt.Errorf("glint: invalid field name %q", name)
+	}
+
+	defer recoverErr(&err)
+
+	p, err := splitDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return nil, ErrSchemaNotFound
+	}
+
+	e, err := fieldEncoder(reflect.TypeOf(value), name)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(e.Schema().Bytes)
+	field := r.Read(r.ReadVarint())
+
+	// documents without references or constraints keep their schema exactly as it was written, the rest have both
+	// expanded so that the new field's schema can follow them
+	schema, version := p.schema.Remaining(), byte(formatVersionOriginal)
+	if p.flags&flagVersionMask != formatVersionOriginal || e.schema.Bytes[0]&flagVersionMask != formatVersionOriginal {
+		if schema, err = expandSchema(schema, DefaultLimits); err != nil {
+			return nil, err
+		}
+		if field, err = expandSchema(field, DefaultLimits); err != nil {
+			return nil, err
+		}
+		version = formatVersionConstraint
+		if p.flags&flagVersionMask == formatVersionPointers || e.schema.Bytes[0]&flagVersionMask == formatVersionPointers {
+			version = formatVersionPointers
+		}
+	}
+
+	if err := checkNesting(NewReader(schema), DefaultLimits); err != nil {
+		return nil, err
+	}
+	for _, f := range parseSchemaNodes(NewReader(schema)) {
+		if f.name == name {
+			return nil, fmt.Errorf("%w: %q", ErrFieldExists, name)
+		}
+	}
+
+	l := uint(len(schema) + len(field))
+	b := &Buffer{Bytes: make([]byte, 5, 5+binary.MaxVarintLen64+int(l)+len(p.written)+64)}
+	b.AppendUint(l)
+	b.Bytes = append(b.Bytes, schema...)
+	b.Bytes = append(b.Bytes, field...)
+	if version == formatVersionPointers {
+		b.AppendBytes(nil) // an empty list of name hashes, which the layout of version 4 has after the schema
+	}
+	binary.LittleEndian.PutUint32(b.Bytes[1:5], crc32.ChecksumIEEE(b.Bytes[5:]))
+
+	// the body is copied as it was written, decompressed and without its table where it has either
+	body := len(b.Bytes)
+	b.Bytes = append(b.Bytes, p.body.Remaining()...)
+	if p.body.strings != nil {
+		b.strings.index = make(map[string]uint, len(*p.body.strings))
+		for i, s := range *p.body.strings {
+			b.strings.index[s] = uint(i)
+		}
+		b.strings.values = append(b.strings.values, *p.body.strings...)
+	}
+
+	// a struct holding a single field has it at the start, so a pointer to the value is a pointer to the struct
+	v := reflect.New(reflect.TypeOf(value))
+	v.Elem().Set(reflect.ValueOf(value))
+	e.marshalBody(v.UnsafePointer(), b)
+
+	flags := version
+	if b.appendStringTable() {
+		flags |= flagStringTable
+	}
+	if codec := Codec(p.flags >> 4); codec != CodecNone {
+		compressed, err := compressBodyWith(codec, b, body)
+		if err != nil {
+			return nil, err
+		}
+		if compressed {
+			flags |= byte(codec) << 4
+		}
+	}
+
+	b.Bytes[0] = flags
+	return b.Bytes, nil
+}
+
+// fieldEncoder returns the encoder of a document holding a single field of type t called name, built on first use
+func fieldEncoder(t reflect.Type, name string) (e *encoderImpl, err error) {
+	key := appendedField{t: t, name: name}
+	if e, ok := fieldEncoders.Load(key); ok {
+		return e.(*encoderImpl), nil
+	}
+
+	defer recoverErrAs(&err, fmt.Errorf("glint: cannot append a %v", t)) // encoders panic on types they can't write
+
+	s := reflect.StructOf([]reflect.StructField{{
+		Name: "Value",
+		Type: t,
+		Tag:  reflect.StructTag(fmt.Sprintf("glint:%q", name)),
+	}})
+	e = newRootEncoder(reflect.New(s).Elem().Interface(), "glint", newEncoderConfig(nil))
+	if len(e.instructions) == 0 { // fields of unsupported types are left out rather than refused
+		return nil, fmt.Errorf("glint: cannot append a %v", t)
+	}
+
+	actual, _ := fieldEncoders.LoadOrStore(key, e)
+	return actual.(*encoderImpl), nil
+}
@@ -0,0 +1,186 @@
+package glint
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Arena is a bump allocator that decoded strings, slices and the values of pointer fields are allocated from, so
+// that decoding a document costs a few large allocations rather than one per value. Pass one to a decode through
+// DecoderContext.Arena:
+//
+//	arena := glint.NewArena(64 * 1024)
+//	err := dec.UnmarshalWithContext(data, &v, glint.DecoderContext{Arena: arena})
+//	// ... use v
+//	arena.Reset() // v, and everything decoded into it, must no longer be used
+//
+// Strings decoded into an arena are copies, so they stay valid once the document's bytes are reused, but only
+// until the arena is reset. Maps are allocated as usual, as are values too large to share a chunk with others.
+// An Arena isn't safe for concurrent use, give each goroutine or request its own.
+type Arena struct {
+	size     int                          // bytes in each chunk
+	chunk    []byte                       // memory for values without pointers, strings and slices of numbers
+	used     int                          // bytes of chunk handed out
+	typed    map[reflect.Type]*typedChunk // memory for values holding pointers, typed so the GC can see them
+	pointers map[reflect.Type]bool        // whether each type allocated so far holds pointers
+}
+
+// typedChunk is the backing array of a slice values of one type are handed out from
+type typedChunk struct {
+	slice     reflect.Value
+	zero      reflect.Value // a slice of zero values to clear slice with, made on the first Reset
+	base      unsafe.Pointer
+	elem      uintptr // size of each value
+	used, len int
+}
+
+// NewArena creates an arena that allocates chunks of size bytes as it needs them. Values larger than a quarter
+// of size are allocated on their own.
+func NewArena(size int) *Arena {
+	if size < 64 {
+		size = 64
+	}
+	return &Arena{size: size}
+}
+
+// Reset releases everything allocated from the arena at once, and hands its current chunks out again, so values
+// decoded before Reset must not be used after it.
+func (a *Arena) Reset() {
+	a.used = 0
+
+	for t, c := range a.typed {
+		if c.used == 0 {
+			continue
+		}
+		if !c.zero.IsValid() {
+			c.zero = reflect.MakeSlice(reflect.SliceOf(t), c.len, c.len)
+		}
+		reflect.Copy(c.slice.Slice(0, c.used), c.zero) // copied rather than cleared as bytes, so the GC sees it
+		c.used = 0
+	}
+}
+
+// bytes returns n bytes aligned to align, which must be a power of two
+func (a *Arena) bytes(n, align int) []byte {
+	if n > a.size/4 {
+		return make([]byte, n)
+	}
+
+	off := (a.used + align - 1) &^ (align - 1)
+	if off+n > len(a.chunk) {
+		a.chunk, off = make([]byte, a.size), 0 // the old chunk lives on for as long as anything refers to it
+	}
+	a.used = off + n
+
+	b := a.chunk[off : off+n : off+n]
+	for i := range b { // memory handed out before a Reset is handed out again
+		b[i] = 0
+	}
+	return b
+}
+
+// copyString returns a copy of b held in the arena
+func (a *Arena) copyString(b []byte) string {
+	s := a.bytes(len(b), 1)
+	copy(s, b)
+	return bytesToString(s)
+}
+
+// alloc returns zeroed memory for n values of type t
+func (a *Arena) alloc(t reflect.Type, n int) unsafe.Pointer {
+	size := t.Size() * uintptr(n)
+
+	if !a.hasPointers(t) {
+		b := a.bytes(int(size), t.Align())
+		return unsafe.Pointer(unsafe.SliceData(b))
+	}
+
+	c := a.typed[t]
+	if c == nil || c.used+n > c.len {
+		if t.Size() == 0 || int(size) > a.size/4 {
+			return reflect.MakeSlice(reflect.SliceOf(t), n, n).UnsafePointer()
+		}
+
+		l := a.size / int(t.Size())
+		s := reflect.MakeSlice(reflect.SliceOf(t), l, l)
+		c = &typedChunk{slice: s, base: s.UnsafePointer(), elem: t.Size(), len: l}
+		if a.typed == nil {
+			a.typed = map[reflect.Type]*typedChunk{}
+		}
+		a.typed[t] = c
+	}
+
+	p := unsafe.Add(c.base, uintptr(c.used)*c.elem)
+	c.used += n
+	return p
+}
+
+// hasPointers reports whether values of t hold pointers the GC has to see
+func (a *Arena) hasPointers(t reflect.Type) bool {
+	if p, ok := a.pointers[t]; ok {
+		return p
+	}
+	if a.pointers == nil {
+		a.pointers = map[reflect.Type]bool{}
+	}
+	p := typeHasPointers(t)
+	a.pointers[t] = p
+	return p
+}
+
+// typeHasPointers reports whether values of t hold pointers
+func typeHasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Array:
+		return t.Len() > 0 && typeHasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if typeHasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// newValue returns a pointer to a new zero value of type t, from the reader's arena when it has one
+func (r *Reader) newValue(t reflect.Type) unsafe.Pointer {
+	if r.arena != nil {
+		return r.arena.alloc(t, 1)
+	}
+	return reflect.New(t).UnsafePointer()
+}
+
+// newSlice returns the backing array of a new slice of type t, from the reader's arena when it has one
+func (r *Reader) newSlice(t reflect.Type, len, cap int) unsafe.Pointer {
+	if r.arena != nil {
+		return r.arena.alloc(t.Elem(), cap)
+	}
+	return reflect.MakeSlice(t, len, cap).UnsafePointer()
+}
+
+// makeSlice returns a new empty slice of capacity n, from the reader's arena when it has one
+func makeSlice[T any](r *Reader, n uint) []T {
+	if r.arena == nil {
+		return make([]T, 0, n)
+	}
+	var zero T
+	return unsafe.Slice((*T)(r.arena.alloc(reflect.TypeOf(zero), int(n))), n)[:0]
+}
+
+// str returns b as a string, copied into the reader's arena when it has one, or copied out of the document when
+// strings are being copied
+func (r *Reader) str(b []byte) string {
+	switch {
+	case r.arena != nil:
+		return r.arena.copyString(b)
+	case r.copies == copyAll:
+		return string(b)
+	}
+	return bytesToString(b)
+}
@@ -0,0 +1,64 @@
+package glint
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Arrays are written exactly as slices of the same element type, so a [4]float32 field and a []float32 field
+// can read each other's documents. Arrays can't be nil, so unlike slice fields they never carry a presence byte.
+//
+// Arrays are encoded and decoded by the slice encoders and decoders, through a slice header over the array's own
+// memory, so nothing is allocated or copied along the way. Arrays of byte-sized elements such as [32]byte or
+// [64]int8 are copied to and from the document in bulk. Wider numbers are written as varints, so are read and
+// written one element at a time.
+//
+// A document whose array is a different length to the field it's decoded into isn't an error. Extra elements are
+// read and dropped, and elements the document didn't have are left as zero values, so the length of an array can
+// change between versions of a type as slices can.
+
+// arrayAppend writes an array of n elements with the slice encoder for its element type
+func arrayAppend(n int, s *SliceEncoder) func(unsafe.Pointer, *Buffer) {
+	return func(p unsafe.Pointer, b *Buffer) {
+		sl := sliceHeader{Data: p, Len: n, Cap: n}
+		s.instruction(unsafe.Pointer(&sl), b)
+	}
+}
+
+// arrayRead reads into an array of type t with f, a decoder for slices of the array's element type. f decodes into
+// a slice over the array, and only when the document's slice doesn't fit is it copied across.
+func arrayRead(t reflect.Type, f func(unsafe.Pointer, Reader) Reader) func(unsafe.Pointer, Reader) Reader {
+	n := t.Len()
+	sliceType := reflect.SliceOf(t.Elem())
+	zero := reflect.New(t).Elem()
+
+	return func(p unsafe.Pointer, r Reader) Reader {
+		sl := sliceHeader{Data: p, Cap: n}
+		r = f(unsafe.Pointer(&sl), r)
+		if sl.Data == p && sl.Len == n {
+			return r
+		}
+
+		array := reflect.NewAt(t, p).Elem()
+		copied := sl.Len
+		if sl.Data != p { // the decoder needed more room than the array has, or read an empty slice
+			copied = reflect.Copy(array.Slice(0, n), reflect.NewAt(sliceType, unsafe.Pointer(&sl)).Elem())
+		}
+		reflect.Copy(array.Slice(copied, n), zero.Slice(copied, n))
+		return r
+	}
+}
+
+// nilArrayDeref reads the presence byte of a slice written by nilSliceAppend into an array, zeroing the array when
+// the slice was nil
+func nilArrayDeref(t reflect.Type, f func(unsafe.Pointer, Reader) Reader) func(unsafe.Pointer, Reader) Reader {
+	zero := reflect.New(t).Elem()
+
+	return func(p unsafe.Pointer, r Reader) Reader {
+		if r.ReadByte() == 0 {
+			reflect.NewAt(t, p).Elem().Set(zero)
+			return r
+		}
+		return f(p, r)
+	}
+}
@@ -0,0 +1,181 @@
+package glint
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Arbitrary precision numbers have wire types of their own, so amounts such as prices can be written exactly and
+// still be read as numbers by tools and other languages
+//
+//	WireBigInt   big.Int  [length (varint)][sign (byte)][magnitude (big endian)]
+//	WireDecimal  Decimal  [length (varint)][exponent (zigzag varint)][sign (byte)][magnitude (big endian)]
+//
+// Both have the same layout as WireBytes. The sign is 0 for positive numbers and 1 for negative ones, and zero is
+// written without a sign or magnitude, so a zero big.Int has a length of 0.
+//
+// As with Marshaler, these apply to struct fields and pointers to them, not to elements of slices or maps.
+
+// Decimal is an exact decimal number, the value of Coefficient × 10^Exponent. A nil Coefficient is zero.
+//
+//	price := glint.NewDecimal(1999, -2) // 19.99
+type Decimal struct {
+	Coefficient *big.Int
+	Exponent    int32
+}
+
+// NewDecimal returns the decimal coefficient × 10^exponent
+func NewDecimal(coefficient int64, exponent int32) Decimal {
+	return Decimal{Coefficient: big.NewInt(coefficient), Exponent: exponent}
+}
+
+// ParseDecimal parses a decimal number such as "-12.50" or "1.5e-3", keeping every digit given, so "12.50" has a
+// coefficient of 1250 and an exponent of -2
+func ParseDecimal(s string) (Decimal, error) {
+	mantissa, exp, hasExp := strings.Cut(strings.ToLower(s), "e")
+
+	exponent := int64(0)
+	if hasExp {
+		e, err := strconv.ParseInt(exp, 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal %q: %w", s, err)
+		}
+		exponent = e
+	}
+
+	whole, fraction, _ := strings.Cut(mantissa, ".")
+	exponent -= int64(len(fraction))
+
+	c, ok := new(big.Int).SetString(whole+fraction, 10)
+	if !ok || strings.ContainsAny(fraction, "+-") || exponent < math.MinInt32 || exponent > math.MaxInt32 {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{Coefficient: c, Exponent: int32(exponent)}, nil
+}
+
+// String formats the decimal without an exponent, e.g. "-123.45"
+func (d Decimal) String() string {
+	if d.Coefficient == nil {
+		return "0"
+	}
+
+	digits := new(big.Int).Abs(d.Coefficient).String()
+	sign := ""
+	if d.Coefficient.Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.Exponent >= 0 {
+		return sign + digits + strings.Repeat("0", int(d.Exponent))
+	}
+
+	scale := -int(d.Exponent)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// Cmp compares the values of two decimals, returning -1, 0 or +1, so 1.50 and 1.5 compare equal
+func (d Decimal) Cmp(other Decimal) int {
+	a, b := d.scaled(other.Exponent), other.scaled(d.Exponent)
+	return a.Cmp(b)
+}
+
+// scaled returns the coefficient of d written to the smaller of its exponent and the one supplied
+func (d Decimal) scaled(exponent int32) *big.Int {
+	c := new(big.Int)
+	if d.Coefficient != nil {
+		c.Set(d.Coefficient)
+	}
+	if d.Exponent > exponent {
+		c.Mul(c, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Exponent)-int64(exponent)), nil))
+	}
+	return c
+}
+
+var (
+	bigIntType  = reflect.TypeOf(big.Int{})
+	decimalType = reflect.TypeOf(Decimal{})
+)
+
+// bigNumberEncoder returns the wire type and encoding function for a field of type t, when t is a big.Int or a
+// Decimal
+func bigNumberEncoder(t reflect.Type) (WireType, func(unsafe.Pointer, *Buffer), bool) {
+	switch t {
+	case bigIntType:
+		return WireBigInt, func(p unsafe.Pointer, b *Buffer) {
+			b.AppendBigInt((*big.Int)(p))
+		}, true
+	case decimalType:
+		return WireDecimal, func(p unsafe.Pointer, b *Buffer) {
+			b.AppendDecimal(*(*Decimal)(p))
+		}, true
+	}
+	return 0, nil, false
+}
+
+// bigNumberDecoder returns the wire type and decoding function for a field of type t, when t is a big.Int or a
+// Decimal
+func bigNumberDecoder(t reflect.Type) (WireType, func(unsafe.Pointer, Reader) Reader, bool) {
+	switch t {
+	case bigIntType:
+		return WireBigInt, func(p unsafe.Pointer, r Reader) Reader {
+			setBigInt((*big.Int)(p), r.Read(r.ReadVarint()))
+			return r
+		}, true
+	case decimalType:
+		return WireDecimal, func(p unsafe.Pointer, r Reader) Reader {
+			*(*Decimal)(p) = r.ReadDecimal()
+			return r
+		}, true
+	}
+	return 0, nil, false
+}
+
+// bigIntSize returns the number of bytes x is written in, without its length
+func bigIntSize(x *big.Int) int {
+	if x == nil || x.Sign() == 0 {
+		return 0
+	}
+	return 1 + (x.BitLen()+7)/8
+}
+
+// appendBigInt writes the sign and magnitude of x, or nothing when it's zero
+func appendBigInt(dst []byte, x *big.Int) []byte {
+	n := bigIntSize(x)
+	if n == 0 {
+		return dst
+	}
+
+	sign := byte(0)
+	if x.Sign() < 0 {
+		sign = 1
+	}
+	dst = append(dst, sign)
+
+	start := len(dst)
+	for i := 1; i < n; i++ {
+		dst = append(dst, 0)
+	}
+	x.FillBytes(dst[start:])
+	return dst
+}
+
+// setBigInt sets x to the number written as b by appendBigInt
+func setBigInt(x *big.Int, b []byte) *big.Int {
+	if len(b) == 0 {
+		return x.SetInt64(0)
+	}
+
+	x.SetBytes(b[1:])
+	if b[0] == 1 {
+		x.Neg(x)
+	}
+	return x
+}
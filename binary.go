@@ -0,0 +1,51 @@
+package glint
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Binary wraps a value of type T so that it implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler,
+// written as a glint document. Systems that only speak the standard binary interfaces, such as encoding/gob, caches
+// and some database drivers, can then hold glint documents without knowing about glint:
+//
+//	err := enc.Encode(glint.Binary[User]{Value: user}) // a gob.Encoder
+//
+// The encoder and decoder for each T are built on first use and kept for later calls, as with Convert.
+type Binary[T any] struct {
+	Value T `glint:"value"`
+}
+
+// binaryCodec holds the encoder and decoder shared by every Binary[T] of the same T
+type binaryCodec[T any] struct {
+	enc *Encoder[T]
+	dec *Decoder[T]
+}
+
+// binaryCodecs holds the codec built for each type wrapped by Binary
+var binaryCodecs sync.Map // reflect.Type -> *binaryCodec[T]
+
+// codec returns the encoder and decoder for T, building them on first use
+func (Binary[T]) codec() *binaryCodec[T] {
+	key := reflect.TypeOf((*T)(nil)).Elem()
+	c, ok := binaryCodecs.Load(key)
+	if !ok {
+		c, _ = binaryCodecs.LoadOrStore(key, &binaryCodec[T]{enc: NewEncoder[T](), dec: NewDecoder[T]()})
+	}
+	return c.(*binaryCodec[T])
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, writing the value as a glint document
+func (v Binary[T]) MarshalBinary() ([]byte, error) {
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+
+	v.codec().enc.Marshal(&v.Value, b)
+	return append([]byte(nil), b.Bytes...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading the value from a glint document. data is copied
+// before it's decoded, as the interface requires, so strings and byte slices in the value don't share its memory.
+func (v *Binary[T]) UnmarshalBinary(data []byte) error {
+	return v.codec().dec.Unmarshal(append([]byte(nil), data...), &v.Value)
+}
@@ -0,0 +1,110 @@
+package glint
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Slices of bools tagged `bitmap` set WireBitmapFlag in their schema, and their bodies are written as
+//
+//	[length << 1 | packed]
+//
+// followed by either a byte per element when packed is 0, or the elements packed eight to a byte when it's 1, the
+// first element in the lowest bit of the first byte. Unused bits in the last byte are zero.
+//
+// The tag packs every value. Encoders built WithBoolBitmaps write every []bool field this way, packing only the
+// values with at least a threshold of elements, so that short slices are still copied in bulk. Decoders read either
+// layout, and don't need the tag themselves.
+
+// WithBoolBitmaps writes every []bool and [N]bool field as a bitmap, packing the values that hold at least
+// threshold elements and writing shorter ones a byte per element. Fields tagged `bitmap` are always packed.
+func WithBoolBitmaps(threshold int) EncoderOption {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return func(c *encoderConfig) {
+		c.boolBitmaps = threshold
+	}
+}
+
+// bitmapSlice switches a slice encoder over to bitmap encoding, packing values of at least threshold elements.
+// Only slices of bools held directly by a struct field can be bitmaps, as with sparse slices.
+func bitmapSlice(s *SliceEncoder, t reflect.Type, threshold int) {
+	if t.Elem().Kind() != reflect.Bool {
+		panic("bitmap option requires a slice or array of bools")
+	}
+
+	s.wire |= WireBitmapFlag
+	s.instruction = bitmapSliceInstruction(threshold)
+}
+
+// bitmapSliceInstruction writes a slice of bools packed when it holds at least threshold elements, a byte per
+// element otherwise
+func bitmapSliceInstruction(threshold int) func(unsafe.Pointer, *Buffer) {
+	return func(p unsafe.Pointer, b *Buffer) {
+		sl := *(*sliceHeader)(p)
+		if sl.Len < threshold {
+			b.AppendUint(uint(sl.Len) << 1)
+			b.Bytes = append(b.Bytes, unsafe.Slice((*byte)(sl.Data), sl.Len)...) // bools are always 0 or 1 in memory
+			return
+		}
+
+		b.AppendUint(uint(sl.Len)<<1 | 1)
+		for i, v := range unsafe.Slice((*byte)(sl.Data), sl.Len) {
+			if i%8 == 0 {
+				b.Bytes = append(b.Bytes, 0)
+			}
+			b.Bytes[len(b.Bytes)-1] |= v << (i % 8)
+		}
+	}
+}
+
+// bitmapSliceReader reads either layout of a bitmap slice, see bitmapSliceInstruction
+func bitmapSliceReader(limits DecodeLimits) func(unsafe.Pointer, Reader) Reader {
+	return func(p unsafe.Pointer, r Reader) Reader {
+		header := r.ReadVarint()
+		length, packed := header>>1, header&1 == 1
+		checkLimit(length, limits.MaxSliceElements, "slice")
+
+		size := bitmapSize(header)
+		if size > r.BytesLeft() {
+			panic(fmt.Errorf("%w: bitmap length %d exceeds remaining bytes %d", ErrTruncatedDocument, length, r.BytesLeft()))
+		}
+
+		s := *(*[]bool)(p)
+		if uint(cap(s)) < length {
+			s = make([]bool, length)
+		} else if length == 0 {
+			s = make([]bool, 0, 1) // empty slices decode as non-nil, as they do for other slices
+		} else {
+			s = s[:length]
+		}
+
+		data := r.Read(size)
+		for i := range s {
+			if packed {
+				s[i] = data[i/8]&(1<<(i%8)) != 0
+			} else {
+				s[i] = data[i] != 0
+			}
+		}
+
+		*(*[]bool)(p) = s
+		return r
+	}
+}
+
+// skipBitmapSlice reads past a bitmap slice without decoding it
+func skipBitmapSlice(p unsafe.Pointer, r Reader) Reader {
+	r.Read(bitmapSize(r.ReadVarint()))
+	return r
+}
+
+// bitmapSize returns the number of bytes following the header of a bitmap slice
+func bitmapSize(header uint) uint {
+	if header&1 == 1 {
+		return (header>>1 + 7) / 8
+	}
+	return header >> 1
+}
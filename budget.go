@@ -0,0 +1,162 @@
+package glint
+
+import (
+	"context"
+	"fmt"
+)
+
+// budgetCheckInterval is how many values are counted between checks of a decode's context for cancellation
+const budgetCheckInterval = 4096
+
+// decodeBudget counts a document's values against the totals of a decoder's limits before any of it is decoded,
+// checking the decode's context for cancellation as it goes. See DecodeLimits.MaxTotalFields.
+type decodeBudget struct {
+	limits DecodeLimits
+	ctx    context.Context
+	fields uint // struct fields counted so far, nested ones included
+	size   uint // estimated bytes the slices, maps, strings and byte slices counted so far decode to
+	steps  uint // values counted so far
+}
+
+// cancelled carries a context's error out of a count, so that it's returned as-is rather than as a malformed document
+type cancelled struct {
+	err error
+}
+
+// budgeted reports whether documents have to be counted before they're decoded
+func (l *DecodeLimits) budgeted() bool {
+	return l.MaxTotalFields > 0 || l.MaxDecodedSize > 0
+}
+
+// countDocument counts the body of a document against the totals of limits, reading it with its expanded schema
+func countDocument(ctx context.Context, schema, body Reader, limits DecodeLimits) (err error) {
+	if ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	defer recoverErr(&err)
+
+	b := decodeBudget{limits: limits, ctx: ctx}
+	fields := parseSchemaNodes(schema)
+	b.addFields(uint(len(fields)))
+	for i := range fields {
+		b.value(fields[i].wire, &fields[i], &body)
+	}
+	return nil
+}
+
+// value counts the value of t at r, reading past it
+func (b *decodeBudget) value(wire WireType, t *schemaNode, r *Reader) {
+	if b.steps++; b.ctx != nil && b.steps%budgetCheckInterval == 0 {
+		if err := b.ctx.Err(); err != nil {
+			panic(cancelled{err})
+		}
+	}
+
+	switch {
+	case wire&WirePtrFlag > 0:
+		if r.ReadByte() != 0 {
+			b.value(wire&^WirePtrFlag, t, r)
+		}
+
+	case wire&WireSliceFlag > 0:
+		peek := *r
+		l := peek.ReadVarint()
+		if wire&WireBitmapFlag > 0 {
+			l >>= 1 // bitmaps lead with a header, the length shifted past the layout bit
+		}
+		b.grow(l, valueSize(t.elem))
+
+		if wire&(WireSparseFlag|WireBitmapFlag|WireDictFlag|WireDeltaFlag) > 0 || !holdsCounted(t.elem) {
+			skipValue(wire, t, r)
+			return
+		}
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			b.value(t.elem.wire, t.elem, r)
+		}
+
+	case wire == WireStruct:
+		b.addFields(uint(len(t.fields)))
+		for i := range t.fields {
+			b.value(t.fields[i].wire, &t.fields[i], r)
+		}
+
+	case wire == WireMap:
+		l := r.ReadVarint()
+		b.grow(l, valueSize(t.key)+valueSize(t.value))
+		for i := uint(0); i < l; i++ {
+			b.value(t.key.wire, t.key, r)
+			b.value(t.value.wire, t.value, r)
+		}
+
+	case wire == WireString, wire == WireBytes:
+		peek := *r
+		b.grow(peek.ReadVarint(), 1)
+		skipValue(wire, t, r)
+
+	default:
+		skipValue(wire, t, r)
+	}
+}
+
+// addFields counts n more struct fields against MaxTotalFields
+func (b *decodeBudget) addFields(n uint) {
+	b.fields += n
+	if b.limits.MaxTotalFields > 0 && b.fields > b.limits.MaxTotalFields {
+		panic(fmt.Errorf("%w: total fields exceed limit %d", ErrLimitExceeded, b.limits.MaxTotalFields))
+	}
+}
+
+// grow counts n more values of each bytes against MaxDecodedSize
+func (b *decodeBudget) grow(n, each uint) {
+	max := b.limits.MaxDecodedSize
+	if max == 0 {
+		return
+	}
+	if each > 0 && (n > max/each || b.size+n*each > max) { // the first check keeps the product from overflowing
+		panic(fmt.Errorf("%w: decoded size exceeds limit %d", ErrLimitExceeded, max))
+	}
+	b.size += n * each
+}
+
+// holdsCounted reports whether values of t hold anything counted beyond their own size, so need reading one by one
+func holdsCounted(t *schemaNode) bool {
+	wire := t.wire &^ WirePtrFlag
+	return wire&WireSliceFlag > 0 || wire == WireStruct || wire == WireMap || wire == WireString || wire == WireBytes
+}
+
+// valueSize estimates the bytes a value of t takes in memory, not counting anything it refers to
+func valueSize(t *schemaNode) uint {
+	wire := t.wire &^ (WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case wire&WireSliceFlag > 0, wire&^WirePtrFlag == WireBytes:
+		return 24 // slice header, nil slices carry a presence byte rather than a pointer
+	case wire&WirePtrFlag > 0, wire == WireMap:
+		return 8
+	}
+
+	switch wire {
+	case WireBool, WireInt8, WireUint8:
+		return 1
+	case WireInt16, WireUint16:
+		return 2
+	case WireInt32, WireUint32, WireFloat32:
+		return 4
+	case WireString, WireDynamic:
+		return 16
+	case WireTime, WireIP:
+		return 24
+	case WireIPPrefix, WireDecimal:
+		return 32
+	case WireStruct:
+		var n uint
+		for i := range t.fields {
+			n += valueSize(&t.fields[i])
+		}
+		return n
+	}
+	return 8
+}
@@ -2,11 +2,15 @@ package glint
 
 import (
 	"encoding/binary"
+	"math"
+	"math/big"
+	"math/bits"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
 // Buffer accumulates encoded data during serialization. Supports only append operations
@@ -14,30 +18,91 @@ import (
 type Buffer struct {
 	Bytes         []byte
 	TrustedSchema bool // when true, omits schema body for trusted connections
+
+	strings stringTable    // strings written to the current document's string table, see dict.go
+	chunks  *ChunkedBuffer // when set, filled chunks are moved here rather than grown, see chunked.go
 }
 
 // Reset clears the buffer contents but preserves allocated memory
 func (b *Buffer) Reset() {
 	b.Bytes = b.Bytes[:0]
 	b.TrustedSchema = false
+	b.resetStrings()
+}
+
+// bufferClasses are the capacities pooled buffers are sorted by, each pool holding buffers of up to its capacity,
+// so that a buffer grown by a large document isn't handed to every small one after it. Buffers grown beyond the
+// largest class are left to the garbage collector rather than pooled.
+var bufferClasses = [...]int{4 << 10, 64 << 10, 1 << 20}
+
+// bufferPool is the pool of one class of buffers, along with counts of its use
+type bufferPool struct {
+	pool     sync.Pool
+	reused   atomic.Uint64
+	returned atomic.Uint64
+}
+
+var (
+	bufpools       [len(bufferClasses)]bufferPool
+	buffersMade    atomic.Uint64 // buffers made because no pool had one to hand out
+	buffersDropped atomic.Uint64 // buffers returned too large for any pool
+)
+
+// BufferPoolClass counts the use of one class of pooled buffers, see BufferPoolStats
+type BufferPoolClass struct {
+	Capacity int    // the capacity of the largest buffer in the class
+	Reused   uint64 // buffers handed out from the class
+	Returned uint64 // buffers returned to the class
+}
+
+// BufferPoolStats counts the use of the pools NewBufferFromPool hands buffers out from, since the process started
+type BufferPoolStats struct {
+	Classes []BufferPoolClass // from smallest to largest
+	Made    uint64            // buffers made because no class had one to hand out
+	Dropped uint64            // buffers returned with more capacity than the largest class holds
 }
 
-var bufpool = sync.Pool{
-	New: func() any { return &Buffer{} },
+// ReadBufferPoolStats returns the counts of the buffer pools so far. It's safe to call while buffers are in use.
+func ReadBufferPoolStats() BufferPoolStats {
+	s := BufferPoolStats{Made: buffersMade.Load(), Dropped: buffersDropped.Load()}
+	for i := range bufpools {
+		s.Classes = append(s.Classes, BufferPoolClass{
+			Capacity: bufferClasses[i],
+			Reused:   bufpools[i].reused.Load(),
+			Returned: bufpools[i].returned.Load(),
+		})
+	}
+	return s
+}
+
+// getBuffer takes a buffer from the smallest class holding buffers of at least size, trying larger classes when
+// it's empty, and reports whether it was reused rather than made. Buffers are handed out as they were returned.
+func getBuffer(size int) (*Buffer, bool) {
+	for i := range bufpools {
+		if bufferClasses[i] < size {
+			continue
+		}
+		if b, _ := bufpools[i].pool.Get().(*Buffer); b != nil {
+			bufpools[i].reused.Add(1)
+			return b, true
+		}
+	}
+	buffersMade.Add(1)
+	return &Buffer{}, false
 }
 
 // NewBufferFromPool obtains a reset Buffer from the pool. Call ReturnToPool when finished.
 // For existing memory, create directly: `buf := Buffer{mySlice[:0]}` - pooling is optional.
 func NewBufferFromPool() *Buffer {
-	b := bufpool.Get().(*Buffer)
+	b, _ := getBuffer(0)
 	b.Reset()
 	return b
 }
 
-// NewBufferFromPoolWithCap acquires a pooled Buffer with guaranteed capacity.
-// Call ReturnToPool after use.
+// NewBufferFromPoolWithCap acquires a pooled Buffer with guaranteed capacity, taken from the class of buffers
+// that size falls in. Call ReturnToPool after use.
 func NewBufferFromPoolWithCap(size int) *Buffer {
-	b := bufpool.Get().(*Buffer)
+	b, _ := getBuffer(size)
 
 	if c := cap(b.Bytes); c < size {
 		b.Bytes = make([]byte, 0, size)
@@ -48,6 +113,17 @@ func NewBufferFromPoolWithCap(size int) *Buffer {
 	return b
 }
 
+// Grow makes room for at least n more bytes, so that writing a document of a known size doesn't grow the buffer
+// a step at a time. Buffers writing for a ChunkedBuffer start new chunks instead, and aren't grown.
+func (b *Buffer) Grow(n int) {
+	if b.chunks != nil || cap(b.Bytes)-len(b.Bytes) >= n {
+		return
+	}
+	grown := make([]byte, len(b.Bytes), 2*cap(b.Bytes)+n)
+	copy(grown, b.Bytes)
+	b.Bytes = grown
+}
+
 // Trustee interface enables schema trust verification. HTTPTrustee provides a default HTTP-based implementation.
 type Trustee interface {
 	Hash() uint32
@@ -77,7 +153,7 @@ func (h httpTrustee) Hash() uint32 {
 // NewBufferWithTrust acquires a pooled Buffer and enables trust mode if schema hashes match.
 // Remember to call ReturnToPool after use.
 func NewBufferWithTrust(r Trustee, e *encoderImpl) *Buffer {
-	b := bufpool.Get().(*Buffer)
+	b, _ := getBuffer(0)
 	b.Reset()
 
 	if binary.LittleEndian.Uint32(e.header.Bytes[1:5]) == r.Hash() {
@@ -87,10 +163,17 @@ func NewBufferWithTrust(r Trustee, e *encoderImpl) *Buffer {
 	return b
 }
 
-// ReturnToPool releases the buffer back to the pool. Using the buffer after this call
-// results in undefined behavior.
+// ReturnToPool releases the buffer back to the pool for its capacity, or to the garbage collector when it's grown
+// beyond the largest. Using the buffer after this call results in undefined behavior.
 func (b *Buffer) ReturnToPool() {
-	bufpool.Put(b)
+	for i := range bufpools {
+		if cap(b.Bytes) <= bufferClasses[i] {
+			bufpools[i].returned.Add(1)
+			bufpools[i].pool.Put(b)
+			return
+		}
+	}
+	buffersDropped.Add(1)
 }
 
 // AppendString encodes a string with length prefix into the buffer.
@@ -169,6 +252,11 @@ func (b *Buffer) AppendInt64(value int64) {
 	appendVarint(b, uint64(value))
 }
 
+// AppendZigzagInt64 encodes an int64 using zigzag encoding, see WireZigzagFlag.
+func (b *Buffer) AppendZigzagInt64(value int64) {
+	appendVarintZigzag(b, value)
+}
+
 // AppendInt encodes a signed int using zigzag encoding.
 func (b *Buffer) AppendInt(value int) {
 	appendVarintZigzag(b, int64(value))
@@ -177,12 +265,33 @@ func (b *Buffer) AppendInt(value int) {
 
 // AppendFloat32 encodes a float32 by converting to uint32 bits
 func (b *Buffer) AppendFloat32(value float32) {
-	appendVarint(b, uint64(*(*uint32)(unsafe.Pointer(&value))))
+	appendVarint(b, uint64(math.Float32bits(value)))
 }
 
 // AppendFloat64 encodes a float64 by converting to uint64 bits
 func (b *Buffer) AppendFloat64(value float64) {
-	appendVarint(b, *(*uint64)(unsafe.Pointer(&value)))
+	appendVarint(b, math.Float64bits(value))
+}
+
+// appendFloatXor writes the bits that differ between a float and the one before it in a delta encoded slice. No
+// difference is a single 0 byte, otherwise the differing bits are shifted down past their trailing zeros and written
+// above a 6 bit count of those zeros. That can take 70 bits for a float64, so it's written as a varint of its own
+// rather than with appendVarint, though one that SkipVarint steps over in the same way.
+func appendFloatXor(b *Buffer, x uint64) {
+	if x == 0 {
+		b.Bytes = append(b.Bytes, 0)
+		return
+	}
+
+	tz := uint64(bits.TrailingZeros64(x))
+	x >>= tz
+	lo, hi := x<<6|tz, x>>58
+	for hi > 0 || lo >= 0b10000000 {
+		b.Bytes = append(b.Bytes, byte((lo&0b01111111)|0b10000000))
+		lo = lo>>7 | hi<<57
+		hi >>= 7
+	}
+	b.Bytes = append(b.Bytes, byte(lo))
 }
 
 // AppendTime encodes a time value using Go's binary marshaling
@@ -195,6 +304,59 @@ func (b *Buffer) AppendTime(t time.Time) {
 	b.AppendBytes(buf)
 }
 
+// AppendDuration encodes a time.Duration as a variable-length int64
+func (b *Buffer) AppendDuration(d time.Duration) {
+	b.AppendInt64(int64(d))
+}
+
+// AppendAddr encodes an IP address as its 4 or 16 bytes, length-prefixed. The zero Addr is written with no bytes.
+func (b *Buffer) AppendAddr(addr netip.Addr) {
+	if !addr.IsValid() {
+		b.AppendBytes(nil)
+		return
+	}
+	appendVarint(b, uint64(addr.BitLen()/8))
+	b.Bytes = appendAddrBytes(b.Bytes, addr)
+}
+
+// AppendPrefix encodes an IP prefix as its address bytes followed by its length in bits, length-prefixed. The
+// zero Prefix is written with no bytes.
+func (b *Buffer) AppendPrefix(p netip.Prefix) {
+	if !p.IsValid() {
+		b.AppendBytes(nil)
+		return
+	}
+	appendVarint(b, uint64(p.Addr().BitLen()/8+1))
+	b.Bytes = append(appendAddrBytes(b.Bytes, p.Addr()), byte(p.Bits()))
+}
+
+// AppendBigInt encodes an arbitrary precision integer as its sign and magnitude, length-prefixed. Zero and nil are
+// written with no bytes.
+func (b *Buffer) AppendBigInt(x *big.Int) {
+	appendVarint(b, uint64(bigIntSize(x)))
+	b.Bytes = appendBigInt(b.Bytes, x)
+}
+
+// AppendDecimal encodes a decimal as its exponent followed by the sign and magnitude of its coefficient,
+// length-prefixed
+func (b *Buffer) AppendDecimal(d Decimal) {
+	var exp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(exp[:], int64(d.Exponent)) // zigzag, as with AppendInt32
+
+	appendVarint(b, uint64(n+bigIntSize(d.Coefficient)))
+	b.Bytes = appendBigInt(append(b.Bytes, exp[:n]...), d.Coefficient)
+}
+
+// appendAddrBytes appends the 4 bytes of an IPv4 address or the 16 bytes of an IPv6 one, without allocating
+func appendAddrBytes(dst []byte, addr netip.Addr) []byte {
+	if addr.Is4() {
+		a := addr.As4()
+		return append(dst, a[:]...)
+	}
+	a := addr.As16()
+	return append(dst, a[:]...)
+}
+
 // AppendBool encodes a boolean as a single byte: 1 for true, 0 for false.
 func (b *Buffer) AppendBool(value bool) {
 	if value {
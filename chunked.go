@@ -0,0 +1,168 @@
+package glint
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"unsafe"
+)
+
+// defaultChunkSize is the size of the chunks of a ChunkedBuffer created with a size of 0
+const defaultChunkSize = 64 << 10
+
+// ChunkedBuffer holds documents as a list of chunks rather than in a single contiguous slice. A Buffer grows by
+// copying everything written so far into a slice twice the size, which for documents of many megabytes adds up to
+// copying the whole document again, and holding onto memory twice its size while it happens. A ChunkedBuffer
+// starts a new chunk once the current one fills instead, leaving the bytes already written where they are.
+//
+// Chunks are only started between structs, as the encoder finishes writing each one, so they can run past the
+// chunk size by as much as a single struct writes - a large slice of numbers, say. A chunk that does is followed
+// by chunks at least as large, so the buffer adapts to the values it holds.
+//
+// Documents are read out with WriteTo, which hands every chunk to the writer in a single vectored write where the
+// writer supports it, or with Bytes where a contiguous copy is needed. Compressed documents are written into a
+// single chunk, as the body is compressed as a whole.
+type ChunkedBuffer struct {
+	tail   Buffer   // the chunk being written
+	chunks [][]byte // the chunks written before it, in order
+	free   [][]byte // chunks kept by Reset for reuse
+	size   int      // the size new chunks are started with
+
+	w       io.Writer // when set, filled chunks are written here rather than kept, see SpillBuffer
+	written int64     // bytes written to w
+	err     error     // the first error writing to w
+}
+
+// NewChunkedBuffer creates a ChunkedBuffer that starts a new chunk once the current one reaches chunkSize bytes. A
+// chunkSize of 0 or less uses a default of 64KiB.
+func NewChunkedBuffer(chunkSize int) *ChunkedBuffer {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	return &ChunkedBuffer{size: chunkSize}
+}
+
+// MarshalChunked encodes a value of type T into the supplied chunked buffer, after any documents already in it
+func (e *Encoder[T]) MarshalChunked(v *T, c *ChunkedBuffer) {
+	e.impl.marshalChunked(unsafe.Pointer(v), c)
+}
+
+// marshalChunked writes a whole document for the struct at p into c, as marshalDocument does for a Buffer
+func (e *encoderImpl) marshalChunked(p unsafe.Pointer, c *ChunkedBuffer) {
+	if c.size <= 0 {
+		c.size = defaultChunkSize // the zero value is ready to use
+	}
+	b := &c.tail
+	if b.Bytes == nil {
+		b.Bytes = make([]byte, 0, c.size)
+	}
+	b.resetStrings()
+
+	chunk, start := len(c.chunks), len(b.Bytes)
+	e.appendHeader(b)
+
+	// the body is compressed in place, so it needs to stay in one piece, and canonical documents can't carry the
+	// string table streamed documents always have
+	streamed := false
+	if e.config.compressor == nil && (c.w == nil || !e.config.canonical) {
+		b.chunks = c
+
+		// a header written out before the body is finished can't be told afterwards that a string table follows,
+		// so streamed documents have one whether they need it or not
+		if streamed = c.w != nil; streamed {
+			b.Bytes[start] |= flagStringTable
+		}
+	}
+	body := len(b.Bytes)
+	e.marshalBody(p, b)
+	b.chunks = nil
+
+	if streamed {
+		if !b.appendStringTable() {
+			b.AppendUint(0)
+			b.Bytes = binary.LittleEndian.AppendUint32(b.Bytes, 1) // the table is just its count
+		}
+		return
+	}
+
+	flags := e.finishBody(b, body)
+	if flags == 0 {
+		return
+	}
+	if chunk < len(c.chunks) {
+		c.chunks[chunk][start] |= flags // the header was written to a chunk that has since filled
+	} else {
+		b.Bytes[start] |= flags
+	}
+}
+
+// spill moves the current chunk of b onto the chunked buffer it's writing for, once it has filled, and starts b on
+// a new one. Nothing may hold an offset into b.Bytes across a call to spill.
+func (b *Buffer) spill() {
+	c := b.chunks
+	if len(b.Bytes) < c.size {
+		return
+	}
+	if c.w != nil {
+		c.flush()
+		return
+	}
+
+	c.chunks = append(c.chunks, b.Bytes)
+	size := c.size
+	if len(b.Bytes) > size { // values too large for a chunk are likely to be followed by more of the same
+		size = len(b.Bytes)
+	}
+
+	for i := len(c.free) - 1; i >= 0; i-- {
+		if cap(c.free[i]) >= size {
+			b.Bytes = c.free[i][:0]
+			c.free = append(c.free[:i], c.free[i+1:]...)
+			return
+		}
+	}
+	b.Bytes = make([]byte, 0, size)
+}
+
+// Len returns the number of bytes held across every chunk
+func (c *ChunkedBuffer) Len() int {
+	n := len(c.tail.Bytes)
+	for _, chunk := range c.chunks {
+		n += len(chunk)
+	}
+	return n
+}
+
+// Chunks returns the chunks holding the buffer's bytes, in order. They remain valid until the buffer is reset.
+func (c *ChunkedBuffer) Chunks() [][]byte {
+	chunks := make([][]byte, 0, len(c.chunks)+1)
+	chunks = append(chunks, c.chunks...)
+	if len(c.tail.Bytes) > 0 {
+		chunks = append(chunks, c.tail.Bytes)
+	}
+	return chunks
+}
+
+// Bytes returns a copy of the buffer's bytes in a single slice
+func (c *ChunkedBuffer) Bytes() []byte {
+	b := make([]byte, 0, c.Len())
+	for _, chunk := range c.Chunks() {
+		b = append(b, chunk...)
+	}
+	return b
+}
+
+// WriteTo writes every chunk to w, as a single vectored write where w supports one, such as a net.Conn
+func (c *ChunkedBuffer) WriteTo(w io.Writer) (int64, error) {
+	segments := net.Buffers(c.Chunks())
+	return segments.WriteTo(w)
+}
+
+// Reset empties the buffer, keeping its chunks to write into again
+func (c *ChunkedBuffer) Reset() {
+	for _, chunk := range c.chunks {
+		c.free = append(c.free, chunk[:0])
+	}
+	c.chunks = c.chunks[:0]
+	c.tail.Reset()
+}
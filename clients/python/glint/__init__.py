@@ -0,0 +1,35 @@
+"""A Python client for the glint binary format.
+
+    import glint
+
+    with open("export.glint", "rb") as f:
+        rows = glint.decode(f.read())
+
+See README.md for how values map to Python types.
+"""
+
+from .reader import (
+    GlintError,
+    SchemaNotFoundError,
+    decode,
+    parse_schema,
+    parse_schema_bytes,
+)
+from .writer import encode, infer_schema
+from .jsonable import jsonable
+from .schema import Field, Schema
+from . import wire
+
+__all__ = [
+    "Field",
+    "GlintError",
+    "Schema",
+    "SchemaNotFoundError",
+    "decode",
+    "encode",
+    "infer_schema",
+    "jsonable",
+    "parse_schema",
+    "parse_schema_bytes",
+    "wire",
+]
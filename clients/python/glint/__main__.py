@@ -0,0 +1,31 @@
+"""Prints glint documents as JSON: python -m glint [file...], reading stdin when no files are named.
+
+Exits with status 1 when a document can't be read, which is how testdata/conformance runs the client.
+"""
+
+import json
+import sys
+
+from . import GlintError, decode, jsonable
+
+
+def main(argv):
+    sources = argv or ["-"]
+    for source in sources:
+        if source == "-":
+            doc = sys.stdin.buffer.read()
+        else:
+            with open(source, "rb") as f:
+                doc = f.read()
+        try:
+            value = decode(doc)
+        except GlintError as e:
+            sys.stderr.write("glint: %s: %s\n" % (source, e))
+            return 1
+        json.dump(jsonable(value), sys.stdout, indent=2, ensure_ascii=False)
+        sys.stdout.write("\n")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main(sys.argv[1:]))
@@ -0,0 +1,51 @@
+"""Converting decoded documents into values the json module can write."""
+
+import base64
+import datetime
+import decimal
+import ipaddress
+
+
+def jsonable(value):
+    """Returns a decoded value with everything json can't write converted the way Go's encoding/json does.
+
+    Bytes become base64, times become RFC 3339 strings, durations become counts of nanoseconds, and addresses and
+    decimals become their strings. Map keys become strings.
+    """
+    if isinstance(value, dict):
+        return {_key(k): jsonable(v) for k, v in value.items()}
+    if isinstance(value, list):
+        return [jsonable(v) for v in value]
+    if isinstance(value, bytes):
+        return base64.b64encode(value).decode("ascii")
+    if isinstance(value, datetime.datetime):
+        return _rfc3339(value)
+    if isinstance(value, datetime.timedelta):
+        return (value // datetime.timedelta(microseconds=1)) * 1000
+    if isinstance(value, decimal.Decimal):
+        return format(value, "f")
+    if isinstance(value, (ipaddress.IPv4Address, ipaddress.IPv6Address,
+                          ipaddress.IPv4Interface, ipaddress.IPv6Interface)):
+        return str(value)
+    return value
+
+
+def _key(k):
+    if isinstance(k, bool):
+        return "true" if k else "false"
+    v = jsonable(k)
+    return v if isinstance(v, str) else str(v)
+
+
+def _rfc3339(t):
+    # as Go's time.RFC3339Nano, with trailing zeros dropped from the fraction and Z for a zero offset
+    s = "%04d-%02d-%02dT%02d:%02d:%02d" % (t.year, t.month, t.day, t.hour, t.minute, t.second)
+    if t.microsecond:
+        s += ("." + "%06d" % t.microsecond).rstrip("0")
+
+    offset = t.utcoffset() or datetime.timedelta()
+    if not offset:
+        return s + "Z"
+    minutes = int(offset.total_seconds()) // 60
+    sign = "-" if minutes < 0 else "+"
+    return s + "%s%02d:%02d" % (sign, abs(minutes) // 60, abs(minutes) % 60)
@@ -0,0 +1,482 @@
+"""Reading glint documents into plain Python values."""
+
+import datetime
+import decimal
+import ipaddress
+import struct
+import zlib
+
+from . import wire as w
+from .schema import Field, Schema
+
+# bounds on what a document can make the reader do, matching glint.DefaultLimits
+MAX_ELEMENTS = 10_000_000  # elements of a slice, or entries of a map
+MAX_DEPTH = 64  # nesting of schemas and values
+MAX_DOCUMENT_SIZE = 256 * 1024 * 1024  # bytes a compressed body may decompress to
+
+_UTC = datetime.timezone.utc
+_EPOCH = datetime.datetime(1, 1, 1, tzinfo=_UTC)  # Go's times count seconds from the start of year 1
+_EMPTY_SCHEMA_HASH = zlib.crc32(b"\x00")
+
+
+class GlintError(ValueError):
+    """Raised for documents that are malformed, or that use something this client can't read."""
+
+
+class SchemaNotFoundError(GlintError):
+    """Raised for documents written without their schema, as in trusted schema mode or with a schema registry."""
+
+
+class Reader:
+    """Reads the low level encodings from a byte string, raising GlintError when they run past its end."""
+
+    def __init__(self, data, strings=None):
+        self.data = memoryview(data)
+        self.pos = 0
+        self.strings = strings  # the document's string table, when it has one
+
+    def left(self):
+        return len(self.data) - self.pos
+
+    def read(self, n):
+        if n > self.left():
+            raise GlintError("truncated glint document: read of %d bytes with %d left" % (n, self.left()))
+        b = self.data[self.pos:self.pos + n]
+        self.pos += n
+        return bytes(b)
+
+    def byte(self):
+        if self.pos >= len(self.data):
+            raise GlintError("truncated glint document: read out of bounds")
+        b = self.data[self.pos]
+        self.pos += 1
+        return b
+
+    def varint(self):
+        value, shift = 0, 0
+        while True:
+            b = self.byte()
+            value |= (b & 0x7F) << shift
+            if b < 0x80:
+                return value & 0xFFFFFFFFFFFFFFFF
+            shift += 7
+            if shift > 63:
+                raise GlintError("varint longer than 10 bytes")
+
+    def length(self, what):
+        n = self.varint()
+        if n > MAX_ELEMENTS:
+            raise GlintError("%s length %d exceeds the limit of %d" % (what, n, MAX_ELEMENTS))
+        return n
+
+    def table_string(self):
+        i = self.varint()
+        if self.strings is None or i >= len(self.strings):
+            raise GlintError("string table entry %d out of range" % i)
+        return self.strings[i]
+
+
+def decode(doc):
+    """Reads a glint document into a dict keyed by field name.
+
+    Structs become dicts and slices become lists, and maps become dicts keyed by their keys. Integers are ints and
+    floats are floats, times are timezone aware datetimes and durations are timedeltas, both to the microsecond.
+    Byte slices are bytes, addresses are ipaddress addresses and interfaces, big integers are ints and decimals
+    are decimal.Decimals. Nil pointers, slices and addresses are None.
+    """
+    parts = _split(doc)
+    if parts.schema is None:
+        return {}
+
+    r = Reader(parts.body, parts.strings)
+    value = _read_fields(parts.schema.fields, r, 0)
+    if r.left() > 0:
+        raise GlintError("invalid glint document: body bytes remaining > 0: %d" % r.left())
+    return value
+
+
+def parse_schema(doc):
+    """Reads the schema of a glint document, with any shared struct schemas expanded and constraints kept."""
+    parts = _split(doc, body=False)
+    return parts.schema if parts.schema is not None else Schema(hash=parts.hash, fields=[])
+
+
+def parse_schema_bytes(schema):
+    """Reads a schema held apart from any document, as returned by Encoder.Schema and held by a SchemaRegistry."""
+    r = Reader(schema)
+    fields = _SchemaParser(r).fields(r.length("schema") + r.pos, 0)
+    return Schema(hash=zlib.crc32(schema), fields=fields)
+
+
+class _Parts:
+    """The sections of a document, once its layout has been resolved."""
+
+    def __init__(self, hash, schema, body=b"", strings=None):
+        self.hash = hash
+        self.schema = schema  # None for a document without any fields
+        self.body = body
+        self.strings = strings
+
+
+def _split(doc, body=True):
+    doc = bytes(doc)
+    if len(doc) < 6:
+        raise GlintError("invalid glint document: too short")
+
+    flags = doc[0]
+    version = flags & w.FLAG_VERSION_MASK
+    if version > w.VERSION_POINTERS:
+        raise GlintError("unsupported glint document version: %d" % version)
+    hash = int.from_bytes(doc[1:5], "little")
+
+    r = Reader(doc)
+    r.pos = 5
+    schema_len = r.varint()
+    if schema_len > r.left():
+        raise GlintError("invalid glint document: schema length %d exceeds document length" % schema_len)
+
+    if schema_len == 0:
+        if hash != _EMPTY_SCHEMA_HASH:  # a type without fields has a schema hash of its own
+            raise SchemaNotFoundError("glint document has no schema, see trusted schema mode")
+        return _Parts(hash, None)
+
+    schema = Schema(hash=hash, fields=_SchemaParser(r).fields(r.pos + schema_len, 0))
+    if version >= w.VERSION_NAME_HASHES:
+        r.read(r.varint())  # the hashes of the field names only speed up readers that look fields up by name
+    if not body:
+        return _Parts(hash, schema)
+
+    written = r.read(r.left())
+    codec = flags >> 4
+    if codec != w.CODEC_NONE and written:
+        written = _decompress(codec, written)
+
+    strings = None
+    if flags & w.FLAG_STRING_TABLE and written:
+        written, strings = _split_string_table(written)
+
+    return _Parts(hash, schema, written, strings)
+
+
+def _decompress(codec, body):
+    if codec != w.CODEC_DEFLATE:
+        raise GlintError("glint document compressed with codec %d, only deflate (1) is supported" % codec)
+
+    d = zlib.decompressobj(-15)  # raw RFC 1951, as compress/flate writes it
+    try:
+        out = d.decompress(body, MAX_DOCUMENT_SIZE)
+    except zlib.error as e:
+        raise GlintError("invalid glint document: decompressing body: %s" % e) from None
+    if d.unconsumed_tail:
+        raise GlintError("decompressed body exceeds the limit of %d bytes" % MAX_DOCUMENT_SIZE)
+    return out
+
+
+def _split_string_table(written):
+    if len(written) < 4:
+        raise GlintError("invalid glint document: string table size missing")
+    size = int.from_bytes(written[-4:], "little")
+    if size > len(written) - 4:
+        raise GlintError("invalid glint document: string table size %d exceeds the body" % size)
+
+    body, table = written[:len(written) - 4 - size], written[len(written) - 4 - size:-4]
+    r = Reader(table)
+    strings = [_decode_string(r.read(r.varint())) for _ in range(r.length("string table"))]
+    return body, strings
+
+
+class _SchemaParser:
+    """Parses schema fields, expanding references to struct schemas written earlier in the same schema."""
+
+    def __init__(self, r):
+        self.r = r
+        self.defs = []  # struct schemas in the order they were written in full, None while incomplete
+
+    def fields(self, end, depth):
+        r, fields, names = self.r, [], set()
+        if end > len(r.data):
+            raise GlintError("truncated glint document: schema runs past the end")
+
+        while r.pos < end:
+            wire = r.varint()
+            f = Field(name=_decode_string(r.read(r.byte())))
+            if f.name in names:
+                raise GlintError("schema names field %r twice" % f.name)
+            names.add(f.name)
+
+            if wire & w.CONSTRAINT_FLAG:
+                self.constraints(f, Reader(r.read(r.varint())))
+                wire ^= w.CONSTRAINT_FLAG
+
+            self.node(f, wire, depth + 1)
+            fields.append(f)
+
+        if r.pos != end:
+            raise GlintError("invalid glint schema: fields overrun their schema")
+        return fields
+
+    def constraints(self, f, r):
+        while r.left() > 0:
+            kind, text = r.byte(), _decode_string(r.read(r.varint()))
+            if kind == 1:
+                f.min = text
+            elif kind == 2:
+                f.max = text
+            elif kind == 3:
+                f.pattern = text
+
+    def node(self, f, wire, depth):
+        if depth > MAX_DEPTH:
+            raise GlintError("schema nesting exceeds the limit of %d" % MAX_DEPTH)
+
+        ref = wire & w.SCHEMA_REF_FLAG
+        wire &= ~w.SCHEMA_REF_FLAG
+        f.wire = wire
+        base = wire & ~w.ENCODING_FLAGS
+
+        if base == w.SLICE_FLAG:  # a slice of slices, the element's wire type follows
+            f.elem = Field()
+            self.node(f.elem, self.r.varint(), depth + 1)
+        elif base & w.SLICE_FLAG:  # the slice's wire type stands in for its element's
+            f.elem = Field()
+            self.node(f.elem, wire & (w.TYPE_MASK | w.ZIGZAG_FLAG) | ref, depth + 1)
+            f.fields = f.elem.fields
+        elif base == w.STRUCT:
+            f.fields = self.struct(ref, depth)
+        elif base == w.MAP:
+            key, value = self.r.varint(), self.r.varint()
+            f.key, f.value = Field(), Field()
+            self.node(f.key, key, depth + 1)
+            self.node(f.value, value, depth + 1)
+        elif not w.BOOL <= base <= w.DYNAMIC:
+            raise GlintError("unknown wire type %d" % wire)
+
+    def struct(self, ref, depth):
+        if ref:
+            i = self.r.varint()
+            if i >= len(self.defs) or self.defs[i] is None:  # references may only point back to complete schemas
+                raise GlintError("reference to undefined struct schema %d" % i)
+            return self.defs[i]
+
+        end = self.r.varint() + self.r.pos
+        i = len(self.defs)  # numbered before its fields, so the struct comes before those nested within it
+        self.defs.append(None)
+        self.defs[i] = self.fields(end, depth)
+        return self.defs[i]
+
+
+def _decode_string(b):
+    # Go strings are any bytes, those that aren't UTF-8 survive being written back out
+    return b.decode("utf-8", "surrogateescape")
+
+
+def _read_fields(fields, r, depth):
+    if depth > MAX_DEPTH:
+        raise GlintError("nesting exceeds the limit of %d" % MAX_DEPTH)
+    return {f.name: _read_value(f, r, depth + 1) for f in fields}
+
+
+def _read_value(f, r, depth):
+    wire = f.wire & ~w.PTR_FLAG
+    if f.wire & w.PTR_FLAG and r.byte() == 0:
+        return None
+
+    if wire & w.BITMAP_FLAG:
+        return _read_bitmap(r)
+    if wire & w.SPARSE_FLAG:
+        return _read_sparse(f.elem.base, r)
+    if wire & w.DELTA_FLAG:
+        return _read_delta(f.elem.base, r)
+    if wire & w.SLICE_FLAG:
+        n = r.length("slice")
+        if wire & w.DICT_FLAG:
+            return [r.table_string() for _ in range(n)]
+        return [_read_value(f.elem, r, depth + 1) for _ in range(n)]
+
+    base = wire & w.TYPE_MASK
+    if base == w.STRUCT:
+        return _read_fields(f.fields, r, depth)
+    if base == w.MAP:
+        m = {}
+        for _ in range(r.length("map")):
+            key = _read_value(f.key, r, depth + 1)
+            m[key] = _read_value(f.value, r, depth + 1)
+        return m
+    if wire == w.STRING | w.DICT_FLAG:
+        return r.table_string()
+    if wire in (w.INT64 | w.ZIGZAG_FLAG, w.DURATION | w.ZIGZAG_FLAG):
+        value = w.unzigzag(r.varint())
+        return _duration(value) if base == w.DURATION else value
+    if base == w.DYNAMIC:
+        return _read_dynamic(r)
+    return _read_primitive(base, r)
+
+
+def _read_dynamic(r):
+    # a value written with its own wire type, only primitives, pointers to them and slices of them
+    wire = r.varint()
+    if wire & w.PTR_FLAG:
+        wire &= ~w.PTR_FLAG
+        if r.byte() == 0:
+            return None
+
+    elem = wire & ~w.SLICE_FLAG
+    if not wire & w.SLICE_FLAG:
+        if not w.BOOL <= elem <= w.DECIMAL or elem in (w.BYTES, w.STRUCT, w.MAP):
+            raise GlintError("unsupported dynamic wire type %d" % wire)
+        return _read_primitive(elem, r)
+    if not w.BOOL <= elem <= w.DURATION or elem in (w.STRUCT, w.MAP):
+        raise GlintError("unsupported dynamic wire type %d" % wire)
+
+    n = r.length("slice")
+    if elem == w.UINT8:  # written as bytes, after the length
+        return list(r.read(r.varint()))
+    return [_read_primitive(elem, r) for _ in range(n)]
+
+
+def _read_primitive(base, r):
+    if base == w.BOOL:
+        return r.byte() == 1
+    if base in (w.INT, w.INT16, w.INT32):
+        return w.wrap(w.unzigzag(r.varint()), base)
+    if base in (w.INT8, w.UINT8):
+        return w.wrap(r.byte(), base)
+    if base in (w.INT64, w.UINT, w.UINT16, w.UINT32, w.UINT64):
+        return w.wrap(r.varint(), base)
+    if base == w.FLOAT32:
+        return struct.unpack("<f", (r.varint() & 0xFFFFFFFF).to_bytes(4, "little"))[0]
+    if base == w.FLOAT64:
+        return struct.unpack("<d", r.varint().to_bytes(8, "little"))[0]
+    if base == w.STRING:
+        return _decode_string(r.read(r.varint()))
+    if base == w.BYTES:
+        return r.read(r.varint())
+    if base == w.TIME:
+        return _time(r.read(r.varint()))
+    if base == w.DURATION:
+        return _duration(w.wrap(r.varint(), w.INT64))
+    if base == w.IP:
+        return _address(r.read(r.varint()))
+    if base == w.IP_PREFIX:
+        b = r.read(r.varint())
+        if not b:
+            return None
+        return ipaddress.ip_interface((_address(b[:-1]), b[-1]))
+    if base == w.BIG_INT:
+        return _big_int(r.read(r.varint()))
+    if base == w.DECIMAL:
+        vr = Reader(r.read(r.varint()))
+        exponent = w.wrap(w.unzigzag(vr.varint()), w.INT32)
+        coefficient = _big_int(vr.read(vr.left()))
+        digits = tuple(int(d) for d in str(abs(coefficient)))
+        return decimal.Decimal((1 if coefficient < 0 else 0, digits, exponent))
+    raise GlintError("unsupported wire type %d" % base)
+
+
+def _read_bitmap(r):
+    header = r.varint()
+    n, packed = header >> 1, header & 1
+    if n > MAX_ELEMENTS:
+        raise GlintError("bitmap length %d exceeds the limit of %d" % (n, MAX_ELEMENTS))
+    if packed:
+        data = r.read((n + 7) // 8)
+        return [data[i // 8] & (1 << (i % 8)) != 0 for i in range(n)]
+    return [b != 0 for b in r.read(n)]
+
+
+def _read_sparse(elem, r):
+    length, count = r.length("sparse slice"), r.varint()
+    if count > length:
+        raise GlintError("sparse slice holds %d values but has a length of %d" % (count, length))
+
+    # every sparse element type writes its zero value as a single zero byte
+    zero = _read_primitive(elem, Reader(b"\x00"))
+    s = [zero] * length
+    i = 0
+    for n in range(count):
+        if count != length:
+            i += r.varint()
+        if i >= length:
+            raise GlintError("sparse slice index %d out of range for length %d" % (i, length))
+        s[i] = _read_primitive(elem, r)
+        i += 1
+    return s
+
+
+def _read_delta(elem, r):
+    n = r.varint()
+    if n > r.left():  # every element takes at least a byte
+        raise GlintError("delta slice length %d exceeds remaining bytes %d" % (n, r.left()))
+    if n == 0:
+        return []
+
+    if elem in (w.FLOAT32, w.FLOAT64):
+        bits, fmt = (32, "<f") if elem == w.FLOAT32 else (64, "<d")
+        prev = r.varint() & ((1 << bits) - 1)
+        s = [prev]
+        for _ in range(n - 1):
+            prev ^= _read_float_xor(r) & ((1 << bits) - 1)
+            s.append(prev)
+        return [struct.unpack(fmt, v.to_bytes(bits // 8, "little"))[0] for v in s]
+
+    if elem not in w.INTEGER_WIDTHS or elem in (w.INT8, w.UINT8):
+        raise GlintError("delta encoding not supported for wire type %d" % elem)
+
+    v = _read_primitive(elem, r) if elem != w.DURATION else w.wrap(r.varint(), w.INT64)
+    s = [v]
+    for _ in range(n - 1):
+        v = w.wrap(v + w.unzigzag(r.varint()), elem)
+        s.append(v)
+    return [_duration(v) for v in s] if elem == w.DURATION else s
+
+
+def _read_float_xor(r):
+    # up to 70 bits, the XOR shifted right past its trailing zeros, with the count of them in the low 6 bits
+    value, shift = 0, 0
+    while True:
+        b = r.byte()
+        value |= (b & 0x7F) << shift
+        if b < 0x80:
+            break
+        shift += 7
+        if shift > 63:
+            raise GlintError("float delta longer than 10 bytes")
+    return ((value >> 6) << (value & 0b111111)) & 0xFFFFFFFFFFFFFFFF
+
+
+def _time(b):
+    # the layout of Go's time.Time.MarshalBinary
+    if not b:
+        return _EPOCH
+    version = b[0]
+    if version not in (1, 2) or len(b) != (15 if version == 1 else 16):
+        raise GlintError("unsupported time encoding")
+
+    seconds = int.from_bytes(b[1:9], "big", signed=True)
+    nanoseconds = int.from_bytes(b[9:13], "big", signed=True)
+    offset_minutes = int.from_bytes(b[13:15], "big", signed=True)
+
+    t = _EPOCH + datetime.timedelta(seconds=seconds, microseconds=nanoseconds // 1000)
+    if offset_minutes == -1:  # UTC
+        return t
+    offset = offset_minutes * 60 + (int.from_bytes(b[15:16], "big", signed=True) if version == 2 else 0)
+    return t.astimezone(datetime.timezone(datetime.timedelta(seconds=offset)))
+
+
+def _duration(nanoseconds):
+    return datetime.timedelta(microseconds=nanoseconds // 1000)
+
+
+def _address(b):
+    if not b:
+        return None
+    if len(b) not in (4, 16):
+        raise GlintError("invalid IP address of %d bytes" % len(b))
+    return ipaddress.ip_address(b)
+
+
+def _big_int(b):
+    if not b:
+        return 0
+    magnitude = int.from_bytes(b[1:], "big")
+    return -magnitude if b[0] == 1 else magnitude
@@ -0,0 +1,59 @@
+"""A typed model of document schemas, matching glint.Schema and glint.SchemaField in the Go package."""
+
+from dataclasses import dataclass, field as _field
+from typing import List, Optional
+
+from . import wire as w
+
+
+@dataclass
+class Field:
+    """A field of a schema, or the elements of a slice or the keys and values of a map, which have no name.
+
+    wire is the wire type as written, with its flags, so a slice of int64s written with delta encoding has a wire of
+    SLICE_FLAG | DELTA_FLAG | INT64. Slices of slices have a wire of SLICE_FLAG alone, with the inner slice as their
+    elem. Slices of structs carry the struct's fields in fields as well as in elem.
+    """
+
+    name: str = ""
+    wire: int = 0
+    fields: List["Field"] = _field(default_factory=list)
+    elem: Optional["Field"] = None
+    key: Optional["Field"] = None
+    value: Optional["Field"] = None
+    min: Optional[str] = None
+    max: Optional[str] = None
+    pattern: Optional[str] = None
+
+    @property
+    def base(self):
+        """The wire type without its flags."""
+        return self.wire & w.TYPE_MASK
+
+    def type_string(self):
+        """The field's type written like a Go type, as glint.SchemaField.TypeString does."""
+        if self.wire & w.SLICE_FLAG:
+            return "[]" + self.elem.type_string()
+        if self.wire & w.PTR_FLAG:
+            return "*" + Field(wire=self.wire & ~w.PTR_FLAG, fields=self.fields, key=self.key,
+                               value=self.value).type_string()
+        if self.base == w.STRUCT:
+            return "struct {" + "; ".join(f.name + " " + f.type_string() for f in self.fields) + "}"
+        if self.base == w.MAP:
+            return "map[" + self.key.type_string() + "]" + self.value.type_string()
+        return w.NAMES[self.base]
+
+
+@dataclass
+class Schema:
+    """The schema of a document, see parse_schema."""
+
+    hash: int
+    fields: List[Field]
+
+    def field(self, name):
+        """Returns the top level field called name, or None when there isn't one."""
+        for f in self.fields:
+            if f.name == name:
+                return f
+        return None
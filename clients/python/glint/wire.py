@@ -0,0 +1,101 @@
+"""Wire types, flags and the low level encodings shared by the reader and writer.
+
+The values mirror the WireType constants of the Go package, see glint_specification.md at the root of the
+repository for the layout of each.
+"""
+
+BOOL = 1
+INT = 2
+INT8 = 3
+INT16 = 4
+INT32 = 5
+INT64 = 6
+UINT = 7
+UINT8 = 8
+UINT16 = 9
+UINT32 = 10
+UINT64 = 11
+FLOAT32 = 12
+FLOAT64 = 13
+STRING = 14
+BYTES = 15
+STRUCT = 16
+MAP = 17
+TIME = 18
+DURATION = 19
+IP = 20
+IP_PREFIX = 21
+BIG_INT = 22
+DECIMAL = 23
+DYNAMIC = 24  # slice elements each written with their own wire type
+
+TYPE_MASK = 0b00011111
+
+SLICE_FLAG = 1 << 5  # slices, of the type in the low bits
+PTR_FLAG = 1 << 6  # nullable values, written after a presence byte
+DELTA_FLAG = 1 << 7  # numeric slices written as differences
+SPARSE_FLAG = 1 << 9  # numeric slices written without their zeros
+SCHEMA_REF_FLAG = 1 << 10  # struct schemas written as a reference to an earlier one
+CONSTRAINT_FLAG = 1 << 11  # field constraints follow the field name
+DICT_FLAG = 1 << 13  # strings written to the document's string table
+BITMAP_FLAG = 1 << 14  # bools packed eight to a byte
+ZIGZAG_FLAG = 1 << 15  # int64s and durations written as zigzag varints
+
+# flags that describe how a slice is written, rather than the type of its elements
+ENCODING_FLAGS = PTR_FLAG | DELTA_FLAG | SPARSE_FLAG | DICT_FLAG | BITMAP_FLAG | ZIGZAG_FLAG
+
+# the document flags byte
+FLAG_VERSION_MASK = 0b00000111
+FLAG_STRING_TABLE = 0b00001000
+FLAG_CODEC_MASK = 0b11110000
+
+VERSION_ORIGINAL = 0
+VERSION_SCHEMA_REFS = 1
+VERSION_CONSTRAINTS = 2
+VERSION_NAME_HASHES = 3
+VERSION_POINTERS = 4  # as VERSION_NAME_HASHES, with the elements of slices of pointers to structs marked
+
+CODEC_NONE = 0
+CODEC_DEFLATE = 1
+
+# integer wire types by their width in bits and whether they're signed
+INTEGER_WIDTHS = {
+    INT: (64, True),
+    INT8: (8, True),
+    INT16: (16, True),
+    INT32: (32, True),
+    INT64: (64, True),
+    DURATION: (64, True),
+    UINT: (64, False),
+    UINT8: (8, False),
+    UINT16: (16, False),
+    UINT32: (32, False),
+    UINT64: (64, False),
+}
+
+NAMES = {
+    BOOL: "bool", INT: "int", INT8: "int8", INT16: "int16", INT32: "int32", INT64: "int64",
+    UINT: "uint", UINT8: "uint8", UINT16: "uint16", UINT32: "uint32", UINT64: "uint64",
+    FLOAT32: "float32", FLOAT64: "float64", STRING: "string", BYTES: "bytes", STRUCT: "struct", MAP: "map",
+    TIME: "time", DURATION: "duration", IP: "ip", IP_PREFIX: "ipprefix", BIG_INT: "bigint", DECIMAL: "decimal",
+    DYNAMIC: "any",
+}
+
+
+def wrap(value, wire):
+    """Wraps an integer to the width of an integer wire type, as Go's conversions do."""
+    bits, signed = INTEGER_WIDTHS[wire]
+    value &= (1 << bits) - 1
+    if signed and value >= 1 << (bits - 1):
+        value -= 1 << bits
+    return value
+
+
+def zigzag(value):
+    """Returns the zigzag form of a signed 64 bit integer."""
+    return ((value << 1) ^ (value >> 63)) & 0xFFFFFFFFFFFFFFFF
+
+
+def unzigzag(value):
+    """Returns the signed 64 bit integer of a zigzag form."""
+    return (value >> 1) ^ -(value & 1)
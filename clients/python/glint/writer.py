@@ -0,0 +1,387 @@
+"""Writing glint documents from plain Python values."""
+
+import datetime
+import decimal
+import ipaddress
+import struct
+import zlib
+
+from . import wire as w
+from .reader import GlintError, _EPOCH
+from .schema import Field, Schema
+
+_INT64_MIN, _INT64_MAX = -(1 << 63), (1 << 63) - 1
+
+
+def encode(value, schema=None):
+    """Writes a dict as a glint document.
+
+    The schema says how each field is written, and can be a Schema, such as one returned by parse_schema, or a list
+    of Fields. Keys the schema doesn't have are an error, and fields missing from value are written as their zero
+    values, or as nil when they're nullable. Without a schema one is inferred from value, see infer_schema.
+    """
+    if schema is None:
+        schema = infer_schema(value)
+    fields = schema.fields if isinstance(schema, Schema) else list(schema)
+
+    writer = _Writer()
+    fields_bytes = writer.schema_fields(fields)
+    section = _varint(len(fields_bytes)) + fields_bytes
+
+    body = bytearray()
+    writer.struct(fields, value, body, "")
+
+    flags = w.VERSION_CONSTRAINTS if writer.constrained else w.VERSION_ORIGINAL
+    if writer.pointers:  # marking the elements of slices of pointers takes a version with a names section
+        flags = w.VERSION_POINTERS
+        section += _varint(0)
+    if writer.dict_fields:
+        table = bytearray(_varint(len(writer.strings)))
+        for s in writer.strings:
+            b = _encode_string(s)
+            table += _varint(len(b)) + b
+        body += table + len(table).to_bytes(4, "little")
+        flags |= w.FLAG_STRING_TABLE
+
+    return bytes([flags]) + zlib.crc32(section).to_bytes(4, "little") + section + bytes(body)
+
+
+def infer_schema(value):
+    """Infers a schema for a dict, as the glint command's convert --from json does for JSON.
+
+    Dicts become structs, lists become slices of the type of their first element, or of strings when they're empty.
+    ints become WireInt, or WireBigInt when they don't fit in 64 bits, floats become WireFloat64, and strs, bytes,
+    datetimes, timedeltas, ipaddress addresses, interfaces and networks, and decimal.Decimals their own wire
+    types. Fields holding None can't be inferred, pass a schema for those.
+    """
+    if not isinstance(value, dict):
+        raise GlintError("glint documents are dicts, not %s" % type(value).__name__)
+    return Schema(hash=0, fields=[_infer(str(k), v) for k, v in value.items()])
+
+
+def _infer(name, v):
+    if isinstance(v, dict):
+        return Field(name=name, wire=w.STRUCT, fields=infer_schema(v).fields)
+    if isinstance(v, list):
+        elem = _infer("", v[0] if v else "")
+        if elem.wire & w.SLICE_FLAG or elem.base == w.MAP:
+            return Field(name=name, wire=w.SLICE_FLAG, elem=elem)
+        return Field(name=name, wire=w.SLICE_FLAG | elem.wire, elem=elem, fields=elem.fields)
+    if isinstance(v, bool):
+        return Field(name=name, wire=w.BOOL)
+    if isinstance(v, int):
+        return Field(name=name, wire=w.INT if _INT64_MIN <= v <= _INT64_MAX else w.BIG_INT)
+    for types, wire in _INFERRED:
+        if isinstance(v, types):
+            return Field(name=name, wire=wire)
+    if v is None:
+        raise GlintError("can't infer the type of field %r from None, pass a schema" % name)
+    raise GlintError("can't infer the type of field %r from %s" % (name, type(v).__name__))
+
+
+_INFERRED = [
+    (float, w.FLOAT64),
+    (str, w.STRING),
+    ((bytes, bytearray), w.BYTES),
+    (datetime.datetime, w.TIME),
+    (datetime.timedelta, w.DURATION),
+    # interfaces are addresses too, so they're checked first
+    ((ipaddress.IPv4Interface, ipaddress.IPv6Interface, ipaddress.IPv4Network, ipaddress.IPv6Network), w.IP_PREFIX),
+    ((ipaddress.IPv4Address, ipaddress.IPv6Address), w.IP),
+    (decimal.Decimal, w.DECIMAL),
+]
+
+
+class _Writer:
+    def __init__(self):
+        self.strings = []  # the string table, in the order its entries were first written
+        self.index = {}
+        self.dict_fields = False
+        self.constrained = False
+        self.pointers = False
+
+    def schema_fields(self, fields):
+        out, names = bytearray(), set()
+        for f in fields:
+            name = _encode_string(f.name)
+            if len(name) > 255:
+                raise GlintError("field name %r is longer than 255 bytes" % f.name)
+            if f.name in names:
+                raise GlintError("schema names field %r twice" % f.name)
+            names.add(f.name)
+
+            constraints = bytearray()
+            for kind, text in ((1, f.min), (2, f.max), (3, f.pattern)):
+                if text is not None:
+                    b = _encode_string(text)
+                    constraints += bytes([kind]) + _varint(len(b)) + b
+
+            wire = f.wire | (w.CONSTRAINT_FLAG if constraints else 0)
+            out += _varint(wire) + bytes([len(name)]) + name
+            if constraints:
+                self.constrained = True
+                out += _varint(len(constraints)) + constraints
+            out += self.sub_schema(f)
+        return bytes(out)
+
+    def sub_schema(self, f):
+        if f.wire & w.DICT_FLAG:
+            self.dict_fields = True
+
+        base = f.wire & ~w.ENCODING_FLAGS
+        if base == w.SLICE_FLAG:
+            self.pointers = self.pointers or bool(f.elem.wire & w.PTR_FLAG)
+            return _varint(f.elem.wire) + self.sub_schema(f.elem)
+        if base & w.SLICE_FLAG:
+            return self.sub_schema(f.elem) if f.elem is not None else self.sub_schema(Field(wire=base & w.TYPE_MASK,
+                                                                                          fields=f.fields))
+        if base == w.STRUCT:
+            b = self.schema_fields(f.fields)
+            return _varint(len(b)) + b
+        if base == w.MAP:
+            return _varint(f.key.wire) + _varint(f.value.wire) + self.sub_schema(f.key) + self.sub_schema(f.value)
+        return b""
+
+    def struct(self, fields, value, out, path):
+        if value is None:
+            value = {}
+        if not isinstance(value, dict):
+            raise GlintError("%s: structs are written from dicts, not %s" % (path or "document", type(value).__name__))
+
+        known = {f.name for f in fields}
+        for k in value:
+            if k not in known:
+                raise GlintError("%s: the schema has no field %r" % (path or "document", k))
+        for f in fields:
+            self.value(f, value.get(f.name), out, path + "." + f.name if path else f.name)
+
+    def value(self, f, v, out, path):
+        if f.wire & w.PTR_FLAG:
+            if v is None:
+                out.append(0)
+                return
+            out.append(1)
+
+        wire = f.wire & ~w.PTR_FLAG
+        base = wire & w.TYPE_MASK
+        try:
+            if wire & w.BITMAP_FLAG:
+                self.bitmap(v or [], out)
+            elif wire & w.SPARSE_FLAG:
+                self.sparse(self.elem(f), v or [], out)
+            elif wire & w.DELTA_FLAG:
+                self.delta(self.elem(f), v or [], out)
+            elif wire & w.SLICE_FLAG:
+                v = v or []
+                out += _varint(len(v))
+                elem = f.elem if f.elem is not None else Field(wire=wire & (w.TYPE_MASK | w.ZIGZAG_FLAG),
+                                                               fields=f.fields)
+                for i, e in enumerate(v):
+                    if wire & w.DICT_FLAG:
+                        out += _varint(self.table_index(e))
+                    else:
+                        self.value(elem, e, out, "%s[%d]" % (path, i))
+            elif base == w.STRUCT:
+                self.struct(f.fields, v, out, path)
+            elif base == w.MAP:
+                v = v or {}
+                out += _varint(len(v))
+                for k, e in v.items():
+                    self.value(f.key, k, out, path)
+                    self.value(f.value, e, out, "%s[%r]" % (path, k))
+            elif wire == w.STRING | w.DICT_FLAG:
+                out += _varint(self.table_index(v))
+            elif wire in (w.INT64 | w.ZIGZAG_FLAG, w.DURATION | w.ZIGZAG_FLAG):
+                out += _varint(w.zigzag(_integer(_nanoseconds(v) if base == w.DURATION else v, w.INT64)))
+            elif base == w.DYNAMIC:
+                raise GlintError("%s: elements of slices of mixed types can't be written" % path)
+            else:
+                _write_primitive(base, v, out)
+        except (TypeError, ValueError, AttributeError, OverflowError) as e:
+            if isinstance(e, GlintError):
+                raise
+            raise GlintError("%s: %s" % (path, e)) from None
+
+    @staticmethod
+    def elem(f):
+        return f.elem.base if f.elem is not None else f.base
+
+    def table_index(self, s):
+        s = "" if s is None else s
+        if s not in self.index:
+            self.index[s] = len(self.strings)
+            self.strings.append(s)
+        return self.index[s]
+
+    @staticmethod
+    def bitmap(v, out):
+        packed = bytearray((len(v) + 7) // 8)
+        for i, b in enumerate(v):
+            if b:
+                packed[i // 8] |= 1 << (i % 8)
+        out += _varint(len(v) << 1 | 1) + packed
+
+    @staticmethod
+    def sparse(elem, v, out):
+        if elem in (w.FLOAT32, w.FLOAT64):
+            zero = [_float_bits(e, elem) == 0 for e in v]  # -0.0 isn't written as a single zero byte
+        else:
+            zero = [not e for e in v]
+
+        out += _varint(len(v))
+        if sum(zero) * 2 <= len(v):  # sparse only pays once more than half the elements are zero
+            out += _varint(len(v))
+            for e in v:
+                _write_primitive(elem, e, out)
+            return
+
+        out += _varint(len(v) - sum(zero))
+        gap = 0
+        for e, z in zip(v, zero):
+            if z:
+                gap += 1
+                continue
+            out += _varint(gap)
+            _write_primitive(elem, e, out)
+            gap = 0
+
+    @staticmethod
+    def delta(elem, v, out):
+        out += _varint(len(v))
+        if not v:
+            return
+
+        if elem in (w.FLOAT32, w.FLOAT64):
+            prev = _float_bits(v[0], elem)
+            out += _varint(prev)
+            for e in v[1:]:
+                bits = _float_bits(e, elem)
+                out += _float_xor(prev ^ bits)
+                prev = bits
+            return
+
+        if elem not in w.INTEGER_WIDTHS or elem in (w.INT8, w.UINT8):
+            raise GlintError("delta encoding not supported for wire type %d" % elem)
+
+        values = [_nanoseconds(e) for e in v] if elem == w.DURATION else v
+        _write_primitive(elem if elem != w.DURATION else w.INT64, values[0], out)
+        for prev, e in zip(values, values[1:]):
+            out += _varint(w.zigzag(w.wrap(_integer(e, elem) - prev, w.INT64)))
+
+
+def _write_primitive(base, v, out):
+    if base == w.BOOL:
+        out.append(1 if v else 0)
+    elif base in (w.INT, w.INT16, w.INT32):
+        out += _varint(w.zigzag(_integer(v, base)))
+    elif base in (w.INT8, w.UINT8):
+        out.append(_integer(v, base) & 0xFF)
+    elif base in (w.INT64, w.UINT, w.UINT16, w.UINT32, w.UINT64):
+        out += _varint(_integer(v, base) & 0xFFFFFFFFFFFFFFFF)
+    elif base in (w.FLOAT32, w.FLOAT64):
+        out += _varint(_float_bits(v, base))
+    elif base in (w.STRING, w.BYTES):
+        b = _encode_string(v or "") if base == w.STRING else bytes(v or b"")
+        out += _varint(len(b)) + b
+    elif base == w.TIME:
+        b = _time(v)
+        out += _varint(len(b)) + b
+    elif base == w.DURATION:
+        out += _varint(_integer(_nanoseconds(v), w.INT64) & 0xFFFFFFFFFFFFFFFF)
+    elif base == w.IP:
+        b = ipaddress.ip_address(v).packed if v is not None else b""
+        out += _varint(len(b)) + b
+    elif base == w.IP_PREFIX:
+        b = b""
+        if v is not None:
+            if not isinstance(v, (ipaddress.IPv4Network, ipaddress.IPv6Network)):
+                v = ipaddress.ip_interface(v)
+            address = v.ip if hasattr(v, "ip") else v.network_address
+            b = address.packed + bytes([v.network.prefixlen if hasattr(v, "network") else v.prefixlen])
+        out += _varint(len(b)) + b
+    elif base == w.BIG_INT:
+        b = _big_int(int(v or 0))
+        out += _varint(len(b)) + b
+    elif base == w.DECIMAL:
+        d = decimal.Decimal(0 if v is None else v)  # keeping the exponent of zeros such as 0.00
+        if not d.is_finite():
+            raise GlintError("decimals must be finite, not %s" % d)
+        sign, digits, exponent = d.as_tuple()
+        coefficient = int("".join(map(str, digits)) or "0") * (-1 if sign else 1)
+        b = _varint(w.zigzag(_integer(exponent, w.INT32))) + _big_int(coefficient)
+        out += _varint(len(b)) + b
+    else:
+        raise GlintError("unsupported wire type %d" % base)
+
+
+def _integer(v, wire):
+    v = int(v or 0)
+    bits, signed = w.INTEGER_WIDTHS[wire]
+    lo, hi = (-(1 << (bits - 1)), (1 << (bits - 1)) - 1) if signed else (0, (1 << bits) - 1)
+    if not lo <= v <= hi:
+        raise GlintError("%d is out of range for %s" % (v, w.NAMES[wire]))
+    return v
+
+
+def _float_bits(v, wire):
+    if wire == w.FLOAT32:
+        return int.from_bytes(struct.pack("<f", 0.0 if v is None else float(v)), "little")
+    return int.from_bytes(struct.pack("<d", 0.0 if v is None else float(v)), "little")  # keeping -0.0
+
+
+def _float_xor(x):
+    # the XOR shifted right past its trailing zeros, with the count of them in the low 6 bits
+    if x == 0:
+        return b"\x00"
+    tz = (x & -x).bit_length() - 1
+    return _varint((x >> tz) << 6 | tz)
+
+
+def _nanoseconds(v):
+    if isinstance(v, datetime.timedelta):
+        return (v // datetime.timedelta(microseconds=1)) * 1000
+    return int(v or 0)
+
+
+def _time(t):
+    # the layout of Go's time.Time.MarshalBinary, version 1 or 2 when the offset has seconds
+    if t is None:
+        t = _EPOCH
+    if t.tzinfo is None:
+        t = t.replace(tzinfo=datetime.timezone.utc)
+
+    since = t - _EPOCH
+    seconds = since.days * 86400 + since.seconds
+    offset = int(t.utcoffset().total_seconds())
+
+    version, offset_minutes = 1, offset // 60
+    if t.tzinfo is datetime.timezone.utc:
+        offset_minutes = -1
+    elif offset % 60:
+        version = 2
+
+    b = bytes([version]) + seconds.to_bytes(8, "big", signed=True)
+    b += (since.microseconds * 1000).to_bytes(4, "big", signed=True) + offset_minutes.to_bytes(2, "big", signed=True)
+    if version == 2:
+        b += (offset % 60).to_bytes(1, "big", signed=True)
+    return b
+
+
+def _big_int(v):
+    if v == 0:
+        return b""
+    magnitude = abs(v)
+    return bytes([1 if v < 0 else 0]) + magnitude.to_bytes((magnitude.bit_length() + 7) // 8, "big")
+
+
+def _encode_string(s):
+    return s.encode("utf-8", "surrogateescape")
+
+
+def _varint(v):
+    out = bytearray()
+    while v >= 0x80:
+        out.append(v & 0x7F | 0x80)
+        v >>= 7
+    out.append(v)
+    return bytes(out)
@@ -0,0 +1,135 @@
+import glob
+import json
+import os
+import unittest
+
+import glint
+from glint import wire
+from glint.reader import Reader
+
+TESTDATA = os.path.join(os.path.dirname(__file__), "..", "..", "..", "testdata")
+CORPUS = os.path.join(TESTDATA, "conformance")
+VERSIONS = os.path.join(TESTDATA, "versions")
+
+# features of the conformance corpus this client can't support, see README.md
+UNSUPPORTED = {"nanoseconds"}
+
+
+def read(path):
+    with open(path, "rb") as f:
+        return f.read()
+
+
+def conformance_cases():
+    with open(os.path.join(CORPUS, "manifest.json")) as f:
+        manifest = json.load(f)
+    return [c for c in manifest["cases"] if not UNSUPPORTED.intersection(c["features"])]
+
+
+class DecodeTest(unittest.TestCase):
+
+    def test_conformance(self):
+        cases = conformance_cases()
+        self.assertGreater(len(cases), 0)
+        for case in cases:
+            with self.subTest(case["name"]):
+                doc = read(os.path.join(CORPUS, case["document"]))
+                if case.get("error"):
+                    with self.assertRaises(glint.GlintError):
+                        glint.decode(doc)
+                    continue
+                with open(os.path.join(CORPUS, case["expect"])) as f:
+                    expected = json.load(f)
+                self.assertEqual(glint.jsonable(glint.decode(doc)), expected)
+
+    def test_version_fixtures(self):
+        # the documents every version of the format was written as, kept by the Go package
+        files = sorted(glob.glob(os.path.join(VERSIONS, "v*", "populated.glint")))
+        self.assertGreater(len(files), 0)
+        for path in files:
+            with self.subTest(path):
+                v = glint.decode(read(path))
+                self.assertEqual(v["string"], "hello, archive")
+                self.assertEqual(v["items"], [{"a": 3, "b": "three"}, {"a": 4, "b": "four"}])
+                self.assertEqual(v["nested"], {"k": {"a": 5, "b": "five"}})
+                self.assertEqual(v["deltas"], [100, 101, 99, 1000])
+                self.assertEqual(v["matrix"], [[1, 2], [], [3]])
+                if "refs" in v:  # version 4 marks the elements of slices of pointers
+                    self.assertEqual(v["refs"], [{"a": 6, "b": "six"}, None])
+
+    def test_types(self):
+        v = glint.decode(read(os.path.join(CORPUS, "scalars.glint")))
+        self.assertEqual(v["uint64"], (1 << 64) - 1)
+        self.assertEqual(v["bigint"], -123456789012345678901234567890)
+        self.assertEqual(str(v["decimal"]), "-123.45")
+        self.assertEqual(v["time"].isoformat(), "2021-03-04T05:06:07.000891+00:00")
+        self.assertEqual(v["zone"].isoformat(), "1999-12-31T23:59:59-05:30")
+        self.assertEqual(v["duration"].total_seconds(), -90)
+        self.assertEqual(str(v["ip6"]), "2001:db8::1")
+        self.assertEqual(v["bytes"], b"\x00\x01\x02\xfe\xff")
+
+        pointers = glint.decode(read(os.path.join(CORPUS, "pointers.glint")))
+        self.assertIsNone(pointers["nil"])
+        self.assertIsNone(pointers["nil_slice"])
+        self.assertEqual(pointers["empty"], [])
+
+    def test_parse_schema(self):
+        schema = glint.parse_schema(read(os.path.join(CORPUS, "shared.glint")))
+
+        self.assertEqual(schema.field("id").min, "1")
+        self.assertEqual(schema.field("id").max, "1000")
+        self.assertEqual(schema.field("name").pattern, "^[a-z]+$")
+        # work and other refer back to the schema written for home
+        self.assertEqual(schema.field("work").type_string(), "struct {a int; b string}")
+        self.assertEqual(schema.field("other").type_string(), "[]struct {a int; b string}")
+
+        encodings = glint.parse_schema(read(os.path.join(CORPUS, "encodings.glint")))
+        self.assertEqual(encodings.field("deltas").wire,
+                         wire.SLICE_FLAG | wire.DELTA_FLAG | wire.INT64)
+        self.assertIsNone(encodings.field("missing"))
+
+    def test_parse_schema_bytes(self):
+        doc = read(os.path.join(CORPUS, "zero.glint"))
+        self.assertEqual(doc[0], 0)  # version 0, so the schema is all that follows the header up to the body
+        r = Reader(doc)
+        r.pos = 5
+        length = r.varint()
+        schema = doc[5:r.pos + length]
+
+        parsed = glint.parse_schema_bytes(schema)
+        self.assertEqual(parsed, glint.parse_schema(doc))
+
+    def test_errors(self):
+        doc = read(os.path.join(CORPUS, "scalars.glint"))
+
+        with self.assertRaises(glint.GlintError):
+            glint.decode(doc[:len(doc) // 2])
+        with self.assertRaises(glint.GlintError):
+            glint.decode(bytes([7]) + doc[1:])  # unknown format version
+        with self.assertRaises(glint.GlintError):
+            glint.decode(doc + b"\x00")  # trailing bytes
+        with self.assertRaises(glint.GlintError):
+            glint.decode(b"\x00\x01")
+
+        # trusted schema mode writes the header with an empty schema in front of the body
+        with self.assertRaises(glint.SchemaNotFoundError):
+            glint.decode(doc[:5] + b"\x00\x01")
+
+    def test_dynamic(self):
+        doc = read(os.path.join(CORPUS, "dynamic.glint"))
+        v = glint.decode(doc)
+        self.assertEqual(v["values"][:4], [1, "a", True, None])
+        self.assertEqual(v["values"][13], [3, 4])  # a uint8 slice, written as bytes
+        self.assertEqual(v["empty"], [])
+        self.assertEqual(glint.parse_schema(doc).field("values").type_string(), "[]any")
+
+        # elements can't be structs, which would need a schema of their own
+        with self.assertRaisesRegex(glint.GlintError, "unsupported dynamic wire type 16"):
+            glint.decode(doc.replace(b"\x0e\x04last", b"\x10\x04last"))
+
+    def test_empty_document(self):
+        self.assertEqual(glint.decode(glint.encode({})), {})
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,160 @@
+import datetime
+import decimal
+import ipaddress
+import json
+import os
+import unittest
+
+import glint
+from glint import Field, wire
+
+from .test_decode import CORPUS, conformance_cases, read
+
+FIXTURES = os.path.join(os.path.dirname(__file__), "fixtures")
+
+# set to rewrite the documents in fixtures, which the Go package's TestClientFixtures reads back
+UPDATE = os.environ.get("GLINT_UPDATE_FIXTURES") == "1"
+
+UTC = datetime.timezone.utc
+
+
+def inferred():
+    return glint.encode({
+        "name": "ada",
+        "age": 36,
+        "score": 99.5,
+        "active": True,
+        "huge": 1 << 70,
+        "avatar": b"\x89PNG",
+        "joined": datetime.datetime(2020, 5, 6, 7, 8, 9, 123456, tzinfo=UTC),
+        "timeout": datetime.timedelta(seconds=30),
+        "address": {"city": "London", "lines": ["1 Main St", "Flat 2"]},
+        "grid": [[1, 2], [3]],
+        "friends": [{"name": "bob", "age": 40}],
+        "none": [],
+        "host": ipaddress.ip_address("10.0.0.1"),
+        "net": ipaddress.ip_interface("fd00::1/64"),
+        "price": decimal.Decimal("19.99"),
+    })
+
+
+def typed():
+    item = [Field("sku", wire.STRING | wire.DICT_FLAG), Field("qty", wire.UINT16)]
+    schema = [
+        Field("id", wire.INT64, min="1"),
+        Field("note", wire.PTR_FLAG | wire.STRING),
+        Field("missing", wire.PTR_FLAG | wire.STRUCT, fields=item),
+        Field("items", wire.SLICE_FLAG | wire.PTR_FLAG | wire.STRUCT, fields=item, elem=Field(wire=wire.STRUCT, fields=item)),
+        Field("readings", wire.SLICE_FLAG | wire.DELTA_FLAG | wire.INT32, elem=Field(wire=wire.INT32)),
+        Field("levels", wire.SLICE_FLAG | wire.DELTA_FLAG | wire.FLOAT64, elem=Field(wire=wire.FLOAT64)),
+        Field("counts", wire.SLICE_FLAG | wire.SPARSE_FLAG | wire.UINT32, elem=Field(wire=wire.UINT32)),
+        Field("flags", wire.SLICE_FLAG | wire.BITMAP_FLAG | wire.BOOL, elem=Field(wire=wire.BOOL)),
+        Field("offset", wire.INT64 | wire.ZIGZAG_FLAG),
+        Field("totals", wire.MAP, key=Field(wire=wire.STRING), value=Field(wire=wire.FLOAT32)),
+        Field("window", wire.DURATION),
+        Field("small", wire.INT8),
+        Field("refs", wire.SLICE_FLAG, elem=Field(wire=wire.PTR_FLAG | wire.STRUCT, fields=item)),
+    ]
+    return glint.encode({
+        "id": 12,
+        "note": None,
+        "items": [{"sku": "a-1", "qty": 2}, {"sku": "a-1", "qty": 65535}, {"sku": "b-2", "qty": 0}],
+        "readings": [100, 98, -2147483648, 2147483647],
+        "levels": [1.5, 1.5, 1.75, -0.0],
+        "counts": [0, 0, 0, 4000000000, 0],
+        "flags": [True, False, True, True, False, False, False, False, True],
+        "offset": -7,
+        "totals": {"gbp": 1.25},
+        "window": datetime.timedelta(minutes=-5),
+        "small": -128,
+        "refs": [{"sku": "c-3", "qty": 1}, None],
+    }, schema)
+
+
+PYTHON_FIXTURES = {"inferred": inferred, "typed": typed}
+
+
+class EncodeTest(unittest.TestCase):
+
+    def test_python_fixtures(self):
+        for name, fixture in PYTHON_FIXTURES.items():
+            with self.subTest(name):
+                doc = fixture()
+                path = os.path.join(FIXTURES, name)
+                if UPDATE:
+                    with open(path + ".glint", "wb") as f:
+                        f.write(doc)
+                    with open(path + ".json", "w") as f:
+                        json.dump(glint.jsonable(glint.decode(doc)), f, indent=2, ensure_ascii=False)
+                        f.write("\n")
+
+                # the Go package reads these same bytes back and compares them with the JSON
+                with open(path + ".glint", "rb") as f:
+                    self.assertEqual(doc, f.read())
+                with open(path + ".json") as f:
+                    self.assertEqual(glint.jsonable(glint.decode(doc)), json.load(f))
+
+    def test_round_trip_conformance(self):
+        for case in conformance_cases():
+            if case.get("error"):
+                continue
+            with self.subTest(case["name"]):
+                doc = read(os.path.join(CORPUS, case["document"]))
+                value = glint.decode(doc)
+                if "dynamic" in case["features"]:  # read, but not written
+                    with self.assertRaisesRegex(glint.GlintError, "mixed types"):
+                        glint.encode(value, glint.parse_schema(doc))
+                    continue
+                out = glint.encode(value, glint.parse_schema(doc))
+                self.assertEqual(glint.decode(out), value)
+
+                # documents without shared schemas, name hashes or compression come out byte for byte the same
+                if doc[0] & (wire.FLAG_VERSION_MASK | wire.FLAG_CODEC_MASK) == 0:
+                    self.assertEqual(out, doc)
+
+    def test_inferred_schema(self):
+        schema = glint.parse_schema(inferred())
+        self.assertEqual(schema.field("age").wire, wire.INT)
+        self.assertEqual(schema.field("huge").wire, wire.BIG_INT)
+        self.assertEqual(schema.field("none").type_string(), "[]string")
+        self.assertEqual(schema.field("grid").type_string(), "[][]int")
+        self.assertEqual(schema.field("friends").type_string(), "[]struct {name string; age int}")
+
+        value = glint.decode(inferred())
+        self.assertEqual(value["address"], {"city": "London", "lines": ["1 Main St", "Flat 2"]})
+        self.assertEqual(value["price"], decimal.Decimal("19.99"))
+
+    def test_slices_of_pointers(self):
+        # marking the elements of a slice of pointers takes version 4, which has an empty names section
+        doc = typed()
+        self.assertEqual(doc[0] & wire.FLAG_VERSION_MASK, wire.VERSION_POINTERS)
+        self.assertEqual(glint.parse_schema(doc).field("refs").type_string(), "[]*struct {sku string; qty uint16}")
+        self.assertEqual(glint.decode(doc)["refs"], [{"sku": "c-3", "qty": 1}, None])
+
+    def test_signed_zeros(self):
+        schema = [Field("f", wire.FLOAT64), Field("d", wire.DECIMAL)]
+        value = glint.decode(glint.encode({"f": -0.0, "d": decimal.Decimal("0.00")}, schema))
+        self.assertEqual(str(value["f"]), "-0.0")
+        self.assertEqual(str(value["d"]), "0.00")
+
+    def test_missing_fields_are_zero(self):
+        schema = [Field("a", wire.INT), Field("b", wire.STRING), Field("c", wire.PTR_FLAG | wire.INT)]
+        self.assertEqual(glint.decode(glint.encode({}, schema)), {"a": 0, "b": "", "c": None})
+
+    def test_errors(self):
+        with self.assertRaises(glint.GlintError):
+            glint.encode({"a": 1}, [Field("b", wire.INT)])  # a key the schema doesn't have
+        with self.assertRaises(glint.GlintError):
+            glint.encode({"a": None})  # nothing to infer a type from
+        with self.assertRaises(glint.GlintError):
+            glint.encode({"a": 300}, [Field("a", wire.UINT8)])
+        with self.assertRaises(glint.GlintError):
+            glint.encode({"a": -1}, [Field("a", wire.UINT64)])
+        with self.assertRaises(glint.GlintError):
+            glint.encode({"a": "x"}, [Field("a", wire.INT)])
+        with self.assertRaises(glint.GlintError):
+            glint.encode([1, 2])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kungfusheep/glint"
+)
+
+// BenchCmd compares encoding and decoding a JSON payload with glint against encoding/json
+type BenchCmd struct {
+	structFile string
+	duration   time.Duration
+	flags      *flag.FlagSet
+}
+
+func (b *BenchCmd) Name() string { return "bench" }
+
+func (b *BenchCmd) DefineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&b.structFile, "struct", "", "Document or schema file giving the payload's types (default inferred from the JSON)")
+	fs.DurationVar(&b.duration, "time", time.Second, "How long to run each measurement")
+	b.flags = fs
+}
+
+func (b *BenchCmd) Execute(args []string) error {
+	files, err := interspersedArgs(b.flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) > 1 {
+		return fmt.Errorf("usage: glint bench <file.json> [--struct schema.glint]")
+	}
+	payload, err := readInput(files)
+	if err != nil {
+		return err
+	}
+
+	var schema *glint.Schema
+	if b.structFile != "" {
+		data, err := os.ReadFile(b.structFile)
+		if err != nil {
+			return fmt.Errorf("error reading schema: %v", err)
+		}
+		if schema, err = loadSchema(data); err != nil {
+			return fmt.Errorf("error reading schema %s: %v", b.structFile, err)
+		}
+	}
+
+	results, err := benchPayload(payload, schema, b.duration)
+	if err != nil {
+		return err
+	}
+	return writeBenchReport(os.Stdout, results)
+}
+
+// benchResult is the measurements of one format
+type benchResult struct {
+	format         string
+	size           int
+	encode, decode benchTiming
+}
+
+// benchTiming is the cost of one operation
+type benchTiming struct {
+	ns     float64 // nanoseconds per operation
+	allocs float64 // allocations per operation
+}
+
+// benchPayload measures encoding and decoding a JSON object as the Go value the schema describes, with
+// encoding/json and then with glint. Without a schema, the types are inferred from the JSON as convert infers them.
+func benchPayload(payload []byte, schema *glint.Schema, d time.Duration) ([]benchResult, error) {
+	if schema == nil {
+		var data interface{}
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return nil, fmt.Errorf("error parsing JSON: %v", err)
+		}
+		if _, ok := data.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("the payload must be a JSON object, or given a schema with --struct")
+		}
+		doc, err := jsonToGlint(data)
+		if err != nil {
+			return nil, err
+		}
+		if schema, err = glint.ParseSchema(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := schemaValue(payload, schema)
+	if err != nil {
+		return nil, err
+	}
+	out := reflect.New(v.Type().Elem()).Interface()
+
+	// both sides are checked once up front, so the loops can ignore errors
+	jsonDoc, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(jsonDoc, out); err != nil {
+		return nil, err
+	}
+	glintDoc, err := glint.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if err := glint.Unmarshal(glintDoc, out); err != nil {
+		return nil, err
+	}
+
+	return []benchResult{
+		{
+			format: "json",
+			size:   len(jsonDoc),
+			encode: measure(d, func() { json.Marshal(v.Interface()) }),
+			decode: measure(d, func() { json.Unmarshal(jsonDoc, out) }),
+		},
+		{
+			format: "glint",
+			size:   len(glintDoc),
+			encode: measure(d, func() { glint.Marshal(v.Interface()) }),
+			decode: measure(d, func() { glint.Unmarshal(glintDoc, out) }),
+		},
+	}, nil
+}
+
+// measure runs fn repeatedly for about d, doubling the number of runs between checks of the clock
+func measure(d time.Duration, fn func()) benchTiming {
+	fn() // warm up caches, and the encoders and decoders built on first use
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	n := 0
+	start := time.Now()
+	for batch := 1; ; batch *= 2 {
+		for i := 0; i < batch; i++ {
+			fn()
+		}
+		n += batch
+		if time.Since(start) >= d {
+			break
+		}
+	}
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	return benchTiming{
+		ns:     float64(elapsed.Nanoseconds()) / float64(n),
+		allocs: float64(after.Mallocs-before.Mallocs) / float64(n),
+	}
+}
+
+// writeBenchReport writes the results as a table, followed by how glint compares to the first result
+func writeBenchReport(w io.Writer, results []benchResult) error {
+	mbps := func(size int, t benchTiming) float64 {
+		return float64(size) / t.ns * 1e9 / (1 << 20)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "FORMAT\tSIZE\tENCODE\tMB/s\tALLOCS\tDECODE\tMB/s\tALLOCS\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d B\t%s\t%.1f\t%.0f\t%s\t%.1f\t%.0f\t\n", r.format, r.size,
+			time.Duration(r.encode.ns), mbps(r.size, r.encode), r.encode.allocs,
+			time.Duration(r.decode.ns), mbps(r.size, r.decode), r.decode.allocs)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	base, glint := results[0], results[len(results)-1]
+	_, err := fmt.Fprintf(w, "\nglint is %.1f%% of the size of %s, encodes %.2fx and decodes %.2fx as fast\n",
+		100*float64(glint.size)/float64(base.size), base.format, base.encode.ns/glint.encode.ns, base.decode.ns/glint.decode.ns)
+	return err
+}
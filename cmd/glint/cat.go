@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kungfusheep/glint"
+)
+
+// CatCmd prints documents in one of several formats
+type CatCmd struct {
+	format string
+	stream bool
+	where  string
+	flags  *flag.FlagSet
+}
+
+func (c *CatCmd) Name() string { return "cat" }
+
+func (c *CatCmd) DefineFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "format", "pretty", "Output format (pretty, json, yaml, table)")
+	fs.BoolVar(&c.stream, "stream", false, "Read a stream of records, as written by glint.RecordWriter, printing each as it arrives")
+	fs.StringVar(&c.where, "where", "", "Print only the documents where a path has a value, as in user.name=ann or user.name!=ann")
+	c.flags = fs
+}
+
+func (c *CatCmd) Execute(args []string) error {
+	// flags may follow the files as well, as in glint cat data.glint --format json
+	files, err := interspersedArgs(c.flags, args)
+	if err != nil {
+		return err
+	}
+
+	var filter *documentFilter
+	if c.where != "" {
+		if filter, err = parseDocumentFilter(c.where); err != nil {
+			return err
+		}
+	}
+
+	if c.stream {
+		return withRecordInput(files, func(r io.Reader) error {
+			return streamDocuments(r, os.Stdout, os.Stderr, c.format, filter)
+		}, nil)
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	docs := make([][]byte, 0, len(files))
+	for _, file := range files {
+		doc, err := readInput([]string{file})
+		if err != nil {
+			return err
+		}
+		keep := true
+		if filter != nil {
+			if keep, err = filter.match(doc); err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		if keep {
+			docs = append(docs, doc)
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	return catDocuments(os.Stdout, docs, c.format)
+}
+
+// interspersedArgs parses the flags in args wherever they appear among the positional arguments, returning the
+// positional arguments. Everything after a -- terminator is positional.
+func interspersedArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for len(args) > 0 {
+		positional = append(positional, args[0])
+		if err := fs.Parse(args[1:]); err != nil {
+			return nil, err
+		}
+
+		rest := args[1:]
+		args = fs.Args()
+		if n := len(rest) - len(args); n > 0 && rest[n-1] == "--" {
+			return append(positional, args...), nil
+		}
+	}
+	return positional, nil
+}
+
+// catDocuments writes docs to w in the named format. JSON and YAML write each document in turn, as a stream of
+// values, while a table puts them all in one.
+func catDocuments(w io.Writer, docs [][]byte, format string) error {
+	switch format {
+	case "pretty":
+		for _, doc := range docs {
+			s, err := glint.SPrint(doc)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json", "yaml", "table":
+	default:
+		return fmt.Errorf("unsupported format %q, expected pretty, json, yaml or table", format)
+	}
+
+	values := make([]any, len(docs))
+	for i, doc := range docs {
+		v, err := documentJSON(doc)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "yaml":
+		var b bytes.Buffer
+		for i, v := range values {
+			if i > 0 || len(values) > 1 {
+				b.WriteString("---\n")
+			}
+			writeYAML(&b, v, 0)
+		}
+		_, err := w.Write(b.Bytes())
+		return err
+	default:
+		return writeTable(w, values)
+	}
+}
+
+// streamDocuments prints each record read from r to w as soon as it arrives, as catDocuments would print it alone,
+// keeping only those filter matches when there is a filter. JSON is written a document to a line. Records that
+// aren't valid documents are reported to errw and skipped, so one bad message doesn't end a live stream, but a
+// stream that can't be split into records is an error.
+func streamDocuments(r io.Reader, w, errw io.Writer, format string, filter *documentFilter) error {
+	switch format {
+	case "pretty", "json", "yaml":
+	case "table":
+		return fmt.Errorf("tables can't be streamed, use pretty, json or yaml")
+	default:
+		return fmt.Errorf("unsupported format %q, expected pretty, json or yaml", format)
+	}
+
+	var b bytes.Buffer
+	rr := glint.NewRecordReader(r)
+	for i := 1; rr.Next(); i++ {
+		doc := rr.Record()
+		b.Reset()
+
+		err := func() error {
+			if filter != nil {
+				if keep, err := filter.match(doc); err != nil || !keep {
+					return err
+				}
+			}
+
+			switch format {
+			case "json":
+				v, err := documentJSON(doc)
+				if err != nil {
+					return err
+				}
+				return json.NewEncoder(&b).Encode(v)
+			case "yaml":
+				b.WriteString("---\n")
+			}
+			return catDocuments(&b, [][]byte{doc}, format)
+		}()
+		if err != nil {
+			fmt.Fprintf(errw, "record %d: %v\n", i, err)
+			continue
+		}
+
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := rr.Err(); err != nil {
+		return fmt.Errorf("error reading records: %v", err)
+	}
+	return nil
+}
+
+// documentJSON decodes doc into the values encoding/json unmarshals into, with numbers kept as json.Number so none
+// lose precision. Going through JSON gives every format the same view of types JSON has no form for, such as
+// times and durations.
+func documentJSON(doc []byte) (any, error) {
+	data, err := glint.DocumentTemplateData(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(jsonReady(data))
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	return v, d.Decode(&v)
+}
+
+// jsonReady replaces the values in decoded document data that encoding/json would write badly. Decimals are
+// written as their decimal strings rather than their parts, and addresses written without any bytes as null.
+func jsonReady(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonReady(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = jsonReady(e)
+		}
+	case glint.Decimal:
+		return v.String()
+	case netip.Addr:
+		if !v.IsValid() {
+			return nil
+		}
+	case netip.Prefix:
+		if !v.IsValid() {
+			return nil
+		}
+	}
+	return v
+}
+
+// yamlPlain matches the strings that can be written in YAML without quotes, and yamlReserved the plain strings
+// YAML would read as something other than a string
+var (
+	yamlPlain    = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_ ./@-]*$`)
+	yamlReserved = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null)$`)
+)
+
+// writeYAML writes a value decoded by documentJSON as YAML, indented by indent levels. Map keys are sorted, as
+// they are in JSON.
+func writeYAML(b *bytes.Buffer, v any, indent int) {
+	pad := strings.Repeat("  ", indent)
+
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			b.WriteString("{}\n")
+			return
+		}
+		for i, k := range sortedKeys(v) {
+			if i > 0 || b.Len() == 0 || b.Bytes()[b.Len()-1] == '\n' {
+				b.WriteString(pad)
+			}
+			b.WriteString(yamlString(k))
+			b.WriteString(":")
+			writeYAMLChild(b, v[k], indent)
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString("[]\n")
+			return
+		}
+		for i, e := range v {
+			if i > 0 || b.Len() == 0 || b.Bytes()[b.Len()-1] == '\n' {
+				b.WriteString(pad)
+			}
+			b.WriteString("- ")
+			writeYAML(b, e, indent+1) // the first entry of a map or slice follows the dash, the rest line up beneath it
+		}
+	default:
+		writeYAMLScalar(b, v)
+	}
+}
+
+// writeYAMLChild writes the value of a map entry, on the same line when it's a scalar or empty
+func writeYAMLChild(b *bytes.Buffer, v any, indent int) {
+	if n := length(v); n > 0 {
+		b.WriteString("\n")
+		if _, ok := v.([]any); ok {
+			writeYAML(b, v, indent) // sequences in maps are conventionally indented no further than their key
+			return
+		}
+		writeYAML(b, v, indent+1)
+		return
+	}
+	b.WriteString(" ")
+	writeYAML(b, v, indent)
+}
+
+// writeYAMLScalar writes a single value followed by a newline
+func writeYAMLScalar(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		s := v.String()
+		if i := strings.IndexAny(s, "eE"); i >= 0 && !strings.Contains(s[:i], ".") {
+			s = s[:i] + ".0" + s[i:] // YAML 1.1 reads exponents without a point as strings
+		}
+		b.WriteString(s)
+	case string:
+		b.WriteString(yamlString(v))
+	default:
+		b.WriteString(yamlString(fmt.Sprint(v)))
+	}
+	b.WriteString("\n")
+}
+
+// yamlString writes s plainly when YAML would read it back as the same string, and double quoted otherwise. Go's
+// quoting uses escapes that YAML's double quoted strings share.
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !yamlReserved.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// length returns the number of entries in a map or slice, and -1 for anything else
+func length(v any) int {
+	switch v := v.(type) {
+	case map[string]any:
+		return len(v)
+	case []any:
+		return len(v)
+	}
+	return -1
+}
+
+// writeTable writes values as a table. A single document is written as a column of fields and a column of values,
+// unless all it holds is a list of objects, which is written as a row for each. Several documents are written as a
+// row for each document. Nested fields are flattened into columns named by their path.
+func writeTable(w io.Writer, values []any) error {
+	rows := values
+	if len(values) == 1 {
+		rows = nil
+		if m, ok := values[0].(map[string]any); ok && len(m) == 1 {
+			for _, v := range m {
+				if list, ok := v.([]any); ok && len(list) > 0 && length(list[0]) >= 0 {
+					rows = list
+				}
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if rows == nil {
+		fields := map[string]any{}
+		flattenTable("", values[0], fields)
+
+		fmt.Fprintln(tw, "FIELD\tVALUE")
+		for _, k := range sortedKeys(fields) {
+			fmt.Fprintf(tw, "%s\t%s\n", k, tableCell(fields[k]))
+		}
+		return tw.Flush()
+	}
+
+	flattened := make([]map[string]any, len(rows))
+	columns := map[string]any{}
+	for i, row := range rows {
+		flattened[i] = map[string]any{}
+		flattenTable("", row, flattened[i])
+		for k := range flattened[i] {
+			columns[k] = nil
+		}
+	}
+
+	headers := sortedKeys(columns)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(headers, "\t")))
+	for _, row := range flattened {
+		cells := make([]string, len(headers))
+		for i, h := range headers {
+			cells[i] = tableCell(row[h])
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// flattenTable flattens the objects within v into fields, named by their path from the root. Lists are left whole,
+// to be summarised by tableCell.
+func flattenTable(prefix string, v any, fields map[string]any) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) == 0 {
+		if prefix == "" {
+			prefix = "value"
+		}
+		fields[prefix] = v
+		return
+	}
+
+	for k, e := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flattenTable(k, e, fields)
+	}
+}
+
+// tableCell formats a value for a table cell, keeping it to a single line
+func tableCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(v)
+	case []any:
+		if len(v) > 3 {
+			return fmt.Sprintf("[%d items]", len(v))
+		}
+		items := make([]string, len(v))
+		for i, e := range v {
+			items[i] = tableCell(e)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		if len(v) == 0 {
+			return "{}"
+		}
+		return fmt.Sprintf("{%d fields}", len(v))
+	}
+	return fmt.Sprint(v)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
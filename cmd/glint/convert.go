@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kungfusheep/glint"
+)
+
+// JSON is written to a schema by building a Go type from the schema, tagged as glint's struct generator would tag
+// it, filling it in from the JSON and encoding it. The JSON is read as documentJSON writes it, so documents convert
+// to JSON and back without losing anything: times as RFC 3339 strings, durations as nanoseconds, bytes as base64
+// and decimals as strings. Durations may also be written as strings such as "1m30s", and big integers and
+// decimals as numbers.
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	addrType     = reflect.TypeOf(netip.Addr{})
+	prefixType   = reflect.TypeOf(netip.Prefix{})
+	bigIntType   = reflect.TypeOf(big.Int{})
+	decimalType  = reflect.TypeOf(glint.Decimal{})
+)
+
+// jsonToSchema converts a JSON object to a document with the given schema. Fields the JSON leaves out are written
+// as zero values, and keys the schema doesn't have are an error.
+func jsonToSchema(input []byte, schema *glint.Schema) ([]byte, error) {
+	doc, err := schemaValue(input, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := glint.Marshal(doc.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	// options the schema was written with that aren't part of its types, such as distinct nil slices, can't be
+	// carried by tags, but decoders don't depend on them. Anything else means the schema couldn't be rebuilt.
+	written, err := glint.ParseSchema(out)
+	if err != nil {
+		return nil, err
+	}
+	if written.String() != schema.String() {
+		return nil, fmt.Errorf("the schema can't be reproduced, documents would be written as\n%s", written)
+	}
+	return out, nil
+}
+
+// schemaValue reads a JSON object into a new value of the type schemaType builds for schema, returning a pointer
+// to it
+func schemaValue(input []byte, schema *glint.Schema) (reflect.Value, error) {
+	d := json.NewDecoder(bytes.NewReader(input))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return reflect.Value{}, fmt.Errorf("error parsing JSON: %v", err)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return reflect.Value{}, fmt.Errorf("error parsing JSON: more than one value")
+	}
+
+	t, err := schemaType(schema.Fields)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	doc := reflect.New(t)
+	if err := assignJSON(doc.Elem(), v, ""); err != nil {
+		return reflect.Value{}, err
+	}
+	return doc, nil
+}
+
+// schemaType builds a struct type with the given fields, each tagged with its name and options, and with its name
+// for encoding/json
+func schemaType(fields []glint.SchemaField) (reflect.Type, error) {
+	structFields := make([]reflect.StructField, len(fields))
+	for i := range fields {
+		t, err := fieldType(&fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", fields[i].Name, err)
+		}
+		tag := strings.Join(append([]string{fields[i].Name}, fields[i].Options()...), ",")
+		structFields[i] = reflect.StructField{
+			Name: "F" + strconv.Itoa(i), // names have to be exported, the tags give the real ones
+			Type: t,
+			Tag:  reflect.StructTag("glint:" + strconv.Quote(tag) + " json:" + strconv.Quote(fields[i].Name)),
+		}
+	}
+	return reflect.StructOf(structFields), nil
+}
+
+// fieldType returns the Go type of a schema field, as structGenerator.goType names it
+func fieldType(field *glint.SchemaField) (reflect.Type, error) {
+	switch {
+	case field.Wire&glint.WireSliceFlag > 0:
+		elem, err := fieldType(field.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+
+	case field.Wire&glint.WirePtrFlag > 0 && field.Wire&glint.WireTypeMask != glint.WireBytes:
+		inner := *field
+		inner.Wire &^= glint.WirePtrFlag
+		t, err := fieldType(&inner)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PointerTo(t), nil
+	}
+
+	switch field.Wire & glint.WireTypeMask {
+	case glint.WireStruct:
+		return schemaType(field.Fields)
+
+	case glint.WireMap:
+		key, err := fieldType(field.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid map key type: %v", err)
+		}
+		value, err := fieldType(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid map value type: %v", err)
+		}
+		return reflect.MapOf(key, value), nil
+	}
+
+	t, ok := map[glint.WireType]reflect.Type{
+		glint.WireBool: reflect.TypeOf(false), glint.WireString: reflect.TypeOf(""), glint.WireBytes: reflect.TypeOf([]byte(nil)),
+		glint.WireInt: reflect.TypeOf(int(0)), glint.WireInt8: reflect.TypeOf(int8(0)), glint.WireInt16: reflect.TypeOf(int16(0)),
+		glint.WireInt32: reflect.TypeOf(int32(0)), glint.WireInt64: reflect.TypeOf(int64(0)),
+		glint.WireUint: reflect.TypeOf(uint(0)), glint.WireUint8: reflect.TypeOf(uint8(0)), glint.WireUint16: reflect.TypeOf(uint16(0)),
+		glint.WireUint32: reflect.TypeOf(uint32(0)), glint.WireUint64: reflect.TypeOf(uint64(0)),
+		glint.WireFloat32: reflect.TypeOf(float32(0)), glint.WireFloat64: reflect.TypeOf(float64(0)),
+		glint.WireTime: timeType, glint.WireDuration: durationType, glint.WireIP: addrType, glint.WireIPPrefix: prefixType,
+		glint.WireBigInt: bigIntType, glint.WireDecimal: decimalType,
+	}[field.Wire&glint.WireTypeMask]
+	if !ok {
+		return nil, fmt.Errorf("unsupported wire type: %v", field.Wire&glint.WireTypeMask)
+	}
+	return t, nil
+}
+
+// assignJSON sets rv from v, a value decoded from JSON with numbers as json.Number. path names rv in errors.
+func assignJSON(rv reflect.Value, v any, path string) error {
+	mismatch := func(want string) error {
+		got, _ := json.Marshal(v)
+		if path == "" {
+			return fmt.Errorf("expected %s, got %s", want, got)
+		}
+		return fmt.Errorf("%s: expected %s, got %s", path, want, got)
+	}
+	invalid := func(err error) error {
+		if path == "" {
+			return err
+		}
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	if v == nil {
+		rv.SetZero() // null leaves pointers, slices and maps nil, and everything else zero
+		return nil
+	}
+
+	switch rv.Type() {
+	case timeType:
+		s, ok := v.(string)
+		if !ok {
+			return mismatch("an RFC 3339 time")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return invalid(err)
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+
+	case durationType:
+		if s, ok := v.(string); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return invalid(err)
+			}
+			rv.SetInt(int64(d))
+			return nil
+		}
+
+	case addrType:
+		s, ok := v.(string)
+		if !ok {
+			return mismatch("an IP address")
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return invalid(err)
+		}
+		rv.Set(reflect.ValueOf(a))
+		return nil
+
+	case prefixType:
+		s, ok := v.(string)
+		if !ok {
+			return mismatch("an IP prefix")
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return invalid(err)
+		}
+		rv.Set(reflect.ValueOf(p))
+		return nil
+
+	case bigIntType:
+		s, ok := numberString(v)
+		if !ok {
+			return mismatch("an integer")
+		}
+		if _, ok := rv.Addr().Interface().(*big.Int).SetString(s, 10); !ok {
+			return mismatch("an integer")
+		}
+		return nil
+
+	case decimalType:
+		s, ok := numberString(v)
+		if !ok {
+			return mismatch("a decimal")
+		}
+		d, err := glint.ParseDecimal(s)
+		if err != nil {
+			return invalid(err)
+		}
+		rv.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(rv.Type().Elem())
+		if err := assignJSON(p.Elem(), v, path); err != nil {
+			return err
+		}
+		rv.Set(p)
+
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return mismatch("an object")
+		}
+		fields := make(map[string]int, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			name, _, _ := strings.Cut(rv.Type().Field(i).Tag.Get("glint"), ",")
+			fields[name] = i
+		}
+		for _, k := range sortedKeys(m) {
+			i, ok := fields[k]
+			if !ok {
+				return invalid(fmt.Errorf("no field %q in the schema", k))
+			}
+			if err := assignJSON(rv.Field(i), m[k], strings.TrimPrefix(path+"."+k, ".")); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			s, ok := v.(string)
+			if !ok {
+				return mismatch("base64 encoded bytes")
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return invalid(err)
+			}
+			rv.SetBytes(b)
+			return nil
+		}
+		list, ok := v.([]any)
+		if !ok {
+			return mismatch("a list")
+		}
+		rv.Set(reflect.MakeSlice(rv.Type(), len(list), len(list)))
+		for i, e := range list {
+			if err := assignJSON(rv.Index(i), e, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return mismatch("an object")
+		}
+		rv.Set(reflect.MakeMapWithSize(rv.Type(), len(m)))
+		for _, k := range sortedKeys(m) {
+			key := reflect.New(rv.Type().Key()).Elem()
+			if err := assignJSON(key, mapKey(k, key.Kind()), path+"["+k+"]"); err != nil {
+				return err
+			}
+			value := reflect.New(rv.Type().Elem()).Elem()
+			if err := assignJSON(value, m[k], path+"["+k+"]"); err != nil {
+				return err
+			}
+			rv.SetMapIndex(key, value)
+		}
+
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch("true or false")
+		}
+		rv.SetBool(b)
+
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return mismatch("a string")
+		}
+		rv.SetString(s)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch("an integer")
+		}
+		i, err := strconv.ParseInt(n.String(), 10, 64)
+		if err != nil || rv.OverflowInt(i) {
+			return mismatch("an integer that fits " + rv.Type().String())
+		}
+		rv.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch("an unsigned integer")
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		if err != nil || rv.OverflowUint(u) {
+			return mismatch("an unsigned integer that fits " + rv.Type().String())
+		}
+		rv.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch("a number")
+		}
+		f, err := strconv.ParseFloat(n.String(), rv.Type().Bits())
+		if err != nil {
+			return mismatch("a number that fits " + rv.Type().String())
+		}
+		rv.SetFloat(f)
+
+	default:
+		return invalid(fmt.Errorf("unsupported type %s", rv.Type()))
+	}
+	return nil
+}
+
+// numberString returns the digits of a number written either as a JSON number or a string
+func numberString(v any) (string, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String(), true
+	case string:
+		return v, true
+	}
+	return "", false
+}
+
+// mapKey returns a JSON object key as the value it stands for, since keys are always strings in JSON
+func mapKey(k string, kind reflect.Kind) any {
+	switch kind {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(k); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return json.Number(k)
+	}
+	return k
+}
@@ -6,7 +6,9 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,8 +34,11 @@ func NewCommandRegistry() *CommandRegistry {
 	}
 
 	// Register all commands
+	registry.Register(&BenchCmd{})
+	registry.Register(&CatCmd{})
 	registry.Register(&ConvertCmd{})
 	registry.Register(&GenerateCmd{})
+	registry.Register(&GenCmd{})
 	registry.Register(&StatsCmd{})
 	registry.Register(&SchemaCmd{})
 	registry.Register(&CompatCmd{})
@@ -41,6 +46,9 @@ func NewCommandRegistry() *CommandRegistry {
 	registry.Register(&PrintfCmd{})
 	registry.Register(&DebugCmd{})
 	registry.Register(&InspectCmd{})
+	registry.Register(&HeadCmd{})
+	registry.Register(&SampleCmd{})
+	registry.Register(&ValidateCmd{})
 
 	return registry
 }
@@ -149,24 +157,45 @@ Usage:
   glint [command] [flags] [args...]
   glint < document.glint                    # inspect document (default)
 
+Output Formats:
+  cat <file...>                       # print documents, reading stdin when no files are given
+  cat --format json <file...>         # as JSON, for jq
+  cat --format yaml <file...>         # as YAML
+  cat --format table <file...>        # as a table, a row for each document or list element
+  cat --stream [--where path=value]   # print a stream of records from stdin as they arrive
+
 Conversion Commands:
   convert --from json                 # convert JSON to glint
-  convert --to json                   # convert glint to JSON  
+  convert <file> --to glint --schema <file>  # convert JSON to glint with the types of a document or schema file
+  convert <file> --to json            # convert glint to JSON
   convert --to csv                    # convert glint to CSV
 
 Code Generation:
   generate go package.StructName      # generate Go struct from glint
+  gen go --schema <file>              # generate Go struct from a document or schema file
+  gen ts --schema <file>              # generate TypeScript interfaces from a document or schema file
+  gen ts --go <package> --type <struct>  # generate TypeScript interfaces from a Go struct
+  gen ts ... --decoders               # also generate functions decoding documents into them
 
 Analysis Commands:
   stats                              # analyze document structure
+  bench <file.json> [--struct <file>]  # compare size and speed against encoding/json
   schema                             # show document schema only
+  schema show [file]                 # show the schema as Go-like types, with encodings and constraints
+  schema hash [file...]              # schema hash, as sent in the X-Glint-Trust header
+  schema diff <old-file> <new-file>  # field-level differences between two schemas
   compat <old-file>                  # check schema compatibility
+  validate [--schema <file>] <file...>  # check documents are well formed, within limits and match a schema
 
 Data Extraction:
-  get <field-path>                   # extract field (e.g., user.name, items[0])
+  get <path> [file...]               # extract values (e.g., user.name, items[0], items[*].id, tags[env])
   printf "<template>"                # format output with Go template
   printf -f <template-file>          # format using template file
 
+Record Files:
+  head -n 5 <file>                   # first records of a record file or container
+  sample -p 0.01 <file>              # random sample of a record file or container
+
 Debugging:
   debug varint <bytes...>            # decode unsigned varint
   debug zigzag <bytes...>            # decode zigzag-encoded varint  
@@ -175,9 +204,11 @@ Debugging:
 Examples:
   echo '{"name":"SampleUser"}' | glint convert --from json | glint
   glint get user.name < data.glint
+  glint cat data.glint --format json | jq .user
   glint printf "Hello {{.name}}" < data.glint
   glint stats < data.glint
   glint compat old.glint < new.glint
+  glint head -n 5 -print events.glintr
   glint debug varint 172 2
 
 Use 'glint <command> --help' for command-specific help.
@@ -190,36 +221,76 @@ Use 'glint <command> --help' for command-specific help.
 
 // ConvertCmd handles format conversion
 type ConvertCmd struct {
-	from string
-	to   string
+	from   string
+	to     string
+	schema string
+	flags  *flag.FlagSet
 }
 
 func (c *ConvertCmd) Name() string { return "convert" }
 
 func (c *ConvertCmd) DefineFlags(fs *flag.FlagSet) {
-	fs.StringVar(&c.from, "from", "", "Convert from format (json)")
-	fs.StringVar(&c.to, "to", "", "Convert to format (json, csv)")
+	fs.StringVar(&c.from, "from", "", "Convert from format (json, glint)")
+	fs.StringVar(&c.to, "to", "", "Convert to format (glint, json, csv)")
+	fs.StringVar(&c.schema, "schema", "", "Document or schema file the JSON is written to, instead of inferring types")
+	c.flags = fs
 }
 
 func (c *ConvertCmd) Execute(args []string) error {
-	if c.from != "" && c.to != "" {
-		return fmt.Errorf("cannot specify both --from and --to")
+	// flags may follow the input as well, as in glint convert input.json --to glint
+	files, err := interspersedArgs(c.flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) > 1 {
+		return fmt.Errorf("convert takes a single input file, got %d", len(files))
 	}
 
 	if c.from == "" && c.to == "" {
 		return fmt.Errorf("must specify either --from or --to")
 	}
 
-	if c.from == "json" {
-		return convertJSONToGlint()
+	// JSON is converted to glint and glint to anything else, so either flag gives the direction
+	to := c.to
+	switch {
+	case c.from == "json" && (to == "" || to == "glint"):
+		to = "glint"
+	case c.from == "glint" && to != "" && to != "glint":
+	case c.from == "" && to != "":
+	default:
+		return fmt.Errorf("unsupported conversion: from=%s to=%s", c.from, c.to)
+	}
+	if c.schema != "" && to != "glint" {
+		return fmt.Errorf("--schema applies only to conversions to glint")
 	}
 
-	if c.to == "json" {
-		return convertGlintToJSON()
+	var input []byte
+	if len(files) == 0 || files[0] == "-" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(files[0])
+	}
+	if err != nil {
+		return fmt.Errorf("error reading input: %v", err)
 	}
 
-	if c.to == "csv" {
-		return convertGlintToCSV()
+	switch to {
+	case "glint":
+		var schema *glint.Schema
+		if c.schema != "" {
+			data, err := os.ReadFile(c.schema)
+			if err != nil {
+				return fmt.Errorf("error reading schema: %v", err)
+			}
+			if schema, err = loadSchema(data); err != nil {
+				return fmt.Errorf("error reading schema %s: %v", c.schema, err)
+			}
+		}
+		return convertJSONToGlint(input, schema)
+	case "json":
+		return convertGlintToJSON(input)
+	case "csv":
+		return convertGlintToCSV(input)
 	}
 
 	return fmt.Errorf("unsupported conversion: from=%s to=%s", c.from, c.to)
@@ -301,16 +372,28 @@ func (g *GetCmd) DefineFlags(fs *flag.FlagSet) {
 }
 
 func (g *GetCmd) Execute(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("usage: pt get <field-path>\n  pt get user.name\n  pt get items[0].id")
+	if len(args) < 1 {
+		return fmt.Errorf("usage: glint get <path> [file...]\n  glint get user.name data.glint\n  glint get 'users[*].address.city' < data.glint")
 	}
 
-	input, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		return fmt.Errorf("error reading input: %v", err)
+	if len(args) == 1 {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading input: %v", err)
+		}
+		return extractField(input, args[0])
 	}
 
-	return extractField(input, args[0])
+	for _, file := range args[1:] {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading input: %v", err)
+		}
+		if err := extractField(input, args[0]); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
 }
 
 // PrintfCmd handles template formatting
@@ -397,6 +480,89 @@ func (g *GenerateCmd) generateGoStruct(packageStruct string) error {
 	return generateGoStructFromDocument(input, packageName, structName)
 }
 
+// GenCmd generates code from the schema of a document, or from bare schema bytes, read from a file
+type GenCmd struct{}
+
+func (g *GenCmd) Name() string { return "gen" }
+
+func (g *GenCmd) DefineFlags(fs *flag.FlagSet) {
+	// No flags - each language defines its own after the language name
+}
+
+func (g *GenCmd) Execute(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: glint gen <go|ts> --schema <file> [flags]")
+	}
+
+	fs := flag.NewFlagSet("glint gen "+args[0], flag.ContinueOnError)
+	schemaFile := fs.String("schema", "", "document or schema file to generate from, - for stdin")
+	typeName := fs.String("type", "", "name of the generated type, defaults to one taken from the schema file name")
+
+	switch args[0] {
+	case "go":
+		packageName := fs.String("package", "main", "package name of the generated file")
+		input, err := g.readSchema(fs, args[1:], schemaFile, nil, typeName)
+		if err != nil {
+			return err
+		}
+		return generateGoStructFromDocument(input, *packageName, *typeName)
+	case "ts":
+		goPackage := fs.String("go", "", "package declaring the Go struct named by --type, to generate from instead of --schema")
+		decoders := fs.Bool("decoders", false, "also generate a function decoding documents into the interfaces")
+		input, err := g.readSchema(fs, args[1:], schemaFile, goPackage, typeName)
+		if err != nil {
+			return err
+		}
+		result, err := GenerateTypeScript(input, *typeName, *decoders)
+		if err != nil {
+			return err
+		}
+		fmt.Print(result)
+		return nil
+	default:
+		return fmt.Errorf("unsupported generation type: %s", args[0])
+	}
+}
+
+// readSchema parses the language's flags and reads the schema file they name, defaulting the type name. When
+// goPackage is set it reads the schema of the Go struct named by the type name from that package instead.
+func (g *GenCmd) readSchema(fs *flag.FlagSet, args []string, schemaFile, goPackage, typeName *string) ([]byte, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if goPackage != nil && *goPackage != "" {
+		if *schemaFile != "" || *typeName == "" || fs.NArg() > 0 {
+			return nil, fmt.Errorf("usage: %s --go <package> --type <struct> [flags]", fs.Name())
+		}
+		return goTypeSchema(*goPackage, *typeName)
+	}
+	if *schemaFile == "" || fs.NArg() > 0 {
+		return nil, fmt.Errorf("usage: %s --schema <file> [flags]", fs.Name())
+	}
+
+	var input []byte
+	var err error
+	if *schemaFile == "-" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(*schemaFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema: %v", err)
+	}
+
+	if *typeName == "" {
+		*typeName = "Document"
+		if *schemaFile != "-" {
+			base := filepath.Base(*schemaFile)
+			if name := toGoFieldName(strings.TrimSuffix(base, filepath.Ext(base))); name != "" {
+				*typeName = name
+			}
+		}
+	}
+	return input, nil
+}
+
 // InspectCmd handles the default document inspection
 type InspectCmd struct{}
 
@@ -427,6 +593,13 @@ func (s *SchemaCmd) DefineFlags(fs *flag.FlagSet) {
 }
 
 func (s *SchemaCmd) Execute(args []string) error {
+	if ok, err := schemaSubcommand(os.Stdout, args); ok {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unknown schema command %q, expected show, hash or diff", args[0])
+	}
+
 	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		return fmt.Errorf("error reading input: %v", err)
@@ -489,8 +662,16 @@ func (c *CompatCmd) Execute(args []string) error {
 
 // inspectDocument is the default behavior when no command is specified
 func inspectDocument(input []byte) error {
-	// Print a human-readable representation of the glint document
-	glint.Print(input)
+	if len(input) == 0 {
+		return fmt.Errorf("no document to inspect, pipe one in or name a file")
+	}
+
+	// Print a human-readable representation of the glint document, SPrint returning malformed ones as an error
+	s, err := glint.SPrint(input)
+	if err != nil {
+		return fmt.Errorf("error reading document: %v", err)
+	}
+	fmt.Println(s)
 	return nil
 }
 
@@ -531,7 +712,10 @@ func printSchema(input []byte) error {
 
 	// Parse the document to extract just the schema
 	reader := glint.NewReader(input)
-	doc := glint.NewPrinterDocument(&reader)
+	doc, err := glint.NewPrinterDocument(&reader)
+	if err != nil {
+		return err
+	}
 	schema := glint.NewPrinterSchema(&doc.Schema)
 
 	fmt.Printf("Glint Schema\n")
@@ -549,7 +733,11 @@ func analyzeDocument(doc []byte) {
 
 	// Parse document structure
 	reader := glint.NewReader(doc)
-	printerDoc := glint.NewPrinterDocument(&reader)
+	printerDoc, err := glint.NewPrinterDocument(&reader)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 	schema := glint.NewPrinterSchema(&printerDoc.Schema)
 
 	// Calculate size breakdown
@@ -608,12 +796,16 @@ func formatWireTypes(wireTypes map[string]int) string {
 // CONVERSION FUNCTIONS
 // ===============================
 
-// convertJSONToGlint reads JSON from stdin and converts it to glint format
-func convertJSONToGlint() error {
-	// Read JSON input from stdin
-	input, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		return fmt.Errorf("error reading JSON input: %v", err)
+// convertJSONToGlint converts JSON to glint format, writing it to stdout. The JSON is written to the types of
+// schema when there is one, and to types inferred from the JSON when there isn't.
+func convertJSONToGlint(input []byte, schema *glint.Schema) error {
+	if schema != nil {
+		glintData, err := jsonToSchema(input, schema)
+		if err != nil {
+			return fmt.Errorf("error converting to glint: %v", err)
+		}
+		os.Stdout.Write(glintData)
+		return nil
 	}
 
 	// Parse JSON into generic interface{}
@@ -633,22 +825,16 @@ func convertJSONToGlint() error {
 	return nil
 }
 
-// convertGlintToJSON reads glint data from stdin and converts it to JSON format
-func convertGlintToJSON() error {
-	// Read glint input from stdin
-	input, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		return fmt.Errorf("error reading glint input: %v", err)
-	}
-
-	// Create template processor to convert glint to map[string]interface{}
-	tmpl, err := NewTemplate(input)
+// convertGlintToJSON converts a glint document to JSON format, writing it to stdout
+func convertGlintToJSON(input []byte) error {
+	// documentJSON gives the same JSON as cat, which convert --to glint --schema reads back
+	data, err := documentJSON(input)
 	if err != nil {
 		return fmt.Errorf("error parsing glint document: %v", err)
 	}
 
-	// Convert the template data to JSON
-	jsonData, err := json.MarshalIndent(tmpl.data, "", "  ")
+	// Convert the document data to JSON
+	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error converting to JSON: %v", err)
 	}
@@ -659,14 +845,8 @@ func convertGlintToJSON() error {
 	return nil
 }
 
-// convertGlintToCSV reads glint data from stdin and converts it to CSV format
-func convertGlintToCSV() error {
-	// Read glint input from stdin
-	input, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		return fmt.Errorf("error reading glint input: %v", err)
-	}
-
+// convertGlintToCSV converts a glint document to CSV format, writing it to stdout
+func convertGlintToCSV(input []byte) error {
 	// Create template processor to convert glint to map[string]interface{}
 	tmpl, err := NewTemplate(input)
 	if err != nil {
@@ -956,6 +1136,14 @@ func addArrayToBuilder(builder *glint.DocumentBuilder, name string, arr []interf
 		return nil
 	}
 
+	// Arrays of scalars of more than one type, such as [1, "a", true], keep the type of every element
+	if mixed, ok := mixedArray(arr); ok {
+		slice := &glint.SliceBuilder{}
+		slice.AppendDynamicSlice(mixed)
+		builder.AppendSlice(name, *slice)
+		return nil
+	}
+
 	// Determine the array type from the first element
 	firstElem := arr[0]
 
@@ -1095,6 +1283,36 @@ func addArrayToBuilder(builder *glint.DocumentBuilder, name string, arr []interf
 	return nil
 }
 
+// mixedArray returns the elements of a JSON array for AppendDynamicSlice when they are strings, booleans, numbers
+// and nulls of more than one of those types. Whole numbers are returned as ints, as they are in number arrays, when
+// they fit one.
+func mixedArray(arr []interface{}) ([]any, bool) {
+	kinds := map[string]bool{}
+	mixed := make([]any, len(arr))
+	for i, elem := range arr {
+		switch v := elem.(type) {
+		case string:
+			kinds["string"] = true
+			mixed[i] = v
+		case bool:
+			kinds["bool"] = true
+			mixed[i] = v
+		case float64:
+			kinds["number"] = true
+			if v == math.Trunc(v) && v >= -1<<63 && v < 1<<63 { // larger numbers don't fit an int, so stay floats
+				mixed[i] = int(v)
+			} else {
+				mixed[i] = v
+			}
+		case nil:
+			kinds["null"] = true
+		default:
+			return nil, false
+		}
+	}
+	return mixed, len(kinds) > 1
+}
+
 // ===============================
 // UTILITY FUNCTION WRAPPERS
 // ===============================
@@ -1113,25 +1331,9 @@ func generateGoStructFromDocument(input []byte, packageName, structName string)
 	return nil
 }
 
+// extractField writes the values the path leads to in doc, see query.go
 func extractField(doc []byte, fieldPath string) error {
-	// Convert field path to template syntax
-	templateStr, err := fieldPathToTemplate(fieldPath)
-	if err != nil {
-		return fmt.Errorf("invalid field path: %s", fieldPath)
-	}
-
-	// Use template system to extract the field
-	tmpl, err := NewTemplate(doc)
-	if err != nil {
-		return fmt.Errorf("error parsing document: %v", err)
-	}
-
-	err = tmpl.Execute(templateStr)
-	if err != nil {
-		return fmt.Errorf("error extracting field: %v", err)
-	}
-
-	return nil
+	return queryDocument(os.Stdout, doc, fieldPath)
 }
 
 func executeTemplate(doc []byte, templateStr, templateFile string) error {
@@ -1159,7 +1361,10 @@ func checkSchemaCompatibility(oldData, newData []byte) error {
 		return fmt.Errorf("old document too short")
 	}
 	oldReader := glint.NewReader(oldData)
-	oldDoc := glint.NewPrinterDocument(&oldReader)
+	oldDoc, err := glint.NewPrinterDocument(&oldReader)
+	if err != nil {
+		return fmt.Errorf("old document: %w", err)
+	}
 	oldSchema := glint.NewPrinterSchema(&oldDoc.Schema)
 
 	// Parse new document
@@ -1167,7 +1372,10 @@ func checkSchemaCompatibility(oldData, newData []byte) error {
 		return fmt.Errorf("new document too short")
 	}
 	newReader := glint.NewReader(newData)
-	newDoc := glint.NewPrinterDocument(&newReader)
+	newDoc, err := glint.NewPrinterDocument(&newReader)
+	if err != nil {
+		return fmt.Errorf("new document: %w", err)
+	}
 	newSchema := glint.NewPrinterSchema(&newDoc.Schema)
 
 	// Compare schemas
@@ -1211,7 +1419,7 @@ type CompatibilityResult struct {
 
 // SchemaChange represents a single change between schemas
 type SchemaChange struct {
-	Type      string // "added", "removed", "type_changed"
+	Type      string // "added", "removed", "type_changed", "reordered"
 	FieldPath string
 	OldType   string
 	NewType   string
@@ -1224,7 +1432,7 @@ func (c SchemaChange) Icon() string {
 		return "+"
 	case "removed":
 		return "-"
-	case "type_changed":
+	case "type_changed", "reordered":
 		return "~"
 	default:
 		return "?"
@@ -1239,6 +1447,11 @@ func (c SchemaChange) Description() string {
 		return fmt.Sprintf("Removed field '%s' (%s)", c.FieldPath, c.OldType)
 	case "type_changed":
 		return fmt.Sprintf("Changed field '%s' from %s to %s", c.FieldPath, c.OldType, c.NewType)
+	case "reordered":
+		if c.FieldPath == "" {
+			return fmt.Sprintf("Reordered fields from %s to %s", c.OldType, c.NewType)
+		}
+		return fmt.Sprintf("Reordered fields of '%s' from %s to %s", c.FieldPath, c.OldType, c.NewType)
 	default:
 		return fmt.Sprintf("Unknown change: %s", c.FieldPath)
 	}
@@ -3,7 +3,16 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -335,7 +344,10 @@ func TestCLIDocumentParsingWithMaps(t *testing.T) {
 
 	// Test that we can create a reader and parse the document with maps
 	r := glint.NewReader(doc)
-	printerDoc := glint.NewPrinterDocument(&r)
+	printerDoc, err := glint.NewPrinterDocument(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
 	schema := glint.NewPrinterSchema(&printerDoc.Schema)
 
 	// Find the nums1 field (which is a map)
@@ -445,44 +457,33 @@ func TestCLIFieldExtractionAllTypes(t *testing.T) {
 	}
 }
 
-// TestNestedStructFieldExtraction is commented out because struct field skipping is not implemented
-// This test will be re-enabled once struct field skipping is implemented
-// func TestNestedStructFieldExtraction(t *testing.T) {
-// 	doc := createNestedStructDocument()
-// 	
-// 	tests := []struct {
-// 		name     string
-// 		field    string
-// 		expected string
-// 	}{
-// 		// Map access in nested struct works
-// 		{name: "Map in nested struct", field: "data[env]", expected: "production"},
-// 		{name: "Map region", field: "data[region]", expected: "us-west-2"},
-// 		{name: "Map cluster", field: "data[cluster]", expected: "main"},
-// 	}
-// 	
-// 	for _, tt := range tests {
-// 		t.Run(tt.name, func(t *testing.T) {
-// 			r := glint.NewReader(doc)
-// 			printerDoc := glint.NewPrinterDocument(&r)
-// 			schema := glint.NewPrinterSchema(&printerDoc.Schema)
-// 			
-// 			path := parseFieldPath(tt.field)
-// 			if len(path) == 0 {
-// 				t.Fatalf("Invalid field path: %s", tt.field)
-// 			}
-// 			
-// 			value, err := extractFieldValue(&printerDoc.Body, &schema, path)
-// 			if err != nil {
-// 				t.Fatalf("Error extracting field '%s': %v", tt.field, err)
-// 			}
-// 			
-// 			if value != tt.expected {
-// 				t.Errorf("Expected '%s', got '%s'", tt.expected, value)
-// 			}
-// 		})
-// 	}
-// }
+func TestCLINestedStructFieldExtraction(t *testing.T) {
+	doc := createNestedStructDocument()
+
+	tests := []struct {
+		name     string
+		field    string
+		expected string
+	}{
+		{name: "Map in nested struct", field: "data[env]", expected: "staging"},
+		{name: "Map region", field: "data[region]", expected: "us-east-1"},
+		{name: "Map cluster", field: "data.cluster", expected: "primary"},
+		{name: "Field after a struct", field: "sys1.arc1", expected: "x86_64"},
+		{name: "Struct field", field: "user.email", expected: "test@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := queryDocument(&out, doc, tt.field); err != nil {
+				t.Fatalf("Error extracting field '%s': %v", tt.field, err)
+			}
+			if value := strings.TrimSpace(out.String()); value != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, value)
+			}
+		})
+	}
+}
 
 // Test comprehensive template functionality
 func TestCLITemplateComplexFunctionality(t *testing.T) {
@@ -667,7 +668,10 @@ func TestCLIDocumentSchemaValidation(t *testing.T) {
 	doc := createComprehensiveTestDocument()
 	
 	r := glint.NewReader(doc)
-	printerDoc := glint.NewPrinterDocument(&r)
+	printerDoc, err := glint.NewPrinterDocument(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
 	schema := glint.NewPrinterSchema(&printerDoc.Schema)
 
 	// Test that we can at least access the document schema without panicking
@@ -677,7 +681,9 @@ func TestCLIDocumentSchemaValidation(t *testing.T) {
 	
 	// Test that we can create a reader and printer document
 	r2 := glint.NewReader(doc)
-	_ = glint.NewPrinterDocument(&r2)
+	if _, err := glint.NewPrinterDocument(&r2); err != nil {
+		t.Fatal(err)
+	}
 	if len(doc) == 0 {
 		t.Error("Expected document to have data")
 	}
@@ -688,7 +694,10 @@ func TestCLINestedDocumentParsing(t *testing.T) {
 	// Test parsing nested test document
 	doc1 := createNestedTestDocument()
 	r1 := glint.NewReader(doc1)
-	printerDoc1 := glint.NewPrinterDocument(&r1)
+	printerDoc1, err := glint.NewPrinterDocument(&r1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	schema1 := glint.NewPrinterSchema(&printerDoc1.Schema)
 	
 	// Verify we can read the schema
@@ -718,7 +727,10 @@ func TestCLINestedDocumentParsing(t *testing.T) {
 	// Test parsing nested struct document
 	doc2 := createNestedStructDocument()
 	r2 := glint.NewReader(doc2)
-	printerDoc2 := glint.NewPrinterDocument(&r2)
+	printerDoc2, err := glint.NewPrinterDocument(&r2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	schema2 := glint.NewPrinterSchema(&printerDoc2.Schema)
 	
 	// Verify we can read the schema
@@ -1381,8 +1393,14 @@ func TestCLIBinaryFormatPreservation(t *testing.T) {
 			originalReader := glint.NewReader(originalData)
 			roundTripReader := glint.NewReader(roundTripData)
 			
-			originalDoc := glint.NewPrinterDocument(&originalReader)
-			roundTripDoc := glint.NewPrinterDocument(&roundTripReader)
+			originalDoc, err := glint.NewPrinterDocument(&originalReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roundTripDoc, err := glint.NewPrinterDocument(&roundTripReader)
+			if err != nil {
+				t.Fatal(err)
+			}
 			
 			// Create schemas from the document schema readers
 			originalSchema := glint.NewPrinterSchema(&originalDoc.Schema)
@@ -1568,4 +1586,738 @@ Config: {{range $k, $v := .config}}{{$k}}={{$v}} {{end}}`
 			b.Fatalf("Error executing template: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestCLIHeadAndSample(t *testing.T) {
+	type row struct {
+		ID int `glint:"id"`
+	}
+
+	var file bytes.Buffer
+	w := glint.NewRecordWriter(&file)
+	enc := glint.NewEncoder[row]()
+	for i := 0; i < 100; i++ {
+		b := &glint.Buffer{}
+		enc.Marshal(&row{ID: i}, b)
+		if err := w.WriteRecord(b.Bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(records []byte) []int {
+		var out []int
+		r := glint.NewRecordReader(bytes.NewReader(records))
+		dec := glint.NewDecoder[row]()
+		for r.Next() {
+			var v row
+			if err := dec.Unmarshal(r.Record(), &v); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, v.ID)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("Head", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := headRecords(bytes.NewReader(file.Bytes()), &out, 3, false); err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(out.Bytes()); len(got) != 3 || got[0] != 0 || got[2] != 2 {
+			t.Errorf("expected the first 3 records, got %v", got)
+		}
+
+		out.Reset()
+		if err := headRecords(bytes.NewReader(file.Bytes()), &out, 1000, false); err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(out.Bytes()); len(got) != 100 {
+			t.Errorf("expected every record, got %d", len(got))
+		}
+
+		out.Reset()
+		if err := headRecords(bytes.NewReader(file.Bytes()), &out, 2, true); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(out.String(), "id") != 4 { // in the schema and values of each
+			t.Errorf("expected 2 printed documents, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Sample", func(t *testing.T) {
+		sample := func(p float64, seed int64) []int {
+			var out bytes.Buffer
+			if err := sampleRecords(bytes.NewReader(file.Bytes()), &out, p, rand.New(rand.NewSource(seed)), false); err != nil {
+				t.Fatal(err)
+			}
+			return ids(out.Bytes())
+		}
+
+		if got := sample(1, 1); len(got) != 100 {
+			t.Errorf("expected every record with p=1, got %d", len(got))
+		}
+		if got := sample(0, 1); len(got) != 0 {
+			t.Errorf("expected no records with p=0, got %d", len(got))
+		}
+
+		a, b := sample(0.3, 7), sample(0.3, 7)
+		if len(a) == 0 || len(a) == 100 || len(a) != len(b) {
+			t.Errorf("expected a repeatable partial sample, got %d and %d records", len(a), len(b))
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := headRecords(bytes.NewReader(file.Bytes()[:file.Len()-1]), &out, 1000, false); err == nil {
+			t.Error("expected an error for a truncated file")
+		}
+	})
+
+	t.Run("Container", func(t *testing.T) {
+		var container bytes.Buffer
+		cw := glint.NewContainerWriter(&container)
+		for i := 0; i < 100; i++ {
+			if err := cw.Write(enc.MarshalBytes(&row{ID: i})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "rows.glintc")
+		if err := os.WriteFile(path, container.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// a file holding a container is read through its index, rather than as records
+		var out bytes.Buffer
+		err := withRecordInput([]string{path}, func(io.Reader) error {
+			return errors.New("expected the container to be read through its index")
+		}, func(c *glint.Container) error {
+			return headContainer(c, &out, 3, false)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(out.Bytes()); len(got) != 3 || got[0] != 0 || got[2] != 2 {
+			t.Errorf("expected the first 3 documents, got %v", got)
+		}
+
+		c, err := glint.OpenContainer(bytes.NewReader(container.Bytes()), int64(container.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sample := func(p float64, seed int64) []int {
+			var out bytes.Buffer
+			if err := sampleContainer(c, &out, p, rand.New(rand.NewSource(seed)), false); err != nil {
+				t.Fatal(err)
+			}
+			return ids(out.Bytes())
+		}
+		if got := sample(1, 1); len(got) != 100 {
+			t.Errorf("expected every document with p=1, got %d", len(got))
+		}
+		if a, b := sample(0.3, 7), sample(0.3, 7); len(a) == 0 || len(a) == 100 || !reflect.DeepEqual(a, b) {
+			t.Errorf("expected a repeatable partial sample, got %v and %v", a, b)
+		}
+
+		// record files are still streamed
+		recordsPath := filepath.Join(t.TempDir(), "rows.glintr")
+		if err := os.WriteFile(recordsPath, file.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		out.Reset()
+		err = withRecordInput([]string{recordsPath}, func(r io.Reader) error {
+			return headRecords(r, &out, 2, false)
+		}, func(*glint.Container) error {
+			return errors.New("expected a record file to be streamed")
+		})
+		if err != nil || len(ids(out.Bytes())) != 2 {
+			t.Errorf("expected 2 records from the record file, got %v", err)
+		}
+	})
+}
+
+func TestCLICat(t *testing.T) {
+	type item struct {
+		SKU string `glint:"sku"`
+		Qty int    `glint:"qty"`
+	}
+	type order struct {
+		ID    uint64            `glint:"id"`
+		Note  string            `glint:"note"`
+		Items []item            `glint:"items"`
+		Tags  map[string]string `glint:"tags"`
+		Empty []int             `glint:"empty"`
+	}
+
+	doc := glint.NewEncoder[order]().MarshalBytes(&order{
+		ID: 18446744073709551615, Note: "yes", Items: []item{{SKU: "a-1", Qty: 2}, {SKU: "b: 2", Qty: 1}},
+		Tags: map[string]string{"env": "prod"}, Empty: []int{},
+	})
+
+	cat := func(format string, docs ...[]byte) string {
+		var out bytes.Buffer
+		if err := catDocuments(&out, docs, format); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		var v map[string]interface{}
+		d := json.NewDecoder(strings.NewReader(cat("json", doc)))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v["id"] != json.Number("18446744073709551615") {
+			t.Errorf("expected the id without any loss of precision, got %v", v["id"])
+		}
+	})
+
+	t.Run("YAML", func(t *testing.T) {
+		want := `empty: []
+id: 18446744073709551615
+items:
+- qty: 2
+  sku: a-1
+- qty: 1
+  sku: "b: 2"
+note: "yes"
+tags:
+  env: prod
+`
+		if got := cat("yaml", doc); got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+		if got := cat("yaml", doc, doc); strings.Count(got, "---\n") != 2 {
+			t.Errorf("expected each document to start with ---, got\n%s", got)
+		}
+	})
+
+	t.Run("Table", func(t *testing.T) {
+		want := "FIELD     VALUE\nempty     []\nid        18446744073709551615\nitems     [{2 fields}, {2 fields}]\nnote      yes\ntags.env  prod\n"
+		if got := cat("table", doc); got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+
+		list := glint.NewEncoder[struct {
+			Items []item `glint:"items"`
+		}]().MarshalBytes(&struct {
+			Items []item `glint:"items"`
+		}{Items: []item{{SKU: "a-1", Qty: 2}, {SKU: "b-2", Qty: 10}}})
+		want = "QTY  SKU\n2    a-1\n10   b-2\n"
+		if got := cat("table", list); got != want {
+			t.Errorf("expected a row for each item, got\n%s\nwant\n%s", got, want)
+		}
+
+		if got := cat("table", doc, doc); strings.Count(got, "18446744073709551615") != 2 {
+			t.Errorf("expected a row for each document, got\n%s", got)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if err := catDocuments(io.Discard, [][]byte{doc}, "xml"); err == nil {
+			t.Error("expected an error for an unsupported format")
+		}
+		if err := catDocuments(io.Discard, [][]byte{doc[:3]}, "json"); err == nil {
+			t.Error("expected an error for a malformed document")
+		}
+	})
+
+	t.Run("Arguments", func(t *testing.T) {
+		c := &CatCmd{}
+		fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+		c.DefineFlags(fs)
+
+		files, err := interspersedArgs(fs, []string{"a.glint", "--format", "json", "b.glint", "--", "--format"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.format != "json" || strings.Join(files, " ") != "a.glint b.glint --format" {
+			t.Errorf("got format %q and files %q", c.format, files)
+		}
+	})
+}
+
+func TestCLIQuery(t *testing.T) {
+	type address struct {
+		City string `glint:"city"`
+	}
+	type user struct {
+		Name    string   `glint:"name"`
+		Address *address `glint:"address"`
+	}
+	type doc struct {
+		Users  []user           `glint:"users"`
+		Counts map[int]int      `glint:"counts"`
+		Tags   map[string]string `glint:"tags"`
+	}
+
+	b := glint.NewEncoder[doc]().MarshalBytes(&doc{
+		Users: []user{
+			{Name: "ann", Address: &address{City: "Leeds"}},
+			{Name: "bob"},
+			{Name: "cat", Address: &address{City: "York"}},
+			{Name: "dan", Address: &address{City: "Hull"}},
+		},
+		Counts: map[int]int{3: 30, 7: 70},
+		Tags:   map[string]string{"a.b": "dotted", "x": "y"},
+	})
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "users[3].address.city", expected: "Hull"},
+		{query: "users[-1].name", expected: "dan"},
+		{query: ".users[0].name", expected: "ann"},
+		{query: "users[*].address.city", expected: "Leeds\nYork\nHull"}, // bob has no address
+		{query: "users[1].address", expected: "null"},
+		{query: "counts[3]", expected: "30"}, // a map key, not an index
+		{query: "counts.*", expected: "30\n70"},
+		{query: `tags["a.b"]`, expected: "dotted"},
+		{query: "tags[*]", expected: "dotted\ny"},
+		{query: "users[0]", expected: `{"address":{"city":"Leeds"},"name":"ann"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := queryDocument(&out, b, tt.query); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(out.String(), "\n"); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	for _, query := range []string{"", "users[", "users[]", "users..name", "users.name", "users[4]", "users[x]", "nope", "users[1].address.city", `tags["a]`, "users[0]name"} {
+		if err := queryDocument(io.Discard, b, query); err == nil {
+			t.Errorf("expected an error for %q", query)
+		}
+	}
+}
+
+func TestCLIConvertSchema(t *testing.T) {
+	type part struct {
+		Name  string  `glint:"name"`
+		Count *uint16 `glint:"count"`
+	}
+	type record struct {
+		ID      int64           `glint:"id"`
+		Scores  []int32         `glint:"scores,delta"`
+		Offsets []int           `glint:"offsets,zigzag"`
+		Labels  []string        `glint:"labels,dict"`
+		Ratio   float32         `glint:"ratio"`
+		Payload []byte          `glint:"payload"`
+		Created time.Time       `glint:"created"`
+		Timeout time.Duration   `glint:"timeout"`
+		Addr    netip.Addr      `glint:"addr"`
+		Network netip.Prefix    `glint:"network"`
+		Total   big.Int         `glint:"total"`
+		Price   glint.Decimal   `glint:"price"`
+		Parts   []part          `glint:"parts"`
+		Owner   *part           `glint:"owner"`
+		ByID    map[uint32]part `glint:"by_id"`
+		Nested  [][]string      `glint:"nested"`
+		Missing []int           `glint:"missing"`
+		Enabled bool            `glint:"enabled"`
+		Age     uint8           `glint:"age,min=1,max=150"`
+	}
+
+	seven := uint16(7)
+	price, _ := glint.ParseDecimal("12.50")
+	v := record{
+		ID: -3, Scores: []int32{10, 12, 15}, Offsets: []int{-1, 1}, Labels: []string{"a", "b", "a"}, Ratio: 0.25,
+		Payload: []byte{0, 1, 2}, Created: time.Date(2024, 1, 2, 3, 4, 5, 500, time.UTC), Timeout: 90 * time.Second,
+		Addr: netip.MustParseAddr("10.0.0.1"), Network: netip.MustParsePrefix("10.0.0.0/8"), Price: price,
+		Parts: []part{{Name: "bolt", Count: &seven}, {Name: "nut"}}, ByID: map[uint32]part{4: {Name: "washer"}},
+		Nested: [][]string{{"x"}, {}}, Enabled: true, Age: 42,
+	}
+	v.Total.SetString("123456789012345678901234567890", 10)
+	doc := glint.NewEncoder[record]().MarshalBytes(&v)
+
+	schema, err := glint.ParseSchema(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		data, err := documentJSON(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := jsonToSchema(j, schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, doc) {
+			t.Errorf("expected the document to convert to JSON and back unchanged\n%s", j)
+		}
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		got, err := jsonToSchema([]byte(`{"id": 9, "timeout": "1m30s", "total": "5", "price": 1.5, "by_id": {"2": {"name": "x"}}}`), schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r record
+		if err := glint.NewDecoder[record]().Unmarshal(got, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.ID != 9 || r.Timeout != 90*time.Second || r.Total.Int64() != 5 || r.Price.String() != "1.5" || r.ByID[2].Name != "x" {
+			t.Errorf("unexpected values %+v", r)
+		}
+		if len(r.Parts) != 0 || r.Owner != nil {
+			t.Errorf("expected fields left out of the JSON to be zero, got %+v", r)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for input, want := range map[string]string{
+			`{"nope": 1}`:              `no field "nope" in the schema`,
+			`{"id": "one"}`:            `id: expected an integer`,
+			`{"age": 300}`:             `age: expected an unsigned integer that fits uint8`,
+			`{"parts": [{"name": 1}]}`: `parts[0].name: expected a string`,
+			`{"by_id": {"four": {}}}`:  `by_id[four]: expected an unsigned integer`,
+			`{"created": "yesterday"}`: `created:`,
+			`[1, 2]`:                   `expected an object`,
+			`{"id": 1} {"id": 2}`:      `more than one value`,
+		} {
+			_, err := jsonToSchema([]byte(input), schema)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected an error containing %q, got %v", input, want, err)
+			}
+		}
+	})
+}
+
+func TestCLISchema(t *testing.T) {
+	type itemV1 struct {
+		SKU string `glint:"sku"`
+		Qty int32  `glint:"qty"`
+	}
+	type itemV2 struct {
+		SKU string `glint:"sku"`
+		Qty int64  `glint:"qty"`
+	}
+	type orderV1 struct {
+		ID      uint64            `glint:"id"`
+		Address string            `glint:"address"`
+		Items   []itemV1          `glint:"items"`
+		Totals  []int             `glint:"totals"`
+		ByName  map[string]itemV1 `glint:"by_name"`
+	}
+	type orderV2 struct {
+		Items  []itemV2          `glint:"items"`
+		ID     uint64            `glint:"id"`
+		Email  string            `glint:"email"`
+		Totals []int             `glint:"totals,delta"`
+		ByName map[string]itemV2 `glint:"by_name"`
+	}
+	v1 := glint.NewEncoder[orderV1]().MarshalBytes(&orderV1{})
+	v2 := glint.NewEncoder[orderV2]().MarshalBytes(&orderV2{})
+
+	schema := func(args ...string) string {
+		dir := t.TempDir()
+		for i, arg := range args {
+			for name, doc := range map[string][]byte{"v1.glint": v1, "v2.glint": v2} {
+				if arg == name {
+					args[i] = dir + "/" + name
+					if err := os.WriteFile(args[i], doc, 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		}
+
+		var out bytes.Buffer
+		if ok, err := schemaSubcommand(&out, args); !ok || err != nil {
+			t.Fatalf("schema %v: %v, %v", args, ok, err)
+		}
+		return strings.ReplaceAll(out.String(), dir+"/", "")
+	}
+
+	t.Run("Show", func(t *testing.T) {
+		want := fmt.Sprintf("hash %d\nitems []struct {\n\tsku string\n\tqty int64\n}\nid uint64\nemail string\ntotals []int delta\nby_name map[string]struct {\n\tsku string\n\tqty int64\n}\n", glint.Document(v2).Hash())
+		if got := schema("show", "v2.glint"); got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		want := fmt.Sprintf("%d  v1.glint\n%d  v2.glint\n", glint.Document(v1).Hash(), glint.Document(v2).Hash())
+		if got := schema("hash", "v1.glint", "v2.glint"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		bare := glint.NewEncoder[orderV1]().Schema().Bytes
+		if hash, err := schemaHash(bare); err != nil || hash != glint.Document(v1).Hash() {
+			t.Errorf("expected a bare schema to hash as its documents do, got %d, %v", hash, err)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		want := fmt.Sprintf(`--- v1.glint  hash %d
++++ v2.glint  hash %d
+- address string
+~ items[*].qty int32 → int64
+~ totals []int → []int delta
+~ by_name[*].qty int32 → int64
++ email string
+~ fields reordered: id, items, totals, by_name → items, id, totals, by_name
+`, glint.Document(v1).Hash(), glint.Document(v2).Hash())
+		if got := schema("diff", "v1.glint", "v2.glint"); got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+		if got := schema("diff", "v1.glint", "v1.glint"); !strings.HasSuffix(got, "schemas are identical\n") {
+			t.Errorf("expected identical schemas, got\n%s", got)
+		}
+	})
+
+	if ok, _ := schemaSubcommand(io.Discard, []string{"nope"}); ok {
+		t.Error("expected an unknown subcommand to be left to the schema command")
+	}
+}
+
+func TestCLICatStream(t *testing.T) {
+	type event struct {
+		Kind string `glint:"kind"`
+		User struct {
+			Name string `glint:"name"`
+		} `glint:"user"`
+	}
+	enc := glint.NewEncoder[event]()
+
+	var records bytes.Buffer
+	rw := glint.NewRecordWriter(&records)
+	for _, e := range []struct{ kind, name string }{{"login", "ann"}, {"logout", "bob"}, {"login", "cat"}} {
+		v := event{Kind: e.kind}
+		v.User.Name = e.name
+		if err := rw.WriteRecord(enc.MarshalBytes(&v)); err != nil {
+			t.Fatal(err)
+		}
+		if e.name == "bob" {
+			rw.WriteRecord([]byte{1, 2, 3}) // not a document, which is skipped
+		}
+	}
+
+	stream := func(format, where string) (string, string) {
+		var filter *documentFilter
+		if where != "" {
+			var err error
+			if filter, err = parseDocumentFilter(where); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var out, errs bytes.Buffer
+		if err := streamDocuments(bytes.NewReader(records.Bytes()), &out, &errs, format, filter); err != nil {
+			t.Fatal(err)
+		}
+		return out.String(), errs.String()
+	}
+
+	out, errs := stream("json", "")
+	want := `{"kind":"login","user":{"name":"ann"}}
+{"kind":"logout","user":{"name":"bob"}}
+{"kind":"login","user":{"name":"cat"}}
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+	if !strings.HasPrefix(errs, "record 3: ") || strings.Count(errs, "\n") != 1 {
+		t.Errorf("expected an error for the third record alone, got %q", errs)
+	}
+
+	if out, _ := stream("json", "kind=login"); strings.Count(out, "\n") != 2 || strings.Contains(out, "bob") {
+		t.Errorf("expected the logins, got\n%s", out)
+	}
+	if out, _ := stream("yaml", "user.name!=ann"); out != "---\nkind: logout\nuser:\n  name: bob\n---\nkind: login\nuser:\n  name: cat\n" {
+		t.Errorf("expected everyone but ann, got\n%s", out)
+	}
+	if out, _ := stream("pretty", "missing=1"); out != "" {
+		t.Errorf("expected a path that leads nowhere to match nothing, got\n%s", out)
+	}
+
+	if err := streamDocuments(bytes.NewReader(records.Bytes()), io.Discard, io.Discard, "table", nil); err == nil {
+		t.Error("expected an error streaming a table")
+	}
+	if err := streamDocuments(bytes.NewReader(records.Bytes()[:records.Len()-1]), io.Discard, io.Discard, "json", nil); err == nil {
+		t.Error("expected an error for a truncated stream")
+	}
+	for _, where := range []string{"kind", "=login", "a..b=1"} {
+		if _, err := parseDocumentFilter(where); err == nil {
+			t.Errorf("expected an error for the filter %q", where)
+		}
+	}
+}
+
+func TestCLIValidate(t *testing.T) {
+	type inner struct {
+		Tags []string `glint:"tags"`
+	}
+	type expected struct {
+		ID    int    `glint:"id"`
+		Name  string `glint:"name"`
+		Inner inner  `glint:"inner"`
+	}
+	type extra struct {
+		Name  string `glint:"name"`
+		ID    int    `glint:"id"`
+		Inner inner  `glint:"inner"`
+		Note  string `glint:"note"`
+	}
+	type changed struct {
+		ID    string `glint:"id"`
+		Inner inner  `glint:"inner"`
+	}
+
+	schema, err := glint.ParseSchema(glint.NewEncoder[expected]().MarshalBytes(&expected{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := glint.NewEncoder[expected]().MarshalBytes(&expected{ID: 1, Name: "ann", Inner: inner{Tags: []string{"a", "long tag"}}})
+	extraDoc := glint.NewEncoder[extra]().MarshalBytes(&extra{})
+	changedDoc := glint.NewEncoder[changed]().MarshalBytes(&changed{})
+
+	if err := validateDocument(doc, glint.DefaultLimits, schema, true); err != nil {
+		t.Errorf("expected a document to match its own schema strictly, got %v", err)
+	}
+	if err := validateDocument(extraDoc, glint.DefaultLimits, schema, false); err != nil {
+		t.Errorf("expected extra and reordered fields to be allowed, got %v", err)
+	}
+	if err := validateDocument(extraDoc, glint.DefaultLimits, schema, true); err == nil || !strings.Contains(err.Error(), "Added field 'note'") || !strings.Contains(err.Error(), "Reordered fields") {
+		t.Errorf("expected extra and reordered fields to fail strictly, got %v", err)
+	}
+	if err := validateDocument(changedDoc, glint.DefaultLimits, schema, false); err == nil || !strings.Contains(err.Error(), "Changed field 'id' from int to string") || !strings.Contains(err.Error(), "Removed field 'name'") {
+		t.Errorf("expected changed and missing fields to fail, got %v", err)
+	}
+
+	limits := glint.DefaultLimits
+	limits.MaxSliceElements = 1
+	if err := validateDocument(doc, limits, nil, false); !errors.Is(err, glint.ErrLimitExceeded) {
+		t.Errorf("expected a slice to exceed the limit, got %v", err)
+	}
+	limits = glint.DefaultLimits
+	limits.MaxNestingDepth, limits.MaxSchemaDepth = 1, 1
+	if err := validateDocument(doc, limits, nil, false); !errors.Is(err, glint.ErrLimitExceeded) {
+		t.Errorf("expected nesting to exceed the limit, got %v", err)
+	}
+	limits = glint.DefaultLimits
+	limits.MaxDocumentSize = uint(len(doc) - 1)
+	if err := validateDocument(doc, limits, nil, false); !errors.Is(err, glint.ErrLimitExceeded) {
+		t.Errorf("expected a large document to exceed the limit, got %v", err)
+	}
+	if err := validateDocument(doc[:len(doc)-1], glint.DefaultLimits, nil, false); !errors.Is(err, glint.ErrInvalidDocument) {
+		t.Errorf("expected a truncated document to be invalid, got %v", err)
+	}
+
+	for in, want := range map[string]uint{"512": 512, "64KB": 64 << 10, "10mb": 10 << 20, "1 GB": 1 << 30, "7B": 7} {
+		var b byteSize
+		if err := b.Set(in); err != nil || uint(b) != want {
+			t.Errorf("%q: got %d, %v, want %d", in, b, err, want)
+		}
+	}
+	var b byteSize
+	if err := b.Set("10XB"); err == nil {
+		t.Error("expected an error for an unknown unit")
+	}
+	if b = byteSize(10 << 20); b.String() != "10MB" {
+		t.Errorf("expected 10MB, got %s", b.String())
+	}
+}
+
+func TestCLIBench(t *testing.T) {
+	payload := []byte(`{"id": 12345, "name": "Widget", "tags": ["a", "b"], "items": [{"sku": "x-1", "qty": 3}]}`)
+
+	results, err := benchPayload(payload, nil, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].format != "json" || results[1].format != "glint" {
+		t.Fatalf("expected json and glint results, got %+v", results)
+	}
+	for _, r := range results {
+		if r.size == 0 || r.encode.ns <= 0 || r.decode.ns <= 0 {
+			t.Errorf("expected a size and timings for %s, got %+v", r.format, r)
+		}
+	}
+	if want := len(`{"id":12345,"items":[{"qty":3,"sku":"x-1"}],"name":"Widget","tags":["a","b"]}`); results[0].size != want {
+		t.Errorf("expected the JSON size of the compacted payload, %d, got %d", want, results[0].size)
+	}
+
+	var out bytes.Buffer
+	if err := writeBenchReport(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "FORMAT") || !strings.Contains(out.String(), "glint is ") {
+		t.Errorf("unexpected report\n%s", out.String())
+	}
+
+	// with a schema, the payload is read as its types rather than those inferred
+	type item struct {
+		SKU string `glint:"sku"`
+		Qty uint8  `glint:"qty"`
+	}
+	type product struct {
+		ID    uint32   `glint:"id"`
+		Name  string   `glint:"name"`
+		Tags  []string `glint:"tags"`
+		Items []item   `glint:"items"`
+	}
+	schema, err := glint.ParseSchema(glint.NewEncoder[product]().MarshalBytes(&product{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := benchPayload(payload, schema, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed[1].size >= results[1].size {
+		t.Errorf("expected narrower types to write a smaller document, got %d and %d", typed[1].size, results[1].size)
+	}
+
+	if _, err := benchPayload([]byte(`[1, 2]`), nil, time.Millisecond); err == nil {
+		t.Error("expected an error for a payload that isn't an object")
+	}
+}
+
+func TestMixedArrays(t *testing.T) {
+	for _, in := range []string{
+		`{"values":[1,"a",true,null,2.5]}`,
+		`{"values":["a",1]}`,
+		`{"values":[null,false]}`,
+		`{"values":[9223372036854776000,10000000000000000000,-9223372036854775808,"a"]}`,
+	} {
+		var jsonData interface{}
+		if err := json.Unmarshal([]byte(in), &jsonData); err != nil {
+			t.Fatal(err)
+		}
+		glintData, err := jsonToGlint(jsonData)
+		if err != nil {
+			t.Fatalf("Error converting JSON to glint: %v", err)
+		}
+		tmpl, err := NewTemplate(glintData)
+		if err != nil {
+			t.Fatalf("Error creating template: %v", err)
+		}
+		out, err := json.Marshal(tmpl.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != in {
+			t.Errorf("expected every element to keep its type, got %s for %s", out, in)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// goTypeSchema returns the schema of a Go struct type, as glint.NewEncoder[T]().Schema() returns it, by running a
+// program that imports the package declaring it. The program is written to a directory within the package, so it
+// builds against the requirements of the package's module, which must include glint. Main packages can't be
+// imported, so types declared in them can't be read.
+func goTypeSchema(pkg, typeName string) ([]byte, error) {
+	out, err := exec.Command("go", "list", "-f", "{{.ImportPath}}\n{{.Dir}}\n{{.Name}}", pkg).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error finding package %s: %v", pkg, commandError(err))
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("error finding package %s: unexpected go list output %q", pkg, out)
+	}
+	importPath, dir, name := lines[0], lines[1], lines[2]
+	if name == "main" {
+		return nil, fmt.Errorf("%s is a main package, which can't be imported to read %s from", importPath, typeName)
+	}
+
+	tmp, err := os.MkdirTemp(dir, "glintgen")
+	if err != nil {
+		return nil, fmt.Errorf("error writing schema program: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	program := fmt.Sprintf(goTypeSchemaProgram, importPath, typeName)
+	if err := os.WriteFile(filepath.Join(tmp, "main.go"), []byte(program), 0o644); err != nil {
+		return nil, fmt.Errorf("error writing schema program: %v", err)
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = tmp
+	schema, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the schema of %s.%s: %v", importPath, typeName, commandError(err))
+	}
+	return schema, nil
+}
+
+// goTypeSchemaProgram writes out the schema of the type named by its second verb, from the package imported by
+// its first
+const goTypeSchemaProgram = `package main
+
+import (
+	"os"
+
+	"github.com/kungfusheep/glint"
+	target %q
+)
+
+func main() {
+	os.Stdout.Write(glint.NewEncoder[target.%s]().Schema().Bytes)
+}
+`
+
+// commandError adds what a failed command wrote to stderr to its error
+func commandError(err error) error {
+	if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+		return fmt.Errorf("%v\n%s", err, bytes.TrimSpace(exit.Stderr))
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Queries pick values out of a document by path, as in jq
+//
+//	users[3].address.city     the city of the fourth user
+//	users[-1].name            the name of the last user
+//	tags[env]                 the value of the env key in the tags map, or tags["env"] for keys with . or ]
+//	users[*].name             the name of every user
+//	limits.*                  every field of limits
+//
+// Brackets index lists by position and maps by key, according to what they're applied to, so map keys that look
+// like numbers work as expected. Paths are evaluated over the document's JSON form, see documentJSON.
+
+// queryStep is one step of a parsed query
+type queryStep struct {
+	key      string // the field, map key or list index to step to
+	wildcard bool   // step to every field, entry or element instead
+	bracket  bool   // written in brackets, so key may index a list
+}
+
+func (s queryStep) String() string {
+	switch {
+	case s.wildcard && s.bracket:
+		return "[*]"
+	case s.wildcard:
+		return ".*"
+	case s.bracket:
+		return "[" + s.key + "]"
+	}
+	return "." + s.key
+}
+
+// parseQuery splits a query into its steps
+func parseQuery(query string) ([]queryStep, error) {
+	var steps []queryStep
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == '.':
+			i++
+			if i == len(query) || query[i] == '.' || query[i] == '[' {
+				return nil, fmt.Errorf("expected a field name after . at offset %d", i)
+			}
+		case c == '[':
+			end, key, err := parseQueryBracket(query, i)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, queryStep{key: key, wildcard: key == "*" && query[i+1] != '"', bracket: true})
+			i = end
+		case c == ']':
+			return nil, fmt.Errorf("unexpected ] at offset %d", i)
+		default:
+			if i > 0 && query[i-1] != '.' {
+				return nil, fmt.Errorf("expected . or [ at offset %d", i)
+			}
+			end := i + strings.IndexAny(query[i:], ".[]")
+			if end < i {
+				end = len(query)
+			}
+			key := query[i:end]
+			steps = append(steps, queryStep{key: key, wildcard: key == "*"})
+			i = end
+		}
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	return steps, nil
+}
+
+// parseQueryBracket reads the bracket starting at offset i, returning the offset after it and the key within it.
+// Keys may be quoted as Go strings.
+func parseQueryBracket(query string, i int) (int, string, error) {
+	if i+1 < len(query) && query[i+1] == '"' {
+		// the closing quote is the first that isn't escaped, followed by ]
+		for j := i + 2; j < len(query); j++ {
+			if query[j] == '\\' {
+				j++
+				continue
+			}
+			if query[j] != '"' {
+				continue
+			}
+			key, err := strconv.Unquote(query[i+1 : j+1])
+			if err != nil || j+1 == len(query) || query[j+1] != ']' {
+				return 0, "", fmt.Errorf("malformed quoted key at offset %d", i)
+			}
+			return j + 2, key, nil
+		}
+		return 0, "", fmt.Errorf("unterminated quoted key at offset %d", i)
+	}
+
+	end := strings.IndexByte(query[i:], ']')
+	if end < 0 {
+		return 0, "", fmt.Errorf("unterminated [ at offset %d", i)
+	}
+	key := query[i+1 : i+end]
+	if key == "" {
+		return 0, "", fmt.Errorf("empty [] at offset %d", i)
+	}
+	return i + end + 1, key, nil
+}
+
+// evalQuery returns the values the steps lead to from v. A path through missing fields or out of range indexes is
+// an error, unless it passes through a wildcard, in which case the values it can't reach are left out.
+func evalQuery(v any, steps []queryStep) ([]any, error) {
+	values := []any{v}
+	path := ""
+	wildcard := false
+
+	for _, s := range steps {
+		var next []any
+		for _, v := range values {
+			found, err := s.apply(v)
+			if err != nil {
+				if wildcard {
+					continue
+				}
+				if path == "" {
+					return nil, err
+				}
+				return nil, fmt.Errorf("%s: %v", strings.TrimPrefix(path, "."), err)
+			}
+			next = append(next, found...)
+		}
+
+		values = next
+		path += s.String()
+		wildcard = wildcard || s.wildcard
+	}
+	return values, nil
+}
+
+// apply takes the step from v
+func (s queryStep) apply(v any) ([]any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if s.wildcard {
+			values := make([]any, 0, len(v))
+			for _, k := range sortedKeys(v) {
+				values = append(values, v[k])
+			}
+			return values, nil
+		}
+		e, ok := v[s.key]
+		if !ok {
+			return nil, fmt.Errorf("no field or key %q", s.key)
+		}
+		return []any{e}, nil
+	case []any:
+		if s.wildcard {
+			return v, nil
+		}
+		if !s.bracket {
+			return nil, fmt.Errorf("can't look up field %q in a list, index it first as in [0] or [*]", s.key)
+		}
+		i, err := strconv.Atoi(s.key)
+		if err != nil {
+			return nil, fmt.Errorf("lists are indexed by number, not %q", s.key)
+		}
+		if i < 0 {
+			i += len(v) // counting back from the end
+		}
+		if i < 0 || i >= len(v) {
+			return nil, fmt.Errorf("index %s out of range for a list of %d", s.key, len(v))
+		}
+		return []any{v[i]}, nil
+	}
+
+	if v == nil {
+		return nil, fmt.Errorf("can't step to %s of null", s)
+	}
+	return nil, fmt.Errorf("can't step to %s of %v", s, v)
+}
+
+// queryDocument writes the values query leads to in doc to w, one to a line. Strings are written as they are, and
+// lists and objects as JSON.
+func queryDocument(w io.Writer, doc []byte, query string) error {
+	steps, err := parseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid query %q: %v", query, err)
+	}
+
+	v, err := documentJSON(doc)
+	if err != nil {
+		return fmt.Errorf("error parsing document: %v", err)
+	}
+
+	values, err := evalQuery(v, steps)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	for _, v := range values {
+		s, err := queryValue(v)
+		if err != nil {
+			return err
+		}
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// queryValue formats a value a query leads to as queryDocument writes it
+func queryValue(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	j, err := json.Marshal(v)
+	return string(j), err
+}
+
+// documentFilter keeps the documents where a query leads to a value, written path=value, or where it doesn't,
+// written path!=value. Values are compared as glint get writes them, so strings are written without quotes.
+type documentFilter struct {
+	steps  []queryStep
+	value  string
+	negate bool
+}
+
+// parseDocumentFilter parses a filter such as users[*].name=ann
+func parseDocumentFilter(filter string) (*documentFilter, error) {
+	query, value, ok := strings.Cut(filter, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid filter %q, expected path=value or path!=value", filter)
+	}
+	query, negate := strings.CutSuffix(query, "!")
+
+	steps, err := parseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %v", query, err)
+	}
+	return &documentFilter{steps: steps, value: value, negate: negate}, nil
+}
+
+// match reports whether the filter keeps doc. Paths that lead nowhere lead to no values, which matches a != filter
+// and not an = one.
+func (f *documentFilter) match(doc []byte) (bool, error) {
+	v, err := documentJSON(doc)
+	if err != nil {
+		return false, err
+	}
+
+	values, _ := evalQuery(v, f.steps)
+	for _, v := range values {
+		if s, err := queryValue(v); err == nil && s == f.value {
+			return !f.negate, nil
+		}
+	}
+	return f.negate, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/kungfusheep/glint"
+)
+
+// HeadCmd writes the first records of a record file
+type HeadCmd struct {
+	n     int
+	print bool
+}
+
+func (h *HeadCmd) Name() string { return "head" }
+
+func (h *HeadCmd) DefineFlags(fs *flag.FlagSet) {
+	fs.IntVar(&h.n, "n", 10, "Number of records")
+	fs.BoolVar(&h.print, "print", false, "Print records as documents rather than writing them as records")
+}
+
+func (h *HeadCmd) Execute(args []string) error {
+	return withRecordInput(args, func(r io.Reader) error {
+		return headRecords(r, os.Stdout, h.n, h.print)
+	}, func(c *glint.Container) error {
+		return headContainer(c, os.Stdout, h.n, h.print)
+	})
+}
+
+// SampleCmd writes a random sample of the records in a record file
+type SampleCmd struct {
+	p     float64
+	seed  int64
+	print bool
+}
+
+func (s *SampleCmd) Name() string { return "sample" }
+
+func (s *SampleCmd) DefineFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&s.p, "p", 0.01, "Probability of keeping each record")
+	fs.Int64Var(&s.seed, "seed", 0, "Random seed, for a repeatable sample (default random)")
+	fs.BoolVar(&s.print, "print", false, "Print records as documents rather than writing them as records")
+}
+
+func (s *SampleCmd) Execute(args []string) error {
+	if s.p < 0 || s.p > 1 {
+		return fmt.Errorf("-p must be between 0 and 1, got %v", s.p)
+	}
+
+	seed := s.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return withRecordInput(args, func(r io.Reader) error {
+		return sampleRecords(r, os.Stdout, s.p, rand.New(rand.NewSource(seed)), s.print)
+	}, func(c *glint.Container) error {
+		return sampleContainer(c, os.Stdout, s.p, rand.New(rand.NewSource(seed)), s.print)
+	})
+}
+
+// withRecordInput calls stream with the file named by args, or stdin when there isn't one. When indexed is set,
+// files holding a container are handed to it instead, so only the documents wanted are read. Stdin can't be read
+// out of order, so containers piped in are refused.
+func withRecordInput(args []string, stream func(io.Reader) error, indexed func(*glint.Container) error) error {
+	switch len(args) {
+	case 0:
+		if indexed == nil {
+			return stream(os.Stdin)
+		}
+		br := bufio.NewReader(os.Stdin)
+		if magic, _ := br.Peek(len(containerMagic)); string(magic) == containerMagic {
+			return fmt.Errorf("containers can't be read from stdin, name the file instead")
+		}
+		return stream(br)
+	case 1:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("error opening input: %v", err)
+		}
+		defer f.Close()
+		if indexed == nil {
+			return stream(f)
+		}
+
+		magic := make([]byte, len(containerMagic))
+		if _, err := io.ReadFull(f, magic); err != nil || string(magic) != containerMagic {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("error reading input: %v", err)
+			}
+			return stream(f)
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("error reading input: %v", err)
+		}
+		c, err := glint.OpenContainer(f, info.Size())
+		if err != nil {
+			return fmt.Errorf("error reading container: %v", err)
+		}
+		return indexed(c)
+	default:
+		return fmt.Errorf("expected at most one input file, got %d", len(args))
+	}
+}
+
+// containerMagic starts every container written by glint.ContainerWriter
+const containerMagic = "GLCT"
+
+// headRecords copies the first n records from r to w. Reading stops once they're found, so the rest of a large
+// file is never read.
+func headRecords(r io.Reader, w io.Writer, n int, print bool) error {
+	i := 0
+	return copyRecords(r, w, print, func([]byte) (keep, more bool) {
+		i++
+		return i <= n, i < n
+	})
+}
+
+// sampleRecords copies each record from r to w with probability p
+func sampleRecords(r io.Reader, w io.Writer, p float64, rnd *rand.Rand, print bool) error {
+	return copyRecords(r, w, print, func([]byte) (keep, more bool) {
+		return rnd.Float64() < p, true
+	})
+}
+
+// headContainer copies the first n documents of a container to w, reading only those through its index
+func headContainer(c *glint.Container, w io.Writer, n int, print bool) error {
+	return copyContainer(c, w, print, func(i int) (keep, more bool) {
+		return i < n, i+1 < n
+	})
+}
+
+// sampleContainer copies each document of a container to w with probability p, reading only those kept through
+// its index
+func sampleContainer(c *glint.Container, w io.Writer, p float64, rnd *rand.Rand, print bool) error {
+	return copyContainer(c, w, print, func(int) (keep, more bool) {
+		return rnd.Float64() < p, true
+	})
+}
+
+// copyRecords copies the records from r that filter keeps to w, either as records or printed as documents, until
+// filter reports there are no more to look at
+func copyRecords(r io.Reader, w io.Writer, print bool, filter func(doc []byte) (keep, more bool)) error {
+	bw := bufio.NewWriter(w)
+	rw := glint.NewRecordWriter(bw)
+
+	rr := glint.NewRecordReader(r)
+	for more := true; more && rr.Next(); {
+		var keep bool
+		keep, more = filter(rr.Record())
+		if !keep {
+			continue
+		}
+		if err := writeRecord(bw, rw, rr.Record(), print); err != nil {
+			return err
+		}
+	}
+	if err := rr.Err(); err != nil {
+		return fmt.Errorf("error reading records: %v", err)
+	}
+
+	return bw.Flush()
+}
+
+// copyContainer copies the documents of a container that filter keeps, by their index, to w in the same way as
+// copyRecords. Documents that aren't kept are never read.
+func copyContainer(c *glint.Container, w io.Writer, print bool, filter func(i int) (keep, more bool)) error {
+	bw := bufio.NewWriter(w)
+	rw := glint.NewRecordWriter(bw)
+
+	for i, more := 0, true; more && i < c.Len(); i++ {
+		var keep bool
+		keep, more = filter(i)
+		if !keep {
+			continue
+		}
+
+		doc, err := c.Document(i)
+		if err != nil {
+			return fmt.Errorf("error reading document %d: %v", i, err)
+		}
+		if err := writeRecord(bw, rw, doc, print); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeRecord writes a single document, either as a record or printed
+func writeRecord(bw *bufio.Writer, rw *glint.RecordWriter, doc []byte, print bool) error {
+	if !print {
+		return rw.WriteRecord(doc)
+	}
+
+	s, err := glint.SPrint(doc)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(bw, s)
+	return err
+}
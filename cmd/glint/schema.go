@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kungfusheep/glint"
+)
+
+// schemaSubcommand runs the schema subcommands, returning false for anything else so the schema command can fall
+// back to printing the schema of stdin
+func schemaSubcommand(w io.Writer, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "show":
+		if len(args) > 2 {
+			return true, fmt.Errorf("usage: glint schema show [file]")
+		}
+		data, err := readInput(args[1:])
+		if err != nil {
+			return true, err
+		}
+		schema, err := loadSchema(data)
+		if err != nil {
+			return true, err
+		}
+		_, err = fmt.Fprintf(w, "hash %d\n%s", schema.Hash, schema)
+		return true, err
+
+	case "hash":
+		files := args[1:]
+		if len(files) == 0 {
+			files = []string{"-"}
+		}
+		for _, file := range files {
+			data, err := readInput([]string{file})
+			if err != nil {
+				return true, err
+			}
+			hash, err := schemaHash(data)
+			if err != nil {
+				return true, fmt.Errorf("%s: %v", file, err)
+			}
+			if len(files) > 1 {
+				_, err = fmt.Fprintf(w, "%d  %s\n", hash, file)
+			} else {
+				_, err = fmt.Fprintf(w, "%d\n", hash)
+			}
+			if err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+
+	case "diff":
+		if len(args) != 3 {
+			return true, fmt.Errorf("usage: glint schema diff <old-file> <new-file>")
+		}
+		schemas := make([]*glint.Schema, 2)
+		for i, file := range args[1:] {
+			data, err := readInput([]string{file})
+			if err != nil {
+				return true, err
+			}
+			if schemas[i], err = loadSchema(data); err != nil {
+				return true, fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		return true, writeSchemaDiff(w, args[1], schemas[0], args[2], schemas[1])
+	}
+	return false, nil
+}
+
+// readInput reads the file named by args, or stdin when there isn't one or it's -
+func readInput(args []string) ([]byte, error) {
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading input: %v", err)
+	}
+	return data, nil
+}
+
+// schemaHash returns the hash of the schema a document was written with, as sent in the X-Glint-Trust header and
+// registered with a SchemaRegistry. Documents written without their schema have the hash all the same, and bare
+// schema files are hashed as an encoder would hash them.
+func schemaHash(data []byte) (uint32, error) {
+	_, err := glint.ParseSchema(data)
+	if err == nil || errors.Is(err, glint.ErrSchemaNotFound) {
+		return glint.Document(data).Hash(), nil
+	}
+	schema, err := loadSchema(data)
+	if err != nil {
+		return 0, err
+	}
+	return schema.Hash, nil
+}
+
+// writeSchemaDiff writes the differences between two schemas to w, a line to a field, as diff does: - for a removed
+// field, + for an added one and ~ for a change of type, encoding or constraints. Fields are matched by name, at any
+// depth, with paths written as glint get reads them, such as items[*].qty.
+//
+// Fields in a different order make for a different hash, though decoders don't mind, so the order is reported too.
+func writeSchemaDiff(w io.Writer, fromName string, from *glint.Schema, toName string, to *glint.Schema) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--- %s  hash %d\n+++ %s  hash %d\n", fromName, from.Hash, toName, to.Hash)
+
+	changes := diffSchemaFields("", from.Fields, to.Fields, nil)
+	if len(changes) == 0 {
+		b.WriteString("schemas are identical\n")
+	}
+	for _, c := range changes {
+		switch c.Type {
+		case "added":
+			fmt.Fprintf(&b, "+ %s %s\n", c.FieldPath, c.NewType)
+		case "removed":
+			fmt.Fprintf(&b, "- %s %s\n", c.FieldPath, c.OldType)
+		case "reordered":
+			fmt.Fprintf(&b, "~ %s reordered: %s → %s\n", strings.TrimPrefix(c.FieldPath+" fields", " "), c.OldType, c.NewType)
+		default:
+			fmt.Fprintf(&b, "~ %s %s → %s\n", c.FieldPath, c.OldType, c.NewType)
+		}
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// diffSchemaFields appends the differences between two lists of fields to changes, with paths prefixed by path
+func diffSchemaFields(path string, from, to []glint.SchemaField, changes []SchemaChange) []SchemaChange {
+	fieldPath := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	toIndex := make(map[string]int, len(to))
+	for i := range to {
+		toIndex[to[i].Name] = i
+	}
+	fromIndex := make(map[string]int, len(from))
+	var fromOrder, toOrder []string
+
+	for i := range from {
+		fromIndex[from[i].Name] = i
+		j, ok := toIndex[from[i].Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Type: "removed", FieldPath: fieldPath(from[i].Name), OldType: fieldSummary(&from[i]), Breaking: true})
+			continue
+		}
+		fromOrder = append(fromOrder, from[i].Name)
+		changes = diffSchemaField(fieldPath(from[i].Name), &from[i], &to[j], changes)
+	}
+	for i := range to {
+		if _, ok := fromIndex[to[i].Name]; !ok {
+			changes = append(changes, SchemaChange{Type: "added", FieldPath: fieldPath(to[i].Name), NewType: fieldSummary(&to[i])})
+			continue
+		}
+		toOrder = append(toOrder, to[i].Name)
+	}
+
+	if strings.Join(fromOrder, ",") != strings.Join(toOrder, ",") {
+		changes = append(changes, SchemaChange{Type: "reordered", FieldPath: path, OldType: strings.Join(fromOrder, ", "), NewType: strings.Join(toOrder, ", ")})
+	}
+	return changes
+}
+
+// diffSchemaField appends the differences between two fields of the same name to changes. Fields holding structs
+// in the same way are compared field by field.
+func diffSchemaField(path string, from, to *glint.SchemaField, changes []SchemaChange) []SchemaChange {
+	if o, n := fieldSummary(from), fieldSummary(to); o != n {
+		return append(changes, SchemaChange{Type: "type_changed", FieldPath: path, OldType: o, NewType: n, Breaking: true})
+	}
+
+	// the shapes match, so both lead to structs, or neither does, by the same steps
+	for {
+		switch {
+		case from.Wire&glint.WireSliceFlag > 0:
+			path, from, to = path+"[*]", from.Elem, to.Elem
+			continue
+		case from.Wire&glint.WireTypeMask == glint.WireMap:
+			path, from, to = path+"[*]", from.Value, to.Value
+			continue
+		case from.Wire&glint.WireTypeMask == glint.WireStruct:
+			return diffSchemaFields(path, from.Fields, to.Fields, changes)
+		}
+		return changes
+	}
+}
+
+// fieldSummary returns a field's type with any struct written as struct, followed by its encodings and
+// constraints, so fields holding structs are compared by everything but the fields of the structs
+func fieldSummary(f *glint.SchemaField) string {
+	s := fieldShape(f)
+	if opts := f.Options(); len(opts) > 0 {
+		s += " " + strings.Join(opts, ",")
+	}
+	return s
+}
+
+// fieldShape is fieldSummary without the options of the field itself
+func fieldShape(f *glint.SchemaField) string {
+	switch {
+	case f.Wire&glint.WireTypeMask == 0 && f.Wire&glint.WireSliceFlag > 0:
+		return "[]" + fieldSummary(f.Elem) // slices of slices, whose elements have encodings of their own
+	case f.Wire&glint.WireSliceFlag > 0:
+		return "[]" + fieldShape(f.Elem) // the elements' encodings are the slice's
+	case f.Wire&glint.WirePtrFlag > 0:
+		return "*" + fieldShape(&glint.SchemaField{Wire: f.Wire &^ glint.WirePtrFlag, Key: f.Key, Value: f.Value})
+	}
+
+	switch f.Wire & glint.WireTypeMask {
+	case glint.WireStruct:
+		return "struct"
+	case glint.WireMap:
+		return "map[" + fieldSummary(f.Key) + "]" + fieldSummary(f.Value)
+	}
+	return f.TypeString()
+}
@@ -3,51 +3,66 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/kungfusheep/glint"
 )
 
-// GenerateStruct generates Go struct code from a glint document
-func GenerateStruct(doc []byte, packageName, structName string) (result string, err error) {
-	// Parse the glint document with error handling
-	if len(doc) < 5 {
-		return "", fmt.Errorf("invalid glint document: too short")
+// GenerateStruct generates Go struct code from a glint document, or from a schema held apart from any document as
+// returned by Encoder.Schema
+func GenerateStruct(doc []byte, packageName, structName string) (string, error) {
+	schema, err := loadSchema(doc)
+	if err != nil {
+		return "", err
 	}
-	
-	// Use defer to catch panics from invalid documents
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("invalid glint document: %v", r)
-		}
-	}()
-	
-	reader := glint.NewReader(doc)
-	printerDoc := glint.NewPrinterDocument(&reader)
-	schema := glint.NewPrinterSchema(&printerDoc.Schema)
+	return GenerateStructFromSchema(schema, packageName, structName)
+}
 
-	// Generate the struct
+// GenerateStructFromSchema generates Go struct code for a parsed schema. Fields are tagged with their names and any
+// encodings and constraints they were written with, so the struct writes documents the same way.
+func GenerateStructFromSchema(schema *glint.Schema, packageName, structName string) (string, error) {
 	generator := &structGenerator{
 		packageName: packageName,
+		rootName:    structName,
 		structs:     make(map[string]*structInfo),
 		imports:     make(map[string]bool),
 	}
 
 	// Generate main struct
-	structDef, err := generator.generateStructFromSchema(structName, &schema)
+	fields, err := generator.generateFields(schema.Fields)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate struct: %v", err)
 	}
+	structDef := &structInfo{name: structName, fields: fields}
 
 	// Build the complete Go file
 	return generator.buildGoFile(structName, structDef), nil
 }
 
+// loadSchema parses the schema of a glint document, falling back to reading data as bare schema bytes
+func loadSchema(data []byte) (*glint.Schema, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("invalid glint document: too short")
+	}
+
+	schema, err := glint.ParseSchema(data)
+	if err == nil {
+		return schema, nil
+	}
+	if bare, bareErr := glint.ParseSchemaBytes(data); bareErr == nil {
+		return bare, nil
+	}
+	return nil, fmt.Errorf("invalid glint document: %v", err)
+}
+
 // structGenerator handles the generation of Go structs from glint schemas
 type structGenerator struct {
 	packageName string
+	rootName    string
 	structs     map[string]*structInfo
+	order       []string // nested struct names in the order they were generated
 	imports     map[string]bool
 }
 
@@ -65,177 +80,130 @@ type fieldInfo struct {
 	comment  string
 }
 
-// generateStructFromSchema converts a PrinterSchema to a Go struct
-func (g *structGenerator) generateStructFromSchema(structName string, schema *glint.PrinterSchema) (*structInfo, error) {
-	// Check if we've already generated this struct
-	if existing, exists := g.structs[structName]; exists {
-		return existing, nil
+// generateFields converts the fields of a schema to the fields of a Go struct
+func (g *structGenerator) generateFields(fields []glint.SchemaField) ([]fieldInfo, error) {
+	result := make([]fieldInfo, 0, len(fields))
+	names := make(map[string]bool, len(fields))
+	for i := range fields {
+		fieldDef, err := g.generateField(&fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate field %s: %v", fields[i].Name, err)
+		}
+
+		// names that differ only in case or separators convert to the same Go name, number all but the first
+		name := fieldDef.name
+		for n := 2; names[fieldDef.name]; n++ {
+			fieldDef.name = name + strconv.Itoa(n)
+		}
+		names[fieldDef.name] = true
+
+		result = append(result, fieldDef)
 	}
+	return result, nil
+}
 
-	structDef := &structInfo{
-		name:   structName,
-		fields: make([]fieldInfo, 0, len(schema.Fields)),
+// generateStruct generates a nested struct, returning its name. Structs with the same fields share one type, and
+// those that differ but would share a name are numbered.
+func (g *structGenerator) generateStruct(structName string, fields []glint.SchemaField) (string, error) {
+	fieldDefs, err := g.generateFields(fields)
+	if err != nil {
+		return "", err
 	}
 
-	// Process each field in the schema
-	for _, field := range schema.Fields {
-		fieldDef, err := g.generateField(field)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate field %s: %v", field.Name, err)
+	name := structName
+	for n := 2; ; n++ {
+		existing, exists := g.structs[name]
+		if !exists && name != g.rootName {
+			break
+		}
+		if exists && sameFields(existing.fields, fieldDefs) {
+			return name, nil
 		}
-		structDef.fields = append(structDef.fields, fieldDef)
+		name = structName + strconv.Itoa(n)
 	}
 
-	// Store the struct definition
-	g.structs[structName] = structDef
-	return structDef, nil
+	g.structs[name] = &structInfo{name: name, fields: fieldDefs}
+	g.order = append(g.order, name)
+	return name, nil
+}
+
+// sameFields reports whether two generated structs have the same fields
+func sameFields(a, b []fieldInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-// generateField converts a PrinterSchemaField to a Go struct field
-func (g *structGenerator) generateField(field glint.PrinterSchemaField) (fieldInfo, error) {
+// generateField converts a SchemaField to a Go struct field
+func (g *structGenerator) generateField(field *glint.SchemaField) (fieldInfo, error) {
 	// Convert field name to Go naming convention
 	goFieldName := toGoFieldName(field.Name)
-	
+
 	// Generate the Go type
-	goType, err := g.wireTypeToGoType(field)
+	goType, err := g.goType(field, goFieldName)
 	if err != nil {
 		return fieldInfo{}, fmt.Errorf("failed to convert type for field %s: %v", field.Name, err)
 	}
 
-	// Generate glint tag
-	tag := fmt.Sprintf(`glint:"%s"`, field.Name)
+	// Generate glint tag, carrying the encodings and constraints the field was written with
+	tag := strings.Join(append([]string{field.Name}, field.Options()...), ",")
 
 	return fieldInfo{
 		name:   goFieldName,
 		goType: goType,
-		tag:    tag,
+		tag:    "glint:" + strconv.Quote(tag),
 	}, nil
 }
 
-// wireTypeToGoType converts a glint wire type to a Go type string
-func (g *structGenerator) wireTypeToGoType(field glint.PrinterSchemaField) (string, error) {
-	baseType := field.TypeID & glint.WireTypeMask
-	
-	// Handle pointer types
-	var prefix string
-	if field.IsPointer {
-		prefix = "*"
-	}
-
-	// Handle slice types
-	if field.IsSlice {
-		elementType, err := g.getElementType(field)
+// goType converts a schema field to a Go type, naming any struct it needs after typeName
+func (g *structGenerator) goType(field *glint.SchemaField, typeName string) (string, error) {
+	switch {
+	case field.Wire&glint.WireSliceFlag > 0:
+		// slices written with a presence byte are still slices, nil rather than empty
+		elementType, err := g.goType(field.Elem, strings.TrimSuffix(typeName, "Item")+"Item")
 		if err != nil {
 			return "", err
 		}
-		return prefix + "[]" + elementType, nil
-	}
-
-	// Handle base types
-	goType, err := g.getBaseGoType(baseType, field)
-	if err != nil {
-		return "", err
-	}
+		return "[]" + elementType, nil
 
-	return prefix + goType, nil
-}
-
-// getElementType gets the Go type for slice elements
-func (g *structGenerator) getElementType(field glint.PrinterSchemaField) (string, error) {
-	baseType := field.TypeID & glint.WireTypeMask
-	
-	if baseType == glint.WireStruct {
-		// Array of structs - generate nested struct type
-		if field.NestedSchema == nil {
-			return "", fmt.Errorf("struct slice missing nested schema")
-		}
-		
-		// Generate a nested struct name
-		nestedName := toGoFieldName(field.Name) + "Item"
-		_, err := g.generateStructFromSchema(nestedName, field.NestedSchema)
+	case field.Wire&glint.WirePtrFlag > 0 && field.Wire&glint.WireTypeMask != glint.WireBytes:
+		// byte slices, like other slices, can be nil without a pointer
+		inner := *field
+		inner.Wire &^= glint.WirePtrFlag
+		goType, err := g.goType(&inner, typeName)
 		if err != nil {
 			return "", err
 		}
-		return nestedName, nil
+		return "*" + goType, nil
 	}
-	
-	// Primitive slice element
-	return g.getBaseGoType(baseType, field)
-}
 
-// getBaseGoType converts a base wire type to a Go type
-func (g *structGenerator) getBaseGoType(wireType glint.WireType, field glint.PrinterSchemaField) (string, error) {
-	switch wireType {
-	case glint.WireBool:
-		return "bool", nil
-	case glint.WireInt:
-		return "int", nil
-	case glint.WireInt8:
-		return "int8", nil
-	case glint.WireInt16:
-		return "int16", nil
-	case glint.WireInt32:
-		return "int32", nil
-	case glint.WireInt64:
-		return "int64", nil
-	case glint.WireUint:
-		return "uint", nil
-	case glint.WireUint8:
-		return "uint8", nil
-	case glint.WireUint16:
-		return "uint16", nil
-	case glint.WireUint32:
-		return "uint32", nil
-	case glint.WireUint64:
-		return "uint64", nil
-	case glint.WireFloat32:
-		return "float32", nil
-	case glint.WireFloat64:
-		return "float64", nil
-	case glint.WireString:
-		return "string", nil
-	case glint.WireBytes:
-		return "[]byte", nil
-	case glint.WireTime:
-		g.imports["time"] = true
-		return "time.Time", nil
+	switch field.Wire & glint.WireTypeMask {
 	case glint.WireStruct:
-		// Nested struct
-		if field.NestedSchema == nil {
-			return "", fmt.Errorf("struct field missing nested schema")
-		}
-		
-		// Generate nested struct name
-		nestedName := toGoFieldName(field.Name)
-		_, err := g.generateStructFromSchema(nestedName, field.NestedSchema)
-		if err != nil {
-			return "", err
-		}
-		return nestedName, nil
+		return g.generateStruct(typeName, field.Fields)
+
 	case glint.WireMap:
-		// Map type
-		if len(field.MapType) != 2 {
-			return "", fmt.Errorf("invalid map type")
-		}
-		
-		keyType, err := g.wireTypeToGoTypeSimple(field.MapType[0])
+		keyType, err := g.goType(field.Key, typeName+"Key")
 		if err != nil {
 			return "", fmt.Errorf("invalid map key type: %v", err)
 		}
-		
-		valueType, err := g.wireTypeToGoTypeSimple(field.MapType[1])
+		valueType, err := g.goType(field.Value, typeName+"Value")
 		if err != nil {
 			return "", fmt.Errorf("invalid map value type: %v", err)
 		}
-		
 		return fmt.Sprintf("map[%s]%s", keyType, valueType), nil
-	default:
-		return "", fmt.Errorf("unsupported wire type: %v", wireType)
 	}
+
+	return g.getBaseGoType(field.Wire & glint.WireTypeMask)
 }
 
-// wireTypeToGoTypeSimple converts simple wire types to Go types (for map keys/values)
-func (g *structGenerator) wireTypeToGoTypeSimple(wireType glint.WireType) (string, error) {
+// getBaseGoType converts a base wire type to a Go type
+func (g *structGenerator) getBaseGoType(wireType glint.WireType) (string, error) {
 	switch wireType {
 	case glint.WireBool:
 		return "bool", nil
@@ -270,8 +238,22 @@ func (g *structGenerator) wireTypeToGoTypeSimple(wireType glint.WireType) (strin
 	case glint.WireTime:
 		g.imports["time"] = true
 		return "time.Time", nil
+	case glint.WireDuration:
+		g.imports["time"] = true
+		return "time.Duration", nil
+	case glint.WireIP:
+		g.imports["net/netip"] = true
+		return "netip.Addr", nil
+	case glint.WireIPPrefix:
+		g.imports["net/netip"] = true
+		return "netip.Prefix", nil
+	case glint.WireBigInt:
+		g.imports["math/big"] = true
+		return "big.Int", nil
+	case glint.WireDecimal:
+		return "glint.Decimal", nil
 	default:
-		return "", fmt.Errorf("unsupported simple wire type: %v", wireType)
+		return "", fmt.Errorf("unsupported wire type: %v", wireType)
 	}
 }
 
@@ -304,21 +286,11 @@ func (g *structGenerator) buildGoFile(mainStructName string, mainStruct *structI
 		b.WriteString("import \"github.com/kungfusheep/glint\"\n\n")
 	}
 	
-	// Generate structs in dependency order (main struct last)
-	generated := make(map[string]bool)
-	
-	// Generate nested structs first
-	for name, structDef := range g.structs {
-		if name != mainStructName && !generated[name] {
-			g.writeStruct(&b, structDef)
-			generated[name] = true
-		}
-	}
-	
-	// Generate main struct last
-	if !generated[mainStructName] {
-		g.writeStruct(&b, mainStruct)
+	// Generate structs in dependency order, nested structs before those using them and the main struct last
+	for _, name := range g.order {
+		g.writeStruct(&b, g.structs[name])
 	}
+	g.writeStruct(&b, mainStruct)
 	
 	return b.String()
 }
@@ -361,9 +333,14 @@ func toGoFieldName(fieldName string) string {
 		return ""
 	}
 	
-	// Split on underscores and convert to PascalCase
-	parts := strings.Split(fieldName, "_")
+	// Split on underscores, and anything else that can't be part of a Go name, and convert to PascalCase
+	parts := strings.FieldsFunc(fieldName, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
 	var result strings.Builder
+	if len(parts) > 0 && unicode.IsDigit([]rune(parts[0])[0]) {
+		result.WriteString("F") // Go names can't start with a digit
+	}
 	
 	for _, part := range parts {
 		if len(part) == 0 {
@@ -257,4 +257,102 @@ func TestCLIStructGeneratorEmptyDocument(t *testing.T) {
 	if !strings.Contains(result, "package empty") {
 		t.Error("Expected package declaration")
 	}
-}
\ No newline at end of file
+}
+func TestCLIStructGeneratorEncodingsAndConstraints(t *testing.T) {
+	type Address struct {
+		City string `glint:"city,pattern=^[A-Z]"`
+	}
+	type Order struct {
+		ID        int64              `glint:"id,min=1"`
+		Readings  []int64            `glint:"readings,delta"`
+		Tags      []string           `glint:"tags,dict"`
+		Note      *string            `glint:"note"`
+		Blob      []byte             `glint:"blob"`
+		Home      Address            `glint:"home"`
+		Addresses map[string]Address `glint:"addresses"`
+	}
+
+	doc := glint.NewEncoder[Order]().MarshalBytes(&Order{})
+
+	result, err := GenerateStruct(doc, "orders", "Order")
+	if err != nil {
+		t.Fatalf("GenerateStruct failed: %v", err)
+	}
+
+	expected := []string{
+		"Id        int64                     `glint:\"id,min=1\"`",
+		"Readings  []int64                   `glint:\"readings,delta\"`",
+		"Tags      []string                  `glint:\"tags,dict\"`",
+		"Note      *string                   `glint:\"note\"`",
+		"Blob      []byte                    `glint:\"blob\"`",
+		"Home      Home                      `glint:\"home\"`",
+		"Addresses map[string]AddressesValue `glint:\"addresses\"`",
+		"City string `glint:\"city,pattern=^[A-Z]\"`",
+	}
+	for _, field := range expected {
+		if !strings.Contains(result, field) {
+			t.Errorf("Expected field %s in result:\n%s", field, result)
+		}
+	}
+
+	// nested structs come before the struct using them
+	if strings.Index(result, "type Home struct") > strings.Index(result, "type Order struct") {
+		t.Errorf("Expected nested structs before the main struct:\n%s", result)
+	}
+}
+
+func TestCLIStructGeneratorSchemaBytes(t *testing.T) {
+	type User struct {
+		Name string `glint:"name"`
+		Age  int    `glint:"age"`
+	}
+
+	fromDoc, err := GenerateStruct(glint.NewEncoder[User]().MarshalBytes(&User{}), "main", "User")
+	if err != nil {
+		t.Fatalf("GenerateStruct failed for document: %v", err)
+	}
+	fromSchema, err := GenerateStruct(glint.NewEncoder[User]().Schema().Bytes, "main", "User")
+	if err != nil {
+		t.Fatalf("GenerateStruct failed for schema bytes: %v", err)
+	}
+	if fromDoc != fromSchema {
+		t.Errorf("Expected the same struct from a document and its schema bytes\ndocument:\n%s\nschema:\n%s", fromDoc, fromSchema)
+	}
+}
+
+func TestCLIStructGeneratorNameClashes(t *testing.T) {
+	type Inner struct {
+		A int `glint:"a"`
+	}
+	type Other struct {
+		B string `glint:"b"`
+	}
+	type Doc struct {
+		Lower string  `glint:"first_name"`
+		Upper string  `glint:"first-name"`
+		Digit int     `glint:"2fa"`
+		Self  Other   `glint:"doc"`
+		Items []Inner `glint:"items"`
+		Again []Inner `glint:"items_again"`
+	}
+
+	result, err := GenerateStruct(glint.NewEncoder[Doc]().MarshalBytes(&Doc{}), "main", "Doc")
+	if err != nil {
+		t.Fatalf("GenerateStruct failed: %v", err)
+	}
+
+	expected := []string{
+		"FirstName  string",
+		"FirstName2 string",
+		"F2fa       int",
+		"Doc        Doc2",
+		"type Doc2 struct {",
+		"type ItemsItem struct {",
+		"ItemsAgain []ItemsAgainItem",
+	}
+	for _, field := range expected {
+		if !strings.Contains(result, field) {
+			t.Errorf("Expected %s in result:\n%s", field, result)
+		}
+	}
+}
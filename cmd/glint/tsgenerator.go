@@ -0,0 +1,736 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kungfusheep/glint"
+)
+
+// GenerateTypeScript generates TypeScript interfaces from a glint document, or from a schema held apart from any
+// document as returned by Encoder.Schema. With decoders, a function decoding documents into the root interface
+// follows them, see GenerateTypeScriptDecoders.
+func GenerateTypeScript(doc []byte, typeName string, decoders bool) (string, error) {
+	schema, err := loadSchema(doc)
+	if err != nil {
+		return "", err
+	}
+	if !decoders {
+		return GenerateTypeScriptFromSchema(schema, typeName)
+	}
+	return GenerateTypeScriptDecoders(schema, typeName, schemaID(doc))
+}
+
+// GenerateTypeScriptFromSchema generates TypeScript interfaces for a parsed schema, with properties named as the
+// fields are in documents. Integers wider than 32 bits are bigints, as numbers can't hold all of their values.
+func GenerateTypeScriptFromSchema(schema *glint.Schema, typeName string) (string, error) {
+	generator := &tsGenerator{
+		rootName:   typeName,
+		interfaces: make(map[string]tsInterface),
+	}
+
+	root, err := generator.generateProperties(schema.Fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate interface: %v", err)
+	}
+	return generator.interfacesSource(root), nil
+}
+
+// GenerateTypeScriptDecoders is GenerateTypeScriptFromSchema followed by a function named decode<typeName>, which
+// reads a document into the root interface, and the reader it shares with a function for each nested interface.
+// The decode function refuses compressed documents, and those written with any schema other than the one whose ID
+// is schemaID, the CRC32 of the schema as Encoder.Schema returns it. Documents written without their schema are
+// read when their header carries that ID, as those written with a SchemaRegistry do.
+func GenerateTypeScriptDecoders(schema *glint.Schema, typeName string, schemaID uint32) (string, error) {
+	generator := &tsGenerator{
+		rootName:   typeName,
+		interfaces: make(map[string]tsInterface),
+	}
+
+	root, err := generator.generateProperties(schema.Fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate interface: %v", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(generator.interfacesSource(root))
+	b.WriteString(fmt.Sprintf("\n// decode%[1]s reads a glint document written with the schema %[1]s was generated from\n", typeName))
+	b.WriteString(fmt.Sprintf("export function decode%[1]s(doc: Uint8Array): %[1]s {\n", typeName))
+	b.WriteString(fmt.Sprintf("  const r = glintBody(doc, 0x%08x);\n  const v = read%s(r);\n  r.end();\n  return v;\n}\n", schemaID, typeName))
+	for _, name := range generator.order {
+		b.WriteString(readerSource(name, generator.interfaces[name].read))
+	}
+	b.WriteString(readerSource(typeName, root.read))
+	b.WriteString(tsRuntime)
+	return b.String(), nil
+}
+
+// schemaID returns the CRC32 of the schema of a document, or of bare schema bytes, as Encoder.Schema returns it
+func schemaID(data []byte) uint32 {
+	if _, err := glint.ParseSchema(data); err != nil {
+		return crc32.ChecksumIEEE(data) // bare schema bytes, see loadSchema
+	}
+	l, n := binary.Uvarint(data[5:])
+	return crc32.ChecksumIEEE(data[5 : 5+n+int(l)])
+}
+
+// tsGenerator handles the generation of TypeScript interfaces from glint schemas
+type tsGenerator struct {
+	rootName   string
+	interfaces map[string]tsInterface // nested interfaces by name
+	order      []string               // nested interface names in the order they were generated
+}
+
+// tsInterface is the body of a generated interface, and of the object literal reading one from a document
+type tsInterface struct {
+	body, read string
+}
+
+// tsIdentifier matches property names that can be written without quotes
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// interfacesSource writes the nested interfaces in the order they were generated, followed by the root
+func (g *tsGenerator) interfacesSource(root tsInterface) string {
+	var b strings.Builder
+	for _, name := range g.order {
+		b.WriteString(fmt.Sprintf("export interface %s {\n%s}\n\n", name, g.interfaces[name].body))
+	}
+	b.WriteString(fmt.Sprintf("export interface %s {\n%s}\n", g.rootName, root.body))
+	return b.String()
+}
+
+// readerSource writes the function reading an interface from a document
+func readerSource(name, read string) string {
+	if read == "" {
+		return fmt.Sprintf("\nfunction read%[1]s(r: GlintReader): %[1]s {\n  return {};\n}\n", name)
+	}
+	return fmt.Sprintf("\nfunction read%[1]s(r: GlintReader): %[1]s {\n  return {\n%[2]s  };\n}\n", name, read)
+}
+
+// generateProperties converts the fields of a schema to the body of a TypeScript interface, and of an object
+// literal reading its properties in the order they're written
+func (g *tsGenerator) generateProperties(fields []glint.SchemaField) (tsInterface, error) {
+	var body, read strings.Builder
+	for i := range fields {
+		field := &fields[i]
+
+		tsType, readValue, err := g.tsType(field, toGoFieldName(field.Name))
+		if err != nil {
+			return tsInterface{}, fmt.Errorf("failed to convert type for field %s: %v", field.Name, err)
+		}
+
+		name := field.Name
+		if !tsIdentifier.MatchString(name) {
+			name = strconv.Quote(name)
+		}
+
+		body.WriteString(fmt.Sprintf("  %s: %s;", name, tsType))
+		if opts := field.Options(); len(opts) > 0 {
+			body.WriteString(" // " + strings.Join(opts, ","))
+		}
+		body.WriteString("\n")
+		read.WriteString(fmt.Sprintf("    %s: %s,\n", name, readValue))
+	}
+	return tsInterface{body: body.String(), read: read.String()}, nil
+}
+
+// generateInterface generates a nested interface, returning its name. Interfaces with the same properties, read
+// the same way, share one name, and those that differ but would share a name are numbered.
+func (g *tsGenerator) generateInterface(typeName string, fields []glint.SchemaField) (string, error) {
+	generated, err := g.generateProperties(fields)
+	if err != nil {
+		return "", err
+	}
+
+	name := typeName
+	for n := 2; ; n++ {
+		existing, exists := g.interfaces[name]
+		if !exists && name != g.rootName {
+			break
+		}
+		if exists && existing == generated {
+			return name, nil
+		}
+		name = typeName + strconv.Itoa(n)
+	}
+
+	g.interfaces[name] = generated
+	g.order = append(g.order, name)
+	return name, nil
+}
+
+// tsType converts a schema field to a TypeScript type, and an expression reading its value from the GlintReader
+// r, naming any interface it needs after typeName
+func (g *tsGenerator) tsType(field *glint.SchemaField, typeName string) (string, string, error) {
+	tsType, read, err := g.tsValue(field, typeName)
+	if err != nil || field.Wire&glint.WirePtrFlag == 0 {
+		return tsType, read, err
+	}
+	return tsType + " | null", "(r.present() ? " + read + " : null)", nil
+}
+
+// tsValue is tsType for the value of a field, without the presence byte of those that are nullable
+func (g *tsGenerator) tsValue(field *glint.SchemaField, typeName string) (string, string, error) {
+	wire := field.Wire &^ glint.WirePtrFlag
+
+	switch {
+	case wire&glint.WireSliceFlag > 0:
+		elementType, readElement, err := g.tsType(field.Elem, strings.TrimSuffix(typeName, "Item")+"Item")
+		if err != nil {
+			return "", "", err
+		}
+		if strings.Contains(elementType, " ") {
+			elementType = "(" + elementType + ")"
+		}
+
+		switch elem := field.Elem.Wire & glint.WireTypeMask; {
+		case wire&glint.WireBitmapFlag > 0:
+			return elementType + "[]", "r.bitmap()", nil
+		case wire&glint.WireSparseFlag > 0:
+			return elementType + "[]", fmt.Sprintf("(r.sparse(%d) as %s[])", elem, elementType), nil
+		case wire&glint.WireDeltaFlag > 0:
+			return elementType + "[]", fmt.Sprintf("(r.delta(%d) as %s[])", elem, elementType), nil
+		case wire&glint.WireDictFlag > 0:
+			readElement = "r.table()"
+		}
+		return elementType + "[]", "r.slice(() => " + readElement + ")", nil
+	}
+
+	switch wire & glint.WireTypeMask {
+	case glint.WireStruct:
+		name, err := g.generateInterface(typeName, field.Fields)
+		return name, "read" + name + "(r)", err
+
+	case glint.WireMap:
+		keyType, readKey, err := g.tsType(field.Key, typeName+"Key")
+		if err != nil {
+			return "", "", fmt.Errorf("invalid map key type: %v", err)
+		}
+		valueType, readValue, err := g.tsType(field.Value, typeName+"Value")
+		if err != nil {
+			return "", "", fmt.Errorf("invalid map value type: %v", err)
+		}
+		return fmt.Sprintf("Map<%s, %s>", keyType, valueType), fmt.Sprintf("r.map(() => %s, () => %s)", readKey, readValue), nil
+	}
+
+	baseType, err := tsBaseType(wire & glint.WireTypeMask)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case wire == glint.WireString|glint.WireDictFlag:
+		return baseType, "r.table()", nil
+	case wire&glint.WireZigzagFlag > 0:
+		return baseType, "r.int()", nil // zigzag int64s and durations are written as ints are
+	}
+	return baseType, "r." + tsReaders[wire&glint.WireTypeMask] + "()", nil
+}
+
+// tsBaseType converts a base wire type to a TypeScript type
+func tsBaseType(wireType glint.WireType) (string, error) {
+	switch wireType {
+	case glint.WireBool:
+		return "boolean", nil
+	case glint.WireInt8, glint.WireInt16, glint.WireInt32, glint.WireUint8, glint.WireUint16, glint.WireUint32,
+		glint.WireFloat32, glint.WireFloat64:
+		return "number", nil
+	case glint.WireInt, glint.WireInt64, glint.WireUint, glint.WireUint64, glint.WireBigInt:
+		return "bigint", nil
+	case glint.WireDuration:
+		return "bigint", nil // nanoseconds
+	case glint.WireString, glint.WireIP, glint.WireIPPrefix, glint.WireDecimal:
+		return "string", nil
+	case glint.WireBytes:
+		return "Uint8Array", nil
+	case glint.WireTime:
+		return "Date", nil
+	case glint.WireDynamic:
+		return "unknown", nil // elements of slices of any type
+	default:
+		return "", fmt.Errorf("unsupported wire type: %v", wireType)
+	}
+}
+
+// tsReaders holds the GlintReader method reading each base wire type
+var tsReaders = map[glint.WireType]string{
+	glint.WireBool: "bool", glint.WireInt: "int", glint.WireInt8: "int8", glint.WireInt16: "int16",
+	glint.WireInt32: "int32", glint.WireInt64: "int64", glint.WireUint: "uint", glint.WireUint8: "uint8",
+	glint.WireUint16: "uint16", glint.WireUint32: "uint32", glint.WireUint64: "uint64",
+	glint.WireFloat32: "float32", glint.WireFloat64: "float64", glint.WireString: "string",
+	glint.WireBytes: "bytes", glint.WireTime: "time", glint.WireDuration: "duration", glint.WireIP: "ip",
+	glint.WireIPPrefix: "prefix", glint.WireBigInt: "bigInt", glint.WireDecimal: "decimal",
+	glint.WireDynamic: "dynamic",
+}
+
+// tsRuntime is the reader the generated decode functions share, written after them. It follows the layouts of
+// glint_specification.md, which the Python client's reader follows too, and needs an ES2020 target for bigints.
+const tsRuntime = `
+// GlintReader reads the values of a glint document's body, throwing when they run past its end
+class GlintReader {
+  pos = 0;
+
+  constructor(private readonly b: Uint8Array, private readonly strings: string[] = []) {}
+
+  left(): number {
+    return this.b.length - this.pos;
+  }
+
+  read(n: number): Uint8Array {
+    if (n > this.left()) {
+      throw new Error("glint: truncated document");
+    }
+    return this.b.subarray(this.pos, (this.pos += n));
+  }
+
+  byte(): number {
+    return this.read(1)[0];
+  }
+
+  // varint reads an unsigned varint of up to 64 bits
+  varint(): bigint {
+    let v = 0n;
+    for (let shift = 0n; shift <= 63n; shift += 7n) {
+      const b = this.byte();
+      v |= BigInt(b & 0x7f) << shift;
+      if (b < 0x80) {
+        return BigInt.asUintN(64, v);
+      }
+    }
+    throw new Error("glint: varint longer than 10 bytes");
+  }
+
+  // length reads the length of a slice or map, refusing those beyond the limit glint decoders default to
+  length(): number {
+    const n = this.varint();
+    if (n > 10_000_000n) {
+      throw new Error(` + "`glint: length ${n} exceeds the limit of 10000000`" + `);
+    }
+    return Number(n);
+  }
+
+  // chunk reads bytes written after their length
+  chunk(): Uint8Array {
+    const n = this.varint();
+    if (n > BigInt(this.left())) {
+      throw new Error("glint: truncated document");
+    }
+    return this.read(Number(n));
+  }
+
+  end(): void {
+    if (this.left() > 0) {
+      throw new Error(` + "`glint: ${this.left()} body bytes remaining`" + `);
+    }
+  }
+
+  present(): boolean {
+    return this.byte() !== 0;
+  }
+
+  bool(): boolean {
+    return this.byte() === 1;
+  }
+
+  int(): bigint {
+    return BigInt.asIntN(64, glintUnzigzag(this.varint()));
+  }
+
+  int8(): number {
+    return (this.byte() << 24) >> 24;
+  }
+
+  int16(): number {
+    return Number(BigInt.asIntN(16, glintUnzigzag(this.varint())));
+  }
+
+  int32(): number {
+    return Number(BigInt.asIntN(32, glintUnzigzag(this.varint())));
+  }
+
+  int64(): bigint {
+    return BigInt.asIntN(64, this.varint());
+  }
+
+  uint(): bigint {
+    return this.varint();
+  }
+
+  uint8(): number {
+    return this.byte();
+  }
+
+  uint16(): number {
+    return Number(BigInt.asUintN(16, this.varint()));
+  }
+
+  uint32(): number {
+    return Number(BigInt.asUintN(32, this.varint()));
+  }
+
+  uint64(): bigint {
+    return this.varint();
+  }
+
+  float32(): number {
+    return glintFloat(32, BigInt.asUintN(32, this.varint()));
+  }
+
+  float64(): number {
+    return glintFloat(64, this.varint());
+  }
+
+  string(): string {
+    return glintText.decode(this.chunk());
+  }
+
+  bytes(): Uint8Array {
+    return this.chunk().slice();
+  }
+
+  // time reads the layout of Go's time.Time.MarshalBinary, dropping the zone, which Dates don't have
+  time(): Date {
+    const b = this.chunk();
+    if (b.length === 0) {
+      return new Date(-62135596800000); // Go's zero time, the start of year 1
+    }
+    if (!(b[0] === 1 && b.length === 15) && !(b[0] === 2 && b.length === 16)) {
+      throw new Error("glint: unsupported time encoding");
+    }
+    const v = new DataView(b.buffer, b.byteOffset, b.byteLength);
+    return new Date(Number(v.getBigInt64(1) - 62135596800n) * 1000 + Math.floor(v.getInt32(9) / 1e6));
+  }
+
+  // duration reads nanoseconds
+  duration(): bigint {
+    return this.int64();
+  }
+
+  ip(): string {
+    return glintAddress(this.chunk());
+  }
+
+  prefix(): string {
+    const b = this.chunk();
+    return b.length === 0 ? "" : ` + "`${glintAddress(b.subarray(0, -1))}/${b[b.length - 1]}`" + `;
+  }
+
+  bigInt(): bigint {
+    return glintBigInt(this.chunk());
+  }
+
+  // decimal reads a decimal as Go's glint.Decimal.String writes it
+  decimal(): string {
+    const d = new GlintReader(this.chunk());
+    const exponent = Number(BigInt.asIntN(32, glintUnzigzag(d.varint())));
+    const c = glintBigInt(d.read(d.left()));
+    const sign = c < 0n ? "-" : "";
+    let digits = (c < 0n ? -c : c).toString();
+    if (exponent >= 0) {
+      return sign + digits + "0".repeat(exponent);
+    }
+    if (digits.length <= -exponent) {
+      digits = "0".repeat(-exponent - digits.length + 1) + digits;
+    }
+    return ` + "`${sign}${digits.slice(0, exponent)}.${digits.slice(exponent)}`" + `;
+  }
+
+  // dynamic reads a value written with its own wire type, only primitives, pointers to them and slices of them
+  dynamic(): unknown {
+    let wire = Number(this.varint());
+    if (wire & 64) {
+      wire &= ~64;
+      if (this.byte() === 0) {
+        return null;
+      }
+    }
+
+    const elem = wire & ~32;
+    if (!(wire & 32)) {
+      if (elem < 1 || elem > 23 || elem === 15 || elem === 16 || elem === 17) {
+        throw new Error(` + "`glint: unsupported dynamic wire type ${wire}`" + `);
+      }
+      return this.primitive(elem);
+    }
+    if (elem < 1 || elem > 19 || elem === 16 || elem === 17) {
+      throw new Error(` + "`glint: unsupported dynamic wire type ${wire}`" + `);
+    }
+
+    const n = this.length();
+    if (elem === 8) {
+      return Array.from(this.chunk()); // written as bytes, after the length
+    }
+    const s: unknown[] = [];
+    for (let i = 0; i < n; i++) {
+      s.push(this.primitive(elem));
+    }
+    return s;
+  }
+
+  primitive(wire: number): unknown {
+    switch (wire) {
+      case 1: return this.bool();
+      case 2: return this.int();
+      case 3: return this.int8();
+      case 4: return this.int16();
+      case 5: return this.int32();
+      case 6: return this.int64();
+      case 7: return this.uint();
+      case 8: return this.uint8();
+      case 9: return this.uint16();
+      case 10: return this.uint32();
+      case 11: return this.uint64();
+      case 12: return this.float32();
+      case 13: return this.float64();
+      case 14: return this.string();
+      case 15: return this.bytes();
+      case 18: return this.time();
+      case 19: return this.duration();
+      case 20: return this.ip();
+      case 21: return this.prefix();
+      case 22: return this.bigInt();
+      case 23: return this.decimal();
+    }
+    throw new Error(` + "`glint: unsupported wire type ${wire}`" + `);
+  }
+
+  // table reads a string written to the document's string table
+  table(): string {
+    const i = this.varint();
+    if (i >= BigInt(this.strings.length)) {
+      throw new Error(` + "`glint: string table entry ${i} out of range`" + `);
+    }
+    return this.strings[Number(i)];
+  }
+
+  slice<T>(read: () => T): T[] {
+    const s: T[] = [];
+    for (let n = this.length(); n > 0; n--) {
+      s.push(read());
+    }
+    return s;
+  }
+
+  map<K, V>(key: () => K, value: () => V): Map<K, V> {
+    const m = new Map<K, V>();
+    for (let n = this.length(); n > 0; n--) {
+      const k = key();
+      m.set(k, value());
+    }
+    return m;
+  }
+
+  // bitmap reads bools packed eight to a byte, or a byte each when they weren't packed
+  bitmap(): boolean[] {
+    const header = this.varint();
+    if (header >> 1n > 10_000_000n) {
+      throw new Error(` + "`glint: length ${header >> 1n} exceeds the limit of 10000000`" + `);
+    }
+    const n = Number(header >> 1n);
+    if (header & 1n) {
+      const b = this.read(Math.ceil(n / 8));
+      return Array.from({ length: n }, (_, i) => (b[i >> 3] & (1 << (i & 7))) !== 0);
+    }
+    return Array.from(this.read(n), (b) => b !== 0);
+  }
+
+  // sparse reads a slice written without its zeros
+  sparse(wire: number): unknown[] {
+    const length = this.length();
+    const count = this.varint();
+    if (count > BigInt(length)) {
+      throw new Error(` + "`glint: sparse slice holds ${count} values but has a length of ${length}`" + `);
+    }
+
+    const s = new Array(length).fill(new GlintReader(Uint8Array.of(0)).primitive(wire));
+    for (let i = 0, n = 0; n < Number(count); i++, n++) {
+      if (count !== BigInt(length)) {
+        i += Number(this.varint());
+      }
+      if (i >= length) {
+        throw new Error(` + "`glint: sparse slice index ${i} out of range for length ${length}`" + `);
+      }
+      s[i] = this.primitive(wire);
+    }
+    return s;
+  }
+
+  // delta reads a slice of integers written as differences, or of floats written as XORs
+  delta(wire: number): unknown[] {
+    const n = this.length();
+    const s: unknown[] = [];
+    if (n === 0) {
+      return s;
+    }
+
+    if (wire === 12 || wire === 13) {
+      const bits = wire === 12 ? 32 : 64;
+      let prev = BigInt.asUintN(bits, this.varint());
+      for (let i = 0; ; i++) {
+        s.push(glintFloat(bits, prev));
+        if (i === n - 1) {
+          return s;
+        }
+        prev ^= BigInt.asUintN(bits, this.floatXOR());
+      }
+    }
+
+    const width = glintWidths[wire];
+    if (width === undefined || width[0] === 8) {
+      throw new Error(` + "`glint: delta encoding not supported for wire type ${wire}`" + `);
+    }
+    const [bits, signed] = width;
+    let v = BigInt(this.primitive(wire) as number | bigint);
+    for (let i = 0; ; i++) {
+      s.push(bits === 64 ? v : Number(v));
+      if (i === n - 1) {
+        return s;
+      }
+      v += glintUnzigzag(this.varint());
+      v = signed ? BigInt.asIntN(bits, v) : BigInt.asUintN(bits, v);
+    }
+  }
+
+  // floatXOR reads up to 70 bits, the XOR shifted right past its trailing zeros, with the count of them in the
+  // low 6 bits
+  floatXOR(): bigint {
+    let v = 0n;
+    for (let shift = 0n; shift <= 63n; shift += 7n) {
+      const b = this.byte();
+      v |= BigInt(b & 0x7f) << shift;
+      if (b < 0x80) {
+        return BigInt.asUintN(64, (v >> 6n) << (v & 63n));
+      }
+    }
+    throw new Error("glint: float delta longer than 10 bytes");
+  }
+}
+
+// glintWidths holds the width in bits of each integer wire type, and whether it's signed
+const glintWidths: Record<number, [number, boolean]> = {
+  2: [64, true], 3: [8, true], 4: [16, true], 5: [32, true], 6: [64, true], 19: [64, true],
+  7: [64, false], 8: [8, false], 9: [16, false], 10: [32, false], 11: [64, false],
+};
+
+const glintText = new TextDecoder();
+const glintScratch = new DataView(new ArrayBuffer(8));
+let glintCRCTable: Uint32Array | undefined;
+
+// glintBody checks a document was written with the schema whose ID is schemaID, and returns a reader over its body
+function glintBody(doc: Uint8Array, schemaID: number): GlintReader {
+  if (doc.length < 6) {
+    throw new Error("glint: document too short");
+  }
+  const flags = doc[0];
+  if ((flags & 0b111) > 4) {
+    throw new Error(` + "`glint: unsupported document version ${flags & 0b111}`" + `);
+  }
+  if (flags >> 4 !== 0) {
+    throw new Error("glint: compressed documents aren't supported, decompress them with the glint package first");
+  }
+
+  const r = new GlintReader(doc);
+  const hash = new DataView(doc.buffer, doc.byteOffset).getUint32(1, true);
+  r.read(5);
+  const schema = r.chunk();
+  if ((schema.length === 0 ? hash : glintCRC32(doc.subarray(5, r.pos))) !== schemaID) {
+    throw new Error("glint: document written with a schema other than the one its types were generated from");
+  }
+  if (schema.length > 0 && (flags & 0b111) >= 3) {
+    r.chunk(); // the hashes of the field names, which only speed up finding fields by name
+  }
+
+  let body = r.read(r.left());
+  let strings: string[] = [];
+  if (flags & 0b1000 && body.length > 0) {
+    if (body.length < 4) {
+      throw new Error("glint: string table size missing");
+    }
+    const size = new DataView(body.buffer, body.byteOffset).getUint32(body.length - 4, true);
+    if (size > body.length - 4) {
+      throw new Error("glint: string table size exceeds the body");
+    }
+    const t = new GlintReader(body.subarray(body.length - 4 - size, body.length - 4));
+    strings = t.slice(() => t.string());
+    body = body.subarray(0, body.length - 4 - size);
+  }
+  return new GlintReader(body, strings);
+}
+
+function glintUnzigzag(v: bigint): bigint {
+  return (v >> 1n) ^ -(v & 1n);
+}
+
+function glintFloat(bits: number, v: bigint): number {
+  if (bits === 32) {
+    glintScratch.setUint32(0, Number(v));
+    return glintScratch.getFloat32(0);
+  }
+  glintScratch.setBigUint64(0, v);
+  return glintScratch.getFloat64(0);
+}
+
+function glintBigInt(b: Uint8Array): bigint {
+  let v = 0n;
+  for (const x of b.subarray(1)) {
+    v = (v << 8n) | BigInt(x);
+  }
+  return b[0] === 1 ? -v : v;
+}
+
+// glintAddress formats an IP address as Go's netip.Addr.String does, empty for the zero address
+function glintAddress(b: Uint8Array): string {
+  if (b.length === 0) {
+    return "";
+  }
+  if (b.length === 4) {
+    return b.join(".");
+  }
+  if (b.length !== 16) {
+    throw new Error(` + "`glint: invalid IP address of ${b.length} bytes`" + `);
+  }
+  if (b.subarray(0, 10).every((x) => x === 0) && b[10] === 0xff && b[11] === 0xff) {
+    return "::ffff:" + b.subarray(12).join(".");
+  }
+
+  const groups = Array.from({ length: 8 }, (_, i) => ((b[2 * i] << 8) | b[2 * i + 1]).toString(16));
+  let start = -1;
+  let run = 1; // the longest run of zero groups, of two or more, is written as ::
+  for (let i = 0; i < 8; i++) {
+    let j = i;
+    while (j < 8 && groups[j] === "0") {
+      j++;
+    }
+    if (j - i > run) {
+      start = i;
+      run = j - i;
+    }
+  }
+  if (start < 0) {
+    return groups.join(":");
+  }
+  return groups.slice(0, start).join(":") + "::" + groups.slice(start + run).join(":");
+}
+
+function glintCRC32(b: Uint8Array): number {
+  if (glintCRCTable === undefined) {
+    glintCRCTable = new Uint32Array(256);
+    for (let n = 0; n < 256; n++) {
+      let c = n;
+      for (let k = 0; k < 8; k++) {
+        c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+      }
+      glintCRCTable[n] = c;
+    }
+  }
+  let c = 0xffffffff;
+  for (const x of b) {
+    c = glintCRCTable[(c ^ x) & 0xff] ^ (c >>> 8);
+  }
+  return (c ^ 0xffffffff) >>> 0;
+}
+`
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kungfusheep/glint"
+)
+
+func TestCLITypeScriptGenerator(t *testing.T) {
+	type Address struct {
+		City string `glint:"city"`
+	}
+	type Order struct {
+		ID        int64              `glint:"id,min=1"`
+		Count     int32              `glint:"count"`
+		Readings  []int64            `glint:"readings,delta"`
+		Note      *string            `glint:"note"`
+		Created   time.Time          `glint:"created"`
+		Blob      []byte             `glint:"blob"`
+		Home      Address            `glint:"home"`
+		Previous  []Address          `glint:"previous"`
+		Addresses map[string]Address `glint:"addresses"`
+		Dashed    bool               `glint:"is-dashed"`
+	}
+
+	result, err := GenerateTypeScript(glint.NewEncoder[Order](glint.WithDistinctNilSlices()).MarshalBytes(&Order{}), "Order", false)
+	if err != nil {
+		t.Fatalf("GenerateTypeScript failed: %v", err)
+	}
+
+	expected := `export interface Home {
+  city: string;
+}
+
+export interface PreviousItem {
+  city: string;
+}
+
+export interface AddressesValue {
+  city: string;
+}
+
+export interface Order {
+  id: bigint; // min=1
+  count: number;
+  readings: bigint[] | null; // delta
+  note: string | null;
+  created: Date;
+  blob: Uint8Array | null;
+  home: Home;
+  previous: PreviousItem[] | null;
+  addresses: Map<string, AddressesValue>;
+  "is-dashed": boolean;
+}
+`
+	if result != expected {
+		t.Errorf("GenerateTypeScript returned\n%s\nexpected\n%s", result, expected)
+	}
+}
+
+func TestCLITypeScriptGeneratorSchemaBytes(t *testing.T) {
+	type User struct {
+		Name string `glint:"name"`
+		User struct {
+			Age int `glint:"age"`
+		} `glint:"user"`
+	}
+
+	result, err := GenerateTypeScript(glint.NewEncoder[User]().Schema().Bytes, "User", false)
+	if err != nil {
+		t.Fatalf("GenerateTypeScript failed: %v", err)
+	}
+
+	// the nested struct can't take the name of the root interface
+	for _, want := range []string{"export interface User2 {", "  user: User2;", "export interface User {"} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %s in result:\n%s", want, result)
+		}
+	}
+}
+
+func TestCLITypeScriptGeneratorErrorHandling(t *testing.T) {
+	if _, err := GenerateTypeScript([]byte{0x01, 0x02, 0x03}, "Invalid", false); err == nil {
+		t.Error("Expected error for invalid glint data")
+	}
+}
+
+func TestCLITypeScriptGeneratorDecoders(t *testing.T) {
+	type Line struct {
+		SKU string `glint:"sku,dict"`
+		Qty uint16 `glint:"qty"`
+	}
+	type Order struct {
+		ID     int64            `glint:"id"`
+		Lines  []*Line          `glint:"lines"`
+		Counts []uint32         `glint:"counts,sparse"`
+		Offset int64            `glint:"offset,zigzag"`
+		Note   *string          `glint:"note"`
+		Totals map[string][]int `glint:"totals"`
+	}
+
+	enc := glint.NewEncoder[Order]()
+	result, err := GenerateTypeScript(enc.MarshalBytes(&Order{}), "Order", true)
+	if err != nil {
+		t.Fatalf("GenerateTypeScript failed: %v", err)
+	}
+
+	expected := fmt.Sprintf(`export interface LinesItem {
+  sku: string; // dict
+  qty: number;
+}
+
+export interface Order {
+  id: bigint;
+  lines: (LinesItem | null)[];
+  counts: number[]; // sparse
+  offset: bigint; // zigzag
+  note: string | null;
+  totals: Map<string, bigint[]>;
+}
+
+// decodeOrder reads a glint document written with the schema Order was generated from
+export function decodeOrder(doc: Uint8Array): Order {
+  const r = glintBody(doc, 0x%08x);
+  const v = readOrder(r);
+  r.end();
+  return v;
+}
+
+function readLinesItem(r: GlintReader): LinesItem {
+  return {
+    sku: r.table(),
+    qty: r.uint16(),
+  };
+}
+
+function readOrder(r: GlintReader): Order {
+  return {
+    id: r.int64(),
+    lines: r.slice(() => (r.present() ? readLinesItem(r) : null)),
+    counts: (r.sparse(10) as number[]),
+    offset: r.int(),
+    note: (r.present() ? r.string() : null),
+    totals: r.map(() => r.string(), () => r.slice(() => r.int())),
+  };
+}
+`, crc32.ChecksumIEEE(enc.Schema().Bytes))
+	if !strings.HasPrefix(result, expected) {
+		t.Errorf("GenerateTypeScript returned\n%s\nexpected it to start with\n%s", result, expected)
+	}
+	if !strings.Contains(result, "class GlintReader {") || !strings.HasSuffix(result, "}\n") {
+		t.Error("expected the reader to follow the decode functions")
+	}
+
+	// the ID is the same generated from bare schema bytes or from documents with name hashes, and documents
+	// written with a schema registry carry it in their header
+	id := crc32.ChecksumIEEE(enc.Schema().Bytes)
+	for name, doc := range map[string][]byte{
+		"schema": enc.Schema().Bytes,
+		"hashes": glint.NewEncoder[Order](glint.WithNameHashes()).MarshalBytes(&Order{}),
+	} {
+		if got := schemaID(doc); got != id {
+			t.Errorf("%s: expected schema ID %08x, got %08x", name, id, got)
+		}
+	}
+	registered := glint.NewEncoder[Order](glint.WithSchemaRegistry(glint.NewSchemaRegistry(nil))).MarshalBytes(&Order{})
+	if got := glint.Document(registered).Hash(); got != id {
+		t.Errorf("expected registry documents to carry schema ID %08x, got %08x", id, got)
+	}
+}
+
+func TestCLITypeScriptGeneratorGoType(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs a program to read the schema")
+	}
+
+	// a package within the module, which the program reading the schema can import
+	dir, err := os.MkdirTemp(".", "tsmodels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	source := `package models
+
+type Line struct {
+	SKU string ` + "`glint:\"sku\"`" + `
+}
+
+type Order struct {
+	ID    int64   ` + "`glint:\"id\"`" + `
+	Lines []*Line ` + "`glint:\"lines\"`" + `
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "models.go"), []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	type Line struct {
+		SKU string `glint:"sku"`
+	}
+	type Order struct {
+		ID    int64   `glint:"id"`
+		Lines []*Line `glint:"lines"`
+	}
+
+	schema, err := goTypeSchema("./"+dir, "Order")
+	if err != nil {
+		t.Fatalf("goTypeSchema failed: %v", err)
+	}
+	if !bytes.Equal(schema, glint.NewEncoder[Order]().Schema().Bytes) {
+		t.Errorf("expected the schema of Order, got %v", schema)
+	}
+
+	result, err := GenerateTypeScript(schema, "Order", false)
+	if err != nil {
+		t.Fatalf("GenerateTypeScript failed: %v", err)
+	}
+	if !strings.Contains(result, "  lines: (LinesItem | null)[];") {
+		t.Errorf("expected the lines of the order in result:\n%s", result)
+	}
+
+	if _, err := goTypeSchema("./"+dir, "Missing"); err == nil || !strings.Contains(err.Error(), "undefined: target.Missing") {
+		t.Errorf("expected an error for a type the package doesn't declare, got %v", err)
+	}
+	if _, err := goTypeSchema(".", "GenCmd"); err == nil || !strings.Contains(err.Error(), "main package") {
+		t.Errorf("expected an error for a type in a main package, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kungfusheep/glint"
+)
+
+// ValidateCmd checks documents are well formed, within limits and, optionally, written with an expected schema
+type ValidateCmd struct {
+	maxDepth    uint
+	maxBytes    byteSize
+	maxElements uint
+	schema      string
+	strict      bool
+	flags       *flag.FlagSet
+}
+
+func (v *ValidateCmd) Name() string { return "validate" }
+
+func (v *ValidateCmd) DefineFlags(fs *flag.FlagSet) {
+	v.maxBytes = byteSize(glint.DefaultLimits.MaxDocumentSize)
+	fs.UintVar(&v.maxDepth, "max-depth", glint.DefaultLimits.MaxNestingDepth, "Maximum nesting of structs, slices and maps")
+	fs.Var(&v.maxBytes, "max-bytes", "Maximum document size, as in 10MB")
+	fs.UintVar(&v.maxElements, "max-elements", glint.DefaultLimits.MaxSliceElements, "Maximum elements in any slice or entries in any map")
+	fs.StringVar(&v.schema, "schema", "", "Document or schema file the documents must be readable as")
+	fs.BoolVar(&v.strict, "strict", false, "Require the exact schema of --schema, with no extra fields, encodings or reordering")
+	v.flags = fs
+}
+
+func (v *ValidateCmd) Execute(args []string) error {
+	files, err := interspersedArgs(v.flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if v.strict && v.schema == "" {
+		return fmt.Errorf("--strict needs an expected --schema")
+	}
+
+	var expected *glint.Schema
+	if v.schema != "" {
+		data, err := os.ReadFile(v.schema)
+		if err != nil {
+			return fmt.Errorf("error reading schema: %v", err)
+		}
+		if expected, err = loadSchema(data); err != nil {
+			return fmt.Errorf("error reading schema %s: %v", v.schema, err)
+		}
+	}
+
+	limits := glint.DefaultLimits
+	limits.MaxNestingDepth, limits.MaxSchemaDepth = v.maxDepth, v.maxDepth
+	limits.MaxDocumentSize = uint(v.maxBytes)
+	limits.MaxSliceElements, limits.MaxMapEntries = v.maxElements, v.maxElements
+
+	failed := 0
+	for _, file := range files {
+		doc, err := readInput([]string{file})
+		if err != nil {
+			return err
+		}
+		if err := validateDocument(doc, limits, expected, v.strict); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", file, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", file)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed validation", failed, len(files))
+	}
+	return nil
+}
+
+// validateDocument checks doc is well formed and within limits, then that it has the fields of expected, when
+// there is one. Documents may have fields expected doesn't, in any order, as decoders allow, unless strict, in which
+// case the schemas have to be identical.
+func validateDocument(doc []byte, limits glint.DecodeLimits, expected *glint.Schema, strict bool) error {
+	if limits.MaxDocumentSize > 0 && uint(len(doc)) > limits.MaxDocumentSize {
+		return fmt.Errorf("%w: document size %d exceeds limit %d", glint.ErrLimitExceeded, len(doc), limits.MaxDocumentSize)
+	}
+	if err := glint.Document(doc).Validate(limits); err != nil {
+		return err
+	}
+	if expected == nil {
+		return nil
+	}
+
+	schema, err := glint.ParseSchema(doc)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, c := range diffSchemaFields("", expected.Fields, schema.Fields, nil) {
+		if c.Breaking || strict {
+			problems = append(problems, c.Description())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("schema doesn't match the expected schema:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// byteSize is a flag holding a number of bytes, written with an optional unit: B, KB, MB or GB, in multiples of 1024
+type byteSize uint
+
+func (b *byteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		size   uint
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if uint(*b) >= u.size && uint(*b)%u.size == 0 {
+			return strconv.FormatUint(uint64(uint(*b)/u.size), 10) + u.suffix
+		}
+	}
+	return strconv.FormatUint(uint64(*b), 10) + "B"
+}
+
+func (b *byteSize) Set(s string) error {
+	n := strings.TrimSpace(strings.ToUpper(s))
+	size := uint64(1)
+	for _, u := range []struct {
+		suffix string
+		size   uint64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(n, u.suffix) {
+			n, size = strings.TrimSpace(strings.TrimSuffix(n, u.suffix)), u.size
+			break
+		}
+	}
+
+	v, err := strconv.ParseUint(n, 10, 64)
+	if err != nil || v > uint64(^uint(0))/size {
+		return fmt.Errorf("invalid size %q, expected a number of bytes such as 512, 64KB or 10MB", s)
+	}
+	*b = byteSize(v * size)
+	return nil
+}
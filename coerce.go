@@ -0,0 +1,152 @@
+package glint
+
+import "unsafe"
+
+// WithNumericCoercion decodes numbers into fields of a wider numeric type than they were written as, such as an
+// int32 into an int64 field, rather than refusing them with a *FieldTypeError. Only conversions that can't lose
+// anything are made: integers into wider integers, unsigned integers into wider signed ones, integers into floats
+// with the precision to hold them, and float32s into float64s. Narrowing, and signed integers into unsigned ones,
+// remain errors. It applies to struct fields, and pointers to them, but not to the elements of slices and maps.
+func WithNumericCoercion() DecoderOption {
+	return func(d *decoderImpl) {
+		d.eachDecoder(func(d *decoderImpl) {
+			d.coerce = true
+		})
+	}
+}
+
+// numericWire describes a numeric wire type, for working out which can be read into which without loss
+type numericWire struct {
+	signed, float bool
+	bits          int // bits of precision, the mantissa of floats
+}
+
+var numericWires = map[WireType]numericWire{
+	WireInt8:    {signed: true, bits: 8},
+	WireInt16:   {signed: true, bits: 16},
+	WireInt32:   {signed: true, bits: 32},
+	WireInt64:   {signed: true, bits: 64},
+	WireInt:     {signed: true, bits: 64},
+	WireUint8:   {bits: 8},
+	WireUint16:  {bits: 16},
+	WireUint32:  {bits: 32},
+	WireUint64:  {bits: 64},
+	WireUint:    {bits: 64},
+	WireFloat32: {float: true, signed: true, bits: 24},
+	WireFloat64: {float: true, signed: true, bits: 53},
+}
+
+// widens reports whether every value of the wire type from can be held by a field of the wire type to
+func widens(from, to WireType) bool {
+	f, ok := numericWires[from]
+	t, ok2 := numericWires[to]
+	switch {
+	case !ok || !ok2 || from == to:
+		return false
+	case f.float:
+		return t.float && t.bits >= f.bits
+	case t.float, f.signed != t.signed:
+		return t.signed && t.bits > f.bits // the sign bit, or the integer's top bit, needs room of its own
+	}
+	return t.bits >= f.bits
+}
+
+// coercedField returns the instruction function reading a number written as the wire type from into a field of
+// di's type, when it widens, see WithNumericCoercion
+func coercedField(di decodeInstruction, from WireType) (func(unsafe.Pointer, Reader) Reader, bool) {
+	to := di.kind &^ wireCustom
+	if from&WirePtrFlag != to&WirePtrFlag || !widens(from&^WirePtrFlag, to&^WirePtrFlag) {
+		return nil, false
+	}
+
+	read, write := numberReader(from&^WirePtrFlag), numberWriter(to&^WirePtrFlag)
+	fun := func(p unsafe.Pointer, r Reader) Reader {
+		write(p, read(&r))
+		return r
+	}
+	if from&WirePtrFlag > 0 {
+		fun = deref(fun, from, di.subType)
+	}
+	return fun, true
+}
+
+// coercedNumber holds a value of any numeric wire type while it's converted to another
+type coercedNumber struct {
+	i int64
+	u uint64
+	f float64
+	k numericWire
+}
+
+// numberReader returns a function reading a number of the wire type w
+func numberReader(w WireType) func(r *Reader) coercedNumber {
+	k := numericWires[w]
+	return func(r *Reader) coercedNumber {
+		n := coercedNumber{k: k}
+		switch w {
+		case WireInt8:
+			n.i = int64(r.ReadInt8())
+		case WireInt16:
+			n.i = int64(r.ReadInt16())
+		case WireInt32:
+			n.i = int64(r.ReadInt32())
+		case WireInt64:
+			n.i = r.ReadInt64()
+		case WireInt:
+			n.i = int64(r.ReadInt())
+		case WireUint8:
+			n.u = uint64(r.ReadUint8())
+		case WireUint16:
+			n.u = uint64(r.ReadUint16())
+		case WireUint32:
+			n.u = uint64(r.ReadUint32())
+		case WireUint64:
+			n.u = r.ReadUint64()
+		case WireUint:
+			n.u = uint64(r.ReadUint())
+		case WireFloat32:
+			n.f = float64(r.ReadFloat32())
+		case WireFloat64:
+			n.f = r.ReadFloat64()
+		}
+		return n
+	}
+}
+
+// numberWriter returns a function storing a number into a field of the wire type w
+func numberWriter(w WireType) func(p unsafe.Pointer, n coercedNumber) {
+	return func(p unsafe.Pointer, n coercedNumber) {
+		i, u, f := n.i, n.u, n.f
+		switch {
+		case n.k.float:
+			i, u = int64(f), uint64(f)
+		case n.k.signed:
+			u, f = uint64(i), float64(i)
+		default:
+			i, f = int64(u), float64(u)
+		}
+
+		switch w {
+		case WireInt16:
+			*(*int16)(p) = int16(i)
+		case WireInt32:
+			*(*int32)(p) = int32(i)
+		case WireInt64:
+			*(*int64)(p) = i
+		case WireInt:
+			*(*int)(p) = int(i)
+		case WireUint16:
+			*(*uint16)(p) = uint16(u)
+		case WireUint32:
+			*(*uint32)(p) = uint32(u)
+		case WireUint64:
+			*(*uint64)(p) = u
+		case WireUint:
+			*(*uint)(p) = uint(u)
+		case WireFloat32:
+			*(*float32)(p) = float32(f)
+		case WireFloat64:
+			*(*float64)(p) = f
+		}
+	}
+}
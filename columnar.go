@@ -0,0 +1,211 @@
+package glint
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// ColumnarEncoder writes a slice of structs a column at a time rather than a row at a time, every value of the
+// first field, then every value of the second, and so on. A slice of T is written as a document holding a slice
+// for each field of T, named after the field, so that
+//
+//	[]User{{ID: 1, Name: "ada"}, {ID: 2, Name: "bob"}}
+//
+// is written as the document of
+//
+//	struct {
+//		ID   []int64  `glint:"id"`
+//		Name []string `glint:"name"`
+//	}{ID: []int64{1, 2}, Name: []string{"ada", "bob"}}
+//
+// Values of the same field sit side by side, so the options of the field's tag apply to the whole column: an
+// ascending `id,delta` is written as the differences between neighbouring rows, and a repetitive `region,dict`
+// as references to the string table. Tags with options that only make sense for columns, such as `delta` on an
+// int field, are ignored when the type is written a row at a time. WithFieldOptions can choose them at runtime.
+//
+// Documents are read back into a []T with ColumnarDecoder. Being documents like any other, single columns can be
+// read with a Decoder for a struct holding only the columns it needs, tagged with the same options.
+type ColumnarEncoder[T any] struct {
+	impl    *encoderImpl
+	columns columnLayout
+}
+
+// ColumnarDecoder reads documents written by ColumnarEncoder back into a slice of structs
+type ColumnarDecoder[T any] struct {
+	impl    *decoderImpl
+	columns columnLayout
+}
+
+// columnLayout maps the fields of a row type onto the slices of the struct its columns are written from
+type columnLayout struct {
+	t      reflect.Type // the struct of columns, a slice for each field of the row
+	fields []taggedField
+	copies []func(dst, src unsafe.Pointer)
+}
+
+// columnOptions are the tag options carried from the fields of a row over to its columns, which describe how
+// values are written rather than what they are
+var columnOptions = []string{"delta", "sparse", "dict", "bitmap", "zigzag", "nozigzag", "copy"}
+
+// NewColumnarEncoder constructs an encoder that writes slices of the struct type T a column at a time, with the
+// same options as NewEncoder
+func NewColumnarEncoder[T any](opts ...EncoderOption) *ColumnarEncoder[T] {
+	var zero T
+	layout := newColumnLayout(reflect.TypeOf(zero))
+	impl := newRootEncoder(reflect.New(layout.t).Elem().Interface(), "glint", newEncoderConfig(opts))
+	return &ColumnarEncoder[T]{impl: impl, columns: layout}
+}
+
+// NewColumnarDecoder constructs a decoder that reads documents written by ColumnarEncoder into slices of T
+func NewColumnarDecoder[T any]() *ColumnarDecoder[T] {
+	return NewColumnarDecoderWithLimits[T](DefaultLimits)
+}
+
+// NewColumnarDecoderWithLimits constructs a columnar decoder with custom bounds checking limits
+func NewColumnarDecoderWithLimits[T any](limits DecodeLimits) *ColumnarDecoder[T] {
+	var zero T
+	layout := newColumnLayout(reflect.TypeOf(zero))
+	impl := newDecoderWithLimits(reflect.New(layout.t).Elem().Interface(), limits)
+	return &ColumnarDecoder[T]{impl: impl, columns: layout}
+}
+
+// Marshal encodes the rows of v a column at a time into the supplied buffer
+func (e *ColumnarEncoder[T]) Marshal(v []T, b *Buffer) {
+	columns := e.columns.split(unsafe.Pointer(unsafe.SliceData(v)), len(v), unsafe.Sizeof(*new(T)))
+	e.impl.marshalDocument(columns, b, len(b.Bytes))
+}
+
+// Schema retrieves the schema of the columns this encoder writes, excluding version and hash bytes
+func (e *ColumnarEncoder[T]) Schema() *Buffer {
+	return e.impl.Schema()
+}
+
+// Unmarshal decodes a document written a column at a time into v, reusing its capacity. Columns missing from the
+// document are left as zero values, and columns of differing lengths are an error.
+func (d *ColumnarDecoder[T]) Unmarshal(bytes []byte, v *[]T) error {
+	columns := reflect.New(d.columns.t)
+	if err := d.impl.Unmarshal(bytes, columns.Interface()); err != nil {
+		return err
+	}
+
+	rows := 0
+	for i := range d.columns.fields {
+		if l := columns.Elem().Field(i).Len(); l != rows && rows != 0 && l != 0 {
+			return fmt.Errorf("%w: column %q holds %d rows where others hold %d", ErrInvalidDocument, d.columns.fields[i].name, l, rows)
+		} else if l > 0 {
+			rows = l
+		}
+	}
+
+	if cap(*v) < rows {
+		*v = make([]T, rows)
+	} else {
+		var zero T
+		*v = (*v)[:rows]
+		for i := range *v {
+			(*v)[i] = zero
+		}
+	}
+
+	d.columns.join(columns.UnsafePointer(), unsafe.Pointer(unsafe.SliceData(*v)), rows, unsafe.Sizeof(*new(T)))
+	return nil
+}
+
+// newColumnLayout builds the struct of columns for the struct type row
+func newColumnLayout(row reflect.Type) columnLayout {
+	if row == nil || row.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("columnar encoding requires a struct type, got %v", row))
+	}
+
+	l := columnLayout{fields: taggedFields(row, "glint")}
+	columns := make([]reflect.StructField, len(l.fields))
+	for i, f := range l.fields {
+		tag := f.name
+		for _, opt := range columnOptions {
+			if f.opts.Contains(opt) {
+				tag += "," + opt
+			}
+		}
+
+		columns[i] = reflect.StructField{
+			Name: fmt.Sprintf("Column%d", i),
+			Type: reflect.SliceOf(f.Type),
+			Tag:  reflect.StructTag(fmt.Sprintf("glint:%q", tag)),
+		}
+		l.copies = append(l.copies, valueCopier(f.Type))
+	}
+
+	l.t = reflect.StructOf(columns)
+	return l
+}
+
+// split copies n rows of the given size, starting at rows, into a new struct of columns
+func (l columnLayout) split(rows unsafe.Pointer, n int, size uintptr) unsafe.Pointer {
+	columns := reflect.New(l.t).Elem()
+	for i, f := range l.fields {
+		column := reflect.MakeSlice(l.t.Field(i).Type, n, n)
+		columns.Field(i).Set(column)
+
+		data, elem := column.UnsafePointer(), f.Type.Size()
+		for j := 0; j < n; j++ {
+			l.copies[i](unsafe.Add(data, uintptr(j)*elem), unsafe.Add(rows, uintptr(j)*size+f.Offset))
+		}
+	}
+	return columns.Addr().UnsafePointer()
+}
+
+// join copies the columns of the struct at columns into n rows of the given size, starting at rows. Columns
+// shorter than n are left out.
+func (l columnLayout) join(columns unsafe.Pointer, rows unsafe.Pointer, n int, size uintptr) {
+	for i, f := range l.fields {
+		column := *(*sliceHeader)(unsafe.Add(columns, l.t.Field(i).Offset))
+		if column.Len < n {
+			continue
+		}
+
+		elem := f.Type.Size()
+		for j := 0; j < n; j++ {
+			l.copies[i](unsafe.Add(rows, uintptr(j)*size+f.Offset), unsafe.Add(column.Data, uintptr(j)*elem))
+		}
+	}
+}
+
+// valueCopier returns a function copying a value of type t from src to dst. Values without pointers are copied
+// as bytes, and the rest through reflection so that the garbage collector sees the pointers being written.
+func valueCopier(t reflect.Type) func(dst, src unsafe.Pointer) {
+	switch {
+	case pointerFree(t):
+		size := t.Size()
+		return func(dst, src unsafe.Pointer) {
+			copy(unsafe.Slice((*byte)(dst), size), unsafe.Slice((*byte)(src), size))
+		}
+	case t.Kind() == reflect.String:
+		return func(dst, src unsafe.Pointer) {
+			*(*string)(dst) = *(*string)(src)
+		}
+	}
+	return func(dst, src unsafe.Pointer) {
+		reflect.NewAt(t, dst).Elem().Set(reflect.NewAt(t, src).Elem())
+	}
+}
+
+// pointerFree reports whether values of type t hold no pointers at all
+func pointerFree(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return pointerFree(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !pointerFree(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package glint
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Document bodies can be compressed, leaving the header and schema as they are so that tools can still inspect a
+// document without decompressing it. The codec is recorded in the top four bits of the flags byte, and the body,
+// along with its string table when it has one, is replaced by its compressed form:
+//
+//	[flags][crc32][schema length][schema][compressed body]
+//
+// Glint doesn't depend on any compression library. Deflate, from the standard library, is built in, and other
+// codecs are plugged in by implementing Compressor and passing it to RegisterCompressor, or to WithCompression
+// which registers it too. Decoders decompress any document whose codec is registered, so Unmarshal, Walk, the
+// Printer and the CLI all read compressed documents without changes.
+//
+// Compression is decided per document. Bodies that don't shrink, typically small ones, are written uncompressed
+// with no codec in their flags, so an encoder can be given a compressor without penalising its smallest documents.
+
+// Codec identifies the compression of a document body in its flags byte
+type Codec byte
+
+const (
+	CodecNone    Codec = iota // the body isn't compressed
+	CodecDeflate              // compress/flate, built in, see DeflateCompressor
+	CodecZstd                 // reserved for Zstandard, register a Compressor to use it
+	CodecLZ4                  // reserved for LZ4, register a Compressor to use it
+	CodecSnappy               // reserved for Snappy, register a Compressor to use it
+
+	maxCodec Codec = 15 // codecs are written in four bits
+)
+
+// Compressor compresses and decompresses document bodies in one format. Implementations must be safe for
+// concurrent use.
+type Compressor interface {
+	// Codec returns the codec recorded in documents compressed by this Compressor
+	Codec() Codec
+
+	// Compress appends the compressed form of src to dst
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed form of src to dst. It must fail rather than produce more than limit
+	// bytes, so that small hostile documents can't expand into huge ones. limit is 0 when there's no limit.
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+// ErrUnknownCodec is returned when a document is compressed with a codec that has no registered Compressor
+var ErrUnknownCodec = errors.New("glint: no compressor registered for codec")
+
+// compressors holds the Compressor registered for each codec
+var compressors = struct {
+	sync.RWMutex
+	byCodec [maxCodec + 1]Compressor
+}{byCodec: [maxCodec + 1]Compressor{CodecDeflate: NewDeflateCompressor(flate.DefaultCompression)}}
+
+// RegisterCompressor makes c the Compressor used to decompress documents written with its codec, replacing any
+// registered before it. Deflate is registered from the start. It panics when the codec is CodecNone or doesn't
+// fit in four bits.
+func RegisterCompressor(c Compressor) {
+	codec := c.Codec()
+	if codec == CodecNone || codec > maxCodec {
+		panic(fmt.Sprintf("glint: compressor codec %d out of range [1, %d]", codec, maxCodec))
+	}
+
+	compressors.Lock()
+	compressors.byCodec[codec] = c
+	compressors.Unlock()
+}
+
+// WithCompression compresses the body of each document with c, whenever that makes it smaller. c is registered
+// with RegisterCompressor, so decoders in the same process read the documents without further setup.
+func WithCompression(c Compressor) EncoderOption {
+	RegisterCompressor(c)
+	return func(cfg *encoderConfig) {
+		cfg.compressor = c
+	}
+}
+
+// compressBody compresses the body written to b from body onwards in place, reporting whether it did. Bodies that
+// fail to compress, or don't shrink, are left as they are.
+func compressBody(c Compressor, b *Buffer, body int) bool {
+	scratch := NewBufferFromPool()
+	defer scratch.ReturnToPool()
+
+	compressed, err := c.Compress(scratch.Bytes, b.Bytes[body:])
+	scratch.Bytes = compressed[:0] // keep any growth for the next document
+	if err != nil || len(compressed) >= len(b.Bytes)-body {
+		return false
+	}
+
+	b.Bytes = append(b.Bytes[:body], compressed...)
+	return true
+}
+
+// compressBodyWith compresses the body written to b from body onwards with the compressor registered for codec, as
+// compressBody does, for documents rewritten in the codec they were read in
+func compressBodyWith(codec Codec, b *Buffer, body int) (bool, error) {
+	compressors.RLock()
+	c := compressors.byCodec[codec]
+	compressors.RUnlock()
+
+	if c == nil {
+		return false, fmt.Errorf("%w %d", ErrUnknownCodec, codec)
+	}
+	return compressBody(c, b, body), nil
+}
+
+// decompressBody returns the decompressed form of a body written with codec, bounded by
+// DefaultLimits.MaxDocumentSize
+func decompressBody(codec Codec, body []byte) ([]byte, error) {
+	compressors.RLock()
+	c := compressors.byCodec[codec]
+	compressors.RUnlock()
+
+	if c == nil {
+		return nil, fmt.Errorf("%w %d", ErrUnknownCodec, codec)
+	}
+
+	decompressed, err := c.Decompress(nil, body, int(DefaultLimits.MaxDocumentSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: decompressing body: %v", ErrInvalidDocument, err)
+	}
+	return decompressed, nil
+}
+
+// DeflateCompressor compresses bodies with compress/flate from the standard library. It's slower than the
+// codecs made for the purpose, but always available.
+type DeflateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewDeflateCompressor creates a DeflateCompressor that compresses at the supplied level, see compress/flate
+func NewDeflateCompressor(level int) *DeflateCompressor {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		panic(fmt.Sprintf("glint: %v", err))
+	}
+	return &DeflateCompressor{level: level}
+}
+
+// Codec returns CodecDeflate
+func (d *DeflateCompressor) Codec() Codec {
+	return CodecDeflate
+}
+
+// Compress appends the deflated form of src to dst
+func (d *DeflateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	out := bytes.NewBuffer(dst)
+
+	w, _ := d.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(out, d.level)
+	} else {
+		w.Reset(out)
+	}
+	defer func() {
+		w.Reset(io.Discard) // don't hold on to the output while pooled
+		d.writers.Put(w)
+	}()
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decompress appends the inflated form of src to dst, failing when it's larger than limit bytes
+func (d *DeflateCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	in := bytes.NewReader(src)
+
+	r, _ := d.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(in)
+	} else if err := r.(flate.Resetter).Reset(in, nil); err != nil {
+		return dst, err
+	}
+	defer d.readers.Put(r)
+
+	var lr io.Reader = r
+	if limit > 0 {
+		lr = io.LimitReader(r, int64(limit)+1)
+	}
+
+	out := bytes.NewBuffer(dst)
+	if _, err := out.ReadFrom(lr); err != nil {
+		return dst, err
+	}
+	if limit > 0 && out.Len()-len(dst) > limit {
+		return dst, fmt.Errorf("decompressed body exceeds %d bytes", limit)
+	}
+	return out.Bytes(), nil
+}
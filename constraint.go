@@ -0,0 +1,356 @@
+package glint
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fields may declare constraints on their values in their tags, which are written into the schema so they travel
+// with the data
+//
+//	Age  int    `glint:"age,min=0,max=150"`
+//	Name string `glint:"name,pattern=^\\w+$"`
+//
+// min and max bound numeric values, pattern is a regular expression string values must match. Constraints on
+// slices and maps apply to each of their elements. Patterns can't contain commas, which separate tag options.
+//
+// A field with constraints sets WireConstraintFlag on its wire type, and its constraints follow its name
+//
+//	[wire | WireConstraintFlag][name length][name][constraints length (varint)][constraints][sub-schema]
+//
+// where the constraints are a list of [kind (byte)][length (varint)][text]. Documents with constraints are
+// written as format version 2. Decoders drop the constraints along with any schema references when a schema is
+// parsed, only decoders created with NewValidatingDecoder enforce them.
+
+// constraint kinds, as written into the schema
+const (
+	constraintMin     byte = 1
+	constraintMax     byte = 2
+	constraintPattern byte = 3
+)
+
+// constraintOptions are the tag options that declare constraints, by kind
+var constraintOptions = []struct {
+	kind byte
+	name string
+}{
+	{constraintMin, "min"},
+	{constraintMax, "max"},
+	{constraintPattern, "pattern"},
+}
+
+// ErrConstraintViolation is returned by validating decoders when a value breaks a constraint from the schema
+var ErrConstraintViolation = errors.New("constraint violation")
+
+// Value returns the value of a name=value option, and whether the option was present
+func (o tagOptions) Value(optionName string) (string, bool) {
+	for _, opt := range strings.Split(string(o), ",") {
+		if name, value, ok := strings.Cut(opt, "="); ok && name == optionName {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns the values of every option named optionName, for options that may be repeated such as alias
+func (o tagOptions) Values(optionName string) []string {
+	var values []string
+	for _, opt := range strings.Split(string(o), ",") {
+		if name, value, ok := strings.Cut(opt, "="); ok && name == optionName {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// appendConstraints writes the constraints declared in a field's tag options, for a field of type t. Invalid
+// constraints panic, as with other tag misuse.
+func appendConstraints(b []byte, opts tagOptions, t reflect.Type) []byte {
+	kind := constrainedKind(t)
+
+	for _, c := range constraintOptions {
+		value, ok := opts.Value(c.name)
+		if !ok {
+			continue
+		}
+
+		switch c.kind {
+		case constraintMin, constraintMax:
+			if _, err := strconv.ParseFloat(value, 64); err != nil || !isNumericKind(kind) {
+				panic(fmt.Sprintf("%s=%q requires a number, on a numeric field", c.name, value))
+			}
+		case constraintPattern:
+			if _, err := regexp.Compile(value); err != nil || kind != reflect.String {
+				panic(fmt.Sprintf("pattern=%q requires a valid regular expression, on a string field", value))
+			}
+		}
+
+		b = append(b, c.kind)
+		b = appendVarintb(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+
+	return b
+}
+
+// constrainedKind returns the kind that constraints on a field of type t apply to, looking through pointers,
+// slices and maps to their elements
+func constrainedKind(t reflect.Type) reflect.Kind {
+	for {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		default:
+			return t.Kind()
+		}
+	}
+}
+
+// isNumericKind reports whether k is an integer or float kind
+func isNumericKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64 && k != reflect.Uintptr
+}
+
+// constraint holds the constraints on a single field, along with those on any fields nested within it
+type constraint struct {
+	min, max string // bounds as written, empty when absent
+	pattern  *regexp.Regexp
+
+	fields constraintSet // constraints on the fields of a struct, or of struct elements
+}
+
+// constraintSet holds the constraints on a list of fields, by name. Fields without constraints are absent.
+type constraintSet map[string]*constraint
+
+// readConstraints reads the constraints from a list of schema fields, which must have any references expanded.
+// The result is nil when there are none.
+func readConstraints(r Reader) constraintSet {
+	var set constraintSet
+
+	for r.BytesLeft() > 0 {
+		wire := WireType(r.ReadVarint())
+		name := string(r.Read(uint(r.ReadByte())))
+
+		var c constraint
+		if wire&WireConstraintFlag > 0 {
+			c.read(NewReader(r.Read(r.ReadVarint())))
+			wire ^= WireConstraintFlag
+		}
+		c.fields = readNodeConstraints(wire, &r)
+
+		if c.min != "" || c.max != "" || c.pattern != nil || c.fields != nil {
+			if set == nil {
+				set = constraintSet{}
+			}
+			set[name] = &c
+		}
+	}
+
+	return set
+}
+
+// readNodeConstraints reads the constraints within the sub-schema belonging to wire, following the same layout as
+// parseSchemaNode
+func readNodeConstraints(wire WireType, r *Reader) constraintSet {
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case base == WireSliceFlag:
+		return readNodeConstraints(WireType(r.ReadVarint()), r)
+
+	case base&WireSliceFlag > 0:
+		return readNodeConstraints(base&WireTypeMask, r)
+
+	case base == WireStruct:
+		return readConstraints(NewReader(r.Read(r.ReadVarint())))
+
+	case base == WireMap:
+		key, value := WireType(r.ReadVarint()), WireType(r.ReadVarint())
+		readNodeConstraints(key, r)
+		return readNodeConstraints(value, r)
+	}
+
+	return nil
+}
+
+// read fills in the constraints written for a single field
+func (c *constraint) read(r Reader) {
+	for r.BytesLeft() > 0 {
+		kind := r.ReadByte()
+		value := string(r.Read(r.ReadVarint()))
+
+		switch kind {
+		case constraintMin:
+			c.min = value
+		case constraintMax:
+			c.max = value
+		case constraintPattern:
+			c.pattern = regexp.MustCompile(value) // panics are surfaced as errors by the caller
+		}
+	}
+}
+
+// check validates the fields of a struct value against the set, tagName selecting the names of its fields. The
+// first violation is returned, or with all set every violation, joined.
+func (set constraintSet) check(v reflect.Value, tagName, path string, all bool) error {
+	var errs []error
+	for _, f := range taggedFields(v.Type(), tagName) {
+		c, ok := set[f.name]
+		if !ok {
+			continue
+		}
+
+		if err := c.check(v.FieldByIndex(f.Index), tagName, path+f.name, all); err != nil {
+			if !all {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// check validates a single value against the constraint, along with any elements and fields within it
+func (c *constraint) check(v reflect.Value, tagName, path string, all bool) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return c.check(v.Elem(), tagName, path, all)
+
+	case reflect.Slice, reflect.Array:
+		var errs []error
+		for i := 0; i < v.Len(); i++ {
+			if err := c.check(v.Index(i), tagName, fmt.Sprintf("%s[%d]", path, i), all); err != nil {
+				if !all {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+
+	case reflect.Map:
+		var errs []error
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := c.check(iter.Value(), tagName, fmt.Sprintf("%s[%v]", path, iter.Key()), all); err != nil {
+				if !all {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+
+	case reflect.Struct:
+		if c.fields != nil {
+			return c.fields.check(v, tagName, path+".", all)
+		}
+
+	case reflect.String:
+		if c.pattern != nil && !c.pattern.MatchString(v.String()) {
+			return fmt.Errorf("%w: %s %q doesn't match pattern %s", ErrConstraintViolation, path, v.String(), c.pattern)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return bounds(path, v.Int(), c.min, c.max, strconv.ParseInt, func(n int64) float64 { return float64(n) })
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return bounds(path, v.Uint(), c.min, c.max, func(s string, base, bits int) (uint64, error) {
+			if strings.HasPrefix(s, "-") {
+				return 0, strconv.ErrRange // negative bounds are compared as floats
+			}
+			return strconv.ParseUint(s, base, bits)
+		}, func(n uint64) float64 { return float64(n) })
+
+	case reflect.Float32, reflect.Float64:
+		return bounds(path, v.Float(), c.min, c.max, func(s string, _, _ int) (float64, error) { return strconv.ParseFloat(s, 64) },
+			func(n float64) float64 { return n })
+	}
+
+	return nil
+}
+
+// bounds checks a number against the min and max constraints. Bounds are compared exactly when they parse as the
+// number's own type, and as floats otherwise, e.g. min=0.5 on an int.
+func bounds[N int64 | uint64 | float64](path string, n N, min, max string, parse func(string, int, int) (N, error), float func(N) float64) error {
+	below := func(bound string) bool {
+		if b, err := parse(bound, 10, 64); err == nil {
+			return n < b
+		}
+		b, _ := strconv.ParseFloat(bound, 64)
+		return float(n) < b
+	}
+	above := func(bound string) bool {
+		if b, err := parse(bound, 10, 64); err == nil {
+			return n > b
+		}
+		b, _ := strconv.ParseFloat(bound, 64)
+		return float(n) > b
+	}
+
+	if min != "" && below(min) {
+		return fmt.Errorf("%w: %s %v is less than min %s", ErrConstraintViolation, path, n, min)
+	}
+	if max != "" && above(max) {
+		return fmt.Errorf("%w: %s %v is more than max %s", ErrConstraintViolation, path, n, max)
+	}
+	return nil
+}
+
+// constraintCache holds the constraints read from each schema a validating decoder has seen, by schema hash
+type constraintCache struct {
+	mu      sync.RWMutex
+	schemas map[uint32]constraintSet
+}
+
+// lookup returns the constraints for a document's schema, reading them from the document the first time the
+// schema is seen. Documents without a schema whose constraints haven't been seen can't be validated, and are
+// treated as having none.
+func (c *constraintCache) lookup(doc []byte, registry *SchemaRegistry, limits DecodeLimits) (set constraintSet, err error) {
+	hash := Document(doc).Hash()
+
+	c.mu.RLock()
+	set, ok := c.schemas[hash]
+	c.mu.RUnlock()
+	if ok {
+		return set, nil
+	}
+
+	p, err := splitDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		if registry == nil {
+			return nil, nil
+		}
+		if p.schema, err = registry.resolve(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.flags&flagVersionMask >= formatVersionConstraint {
+		fields, err := expandSchema(p.schema.Remaining(), limits)
+		if err != nil {
+			return nil, err
+		}
+
+		defer recoverErr(&err)
+		set = readConstraints(NewReader(fields))
+	}
+
+	c.mu.Lock()
+	c.schemas[hash] = set
+	c.mu.Unlock()
+
+	return set, nil
+}
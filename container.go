@@ -0,0 +1,411 @@
+package glint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A container holds many documents in a single file along with an index of where each one starts, so any one of
+// them can be read without reading the others
+//
+//	["GLCT"][records][dictionary][index][dictionary offset (uint64, little endian)]["GLCT"]
+//
+// Each record is a single document, written as [header (varint)][body]. Documents with the same header, the
+// flags, schema hash and schema, share a single copy of it in the dictionary, and the header of a record is its
+// index there. The dictionary is a list of byte strings, [count (varint)]([length (varint)][bytes])*, and the
+// index holds the offset of each record from the start of the container, [count (varint)]([offset gap (varint)])*.
+//
+// Containers may also share repeated string and byte slice values between their documents. A string a writer
+// has already seen in an earlier value is added to the dictionary, and later values holding it are written as a
+// reference to it. Every string and byte slice in a body is written as [n (varint)], followed by n/2 bytes when n
+// is even, or as a reference to dictionary entry n/2 when n is odd. Compressed bodies are stored as they are,
+// without sharing their strings. Documents read from a container are the documents that were written to it, byte
+// for byte.
+//
+// Documents must carry their schema to be written to a container, as the bodies are rewritten by following it.
+
+// DefaultMaxDictionaryEntries is the number of repeated strings a ContainerWriter shares between documents,
+// unless created with NewContainerWriterWithDictionaryLimit
+const DefaultMaxDictionaryEntries = 64 * 1024
+
+// containerMagic starts and ends every container
+var containerMagic = []byte("GLCT")
+
+// ErrInvalidContainer is returned when a container is malformed or truncated
+var ErrInvalidContainer = errors.New("invalid glint container")
+
+// ContainerWriter writes documents to a container. Nothing is readable until Close writes the dictionary and
+// index, which follow the documents. It doesn't buffer, wrap the writer in a bufio.Writer when writing many
+// documents.
+type ContainerWriter struct {
+	w      io.Writer
+	offset uint64
+	err    error
+
+	offsets []uint64
+	entries [][]byte
+	lookup  map[string]uint // dictionary entries by value, headers and strings alike
+	seen    map[string]struct{}
+
+	maxStrings uint // strings added to the dictionary so far may not exceed this
+	strings    uint
+
+	body Buffer
+}
+
+// NewContainerWriter creates a ContainerWriter that writes a container to w, sharing up to
+// DefaultMaxDictionaryEntries repeated strings between its documents
+func NewContainerWriter(w io.Writer) *ContainerWriter {
+	return NewContainerWriterWithDictionaryLimit(w, DefaultMaxDictionaryEntries)
+}
+
+// NewContainerWriterWithDictionaryLimit creates a ContainerWriter that writes a container to w, sharing up to
+// maxEntries repeated strings between its documents. A limit of 0 writes every string in full, only schemas are
+// shared.
+func NewContainerWriterWithDictionaryLimit(w io.Writer, maxEntries uint) *ContainerWriter {
+	return &ContainerWriter{w: w, lookup: map[string]uint{}, seen: map[string]struct{}{}, maxStrings: maxEntries}
+}
+
+// Write adds a single document to the container. Documents without a schema return ErrSchemaNotFound.
+func (cw *ContainerWriter) Write(doc []byte) (err error) {
+	if cw.err != nil {
+		return cw.err
+	}
+
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil {
+		return err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return ErrSchemaNotFound
+	}
+
+	defer recoverErr(&err)
+
+	header := doc[:len(doc)-len(p.written)]
+
+	cw.body.Reset()
+	cw.body.AppendUint(cw.entry(header))
+
+	if p.flags&flagCodecMask != 0 { // compressed bodies are kept as they are, see compress.go
+		cw.body.Bytes = append(cw.body.Bytes, p.written...)
+	} else {
+		fields := parseSchemaNodes(p.schema)
+		body := p.body
+		for i := range fields {
+			rewriteStrings(&fields[i], &body, &cw.body, cw.appendString)
+		}
+		if body.BytesLeft() > 0 {
+			return fmt.Errorf("%w: body bytes remaining > 0: %v", ErrInvalidDocument, body.BytesLeft())
+		}
+		cw.body.Bytes = append(cw.body.Bytes, p.written[len(p.body.Remaining()):]...) // the string table, see dict.go
+	}
+
+	if cw.offset == 0 {
+		cw.write(containerMagic)
+	}
+	cw.offsets = append(cw.offsets, cw.offset)
+	cw.write(cw.body.Bytes)
+
+	return cw.err
+}
+
+// Close writes the dictionary and index, completing the container. It doesn't close the underlying writer.
+func (cw *ContainerWriter) Close() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	if cw.offset == 0 {
+		cw.write(containerMagic)
+	}
+
+	var b Buffer
+	b.AppendUint(uint(len(cw.entries)))
+	for _, e := range cw.entries {
+		b.AppendBytes(e)
+	}
+
+	b.AppendUint(uint(len(cw.offsets)))
+	prev := uint64(0)
+	for _, o := range cw.offsets {
+		appendVarint(&b, o-prev)
+		prev = o
+	}
+
+	b.Bytes = binary.LittleEndian.AppendUint64(b.Bytes, cw.offset)
+	b.Bytes = append(b.Bytes, containerMagic...)
+
+	cw.write(b.Bytes)
+	if cw.err == nil {
+		cw.err = errors.New("glint: container writer is closed")
+		return nil
+	}
+	return cw.err
+}
+
+// write writes to the underlying writer, keeping track of the offset and the first error
+func (cw *ContainerWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.Write(b)
+	cw.offset += uint64(n)
+	cw.err = err
+}
+
+// entry returns the index of a dictionary entry, adding it when it's new
+func (cw *ContainerWriter) entry(b []byte) uint {
+	if i, ok := cw.lookup[string(b)]; ok {
+		return i
+	}
+
+	i := uint(len(cw.entries))
+	cw.entries = append(cw.entries, append([]byte{}, b...))
+	cw.lookup[string(b)] = i
+	return i
+}
+
+// appendString copies a string or byte slice value from a document, as a reference when it has been seen before
+func (cw *ContainerWriter) appendString(r *Reader, b *Buffer) {
+	s := r.Read(r.ReadVarint())
+
+	if i, ok := cw.lookup[string(s)]; ok {
+		b.AppendUint(i<<1 | 1)
+		return
+	}
+
+	if _, ok := cw.seen[string(s)]; ok && cw.strings < cw.maxStrings {
+		delete(cw.seen, string(s))
+		cw.strings++
+		b.AppendUint(cw.entry(s)<<1 | 1)
+		return
+	}
+
+	// a single byte reference saves nothing on values this short
+	if len(s) > 1 && uint(len(cw.seen)) < 4*cw.maxStrings {
+		cw.seen[string(s)] = struct{}{}
+	}
+
+	b.AppendUint(uint(len(s)) << 1)
+	b.Bytes = append(b.Bytes, s...)
+}
+
+// Container reads documents from a container written by a ContainerWriter, in any order
+type Container struct {
+	r       io.ReaderAt
+	offsets []int64 // offsets of each record, followed by the offset of the dictionary
+	entries [][]byte
+}
+
+// OpenContainer reads the dictionary and index of a container of size bytes
+func OpenContainer(r io.ReaderAt, size int64) (*Container, error) {
+	trailer := int64(8 + len(containerMagic))
+	if size < int64(len(containerMagic))+trailer {
+		return nil, ErrInvalidContainer
+	}
+
+	tail := make([]byte, trailer)
+	if err := readAt(r, tail, size-trailer); err != nil {
+		return nil, err
+	}
+	if string(tail[8:]) != string(containerMagic) {
+		return nil, fmt.Errorf("%w: missing trailer", ErrInvalidContainer)
+	}
+
+	dictOffset := binary.LittleEndian.Uint64(tail)
+	if dictOffset < uint64(len(containerMagic)) || dictOffset > uint64(size-trailer) {
+		return nil, fmt.Errorf("%w: dictionary offset %d out of range", ErrInvalidContainer, dictOffset)
+	}
+
+	section := make([]byte, uint64(size-trailer)-dictOffset)
+	if err := readAt(r, section, int64(dictOffset)); err != nil {
+		return nil, err
+	}
+
+	c := &Container{r: r}
+	if err := c.readIndex(section, dictOffset); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readIndex reads the dictionary and index of the container from section, the bytes from dictOffset to the trailer
+func (c *Container) readIndex(section []byte, dictOffset uint64) (err error) {
+	defer recoverErrAs(&err, ErrInvalidContainer) // the Reader panics on truncated sections
+
+	s := NewReader(section)
+
+	n := s.ReadVarint()
+	if n > s.BytesLeft() { // every entry takes at least a byte, bounding the allocation
+		return fmt.Errorf("%w: %d dictionary entries", ErrInvalidContainer, n)
+	}
+	c.entries = make([][]byte, n)
+	for i := range c.entries {
+		c.entries[i] = s.Read(s.ReadVarint())
+	}
+
+	n = s.ReadVarint()
+	if n > s.BytesLeft() {
+		return fmt.Errorf("%w: %d documents", ErrInvalidContainer, n)
+	}
+	c.offsets = make([]int64, 0, n+1)
+	offset := uint64(0)
+	for i := uint(0); i < n; i++ {
+		offset += uint64(s.ReadVarint())
+		if offset < uint64(len(containerMagic)) || offset >= dictOffset {
+			return fmt.Errorf("%w: document offset %d out of range", ErrInvalidContainer, offset)
+		}
+		c.offsets = append(c.offsets, int64(offset))
+	}
+	c.offsets = append(c.offsets, int64(dictOffset))
+
+	if s.BytesLeft() > 0 {
+		return fmt.Errorf("%w: index bytes remaining > 0: %v", ErrInvalidContainer, s.BytesLeft())
+	}
+	return nil
+}
+
+// Len returns the number of documents in the container
+func (c *Container) Len() int {
+	return len(c.offsets) - 1
+}
+
+// Document reads the document at index i, restoring any strings shared through the dictionary
+func (c *Container) Document(i int) (doc []byte, err error) {
+	if i < 0 || i >= c.Len() {
+		return nil, fmt.Errorf("glint: container document %d out of range [0, %d)", i, c.Len())
+	}
+
+	record := make([]byte, c.offsets[i+1]-c.offsets[i])
+	if err := readAt(c.r, record, c.offsets[i]); err != nil {
+		return nil, err
+	}
+
+	defer recoverErrAs(&err, ErrInvalidContainer) // the Reader panics on truncated records
+
+	body := NewReader(record)
+	header := c.entry(body.ReadVarint())
+
+	p, err := Document(header).parts(DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	b := Buffer{Bytes: make([]byte, 0, len(header)+len(record))}
+	b.Bytes = append(b.Bytes, header...)
+	if p.flags&flagCodecMask == 0 {
+		fields := parseSchemaNodes(p.schema)
+		for i := range fields {
+			rewriteStrings(&fields[i], &body, &b, c.appendString)
+		}
+	}
+	if p.flags&(flagStringTable|flagCodecMask) > 0 { // compressed bodies and string tables are stored as they are
+		b.Bytes = append(b.Bytes, body.Read(body.BytesLeft())...)
+	}
+	if body.BytesLeft() > 0 {
+		return nil, fmt.Errorf("%w: record bytes remaining > 0: %v", ErrInvalidContainer, body.BytesLeft())
+	}
+
+	return b.Bytes, nil
+}
+
+// appendString copies a string or byte slice value from a record, restoring it from the dictionary when it's a
+// reference
+func (c *Container) appendString(r *Reader, b *Buffer) {
+	n := r.ReadVarint()
+	if n&1 == 1 {
+		b.AppendBytes(c.entry(n >> 1))
+		return
+	}
+	b.AppendBytes(r.Read(n >> 1))
+}
+
+// entry returns a dictionary entry, panicking when there's no such entry
+func (c *Container) entry(i uint) []byte {
+	if i >= uint(len(c.entries)) {
+		panic(fmt.Sprintf("dictionary entry %d out of range", i))
+	}
+	return c.entries[i]
+}
+
+// readAt fills p from r at off, allowing readers that return io.EOF along with the last bytes
+func readAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	return err
+}
+
+// rewriteStrings copies the value of t from r to b unchanged, other than every string and byte slice, which are
+// copied by str. Values are followed the same way the generator writes them.
+func rewriteStrings(t *schemaNode, r *Reader, b *Buffer, str func(r *Reader, b *Buffer)) {
+
+	if t.wire&WirePtrFlag > 0 {
+		present := r.ReadByte()
+		b.AppendUint8(present)
+		if present == 0 {
+			return
+		}
+	}
+
+	wire := t.wire &^ WirePtrFlag
+
+	switch {
+	case wire&WireDictFlag > 0: // references to the document's string table, copied as they are
+		r.SetMark()
+		if wire&WireSliceFlag > 0 {
+			*r = skipDictSlice(nil, *r)
+		} else {
+			r.SkipVarint()
+		}
+		b.Bytes = append(b.Bytes, r.BytesFromMark()...)
+
+	case wire&WireBitmapFlag > 0: // bools only, copied as they are
+		r.SetMark()
+		*r = skipBitmapSlice(nil, *r)
+		b.Bytes = append(b.Bytes, r.BytesFromMark()...)
+
+	case wire&WireSparseFlag > 0: // numbers only, copied as they are
+		r.SetMark()
+		*r = skipSparseSlice(nil, *r)
+		b.Bytes = append(b.Bytes, r.BytesFromMark()...)
+
+	case wire&WireDeltaFlag > 0:
+		r.SetMark()
+		l := r.ReadVarint()
+		for i := uint(0); i < l; i++ {
+			r.SkipVarint()
+		}
+		b.Bytes = append(b.Bytes, r.BytesFromMark()...)
+
+	case wire&WireSliceFlag > 0:
+		l := r.ReadVarint()
+		b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			rewriteStrings(t.elem, r, b, str)
+		}
+
+	case wire == WireStruct:
+		for i := range t.fields {
+			rewriteStrings(&t.fields[i], r, b, str)
+		}
+
+	case wire == WireMap:
+		l := r.ReadVarint()
+		b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			rewriteStrings(t.key, r, b, str)
+			rewriteStrings(t.value, r, b, str)
+		}
+
+	case wire == WireString, wire == WireBytes:
+		str(r, b)
+
+	default:
+		r.SetMark()
+		fieldBytes(r, wire)
+		b.Bytes = append(b.Bytes, r.BytesFromMark()...)
+	}
+}
@@ -0,0 +1,55 @@
+package glint
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Converter maps values of one struct type onto another by writing a document from the first and reading it into
+// the second. Fields are matched by their tag names, so the two types can differ in exactly the ways documents can
+// evolve: fields the target doesn't have are skipped, fields the source doesn't have keep their default= values or
+// zero values, and fields may be in any order. Fields with the same name but incompatible types are an error.
+//
+// Create one Converter per pair of types and reuse it, it's safe for concurrent use.
+type Converter[A, B any] struct {
+	enc *Encoder[A]
+	dec *Decoder[B]
+}
+
+// NewConverter builds a Converter from A to B
+func NewConverter[A, B any]() *Converter[A, B] {
+	return &Converter[A, B]{enc: NewEncoder[A](), dec: NewDecoder[B]()}
+}
+
+// Convert decodes a into b. Strings and byte slices in b are backed by a document of their own, rather than
+// sharing memory with a.
+func (c *Converter[A, B]) Convert(a *A, b *B) error {
+	buf := &Buffer{} // the decoded strings point into the document, so it can't be pooled
+	c.enc.Marshal(a, buf)
+	return c.dec.Unmarshal(buf.Bytes, b)
+}
+
+// converters holds the Converter built for each pair of types passed to Convert
+var converters sync.Map // [2]reflect.Type -> *Converter[A, B]
+
+// Convert maps a onto a new B, as a Converter does. The Converter for each pair of types is built on first use
+// and kept for later calls. A nil a converts to a nil B.
+//
+//	v2, err := glint.Convert[OrderV1, OrderV2](&order)
+func Convert[A, B any](a *A) (*B, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	key := [2]reflect.Type{reflect.TypeOf(a).Elem(), reflect.TypeOf((*B)(nil)).Elem()}
+	c, ok := converters.Load(key)
+	if !ok {
+		c, _ = converters.LoadOrStore(key, NewConverter[A, B]())
+	}
+
+	b := new(B)
+	if err := c.(*Converter[A, B]).Convert(a, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -1,10 +1,17 @@
 package glint
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -14,15 +21,144 @@ type Decoder[T any] struct {
 	impl *decoderImpl
 }
 
+// DecoderOption configures optional decoder behaviour, see NewDecoder
+type DecoderOption func(*decoderImpl)
+
+// WithStrictSchemaHash checks the schema hash in the header of every document against the schema the document
+// carries, refusing documents whose schema has been corrupted or tampered with with a *SchemaHashError. Without it
+// the hash is only used to look up the instructions of schemas already seen, so a damaged schema behind a known
+// hash decodes with the instructions of the genuine one. Documents written without their schema have nothing to
+// check the hash against, and decode as usual.
+func WithStrictSchemaHash() DecoderOption {
+	return func(d *decoderImpl) {
+		d.strictHash = true
+	}
+}
+
+// WithDisallowUnknownFields refuses documents whose schema has a field the decoded type doesn't, at any depth,
+// with an error wrapping ErrUnknownField, as json.Decoder.DisallowUnknownFields does. Such fields are otherwise
+// skipped, which suits services that must tolerate newer producers, but lets data that's expected to be kept go
+// unnoticed by validators.
+func WithDisallowUnknownFields() DecoderOption {
+	return func(d *decoderImpl) {
+		d.eachDecoder(func(d *decoderImpl) {
+			d.disallowUnknown = true
+		})
+	}
+}
+
+// WithDuplicateMapKeys sets how maps holding the same key more than once are decoded, at any depth. The last entry
+// wins by default, DuplicateKeysFirstWins keeps the first and DuplicateKeysError fails the decode with
+// ErrDuplicateMapKey. Keys already held by a map before decoding aren't duplicates, they're replaced as usual.
+func WithDuplicateMapKeys(policy DuplicateKeyPolicy) DecoderOption {
+	return func(d *decoderImpl) {
+		d.eachMapDecoder(func(m *mapDecoder) {
+			m.duplicates = policy
+		})
+	}
+}
+
+// stringCopies selects which decoded strings and byte slices are copied out of the document, rather than sharing
+// its memory
+type stringCopies uint8
+
+const (
+	copyTagged stringCopies = iota // those of fields with the copy tag option, the default
+	copyNone                       // none, see WithZeroCopyStrings
+	copyAll                        // every one, see WithCopiedStrings
+)
+
+// WithZeroCopyStrings decodes every string and byte slice as a view onto the document, even those of fields with
+// the copy tag option, so they stay valid only as long as the document's bytes are left alone. Builds with the
+// purego tag copy strings regardless.
+func WithZeroCopyStrings() DecoderOption {
+	return func(d *decoderImpl) {
+		d.copies = copyNone
+	}
+}
+
+// WithCopiedStrings copies every decoded string and byte slice out of the document, as if each field had the copy
+// tag option, so the document's bytes can be reused as soon as it's decoded.
+func WithCopiedStrings() DecoderOption {
+	return func(d *decoderImpl) {
+		d.copies = copyAll
+	}
+}
+
 // NewDecoder constructs a decoder specialized for type T with default limits
-func NewDecoder[T any]() *Decoder[T] {
-	return NewDecoderWithLimits[T](DefaultLimits)
+func NewDecoder[T any](opts ...DecoderOption) *Decoder[T] {
+	return NewDecoderWithLimits[T](DefaultLimits, opts...)
 }
 
 // NewDecoderWithLimits constructs a decoder with custom bounds checking limits
-func NewDecoderWithLimits[T any](limits DecodeLimits) *Decoder[T] {
+func NewDecoderWithLimits[T any](limits DecodeLimits, opts ...DecoderOption) *Decoder[T] {
 	var zero T
 	impl := newDecoderWithLimits(zero, limits)
+	for _, opt := range opts {
+		opt(impl)
+	}
+	if impl.metrics != nil {
+		impl.name = reflect.TypeOf((*T)(nil)).Elem().String()
+		impl.eachMapDecoder(func(m *mapDecoder) {
+			m.metrics, m.name = impl.metrics, impl.name
+		})
+	}
+	return &Decoder[T]{impl: impl}
+}
+
+// NewDecoderWithRegistry constructs a decoder that resolves documents carrying only a schema ID through the
+// supplied registry, see WithSchemaRegistry. Documents that include their schema decode as usual.
+func NewDecoderWithRegistry[T any](r *SchemaRegistry) *Decoder[T] {
+	d := NewDecoder[T]()
+	d.impl.registry = r
+	return d
+}
+
+// NewValidatingDecoder constructs a decoder that enforces the field constraints a document's schema carries,
+// such as min, max and pattern, returning an error wrapping ErrConstraintViolation for values that break them.
+// Constraints are those written by the encoder, not those in T's own tags. Documents written without their
+// schema are validated against the constraints of an earlier document with the same schema.
+func NewValidatingDecoder[T any]() *Decoder[T] {
+	d := NewDecoder[T]()
+	d.impl.validate = &constraintCache{schemas: map[uint32]constraintSet{}}
+	return d
+}
+
+// CollectErrors switches the decoder to reporting every field-level error in a document rather than stopping at
+// the first, so that an API can return all of a request's problems in one response. Fields whose type doesn't
+// match the document's schema are skipped, and the rest of the document decoded, every missing required field is
+// reported, and a validating decoder reports every constraint violation. A body that ends early still stops the
+// decode, with the errors collected before it.
+//
+// The errors are joined with errors.Join, so errors.Is finds each of them and their Unwrap() []error method lists
+// them. It returns d, and should be called before the decoder is first used.
+//
+//	err := glint.NewValidatingDecoder[Signup]().CollectErrors().Unmarshal(doc, &signup)
+func (d *Decoder[T]) CollectErrors() *Decoder[T] {
+	d.impl.collect = true
+	return d
+}
+
+// NewDecoderWithFields constructs a decoder that only decodes the named top level fields of T, leaving the rest
+// of v untouched. Every other field in a document is skipped as if T didn't have it, which is much cheaper than
+// decoding it, so wide structs can be read for just the fields a caller needs. Nested structs are decoded whole
+// when selected. Naming a field T doesn't have panics.
+func NewDecoderWithFields[T any](fields ...string) *Decoder[T] {
+	return NewDecoderWithFieldsAndLimits[T](DefaultLimits, fields...)
+}
+
+// NewDecoderWithFieldsAndLimits combines NewDecoderWithFields and NewDecoderWithLimits
+func NewDecoderWithFieldsAndLimits[T any](limits DecodeLimits, fields ...string) *Decoder[T] {
+	only := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		only[f] = true
+	}
+
+	var zero T
+	impl := newDecoderSelectingFields(zero, "glint", limits, only)
+	if impl.numfield != len(only) {
+		panic(fmt.Sprintf("glint: field mask %q names fields that %T doesn't have", fields, zero))
+	}
 	return &Decoder[T]{impl: impl}
 }
 
@@ -34,23 +170,49 @@ func NewDecoderUsingTag[T any](usingTagName string) *Decoder[T] {
 	return &Decoder[T]{impl: impl}
 }
 
+// NewDecoderUsingTagFallback is like NewDecoder, but names fields from the first of tagNames each field has, as
+// NewEncoderUsingTagFallback does
+func NewDecoderUsingTagFallback[T any](tagNames ...string) *Decoder[T] {
+	var zero T
+	impl := newDecoderUsingTag(zero, fallbackTags(tagNames))
+	return &Decoder[T]{impl: impl}
+}
+
 // Unmarshal extracts data from bytes into a value of type T
 func (d *Decoder[T]) Unmarshal(bytes []byte, v *T) error {
 	return d.impl.Unmarshal(bytes, v)
 }
 
+// UnmarshalFrom reads a single document from r, up to the end of the reader, and decodes it into v. The document
+// is read a section at a time, and the schema and document sizes are checked against the decoder's limits before
+// they're read.
+func (d *Decoder[T]) UnmarshalFrom(r io.Reader, v *T) error {
+	doc, err := readDocument(r, d.impl.limits)
+	if err != nil {
+		return err
+	}
+	return d.impl.Unmarshal(doc, v)
+}
+
 // UnmarshalWithContext performs decoding using the supplied context
 func (d *Decoder[T]) UnmarshalWithContext(bytes []byte, v *T, context DecoderContext) error {
 	return d.impl.UnmarshalWithContext(bytes, v, context)
 }
 
+// UnmarshalContext decodes bytes into v, returning ctx's error if it's cancelled before decoding is done. The
+// document is counted before it's decoded so that ctx can be checked as it's read, see DecodeLimits.MaxTotalFields.
+func (d *Decoder[T]) UnmarshalContext(ctx context.Context, bytes []byte, v *T) error {
+	return d.impl.UnmarshalWithContext(bytes, v, DecoderContext{InstructionCache: &d.impl.cache, Context: ctx})
+}
+
 const smallKeys = 9 // character limit for small keys to use trie lookups
 
 // dtrienode represents a node in the decode instruction trie
 type dtrienode struct {
 	children [256]*dtrienode
 	field    []decodeInstruction
-	word     bool // marks end of a complete word
+	schema   []byte // the expanded schema the instructions were parsed from, kept only when documents are counted
+	word     bool   // marks end of a complete word
 }
 
 // trienode forms nodes within the field lookup trie
@@ -88,9 +250,12 @@ func (t *trie) Add(name string, field decodeInstruction) {
 // Get performs the lookup on the supplied name
 func (t *trie) Get(name string) (decodeInstruction, bool) {
 	node := &t.root
+	if len(name) == 0 { // schemas may name a field "", which no field is tagged with
+		return node.field, node.word
+	}
 	var i int
 start:
-	if node.children[name[i]] == nil {
+	if name[i] >= byte(len(node.children)) || node.children[name[i]] == nil { // non-ASCII names aren't in the trie
 		return decodeInstruction{}, false
 	}
 	node = node.children[name[i]]
@@ -111,7 +276,7 @@ type DecodeInstructionLookup struct {
 }
 
 // add appends the supplied field into the trie against name
-func (t *DecodeInstructionLookup) add(hash []byte, field []decodeInstruction, id uint) {
+func (t *DecodeInstructionLookup) add(hash []byte, field []decodeInstruction, schema []byte, id uint) {
 
 	node := &t.root
 
@@ -125,6 +290,7 @@ func (t *DecodeInstructionLookup) add(hash []byte, field []decodeInstruction, id
 	}
 
 	node.field = field
+	node.schema = schema
 	node.word = true
 
 	if t.Added != nil {
@@ -152,6 +318,17 @@ start:
 	return node.field, node.word
 }
 
+// schema returns the schema kept against hash, if any
+func (t *DecodeInstructionLookup) schema(hash []byte) []byte {
+	node := &t.root
+	for _, char := range hash {
+		if node = node.children[char]; node == nil {
+			return nil
+		}
+	}
+	return node.schema
+}
+
 // decoder defines methods required by all decoder types during recursive decoding
 type decoder interface {
 	Unmarshal([]byte, any) error
@@ -162,14 +339,29 @@ type decoder interface {
 
 // decoderImpl holds the internal decoding state - always construct via `newDecoder`
 type decoderImpl struct {
-	trie     trie                         // optimized lookups for short field names
-	lookup   map[string]decodeInstruction // map-based lookups for longer names (more consistent performance)
-	instr    []decodeInstruction          // fixed instruction set for specialized decoders (e.g. map values)
-	numfield int                          // total fields registered in lookups
-	wireType WireType                     // enables runtime type validation
-	lastHash uint32                       // most recent schema hash encountered
-	limits   DecodeLimits                 // bounds checking configuration
-	cache    DecodeInstructionLookup      // per-decoder instance cache
+	trie            trie                         // optimized lookups for short field names
+	lookup          map[string]decodeInstruction // map-based lookups for longer names (more consistent performance)
+	hashed          map[uint64]decodeInstruction // the same lookups by name hash, for schemas carrying them
+	instr           []decodeInstruction          // fixed instruction set for specialized decoders (e.g. map values)
+	numfield        int                          // total fields registered in lookups
+	wireType        WireType                     // enables runtime type validation
+	lastHash        uint32                       // most recent schema hash encountered
+	limits          DecodeLimits                 // bounds checking configuration
+	cache           DecodeInstructionLookup      // per-decoder instance cache
+	registry        *SchemaRegistry              // resolves the schemas of documents that only carry their ID, may be nil
+	validate        *constraintCache             // enforces field constraints from the schema when set, see constraint.go
+	defaults        []decodeInstruction          // set fields with a default= option, when a schema doesn't have them
+	required        []string                     // tags of fields a schema must have, from the required option
+	collect         bool                         // report every field-level error rather than the first, see CollectErrors
+	policy          *policyCheck                 // checks document schemas against a SchemaPolicy when set, see policy.go
+	strictHash      bool                         // check each document's schema against its hash, see WithStrictSchemaHash
+	copies          stringCopies                 // which strings and byte slices are copied, see WithCopiedStrings
+	metrics         MetricsSink                  // when set, documents decoded are reported to it, see WithDecoderMetrics
+	name            string                       // the decoded type's name, for decoders reporting metrics
+	matching        nameMatching                 // how schema names that aren't the same as a tag are matched to it
+	folded          map[string]decodeInstruction // fields by their folded tags, when names are matched loosely
+	coerce          bool                         // read numbers into wider numeric fields, see WithNumericCoercion
+	disallowUnknown bool                         // refuse documents with fields the type doesn't have, see WithDisallowUnknownFields
 
 }
 
@@ -200,8 +392,15 @@ func newDecoderUsingTag(t any, usingTagName string) *decoderImpl {
 }
 
 func newDecoderUsingTagWithLimits(t any, usingTagName string, limits DecodeLimits) *decoderImpl {
+	return newDecoderSelectingFields(t, usingTagName, limits, nil)
+}
+
+// newDecoderSelectingFields builds a decoder for only the fields named in only, or every field when only is nil.
+// Fields left out are missing from the lookups, so they're skipped like fields the type doesn't have.
+func newDecoderSelectingFields(t any, usingTagName string, limits DecodeLimits, only map[string]bool) *decoderImpl {
 	d := &decoderImpl{}
 	d.lookup = make(map[string]decodeInstruction)
+	d.hashed = make(map[uint64]decodeInstruction)
 	d.limits = limits
 
 	tt := reflect.TypeOf(t)
@@ -209,12 +408,16 @@ func newDecoderUsingTagWithLimits(t any, usingTagName string, limits DecodeLimit
 	if tt.Kind() == reflect.Pointer {
 		tt = tt.Elem()
 	}
+	if tt.Kind() == reflect.Map {
+		tt = rootStruct(tt, usingTagName)
+	}
 
-	for i := 0; i < tt.NumField(); i++ {
-		f := tt.Field(i)
+	var aliases []decodeInstruction // fields by the names in their alias options, added once every tag is known
+	var aliased []string
 
-		tag, opts := parseTag(f.Tag.Get(usingTagName))
-		if tag == "" {
+	for _, f := range taggedFields(tt, usingTagName) {
+		tag, opts := f.name, f.opts
+		if only != nil && !only[tag] {
 			continue
 		}
 
@@ -223,28 +426,491 @@ func newDecoderUsingTagWithLimits(t any, usingTagName string, limits DecodeLimit
 
 		// route decode instructions to trie or map based on name length
 		// for optimal lookup performance during schema parsing
-		df := decodeInstruction{fun: assigner.fun, offset: f.Offset, kind: assigner.wire, subdec: assigner.subDecoder, subType: f.Type, tag: tag, subinstr: nil, optimizable: false}
+		df := decodeInstruction{fun: assigner.fun, offset: f.Offset, kind: assigner.wire, subdec: assigner.subDecoder, subType: f.Type, tag: tag, subinstr: nil, optimizable: false, copy: opts.Contains("copy")}
 		if len(tag) < smallKeys {
 			d.trie.Add(tag, df)
 		} else {
 			d.lookup[tag] = df
+			d.hashed[nameHash(tag)] = df
 		}
 		d.numfield++
+
+		if opts.Contains("required") {
+			d.required = append(d.required, tag)
+		}
+		if value, ok := opts.Value("default"); ok {
+			d.defaults = append(d.defaults, decodeInstruction{fun: defaultAssigner(f.Type, value), offset: f.Offset, subType: f.Type, tag: tag})
+		}
+		for _, alias := range opts.Values("alias") {
+			aliases, aliased = append(aliases, df), append(aliased, alias)
+		}
+	}
+
+	for i, alias := range aliased {
+		d.addAlias(alias, aliases[i])
 	}
 
 	return d
 }
 
+// addAlias adds the field of di under the name alias, as well as its tag, so that documents written before the
+// field was renamed still decode into it. The instruction keeps its tag, which is the name errors and required
+// checks use. Aliases that are empty or already name a field panic, as with other tag misuse.
+func (d *decoderImpl) addAlias(alias string, di decodeInstruction) {
+	if alias == "" {
+		panic(fmt.Sprintf("glint: field %q has an empty alias", di.tag))
+	}
+	if _, ok := d.field(alias); ok {
+		panic(fmt.Sprintf("glint: alias %q of field %q names another field", alias, di.tag))
+	}
+	if len(alias) < smallKeys {
+		d.trie.Add(alias, di)
+	} else {
+		d.lookup[alias] = di // left out of the hashed lookups, whose instructions are checked against their tags
+	}
+}
+
+// eachField calls fn with the name and instruction of every field d decodes, once for its tag and once for each of
+// its aliases
+func (d *decoderImpl) eachField(fn func(name string, di decodeInstruction)) {
+	var walk func(n *trienode, name []byte)
+	walk = func(n *trienode, name []byte) {
+		if n.word {
+			fn(string(name), n.field)
+		}
+		for c, child := range n.children {
+			if child != nil {
+				walk(child, append(name, byte(c)))
+			}
+		}
+	}
+	walk(&d.trie.root, nil)
+
+	for name, di := range d.lookup {
+		fn(name, di)
+	}
+}
+
+// eachDecoder calls fn with d and the decoder of every struct nested within it, for options that apply to the
+// whole document
+func (d *decoderImpl) eachDecoder(fn func(*decoderImpl)) {
+	d.eachSubDecoder(func(dec decoder) {
+		if dec, ok := dec.(*decoderImpl); ok {
+			fn(dec)
+		}
+	})
+}
+
+// eachMapDecoder calls fn with the decoder of every map nested within d
+func (d *decoderImpl) eachMapDecoder(fn func(*mapDecoder)) {
+	d.eachSubDecoder(func(dec decoder) {
+		if dec, ok := dec.(*mapDecoder); ok {
+			fn(dec)
+		}
+	})
+}
+
+// eachSubDecoder calls fn with d and every struct, slice and map decoder nested within it
+func (d *decoderImpl) eachSubDecoder(fn func(decoder)) {
+	seen := map[*decoderImpl]bool{}
+
+	var walk func(dec decoder)
+	walk = func(dec decoder) {
+		switch dec := dec.(type) {
+		case *decoderImpl:
+			if seen[dec] {
+				return // fields with aliases are found under each of their names
+			}
+			seen[dec] = true
+			fn(dec)
+			dec.eachField(func(_ string, di decodeInstruction) {
+				walk(di.subdec)
+			})
+		case *sliceDecoder:
+			fn(dec)
+			walk(dec.subdec)
+		case *mapDecoder:
+			fn(dec)
+			walk(dec.subdec)
+		}
+	}
+	walk(d)
+}
+
+// field looks up the field with the tag or alias name
+func (d *decoderImpl) field(name string) (decodeInstruction, bool) {
+	if len(name) < smallKeys {
+		return d.trie.Get(name)
+	}
+	di, ok := d.lookup[name]
+	return di, ok
+}
+
+// defaultAssigner returns an instruction function that sets a field of type t to the value of its default=
+// option, without reading anything from the body. Defaults are supported on strings, bools and numbers, and
+// pointers to them, with time.Duration defaults written as durations, e.g. "1m30s". Other types panic.
+func defaultAssigner(t reflect.Type, value string) func(unsafe.Pointer, Reader) Reader {
+	base := t
+	if t.Kind() == reflect.Pointer {
+		base = t.Elem()
+	}
+
+	v := reflect.New(base).Elem()
+	var err error
+
+	switch base.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if base == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			n = int64(d)
+		} else {
+			n, err = strconv.ParseInt(value, 10, base.Bits())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, base.Bits())
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(value, base.Bits())
+		v.SetFloat(n)
+	default:
+		err = errors.New("defaults aren't supported on this type")
+	}
+	if err != nil {
+		panic(fmt.Sprintf("default=%q on a field of type %v: %v", value, t, err))
+	}
+
+	if t.Kind() == reflect.Pointer {
+		return func(p unsafe.Pointer, r Reader) Reader {
+			n := reflect.New(base) // a new value each time, so decoded values don't share it
+			n.Elem().Set(v)
+			*(*unsafe.Pointer)(p) = n.UnsafePointer()
+			return r
+		}
+	}
+
+	return func(p unsafe.Pointer, r Reader) Reader {
+		reflect.NewAt(base, p).Elem().Set(v)
+		return r
+	}
+}
+
+// checkRequired returns an error naming the first field with the required option that's missing from a parsed
+// schema, or in collecting mode every such field
+func (d *decoderImpl) checkRequired(instructions []decodeInstruction) error {
+	var errs fieldErrors
+next:
+	for _, tag := range d.required {
+		for i := range instructions {
+			if instructions[i].tag == tag {
+				continue next
+			}
+		}
+		err := fmt.Errorf("%w: %q", ErrMissingRequiredField, tag)
+		if !d.collect {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// appendDefaults adds an instruction for each field with a default that's missing from a parsed schema
+func (d *decoderImpl) appendDefaults(instructions []decodeInstruction) []decodeInstruction {
+next:
+	for _, def := range d.defaults {
+		for i := range instructions {
+			if instructions[i].tag == def.tag {
+				continue next
+			}
+		}
+		instructions = append(instructions, def)
+	}
+	return instructions
+}
+
 // Unmarshal Errors
 var (
 	ErrInvalidDocument = errors.New("invalid glint document")
 	ErrSchemaNotFound  = errors.New("schema parse error. document was supplied with no schema and there are no cached instructions for the hash")
+
+	ErrMissingRequiredField = errors.New("required field missing from document schema")
+	ErrUnknownField         = errors.New("unknown field in document schema") // see WithDisallowUnknownFields
+	ErrSchemaHashMismatch   = errors.New("schema hash mismatch")
+
+	ErrSchemaMismatch    = errors.New("schema mismatch")          // the document's schema can't be decoded into the type
+	ErrFieldTypeMismatch = errors.New("field type mismatch")      // a field has a different type to the decoder's, see FieldTypeError
+	ErrTruncatedDocument = errors.New("truncated glint document") // the document ends part way through a value
+	ErrLimitExceeded     = errors.New("decode limit exceeded")    // the document breaks one of the decoder's DecodeLimits
 )
 
+// FieldTypeError is returned when a field of a document has a different type to the same field of the type it's
+// decoded into. It matches both ErrFieldTypeMismatch and ErrSchemaMismatch with errors.Is.
+type FieldTypeError struct {
+	Field    string   // the name of the field
+	Expected WireType // the decoder's type for the field
+	Got      WireType // the document's type for the field
+}
+
+// Error implements the error interface
+func (e *FieldTypeError) Error() string {
+	return fmt.Sprintf("schema mismatch for field %q, expected id %v got %v", e.Field, e.Expected, e.Got)
+}
+
+// Unwrap returns the sentinel errors the mismatch matches
+func (e *FieldTypeError) Unwrap() []error {
+	return []error{ErrFieldTypeMismatch, ErrSchemaMismatch}
+}
+
+// recoveredError turns the value recovered from a panic while reading a document into an error wrapping
+// ErrInvalidDocument, along with whatever the panic was raised with, such as ErrTruncatedDocument or ErrLimitExceeded.
+// PathErrors, which already say where reading failed, are returned as they are, and a context cancelled while a
+// document is counted returns its own error, see countDocument.
+func recoveredError(rc any) error {
+	switch e := rc.(type) {
+	case *PathError:
+		return e
+	case cancelled:
+		return e.err
+	}
+	return recoveredAs(ErrInvalidDocument, rc)
+}
+
+// recoveredAs turns the value recovered from a panic into an error wrapping invalid, along with whatever the panic
+// was raised with. The Reader checks its own bounds, panicking with ErrTruncatedDocument, so runtime errors such as
+// an index out of range are bugs rather than malformed input, and are panicked with again.
+func recoveredAs(invalid error, rc any) error {
+	switch e := rc.(type) {
+	case runtime.Error:
+		panic(e)
+	case error:
+		return fmt.Errorf("%w: %w", invalid, e)
+	}
+	return fmt.Errorf("%w: %v", invalid, rc)
+}
+
+// recoverErr is deferred by functions that read documents with a Reader, which panics on malformed input, to return
+// the panic as their error instead, see recoveredError. Results other than err are left as the panic found them.
+func recoverErr(err *error) {
+	if rc := recover(); rc != nil {
+		*err = recoveredError(rc)
+	}
+}
+
+// recoverErrAs is recoverErr for functions reading something other than a document, such as a container or a
+// plan, returning the panic as an error wrapping invalid rather than ErrInvalidDocument
+func recoverErrAs(err *error, invalid error) {
+	if rc := recover(); rc != nil {
+		*err = recoveredAs(invalid, rc)
+	}
+}
+
+// PathError is an error decoding a particular field, giving the chain of fields leading to it. Fields of nested
+// structs follow their parent's name after a dot, and the elements of slices and maps follow in brackets, by index
+// or by string key, e.g. users[3].preferences.theme. Schema errors apply to every element of a slice or map, so
+// their paths leave the brackets out.
+type PathError struct {
+	Path string // the path of the field
+	Err  error  // the error decoding it
+}
+
+// Error implements the error interface
+func (e *PathError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error decoding the field
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// atField adds the name of the field whose value raised err to the start of the error's path
+func atField(name []byte, err error) error {
+	switch e := err.(type) {
+	case *PathError:
+		path := string(name) + "." + e.Path
+		if strings.HasPrefix(e.Path, "[") {
+			path = string(name) + e.Path
+		}
+		return &PathError{Path: path, Err: e.Err}
+	case fieldErrors:
+		errs := make(fieldErrors, len(e))
+		for i := range e {
+			errs[i] = atField(name, e[i])
+		}
+		return errs
+	}
+	return &PathError{Path: string(name), Err: err}
+}
+
+// failedPath returns the path of the field whose value stopped the body of doc from being decoded, found by
+// walking the document with its own schema until the walk fails too. It returns "" when the document is
+// well-formed as far as its schema goes, such as when the failure is the decoder's alone, like a broken limit.
+func (d *decoderImpl) failedPath(doc []byte) (path string) {
+	var at string
+	defer func() {
+		if rc := recover(); rc != nil {
+			path = at
+		}
+	}()
+
+	p, err := splitDocument(doc)
+	if err != nil {
+		return ""
+	}
+	if p.schema.BytesLeft() == 0 {
+		if d.registry == nil {
+			return ""
+		}
+		if p.schema, err = d.registry.resolve(p); err != nil {
+			return ""
+		}
+	}
+	if p.inlineSchema(d.limits) != nil || checkNesting(p.schema, d.limits) != nil {
+		return ""
+	}
+
+	fields, body := parseSchemaNodes(p.schema), p.body
+	for i := range fields {
+		walkPath(fields[i].wire, &fields[i], fields[i].name, &at, &body)
+	}
+	return ""
+}
+
+// walkPath reads a value described by t from r, as skipValue does, setting at to the path of each value before
+// it's read
+func walkPath(wire WireType, t *schemaNode, path string, at *string, r *Reader) {
+	*at = path
+
+	switch {
+	case wire&WirePtrFlag > 0:
+		if r.ReadByte() != 0 {
+			walkPath(wire&^WirePtrFlag, t, path, at, r)
+		}
+
+	case wire&WireSliceFlag > 0 && wire&(WireSparseFlag|WireBitmapFlag|WireDeltaFlag|WireDictFlag) == 0:
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			walkPath(t.elem.wire, t.elem, fmt.Sprintf("%s[%d]", path, i), at, r)
+		}
+
+	case wire == WireStruct:
+		for i := range t.fields {
+			walkPath(t.fields[i].wire, &t.fields[i], path+"."+t.fields[i].name, at, r)
+		}
+
+	case wire == WireMap:
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			entry := fmt.Sprintf("%s[%d]", path, i)
+			*at = entry
+			if t.key.wire == WireString {
+				entry = fmt.Sprintf("%s[%q]", path, r.ReadString())
+			} else {
+				skipValue(t.key.wire, t.key, r)
+			}
+			walkPath(t.value.wire, t.value, entry, at, r)
+		}
+
+	default: // values holding no fields of their own, packed slices included
+		skipValue(wire, t, r)
+	}
+}
+
+// SchemaHashError is returned by decoders built WithStrictSchemaHash, and by Document.Validate, for documents
+// whose schema doesn't match the hash in their header. It matches both ErrSchemaHashMismatch and
+// ErrInvalidDocument with errors.Is.
+type SchemaHashError struct {
+	Header uint32 // the hash written in the document's header
+	Schema uint32 // the hash of the schema the document carries
+}
+
+// Error implements the error interface
+func (e *SchemaHashError) Error() string {
+	return fmt.Sprintf("%v: %v: header has %08x, schema hashes to %08x", ErrInvalidDocument, ErrSchemaHashMismatch, e.Header, e.Schema)
+}
+
+// Unwrap returns the sentinel errors the mismatch matches
+func (e *SchemaHashError) Unwrap() []error {
+	return []error{ErrInvalidDocument, ErrSchemaHashMismatch}
+}
+
+// checkSchemaHash compares the hash in a document's header with the hash of the schema it carries, which covers
+// everything between the header and the body, the schema's length included
+func checkSchemaHash(doc []byte, p documentParts) error {
+	header := binary.LittleEndian.Uint32(p.hash)
+	if schema := crc32.ChecksumIEEE(doc[5 : len(doc)-len(p.written)]); schema != header {
+		return &SchemaHashError{Header: header, Schema: schema}
+	}
+	return nil
+}
+
+// readDocument reads a whole document from r, bounded by the MaxSchemaSize and MaxDocumentSize limits
+func readDocument(r io.Reader, limits DecodeLimits) ([]byte, error) {
+	var doc bytes.Buffer
+
+	// the flags, hash and schema length, which is read a byte at a time so nothing past it is consumed
+	head := make([]byte, 5, 5+binary.MaxVarintLen64)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrInvalidDocument, err)
+	}
+	for {
+		if _, err := io.ReadFull(r, head[len(head):len(head)+1]); err != nil {
+			return nil, fmt.Errorf("%w: reading schema length: %v", ErrInvalidDocument, err)
+		}
+		head = head[:len(head)+1]
+		if head[len(head)-1] < 0x80 {
+			break
+		}
+		if len(head) == cap(head) {
+			return nil, fmt.Errorf("%w: schema length overflows", ErrInvalidDocument)
+		}
+	}
+	doc.Write(head)
+
+	l, _ := binary.Uvarint(head[5:])
+	if limits.MaxSchemaSize > 0 && l > uint64(limits.MaxSchemaSize) {
+		return nil, fmt.Errorf("%w: schema length %d exceeds limit %d", ErrInvalidDocument, l, limits.MaxSchemaSize)
+	}
+	if limits.MaxDocumentSize > 0 && uint64(doc.Len())+l > uint64(limits.MaxDocumentSize) {
+		return nil, fmt.Errorf("%w: document exceeds limit %d", ErrInvalidDocument, limits.MaxDocumentSize)
+	}
+	if _, err := io.CopyN(&doc, r, int64(l)); err != nil {
+		return nil, fmt.Errorf("%w: reading schema: %v", ErrInvalidDocument, err)
+	}
+
+	// the body runs to the end of the reader
+	body := r
+	if limits.MaxDocumentSize > 0 {
+		body = io.LimitReader(r, int64(limits.MaxDocumentSize)-int64(doc.Len())+1)
+	}
+	if _, err := doc.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	if limits.MaxDocumentSize > 0 && uint(doc.Len()) > limits.MaxDocumentSize {
+		return nil, fmt.Errorf("%w: document exceeds limit %d", ErrInvalidDocument, limits.MaxDocumentSize)
+	}
+
+	return doc.Bytes(), nil
+}
+
 // DecoderContext supports trusted schema mode with an instruction cache and caller-defined affinity ID
 type DecoderContext struct {
 	InstructionCache *DecodeInstructionLookup
 	ID               uint
+	Context          context.Context // cancels the decode, checked as the document is counted before it's decoded
+	Arena            *Arena          // what strings, slices and pointer fields are allocated from, when set
 	// Warning: non-static fields here cause allocations when passed to function pointers.
 	// Verify with escape analysis and benchmarks before adding fields.
 }
@@ -256,32 +922,84 @@ func (d *decoderImpl) Unmarshal(bytes []byte, s any) error {
 
 }
 
-func (d *decoderImpl) UnmarshalWithContext(bytes []byte, s any, context DecoderContext) error {
+func (d *decoderImpl) UnmarshalWithContext(bytes []byte, s any, context DecoderContext) (err error) {
+
+	if d.metrics != nil { // deferred first, so it sees the error recovered below
+		defer d.decoded(len(bytes), time.Now(), &err)
+	}
 
 	if len(bytes) < 5 {
 		return ErrInvalidDocument
 	}
 
+	var errs fieldErrors // the errors of fields skipped in collecting mode
+	decoding := false    // whether the body is being read, so that a failure can be traced to a field
+
+	defer func() {
+		if rc := recover(); rc != nil { // malformed bodies panic part way through reading, surface that as an error
+			err = recoveredError(rc) // keep errors raised while decoding, like ErrDuplicateMapKey
+			if decoding {
+				if path := d.failedPath(bytes); path != "" {
+					err = &PathError{Path: path, Err: err}
+				}
+			}
+			if len(errs) > 0 {
+				err = errors.Join(append(errs, err)...)
+			}
+		}
+	}()
+
 	if d.numfield == 0 {
 		return nil
 	}
 
-	// Reader traverses the document using value semantics (not pointers) to ensure stack allocation.
+	if context.InstructionCache == nil { // a context may be supplied just for its Context
+		context.InstructionCache = &d.cache
+	}
+
+	// Readers traverse the document using value semantics (not pointers) to ensure stack allocation.
 	// Function pointers prevent escape analysis from proving pointer safety, so we pass/return
 	// by value (similar to append) to avoid heap allocation.
-	r := NewReader(bytes)
+	parts, err := splitDocument(bytes)
+	if err != nil {
+		return err
+	}
+
+	if d.strictHash && parts.schema.BytesLeft() > 0 {
+		if err := checkSchemaHash(bytes, parts); err != nil {
+			return err
+		}
+	}
+
+	if d.policy != nil {
+		if err := d.policy.check(parts, d.registry, d.limits); err != nil {
+			return err
+		}
+	}
 
-	var _ = r.ReadByte() // flags
-	hash := r.Read(4)
-	schema := NewReader(r.Read(uint(r.ReadVarint())))
-	body := NewReader(r.Remaining())
+	hash := parts.hash
+	schema := parts.schema
+	body := parts.body
 
 	d.lastHash = binary.LittleEndian.Uint32(hash)
 
-	var err error
 	var instructions []decodeInstruction // the full list of instructions needed to decode the given schema, including skips
 
+	counted := d.limits.budgeted() || context.Context != nil // whether the document is counted before it's decoded
+
 	ins, okl := context.InstructionCache.get(hash) // do we have a cached set of instructions?
+	if okl && counted {
+		// the document may be without a schema of its own, so it's counted with the one kept alongside the
+		// instructions, caches filled without one are filled again
+		if kept := context.InstructionCache.schema(hash); kept != nil {
+			schema = NewReader(kept)
+		} else {
+			okl = false
+		}
+	}
+	if d.metrics != nil {
+		d.metrics.SchemaCache(d.name, okl)
+	}
 	if okl {
 		instructions = ins
 
@@ -289,60 +1007,313 @@ func (d *decoderImpl) UnmarshalWithContext(bytes []byte, s any, context DecoderC
 	} else {
 
 		if schema.BytesLeft() == 0 {
-			return ErrSchemaNotFound
+			if d.registry == nil {
+				return ErrSchemaNotFound
+			}
+			if parts.schema, err = d.registry.resolve(parts); err != nil {
+				return err
+			}
+		}
+
+		if err := parts.inlineSchema(d.limits); err != nil {
+			return err
+		}
+		schema = parts.schema
+
+		if err := checkSchema(schema, d.limits); err != nil {
+			return err
 		}
 
 		ins := [10]decodeInstruction{} // fixed-size array for stack allocation; must remain in this scope for performance
 		instructions = ins[:0]
 	}
 
-	instructions, _, err = d.parseSchema(schema, instructions)
-	if err != nil {
+	instructions, _, err = d.parseNamedSchema(schema, parts.names, instructions)
+	if fe, ok := err.(fieldErrors); ok { // asserted rather than found with errors.As, which would move errs to the heap
+		errs = fe
+	} else if err != nil {
 		return err
 	}
-	if !okl {
-		context.InstructionCache.add(hash, instructions, context.ID) // cache per session for reuse
+	if !okl && len(errs) == 0 { // schemas with skipped fields are parsed again, so their errors are reported again
+		var kept []byte
+		if counted {
+			kept = append(kept, schema.Remaining()...) // the document's bytes are the caller's to reuse
+		}
+		context.InstructionCache.add(hash, instructions, kept, context.ID) // cache per session for reuse
 	}
 
 start_values:
+	body.arena = context.Arena
+	body.copies = d.copies
+
+	if counted {
+		if err := countDocument(context.Context, schema, body, d.limits); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+
+	decoding = true
 	body = d.unmarshal(body, instructions, s)
 
 	if len(body.Remaining()) > 0 {
-		return fmt.Errorf("body bytes remaining > 0: %v", len(body.Remaining()))
+		return errors.Join(append(errs, fmt.Errorf("body bytes remaining > 0: %v", len(body.Remaining())))...)
+	}
+
+	if d.validate != nil {
+		if err := d.checkConstraints(bytes, s); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// fieldErrors are the errors of the fields skipped while parsing a schema in collecting mode, see CollectErrors
+type fieldErrors []error
+
+// Error implements the error interface
+func (e fieldErrors) Error() string {
+	return errors.Join(e...).Error()
+}
+
+// checkConstraints validates a decoded value against the constraints carried by its document's schema
+func (d *decoderImpl) checkConstraints(doc []byte, s any) error {
+	set, err := d.validate.lookup(doc, d.registry, d.limits)
+	if err != nil || set == nil {
+		return err
+	}
+	v := reflect.ValueOf(s).Elem()
+	if v.Kind() == reflect.Map {
+		v = reflect.NewAt(rootStruct(v.Type(), "glint"), v.Addr().UnsafePointer()).Elem()
+	}
+	return set.check(v, "glint", "", d.collect)
+}
+
+// checkSchema validates a received schema against the supplied limits before it's parsed. parseSchema, and the
+// decoders it hands nested schemas to, recurse once per level of nesting so a hostile schema could otherwise
+// exhaust the stack before the body is even looked at. Only needed on an instruction cache miss.
+func checkSchema(schema Reader, limits DecodeLimits) (err error) {
+
+	defer recoverErr(&err)
+
+	checkLimit(schema.BytesLeft(), limits.MaxSchemaSize, "schema")
+	checkSchemaFields(schema, 0, limits)
+	return nil
+}
+
+// checkNesting validates the nesting of a schema against the MaxNestingDepth limit alone. It's for readers that
+// recurse over whatever document they're given, such as the Walker and the printer, which don't hold documents to
+// the rest of checkSchema's rules.
+func checkNesting(schema Reader, limits DecodeLimits) (err error) {
+	if limits.MaxNestingDepth == 0 {
+		return nil
 	}
 
+	defer recoverErr(&err)
+
+	checkNestingFields(schema, 0, limits)
 	return nil
 }
 
+// checkNestingFields validates the nesting of a list of schema fields, which sit at the supplied depth
+func checkNestingFields(r Reader, depth uint, limits DecodeLimits) {
+	for r.BytesLeft() > 0 {
+		wire := WireType(r.ReadVarint())
+		r.Read(uint(r.ReadByte()))
+		checkNestingNode(wire, &r, depth+1, limits)
+	}
+}
+
+// checkNestingNode validates the nesting of the sub-schema belonging to wire, following the same layout as
+// checkSchemaNode
+func checkNestingNode(wire WireType, r *Reader, depth uint, limits DecodeLimits) {
+	checkNestingDepth(depth, limits)
+
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case base == WireSliceFlag: // slice of slices or of pointers, the element type follows
+		checkNestingNode(WireType(r.ReadVarint()), r, depth+1, limits)
+
+	case base&WireSliceFlag > 0:
+		checkNestingNode(base&WireTypeMask, r, depth+1, limits)
+
+	case base == WireStruct:
+		checkNestingFields(NewReader(r.Read(r.ReadVarint())), depth, limits)
+
+	case base == WireMap:
+		key, value := WireType(r.ReadVarint()), WireType(r.ReadVarint())
+		checkNestingNode(key, r, depth+1, limits)
+		checkNestingNode(value, r, depth+1, limits)
+	}
+}
+
+// checkSchemaFields validates a list of schema fields, which sit at the supplied depth
+func checkSchemaFields(r Reader, depth uint, limits DecodeLimits) {
+	var seen [16][]byte
+	names := seen[:0]
+
+	for fields := uint(1); r.BytesLeft() > 0; fields++ {
+		checkLimit(fields, limits.MaxFieldsPerSchema, "schema fields")
+
+		wire := WireType(r.ReadVarint())
+		name := r.Read(uint(r.ReadByte()))
+		checkSchemaNode(wire, &r, depth+1, limits)
+
+		// a field written twice would be decoded twice, with the first value silently overwritten
+		for _, n := range names {
+			if string(n) == string(name) {
+				panic(fmt.Sprintf("duplicate field %q", name))
+			}
+		}
+		names = append(names, name)
+	}
+}
+
+// checkSchemaNode validates the sub-schema belonging to wire, following the same layout as parseSchemaNode
+func checkSchemaNode(wire WireType, r *Reader, depth uint, limits DecodeLimits) {
+	checkSchemaDepth(depth, limits)
+
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case base == WireSliceFlag: // slice of slices or of pointers, the element type follows
+		checkSchemaNode(WireType(r.ReadVarint()), r, depth+1, limits)
+
+	case base&WireSliceFlag > 0:
+		checkSchemaNode(base&WireTypeMask, r, depth+1, limits)
+
+	case base == WireStruct:
+		checkSchemaFields(NewReader(r.Read(r.ReadVarint())), depth, limits)
+
+	case base == WireMap:
+		key, value := WireType(r.ReadVarint()), WireType(r.ReadVarint())
+		checkSchemaNode(key, r, depth+1, limits)
+		checkSchemaNode(value, r, depth+1, limits)
+	}
+}
+
 // parseSchema transforms the received schema into an ordered instruction list using our pre-built lookups
 func (d *decoderImpl) parseSchema(schema Reader, instructions []decodeInstruction) ([]decodeInstruction, Reader, error) {
+	return d.parseNamedSchema(schema, Reader{}, instructions)
+}
+
+// parseNamedSchema is parseSchema for a schema followed by the hashes of its field names, see namehash.go. names
+// is empty for schemas without them.
+func (d *decoderImpl) parseNamedSchema(schema, names Reader, instructions []decodeInstruction) ([]decodeInstruction, Reader, error) {
+	var errs fieldErrors // fields skipped in collecting mode, see CollectErrors
 
 start_schema:
 	// Build execution order by matching schema field names to our stored decoder functions.
 	// The resulting instruction array can be cached for future documents with the same schema.
 
 	if schema.BytesLeft() == 0 {
-		return instructions, schema, nil
+		if err := d.checkRequired(instructions); err != nil {
+			if !d.collect {
+				return nil, schema, err
+			}
+			errs = append(errs, err.(fieldErrors)...)
+		}
+		if len(errs) > 0 {
+			return d.appendDefaults(instructions), schema, errs
+		}
+		return d.appendDefaults(instructions), schema, nil
 	}
 
 	// each schema entry has 3 core elements - specialized types may include extra data
 	wireType := WireType(schema.ReadVarint())
 	nameLen := schema.ReadByte()
 	name := schema.Read(uint(nameLen))
+	written, sub := wireType, schema // fields that fail in collecting mode are skipped from here, as unknown fields are
+
+	var hash uint64
+	hashed := names.BytesLeft() >= 8
+	if hashed {
+		hash = binary.LittleEndian.Uint64(names.Read(8))
+	}
 
 	var di decodeInstruction
 	var ok bool
 	if len(name) < smallKeys { // fast path for small names
 		di, ok = d.trie.Get(*(*string)(unsafe.Pointer(&name)))
-	} else {
+	} else if di, ok = d.hashed[hash]; !hashed || !ok || di.tag != string(name) { // no hash, or a collision
 		di, ok = d.lookup[*(*string)(unsafe.Pointer(&name))]
 	}
+	if !ok && d.matching != matchExact {
+		di, ok = d.foldedField(name)
+	}
+	if !ok && d.disallowUnknown {
+		err := fmt.Errorf("%w: %q", ErrUnknownField, name)
+		if !d.collect {
+			return nil, schema, err
+		}
+		errs = append(errs, err) // skipped as usual, so the rest of the document's fields are still checked
+	}
+
+	// slices may be written with a presence byte so that nil survives the roundtrip. Those decode with the same
+	// instructions as slices without one, wrapped to read the presence byte first.
+	nilSlice := false
+	if ok && wireType&WirePtrFlag > 0 && di.kind&WirePtrFlag == 0 && isSliceWire(wireType) {
+		nilSlice = true
+		wireType ^= WirePtrFlag
+	}
+
+	// sparse slices are read by the same decoders as dense ones, any numeric slice field can be sent either way
+	sparse := false
+	if ok && wireType&WireSparseFlag > 0 {
+		sparse = true
+		wireType ^= WireSparseFlag
+	}
+
+	// bools may be packed into a bitmap, any bool slice field can be read from either
+	bitmap := false
+	if ok && wireType&WireBitmapFlag > 0 {
+		bitmap = true
+		wireType ^= WireBitmapFlag
+	}
+
+	// strings may be written to the document's string table, any string field can be read from either
+	dict := false
+	if ok && wireType&WireDictFlag > 0 {
+		dict = true
+		wireType ^= WireDictFlag
+	}
+
+	// int64s and durations may be written as zigzag varints, any field of either can be read from both
+	zigzag := false
+	if ok && wireType&WireZigzagFlag > 0 {
+		zigzag = true
+		wireType ^= WireZigzagFlag
+	}
+
+	// durations were written as WireInt64 before they had a wire type of their own, and have the same body, so
+	// either decodes as the other
+	if ok && durationCompatible(di.kind, wireType) {
+		di.kind = wireType
+	}
+
+	// slices of pointers to structs mark the presence byte before each element on the element's own wire type,
+	// which follows, and any slice of structs field reads them, see formatVersionPointers
+	if ok && di.kind == WireSliceFlag|WireStruct && pointerElements(wireType, schema) {
+		di.kind = wireType
+	}
+
+	if ok && d.coerce && di.kind&^wireCustom != wireType {
+		if fun, coerced := coercedField(di, wireType); coerced {
+			di.fun, di.kind = fun, wireType|wireCustom // keeps the field off the fast paths, which read the field's own type
+		}
+	}
 
 	// if the field name is not in the trie/lookup then we'll skip it
-	if ok && di.kind != WireType(wireType) {
-		return nil, schema, fmt.Errorf("schema mismatch for field %q, expected id %v got %v", name, di.kind, wireType)
+	if ok && di.kind&^wireCustom != WireType(wireType) {
+		err := atField(name, &FieldTypeError{Field: string(name), Expected: di.kind &^ wireCustom, Got: wireType})
+		if !d.collect {
+			return nil, schema, err
+		}
+		errs, ok, wireType, schema = append(errs, err), false, written, sub
 	}
 
+skip_field:
 	if !ok {
 		// unknown field in schema - create skip instruction to bypass it.
 		// wireType gets signed to distinguish from actual body instructions.
@@ -350,7 +1321,7 @@ start_schema:
 		switch {
 		case wireType&^WirePtrFlag == WireStruct:
 			// unknown struct field - build temporary decoder to navigate past all its sub-fields
-			dec := newDecoder(struct{}{})
+			dec := newDecoderWithLimits(struct{}{}, d.limits)
 			sl := schema.ReadVarint()
 
 			ins, _, err := dec.parseSchema(NewReader(schema.Read(sl)), nil) // parse unwanted object's schema for skipping
@@ -362,13 +1333,22 @@ start_schema:
 				return dec.unmarshal(r, ins, struct{}{}) // discard data by decoding to empty struct
 			}
 			if wireType&WirePtrFlag > 0 {
-				skipfun = deref(skipfun, wireType, di.subType)
+				skipfun = skipAbsent(skipfun)
 			}
 			instructions = append(instructions, decodeInstruction{fun: skipfun, tag: string(name), optimizable: false})
 
+		case wireType&WireTypeMask == WireDynamic:
+			// values of any type, see dynamic.go, for a field that isn't a []any
+			t := parseSchemaNode(wireType, &schema)
+			skipfun := func(p unsafe.Pointer, r Reader) Reader {
+				skipValue(wireType, &t, &r)
+				return r
+			}
+			instructions = append(instructions, decodeInstruction{fun: skipfun, tag: string(name), kind: wireType, optimizable: false})
+
 		case wireType&WireSliceFlag > 0:
 
-			dec := sliceDecoder{wireType: wireType}
+			dec := sliceDecoder{wireType: wireType &^ WirePtrFlag, limits: d.limits}
 
 			var err error
 			_, schema, err = dec.parseSchema(schema, nil)
@@ -380,12 +1360,12 @@ start_schema:
 				return dec.unmarshal(r, nil, []struct{}{}) // discard slice data by decoding to empty slice
 			}
 			if wireType&WirePtrFlag > 0 {
-				skipfun = deref(skipfun, wireType, di.subType)
+				skipfun = skipAbsent(skipfun)
 			}
 			instructions = append(instructions, decodeInstruction{fun: skipfun, tag: string(name), kind: wireType, optimizable: false})
 		case wireType&WireTypeMask == WireMap:
 
-			dec := mapDecoder{}
+			dec := mapDecoder{limits: d.limits}
 
 			var err error
 			_, schema, err = dec.parseSchema(schema, nil)
@@ -397,7 +1377,7 @@ start_schema:
 				return dec.unmarshal(r, nil, make(map[string]struct{}))
 			}
 			if wireType&WirePtrFlag > 0 {
-				skipfun = deref(skipfun, wireType, di.subType)
+				skipfun = skipAbsent(skipfun)
 			}
 			instructions = append(instructions, decodeInstruction{fun: skipfun, tag: string(name), optimizable: false})
 
@@ -412,11 +1392,50 @@ start_schema:
 	switch {
 
 	case wireType&WireSliceFlag > 0 || wireType == WireMap:
-		di.subdec.setWireType(wireType)
+		di.subdec.setWireType(wireType &^ WirePtrFlag) // pointers are dereferenced before the sub-decoder runs
 		var err error
 		_, schema, err = di.subdec.parseSchema(schema, nil)
 		if err != nil {
-			return nil, schema, err
+			err = atField(name, err)
+			if !d.collect {
+				return nil, schema, err
+			}
+			errs, ok, wireType, schema = append(errs, err), false, written, sub
+			goto skip_field
+		}
+
+		// the slice decoder is shared by every schema we see, so whether this field was sent sparse is settled
+		// on the instruction rather than on the decoder
+		if sparse {
+			sd, isSlice := di.subdec.(*sliceDecoder)
+			if !isSlice || sd.sparse == nil || wireType&WirePtrFlag > 0 {
+				err := atField(name, fmt.Errorf("%w: sparse encoding is not supported for field %q of type %v", ErrSchemaMismatch, name, wireType))
+				if !d.collect {
+					return nil, schema, err
+				}
+				errs, ok, wireType, schema = append(errs, err), false, written, sub
+				goto skip_field
+			}
+			di.fun = sd.sparse
+			if di.subType != nil && di.subType.Kind() == reflect.Array {
+				di.fun = arrayRead(di.subType, sd.sparse)
+			}
+		}
+
+		if bitmap {
+			sd, isSlice := di.subdec.(*sliceDecoder)
+			if !isSlice || sd.bitmap == nil || wireType&WirePtrFlag > 0 {
+				err := atField(name, fmt.Errorf("%w: bitmap encoding is not supported for field %q of type %v", ErrSchemaMismatch, name, wireType))
+				if !d.collect {
+					return nil, schema, err
+				}
+				errs, ok, wireType, schema = append(errs, err), false, written, sub
+				goto skip_field
+			}
+			di.fun = sd.bitmap
+			if di.subType != nil && di.subType.Kind() == reflect.Array {
+				di.fun = arrayRead(di.subType, sd.bitmap)
+			}
 		}
 
 	case wireType == WireStruct || wireType^WirePtrFlag == WireStruct:
@@ -425,7 +1444,12 @@ start_schema:
 
 		subinstr, _, err := di.subdec.parseSchema(NewReader(schemaBody), nil) // build nested instructions from sub-decoder
 		if err != nil {
-			return nil, schema, err
+			err = atField(name, err)
+			if !d.collect {
+				return nil, schema, err
+			}
+			errs, ok, wireType, schema = append(errs, err), false, written, sub
+			goto skip_field
 		}
 
 		di.fun = func(p unsafe.Pointer, r Reader) Reader {
@@ -441,11 +1465,101 @@ start_schema:
 
 	}
 
+	if dict {
+		sd, isSlice := di.subdec.(*sliceDecoder)
+		switch {
+		case di.kind == WireString:
+			di.kind |= WireDictFlag // keeps the field off the fast paths
+			di.fun = dictStringRead
+		case isSlice && sd.dict != nil && wireType&WirePtrFlag == 0:
+			di.fun = sd.dict
+			if di.subType != nil && di.subType.Kind() == reflect.Array {
+				di.fun = arrayRead(di.subType, sd.dict)
+			}
+		default:
+			err := atField(name, fmt.Errorf("%w: dictionary encoding is not supported for field %q of type %v", ErrSchemaMismatch, name, wireType))
+			if !d.collect {
+				return nil, schema, err
+			}
+			errs, ok, wireType, schema = append(errs, err), false, written, sub
+			goto skip_field
+		}
+	}
+
+	if zigzag {
+		sd, isSlice := di.subdec.(*sliceDecoder)
+		switch base := wireType &^ WirePtrFlag; {
+		case base == WireInt64 || base == WireDuration:
+			di.kind |= WireZigzagFlag // keeps the field off the fast paths
+			di.fun = zigzagInt64Read
+			if wireType&WirePtrFlag > 0 {
+				di.fun = deref(zigzagInt64Read, wireType, di.subType)
+			}
+		case isSlice && sd.zigzag != nil && wireType&WirePtrFlag == 0:
+			di.fun = sd.zigzag
+			if di.subType != nil && di.subType.Kind() == reflect.Array {
+				di.fun = arrayRead(di.subType, sd.zigzag)
+			}
+		default:
+			err := atField(name, fmt.Errorf("%w: zigzag encoding is not supported for field %q of type %v", ErrSchemaMismatch, name, wireType))
+			if !d.collect {
+				return nil, schema, err
+			}
+			errs, ok, wireType, schema = append(errs, err), false, written, sub
+			goto skip_field
+		}
+	}
+
+	if nilSlice {
+		di.kind |= WirePtrFlag // keep the slice off the fast paths, the presence byte needs reading first
+		if di.subType != nil && di.subType.Kind() == reflect.Array {
+			di.fun = nilArrayDeref(di.subType, di.fun)
+		} else {
+			di.fun = nilSliceDeref(di.fun)
+		}
+	}
+
+	if di.copy {
+		di.fun = copiedField(d, di)
+		di.kind |= wireCustom // keeps the field off the fast paths
+	}
+
 	instructions = append(instructions, di)
 
 	goto start_schema
 }
 
+// copiedField wraps the decoding of a field with the copy tag option, so that the strings and byte slices within
+// it are copied out of the document unless the decoder was built WithZeroCopyStrings
+func copiedField(d *decoderImpl, di decodeInstruction) func(unsafe.Pointer, Reader) Reader {
+	di.offset = 0 // the field is decoded at the pointer it's given
+	field := []decodeInstruction{di}
+
+	return func(p unsafe.Pointer, r Reader) Reader {
+		if r.copies != copyTagged {
+			return d.unmarshal(r, field, p)
+		}
+		r.copies = copyAll
+		r = d.unmarshal(r, field, p)
+		r.copies = copyTagged
+		return r
+	}
+}
+
+// durationCompatible reports whether a and b differ only in one being WireDuration where the other is WireInt64
+func durationCompatible(a, b WireType) bool {
+	if a&^WireTypeMask != b&^WireTypeMask {
+		return false
+	}
+	a, b = a&WireTypeMask, b&WireTypeMask
+	return (a == WireDuration && b == WireInt64) || (a == WireInt64 && b == WireDuration)
+}
+
+// isSliceWire reports whether wire describes a slice, including byte slices
+func isSliceWire(wire WireType) bool {
+	return wire&WireSliceFlag > 0 || wire&^WirePtrFlag == WireBytes
+}
+
 // unmarshal executes compiled instructions to populate the struct with data from the reader.
 func (d *decoderImpl) unmarshal(body Reader, instructions []decodeInstruction, s any) Reader {
 
@@ -468,7 +1582,7 @@ func (d *decoderImpl) unmarshal(body Reader, instructions []decodeInstruction, s
 			*(*int16)(unsafe.Add(p, instructions[i].offset)) = body.ReadInt16()
 		case WireInt32:
 			*(*int32)(unsafe.Add(p, instructions[i].offset)) = body.ReadInt32()
-		case WireInt64:
+		case WireInt64, WireDuration:
 			*(*int64)(unsafe.Add(p, instructions[i].offset)) = body.ReadInt64()
 		case WireUint:
 			*(*uint)(unsafe.Add(p, instructions[i].offset)) = body.ReadUint()
@@ -487,11 +1601,10 @@ func (d *decoderImpl) unmarshal(body Reader, instructions []decodeInstruction, s
 		case WireString:
 			l := body.ReadVarint()
 			if l > body.BytesLeft() {
-				panic(fmt.Sprintf("string length %d exceeds remaining bytes %d", l, body.BytesLeft()))
+				panic(fmt.Errorf("%w: string length %d exceeds remaining bytes %d", ErrTruncatedDocument, l, body.BytesLeft()))
 			}
 
-			b := body.Read(l)
-			*(*string)(unsafe.Add(p, instructions[i].offset)) = *(*string)(unsafe.Pointer(&b))
+			*(*string)(unsafe.Add(p, instructions[i].offset)) = body.str(body.Read(l))
 		case WireTime:
 			*(*time.Time)(unsafe.Add(p, instructions[i].offset)) = body.ReadTime()
 		case WireStruct:
@@ -515,15 +1628,20 @@ func (d *decoderImpl) unmarshal(body Reader, instructions []decodeInstruction, s
 				}
 			}
 
+			if instructions[i].kind&WireDictFlag > 0 { // a reference to the string table
+				body.SkipVarint()
+				continue
+			}
+
 			switch instructions[i].kind & WireTypeMask {
 
 			case WireInt, WireInt16, WireInt32, WireInt64,
 				WireUint, WireUint16, WireUint32, WireUint64,
-				WireFloat32, WireFloat64:
+				WireFloat32, WireFloat64, WireDuration:
 
 				body.SkipVarint()
 
-			case WireString, WireBytes, WireTime:
+			case WireString, WireBytes, WireTime, WireIP, WireIPPrefix, WireBigInt, WireDecimal:
 				body.Skip(body.ReadVarint())
 
 			case WireBool, WireInt8, WireUint8:
@@ -0,0 +1,185 @@
+package glint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Strings and slices of strings tagged `dict` are written to a string table shared by the whole document, once
+// for each distinct value, with the fields themselves holding the index of their value in the table. Documents
+// that repeat a few strings many times, such as enums, country codes or host names, shrink by the size of every
+// repeat. Dictionary strings have WireDictFlag set in their schema, so decoders read either form into any string
+// field, whatever its own tags.
+//
+// Strings are added to the table as the body is written, so the table follows the body, with its size in a fixed
+// four bytes at the very end so that it can be found without reading the body first:
+//
+//	[flags][crc32][schema length][schema][body][table][table size (uint32, little endian)]
+//
+// The table is [count (varint)]([length (varint)][bytes])*, and documents carrying one set flagStringTable in their
+// flags byte. Documents without any dictionary strings are written exactly as they would be without the tag.
+//
+// Decoded strings point into the table, as strings point into the body, so they share memory with the document.
+
+// stringTable holds the strings written to a document's string table so far
+type stringTable struct {
+	index  map[string]uint
+	values []string
+}
+
+// appendDictString writes s as a reference to the document's string table, adding it to the table when it's new
+func (b *Buffer) appendDictString(s string) {
+	i, ok := b.strings.index[s]
+	if !ok {
+		if b.strings.index == nil {
+			b.strings.index = map[string]uint{}
+		}
+		i = uint(len(b.strings.values))
+		b.strings.index[s] = i
+		b.strings.values = append(b.strings.values, s)
+	}
+	b.AppendUint(i)
+}
+
+// resetStrings empties the string table, ready for the next document
+func (b *Buffer) resetStrings() {
+	if len(b.strings.values) == 0 {
+		return
+	}
+	for s := range b.strings.index {
+		delete(b.strings.index, s)
+	}
+	for i := range b.strings.values {
+		b.strings.values[i] = "" // don't hold on to the values of pooled buffers
+	}
+	b.strings.values = b.strings.values[:0]
+}
+
+// appendStringTable writes the string table after the body, reporting whether the document had one. The table is
+// emptied once it's written.
+func (b *Buffer) appendStringTable() bool {
+	if len(b.strings.values) == 0 {
+		return false
+	}
+
+	start := len(b.Bytes)
+	b.AppendUint(uint(len(b.strings.values)))
+	for _, s := range b.strings.values {
+		b.AppendString(s)
+	}
+	b.Bytes = binary.LittleEndian.AppendUint32(b.Bytes, uint32(len(b.Bytes)-start))
+
+	b.resetStrings()
+	return true
+}
+
+// splitStringTable separates the string table from the end of the body and reads it
+func (p *documentParts) splitStringTable() error {
+	body := p.body.Remaining()
+	if len(body) < 4 {
+		return fmt.Errorf("%w: string table size exceeds document length", ErrInvalidDocument)
+	}
+
+	size := binary.LittleEndian.Uint32(body[len(body)-4:])
+	if uint64(size) > uint64(len(body)-4) {
+		return fmt.Errorf("%w: string table size %d exceeds document length", ErrInvalidDocument, size)
+	}
+
+	split := len(body) - 4 - int(size)
+	strings, err := readStringTable(body[split : len(body)-4])
+	if err != nil {
+		return err
+	}
+
+	p.body = Reader{bytes: body[:split], strings: &strings}
+	return nil
+}
+
+// readStringTable reads the strings of a table written by appendStringTable
+func readStringTable(table []byte) (strings []string, err error) {
+	defer recoverErr(&err)
+
+	r := NewReader(table)
+	n := r.ReadVarint()
+	if n > r.BytesLeft() { // every string takes at least a byte for its length
+		return nil, fmt.Errorf("%w: string table count %d exceeds table size", ErrInvalidDocument, n)
+	}
+
+	values := make([]string, n)
+	for i := range values {
+		values[i] = r.ReadString()
+	}
+	if r.BytesLeft() > 0 {
+		return nil, fmt.Errorf("%w: string table bytes remaining > 0: %v", ErrInvalidDocument, r.BytesLeft())
+	}
+	return values, nil
+}
+
+// dictStringAppend writes a string field to the string table
+func dictStringAppend(p unsafe.Pointer, b *Buffer) {
+	b.appendDictString(*(*string)(p))
+}
+
+// dictStringRead reads a string field from the string table
+func dictStringRead(p unsafe.Pointer, r Reader) Reader {
+	*(*string)(p) = r.ReadDictString()
+	return r
+}
+
+// dictSlice switches a slice encoder over to writing its strings to the string table
+func dictSlice(s *SliceEncoder, t reflect.Type) {
+	if t.Elem().Kind() != reflect.String {
+		panic("dict option requires a string, or a slice or array of strings")
+	}
+	if s.wire&(WireDeltaFlag|WireSparseFlag) > 0 {
+		panic("dict option can't be combined with delta or sparse")
+	}
+
+	s.wire |= WireDictFlag
+	s.instruction = func(p unsafe.Pointer, b *Buffer) {
+		v := *(*[]string)(p)
+		b.AppendUint(uint(len(v)))
+		for i := range v {
+			b.appendDictString(v[i])
+		}
+	}
+}
+
+// dictSliceReader reads a slice of strings written to the string table
+func dictSliceReader(limits DecodeLimits) func(unsafe.Pointer, Reader) Reader {
+	return func(p unsafe.Pointer, r Reader) Reader {
+		l := r.ReadVarint()
+		checkLimit(l, limits.MaxSliceElements, "slice")
+		if l > r.BytesLeft() { // every reference takes at least a byte
+			panic(fmt.Errorf("%w: slice length %d exceeds remaining bytes %d", ErrTruncatedDocument, l, r.BytesLeft()))
+		}
+
+		s := *(*[]string)(p)
+		if s == nil || uint(cap(s)) < l {
+			c := l
+			if c == 0 {
+				c = 1 // empty slices decode as non-nil, as they do for inline strings
+			}
+			s = make([]string, l, c)
+		} else {
+			s = s[:l]
+		}
+
+		for i := range s {
+			s[i] = r.ReadDictString()
+		}
+
+		*(*[]string)(p) = s
+		return r
+	}
+}
+
+// skipDictSlice reads past a slice of strings written to the string table. Each element is a varint.
+func skipDictSlice(p unsafe.Pointer, r Reader) Reader {
+	for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+		r.SkipVarint()
+	}
+	return r
+}
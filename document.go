@@ -0,0 +1,140 @@
+package glint
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Document is a glint document held as bytes. It's the entry point for inspecting a document without decoding
+// it, and implements fmt.Formatter for pretty printing.
+type Document []byte
+
+// String implements fmt.Stringer interface. Documents that can't be printed give the error printing them instead.
+func (d Document) String() string {
+	if len(d) == 0 {
+		return ""
+	}
+	s, err := SPrint([]byte(d))
+	if err != nil {
+		return err.Error()
+	}
+	return s
+}
+
+// Format implements fmt.Formatter interface for custom formatting verbs
+func (d Document) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 's':
+		// %s - pretty printed document
+		f.Write([]byte(d.String()))
+	case 'v':
+		if f.Flag('+') {
+			// %+v - verbose format showing both tree and hex
+			f.Write([]byte(fmt.Sprintf("Glint Document (hex: %x)\n%s", []byte(d), d.String())))
+		} else {
+			// %v - same as %s
+			f.Write([]byte(d.String()))
+		}
+	case 'x':
+		// %x - lowercase hex representation
+		fmt.Fprintf(f, "%x", []byte(d))
+	case 'X':
+		// %X - uppercase hex representation
+		fmt.Fprintf(f, "%X", []byte(d))
+	case 'q':
+		// %q - quoted hex representation
+		fmt.Fprintf(f, "%q", []byte(d))
+	default:
+		// fallback to default []byte formatting
+		fmt.Fprintf(f, "%%!%c(glint.Document=%x)", verb, []byte(d))
+	}
+}
+
+// Hash returns the schema hash from the document's header, or 0 when the document is too short to have one
+func (d Document) Hash() uint32 {
+	if len(d) < 5 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(d[1:5])
+}
+
+// Schema parses the document's schema, with any shared struct schemas expanded. Documents written without their
+// schema return ErrSchemaNotFound.
+func (d Document) Schema() (schema PrinterSchema, err error) {
+
+	defer recoverErr(&err)
+
+	p, err := d.parts(DefaultLimits)
+	if err != nil {
+		return PrinterSchema{}, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return PrinterSchema{}, ErrSchemaNotFound
+	}
+
+	return NewPrinterSchema(&p.schema), nil
+}
+
+// BodyReader returns a Reader positioned at the start of the document's body
+func (d Document) BodyReader() (Reader, error) {
+	p, err := splitDocument(d)
+	if err != nil {
+		return Reader{}, err
+	}
+	return p.body, nil
+}
+
+// Validate checks that the document is well formed without decoding it into a value. The header and schema
+// checksum are checked, the schema is checked against the supplied limits, then the body is read through to make
+// sure it matches the schema exactly.
+//
+// Documents written without their schema, as in trusted schema mode, can't be validated and return
+// ErrSchemaNotFound. SchemaRegistry.Expand can restore the schema of registry documents beforehand.
+func (d Document) Validate(limits DecodeLimits) (err error) {
+	split, err := splitDocument(d)
+	if err != nil {
+		return err
+	}
+	if split.schema.BytesLeft() == 0 {
+		return ErrSchemaNotFound
+	}
+
+	if err := checkSchemaHash(d, split); err != nil {
+		return err
+	}
+
+	p, err := d.parts(limits)
+	if err != nil {
+		return err
+	}
+
+	defer recoverErr(&err)
+
+	// a decoder without fields skips every field in the schema, which reads through the whole body
+	dec := newDecoderWithLimits(struct{}{}, limits)
+	instructions, _, err := dec.parseSchema(p.schema, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDocument, err)
+	}
+
+	if body := dec.unmarshal(p.body, instructions, struct{}{}); body.BytesLeft() > 0 {
+		return fmt.Errorf("%w: %d body bytes remaining", ErrInvalidDocument, body.BytesLeft())
+	}
+
+	return nil
+}
+
+// parts separates the document into its sections, with its schema expanded and checked against the limits
+func (d Document) parts(limits DecodeLimits) (documentParts, error) {
+	p, err := splitDocument(d)
+	if err != nil {
+		return p, err
+	}
+	if err := p.inlineSchema(limits); err != nil {
+		return p, err
+	}
+	if err := checkSchema(p.schema, limits); err != nil {
+		return p, err
+	}
+	return p, nil
+}
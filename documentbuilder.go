@@ -1,7 +1,13 @@
 package glint
 
 import (
+	"encoding/binary"
+	"fmt"
 	"hash/crc32"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"sync"
 	"time"
 )
 
@@ -9,160 +15,440 @@ import (
 type DocumentBuilder struct {
 	schema Buffer
 	body   Buffer
+	err    error // the first problem with a field name too long to be written, reported by Build
+
+	version byte // the format version the document is marked as, see AppendStruct
+
+	crc    uint32 // checksum of the schema bytes up to hashed, see SchemaHash
+	hashed int
 }
 
 // AppendNestedDocument appends another document within this one. Equivalent of a nested struct.
 func (d *DocumentBuilder) AppendNestedDocument(name string, value *DocumentBuilder) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireStruct)
+	d.field(name, WireStruct)
 	d.schema.AppendBytes(value.schema.Bytes)
-	d.body.Bytes = append(d.body.Bytes, value.body.Bytes...)
+	appendBuilt(&d.body, &value.body, WireStruct, value.schema.Bytes)
+	d.noteErr(value.err)
+	d.noteVersion(value.version)
+	return d
+}
+
+// AppendStruct appends a struct within the document as a nested document, written by an Encoder of its type, so
+// that documents can be partly built from known types and partly assembled field by field:
+//
+//	doc.AppendStruct("user", userEncoder, &user).AppendString("source", source)
+//
+// v must be a pointer to the type the encoder was built for, which panics otherwise. The struct is written as the
+// encoder writes it, other than repeated struct schemas being written in full and field constraints being left out,
+// as the document is format version 0, and compression being left to the document as a whole. Encoders built with
+// WithDistinctNilSlices mark the document as format version 1, and structs holding slices of pointers mark it as
+// version 4, as they do their own.
+func (d *DocumentBuilder) AppendStruct(name string, enc StructEncoder, v any) *DocumentBuilder {
+	fields, version := enc.appendStruct(&d.body, v)
+	d.field(name, WireStruct)
+	d.schema.AppendBytes(fields)
+	d.noteVersion(version)
 	return d
 }
 
+// StructEncoder is an Encoder of any type, for appending structs to a DocumentBuilder, see AppendStruct
+type StructEncoder interface {
+	appendStruct(b *Buffer, v any) ([]byte, byte) // writes the struct at v to b, returning its version 0 schema fields
+}
+
+// noteVersion moves the document to format version v, if it's newer than the one the document is marked as
+func (d *DocumentBuilder) noteVersion(v byte) {
+	if v > d.version {
+		d.version = v
+	}
+}
+
+// appendBuilt appends the body of a value put together by another builder to b, along with any dictionary strings
+// the value has. References to the strings are moved along past those b already has, which means reading the value,
+// so wire and schema describe it, with struct schemas being the fields alone.
+func appendBuilt(b *Buffer, value *Buffer, wire WireType, schema []byte) {
+	offset := uint(len(b.strings.values))
+	if offset == 0 || len(value.strings.values) == 0 {
+		b.Bytes = append(b.Bytes, value.Bytes...)
+	} else {
+		var t schemaNode
+		if wire == WireStruct {
+			t = schemaNode{wire: wire, fields: parseSchemaNodes(NewReader(schema))}
+		} else {
+			r := NewReader(schema)
+			t = parseSchemaNode(wire, &r)
+		}
+		r := NewReader(value.Bytes)
+		shiftDictRefs(wire, &t, &r, b, offset)
+	}
+
+	for _, s := range value.strings.values {
+		if _, ok := b.strings.index[s]; !ok {
+			if b.strings.index == nil {
+				b.strings.index = map[string]uint{}
+			}
+			b.strings.index[s] = uint(len(b.strings.values))
+		}
+		b.strings.values = append(b.strings.values, s)
+	}
+}
+
 // AppendSlice adds a slice field to the document against a given name
 func (d *DocumentBuilder) AppendSlice(name string, value SliceBuilder) *DocumentBuilder {
 	if value.wire == 0 {
 		return d // if the slice was empty then it has no type so theres nothing to encode
 	}
 
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireSliceFlag|value.wire)
+	d.field(name, WireSliceFlag|value.wire)
 	if len(value.schema.Bytes) > 0 {
 		d.schema.Bytes = append(d.schema.Bytes, value.schema.Bytes...)
 	}
-	d.body.Bytes = append(d.body.Bytes, value.body.Bytes...)
+	appendBuilt(&d.body, &value.body, WireSliceFlag|value.wire, value.schema.Bytes)
+	d.noteErr(value.err)
+	d.noteVersion(value.version)
+	return d
+}
+
+// AppendStringMap adds a map of strings to strings to the document against a given name
+func (d *DocumentBuilder) AppendStringMap(name string, value map[string]string) *DocumentBuilder {
+	d.field(name, WireMap)
+	d.schema.Bytes = appendVarintb(appendVarintb(d.schema.Bytes, uint64(WireString)), uint64(WireString))
+
+	d.body.AppendUint(uint(len(value)))
+	for k, v := range value {
+		d.body.AppendString(k)
+		d.body.AppendString(v)
+	}
+	return d
+}
+
+// AppendIntMap adds a map of strings to ints to the document against a given name
+func (d *DocumentBuilder) AppendIntMap(name string, value map[string]int) *DocumentBuilder {
+	d.field(name, WireMap)
+	d.schema.Bytes = appendVarintb(appendVarintb(d.schema.Bytes, uint64(WireString)), uint64(WireInt))
+
+	d.body.AppendUint(uint(len(value)))
+	for k, v := range value {
+		d.body.AppendString(k)
+		d.body.AppendInt(v)
+	}
+	return d
+}
+
+// builderMaps holds the encoder built for each type of map passed to AppendMap
+var builderMaps sync.Map // reflect.Type -> *mapEncoder
+
+// AppendMap adds a map of any type an encoder can write to the document against a given name, written as a map
+// field of that type would be, e.g. a map[string][]float64 or a map[int32]Point with Point tagged for NewEncoder.
+// The encoder for each type of map is built on its first use and kept for later calls. Anything other than a map
+// panics, as do maps an encoder can't be built for.
+func (d *DocumentBuilder) AppendMap(name string, value any) *DocumentBuilder {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Map {
+		panic(fmt.Sprintf("AppendMap needs a map, got %T", value))
+	}
+
+	enc, ok := builderMaps.Load(rv.Type())
+	if !ok {
+		enc, _ = builderMaps.LoadOrStore(rv.Type(), newMapEncoderUsingTagWithSchemaAndOpts(value, "glint", &Buffer{}, "", newEncoderConfig(nil)))
+	}
+	m := enc.(*mapEncoder)
+
+	d.field(name, WireMap)
+	d.schema.Bytes = append(d.schema.Bytes, m.schema.Bytes...)
+
+	p := reflect.New(rv.Type()) // the encoder reads the map through a pointer to it, as it would a struct field
+	p.Elem().Set(rv)
+	m.instruction(p.UnsafePointer(), &d.body)
 	return d
 }
 
 // AppendString adds a string field to the document against a given name
 func (d *DocumentBuilder) AppendString(name, value string) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireString)
+	d.field(name, WireString)
 	d.body.AppendString(value)
 	return d
 }
 
 // AppendInt adds a int field to the document against a given name
 func (d *DocumentBuilder) AppendInt(name string, value int) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireInt)
+	d.field(name, WireInt)
 	d.body.AppendInt(value)
 	return d
 }
 
 // AppendBytes adds a bytes field to the document against a given name
 func (d *DocumentBuilder) AppendBytes(name string, value []byte) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireBytes)
+	d.field(name, WireBytes)
 	d.body.AppendBytes(value)
 	return d
 }
 
 // AppendUint8 adds a uint8 field to the document against a given name
 func (d *DocumentBuilder) AppendUint8(name string, value uint8) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireUint8)
+	d.field(name, WireUint8)
 	d.body.AppendUint8(value)
 	return d
 }
 
 // AppendUint16 adds a uint16 field to the document against a given name
 func (d *DocumentBuilder) AppendUint16(name string, value uint16) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireUint16)
+	d.field(name, WireUint16)
 	d.body.AppendUint16(value)
 	return d
 }
 
 // AppendUint32 adds a uint32 field to the document against a given name
 func (d *DocumentBuilder) AppendUint32(name string, value uint32) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireUint32)
+	d.field(name, WireUint32)
 	d.body.AppendUint32(value)
 	return d
 }
 
 // AppendUint64 adds a uint64 field to the document against a given name
 func (d *DocumentBuilder) AppendUint64(name string, value uint64) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireUint64)
+	d.field(name, WireUint64)
 	d.body.AppendUint64(value)
 	return d
 }
 
 // AppendUint adds a uint field to the document against a given name
 func (d *DocumentBuilder) AppendUint(name string, value uint) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireUint)
+	d.field(name, WireUint)
 	d.body.AppendUint(value)
 	return d
 }
 
 // AppendInt8 adds a int8 field to the document against a given name
 func (d *DocumentBuilder) AppendInt8(name string, value int8) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireInt8)
+	d.field(name, WireInt8)
 	d.body.AppendInt8(value)
 	return d
 }
 
 // AppendInt16 adds a int16 field to the document against a given name
 func (d *DocumentBuilder) AppendInt16(name string, value int16) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireInt16)
+	d.field(name, WireInt16)
 	d.body.AppendInt16(value)
 	return d
 }
 
 // AppendInt32 adds a int32 field to the document against a given name
 func (d *DocumentBuilder) AppendInt32(name string, value int32) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireInt32)
+	d.field(name, WireInt32)
 	d.body.AppendInt32(value)
 	return d
 }
 
 // AppendInt64 adds a int64 field to the document against a given name
 func (d *DocumentBuilder) AppendInt64(name string, value int64) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireInt64)
+	d.field(name, WireInt64)
 	d.body.AppendInt64(value)
 	return d
 }
 
 // AppendFloat32 adds a float32 field to the document against a given name
 func (d *DocumentBuilder) AppendFloat32(name string, value float32) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireFloat32)
+	d.field(name, WireFloat32)
 	d.body.AppendFloat32(value)
 	return d
 }
 
 // AppendFloat64 adds a float64 field to the document against a given name
 func (d *DocumentBuilder) AppendFloat64(name string, value float64) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireFloat64)
+	d.field(name, WireFloat64)
 	d.body.AppendFloat64(value)
 	return d
 }
 
+// AppendDuration adds a time.Duration field to the document against a given name
+func (d *DocumentBuilder) AppendDuration(name string, value time.Duration) *DocumentBuilder {
+	d.field(name, WireDuration)
+	d.body.AppendDuration(value)
+	return d
+}
+
+// AppendAddr adds an IP address field to the document against a given name
+func (d *DocumentBuilder) AppendAddr(name string, value netip.Addr) *DocumentBuilder {
+	d.field(name, WireIP)
+	d.body.AppendAddr(value)
+	return d
+}
+
+// AppendPrefix adds an IP prefix field to the document against a given name
+func (d *DocumentBuilder) AppendPrefix(name string, value netip.Prefix) *DocumentBuilder {
+	d.field(name, WireIPPrefix)
+	d.body.AppendPrefix(value)
+	return d
+}
+
+// AppendBigInt adds an arbitrary precision integer field to the document against a given name
+func (d *DocumentBuilder) AppendBigInt(name string, value *big.Int) *DocumentBuilder {
+	d.field(name, WireBigInt)
+	d.body.AppendBigInt(value)
+	return d
+}
+
+// AppendDecimal adds a decimal field to the document against a given name
+func (d *DocumentBuilder) AppendDecimal(name string, value Decimal) *DocumentBuilder {
+	d.field(name, WireDecimal)
+	d.body.AppendDecimal(value)
+	return d
+}
+
 // AppendTime adds a time field to the document against a given name
 func (d *DocumentBuilder) AppendTime(name string, value time.Time) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireTime)
+	d.field(name, WireTime)
 	d.body.AppendTime(value)
 	return d
 }
 
 // AppendBool adds a bool field to the document against a given name
 func (d *DocumentBuilder) AppendBool(name string, value bool) *DocumentBuilder {
-	d.schema.Bytes = appendField(d.schema.Bytes, name, WireBool)
+	d.field(name, WireBool)
 	d.body.AppendBool(value)
 	return d
 }
 
-// WriteTo writes the document to a buffer
+// field adds a field to the schema, noting names too long to be written for Build to report
+func (d *DocumentBuilder) field(name string, wire WireType) {
+	if len(name) > maxFieldName {
+		d.noteErr(invalidFieldName(name))
+	}
+	d.schema.Bytes = appendField(d.schema.Bytes, name, wire)
+}
+
+// noteErr keeps err for Build to report, unless there's already an error to report
+func (d *DocumentBuilder) noteErr(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// maxFieldName is the longest field name a schema can hold, its length being written as a single byte
+const maxFieldName = 255
+
+// invalidFieldName returns the error for a field name that can't be written to a schema
+func invalidFieldName(name string) error {
+	if len(name) > maxFieldName {
+		return fmt.Errorf("glint: invalid field name %q..., names are at most %d bytes", name[:16], maxFieldName)
+	}
+	return fmt.Errorf("glint: invalid field name %q", name)
+}
+
+// Build returns the document as Bytes does, once it's checked decoders will read it as it was built. Appending
+// fields never fails, so a field without a name, with a name too long to be written or with the same name as
+// another field of its struct is left for Build to report, as the first of these it finds. Nested documents,
+// those in slices and maps, and the structs of AppendStruct are checked along with the rest, as are the elements
+// of AppendDynamicSlice, which can't be of every type. Duplicates return a *PathError wrapping ErrFieldExists, with
+// the path of the field.
+func (d *DocumentBuilder) Build() ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := checkBuiltFields("", parseSchemaNodes(NewReader(d.schema.Bytes))); err != nil {
+		return nil, err
+	}
+	return d.Bytes(), nil
+}
+
+// checkBuiltFields checks the fields of a struct at path have names, and that no two have the same one
+func checkBuiltFields(path string, fields []schemaNode) error {
+	seen := make(map[string]bool, len(fields))
+	for i := range fields {
+		name := fields[i].name
+		if name == "" {
+			if path == "" {
+				return invalidFieldName(name)
+			}
+			return &PathError{Path: path, Err: invalidFieldName(name)}
+		}
+
+		at := name
+		if path != "" {
+			at = path + "." + name
+		}
+		if seen[name] {
+			return &PathError{Path: at, Err: ErrFieldExists}
+		}
+		seen[name] = true
+
+		if err := checkBuiltNode(at, &fields[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBuiltNode checks the fields of any structs within t, which every element of a slice, and every key and
+// value of a map, share, so paths leave their brackets out
+func checkBuiltNode(path string, t *schemaNode) error {
+	switch {
+	case t.elem != nil:
+		return checkBuiltNode(path, t.elem)
+	case t.key != nil:
+		if err := checkBuiltNode(path, t.key); err != nil {
+			return err
+		}
+		return checkBuiltNode(path, t.value)
+	}
+	return checkBuiltFields(path, t.fields)
+}
+
+// SchemaHash returns the hash of the schema built so far, as written to the header of the document and used to
+// negotiate trust, see SchemaSession. The hash is kept up to date as fields are appended, so only the schema bytes
+// added since the last call are read.
+func (d *DocumentBuilder) SchemaHash() uint32 {
+	d.crc = crc32.Update(d.crc, crc32.IEEETable, d.schema.Bytes[d.hashed:])
+	d.hashed = len(d.schema.Bytes)
+
+	// the hash covers the length of the schema as well, which comes first
+	length := appendVarintb(make([]byte, 0, 10), uint64(len(d.schema.Bytes)))
+	crc := crc32Combine(crc32.ChecksumIEEE(length), d.crc, len(d.schema.Bytes))
+	if d.version >= formatVersionNameHashes {
+		crc = crc32.Update(crc, crc32.IEEETable, []byte{0}) // and the empty list of name hashes that follows it
+	}
+	return crc
+}
+
+// WriteTo writes the document to a buffer. Buffers in trusted schema mode get only the hash of the schema, as
+// encoders write them.
 func (d *DocumentBuilder) WriteTo(b *Buffer) {
+	start := len(b.Bytes)
 
 	// 8 bits reserved for flags
-	// 32 bits reserved for schema checksum (below)
-	b.Bytes = append(b.Bytes, []byte{0, 0, 0, 0, 0}...)
+	// 32 bits for the schema checksum
+	b.Bytes = binary.LittleEndian.AppendUint32(append(b.Bytes, d.version), d.SchemaHash())
 
-	b.AppendBytes(d.schema.Bytes)
-
-	// encode a hash of the schema into the schema
-	crc := crc32.ChecksumIEEE(b.Bytes[5:])
-	h := b.Bytes[1:5]
-	h[0] = byte(crc)
-	h[1] = byte(crc >> 8)
-	h[2] = byte(crc >> 16)
-	h[3] = byte(crc >> 24)
+	if b.TrustedSchema {
+		b.Bytes = append(b.Bytes, 0) // a zero length schema, the reader already has it
+	} else {
+		b.AppendBytes(d.schema.Bytes)
+		if d.version >= formatVersionNameHashes {
+			b.AppendBytes(nil) // an empty list of name hashes, which the layout of version 4 has after the schema
+		}
+	}
 
 	b.Bytes = append(b.Bytes, d.body.Bytes...)
+
+	// dictionary strings from appended structs follow the body, as they do in the documents encoders write
+	if len(d.body.strings.values) > 0 {
+		table := Buffer{Bytes: b.Bytes}
+		table.strings.values = append([]string(nil), d.body.strings.values...) // written tables are emptied
+		table.appendStringTable()
+		b.Bytes = table.Bytes
+		b.Bytes[start] |= flagStringTable
+	}
+}
+
+// WriteToWithSession writes the document to a buffer like WriteTo, leaving the schema out when the session's peer
+// has acknowledged it
+func (d *DocumentBuilder) WriteToWithSession(b *Buffer, s *SchemaSession) {
+	trusted := b.TrustedSchema
+	defer func() { b.TrustedSchema = trusted }()
+
+	b.TrustedSchema = s.Trusts(d.SchemaHash())
+	d.WriteTo(b)
 }
 
 // Bytes returns the document as a byte array
@@ -171,3 +457,51 @@ func (d *DocumentBuilder) Bytes() []byte {
 	d.WriteTo(&b)
 	return b.Bytes
 }
+
+// crc32Combine returns the IEEE checksum of two byte sequences one after the other, from the checksum of each and
+// the length of the second, without reading either again. See crc32_combine in zlib.
+func crc32Combine(crc1, crc2 uint32, len2 int) uint32 {
+	return crc32MultModP(crc32PowerOf2(len2, 3), crc1) ^ crc2
+}
+
+// crc32MultModP multiplies a and b modulo the IEEE polynomial, in its reflected bit order
+func crc32MultModP(a, b uint32) uint32 {
+	m, p := uint32(1)<<31, uint32(0)
+	for {
+		if a&m != 0 {
+			p ^= b
+			if a&(m-1) == 0 {
+				return p
+			}
+		}
+		m >>= 1
+		if b&1 != 0 {
+			b = b>>1 ^ crc32.IEEE
+		} else {
+			b >>= 1
+		}
+	}
+}
+
+// crc32Powers holds x^(2^n) modulo the IEEE polynomial for each n
+var crc32Powers = func() (t [32]uint32) {
+	p := uint32(1) << 30 // x^1
+	t[0] = p
+	for n := 1; n < len(t); n++ {
+		p = crc32MultModP(p, p)
+		t[n] = p
+	}
+	return t
+}()
+
+// crc32PowerOf2 returns x^(n * 2^k) modulo the IEEE polynomial, with k of 3 shifting a checksum past n zero bytes
+func crc32PowerOf2(n int, k uint) uint32 {
+	p := uint32(1) << 31 // x^0
+	for ; n != 0; n >>= 1 {
+		if n&1 != 0 {
+			p = crc32MultModP(crc32Powers[k&31], p)
+		}
+		k++
+	}
+	return p
+}
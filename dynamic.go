@@ -0,0 +1,55 @@
+package glint
+
+import (
+	"fmt"
+)
+
+// Slices whose elements don't share a type, such as the JSON array [1, "a", true], have WireDynamic elements.
+// Every element is written as AppendDynamicValue writes a value, its own wire type followed by the value:
+//
+//	[length (varint)]([wire type (varint)][presence (byte), for pointer types][value])*
+//
+// Elements can be any of the primitive types, pointers to them and slices of them, with nil written as a nil
+// pointer. Decoders read them into []any fields, with each element as DocumentTemplateData reads it, and skip them
+// otherwise, as they do any field they don't have. The Walker, DocumentTemplateData and the printer read them too.
+
+// readDynamic reads a value written by AppendDynamicValue, returning its wire type and the value as
+// templatePrimitive reads it, with slices as []any. Nil pointers are returned as nil. Wire types AppendDynamicValue
+// doesn't write panic, as the Reader does on malformed documents.
+func readDynamic(r *Reader) (WireType, any) {
+	wire := WireType(r.ReadVarint())
+	if wire&WirePtrFlag > 0 {
+		if r.ReadByte() == 0 {
+			return wire &^ WirePtrFlag, nil
+		}
+		wire &^= WirePtrFlag
+	}
+
+	elem := wire &^ WireSliceFlag
+	if wire&WireSliceFlag == 0 {
+		if elem < WireBool || elem > WireDecimal || elem == WireBytes || elem == WireStruct || elem == WireMap {
+			panic(fmt.Sprintf("unsupported dynamic wire type %v", wire))
+		}
+		return wire, templatePrimitive(wire, r)
+	}
+	if elem < WireBool || elem > WireDuration || elem == WireStruct || elem == WireMap {
+		panic(fmt.Sprintf("unsupported dynamic wire type %v", wire))
+	}
+
+	l := r.ReadVarint()
+	checkLimit(l, DefaultLimits.MaxSliceElements, "slice")
+	if elem == WireUint8 { // written as bytes, after the length
+		values := r.Read(r.ReadVarint())
+		s := make([]any, len(values))
+		for i, v := range values {
+			s[i] = uint(v)
+		}
+		return wire, s
+	}
+
+	s := make([]any, 0, min(l, r.BytesLeft())) // elements can take no bytes at all, so grow into longer slices
+	for i := uint(0); i < l; i++ {
+		s = append(s, templatePrimitive(elem, r))
+	}
+	return wire, s
+}
@@ -3,7 +3,11 @@ package glint
 import (
 	"fmt"
 	"hash/crc32"
+	"io"
+	"net"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -25,16 +29,221 @@ type Encoder[T any] struct {
 //		age  uint8  `glint:"age"`
 //	}
 //
-// When `Marshal` encodes data into the Buffer, these tag names are used as the schema field names
-func NewEncoder[T any]() *Encoder[T] {
+// When `Marshal` encodes data into the Buffer, these tag names are used as the schema field names.
+//
+// Options may be supplied to change how documents are written, e.g. WithDistinctNilSlices.
+func NewEncoder[T any](opts ...EncoderOption) *Encoder[T] {
 	var zero T
-	impl := newEncoder(zero)
+	impl := newRootEncoder(zero, "glint", newEncoderConfig(opts))
 	return &Encoder[T]{impl: impl}
 }
 
+// EncoderOption configures optional encoder behaviour, see NewEncoder
+type EncoderOption func(*encoderConfig)
+
+// encoderConfig holds the options an encoder was built with. It is handed down to the encoders of nested types
+// so that the whole document is written the same way.
+type encoderConfig struct {
+	distinctNilSlices bool              // write slices with a presence byte, so nil and empty slices can be told apart
+	canonical         bool              // write a single, stable byte representation for any given value
+	inlineSchemas     bool              // write every struct schema in full, even when it's repeated
+	strictUnexported  bool              // panic on unexported fields without a tag, rather than leaving them out
+	nameHashes        bool              // write a hash of each top level field name after the schema, see namehash.go
+	registry          *SchemaRegistry   // when set, documents carry the ID of their schema in this registry instead
+	compressor        Compressor        // when set, document bodies are compressed with it, see compress.go
+	fieldOptions      map[string]string // tag options added to fields by path, see WithFieldOptions
+	boolBitmaps       int               // when set, []bool fields of at least this many values are packed, see bitmap.go
+	zigzagInts        bool              // write int64 and time.Duration fields as zigzag varints, see zigzag.go
+	metrics           MetricsSink       // when set, root encoders report what they encode to it, see metrics.go
+	maxFormatVersion  byte              // the newest format version documents may be written as, see version.go
+}
+
+// newEncoderConfig applies the supplied options over the defaults
+func newEncoderConfig(opts []EncoderOption) encoderConfig {
+	c := encoderConfig{maxFormatVersion: currentFormatVersion}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.maxFormatVersion < formatVersionNilSlices { // the presence byte is newer than the documents being written
+		c.distinctNilSlices = false
+	}
+	return c
+}
+
+// WithDistinctNilSlices writes slice fields with a presence byte, in the same way as pointers, so that nil and empty
+// slices survive a roundtrip.
+//
+// By default nil slices are written exactly as empty slices, and both decode as empty. The presence byte costs a
+// byte per slice field, and keeps those fields off the decoders' fast paths. Documents written with it are format
+// version 1 or newer, as decoders predating the presence byte can't read them, so it has no effect alongside
+// WithFormatVersion(0).
+func WithDistinctNilSlices() EncoderOption {
+	return func(c *encoderConfig) {
+		c.distinctNilSlices = true
+	}
+}
+
+// WithCanonicalEncoding guarantees that equal values are always written as identical bytes, regardless of the
+// process, host or time zone doing the encoding. This makes documents suitable for signing and hashing.
+//
+// In canonical form
+//   - struct fields are written in order of their tag names rather than their declaration order
+//   - map entries are written in order of their encoded keys
+//   - times are written in UTC, at nanosecond precision
+//   - the schema is always written in full, trusted schema mode is ignored
+//
+// Canonical documents are ordinary documents and decode with any Decoder.
+func WithCanonicalEncoding() EncoderOption {
+	return func(c *encoderConfig) {
+		c.canonical = true
+	}
+}
+
+// WithInlineSchemas writes the schema of every struct in full, even when the same struct appears more than once
+// in a document.
+//
+// By default repeated struct schemas are written once, and referred back to afterwards, which keeps the headers
+// of wide documents small. Documents written that way need a decoder from a release that understands format
+// version 1, this option keeps them readable by older ones.
+func WithInlineSchemas() EncoderOption {
+	return func(c *encoderConfig) {
+		c.inlineSchemas = true
+	}
+}
+
+// WithSchemaRegistry registers the encoder's schema with the supplied registry, and writes documents that carry
+// only the schema's ID in place of the schema itself. Decoders resolve the ID through a registry of their own,
+// see NewDecoderWithRegistry.
+//
+// The schema is registered when the encoder is built, which panics if the registry's store fails to save it.
+func WithSchemaRegistry(r *SchemaRegistry) EncoderOption {
+	return func(c *encoderConfig) {
+		c.registry = r
+	}
+}
+
+// WithStrictUnexportedFields makes building the encoder panic when a struct within the encoded type has an
+// unexported field without a tag, to catch data that would otherwise be silently left out of documents.
+//
+// Fields without a tag are never encoded, exported or not, and decode as their zero value. Unexported fields are
+// easily left untagged by accident though, say a cached or mirrored copy of an exported field. Tag them with a name
+// to encode them, or with glint:"-" to skip them explicitly and satisfy this check.
+func WithStrictUnexportedFields() EncoderOption {
+	return func(c *encoderConfig) {
+		c.strictUnexported = true
+	}
+}
+
+// WithFieldOptions adds tag options to fields as though they were written in their tags, e.g. "delta" or "dict",
+// so that how fields are encoded can be decided at runtime, such as from the suggestions of FieldStats. Fields are
+// addressed by their tag name, with the fields of nested structs, and of the structs held by slices and maps,
+// following their parent's name after a dot. Options for fields the type doesn't have are ignored.
+//
+// Decoders read `sparse` and `dict` fields whatever their own tags, so documents written with those options decode
+// without any changes at the receiving end. A `delta` field needs the option in the decoder's tags as well.
+func WithFieldOptions(options map[string]string) EncoderOption {
+	return func(c *encoderConfig) {
+		c.fieldOptions = options
+	}
+}
+
+// fieldTagOptions returns the options of the field with the supplied tag name, along with any added to it by
+// WithFieldOptions
+func (c encoderConfig) fieldTagOptions(tag string, opts tagOptions) tagOptions {
+	extra, ok := c.fieldOptions[tag]
+	switch {
+	case !ok || extra == "":
+		return opts
+	case opts == "":
+		return tagOptions(extra)
+	}
+	return opts + "," + tagOptions(extra)
+}
+
+// nested returns the config for the encoders of the types held by the field with the supplied tag name, with the
+// options WithFieldOptions addresses to their fields
+func (c encoderConfig) nested(tag string) encoderConfig {
+	if len(c.fieldOptions) == 0 {
+		return c
+	}
+
+	prefix := tag + "."
+	options := map[string]string{}
+	for path, opts := range c.fieldOptions {
+		if strings.HasPrefix(path, prefix) {
+			options[path[len(prefix):]] = opts
+		}
+	}
+	c.fieldOptions = options
+	return c
+}
+
+// appendTime writes a time value, normalising it first when the encoder is canonical
+func (c encoderConfig) appendTime(b *Buffer, t time.Time) {
+	if c.canonical {
+		t = t.UTC() // the binary form carries the zone offset, which would otherwise differ between hosts
+	}
+	b.AppendTime(t)
+}
+
 // Marshal encodes a value of type T into the supplied buffer
 func (e *Encoder[T]) Marshal(v *T, buf *Buffer) {
-	e.impl.Marshal(v, buf)
+	if e.impl.config.metrics != nil {
+		defer e.impl.encoded(buf, len(buf.Bytes), time.Now())
+	}
+	e.impl.marshalDocument(unsafe.Pointer(v), buf, len(buf.Bytes))
+}
+
+// MarshalBytes encodes a value of type T into a slice of its own. The document is written through a pooled buffer
+// and copied out of it, so unlike Marshal there's no buffer to return, or to keep the document from being overwritten
+// by the next one to use it.
+func (e *Encoder[T]) MarshalBytes(v *T) []byte {
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+
+	e.Marshal(v, b)
+	return append([]byte(nil), b.Bytes...)
+}
+
+// MarshalAppend encodes a value of type T onto the end of dst, returning the extended slice as append does. It
+// allocates only when dst hasn't the room, so a slice reused from one call to the next encodes without allocating.
+func (e *Encoder[T]) MarshalAppend(dst []byte, v *T) []byte {
+	b := NewBufferFromPool() // lent dst for the call, a Buffer of its own would escape
+	own := b.Bytes
+	b.Bytes = dst
+	e.Marshal(v, b)
+
+	dst, b.Bytes = b.Bytes, own
+	b.ReturnToPool()
+	return dst
+}
+
+// MarshalTo encodes a value of type T and writes the document to w, returning the number of bytes written.
+//
+// Only the body is encoded into a buffer, the header and schema are written straight from the encoder. Writers
+// that support vectored writes, such as a net.Conn, receive both in a single call.
+func (e *Encoder[T]) MarshalTo(w io.Writer, v *T) (int64, error) {
+	head := e.impl.schema.Bytes
+	if e.impl.idOnly {
+		head = e.impl.header.Bytes
+	}
+
+	var start time.Time
+	if e.impl.config.metrics != nil {
+		start = time.Now()
+	}
+	b := e.impl.pooledBuffer()
+	defer b.ReturnToPool()
+	e.impl.marshalBody(unsafe.Pointer(v), b)
+	if flags := e.impl.finishBody(b, 0); flags != 0 {
+		head = append([]byte{head[0] | flags}, head[1:]...)
+	}
+	if e.impl.config.metrics != nil {
+		e.impl.config.metrics.Encoded(e.impl.name, len(head)+len(b.Bytes), time.Since(start))
+	}
+
+	segments := net.Buffers{head, b.Bytes}
+	return segments.WriteTo(w)
 }
 
 // Schema retrieves this encoder's schema, excluding version and hash bytes
@@ -47,11 +256,45 @@ func (e *Encoder[T]) ClearSchema() {
 	e.impl.ClearSchema()
 }
 
+// appendStruct writes the struct at v to b, without a header, returning the fields of its schema as a format
+// version 0 document would have them, and the format version documents holding them need to be marked as, see
+// DocumentBuilder.AppendStruct
+func (e *Encoder[T]) appendStruct(b *Buffer, v any) ([]byte, byte) {
+	p, ok := v.(*T)
+	if !ok || p == nil {
+		panic(fmt.Sprintf("AppendStruct needs a non-nil %T for this encoder, got %T", p, v))
+	}
+
+	r := NewReader(e.impl.Schema().Bytes)
+	fields := r.Read(r.ReadVarint())
+	if e.impl.schema.Bytes[0]&flagVersionMask != formatVersionOriginal {
+		inlined, err := inlineSchemaRefs(fields, DefaultLimits)
+		if err != nil {
+			panic(err)
+		}
+		fields = inlined
+	}
+
+	version := byte(formatVersionOriginal)
+	if e.impl.config.distinctNilSlices {
+		version = formatVersionNilSlices
+	}
+	if e.impl.schema.Bytes[0]&flagVersionMask == formatVersionPointers {
+		version = formatVersionPointers
+	}
+
+	e.impl.marshalBody(unsafe.Pointer(p), b)
+	return fields, version
+}
+
 // encoderImpl holds the internal encoding state - always construct via `newEncoder`
 type encoderImpl struct {
 	instructions []encodeInstruction // encoding operations to execute for this struct
 	header       Buffer              // header bytes (1 flag, 4 crc32, 1 zero) for trusted schema mode
 	schema       Buffer              // complete schema data with header included
+	config       encoderConfig       // options this encoder, and those of its nested types, were built with
+	idOnly       bool                // root encoders using a schema registry, only the header is written
+	name         string              // the encoded type's name, for root encoders reporting metrics
 }
 
 // encoder defines the required methods for all encoder types (Encoder, SliceEncoder, MapEncoder)
@@ -71,12 +314,14 @@ type encodeInstruction struct {
 	subenc *encoderImpl                  // encoder for nested struct types
 }
 
-// Schema extracts the raw schema data, stripping version and hash prefixes
+// Schema extracts the raw schema data, stripping version and hash prefixes, and any name hashes that follow it
 func (e *encoderImpl) Schema() *Buffer {
 	if len(e.schema.Bytes) < 5 {
 		return &Buffer{}
 	}
-	return &Buffer{Bytes: e.schema.Bytes[5:]}
+	r := NewReader(e.schema.Bytes[5:])
+	r.Skip(r.ReadVarint())
+	return &Buffer{Bytes: e.schema.Bytes[5 : len(e.schema.Bytes)-int(r.BytesLeft())]}
 }
 
 // ClearSchema resets the schema buffer, discarding all data
@@ -92,7 +337,55 @@ func newEncoder(t any) *encoderImpl {
 //
 // Like newEncoder but accepts a custom struct tag name for framework integration (e.g. "rpc").
 func newEncoderUsingTag(t any, tagName string) *encoderImpl {
-	e := &encoderImpl{}
+	return newRootEncoder(t, tagName, newEncoderConfig(nil))
+}
+
+// NewEncoderUsingTagFallback is like NewEncoder, but names fields from the first of tagNames each field has, so
+// that types tagged for another package can be encoded without tagging them again:
+//
+//	enc := glint.NewEncoderUsingTagFallback[Order]("glint", "json")
+//
+// Fields tagged with the first name are read exactly as NewEncoder reads glint tags. Only the name of a later tag is
+// used, its options being another package's, and a later tag without a name, such as `json:",omitempty"`, names the
+// field as it's declared. Fields without any of the tags are left out, as usual. Decode the documents it writes with
+// a decoder built from the same tag names, see NewDecoderUsingTagFallback.
+func NewEncoderUsingTagFallback[T any](tagNames ...string) *Encoder[T] {
+	var zero T
+	impl := newRootEncoder(zero, fallbackTags(tagNames), newEncoderConfig(nil))
+	return &Encoder[T]{impl: impl}
+}
+
+// newRootEncoder builds the encoder for a whole document, rather than for a type nested within one
+func newRootEncoder(t any, tagName string, config encoderConfig) *encoderImpl {
+	e := newEncoderUsingTagWithConfig(t, tagName, config)
+	if config.maxFormatVersion < formatVersionConstraint {
+		e.dropConstraints()
+	}
+	if !config.inlineSchemas && config.maxFormatVersion >= formatVersionSchemaRefs {
+		e.shareSchemas()
+	}
+	e.markConstraints()
+	if config.distinctNilSlices {
+		e.markNilSlices()
+	}
+	if config.metrics != nil {
+		e.name = reflect.TypeOf(t).String()
+	}
+	if config.registry != nil {
+		if _, err := config.registry.Register(e.Schema().Bytes); err != nil {
+			panic(err)
+		}
+		e.idOnly = true
+	} else if config.nameHashes && config.maxFormatVersion >= formatVersionNameHashes {
+		e.appendNameHashes()
+	}
+	e.markPointers()
+	return e
+}
+
+// newEncoderUsingTagWithConfig is like newEncoderUsingTag but builds the encoder with the supplied options
+func newEncoderUsingTagWithConfig(t any, tagName string, config encoderConfig) *encoderImpl {
+	e := &encoderImpl{config: config}
 
 	tt := reflect.TypeOf(t)
 
@@ -106,9 +399,17 @@ func newEncoderUsingTag(t any, tagName string) *encoderImpl {
 
 	case reflect.Struct:
 		e.buildStruct(tt, tagName)
+
+	case reflect.Map:
+		e.buildStruct(rootStruct(tt, tagName), tagName)
 	}
 
-	// embed the schema's checksum within itself
+	e.seal()
+	return e
+}
+
+// seal embeds the schema's checksum within itself, and copies the result into the trusted schema mode header
+func (e *encoderImpl) seal() {
 	crc := crc32.ChecksumIEEE(e.schema.Bytes[5:])
 	b := e.schema.Bytes[1:5]
 	b[0] = byte(crc)
@@ -118,7 +419,76 @@ func newEncoderUsingTag(t any, tagName string) *encoderImpl {
 
 	e.header.Bytes = make([]byte, 6) // 6 bytes: 5 for header + 1 zero-length schema marker
 	copy(e.header.Bytes, e.schema.Bytes[:5])
-	return e
+}
+
+// shareSchemas writes struct schemas that are repeated within the document once, referring back to them
+// afterwards. Documents only move to the format version that supports this when something was repeated.
+func (e *encoderImpl) shareSchemas() {
+	r := NewReader(e.schema.Bytes[5:])
+	shared, ok := shareSchemas(r.Read(r.ReadVarint()))
+	if !ok {
+		return
+	}
+
+	e.schema.Bytes = e.schema.Bytes[:5]
+	e.schema.Bytes[0] |= formatVersionSchemaRefs
+	e.schema.AppendBytes(shared)
+	e.seal()
+}
+
+// markConstraints moves documents to the format version that carries field constraints, when any field in the
+// schema has them
+func (e *encoderImpl) markConstraints() {
+	r := NewReader(e.schema.Bytes[5:])
+	s := schemaRefs{keepConstraints: true}
+	if s.fields(NewReader(r.Read(r.ReadVarint())), nil); !s.constrained {
+		return
+	}
+
+	e.schema.Bytes[0] = e.schema.Bytes[0]&^flagVersionMask | formatVersionConstraint
+	e.seal()
+}
+
+// markNilSlices moves documents to the format version whose decoders read slices written with a presence byte,
+// see WithDistinctNilSlices
+func (e *encoderImpl) markNilSlices() {
+	if e.schema.Bytes[0]&flagVersionMask >= formatVersionNilSlices {
+		return
+	}
+
+	e.schema.Bytes[0] = e.schema.Bytes[0]&^flagVersionMask | formatVersionNilSlices
+	e.seal()
+}
+
+// markPointers moves documents to the format version that marks the presence byte of each element of a slice of
+// pointers in the schema, when the schema has one. The version follows the layout of version 3, so documents
+// without name hashes carry an empty list of them.
+func (e *encoderImpl) markPointers() {
+	r := NewReader(e.schema.Bytes[5:])
+	if !markedPointers(r.Read(r.ReadVarint())) {
+		return
+	}
+
+	if e.schema.Bytes[0]&flagVersionMask != formatVersionNameHashes && !e.idOnly {
+		e.schema.AppendBytes(nil)
+	}
+	e.schema.Bytes[0] = e.schema.Bytes[0]&^flagVersionMask | formatVersionPointers
+	e.seal()
+}
+
+// dropConstraints removes any field constraints from the schema, for documents held to a format version older than
+// the one that carries them
+func (e *encoderImpl) dropConstraints() {
+	r := NewReader(e.schema.Bytes[5:])
+	var s schemaRefs
+	fields := s.fields(NewReader(r.Read(r.ReadVarint())), nil)
+	if !s.constrained {
+		return
+	}
+
+	e.schema.Bytes = e.schema.Bytes[:5]
+	e.schema.AppendBytes(fields)
+	e.seal()
 }
 
 // binaryEncoder allows types to handle their own encoding when tagged with 'encoder'.
@@ -138,13 +508,8 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 
 	bytes := []byte{}
 
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-
-		tag, opts := parseTag(f.Tag.Get(usingTagName))
-		if tag == "" {
-			continue
-		}
+	for _, f := range e.fieldOrder(t, usingTagName) {
+		tag, opts := f.name, e.config.fieldTagOptions(f.name, f.opts)
 
 		var fun func(unsafe.Pointer, *Buffer)
 		var wire WireType
@@ -158,7 +523,21 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 			k = f.Type.Elem().Kind()
 		}
 
+		base := f.Type
+		if pointerWrap {
+			base = base.Elem()
+		}
+		custom := false
+		if wire, fun, custom = marshalerInstruction(base); custom {
+			k = reflect.Invalid // written by the type itself, see Marshaler
+		} else if wire, fun, custom = netAddrEncoder(base); custom {
+			k = reflect.Invalid // see netaddr.go
+		} else if wire, fun, custom = bigNumberEncoder(base); custom {
+			k = reflect.Invalid // see bignum.go
+		}
+
 		switch k {
+		case reflect.Invalid:
 		case reflect.Uint8:
 			wire = WireUint8
 			fun = func(p unsafe.Pointer, b *Buffer) {
@@ -202,9 +581,16 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 			}
 		case reflect.Int64:
 			wire = WireInt64
+			if base == durationType {
+				wire = WireDuration
+			}
 			fun = func(p unsafe.Pointer, b *Buffer) {
 				b.AppendInt64(*(*int64)(p))
 			}
+			if e.config.zigzagField(opts) {
+				wire |= WireZigzagFlag // keeps the field off the fast paths, see zigzag.go
+				fun = zigzagInt64Append
+			}
 
 		case reflect.Float32:
 			wire = WireFloat32
@@ -235,10 +621,17 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 			fun = func(p unsafe.Pointer, b *Buffer) {
 				b.AppendString(*(*string)(p))
 			}
+			if opts.Contains("dict") {
+				if pointerWrap {
+					panic("dict option requires a string, or a slice or array of strings")
+				}
+				wire |= WireDictFlag // keeps the field off the fast paths, see dict.go
+				fun = dictStringAppend
+			}
 
 		case reflect.Map:
 
-			mpEnc := newMapEncoderUsingTagWithSchemaAndOpts(reflect.New(f.Type).Elem().Interface(), usingTagName, &Buffer{}, opts)
+			mpEnc := newMapEncoderUsingTagWithSchemaAndOpts(reflect.New(f.Type).Elem().Interface(), usingTagName, &Buffer{}, opts, e.config.nested(tag))
 			fun = func(p unsafe.Pointer, b *Buffer) {
 				var em = p
 				mpEnc.Marshal(em, b)
@@ -249,11 +642,56 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 		case reflect.Slice:
 
 			// create a slice encoder to handle the slice type then hand off to it in the fun
-			slEnc := newSliceEncoderUsingTagWithSchemaAndOpts(reflect.New(f.Type).Elem().Interface(), usingTagName, &Buffer{}, opts)
+			slEnc := newSliceEncoderUsingTagWithSchemaAndOpts(reflect.New(f.Type).Elem().Interface(), usingTagName, &Buffer{}, opts, e.config.nested(tag))
 			fun = func(p unsafe.Pointer, b *Buffer) {
 				var em = p
 				slEnc.Marshal(em, b)
 			}
+			if opts.Contains("sparse") {
+				sparseSlice(slEnc, f.Type)
+			}
+			if opts.Contains("dict") {
+				dictSlice(slEnc, f.Type)
+			}
+			if opts.Contains("bitmap") {
+				bitmapSlice(slEnc, f.Type, 0)
+			} else if e.config.boolBitmaps > 0 && !pointerWrap && f.Type.Elem().Kind() == reflect.Bool {
+				bitmapSlice(slEnc, f.Type, e.config.boolBitmaps)
+			}
+			if e.config.zigzagSliceField(opts, pointerWrap, f.Type, slEnc) {
+				zigzagSlice(slEnc, f.Type)
+			}
+
+			wire = slEnc.wire
+			enc = slEnc
+
+			if !pointerWrap && e.config.distinctNilSlices {
+				// slices carry a presence byte in the same way pointers do, so nil and empty slices can be told apart
+				wire |= WirePtrFlag
+				fun = nilSliceAppend(fun)
+			}
+
+		case reflect.Array:
+
+			// arrays are written as slices of their own length, see array.go
+			sliceType := reflect.SliceOf(base.Elem())
+			slEnc := newSliceEncoderUsingTagWithSchemaAndOpts(reflect.New(sliceType).Elem().Interface(), usingTagName, &Buffer{}, opts, e.config.nested(tag))
+			if opts.Contains("sparse") {
+				sparseSlice(slEnc, sliceType)
+			}
+			if opts.Contains("dict") {
+				dictSlice(slEnc, sliceType)
+			}
+			if opts.Contains("bitmap") {
+				bitmapSlice(slEnc, sliceType, 0)
+			} else if e.config.boolBitmaps > 0 && !pointerWrap && base.Elem().Kind() == reflect.Bool {
+				bitmapSlice(slEnc, sliceType, e.config.boolBitmaps)
+			}
+			if e.config.zigzagSliceField(opts, pointerWrap, sliceType, slEnc) {
+				zigzagSlice(slEnc, sliceType)
+			}
+			fun = arrayAppend(base.Len(), slEnc)
+
 			wire = slEnc.wire
 			enc = slEnc
 
@@ -304,8 +742,9 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 			// check first if we're a time field because we have a bespoke method for encoding time
 			if f.Type == timeType || (pointerWrap && f.Type.Elem() == timeType) {
 				wire = WireTime
+				config := e.config
 				fun = func(p unsafe.Pointer, b *Buffer) {
-					b.AppendTime(*(*time.Time)(p))
+					config.appendTime(b, *(*time.Time)(p))
 				}
 				break
 			}
@@ -321,7 +760,7 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 				inf = reflect.New(f.Type).Elem().Interface()
 			}
 
-			se := newEncoderUsingTag(inf, usingTagName)
+			se := newEncoderUsingTagWithConfig(inf, usingTagName, e.config.nested(tag))
 			enc = se
 
 			fun = func(p unsafe.Pointer, b *Buffer) {
@@ -340,7 +779,13 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 			fun = derefAppend(fun)
 		}
 
-		bytes = appendField(bytes, tag, wire)
+		if constraints := appendConstraints(nil, opts, f.Type); len(constraints) > 0 {
+			bytes = appendField(bytes, tag, wire|WireConstraintFlag)
+			bytes = appendVarintb(bytes, uint64(len(constraints)))
+			bytes = append(bytes, constraints...)
+		} else {
+			bytes = appendField(bytes, tag, wire)
+		}
 
 		// sub-encoders append their own schema data when needed.
 		// Examples: slice encoders for multi-dimensional arrays,
@@ -351,8 +796,8 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 			enc.ClearSchema()
 		}
 
-		if opts.Contains("stringer") || opts.Contains("encoder") {
-			wire = 0 // we don't want to use fast paths in marshal for stringer or encoder
+		if custom || k == reflect.Array || opts.Contains("stringer") || opts.Contains("encoder") || (wire == WireTime && e.config.canonical) {
+			wire = 0 // we don't want to use fast paths in marshal for Marshalers, arrays, stringer, encoder or canonical times
 		}
 
 		var encd *encoderImpl
@@ -374,6 +819,45 @@ func (e *encoderImpl) buildStruct(t reflect.Type, usingTagName string) {
 	e.schema.AppendBytes(bytes)
 }
 
+// fieldOrder returns a struct's tagged fields, see taggedFields, in the order they should be encoded. This is
+// declaration order, unless the encoder is canonical in which case fields are ordered by tag name so that the
+// document doesn't depend on how the struct happens to be laid out.
+func (e *encoderImpl) fieldOrder(t reflect.Type, usingTagName string) []taggedField {
+	if e.config.strictUnexported {
+		checkUnexportedFields(t, usingTagName)
+	}
+
+	order := taggedFields(t, usingTagName)
+
+	if e.config.canonical {
+		sort.SliceStable(order, func(a, b int) bool {
+			return order[a].name < order[b].name
+		})
+	}
+
+	return order
+}
+
+// checkUnexportedFields panics on the first unexported field of t without a tag, including those of untagged
+// embedded structs whose fields are promoted into t
+func checkUnexportedFields(t reflect.Type, tagName string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if _, ok, _ := lookupTag(f.Tag, tagName); ok || f.Name == "_" {
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			checkUnexportedFields(f.Type, tagName)
+			continue
+		}
+
+		if !f.IsExported() {
+			panic(fmt.Sprintf("unexported field %v.%s has no %s tag, tag it with a name to encode it or with \"-\" to skip it", t, f.Name, tagName))
+		}
+	}
+}
+
 // Marshal executes the encoding instructions built during NewEncoder to write the struct data
 // into the provided Buffer.
 // Fields tagged as `glint:"name"` map to "name" in the schema, with types inferred from
@@ -383,7 +867,28 @@ func (e *encoderImpl) Marshal(v any, b *Buffer) {
 
 	p := (*iface)(unsafe.Pointer(&v)).Data
 
-	if !b.TrustedSchema {
+	e.appendHeader(b)
+	e.marshalBody(p, b)
+}
+
+// marshalDocument writes a whole document for the struct at p, finishing the body as finishBody does. start is
+// where the document begins, which is before the end of the buffer for callers that write the header themselves.
+func (e *encoderImpl) marshalDocument(p unsafe.Pointer, b *Buffer, start int) {
+	b.resetStrings()
+	e.appendHeader(b)
+
+	body := len(b.Bytes)
+	e.marshalBody(p, b)
+	if flags := e.finishBody(b, body); flags != 0 {
+		b.Bytes[start] |= flags
+	}
+}
+
+// appendHeader writes the flags, hash and schema that come before the body, as the encoder and buffer call for
+func (e *encoderImpl) appendHeader(b *Buffer) {
+	if e.idOnly {
+		b.Bytes = append(b.Bytes, e.header.Bytes...)
+	} else if !b.TrustedSchema || e.config.canonical {
 		b.Bytes = append(b.Bytes, e.schema.Bytes...)
 	} else if len(b.Bytes) == 0 {
 		// For recursive Marshal calls (nested structs), only the top level
@@ -392,7 +897,22 @@ func (e *encoderImpl) Marshal(v any, b *Buffer) {
 		// Trusted schema mode needs only the hash for validation
 		b.Bytes = append(b.Bytes, e.header.Bytes...)
 	}
+}
 
+// finishBody follows the body written to b from body onwards with its string table, see dict.go, and compresses
+// the two when the encoder has a compressor, see compress.go. It returns the flags to set in the document's header.
+func (e *encoderImpl) finishBody(b *Buffer, body int) (flags byte) {
+	if b.appendStringTable() {
+		flags |= flagStringTable
+	}
+	if c := e.config.compressor; c != nil && compressBody(c, b, body) {
+		flags |= byte(c.Codec()) << 4
+	}
+	return flags
+}
+
+// marshalBody writes the values of the struct at p, without any schema or header
+func (e *encoderImpl) marshalBody(p unsafe.Pointer, b *Buffer) {
 	for i := 0; i < len(e.instructions); i++ {
 		switch e.instructions[i].wire {
 		// inlinable fast paths - const cases required for jump table optimization
@@ -406,7 +926,7 @@ func (e *encoderImpl) Marshal(v any, b *Buffer) {
 			b.AppendInt16(*(*int16)(unsafe.Add(p, e.instructions[i].offset)))
 		case WireInt32:
 			b.AppendInt32(*(*int32)(unsafe.Add(p, e.instructions[i].offset)))
-		case WireInt64:
+		case WireInt64, WireDuration:
 			b.AppendInt64(*(*int64)(unsafe.Add(p, e.instructions[i].offset)))
 		case WireUint:
 			b.AppendUint(*(*uint)(unsafe.Add(p, e.instructions[i].offset)))
@@ -435,4 +955,7 @@ func (e *encoderImpl) Marshal(v any, b *Buffer) {
 		}
 	}
 
+	if b.chunks != nil { // between structs nothing holds an offset into the buffer, see chunked.go
+		b.spill()
+	}
 }
@@ -0,0 +1,271 @@
+package glint
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"net/netip"
+	"time"
+	"unsafe"
+)
+
+// GenerateDocument creates a random document that conforms to the supplied schema. The schema is the output of
+// SchemaBytes, or any existing document - in which case its body is ignored and its header reused as-is.
+//
+// Generation is deterministic for a given schema and seed, and does not rely on math/rand, so the same document
+// will be produced across Go versions and platforms. This makes it useful for load testing consumers and for
+// validating third-party implementations against a schema alone, without needing the Go types it came from.
+func GenerateDocument(schema []byte, seed int64) (doc []byte, err error) {
+
+	defer recoverErr(&err)
+
+	p, err := splitDocument(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	header := schema[:len(schema)-len(p.written)]
+
+	if err := p.inlineSchema(DefaultLimits); err != nil {
+		return nil, err
+	}
+	s := p.schema
+
+	if s.BytesLeft() == 0 {
+		return nil, errors.New("glint: cannot generate a document without a schema")
+	}
+
+	fields := parseSchemaNodes(s)
+
+	g := generator{state: uint64(seed)}
+	b := Buffer{Bytes: append([]byte{}, header...)}
+	b.Bytes[0] &^= flagStringTable | flagCodecMask // generated bodies aren't compressed, and have a table of their own
+	for i := range fields {
+		g.value(&fields[i], &b, 0)
+	}
+	if b.appendStringTable() {
+		b.Bytes[0] |= flagStringTable
+	}
+
+	return b.Bytes, nil
+}
+
+// generator produces values using a splitmix64 sequence, which is small, fast, and fully specified - so
+// documents remain reproducible regardless of the standard library's rand implementation.
+type generator struct {
+	state uint64
+}
+
+// next returns the next number in the sequence
+func (g *generator) next() uint64 {
+	g.state += 0x9e3779b97f4a7c15
+	z := g.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a number in the range [0, n)
+func (g *generator) intn(n int) int {
+	return int(g.next() % uint64(n))
+}
+
+// length picks a collection length, shrinking as we nest so that deep schemas don't produce huge documents
+func (g *generator) length(depth int) int {
+	if depth > 3 {
+		return g.intn(2)
+	}
+	return g.intn(5 - depth)
+}
+
+const genAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 "
+
+// value writes a random value for t into b
+func (g *generator) value(t *schemaNode, b *Buffer, depth int) {
+
+	if t.wire&WirePtrFlag > 0 {
+		if g.intn(4) == 0 { // roughly a quarter of pointers are nil
+			b.AppendUint8(0)
+			return
+		}
+		b.AppendUint8(1)
+	}
+
+	wire := t.wire &^ WirePtrFlag
+
+	switch {
+	case wire&WireSliceFlag > 0:
+		l := g.length(depth)
+		if wire&WireBitmapFlag > 0 {
+			g.bitmap(l, b)
+			return
+		}
+		b.AppendUint(uint(l))
+
+		if wire&WireSparseFlag > 0 {
+			g.sparse(t.elem.wire, l, b)
+			return
+		}
+
+		if wire&WireDictFlag > 0 {
+			for i := 0; i < l; i++ {
+				b.appendDictString(g.dictString())
+			}
+			return
+		}
+
+		if wire&WireDeltaFlag > 0 && l > 0 {
+			g.primitive(t.elem.wire, b)
+			for i := 1; i < l; i++ {
+				if e := t.elem.wire; e == WireFloat32 || e == WireFloat64 {
+					appendFloatXor(b, uint64(g.intn(1<<20)))
+					continue
+				}
+				appendVarintZigzag(b, int64(g.intn(201)-100))
+			}
+			return
+		}
+
+		for i := 0; i < l; i++ {
+			g.value(t.elem, b, depth+1)
+		}
+
+	case wire == WireStruct:
+		for i := range t.fields {
+			g.value(&t.fields[i], b, depth+1)
+		}
+
+	case wire == WireMap:
+		// entries are written to a scratch buffer first so we can discard duplicate keys before writing the length.
+		// It shares the document's string table, which dict strings within the values are added to.
+		entries := Buffer{strings: b.strings}
+		seen := map[string]bool{}
+
+		l := g.length(depth)
+		n := 0
+		for i := 0; i < l; i++ {
+			start := len(entries.Bytes)
+			g.value(t.key, &entries, depth+1)
+
+			if k := string(entries.Bytes[start:]); !seen[k] {
+				seen[k] = true
+				g.value(t.value, &entries, depth+1)
+				n++
+				continue
+			}
+			entries.Bytes = entries.Bytes[:start]
+		}
+
+		b.strings = entries.strings
+		b.AppendUint(uint(n))
+		b.Bytes = append(b.Bytes, entries.Bytes...)
+
+	case wire == WireString|WireDictFlag:
+		b.appendDictString(g.dictString())
+
+	default:
+		g.primitive(wire, b)
+	}
+}
+
+// dictString picks one of a few short strings, so that string tables have repeats to share
+func (g *generator) dictString() string {
+	return genAlphabet[:1+g.intn(4)]
+}
+
+// bitmap writes a bitmap slice of l random bools, in either layout
+func (g *generator) bitmap(l int, b *Buffer) {
+	values := make([]bool, l)
+	for i := range values {
+		values[i] = g.intn(2) == 1
+	}
+	bitmapSliceInstruction(g.intn(2)*(l+1))(unsafe.Pointer(&values), b)
+}
+
+// sparse writes a sparse slice of l elements, roughly a quarter of which are non-zero
+func (g *generator) sparse(wire WireType, l int, b *Buffer) {
+	var values Buffer
+	count := 0
+
+	for i, next := 0, 0; i < l; i++ {
+		if g.intn(4) != 0 {
+			continue
+		}
+		values.AppendUint(uint(i - next))
+		g.primitive(wire, &values)
+		next = i + 1
+		count++
+	}
+
+	if count == l { // every element was picked, which is only allowed in the dense layout
+		count = 0
+		values.Reset()
+	}
+
+	b.AppendUint(uint(count))
+	b.Bytes = append(b.Bytes, values.Bytes...)
+}
+
+// primitive writes a random value for a single non-composite wire type
+func (g *generator) primitive(wire WireType, b *Buffer) {
+
+	switch wire & WireTypeMask {
+	case WireBool:
+		b.AppendBool(g.intn(2) == 1)
+	case WireInt:
+		b.AppendInt(int(int32(g.next())))
+	case WireInt8:
+		b.AppendInt8(int8(g.next()))
+	case WireInt16:
+		b.AppendInt16(int16(g.next()))
+	case WireInt32:
+		b.AppendInt32(int32(g.next()))
+	case WireInt64:
+		b.AppendInt64(int64(g.next()))
+	case WireUint:
+		b.AppendUint(uint(uint32(g.next())))
+	case WireUint8:
+		b.AppendUint8(uint8(g.next()))
+	case WireUint16:
+		b.AppendUint16(uint16(g.next()))
+	case WireUint32:
+		b.AppendUint32(uint32(g.next()))
+	case WireUint64:
+		b.AppendUint64(g.next())
+	case WireFloat32:
+		b.AppendFloat32(float32(int32(g.next())) / 1000)
+	case WireFloat64:
+		b.AppendFloat64(float64(int64(g.next()>>11)) / math.Pi)
+	case WireString:
+		s := make([]byte, g.intn(16))
+		for i := range s {
+			s[i] = genAlphabet[g.intn(len(genAlphabet))]
+		}
+		b.AppendString(string(s))
+	case WireBytes:
+		s := make([]byte, g.intn(16))
+		for i := range s {
+			s[i] = byte(g.next())
+		}
+		b.AppendBytes(s)
+	case WireTime:
+		b.AppendTime(time.Unix(int64(g.next()%4102444800), int64(g.intn(1e9))).UTC()) // up to 2100
+	case WireDuration:
+		b.AppendDuration(time.Duration(g.next() % uint64(24*time.Hour)))
+	case WireIP:
+		b.AppendAddr(netip.AddrFrom4([4]byte{byte(g.next()), byte(g.next()), byte(g.next()), byte(g.next())}))
+	case WireIPPrefix:
+		addr := netip.AddrFrom4([4]byte{byte(g.next()), byte(g.next()), byte(g.next()), byte(g.next())})
+		p, _ := addr.Prefix(g.intn(33))
+		b.AppendPrefix(p)
+	case WireBigInt:
+		b.AppendBigInt(new(big.Int).Lsh(big.NewInt(int64(g.next())), uint(g.intn(64))))
+	case WireDecimal:
+		b.AppendDecimal(NewDecimal(int64(g.next()>>1)-math.MaxInt64/2, -int32(g.intn(9))))
+	case WireDynamic:
+		// each element of a slice of any type is written with a type of its own, see dynamic.go
+		elem := []WireType{WireBool, WireInt, WireFloat64, WireString, WireTime}[g.intn(5)]
+		appendVarint(b, uint64(elem))
+		g.primitive(elem, b)
+	}
+}
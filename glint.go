@@ -2,7 +2,10 @@
 package glint
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"strings"
@@ -44,6 +47,13 @@ const (
 	WireStruct  WireType = 16
 	WireMap     WireType = 17
 	WireTime    WireType = 18
+
+	WireDuration WireType = 19 // time.Duration, with the same body as WireInt64
+	WireIP       WireType = 20 // net.IP and netip.Addr, see netaddr.go
+	WireIPPrefix WireType = 21 // net.IPNet and netip.Prefix, see netaddr.go
+	WireBigInt   WireType = 22 // math/big.Int, see bignum.go
+	WireDecimal  WireType = 23 // Decimal, see bignum.go
+	WireDynamic  WireType = 24 // slice elements of any type, each written with its own wire type, see dynamic.go
 	// maximum value 31 (5-bit limit)
 	WireTypeMask = 0b00011111
 
@@ -51,7 +61,15 @@ const (
 	WirePtrFlag   WireType = 1 << 6 // marks nullable fields
 	WireDeltaFlag WireType = 1 << 7 // delta encoding for numeric slices
 
-	wireSkip WireType = 1 << 8 // internal only
+	wireSkip   WireType = 1 << 8  // internal only
+	wireCustom WireType = 1 << 12 // internal only, the field's type decodes itself, see Unmarshaler
+
+	WireSparseFlag     WireType = 1 << 9  // sparse encoding for numeric slices
+	WireSchemaRefFlag  WireType = 1 << 10 // struct schema written as a reference to an earlier one, see schemaref.go
+	WireConstraintFlag WireType = 1 << 11 // field constraints follow the field name, see constraint.go
+	WireDictFlag       WireType = 1 << 13 // strings written to the document's string table, see dict.go
+	WireBitmapFlag     WireType = 1 << 14 // bools packed eight to a byte, see bitmap.go
+	WireZigzagFlag     WireType = 1 << 15 // int64s and durations written as zigzag varints, see zigzag.go
 )
 
 func (w WireType) String() string {
@@ -93,6 +111,18 @@ func (w WireType) String() string {
 		return "WireMap"
 	case WireTime:
 		return "WireTime"
+	case WireDuration:
+		return "WireDuration"
+	case WireIP:
+		return "WireIP"
+	case WireIPPrefix:
+		return "WireIPPrefix"
+	case WireBigInt:
+		return "WireBigInt"
+	case WireDecimal:
+		return "WireDecimal"
+	case WireDynamic:
+		return "WireDynamic"
 
 	default:
 
@@ -109,6 +139,18 @@ func (w WireType) String() string {
 		if w&WireDeltaFlag > 0 {
 			prefix += "(delta)"
 		}
+		if w&WireSparseFlag > 0 {
+			prefix += "(sparse)"
+		}
+		if w&WireDictFlag > 0 {
+			prefix += "(dict)"
+		}
+		if w&WireBitmapFlag > 0 {
+			prefix += "(bitmap)"
+		}
+		if w&WireZigzagFlag > 0 {
+			prefix += "(zigzag)"
+		}
 		if prefix != "" {
 			return prefix + (w & WireTypeMask).String()
 		}
@@ -130,6 +172,9 @@ func ReflectKindToWireType(k reflect.Type) WireType {
 	case reflect.Int32:
 		return WireInt32
 	case reflect.Int64:
+		if k == durationType {
+			return WireDuration
+		}
 		return WireInt64
 	case reflect.Uint:
 		return WireUint
@@ -208,8 +253,12 @@ func WireTypeToReflectType(k WireType) reflect.Type {
 	case WireStruct:
 		return reflect.StructOf([]reflect.StructField{})
 
+	case WireBytes:
+		return reflect.TypeOf([]byte(nil))
 	case WireTime:
 		return reflect.TypeOf(time.Time{})
+	case WireDuration:
+		return durationType
 
 	case WireMap:
 		panic("use mapWireTypesToReflectKind")
@@ -239,6 +288,39 @@ func derefAppend(f func(unsafe.Pointer, *Buffer)) func(unsafe.Pointer, *Buffer)
 	}
 }
 
+// nilSliceAppend wraps a slice encoder with a presence byte, mirroring derefAppend: 1 for a slice, 0 for nil.
+func nilSliceAppend(f func(unsafe.Pointer, *Buffer)) func(unsafe.Pointer, *Buffer) {
+	return func(p unsafe.Pointer, b *Buffer) {
+		if (*sliceHeader)(p).Data == unsafe.Pointer(nil) {
+			b.AppendUint8(0)
+			return
+		}
+		b.AppendUint8(1)
+		f(p, b)
+	}
+}
+
+// nilSliceDeref reads the presence byte written by nilSliceAppend, leaving the slice nil when it was nil on encode.
+func nilSliceDeref(f func(unsafe.Pointer, Reader) Reader) func(unsafe.Pointer, Reader) Reader {
+	return func(p unsafe.Pointer, r Reader) Reader {
+		if r.ReadByte() == 0 {
+			*(*sliceHeader)(p) = sliceHeader{}
+			return r
+		}
+		return f(p, r)
+	}
+}
+
+// skipAbsent wraps a skip function for a field carrying a presence byte, only skipping the value if one was written.
+func skipAbsent(f func(unsafe.Pointer, Reader) Reader) func(unsafe.Pointer, Reader) Reader {
+	return func(p unsafe.Pointer, r Reader) Reader {
+		if r.ReadByte() == 0 {
+			return r
+		}
+		return f(p, r)
+	}
+}
+
 // decodeInstruction specifies how to decode and store a field value
 type decodeInstruction struct {
 	fun         func(unsafe.Pointer, Reader) Reader // fallback decoder when fast path unavailable
@@ -249,6 +331,7 @@ type decodeInstruction struct {
 	tag         string                              // field name from struct tag
 	subinstr    []decodeInstruction                 // nested instructions for inlined decoding
 	optimizable bool                                // true if this slice-of-structs can use fast path
+	copy        bool                                // strings and byte slices within the field are copied, see copiedField
 }
 
 // TrustHeader enables HTTP-based trusted schema mode.
@@ -284,7 +367,7 @@ func deref(wrap func(unsafe.Pointer, Reader) Reader, _ WireType, t reflect.Type)
 		}
 
 		if *(*unsafe.Pointer)(p) == unsafe.Pointer(nil) {
-			*(*unsafe.Pointer)(p) = reflect.New(t.Elem()).UnsafePointer()
+			*(*unsafe.Pointer)(p) = r.newValue(t.Elem())
 		}
 
 		return wrap(*(*unsafe.Pointer)(p), r)
@@ -312,6 +395,21 @@ func reflectKindToAssigner(k reflect.Type, usingTagName string, opts tagOptions,
 		kind = k.Elem().Kind()
 	}
 
+	base := k
+	if pointerWrap {
+		base = base.Elem()
+	}
+	if w, f, ok := unmarshalerInstruction(base); ok {
+		wire, fun = w|wireCustom, f // wireCustom keeps the field off the fast paths, see parseSchema
+		kind = reflect.Invalid
+	} else if w, f, ok := netAddrDecoder(base); ok {
+		wire, fun = w, f
+		kind = reflect.Invalid
+	} else if w, f, ok := bigNumberDecoder(base); ok {
+		wire, fun = w, f
+		kind = reflect.Invalid
+	}
+
 	switch kind {
 	case reflect.Uint8:
 		wire = WireUint8
@@ -365,6 +463,9 @@ func reflectKindToAssigner(k reflect.Type, usingTagName string, opts tagOptions,
 		}
 	case reflect.Int64:
 		wire = WireInt64
+		if base == durationType {
+			wire = WireDuration
+		}
 		fun = func(p unsafe.Pointer, r Reader) Reader {
 			*(*int64)(p) = r.ReadInt64()
 			return r
@@ -431,6 +532,20 @@ func reflectKindToAssigner(k reflect.Type, usingTagName string, opts tagOptions,
 		sub = slDec
 		wire = slDec.kind
 
+	case reflect.Array:
+
+		// arrays are read as slices over the array, see array.go
+		sliceType := reflect.SliceOf(base.Elem())
+		slDec := newSliceDecoderUsingTagAndOptsWithLimits(reflect.New(sliceType).Elem().Interface(), usingTagName, opts, limits)
+		slDec.subType = sliceType
+		fun = arrayRead(base, func(p unsafe.Pointer, r Reader) Reader {
+			var em any = p
+			return slDec.unmarshal(r, nil, em)
+		})
+
+		sub = slDec
+		wire = slDec.kind
+
 	case reflect.Struct:
 
 		if k == timeType || (k.Kind() == reflect.Pointer && k.Elem() == timeType) {
@@ -476,7 +591,7 @@ func reflectKindToAssigner(k reflect.Type, usingTagName string, opts tagOptions,
 
 		// nested structs use recursive decoder
 		var inf = reflect.New(k).Elem().Interface() // create instance for schema
-		dec := newDecoderUsingTag(inf, usingTagName)
+		dec := newDecoderUsingTagWithLimits(inf, usingTagName, limits)
 		sub = dec // store for schema parsing phase
 
 		fun = func(p unsafe.Pointer, r Reader) Reader {
@@ -513,17 +628,23 @@ func reflectKindToReflectValue(k reflect.Type, usingTagName string, opts tagOpti
 	switch k.Kind() {
 	case reflect.Ptr:
 
-		reflectKindToReflectValue(k.Elem(), usingTagName, opts, limits)
 		a := reflectKindToReflectValue(k.Elem(), usingTagName, opts, limits)
 		assigner = a.assigner
 
 		fun = func(r Reader) (reflect.Value, Reader) {
 			if r.ReadByte() == 0 { // read the nil check byte
-				return reflect.ValueOf(nil), r
+				return reflect.Zero(k), r // a nil pointer, rather than no value at all, which would delete the key
 			}
 
 			vv, re := a.fun(r)
-			return vv.Addr(), re
+			if vv.CanAddr() {
+				return vv.Addr(), re
+			}
+
+			// primitives are decoded into values that can't be addressed
+			p := reflect.New(k.Elem())
+			p.Elem().Set(vv)
+			return p, re
 		}
 
 	case reflect.Uint8:
@@ -588,6 +709,13 @@ func reflectKindToReflectValue(k reflect.Type, usingTagName string, opts tagOpti
 			r = assigner.fun(unsafe.Pointer(&val), r)
 			return reflect.ValueOf(val), r
 		}
+		if k == durationType {
+			fun = func(r Reader) (reflect.Value, Reader) {
+				var val time.Duration
+				r = assigner.fun(unsafe.Pointer(&val), r)
+				return reflect.ValueOf(val), r
+			}
+		}
 
 	case reflect.Float32:
 		fun = func(r Reader) (reflect.Value, Reader) {
@@ -634,19 +762,11 @@ func reflectKindToReflectValue(k reflect.Type, usingTagName string, opts tagOpti
 
 	case reflect.Slice:
 		fun = func(r Reader) (reflect.Value, Reader) {
+			// decoded into a new slice header, some slice decoders replace the backing array rather than fill it
+			a := reflect.New(k)
+			r = assigner.fun(unsafe.Pointer(a.Pointer()), r)
 
-			b := r.position
-			sl := int(r.ReadVarint())
-			r.Unread(r.position - b)
-
-			a := reflect.MakeSlice(k, sl, sl)
-
-			p := a.Interface()
-			pp := (*(*iface)(unsafe.Pointer(&p))).Data
-			sh := *(*sliceHeader)(unsafe.Pointer(pp))
-
-			r = assigner.fun(unsafe.Pointer(&sh), r)
-			return a, r
+			return a.Elem(), r
 		}
 
 	case reflect.Struct:
@@ -670,8 +790,16 @@ func reflectKindToReflectValue(k reflect.Type, usingTagName string, opts tagOpti
 	}
 }
 
-// AppendDynamicValue encodes a value with type prefix into the buffer
+// AppendDynamicValue encodes a value with type prefix into the buffer. Types it doesn't support panic.
 func AppendDynamicValue(v any, b *Buffer) {
+	if !appendDynamicValue(v, b) {
+		panic(fmt.Sprintf("unsupported type %T", v))
+	}
+}
+
+// appendDynamicValue is AppendDynamicValue, returning false rather than panicking for types it doesn't support,
+// before anything is written
+func appendDynamicValue(v any, b *Buffer) bool {
 	switch val := v.(type) {
 	case string:
 		appendVarint(b, uint64(WireString))
@@ -718,109 +846,131 @@ func AppendDynamicValue(v any, b *Buffer) {
 	case time.Time:
 		appendVarint(b, uint64(WireTime))
 		b.AppendTime(val)
+	case time.Duration:
+		appendVarint(b, uint64(WireDuration))
+		b.AppendDuration(val)
+	case netip.Addr:
+		appendVarint(b, uint64(WireIP))
+		b.AppendAddr(val)
+	case netip.Prefix:
+		appendVarint(b, uint64(WireIPPrefix))
+		b.AppendPrefix(val)
+	case *big.Int:
+		appendVarint(b, uint64(WireBigInt))
+		b.AppendBigInt(val)
+	case Decimal:
+		appendVarint(b, uint64(WireDecimal))
+		b.AppendDecimal(val)
 
 	case *string:
 		appendVarint(b, uint64(WireString|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendString(*val)
 
 	case *int:
 		appendVarint(b, uint64(WireInt|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendInt(*val)
 
 	case *int8:
 		appendVarint(b, uint64(WireInt8|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendInt8(*val)
 
 	case *int16:
 		appendVarint(b, uint64(WireInt16|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendInt16(*val)
 
 	case *int32:
 		appendVarint(b, uint64(WireInt32|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendInt32(*val)
 
 	case *int64:
 		appendVarint(b, uint64(WireInt64|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendInt64(*val)
 
+	case *time.Duration:
+		appendVarint(b, uint64(WireDuration|WirePtrFlag))
+		if appendNil(val, b) {
+			return true
+		}
+		b.AppendDuration(*val)
+
 	case *uint:
 		appendVarint(b, uint64(WireUint|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendUint(*val)
 
 	case *uint8:
 		appendVarint(b, uint64(WireUint8|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendUint8(*val)
 
 	case *uint16:
 		appendVarint(b, uint64(WireUint16|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendUint16(*val)
 
 	case *uint32:
 		appendVarint(b, uint64(WireUint32|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendUint32(*val)
 
 	case *uint64:
 		appendVarint(b, uint64(WireUint64|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendUint64(*val)
 
 	case *float32:
 		appendVarint(b, uint64(WireFloat32|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendFloat32(*val)
 
 	case *float64:
 		appendVarint(b, uint64(WireFloat64|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendFloat64(*val)
 
 	case *bool:
 		appendVarint(b, uint64(WireBool|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendBool(*val)
 
 	case *time.Time:
 		appendVarint(b, uint64(WireTime|WirePtrFlag))
 		if appendNil(val, b) {
-			return
+			return true
 		}
 		b.AppendTime(*val)
 
@@ -866,6 +1016,13 @@ func AppendDynamicValue(v any, b *Buffer) {
 			b.AppendInt64(val[i])
 		}
 
+	case []time.Duration:
+		appendVarint(b, uint64(WireDuration|WireSliceFlag))
+		b.AppendUint(uint(len(val)))
+		for i := 0; i < len(val); i++ {
+			b.AppendDuration(val[i])
+		}
+
 	case []uint:
 		appendVarint(b, uint64(WireUint|WireSliceFlag))
 		b.AppendUint(uint(len(val)))
@@ -936,13 +1093,13 @@ func AppendDynamicValue(v any, b *Buffer) {
 		}
 
 	default:
-		panic(fmt.Sprintf("unsupported type %T", val))
-		// Handle other types as needed
+		return false
 	}
+	return true
 }
 
 // appendNil writes a nil marker (0) or presence marker (1), returning true for nil
-func appendNil(val any, b *Buffer) bool {
+func appendNil[T any](val *T, b *Buffer) bool {
 	if val == nil {
 		b.Bytes = append(b.Bytes, 0)
 		return true
@@ -1013,6 +1170,16 @@ func ReadDynamicValueFromReader(r *Reader) any {
 		return r.ReadBool()
 	case WireTime:
 		return r.ReadTime()
+	case WireDuration:
+		return r.ReadDuration()
+	case WireIP:
+		return r.ReadAddr()
+	case WireIPPrefix:
+		return r.ReadPrefix()
+	case WireBigInt:
+		return r.ReadBigInt()
+	case WireDecimal:
+		return r.ReadDecimal()
 	default:
 		return nil
 	}
@@ -1067,6 +1234,9 @@ func ReadDynamicSlice(r *Reader, wire WireType) any {
 	case WireTime | WireSliceFlag:
 		return r.ReadTimeSlice()
 
+	case WireDuration | WireSliceFlag:
+		return r.ReadDurationSlice()
+
 	case WireBytes | WireSliceFlag:
 		return r.ReadBytesSlice()
 
@@ -1438,26 +1608,169 @@ func appendField(bytes []byte, name string, wire WireType) []byte {
 
 var timeType = reflect.TypeOf(time.Time{})
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// schemaNode is a minimal description of a schema entry, used where a schema needs walking without a Go type to
+// guide it.
+type schemaNode struct {
+	name   string       // field name, empty for slice elements and map keys and values
+	wire   WireType     // wire type including pointer and delta flags
+	fields []schemaNode // struct fields
+	elem   *schemaNode  // slice elements
+	key    *schemaNode  // map keys
+	value  *schemaNode  // map values
+}
+
+// parseSchemaNodes reads every named entry from a schema
+func parseSchemaNodes(r Reader) []schemaNode {
+	var fields []schemaNode
+
+	for r.BytesLeft() > 0 {
+		wire := WireType(r.ReadVarint())
+		name := string(r.Read(uint(r.ReadByte())))
+		node := parseSchemaNode(wire, &r)
+		node.name = name
+		fields = append(fields, node)
+	}
+
+	return fields
+}
+
+// parseSchemaNode reads any sub-schema belonging to wire, following the same layout the encoders use when writing it
+func parseSchemaNode(wire WireType, r *Reader) schemaNode {
+	t := schemaNode{wire: wire}
+
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case base == WireSliceFlag: // slice of slices or of pointers, the element type follows
+		elem := parseSchemaNode(WireType(r.ReadVarint()), r)
+		t.elem = &elem
+
+	case base&WireSliceFlag > 0:
+		elem := parseSchemaNode(wire&(WireTypeMask|WireZigzagFlag), r) // zigzag describes the elements themselves
+		t.elem = &elem
+
+	case base == WireStruct:
+		t.fields = parseSchemaNodes(NewReader(r.Read(r.ReadVarint())))
+
+	case base == WireMap:
+		keyWire, valueWire := WireType(r.ReadVarint()), WireType(r.ReadVarint())
+		key := parseSchemaNode(keyWire, r)
+		value := parseSchemaNode(valueWire, r)
+		t.key, t.value = &key, &value
+
+	case base < WireBool || base > WireDynamic:
+		panic(fmt.Sprintf("unknown wire type %v", wire))
+	}
+
+	return t
+}
+
+// appendSchemaNodes writes fields in the layout parseSchemaNodes reads
+func appendSchemaNodes(b *Buffer, fields []schemaNode) {
+	for i := range fields {
+		b.AppendUint(uint(fields[i].wire))
+		b.AppendUint8(uint8(len(fields[i].name)))
+		b.Bytes = append(b.Bytes, fields[i].name...)
+		appendSchemaNode(b, &fields[i])
+	}
+}
+
+// appendSchemaNode writes any sub-schema belonging to t, in the layout parseSchemaNode reads
+func appendSchemaNode(b *Buffer, t *schemaNode) {
+	base := t.wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case base == WireSliceFlag:
+		b.AppendUint(uint(t.elem.wire))
+		appendSchemaNode(b, t.elem)
+
+	case base&WireSliceFlag > 0:
+		appendSchemaNode(b, t.elem)
+
+	case base == WireStruct:
+		var fields Buffer
+		appendSchemaNodes(&fields, t.fields)
+		b.AppendUint(uint(len(fields.Bytes)))
+		b.Bytes = append(b.Bytes, fields.Bytes...)
+
+	case base == WireMap:
+		b.AppendUint(uint(t.key.wire))
+		b.AppendUint(uint(t.value.wire))
+		appendSchemaNode(b, t.key)
+		appendSchemaNode(b, t.value)
+	}
+}
+
 // DecodeLimits configures bounds checking during decoding to prevent memory exhaustion attacks
 type DecodeLimits struct {
-	MaxByteSliceLen uint // Maximum byte slice length (0 = unlimited)
-	MaxSliceInitCap uint // Cap initial slice allocations to prevent huge upfront allocations
-	MaxSchemaSize   uint // Maximum schema size in bytes
-	MaxStringLen    uint // Maximum string length
-}
+	MaxByteSliceLen    uint // Maximum byte slice length (0 = unlimited)
+	MaxSliceInitCap    uint // Cap initial slice allocations to prevent huge upfront allocations
+	MaxSliceElements   uint // Maximum elements in any one slice, however deeply nested (0 = unlimited)
+	MaxSchemaSize      uint // Maximum schema size in bytes, once any shared struct schemas are expanded
+	MaxStringLen       uint // Maximum string length
+	MaxSchemaDepth     uint // Maximum nesting of structs, slices and maps within a schema
+	MaxNestingDepth    uint // Maximum nesting of structs, slices and maps within a value, bounding how deep decoding, walking and printing recurse
+	MaxFieldsPerSchema uint // Maximum fields in any one struct schema
+	MaxDocumentSize    uint // Maximum size of a document read from an io.Reader, see Decoder.UnmarshalFrom
+	MaxMapEntries      uint // Maximum entries in any one map, however deeply nested (0 = unlimited)
+
+	// The totals below count a whole document before any of it is decoded, which costs a pass over the body, so
+	// they're off by default. A DecoderContext carrying a Context is counted the same way, so that it's checked
+	// for cancellation as the document is read.
+	MaxTotalFields uint // Maximum struct fields in a document, counting those of every nested struct, slice element and map value (0 = unlimited)
+	MaxDecodedSize uint // Maximum bytes the slices, maps, strings and byte slices of a document decode to, estimated from their lengths (0 = unlimited)
+
+	InternMapKeys *StringInterner // Interns the string keys of decoded maps, when set
+}
+
+// DuplicateKeyPolicy selects how a map that holds the same key more than once is decoded, see
+// WithDuplicateMapKeys. Glint encoders never write duplicate keys, so they only come from buggy or hostile producers.
+type DuplicateKeyPolicy uint8
+
+const (
+	DuplicateKeysLastWins  DuplicateKeyPolicy = iota // later entries replace earlier ones, the default
+	DuplicateKeysFirstWins                           // later entries are discarded
+	DuplicateKeysError                               // decoding fails with ErrDuplicateMapKey
+)
+
+// ErrDuplicateMapKey is raised when a map holds the same key more than once under DuplicateKeysError
+var ErrDuplicateMapKey = errors.New("duplicate map key")
 
 // DefaultLimits provides sensible defaults for most use cases
 var DefaultLimits = DecodeLimits{
-	MaxByteSliceLen: 100 * 1024 * 1024, // 100MB
-	MaxSliceInitCap: 10000,             // 10K elements initial cap
-	MaxSchemaSize:   1024 * 1024,       // 1MB schema max
-	MaxStringLen:    50 * 1024 * 1024,  // 50MB string max
+	MaxByteSliceLen:    100 * 1024 * 1024, // 100MB
+	MaxSliceInitCap:    10000,             // 10K elements initial cap
+	MaxSliceElements:   10000000,          // 10M elements per slice
+	MaxSchemaSize:      1024 * 1024,       // 1MB schema max
+	MaxStringLen:       50 * 1024 * 1024,  // 50MB string max
+	MaxSchemaDepth:     64,                // deeper than any reasonable Go type
+	MaxNestingDepth:    64,                // well within the Go stack, however each level is read
+	MaxFieldsPerSchema: 4096,              // fields per struct
+	MaxDocumentSize:    256 * 1024 * 1024, // 256MB document max
+	MaxMapEntries:      10000000,          // 10M entries per map
 }
 
 // checkLimit validates a length against a limit, with 0 meaning unlimited
 func checkLimit(length, limit uint, name string) {
 	if limit > 0 && length > limit {
-		panic(fmt.Sprintf("%s length %d exceeds limit %d", name, length, limit))
+		panic(fmt.Errorf("%w: %s length %d exceeds limit %d", ErrLimitExceeded, name, length, limit))
+	}
+}
+
+// checkSchemaDepth validates the nesting of a schema node against the MaxSchemaDepth limit, with 0 meaning unlimited
+func checkSchemaDepth(depth uint, limits DecodeLimits) {
+	if limits.MaxSchemaDepth > 0 && depth > limits.MaxSchemaDepth {
+		panic(fmt.Errorf("%w: schema depth exceeds limit %d", ErrLimitExceeded, limits.MaxSchemaDepth))
+	}
+	checkNestingDepth(depth, limits)
+}
+
+// checkNestingDepth validates the nesting of a value against the MaxNestingDepth limit, with 0 meaning unlimited
+func checkNestingDepth(depth uint, limits DecodeLimits) {
+	if limits.MaxNestingDepth > 0 && depth > limits.MaxNestingDepth {
+		panic(fmt.Errorf("%w: nesting depth exceeds limit %d", ErrLimitExceeded, limits.MaxNestingDepth))
 	}
 }
 
@@ -1484,6 +1797,115 @@ func parseTag(tag string) (string, tagOptions) {
 	return tag, tagOptions("")
 }
 
+// fallbackTags joins the tag names fields are read from in order of preference, see NewEncoderUsingTagFallback.
+// Spaces can't appear within a tag name, so a single tag name passes through as itself.
+func fallbackTags(tagNames []string) string {
+	if len(tagNames) == 0 {
+		return "glint"
+	}
+	return strings.Join(tagNames, " ")
+}
+
+// lookupTag returns the value of the first of the tags in tagName, see fallbackTags, that tag has, and whether it
+// came from one of the tags after the first
+func lookupTag(tag reflect.StructTag, tagName string) (value string, found, fallback bool) {
+	for i := 0; ; i++ {
+		name, rest, more := strings.Cut(tagName, " ")
+		if value, ok := tag.Lookup(name); ok {
+			return value, true, i > 0
+		}
+		if !more {
+			return "", false, false
+		}
+		tagName = rest
+	}
+}
+
+// rootMapField is the name of the single field a map root is written as, see rootStruct
+const rootMapField = "value"
+
+// rootStruct returns the struct a map of type t is encoded and decoded as when it's the root of a document, which
+// has the map as its only field. The field sits at offset zero, so a pointer to the map is also a pointer to the
+// struct, and grouped batches such as map[string][]Event need no wrapper type of their own.
+func rootStruct(t reflect.Type, tagName string) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{{
+		Name: "Value",
+		Type: t,
+		Tag:  reflect.StructTag(fmt.Sprintf(`%s:"%s"`, strings.Fields(tagName)[0], rootMapField)),
+	}})
+}
+
+// taggedField is a struct field that's encoded and decoded, along with its tag
+type taggedField struct {
+	reflect.StructField // Offset and Index lead from the outermost struct, through any embedded structs
+
+	name  string
+	opts  tagOptions
+	depth int // embedded structs the field was promoted through
+}
+
+// taggedFields returns the fields of t with a tag name, in declaration order. As with encoding/json, the fields of
+// embedded structs without a tag name are promoted into t in place of the embedded struct, while embedded structs
+// with a tag name are fields like any other. A promoted field is hidden by a field of the same name nearer to t,
+// and names repeated at the same depth are left out altogether. Embedded pointers are only encoded when tagged, and
+// fields tagged "-" are never encoded.
+func taggedFields(t reflect.Type, tagName string) []taggedField {
+	var fields []taggedField
+	collectTaggedFields(t, tagName, nil, 0, 0, &fields)
+
+	// the shallowest field of each name wins, as long as it's the only one at that depth
+	shallowest := map[string]int{}
+	count := map[string]int{}
+	for _, f := range fields {
+		if d, ok := shallowest[f.name]; !ok || f.depth < d {
+			shallowest[f.name], count[f.name] = f.depth, 0
+		}
+		if f.depth == shallowest[f.name] {
+			count[f.name]++
+		}
+	}
+
+	out := fields[:0]
+	for _, f := range fields {
+		if f.depth == shallowest[f.name] && count[f.name] == 1 {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// collectTaggedFields appends the tagged fields of t to fields, promoting those of untagged embedded structs
+func collectTaggedFields(t reflect.Type, tagName string, index []int, offset uintptr, depth int, fields *[]taggedField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, fallback := lookupTag(f.Tag, tagName)
+		name, opts := parseTag(tag)
+		if fallback {
+			// the options of other packages' tags mean nothing here, and as with encoding/json, a tag without a name
+			// leaves the field named as it's declared
+			opts = ""
+			if name == "" && !f.Anonymous {
+				name = f.Name
+			}
+		}
+
+		f.Index = append(append([]int{}, index...), i)
+		f.Offset += offset
+
+		if name == "-" {
+			continue // skipped explicitly
+		}
+		if name == "" {
+			if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+				collectTaggedFields(f.Type, tagName, f.Index, f.Offset, depth+1, fields)
+			}
+			continue
+		}
+
+		*fields = append(*fields, taggedField{StructField: f, name: name, opts: opts, depth: depth})
+	}
+}
+
 // Contains reports whether a comma-separated list of options
 // contains a particular substr flag. substr must be surrounded by a
 // string boundary or commas.
@@ -204,6 +204,13 @@ func (v *JSONTranscodeVisitor) VisitStructEnd(name string) error {
 	return nil
 }
 
+// maps aren't transcoded, the benchmarked documents don't have any
+func (v *JSONTranscodeVisitor) VisitMapStart(name string, key, value WireType, length int) error { return nil }
+func (v *JSONTranscodeVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	return body, ErrSkipVisit
+}
+func (v *JSONTranscodeVisitor) VisitMapEnd(name string) error { return nil }
+
 func (v *JSONTranscodeVisitor) Bytes() []byte {
 	return v.b.Bytes
 }
@@ -907,4 +914,54 @@ func BenchmarkDeltaDecodingPerformance(b *testing.B) {
 			decoder.Unmarshal(buf.Bytes, &decoded)
 		}
 	})
-}
\ No newline at end of file
+}
+// BenchmarkSchemaNameHashes measures matching a schema with long field names to a decoder's fields, as happens on
+// an instruction cache miss, with and without name hashes
+func BenchmarkSchemaNameHashes(b *testing.B) {
+	in := wideRecord{CustomerIdentifier: "c-1", Region: "north"}
+	dec := newDecoder(wideRecord{})
+
+	for name, opts := range map[string][]EncoderOption{"plain": nil, "hashed": {WithNameHashes()}} {
+		buf := &Buffer{}
+		NewEncoder[wideRecord](opts...).Marshal(&in, buf)
+		parts, _ := splitDocument(buf.Bytes)
+
+		b.Run(name, func(b *testing.B) {
+			var ins [10]decodeInstruction
+			for i := 0; i < b.N; i++ {
+				dec.parseNamedSchema(parts.schema, parts.names, ins[:0])
+			}
+		})
+	}
+}
+
+func BenchmarkChunkedBuffer(b *testing.B) {
+	type Row struct {
+		ID     int       `glint:"id"`
+		Name   string    `glint:"name"`
+		Values []float64 `glint:"values"`
+	}
+	type Dataset struct {
+		Rows []Row `glint:"rows"`
+	}
+
+	var v Dataset
+	for i := 0; i < 100000; i++ {
+		v.Rows = append(v.Rows, Row{ID: i, Name: "a row of the dataset", Values: []float64{float64(i), 1.5, 2.5}})
+	}
+	enc := NewEncoder[Dataset]()
+
+	b.Run("buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc.Marshal(&v, &Buffer{}) // a fresh buffer grows by copying as the document does
+		}
+	})
+
+	b.Run("chunked", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			enc.MarshalChunked(&v, NewChunkedBuffer(0))
+		}
+	})
+}
@@ -3,6 +3,8 @@ package glint
 import (
 	"bytes"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -469,37 +471,24 @@ func FuzzDocumentBuilderAPI(f *testing.F) {
 	f.Add("data\x00", "data\xFF", int32(-1), true)
 
 	f.Fuzz(func(t *testing.T, name1, val1 string, intVal int32, boolVal bool) {
-		// Sanitize inputs to prevent triggering known bugs
-		// There's a bug in glint where very long field names can cause Reader panics
-		// Let's limit to reasonable sizes while still testing edge cases
-		if len(name1) > 50 {
-			name1 = name1[:50]
-		}
 		if len(val1) > 500 {
 			val1 = val1[:500]
 		}
 		
-		// Also avoid empty field names which might cause issues
-		if name1 == "" {
-			name1 = "property"
-		}
-		
-		// Skip if field name would create invalid field names with suffixes
-		if len(name1) > 45 {
-			// This would make name1_int and name1_bool too long
-			name1 = name1[:45]
-		}
-		
 		// Build document
 		doc := &DocumentBuilder{}
-		
-		// Add fields (name1 is guaranteed to not be empty now)
 		doc.AppendString(name1, val1)
 		doc.AppendInt32(name1+"_int", intVal)
 		doc.AppendBool(name1+"_bool", boolVal)
 		
-		// Build and ensure we get bytes back
-		data := doc.Bytes()
+		// Build checks the names, which are only invalid when empty or too long to be written
+		data, err := doc.Build()
+		if invalid := name1 == "" || len(name1+"_bool") > 255; invalid != (err != nil) {
+			t.Fatalf("Build of name=%q returned %v", name1, err)
+		}
+		if err != nil {
+			return
+		}
 		if len(data) == 0 {
 			t.Error("Built document should not be empty")
 		}
@@ -509,8 +498,7 @@ func FuzzDocumentBuilderAPI(f *testing.F) {
 			fields: make(map[string]any),
 		}
 		
-		err := Walk(data, visitor)
-		if err != nil {
+		if err := Walk(data, DecodingVisitor(visitor)); err != nil {
 			// This is a bug in glint if we can build a document we can't walk
 			t.Fatalf("Failed to walk document built with name=%q, val=%q: %v", name1, val1, err)
 		}
@@ -524,29 +512,15 @@ func FuzzDocumentBuilderAPI(f *testing.F) {
 	})
 }
 
-// testVisitor implements Visitor for testing
+// testVisitor implements TypedVisitor for testing, walk with DecodingVisitor
 type testVisitor struct {
+	NopTypedVisitor
 	fields map[string]any
 }
 
-func (v *testVisitor) VisitFlags(flags byte) error { return nil }
-func (v *testVisitor) VisitSchemaHash(hash []byte) error { return nil }
-func (v *testVisitor) VisitArrayStart(name string, wire WireType, length int) error { return nil }
-func (v *testVisitor) VisitArrayEnd(name string) error { return nil }
-func (v *testVisitor) VisitStructStart(name string) error { return nil }
-func (v *testVisitor) VisitStructEnd(name string) error { return nil }
-
-func (v *testVisitor) VisitField(name string, wire WireType, body Reader) (Reader, error) {
-	switch wire {
-	case WireString:
-		v.fields[name] = body.ReadString()
-	case WireInt32:
-		v.fields[name] = body.ReadInt32()
-	case WireBool:
-		v.fields[name] = body.ReadBool()
-	}
-	return body, nil
-}
+func (v *testVisitor) VisitString(name, value string) error { v.fields[name] = value; return nil }
+func (v *testVisitor) VisitInt(name string, value int64) error { v.fields[name] = value; return nil }
+func (v *testVisitor) VisitBool(name string, value bool) error { v.fields[name] = value; return nil }
 
 // FuzzDeltaEncoding tests delta encoding with various input patterns
 func FuzzDeltaEncodingGeneric(f *testing.F) {
@@ -1520,3 +1494,41 @@ func FuzzDeltaEncodingCompressionBenefits(f *testing.F) {
 		}
 	})
 }
+
+// FuzzUnmarshalRawBytes feeds arbitrary bytes to everything that reads documents from untrusted sources, none of
+// which may panic whatever they're given. The seeds are the version fixtures in testdata/versions, written in every
+// format version, along with truncated and corrupted copies of them.
+func FuzzUnmarshalRawBytes(f *testing.F) {
+	fixtures, _ := filepath.Glob("testdata/versions/*/*.glint")
+	for _, path := range fixtures {
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(doc)
+		f.Add(doc[:len(doc)/2])
+		corrupted := append([]byte(nil), doc...)
+		corrupted[len(corrupted)/3] ^= 0xFF
+		f.Add(corrupted)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01})
+
+	dec := NewDecoder[versionFixture]()
+	constrained := NewValidatingDecoder[constrainedVersionFixture]()
+
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		var v versionFixture
+		_ = dec.Unmarshal(doc, &v)
+
+		var c constrainedVersionFixture
+		_ = constrained.Unmarshal(doc, &c)
+
+		_ = Walk(doc, DecodingVisitor(&testVisitor{fields: make(map[string]any)}))
+		_, _ = SPrint(doc)
+
+		r := NewReader(doc)
+		_, _ = NewPrinterDocument(&r)
+	})
+}
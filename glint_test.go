@@ -2,17 +2,36 @@ package glint
 
 import (
 	"bytes"
+	"compress/flate"
+	"context"
+	"encoding"
 	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"math"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"text/template"
 	"time"
+	"unsafe"
 )
 
 // Child is used as a nested struct.
@@ -298,7 +317,8 @@ func TestBasicTypesEncodeDecodeRoundtrip(t *testing.T) {
 					AtimeTime []time.Time `glint:"[]timeTime"`
 				}
 
-				encoder := newEncoder(All{})
+				// inline schemas pin the layout written before repeated struct schemas were shared
+				encoder := newRootEncoder(All{}, "glint", newEncoderConfig([]EncoderOption{WithInlineSchemas()}))
 				buf := NewBufferFromPool()
 
 				value := All{
@@ -422,11 +442,17 @@ func TestBasicTypesEncodeDecodeRoundtrip(t *testing.T) {
 
 				encoder.Marshal(&value, buf)
 
-				// Original test only checks encoding, not decoding
-				// since slice of pointers isn't fully supported for decoding
 				if len(buf.Bytes) == 0 {
 					t.Error("Expected non-empty encoding")
 				}
+
+				var decoded Points
+				if err := newDecoder(Points{}).Unmarshal(buf.Bytes, &decoded); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(decoded, value) {
+					t.Errorf("Expected %+v, got %+v", value, decoded)
+				}
 			},
 		},
 		{
@@ -1603,7 +1629,8 @@ func TestDocumentLengthAndEqualityComparison(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			enc := newEncoder(tt.input)
+			// sizes are pinned against the layout written before repeated struct schemas were shared
+			enc := newRootEncoder(tt.input, "glint", newEncoderConfig([]EncoderOption{WithInlineSchemas()}))
 
 			buf := Buffer{}
 			enc.Marshal(tt.input, &buf)
@@ -1792,6 +1819,20 @@ func (v *TestVisitor) VisitStructEnd(name string) error {
 	return nil
 }
 
+func (v *TestVisitor) VisitMapStart(name string, key, value WireType, length int) error {
+	fmt.Println("VisitMapStart", name, key, value, length)
+	return nil
+}
+
+func (v *TestVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	return body, ErrSkipVisit
+}
+
+func (v *TestVisitor) VisitMapEnd(name string) error {
+	fmt.Println("VisitMapEnd", name)
+	return nil
+}
+
 type SilentTestVisitor struct {
 	actionCount int
 }
@@ -1806,10 +1847,16 @@ func (v *SilentTestVisitor) VisitField(_ string, w WireType, body Reader) (Reade
 	fieldBytes(&body, w)
 	return body, v.inc()
 }
-func (v *SilentTestVisitor) VisitArrayStart(_ string, _ WireType, _ int) error { return v.inc() }
-func (v *SilentTestVisitor) VisitArrayEnd(name string) error                   { return v.inc() }
-func (v *SilentTestVisitor) VisitStructStart(name string) error                { return v.inc() }
-func (v *SilentTestVisitor) VisitStructEnd(name string) error                  { return v.inc() }
+func (v *SilentTestVisitor) VisitArrayStart(_ string, _ WireType, _ int) error  { return v.inc() }
+func (v *SilentTestVisitor) VisitArrayEnd(name string) error                    { return v.inc() }
+func (v *SilentTestVisitor) VisitStructStart(name string) error                 { return v.inc() }
+func (v *SilentTestVisitor) VisitStructEnd(name string) error                   { return v.inc() }
+func (v *SilentTestVisitor) VisitMapStart(_ string, _, _ WireType, _ int) error { return v.inc() }
+func (v *SilentTestVisitor) VisitMapKey(w WireType, body Reader) (Reader, error) {
+	fieldBytes(&body, w)
+	return body, v.inc()
+}
+func (v *SilentTestVisitor) VisitMapEnd(name string) error { return v.inc() }
 
 // Benchmark for AppendDynamicValue
 
@@ -2682,7 +2729,7 @@ func testDocumentBuilderDelta[T comparable](t *testing.T, values []T, appendFunc
 	bytes := doc.Bytes()
 
 	// Print the document to verify delta encoding shows up
-	output := SPrint(bytes)
+	output := mustSPrint(t, bytes)
 	if !strings.Contains(output, "(delta)") {
 		t.Error("Expected delta encoding indicator in printed output")
 	}
@@ -2799,18 +2846,90 @@ func TestStringerAndCustomEncodingTags(t *testing.T) {
 			{"uint64", func(t *testing.T) {
 				testDeltaEncodingType(t, []uint64{1000000, 1000005, 1000010, 1000003, 1000008}, "uint64")
 			}},
+			{"float32", func(t *testing.T) {
+				testDeltaEncodingType(t, []float32{21.5, 21.5, 21.75, -0.125, float32(math.Inf(1)), 0, 3.4e38}, "float32")
+			}},
+			{"float64", func(t *testing.T) {
+				testDeltaEncodingType(t, []float64{21.5, 21.5, 21.75, -0.125, math.Inf(-1), 0, math.MaxFloat64, math.SmallestNonzeroFloat64}, "float64")
+			}},
+			{"float32 builder", func(t *testing.T) {
+				testDocumentBuilderDelta(t, []float32{1.5, 1.25, -7, 1e-30}, (*SliceBuilder).AppendFloat32SliceDelta, "float32")
+			}},
+			{"float64 builder", func(t *testing.T) {
+				testDocumentBuilderDelta(t, []float64{1.5, 1.25, -7, 1e-300}, (*SliceBuilder).AppendFloat64SliceDelta, "float64")
+			}},
 		}
 
 		for _, tc := range testCases {
 			t.Run(tc.name, tc.test)
 		}
+	})
+
+	t.Run("DeltaFloats", func(t *testing.T) {
+		type readings struct {
+			Values []float64 `glint:"values"`
+		}
+		type deltaReadings struct {
+			Values []float64 `glint:"values,delta"`
+		}
 
-		// Test unsupported types
-		unsupportedTypes := []string{"float32", "float64"}
-		for _, typ := range unsupportedTypes {
-			t.Run(typ, func(t *testing.T) {
-				t.Skipf("Delta encoding for %s not yet implemented", typ)
-			})
+		// a slowly drifting sensor, sampled at a fixed resolution, with runs of repeated readings
+		r := rand.New(rand.NewSource(42))
+		values := make([]float64, 500)
+		for i, v := 1, 20.0; i < len(values); i++ {
+			if r.Intn(3) == 0 {
+				v += float64(r.Intn(5)-2) / 4
+			}
+			values[i] = v
+		}
+
+		standard, delta := &Buffer{}, &Buffer{}
+		NewEncoder[readings]().Marshal(&readings{Values: values}, standard)
+		NewEncoder[deltaReadings]().Marshal(&deltaReadings{Values: values}, delta)
+		if len(delta.Bytes) >= len(standard.Bytes)/2 {
+			t.Errorf("expected delta encoding to at least halve the size, got %d bytes from %d", len(delta.Bytes), len(standard.Bytes))
+		}
+
+		var decoded deltaReadings
+		if err := NewDecoder[deltaReadings]().Unmarshal(delta.Bytes, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Values, values) {
+			t.Error("delta encoded floats don't match")
+		}
+
+		// every bit pattern survives, including NaNs and signed zeros
+		for i := range values {
+			values[i] = math.Float64frombits(r.Uint64())
+		}
+		values[1], values[2] = math.Copysign(0, -1), math.NaN()
+		delta.Reset()
+		NewEncoder[deltaReadings]().Marshal(&deltaReadings{Values: values}, delta)
+		if err := NewDecoder[deltaReadings]().Unmarshal(delta.Bytes, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		for i := range values {
+			if math.Float64bits(decoded.Values[i]) != math.Float64bits(values[i]) {
+				t.Fatalf("value %d: expected bits %x, got %x", i, math.Float64bits(values[i]), math.Float64bits(decoded.Values[i]))
+			}
+		}
+
+		// readers without the field skip past it
+		type other struct {
+			Name string `glint:"name"`
+		}
+		type withName struct {
+			Values []float64 `glint:"values,delta"`
+			Name   string    `glint:"name"`
+		}
+		delta.Reset()
+		NewEncoder[withName]().Marshal(&withName{Values: values, Name: "kept"}, delta)
+		var o other
+		if err := NewDecoder[other]().Unmarshal(delta.Bytes, &o); err != nil || o.Name != "kept" {
+			t.Errorf("expected the delta field to be skipped, got %+v, %v", o, err)
+		}
+		if !strings.Contains(mustSPrint(t, delta.Bytes), "(delta)") {
+			t.Error("expected the printed document to show the delta encoding")
 		}
 	})
 
@@ -2899,7 +3018,7 @@ func TestStringerAndCustomEncodingTags(t *testing.T) {
 		encoder.Marshal(&data, buf)
 
 		// Print the document
-		output := SPrint(buf.Bytes)
+		output := mustSPrint(t, buf.Bytes)
 
 		// Verify output contains delta indicator
 		if !strings.Contains(output, "[](delta)Int64") {
@@ -2955,7 +3074,7 @@ func TestStringerAndCustomEncodingTags(t *testing.T) {
 		}
 
 		// Test printing
-		output := SPrint(buf.Bytes)
+		output := mustSPrint(t, buf.Bytes)
 		if !strings.Contains(output, "[](delta)Int32") {
 			t.Errorf("Expected [](delta)Int32 in schema, got:\n%s", output)
 		}
@@ -3182,7 +3301,7 @@ func TestStringerAndCustomEncodingTags(t *testing.T) {
 				}
 
 				// Test that SPrint works on the document (tests printer functions)
-				output := SPrint(data)
+				output := mustSPrint(t, data)
 				if !strings.Contains(output, "values") {
 					t.Errorf("SPrint output should contain 'values' field")
 				}
@@ -3246,7 +3365,7 @@ func TestStringerAndCustomEncodingTags(t *testing.T) {
 			}
 
 			// Verify document can be printed (validates structure)
-			output := SPrint(data)
+			output := mustSPrint(t, data)
 			if !strings.Contains(output, "values") {
 				t.Error("Document should contain 'values' field")
 			}
@@ -4251,7 +4370,7 @@ func TestDocumentBuilderAndWalkerFunctionality(t *testing.T) {
 			}
 
 			// Verify SPrint can handle it (tests printer functions)
-			output := SPrint(data)
+			output := mustSPrint(t, data)
 			expectedFields := []string{"uint8_field", "uint16_field", "uint32_field", "uint64_field", "uint_field",
 				"int8_field", "int16_field", "int32_field", "int64_field", "float32_field", "float64_field",
 				"bool_field", "bytes_field", "time_field"}
@@ -4428,7 +4547,7 @@ func TestDocumentBuilderAndWalkerFunctionality(t *testing.T) {
 					}
 
 					// Verify we can print it (basic validation)
-					output := SPrint(data)
+					output := mustSPrint(t, data)
 					for _, field := range tt.expectedFields {
 						if !strings.Contains(output, field) {
 							t.Errorf("SPrint output should contain field '%s'", field)
@@ -4835,7 +4954,10 @@ func TestSchemaParsingAndValidation(t *testing.T) {
 
 		// Create printer document to test schema reading
 		reader := NewReader(buf.Bytes)
-		doc := NewPrinterDocument(&reader)
+		doc, err := NewPrinterDocument(&reader)
+		if err != nil {
+			t.Fatal(err)
+		}
 		schema := NewPrinterSchema(&doc.Schema)
 
 		if len(schema.Fields) == 0 {
@@ -4880,7 +5002,10 @@ func TestSchemaParsingAndValidation(t *testing.T) {
 		encoder.Marshal(&testData, buf)
 
 		reader := NewReader(buf.Bytes)
-		doc := NewPrinterDocument(&reader)
+		doc, err := NewPrinterDocument(&reader)
+		if err != nil {
+			t.Fatal(err)
+		}
 		schema := NewPrinterSchema(&doc.Schema)
 
 		var mapField *PrinterSchemaField
@@ -4920,7 +5045,10 @@ func TestSchemaParsingAndValidation(t *testing.T) {
 		encoder.Marshal(&testData, buf)
 
 		reader := NewReader(buf.Bytes)
-		doc := NewPrinterDocument(&reader)
+		doc, err := NewPrinterDocument(&reader)
+		if err != nil {
+			t.Fatal(err)
+		}
 		schema := NewPrinterSchema(&doc.Schema)
 
 		var sliceField *PrinterSchemaField
@@ -4963,7 +5091,10 @@ func TestSchemaParsingAndValidation(t *testing.T) {
 		encoder.Marshal(&testData, buf)
 
 		reader := NewReader(buf.Bytes)
-		doc := NewPrinterDocument(&reader)
+		doc, err := NewPrinterDocument(&reader)
+		if err != nil {
+			t.Fatal(err)
+		}
 		schema := NewPrinterSchema(&doc.Schema)
 
 		var nestedSliceField *PrinterSchemaField
@@ -5008,7 +5139,10 @@ func TestSchemaParsingAndValidation(t *testing.T) {
 		encoder.Marshal(&testData, buf)
 
 		reader := NewReader(buf.Bytes)
-		doc := NewPrinterDocument(&reader)
+		doc, err := NewPrinterDocument(&reader)
+		if err != nil {
+			t.Fatal(err)
+		}
 		schema := NewPrinterSchema(&doc.Schema)
 
 		var complexField *PrinterSchemaField
@@ -5055,7 +5189,10 @@ func TestSchemaParsingAndValidation(t *testing.T) {
 		encoder.Marshal(&testData, buf)
 
 		reader := NewReader(buf.Bytes)
-		doc := NewPrinterDocument(&reader)
+		doc, err := NewPrinterDocument(&reader)
+		if err != nil {
+			t.Fatal(err)
+		}
 		schema := NewPrinterSchema(&doc.Schema)
 
 		// Verify we can parse complex schema structures without errors
@@ -5202,3 +5339,9157 @@ func TestWireTypeConversionAndMapping(t *testing.T) {
 	})
 
 }
+
+func TestGenerateDocumentFromSchema(t *testing.T) {
+
+	type generated struct {
+		Comprehensive Comprehensive              `glint:"c"`
+		Nested        [][]Child                  `glint:"nested"`
+		Ptr           *Child                     `glint:"ptr"`
+		Deltas        []int                      `glint:"deltas,delta"`
+		MapOfStructs  map[string]Child           `glint:"mos"`
+		MapOfSlices   map[string][]int           `glint:"mosl"`
+		MapOfMaps     map[string]map[string]bool `glint:"momo"`
+		IntKeys       map[int]string             `glint:"ik"`
+	}
+
+	schema := SchemaBytes(generated{})
+
+	t.Run("DecodesIntoMatchingType", func(t *testing.T) {
+		dec := NewDecoder[generated]()
+		for seed := int64(0); seed < 200; seed++ {
+			doc, err := GenerateDocument(schema, seed)
+			if err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+
+			var v generated
+			if err := dec.Unmarshal(doc, &v); err != nil {
+				t.Fatalf("seed %d: unmarshal failed: %v", seed, err)
+			}
+		}
+	})
+
+	t.Run("MapsOfDictStrings", func(t *testing.T) {
+		// dict strings within map values go to the document's string table, as they do anywhere else
+		type tagged struct {
+			Name string   `glint:"name,dict"`
+			Tags []string `glint:"tags,dict"`
+		}
+		type withMaps struct {
+			ByName map[string]tagged         `glint:"by_name"`
+			Nested map[int]map[string]tagged `glint:"nested"`
+		}
+
+		dec := NewDecoder[withMaps]()
+		read := 0
+		for seed := int64(0); seed < 100; seed++ {
+			doc, err := GenerateDocument(SchemaBytes(withMaps{}), seed)
+			if err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+
+			var v withMaps
+			if err := dec.Unmarshal(doc, &v); err != nil {
+				t.Fatalf("seed %d: unmarshal failed: %v", seed, err)
+			}
+			for _, e := range v.ByName {
+				read += len(e.Name) + len(e.Tags)
+			}
+
+			var again withMaps
+			if err := dec.Unmarshal(NewEncoder[withMaps]().MarshalBytes(&v), &again); err != nil || !reflect.DeepEqual(again, v) {
+				t.Fatalf("seed %d: expected the generated value to roundtrip, got %+v, %v", seed, again, err)
+			}
+		}
+		if read == 0 {
+			t.Error("expected some dict strings to be generated within the maps")
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		a, _ := GenerateDocument(schema, 42)
+		b, _ := GenerateDocument(schema, 42)
+		c, _ := GenerateDocument(schema, 43)
+
+		if !bytes.Equal(a, b) {
+			t.Error("same seed produced different documents")
+		}
+		if bytes.Equal(a, c) {
+			t.Error("different seeds produced identical documents")
+		}
+	})
+
+	t.Run("ReusesDocumentHeader", func(t *testing.T) {
+		type simple struct {
+			Name string `glint:"name"`
+			Age  int    `glint:"age"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[simple]().Marshal(&simple{Name: "a", Age: 1}, b)
+
+		doc, err := GenerateDocument(b.Bytes, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(doc, SchemaBytes(simple{})) {
+			t.Error("generated document does not carry the supplied schema")
+		}
+		if mustSPrint(t, doc) == "" {
+			t.Error("expected printable document")
+		}
+	})
+
+	t.Run("InvalidSchema", func(t *testing.T) {
+		if _, err := GenerateDocument([]byte{0, 1, 2}, 1); err == nil {
+			t.Error("expected error for short schema")
+		}
+		if _, err := GenerateDocument([]byte{0, 0, 0, 0, 0, 0}, 1); err == nil {
+			t.Error("expected error for empty schema")
+		}
+		if _, err := GenerateDocument([]byte{0, 0, 0, 0, 0, 3, 31, 1}, 1); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for malformed schema, got %v", err)
+		}
+	})
+}
+
+var updateVersionFixtures = flag.Bool("update-version-fixtures", false, "rewrite the fixtures for the current format version in testdata/versions")
+
+// versionFixture is the shape of the documents stored in testdata/versions. It must never change, the fixtures
+// for older format versions can't be regenerated.
+type versionFixture struct {
+	Bool    bool              `glint:"bool"`
+	Int     int               `glint:"int"`
+	Int8    int8              `glint:"int8"`
+	Int16   int16             `glint:"int16"`
+	Int32   int32             `glint:"int32"`
+	Int64   int64             `glint:"int64"`
+	Uint    uint              `glint:"uint"`
+	Uint8   uint8             `glint:"uint8"`
+	Uint16  uint16            `glint:"uint16"`
+	Uint32  uint32            `glint:"uint32"`
+	Uint64  uint64            `glint:"uint64"`
+	Float32 float32           `glint:"float32"`
+	Float64 float64           `glint:"float64"`
+	String  string            `glint:"string"`
+	Bytes   []byte            `glint:"bytes"`
+	Time    time.Time         `glint:"time"`
+	Child   Child             `glint:"child"`
+	Ptr     *Child            `glint:"ptr"`
+	Ints    []int             `glint:"ints"`
+	Deltas  []int             `glint:"deltas,delta"`
+	Strings []string          `glint:"strings"`
+	Matrix  [][]int           `glint:"matrix"`
+	Items   []Child           `glint:"items"`
+	Map     map[string]int    `glint:"map"`
+	Nested  map[string]Child  `glint:"nested"`
+	Tags    map[string]string `glint:"tags"`
+}
+
+// constrainedVersionFixture is versionFixture with field constraints, which write its documents as version 2, or
+// version 3 along with name hashes. It converts to and from versionFixture, and must never change for the same
+// reason.
+type constrainedVersionFixture struct {
+	Bool    bool              `glint:"bool"`
+	Int     int               `glint:"int,min=-100,max=100"`
+	Int8    int8              `glint:"int8"`
+	Int16   int16             `glint:"int16"`
+	Int32   int32             `glint:"int32"`
+	Int64   int64             `glint:"int64"`
+	Uint    uint              `glint:"uint"`
+	Uint8   uint8             `glint:"uint8"`
+	Uint16  uint16            `glint:"uint16"`
+	Uint32  uint32            `glint:"uint32"`
+	Uint64  uint64            `glint:"uint64"`
+	Float32 float32           `glint:"float32"`
+	Float64 float64           `glint:"float64"`
+	String  string            `glint:"string,pattern=^(h|$)"`
+	Bytes   []byte            `glint:"bytes"`
+	Time    time.Time         `glint:"time"`
+	Child   Child             `glint:"child"`
+	Ptr     *Child            `glint:"ptr"`
+	Ints    []int             `glint:"ints,min=-10"`
+	Deltas  []int             `glint:"deltas,delta"`
+	Strings []string          `glint:"strings"`
+	Matrix  [][]int           `glint:"matrix"`
+	Items   []Child           `glint:"items"`
+	Map     map[string]int    `glint:"map"`
+	Nested  map[string]Child  `glint:"nested"`
+	Tags    map[string]string `glint:"tags"`
+}
+
+// pointerVersionFixture is constrainedVersionFixture with a slice of pointers, which writes its documents as version
+// 4. Decoding them into versionFixture skips the slice, and it must never change for the same reason.
+type pointerVersionFixture struct {
+	constrainedVersionFixture
+	Refs []*Child `glint:"refs"`
+}
+
+// versionFixtures holds the value expected from each fixture, keyed by its path within testdata/versions
+var versionFixtures = map[string]versionFixture{
+	// version 0 documents don't record whether a slice or map was nil, so they always decode as empty
+	"v0/zero.glint": {
+		Bytes: []byte{}, Ints: []int{}, Deltas: []int{}, Strings: []string{}, Matrix: [][]int{}, Items: []Child{},
+		Map: map[string]int{}, Nested: map[string]Child{}, Tags: map[string]string{},
+	},
+	"v0/populated.glint": populatedVersionFixture,
+
+	// version 1 shares the schema of Child between the fields using it
+	"v1/zero.glint":      {Map: map[string]int{}, Nested: map[string]Child{}, Tags: map[string]string{}},
+	"v1/populated.glint": populatedVersionFixture,
+
+	// version 2 carries field constraints in the schema
+	"v2/zero.glint":      {Map: map[string]int{}, Nested: map[string]Child{}, Tags: map[string]string{}},
+	"v2/populated.glint": populatedVersionFixture,
+
+	// version 3 follows the schema with the hashes of its field names
+	"v3/zero.glint":      {Map: map[string]int{}, Nested: map[string]Child{}, Tags: map[string]string{}},
+	"v3/populated.glint": populatedVersionFixture,
+
+	// version 4 marks the elements of slices of pointers in the schema, the fixtures hold one after the other fields.
+	// They were written after nil slices stopped being kept distinct by default, so decode as empty.
+	"v4/zero.glint": {
+		Bytes: []byte{}, Ints: []int{}, Deltas: []int{}, Strings: []string{}, Matrix: [][]int{}, Items: []Child{},
+		Map: map[string]int{}, Nested: map[string]Child{}, Tags: map[string]string{},
+	},
+	"v4/populated.glint": populatedVersionFixture,
+}
+
+var populatedVersionFixture = versionFixture{
+	Bool: true, Int: -42, Int8: -8, Int16: -1600, Int32: 320000, Int64: -6400000000,
+	Uint: 42, Uint8: 8, Uint16: 1600, Uint32: 320000, Uint64: 6400000000,
+	Float32: 3.25, Float64: -1234.5678, String: "hello, archive", Bytes: []byte{0, 1, 2, 254, 255},
+	Time:    time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC),
+	Child:   Child{A: 1, B: "one"},
+	Ptr:     &Child{A: 2, B: "two"},
+	Ints:    []int{1, -2, 3},
+	Deltas:  []int{100, 101, 99, 1000},
+	Strings: []string{"a", "", "c"},
+	Matrix:  [][]int{{1, 2}, {}, {3}},
+	Items:   []Child{{A: 3, B: "three"}, {A: 4, B: "four"}},
+	Map:     map[string]int{"x": 1},
+	Nested:  map[string]Child{"k": {A: 5, B: "five"}},
+	Tags:    map[string]string{"env": "prod"},
+}
+
+func TestVersionedDecoding(t *testing.T) {
+
+	if *updateVersionFixtures {
+		version := fmt.Sprintf("v%d/", currentFormatVersion)
+		if err := os.MkdirAll(filepath.Join("testdata/versions", version), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		enc := NewEncoder[pointerVersionFixture](WithNameHashes())
+		for name, v := range versionFixtures {
+			if !strings.HasPrefix(name, version) {
+				continue // fixtures for older versions can't be regenerated
+			}
+
+			fixture := pointerVersionFixture{constrainedVersionFixture: constrainedVersionFixture(v)}
+			if v.Bool {
+				fixture.Refs = []*Child{{A: 6, B: "six"}, nil}
+			}
+
+			b := &Buffer{}
+			enc.Marshal(&fixture, b)
+			if err := os.WriteFile(filepath.Join("testdata/versions", name), b.Bytes, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("HistoricalFixtures", func(t *testing.T) {
+		files, err := filepath.Glob("testdata/versions/v*/*.glint")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Fatal("no version fixtures found")
+		}
+
+		dec := NewDecoder[versionFixture]()
+		for _, file := range files {
+			t.Run(file, func(t *testing.T) {
+				doc, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected, ok := versionFixtures[filepath.Base(filepath.Dir(file))+"/"+filepath.Base(file)]
+				if !ok {
+					t.Fatalf("no expected value for fixture %s", file)
+				}
+
+				var v versionFixture
+				if err := dec.Unmarshal(doc, &v); err != nil {
+					t.Fatalf("unmarshal failed: %v", err)
+				}
+
+				got, _ := json.Marshal(v)
+				want, _ := json.Marshal(expected)
+				if !bytes.Equal(got, want) {
+					t.Errorf("decoded fixture mismatch\n got: %s\nwant: %s", got, want)
+				}
+
+				if mustSPrint(t, doc) == "" {
+					t.Error("expected printable fixture")
+				}
+			})
+		}
+	})
+
+	t.Run("UnsupportedVersion", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[Child]().Marshal(&Child{A: 1}, b)
+		b.Bytes[0] |= 5
+
+		var v Child
+		if err := NewDecoder[Child]().Unmarshal(b.Bytes, &v); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+		}
+		if err := Walk(b.Bytes, DecodingVisitor(&testVisitor{})); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected ErrUnsupportedVersion from Walk, got %v", err)
+		}
+	})
+
+	t.Run("UpgradeDispatch", func(t *testing.T) {
+		// a made up version that stores its body before the schema, upgraded into the current layout on read
+		const version = 7
+		formatVersions[version] = &formatVersion{
+			split: func(r Reader) (documentParts, error) {
+				p := documentParts{flags: r.ReadByte(), hash: r.Read(4)}
+				body := r.Read(r.ReadVarint())
+				p.schema = NewReader(r.Remaining())
+				p.body = NewReader(body)
+				return p, nil
+			},
+			upgrade: func(p documentParts) (documentParts, error) {
+				p.flags &^= flagVersionMask
+				return p, nil
+			},
+		}
+		defer func() { formatVersions[version] = nil }()
+
+		current := &Buffer{}
+		NewEncoder[Child]().Marshal(&Child{A: 7, B: "seven"}, current)
+
+		parts, err := splitDocument(current.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		old := []byte{version}
+		old = append(old, parts.hash...)
+		old = appendVarintb(old, uint64(parts.body.BytesLeft()))
+		old = append(old, parts.body.Remaining()...)
+		old = append(old, parts.schema.Remaining()...)
+
+		var v Child
+		if err := NewDecoder[Child]().Unmarshal(old, &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.A != 7 || v.B != "seven" {
+			t.Errorf("unexpected value decoded from upgraded document: %+v", v)
+		}
+	})
+}
+
+func TestNilSliceRoundtrip(t *testing.T) {
+
+	type inner struct {
+		Tags []string `glint:"tags"`
+	}
+
+	type record struct {
+		Name  string  `glint:"name"`
+		Ints  []int   `glint:"ints"`
+		Raw   []byte  `glint:"raw"`
+		Inner []inner `glint:"inner"`
+		Grid  [][]int `glint:"grid"`
+		After string  `glint:"after"`
+	}
+
+	type legacy struct {
+		Name  string `glint:"name"`
+		After string `glint:"after"`
+	}
+
+	roundtrip := func(t *testing.T, enc *Encoder[record], in record) record {
+		t.Helper()
+		b := &Buffer{}
+		enc.Marshal(&in, b)
+
+		var out record
+		if err := NewDecoder[record]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatalf("unmarshal failed: %v", err)
+		}
+		return out
+	}
+
+	t.Run("NilAndEmptyAreDistinct", func(t *testing.T) {
+		out := roundtrip(t, NewEncoder[record](WithDistinctNilSlices()), record{
+			Name:  "a",
+			Ints:  []int{},
+			Inner: []inner{{Tags: nil}, {Tags: []string{}}},
+			After: "z",
+		})
+
+		if out.Ints == nil || len(out.Ints) != 0 {
+			t.Errorf("expected empty non-nil ints, got %#v", out.Ints)
+		}
+		if out.Raw != nil {
+			t.Errorf("expected nil raw, got %#v", out.Raw)
+		}
+		if out.Grid != nil {
+			t.Errorf("expected nil grid, got %#v", out.Grid)
+		}
+		if len(out.Inner) != 2 || out.Inner[0].Tags != nil || out.Inner[1].Tags == nil {
+			t.Errorf("nested slices lost their nil-ness: %#v", out.Inner)
+		}
+		if out.Name != "a" || out.After != "z" {
+			t.Errorf("fields either side of the slices were corrupted: %+v", out)
+		}
+	})
+
+	t.Run("Populated", func(t *testing.T) {
+		in := record{
+			Ints:  []int{1, 2, 3},
+			Raw:   []byte{0, 1},
+			Inner: []inner{{Tags: []string{"x"}}},
+			Grid:  [][]int{{1}, {}, nil},
+			After: "z",
+		}
+		out := roundtrip(t, NewEncoder[record](WithDistinctNilSlices()), in)
+
+		// elements of nested slices don't carry a presence byte, so nil decodes as empty there
+		in.Grid[2] = []int{}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("roundtrip mismatch\n got: %#v\nwant: %#v", out, in)
+		}
+	})
+
+	t.Run("CollapsedByDefault", func(t *testing.T) {
+		enc := NewEncoder[record]()
+		out := roundtrip(t, enc, record{})
+
+		if out.Ints == nil || out.Raw == nil || out.Inner == nil || out.Grid == nil {
+			t.Errorf("expected collapsed nil slices to decode as empty, got %#v", out)
+		}
+
+		// populated slices pay for the presence byte only when it's asked for
+		in := record{Ints: []int{1}, Raw: []byte{1}, Inner: []inner{{Tags: []string{"x"}}}, Grid: [][]int{{1}}}
+		withPresence, collapsed := &Buffer{}, &Buffer{}
+		NewEncoder[record](WithDistinctNilSlices()).Marshal(&in, withPresence)
+		enc.Marshal(&in, collapsed)
+
+		if len(withPresence.Bytes)-len(collapsed.Bytes) != 5 {
+			t.Errorf("expected collapsing to save a byte per slice field, got %d vs %d", len(collapsed.Bytes), len(withPresence.Bytes))
+		}
+		if v := collapsed.Bytes[0] & flagVersionMask; v != formatVersionOriginal {
+			t.Errorf("expected collapsed nil slices to keep format version %d, got %d", formatVersionOriginal, v)
+		}
+		if v := withPresence.Bytes[0] & flagVersionMask; v != formatVersionNilSlices {
+			t.Errorf("expected distinct nil slices to be format version %d, got %d", formatVersionNilSlices, v)
+		}
+	})
+
+	t.Run("SkippedByOtherTypes", func(t *testing.T) {
+		for _, in := range []record{{Name: "a", After: "z"}, {Name: "a", Ints: []int{1}, Raw: []byte{1}, Inner: []inner{{}}, Grid: [][]int{{1}}, After: "z"}} {
+			b := &Buffer{}
+			NewEncoder[record](WithDistinctNilSlices()).Marshal(&in, b)
+
+			var out legacy
+			if err := NewDecoder[legacy]().Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatalf("unmarshal failed: %v", err)
+			}
+			if out.Name != "a" || out.After != "z" {
+				t.Errorf("unexpected value decoded past nil-able slices: %+v", out)
+			}
+		}
+	})
+
+	t.Run("PrintAndWalk", func(t *testing.T) {
+		in := record{Name: "a", Inner: []inner{{Tags: nil}}, After: "z"}
+		b := &Buffer{}
+		NewEncoder[record](WithDistinctNilSlices()).Marshal(&in, b)
+
+		s := mustSPrint(t, b.Bytes)
+		if !strings.Contains(s, "z") {
+			t.Errorf("expected printed document to reach the last field, got\n%s", s)
+		}
+
+		v := &testVisitor{fields: map[string]any{}}
+		if err := Walk(b.Bytes, DecodingVisitor(v)); err != nil {
+			t.Fatal(err)
+		}
+		if v.fields["name"] != "a" || v.fields["after"] != "z" {
+			t.Errorf("unexpected walked fields: %v", v.fields)
+		}
+	})
+}
+
+func TestCanonicalEncoding(t *testing.T) {
+
+	type nested struct {
+		Scores map[string]int `glint:"scores"`
+		When   time.Time      `glint:"when"`
+	}
+
+	type first struct {
+		Name   string            `glint:"name"`
+		Labels map[string]string `glint:"labels"`
+		Ids    map[int32]bool    `glint:"ids"`
+		Times  []time.Time       `glint:"times"`
+		Nested []nested          `glint:"nested"`
+		When   *time.Time        `glint:"when"`
+	}
+
+	// same fields as first, declared in a different order
+	type second struct {
+		When   *time.Time        `glint:"when"`
+		Nested []nested          `glint:"nested"`
+		Ids    map[int32]bool    `glint:"ids"`
+		Name   string            `glint:"name"`
+		Times  []time.Time       `glint:"times"`
+		Labels map[string]string `glint:"labels"`
+	}
+
+	instant := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	elsewhere := instant.In(time.FixedZone("elsewhere", -5*60*60))
+
+	labels := map[string]string{}
+	ids := map[int32]bool{}
+	scores := map[string]int{}
+	for i := 0; i < 50; i++ {
+		labels[fmt.Sprint("label", i)] = fmt.Sprint(i)
+		ids[int32(i*7)] = i%2 == 0
+		scores[fmt.Sprint("score", i)] = i
+	}
+
+	a := first{Name: "a", Labels: labels, Ids: ids, Times: []time.Time{instant}, Nested: []nested{{Scores: scores, When: instant}}, When: &instant}
+	b := second{Name: "a", Labels: labels, Ids: ids, Times: []time.Time{elsewhere}, Nested: []nested{{Scores: scores, When: elsewhere}}, When: &elsewhere}
+
+	encA := NewEncoder[first](WithCanonicalEncoding())
+	encB := NewEncoder[second](WithCanonicalEncoding())
+
+	t.Run("Stable", func(t *testing.T) {
+		want := &Buffer{}
+		encA.Marshal(&a, want)
+
+		for i := 0; i < 20; i++ {
+			got := &Buffer{}
+			encA.Marshal(&a, got)
+			if !bytes.Equal(got.Bytes, want.Bytes) {
+				t.Fatal("canonical encoding produced different bytes for the same value")
+			}
+		}
+	})
+
+	t.Run("IndependentOfDeclarationAndZone", func(t *testing.T) {
+		bufA, bufB := &Buffer{}, &Buffer{}
+		encA.Marshal(&a, bufA)
+		encB.Marshal(&b, bufB)
+
+		if !bytes.Equal(bufA.Bytes, bufB.Bytes) {
+			t.Errorf("expected identical documents\n a: %v\n b: %v", bufA.Bytes, bufB.Bytes)
+		}
+	})
+
+	t.Run("IgnoresTrust", func(t *testing.T) {
+		want := &Buffer{}
+		encA.Marshal(&a, want)
+
+		got := &Buffer{TrustedSchema: true}
+		encA.Marshal(&a, got)
+
+		if !bytes.Equal(got.Bytes, want.Bytes) {
+			t.Error("expected trusted schema mode to have no effect on canonical documents")
+		}
+	})
+
+	t.Run("Decodes", func(t *testing.T) {
+		buf := &Buffer{}
+		encB.Marshal(&b, buf)
+
+		var out first
+		if err := NewDecoder[first]().Unmarshal(buf.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(out.Labels, labels) || !reflect.DeepEqual(out.Ids, ids) || !reflect.DeepEqual(out.Nested[0].Scores, scores) {
+			t.Error("maps didn't survive a canonical roundtrip")
+		}
+		if !out.When.Equal(instant) || !out.Times[0].Equal(instant) || !out.Nested[0].When.Equal(instant) {
+			t.Errorf("times didn't survive a canonical roundtrip: %v %v %v", out.When, out.Times, out.Nested[0].When)
+		}
+		if out.When.Location() != time.UTC {
+			t.Errorf("expected canonical times to decode in UTC, got %v", out.When.Location())
+		}
+	})
+}
+
+func TestSparseSliceEncoding(t *testing.T) {
+
+	type histogram struct {
+		Name    string    `glint:"name"`
+		Buckets []uint32  `glint:"buckets,sparse"`
+		OneHot  []float64 `glint:"onehot,sparse"`
+		Ints    []int     `glint:"ints,sparse"`
+		After   string    `glint:"after"`
+	}
+
+	// the same fields without the option, decoders don't need it
+	type plain struct {
+		Name    string    `glint:"name"`
+		Buckets []uint32  `glint:"buckets"`
+		OneHot  []float64 `glint:"onehot"`
+		Ints    []int     `glint:"ints"`
+		After   string    `glint:"after"`
+	}
+
+	buckets := make([]uint32, 1000)
+	buckets[3], buckets[500], buckets[999] = 7, 1<<31, 1
+	onehot := make([]float64, 64)
+	onehot[42] = 1
+	onehot[0] = math.Copysign(0, -1)
+
+	sparse := histogram{
+		Name:    "h",
+		Buckets: buckets,
+		OneHot:  onehot,
+		Ints:    []int{1, -2, 3, 0}, // mostly non-zero, written dense
+		After:   "z",
+	}
+
+	encode := func(v any) []byte {
+		b := &Buffer{}
+		switch v := v.(type) {
+		case histogram:
+			NewEncoder[histogram]().Marshal(&v, b)
+		case plain:
+			NewEncoder[plain]().Marshal(&v, b)
+		}
+		return b.Bytes
+	}
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		var out histogram
+		if err := NewDecoder[histogram]().Unmarshal(encode(sparse), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, sparse) {
+			t.Errorf("roundtrip mismatch\n got: %v\nwant: %v", out, sparse)
+		}
+		if !math.Signbit(out.OneHot[0]) {
+			t.Error("expected negative zero to survive the roundtrip")
+		}
+	})
+
+	t.Run("Smaller", func(t *testing.T) {
+		dense := encode(plain(sparse))
+		if got := encode(sparse); len(got)*10 > len(dense) {
+			t.Errorf("expected sparse document to be much smaller than %d bytes, got %d", len(dense), len(got))
+		}
+	})
+
+	t.Run("DecodedWithoutTheOption", func(t *testing.T) {
+		dec := NewDecoder[plain]()
+
+		// alternate between sparse and dense schemas, so cached instructions are reused for both
+		for i := 0; i < 2; i++ {
+			var out plain
+			if err := dec.Unmarshal(encode(sparse), &out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, plain(sparse)) {
+				t.Errorf("sparse document decoded incorrectly: %v", out)
+			}
+
+			out = plain{}
+			if err := dec.Unmarshal(encode(plain(sparse)), &out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, plain(sparse)) {
+				t.Errorf("dense document decoded incorrectly: %v", out)
+			}
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		type names struct {
+			Name  string `glint:"name"`
+			After string `glint:"after"`
+		}
+
+		var out names
+		if err := NewDecoder[names]().Unmarshal(encode(sparse), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Name != "h" || out.After != "z" {
+			t.Errorf("unexpected value decoded past sparse slices: %+v", out)
+		}
+	})
+
+	t.Run("Printed", func(t *testing.T) {
+		s := mustSPrint(t, encode(sparse))
+		for _, want := range []string{"(sparse)Uint32", "[500]: 2147483648", "[42]: 1"} {
+			if !strings.Contains(s, want) {
+				t.Errorf("expected printed document to contain %q\n%s", want, s)
+			}
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		for seed := int64(0); seed < 50; seed++ {
+			doc, err := GenerateDocument(SchemaBytes(histogram{}), seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out histogram
+			if err := NewDecoder[histogram]().Unmarshal(doc, &out); err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		type one struct {
+			V []uint32 `glint:"v,sparse"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[one]().Marshal(&one{V: []uint32{}}, b)
+		b.Bytes = b.Bytes[:len(b.Bytes)-2] // drop the length and count
+		b.AppendUint(1 << 40)              // a length that isn't backed by any bytes
+		b.AppendUint(0)
+
+		var out one
+		if err := NewDecoder[one]().Unmarshal(b.Bytes, &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected an oversized sparse slice to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		for name, f := range map[string]func(){
+			"strings": func() {
+				NewEncoder[struct {
+					V []string `glint:"v,sparse"`
+				}]()
+			},
+			"delta": func() {
+				NewEncoder[struct {
+					V []int `glint:"v,sparse,delta"`
+				}]()
+			},
+			"nested slices": func() {
+				NewEncoder[struct {
+					V [][]int `glint:"v,sparse"`
+				}]()
+			},
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("expected sparse option on %s to panic", name)
+					}
+				}()
+				f()
+			}()
+		}
+	})
+}
+
+func TestSharedSchemas(t *testing.T) {
+
+	type address struct {
+		Street   string `glint:"street"`
+		City     string `glint:"city"`
+		Postcode string `glint:"postcode"`
+	}
+
+	type company struct {
+		Name    string  `glint:"name"`
+		Address address `glint:"address"`
+	}
+
+	type user struct {
+		Name     string             `glint:"name"`
+		Home     address            `glint:"home"`
+		Work     *address           `glint:"work"`
+		Previous []address          `glint:"previous"`
+		Employer company            `glint:"employer"`
+		Contacts map[string]address `glint:"contacts"`
+		Grid     [][]address        `glint:"grid"`
+		Empty    *address           `glint:"empty"`
+	}
+
+	a := address{Street: "1 High St", City: "Leeds", Postcode: "LS1"}
+	in := user{
+		Name:     "sam",
+		Home:     a,
+		Work:     &address{City: "York"},
+		Previous: []address{a, {City: "Hull"}},
+		Employer: company{Name: "acme", Address: a},
+		Contacts: map[string]address{"mum": a},
+		Grid:     [][]address{{a}},
+	}
+
+	shared, inline := &Buffer{}, &Buffer{}
+	NewEncoder[user]().Marshal(&in, shared)
+	NewEncoder[user](WithInlineSchemas()).Marshal(&in, inline)
+
+	t.Run("Smaller", func(t *testing.T) {
+		if len(shared.Bytes) >= len(inline.Bytes) {
+			t.Errorf("expected shared schemas to shrink the document, got %d vs %d bytes", len(shared.Bytes), len(inline.Bytes))
+		}
+		if v := shared.Bytes[0] & flagVersionMask; v != formatVersionSchemaRefs {
+			t.Errorf("expected format version %d, got %d", formatVersionSchemaRefs, v)
+		}
+		if v := inline.Bytes[0] & flagVersionMask; v != formatVersionOriginal {
+			t.Errorf("expected inline schemas to keep format version %d, got %d", formatVersionOriginal, v)
+		}
+	})
+
+	t.Run("Inlined", func(t *testing.T) {
+		s, err := splitDocument(shared.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.inlineSchema(DefaultLimits); err != nil {
+			t.Fatal(err)
+		}
+
+		i, _ := splitDocument(inline.Bytes)
+		if !bytes.Equal(s.schema.Remaining(), i.schema.Remaining()) {
+			t.Error("expected inlining the shared schema to reproduce the full schema")
+		}
+	})
+
+	t.Run("Decodes", func(t *testing.T) {
+		for name, doc := range map[string][]byte{"shared": shared.Bytes, "inline": inline.Bytes} {
+			var out user
+			if err := NewDecoder[user]().Unmarshal(doc, &out); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !reflect.DeepEqual(out, in) {
+				t.Errorf("%s: roundtrip mismatch\n got: %+v\nwant: %+v", name, out, in)
+			}
+
+			// fields the decoder doesn't know are skipped using the shared schemas too
+			var partial company
+			if err := NewDecoder[company]().Unmarshal(doc, &partial); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if partial.Name != "sam" {
+				t.Errorf("%s: unexpected value decoded: %+v", name, partial)
+			}
+		}
+	})
+
+	t.Run("Tooling", func(t *testing.T) {
+		header := func(doc []byte) int { // printed documents start with their flags and hash, which differ
+			return strings.Index(mustSPrint(t, doc), "name")
+		}
+		if mustSPrint(t, shared.Bytes)[header(shared.Bytes):] != mustSPrint(t, inline.Bytes)[header(inline.Bytes):] {
+			t.Errorf("expected identical printed documents\n%s\n%s", mustSPrint(t, shared.Bytes), mustSPrint(t, inline.Bytes))
+		}
+
+		doc, err := GenerateDocument(shared.Bytes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out user
+		if err := NewDecoder[user]().Unmarshal(doc, &out); err != nil {
+			t.Fatalf("generated document failed to decode: %v", err)
+		}
+	})
+
+	t.Run("UndefinedReference", func(t *testing.T) {
+		schema := appendField(nil, "a", WireStruct|WireSchemaRefFlag)
+		schema = appendVarintb(schema, 3)
+
+		if _, err := inlineSchemaRefs(schema, DefaultLimits); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument, got %v", err)
+		}
+	})
+}
+
+// mapSchemaStore is a SchemaStore standing in for a remote backend
+type mapSchemaStore struct {
+	mu      sync.Mutex
+	schemas map[uint32][]byte
+	gets    int
+	err     error
+}
+
+func (s *mapSchemaStore) PutSchema(id uint32, schema []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.schemas[id] = schema
+	return nil
+}
+
+func (s *mapSchemaStore) GetSchema(id uint32) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	schema, ok := s.schemas[id]
+	if !ok {
+		return nil, ErrSchemaNotRegistered
+	}
+	return schema, nil
+}
+
+func TestSchemaRegistry(t *testing.T) {
+
+	type reading struct {
+		Sensor      string  `glint:"sensor"`
+		Temperature float64 `glint:"temperature"`
+		Humidity    float64 `glint:"humidity"`
+		Sequence    uint64  `glint:"sequence"`
+	}
+
+	in := reading{Sensor: "kitchen", Temperature: 21.5, Humidity: 40, Sequence: 7}
+
+	t.Run("InProcess", func(t *testing.T) {
+		registry := NewSchemaRegistry(nil)
+
+		full, compact := &Buffer{}, &Buffer{}
+		NewEncoder[reading]().Marshal(&in, full)
+		NewEncoder[reading](WithSchemaRegistry(registry)).Marshal(&in, compact)
+
+		if len(compact.Bytes) >= len(full.Bytes) {
+			t.Errorf("expected the schema ID to shrink the document, got %d vs %d bytes", len(compact.Bytes), len(full.Bytes))
+		}
+
+		var out reading
+		if err := NewDecoderWithRegistry[reading](registry).Unmarshal(compact.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out != in {
+			t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, in)
+		}
+
+		if err := NewDecoder[reading]().Unmarshal(compact.Bytes, &out); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected ErrSchemaNotFound without a registry, got %v", err)
+		}
+		if err := NewDecoderWithRegistry[reading](NewSchemaRegistry(nil)).Unmarshal(compact.Bytes, &out); !errors.Is(err, ErrSchemaNotRegistered) {
+			t.Errorf("expected ErrSchemaNotRegistered from an empty registry, got %v", err)
+		}
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		store := &mapSchemaStore{schemas: map[uint32][]byte{}}
+
+		b := &Buffer{}
+		NewEncoder[reading](WithSchemaRegistry(NewSchemaRegistry(store))).Marshal(&in, b)
+
+		// a separate registry, as a decoding process would have, resolves the ID from the store once
+		dec := NewDecoderWithRegistry[reading](NewSchemaRegistry(store))
+		for i := 0; i < 3; i++ {
+			var out reading
+			if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out != in {
+				t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, in)
+			}
+		}
+		if store.gets != 1 {
+			t.Errorf("expected the store to be asked once, got %d", store.gets)
+		}
+	})
+
+	t.Run("StoreMismatch", func(t *testing.T) {
+		store := &mapSchemaStore{schemas: map[uint32][]byte{}}
+		registry := NewSchemaRegistry(store)
+		id, err := registry.Register(NewEncoder[reading]().Schema().Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		type other struct {
+			Name string `glint:"name"`
+		}
+		store.schemas[id] = NewEncoder[other]().Schema().Bytes
+		if _, err := NewSchemaRegistry(store).Lookup(id); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for a schema that doesn't match its ID, got %v", err)
+		}
+	})
+
+	t.Run("StoreFailure", func(t *testing.T) {
+		store := &mapSchemaStore{schemas: map[uint32][]byte{}, err: errors.New("unavailable")}
+		if _, err := NewSchemaRegistry(store).Register(NewEncoder[reading]().Schema().Bytes); !errors.Is(err, store.err) {
+			t.Errorf("expected the store's error, got %v", err)
+		}
+
+		defer func() {
+			if recover() == nil {
+				t.Error("expected building the encoder to panic")
+			}
+		}()
+		NewEncoder[reading](WithSchemaRegistry(NewSchemaRegistry(store)))
+	})
+
+	t.Run("Expand", func(t *testing.T) {
+		registry := NewSchemaRegistry(nil)
+
+		full, compact := &Buffer{}, &Buffer{}
+		NewEncoder[reading]().Marshal(&in, full)
+		NewEncoder[reading](WithSchemaRegistry(registry)).Marshal(&in, compact)
+
+		expanded, err := registry.Expand(compact.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expanded, full.Bytes) {
+			t.Errorf("expected the expanded document to match the full document\n got: %v\nwant: %v", expanded, full.Bytes)
+		}
+		if mustSPrint(t, expanded) != mustSPrint(t, full.Bytes) {
+			t.Error("expected the expanded document to print")
+		}
+
+		// slices of pointers write version 4, which has a list of name hashes after the schema even when it's empty
+		type pointers struct {
+			Readings []*reading `glint:"readings"`
+		}
+		p := pointers{Readings: []*reading{&in, nil}}
+		full.Reset()
+		NewEncoder[pointers]().Marshal(&p, full)
+		expanded, err = registry.Expand(NewEncoder[pointers](WithSchemaRegistry(registry)).MarshalBytes(&p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expanded, full.Bytes) {
+			t.Errorf("expected the expanded version 4 document to match the full document\n got: %v\nwant: %v", expanded, full.Bytes)
+		}
+
+		if _, err := registry.Register([]byte{5, 1}); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument registering a truncated schema, got %v", err)
+		}
+	})
+}
+
+func TestSchemaLimits(t *testing.T) {
+
+	type leaf struct {
+		A int `glint:"a"`
+	}
+
+	// document wraps a list of schema fields into a document, the body is left to the caller
+	document := func(flags byte, schema []byte, body ...byte) []byte {
+		doc := []byte{flags, 1, 2, 3, 4}
+		doc = appendVarintb(doc, uint64(len(schema)))
+		doc = append(doc, schema...)
+		return append(doc, body...)
+	}
+
+	t.Run("Depth", func(t *testing.T) {
+		// a slice of slices nested far beyond anything a Go type would produce, a few bytes per level
+		schema := appendField(nil, "a", WireSliceFlag)
+		for i := 0; i < 1_000_000; i++ {
+			schema = appendVarintb(schema, uint64(WireSliceFlag))
+		}
+		schema = appendVarintb(schema, uint64(WireSliceFlag|WireInt))
+
+		var out leaf
+		if err := NewDecoder[leaf]().Unmarshal(document(0, schema), &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument, got %v", err)
+		}
+	})
+
+	t.Run("Fields", func(t *testing.T) {
+		var schema []byte
+		for i := 0; i < 5000; i++ {
+			schema = appendField(schema, fmt.Sprintf("f%d", i), WireInt)
+		}
+		doc := document(0, schema, make([]byte, 5000)...)
+
+		var out leaf
+		if err := NewDecoder[leaf]().Unmarshal(doc, &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument, got %v", err)
+		}
+
+		limits := DefaultLimits
+		limits.MaxFieldsPerSchema = 0
+		if err := NewDecoderWithLimits[leaf](limits).Unmarshal(doc, &out); err != nil {
+			t.Errorf("expected the document to decode without a field limit, got %v", err)
+		}
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		type outer struct {
+			Items []leaf `glint:"items"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[outer]().Marshal(&outer{Items: []leaf{{A: 1}}}, b)
+
+		limits := DefaultLimits
+		limits.MaxSchemaDepth = 2
+		var out outer
+		if err := NewDecoderWithLimits[outer](limits).Unmarshal(b.Bytes, &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument, got %v", err)
+		}
+
+		limits.MaxSchemaDepth = 3
+		if err := NewDecoderWithLimits[outer](limits).Unmarshal(b.Bytes, &out); err != nil || out.Items[0].A != 1 {
+			t.Errorf("expected the document to decode, got %v %+v", err, out)
+		}
+	})
+
+	t.Run("SharedSchemaExpansion", func(t *testing.T) {
+		// every struct refers to the one before it twice, so the schema doubles in size with each field
+		inner := appendField(nil, "a", WireInt)
+		schema := appendField(nil, "s0", WireStruct)
+		schema = appendVarintb(schema, uint64(len(inner)))
+		schema = append(schema, inner...)
+
+		for i := 1; i < 48; i++ {
+			var fields []byte
+			for _, name := range []string{"a", "b"} {
+				fields = appendField(fields, name, WireStruct|WireSchemaRefFlag)
+				fields = appendVarintb(fields, uint64(i-1))
+			}
+			schema = appendField(schema, fmt.Sprintf("s%d", i), WireStruct)
+			schema = appendVarintb(schema, uint64(len(fields)))
+			schema = append(schema, fields...)
+		}
+
+		var out leaf
+		if err := NewDecoder[leaf]().Unmarshal(document(formatVersionSchemaRefs, schema), &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument, got %v", err)
+		}
+	})
+}
+
+func TestSchemaSession(t *testing.T) {
+
+	type message struct {
+		ID   int    `glint:"id"`
+		Body string `glint:"body"`
+	}
+
+	type messageV2 struct {
+		ID      int    `glint:"id"`
+		Body    string `glint:"body"`
+		Urgent  bool   `glint:"urgent"`
+		Replies int    `glint:"replies"`
+	}
+
+	t.Run("Handshake", func(t *testing.T) {
+		sender, receiver := NewSchemaSession(), NewSchemaSession()
+		enc, dec := NewEncoder[message](), NewDecoder[message]()
+
+		send := func(v message) []byte {
+			b := &Buffer{}
+			enc.MarshalWithSession(&v, b, sender)
+
+			var out message
+			if err := dec.UnmarshalWithSession(b.Bytes, &out, receiver); err != nil {
+				t.Fatal(err)
+			}
+			if out != v {
+				t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, v)
+			}
+			return b.Bytes
+		}
+
+		first := send(message{ID: 1, Body: "hello"})
+		unacknowledged := send(message{ID: 2, Body: "hello"})
+		if len(unacknowledged) != len(first) {
+			t.Error("expected the schema to be sent until it's acknowledged")
+		}
+
+		acks := receiver.Acknowledgements()
+		if len(acks) != 1 {
+			t.Fatalf("expected a single acknowledgement, got %v", acks)
+		}
+		if again := receiver.Acknowledgements(); len(again) != 0 {
+			t.Errorf("expected acknowledgements to be handed back once, got %v", again)
+		}
+		sender.Acknowledge(acks...)
+
+		trusted := send(message{ID: 3, Body: "hello"})
+		if len(trusted) >= len(first) {
+			t.Errorf("expected the schema to be left out once acknowledged, got %d vs %d bytes", len(trusted), len(first))
+		}
+		if acks := receiver.Acknowledgements(); len(acks) != 0 {
+			t.Errorf("expected no acknowledgements for schema-less documents, got %v", acks)
+		}
+
+		sender.Revoke(acks...)
+		sender.Reset()
+		if again := send(message{ID: 4, Body: "hello"}); len(again) != len(first) {
+			t.Error("expected the schema to be sent again after a reset")
+		}
+	})
+
+	t.Run("ChangedSchema", func(t *testing.T) {
+		sender, receiver := NewSchemaSession(), NewSchemaSession()
+		dec := NewDecoder[message]()
+
+		b := &Buffer{}
+		NewEncoder[message]().MarshalWithSession(&message{ID: 1}, b, sender)
+		if err := dec.UnmarshalWithSession(b.Bytes, &message{}, receiver); err != nil {
+			t.Fatal(err)
+		}
+		sender.Acknowledge(receiver.Acknowledgements()...)
+
+		// the peer upgrades, the new schema has a new hash and goes out in full
+		b.Reset()
+		NewEncoder[messageV2]().MarshalWithSession(&messageV2{ID: 2, Urgent: true}, b, sender)
+
+		var out message
+		if err := dec.UnmarshalWithSession(b.Bytes, &out, receiver); err != nil {
+			t.Fatal(err)
+		}
+		if out.ID != 2 {
+			t.Errorf("unexpected value decoded: %+v", out)
+		}
+		if acks := receiver.Acknowledgements(); len(acks) != 1 {
+			t.Errorf("expected the new schema to be acknowledged, got %v", acks)
+		}
+	})
+
+	t.Run("AppendedDocuments", func(t *testing.T) {
+		sender := NewSchemaSession()
+		enc := NewEncoder[message]()
+
+		b := &Buffer{}
+		enc.Marshal(&message{ID: 1}, b)
+		sender.Acknowledge(binary.LittleEndian.Uint32(b.Bytes[1:5]))
+
+		// a second document written after the first, as a stream of documents would be
+		start := len(b.Bytes)
+		enc.MarshalWithSession(&message{ID: 2}, b, sender)
+
+		dec := NewDecoder[message]()
+		var out message
+		if err := dec.Unmarshal(b.Bytes[:start], &out); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Unmarshal(b.Bytes[start:], &out); err != nil || out.ID != 2 {
+			t.Errorf("expected the appended document to decode, got %v %+v", err, out)
+		}
+	})
+
+	t.Run("Header", func(t *testing.T) {
+		receiver := NewSchemaSession()
+		if _, ok := receiver.TrustHeader(); ok {
+			t.Error("expected no header without acknowledgements")
+		}
+
+		for _, doc := range [][]byte{encodeMessage(t, message{ID: 1}), encodeMessage(t, messageV2{ID: 1})} {
+			receiver.receive(doc)
+		}
+		header, ok := receiver.TrustHeader()
+		if !ok || strings.Count(header.Value(), ",") != 1 {
+			t.Fatalf("expected two acknowledgements in the header, got %q", header.Value())
+		}
+
+		h := http.Header{}
+		h.Set(header.Key(), header.Value())
+
+		sender := NewSchemaSession()
+		sender.AcknowledgeHeader(h)
+		if !sender.Trusts(binary.LittleEndian.Uint32(encodeMessage(t, message{})[1:5])) {
+			t.Error("expected the header to be acknowledged")
+		}
+
+		// received schemas stay in the full header after they've been acknowledged
+		if _, ok := receiver.TrustHeader(); ok {
+			t.Error("expected pending acknowledgements to be handed back once")
+		}
+		if all, ok := receiver.ReceivedHeader(); !ok || strings.Count(all.Value(), ",") != 1 {
+			t.Errorf("expected both received schemas in the header, got %q", all.Value())
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		sender, receiver := NewSchemaSession(), NewSchemaSession()
+		enc, dec := NewEncoder[message](), NewDecoder[message]()
+
+		send := func(v message) int64 {
+			var conn bytes.Buffer
+			n, err := enc.MarshalToWithSession(&conn, &v, sender)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out message
+			if err := dec.UnmarshalFromWithSession(&conn, &out, receiver); err != nil {
+				t.Fatal(err)
+			}
+			if out != v {
+				t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, v)
+			}
+			return n
+		}
+
+		first := send(message{ID: 1, Body: "hello"})
+		sender.Acknowledge(receiver.Acknowledgements()...)
+
+		if trusted := send(message{ID: 2, Body: "hello"}); trusted >= first {
+			t.Errorf("expected the schema to be left out once acknowledged, got %d vs %d bytes", trusted, first)
+		}
+	})
+}
+
+// encodeMessage encodes v with a new encoder for its type
+func encodeMessage[T any](t *testing.T, v T) []byte {
+	t.Helper()
+	b := &Buffer{}
+	NewEncoder[T]().Marshal(&v, b)
+	return b.Bytes
+}
+
+func TestDocumentAccessors(t *testing.T) {
+
+	type point struct {
+		X int `glint:"x"`
+		Y int `glint:"y"`
+	}
+
+	type shape struct {
+		Name   string           `glint:"name"`
+		Points []point          `glint:"points"`
+		Tags   []string         `glint:"tags"`
+		Meta   map[string]int   `glint:"meta"`
+		Origin *point           `glint:"origin"`
+		Spans  map[string]point `glint:"spans"`
+	}
+
+	in := shape{
+		Name:   "square",
+		Points: []point{{0, 0}, {0, 1}, {1, 1}, {1, 0}},
+		Tags:   []string{"a", "b"},
+		Meta:   map[string]int{"sides": 4},
+		Origin: &point{},
+		Spans:  map[string]point{"w": {1, 0}},
+	}
+
+	b := &Buffer{}
+	enc := NewEncoder[shape]()
+	enc.Marshal(&in, b)
+	doc := Document(b.Bytes)
+
+	t.Run("Hash", func(t *testing.T) {
+		if doc.Hash() != crc32.ChecksumIEEE(enc.Schema().Bytes) {
+			t.Errorf("expected the hash of the encoder's schema, got %d", doc.Hash())
+		}
+		if Document(nil).Hash() != 0 {
+			t.Error("expected 0 for an empty document")
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		s, err := doc.Schema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range s.Fields {
+			names = append(names, f.Name)
+		}
+		if want := []string{"name", "points", "tags", "meta", "origin", "spans"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("expected fields %v, got %v", want, names)
+		}
+
+		trusted := &Buffer{TrustedSchema: true}
+		enc.Marshal(&in, trusted)
+		if _, err := Document(trusted.Bytes).Schema(); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected ErrSchemaNotFound, got %v", err)
+		}
+	})
+
+	t.Run("BodyReader", func(t *testing.T) {
+		r, err := doc.BodyReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ReadString() != "square" {
+			t.Error("expected the body to start with the first field")
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		if err := doc.Validate(DefaultLimits); err != nil {
+			t.Fatal(err)
+		}
+
+		truncated := doc[:len(doc)-1]
+		if err := truncated.Validate(DefaultLimits); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for a truncated body, got %v", err)
+		}
+
+		trailing := append(append(Document{}, doc...), 0)
+		if err := trailing.Validate(DefaultLimits); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for trailing bytes, got %v", err)
+		}
+
+		corrupt := append(Document{}, doc...)
+		corrupt[8]++ // within the schema, which no longer matches its checksum
+		if err := corrupt.Validate(DefaultLimits); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for a corrupt schema, got %v", err)
+		}
+
+		limits := DefaultLimits
+		limits.MaxSchemaDepth = 2
+		if err := doc.Validate(limits); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument beyond the schema depth limit, got %v", err)
+		}
+
+		if err := Document((&DocumentBuilder{}).AppendString("a", "b").Bytes()).Validate(DefaultLimits); err != nil {
+			t.Errorf("expected a built document to validate, got %v", err)
+		}
+	})
+}
+
+// recordingVisitor records the callbacks a walk makes, reading past every field
+type recordingVisitor struct {
+	events []string
+	skip   bool // return ErrSkipVisit for every field instead of reading it
+}
+
+func (v *recordingVisitor) VisitFlags(flags byte) error       { return nil }
+func (v *recordingVisitor) VisitSchemaHash(hash []byte) error { return nil }
+func (v *recordingVisitor) VisitArrayStart(name string, wire WireType, length int) error {
+	v.events = append(v.events, "["+name)
+	return nil
+}
+func (v *recordingVisitor) VisitArrayEnd(name string) error {
+	v.events = append(v.events, "]")
+	return nil
+}
+func (v *recordingVisitor) VisitStructStart(name string) error {
+	v.events = append(v.events, "{"+name)
+	return nil
+}
+func (v *recordingVisitor) VisitStructEnd(name string) error {
+	v.events = append(v.events, "}")
+	return nil
+}
+func (v *recordingVisitor) VisitMapStart(name string, key, value WireType, length int) error {
+	v.events = append(v.events, "<"+name)
+	return nil
+}
+func (v *recordingVisitor) VisitMapEnd(name string) error {
+	v.events = append(v.events, ">")
+	return nil
+}
+func (v *recordingVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	if v.skip {
+		v.events = append(v.events, "key")
+		return body, ErrSkipVisit
+	}
+	v.events = append(v.events, "key="+fmt.Sprint(readVisitValue(wire, &body)))
+	return body, nil
+}
+func (v *recordingVisitor) VisitField(name string, wire WireType, body Reader) (Reader, error) {
+	if v.skip {
+		v.events = append(v.events, name)
+		return body, ErrSkipVisit
+	}
+	var value any
+	switch wire {
+	case WireString:
+		value = body.ReadString()
+	case WireInt:
+		value = body.ReadInt()
+	default:
+		value = fieldBytes(&body, wire)
+	}
+	v.events = append(v.events, name+"="+fmt.Sprint(value))
+	return body, nil
+}
+
+func TestVisitorCombinators(t *testing.T) {
+
+	type address struct {
+		City   string `glint:"city"`
+		Street string `glint:"street"`
+	}
+
+	type person struct {
+		Name     string    `glint:"name"`
+		Address  address   `glint:"address"`
+		Previous []address `glint:"previous"`
+		Age      int       `glint:"age"`
+	}
+
+	b := &Buffer{}
+	NewEncoder[person]().Marshal(&person{
+		Name:     "sam",
+		Address:  address{City: "Leeds", Street: "High St"},
+		Previous: []address{{City: "York"}},
+		Age:      40,
+	}, b)
+
+	t.Run("Chain", func(t *testing.T) {
+		first, second, count := &recordingVisitor{}, &recordingVisitor{}, &CountingVisitor{}
+		if err := Walk(b.Bytes, ChainVisitors(first, second, count)); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"name=sam", "{address", "city=Leeds", "street=High St", "}", "[previous", "{", "city=York", "street=", "}", "]", "age=40"}
+		if !reflect.DeepEqual(first.events, want) || !reflect.DeepEqual(second.events, want) {
+			t.Errorf("expected every visitor to see the whole document\n got: %v\n     %v\nwant: %v", first.events, second.events, want)
+		}
+
+		if want := (CountingVisitor{Fields: 6, Structs: 2, Slices: 1, Bytes: 25}); *count != want {
+			t.Errorf("unexpected counts\n got: %+v\nwant: %+v", *count, want)
+		}
+	})
+
+	t.Run("CountingEncodings", func(t *testing.T) {
+		// every field of these is visited whole, so the bytes counted are the whole body
+		type encoded struct {
+			Sparse []int64    `glint:"sparse,sparse"`
+			Delta  []int32    `glint:"delta,delta"`
+			Floats []float64  `glint:"floats,delta"`
+			Bitmap []bool     `glint:"bitmap,bitmap"`
+			Big    *big.Int   `glint:"big"`
+			None   *big.Int   `glint:"none"`
+			Count  *int       `glint:"count"`
+			Tag    string     `glint:"tag,dict"`
+			Since  *time.Time `glint:"since"`
+			Zig    int64      `glint:"zig,zigzag"`
+			After  string     `glint:"after"`
+		}
+		n, since := 3, time.Unix(1700000000, 0)
+		doc := NewEncoder[encoded]().MarshalBytes(&encoded{
+			Sparse: []int64{0, 0, 7, 0, 0, 0, 9},
+			Delta:  []int32{10, 11, 13, 12},
+			Floats: []float64{1.5, 1.75, 2},
+			Bitmap: []bool{true, false, true, true, false, false, false, false, true},
+			Big:    big.NewInt(1 << 40),
+			Count:  &n,
+			Tag:    "blue",
+			Since:  &since,
+			Zig:    -5,
+			After:  "end",
+		})
+
+		count, rec := &CountingVisitor{}, &recordingVisitor{skip: true}
+		if err := Walk(doc, ChainVisitors(count, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.events[len(rec.events)-1]; got != "after" {
+			t.Errorf("expected the walk to stay aligned, got %v", rec.events)
+		}
+
+		body, err := Document(doc).BodyReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count.Fields != 11 || count.Bytes != int(body.BytesLeft()) {
+			t.Errorf("expected 11 fields and %d bytes, got %+v", body.BytesLeft(), *count)
+		}
+
+		// dict string slices are walked element by element, each element a reference to the string table
+		type tags struct {
+			Tags []string `glint:"tags,dict"`
+		}
+		count = &CountingVisitor{}
+		if err := Walk(NewEncoder[tags]().MarshalBytes(&tags{Tags: []string{"a", "b", "a"}}), count); err != nil {
+			t.Fatal(err)
+		}
+		if count.Fields != 3 || count.Slices != 1 || count.Bytes != 3 {
+			t.Errorf("expected 3 one-byte references in a slice, got %+v", *count)
+		}
+	})
+
+	t.Run("ChainSkipped", func(t *testing.T) {
+		// visitors that skip fields stay aligned with the document when chained with one that reads them
+		skipping, reading := &recordingVisitor{skip: true}, &recordingVisitor{}
+		if err := Walk(b.Bytes, ChainVisitors(skipping, reading)); err != nil {
+			t.Fatal(err)
+		}
+		if got := reading.events[len(reading.events)-1]; got != "age=40" {
+			t.Errorf("expected the walk to stay aligned, got %v", reading.events)
+		}
+
+		skipping = &recordingVisitor{skip: true}
+		if err := Walk(b.Bytes, ChainVisitors(skipping, &recordingVisitor{skip: true})); err != nil {
+			t.Fatal(err)
+		}
+		if len(skipping.events) != 12 {
+			t.Errorf("expected every callback when all visitors skip, got %v", skipping.events)
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		filtered, all := &recordingVisitor{}, &recordingVisitor{}
+		noAddresses := func(name string, wire WireType) bool { return name != "address" && name != "previous" }
+
+		if err := Walk(b.Bytes, ChainVisitors(FilterVisitor(noAddresses, filtered), all)); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"name=sam", "age=40"}; !reflect.DeepEqual(filtered.events, want) {
+			t.Errorf("expected rejected structs and slices to be hidden\n got: %v\nwant: %v", filtered.events, want)
+		}
+		if len(all.events) != 12 {
+			t.Errorf("expected the rest of the chain to see everything, got %v", all.events)
+		}
+
+		onlyStrings := func(name string, wire WireType) bool {
+			return wire == WireString || wire == WireStruct || wire&WireSliceFlag > 0
+		}
+		filtered = &recordingVisitor{}
+		if err := Walk(b.Bytes, FilterVisitor(onlyStrings, filtered)); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range filtered.events {
+			if strings.HasPrefix(e, "age") {
+				t.Errorf("expected non-string fields to be filtered, got %v", filtered.events)
+			}
+		}
+	})
+}
+
+func TestRecords(t *testing.T) {
+
+	type event struct {
+		ID   int    `glint:"id"`
+		Kind string `glint:"kind"`
+	}
+
+	enc, dec := NewEncoder[event](), NewDecoder[event]()
+
+	var file bytes.Buffer
+	w := NewRecordWriter(&file)
+	for i := 0; i < 100; i++ {
+		b := &Buffer{}
+		enc.Marshal(&event{ID: i, Kind: strings.Repeat("x", i)}, b)
+		if err := w.WriteRecord(b.Bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records := file.Bytes()
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		r := NewRecordReader(bytes.NewReader(records))
+
+		i := 0
+		for ; r.Next(); i++ {
+			var e event
+			if err := dec.Unmarshal(r.Record(), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.ID != i || len(e.Kind) != i {
+				t.Fatalf("record %d decoded as %+v", i, e)
+			}
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if i != 100 {
+			t.Errorf("expected 100 records, got %d", i)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		r := NewRecordReader(bytes.NewReader(nil))
+		if r.Next() || r.Err() != nil {
+			t.Errorf("expected no records and no error, got %v", r.Err())
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		r := NewRecordReader(bytes.NewReader(records[:len(records)-1]))
+		n := 0
+		for r.Next() {
+			n++
+		}
+		if n != 99 || !errors.Is(r.Err(), io.ErrUnexpectedEOF) {
+			t.Errorf("expected 99 records then io.ErrUnexpectedEOF, got %d and %v", n, r.Err())
+		}
+
+		r = NewRecordReader(bytes.NewReader([]byte{0x80}))
+		if r.Next() || !errors.Is(r.Err(), io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF for a partial length, got %v", r.Err())
+		}
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		r := NewRecordReaderWithLimit(bytes.NewReader(records), 64)
+		n := 0
+		for r.Next() {
+			n++
+		}
+		if n == 0 || n == 100 || !errors.Is(r.Err(), ErrRecordTooLarge) {
+			t.Errorf("expected ErrRecordTooLarge part way through, got %d records and %v", n, r.Err())
+		}
+	})
+}
+
+func TestStreamedDocuments(t *testing.T) {
+
+	type payload struct {
+		Name   string   `glint:"name"`
+		Blocks [][]byte `glint:"blocks"`
+		Count  int      `glint:"count"`
+	}
+
+	in := payload{Name: "big", Blocks: [][]byte{bytes.Repeat([]byte{1}, 4096), {2, 3}}, Count: 2}
+	enc, dec := NewEncoder[payload](), NewDecoder[payload]()
+
+	want := &Buffer{}
+	enc.Marshal(&in, want)
+
+	t.Run("MarshalTo", func(t *testing.T) {
+		var w bytes.Buffer
+		n, err := enc.MarshalTo(&w, &in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(want.Bytes)) || !bytes.Equal(w.Bytes(), want.Bytes) {
+			t.Errorf("expected the same document as Marshal, got %d bytes vs %d", n, len(want.Bytes))
+		}
+
+		registry := NewSchemaRegistry(nil)
+		compact := &Buffer{}
+		enc := NewEncoder[payload](WithSchemaRegistry(registry))
+		enc.Marshal(&in, compact)
+
+		w.Reset()
+		if _, err := enc.MarshalTo(&w, &in); err != nil || !bytes.Equal(w.Bytes(), compact.Bytes) {
+			t.Errorf("expected registry encoders to write only the schema ID, got %v", err)
+		}
+	})
+
+	t.Run("UnmarshalFrom", func(t *testing.T) {
+		var out payload
+		if err := dec.UnmarshalFrom(iotest.OneByteReader(bytes.NewReader(want.Bytes)), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, in)
+		}
+
+		// a pipe, as a connection would be, with the encoder writing to one end
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := enc.MarshalTo(pw, &in)
+			pw.CloseWithError(err)
+		}()
+		out = payload{}
+		if err := dec.UnmarshalFrom(pr, &out); err != nil || !reflect.DeepEqual(out, in) {
+			t.Errorf("expected the piped document to decode, got %v", err)
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		for name, limit := range map[string]func(*DecodeLimits){
+			"document": func(l *DecodeLimits) { l.MaxDocumentSize = 1024 },
+			"schema":   func(l *DecodeLimits) { l.MaxSchemaSize = 8 },
+		} {
+			limits := DefaultLimits
+			limit(&limits)
+
+			var out payload
+			err := NewDecoderWithLimits[payload](limits).UnmarshalFrom(bytes.NewReader(want.Bytes), &out)
+			if !errors.Is(err, ErrInvalidDocument) {
+				t.Errorf("%s: expected ErrInvalidDocument, got %v", name, err)
+			}
+		}
+
+		var out payload
+		if err := dec.UnmarshalFrom(bytes.NewReader(want.Bytes[:3]), &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for a truncated header, got %v", err)
+		}
+	})
+}
+
+func TestDuplicateMapKeys(t *testing.T) {
+
+	type maps struct {
+		Strings map[string]string `glint:"strings"`
+		Ints    map[string]int    `glint:"ints"`
+		Keyed   map[int]string    `glint:"keyed"`
+	}
+
+	b := &Buffer{}
+	NewEncoder[maps]().Marshal(&maps{
+		Strings: map[string]string{"a": "1"},
+		Ints:    map[string]int{"a": 1},
+		Keyed:   map[int]string{1: "x"},
+	}, b)
+
+	// each map holds a single entry, so the second can be appended by copying it with a different value
+	doc := b.Bytes
+	doc = bytes.Replace(doc, []byte{1, 1, 'a', 1, '1'}, []byte{2, 1, 'a', 1, '1', 1, 'a', 1, '2'}, 1)
+	doc = bytes.Replace(doc, []byte{1, 1, 'a', 2}, []byte{2, 1, 'a', 2, 1, 'a', 4}, 1)
+	doc = bytes.Replace(doc, []byte{1, 2, 1, 'x'}, []byte{2, 2, 1, 'x', 2, 1, 'y'}, 1)
+
+	decode := func(policy DuplicateKeyPolicy, out *maps, opts ...DecoderOption) (err error) {
+		defer func() {
+			if rc := recover(); rc != nil {
+				err, _ = rc.(error)
+			}
+		}()
+		return NewDecoder[maps](append(opts, WithDuplicateMapKeys(policy))...).Unmarshal(doc, out)
+	}
+
+	t.Run("LastWins", func(t *testing.T) {
+		var out maps
+		if err := decode(DuplicateKeysLastWins, &out); err != nil {
+			t.Fatal(err)
+		}
+		want := maps{Strings: map[string]string{"a": "2"}, Ints: map[string]int{"a": 2}, Keyed: map[int]string{1: "y"}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("expected the last entries to win\n got: %+v\nwant: %+v", out, want)
+		}
+	})
+
+	t.Run("FirstWins", func(t *testing.T) {
+		var out maps
+		if err := decode(DuplicateKeysFirstWins, &out); err != nil {
+			t.Fatal(err)
+		}
+		want := maps{Strings: map[string]string{"a": "1"}, Ints: map[string]int{"a": 1}, Keyed: map[int]string{1: "x"}}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("expected the first entries to win\n got: %+v\nwant: %+v", out, want)
+		}
+
+		// keys already held by the map before decoding aren't duplicates, they're replaced as usual
+		out = maps{Strings: map[string]string{"a": "0", "b": "0"}, Ints: map[string]int{"a": 0}, Keyed: map[int]string{1: "w"}}
+		if err := decode(DuplicateKeysFirstWins, &out); err != nil {
+			t.Fatal(err)
+		}
+		want.Strings["b"] = "0"
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("expected the first decoded entries to win\n got: %+v\nwant: %+v", out, want)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		var out maps
+		if err := decode(DuplicateKeysError, &out); !errors.Is(err, ErrDuplicateMapKey) {
+			t.Errorf("expected ErrDuplicateMapKey, got %v", err)
+		}
+		if err := decode(DuplicateKeysError, &out); !errors.Is(err, ErrDuplicateMapKey) {
+			t.Errorf("expected ErrDuplicateMapKey decoding into a populated map, got %v", err)
+		}
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		sink := &recordingSink{}
+		for i := 0; i < 2; i++ {
+			if err := decode(DuplicateKeysLastWins, &maps{}, WithDecoderMetrics(sink)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := []string{"glint.maps", "glint.maps", "glint.maps", "glint.maps", "glint.maps", "glint.maps"}; !reflect.DeepEqual(sink.duplicates, want) {
+			t.Errorf("expected 6 duplicates reported, got %v", sink.duplicates)
+		}
+
+		b := &Buffer{}
+		NewEncoder[maps]().Marshal(&maps{Strings: map[string]string{"a": "1", "b": "2"}}, b)
+		sink = &recordingSink{}
+		if err := NewDecoder[maps](WithDecoderMetrics(sink)).Unmarshal(b.Bytes, &maps{}); err != nil || len(sink.duplicates) != 0 {
+			t.Errorf("expected no duplicates reported in a well formed document, got %v %v", err, sink.duplicates)
+		}
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		type nested struct {
+			Items []map[string]string `glint:"items"`
+		}
+		b := &Buffer{}
+		NewEncoder[nested]().Marshal(&nested{Items: []map[string]string{{"a": "1"}}}, b)
+		doc := bytes.Replace(b.Bytes, []byte{1, 1, 'a', 1, '1'}, []byte{2, 1, 'a', 1, '1', 1, 'a', 1, '2'}, 1)
+
+		var out nested
+		if err := NewDecoder[nested](WithDuplicateMapKeys(DuplicateKeysError)).Unmarshal(doc, &out); !errors.Is(err, ErrDuplicateMapKey) {
+			t.Errorf("expected ErrDuplicateMapKey from a map within a slice, got %v", err)
+		}
+	})
+}
+
+type lazyInner struct {
+	Label string  `glint:"label"`
+	Score float32 `glint:"score"`
+}
+
+type lazyRow struct {
+	ID      int               `glint:"id"`
+	Tags    []string          `glint:"tags"`
+	Attrs   map[string]int    `glint:"attrs"`
+	Inner   lazyInner         `glint:"inner"`
+	Items   []lazyInner       `glint:"items"`
+	Small   int8              `glint:"small"`
+	Count   uint16            `glint:"count"`
+	Ratio   float64           `glint:"ratio"`
+	Missing *string           `glint:"missing"`
+	Present *int              `glint:"present"`
+	Parent  *lazyInner        `glint:"parent"`
+	Active  bool              `glint:"active"`
+	Blob    []byte            `glint:"blob"`
+	When    time.Time         `glint:"when"`
+	Name    string            `glint:"name"`
+	Extra   map[string]string `glint:"extra"`
+}
+
+func TestLazyDocument(t *testing.T) {
+
+	seven := 7
+	when := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	row := lazyRow{
+		ID:      42,
+		Tags:    []string{"a", "b"},
+		Attrs:   map[string]int{"x": 1},
+		Inner:   lazyInner{Label: "inner", Score: 1.5},
+		Items:   []lazyInner{{Label: "one"}, {Label: "two"}},
+		Small:   -3,
+		Count:   300,
+		Ratio:   0.25,
+		Present: &seven,
+		Active:  true,
+		Blob:    []byte{1, 2, 3},
+		When:    when,
+		Name:    "last",
+		Extra:   map[string]string{"k": "v"},
+	}
+
+	b := &Buffer{}
+	NewEncoder[lazyRow]().Marshal(&row, b)
+
+	t.Run("Fields", func(t *testing.T) {
+		l, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the last field first, which indexes everything before it
+		if name, err := l.String("name"); err != nil || name != "last" {
+			t.Errorf("expected name %q, got %q %v", "last", name, err)
+		}
+		if id, err := l.Int("id"); err != nil || id != 42 {
+			t.Errorf("expected id 42, got %d %v", id, err)
+		}
+		if small, err := l.Int("small"); err != nil || small != -3 {
+			t.Errorf("expected small -3, got %d %v", small, err)
+		}
+		if count, err := l.Uint("count"); err != nil || count != 300 {
+			t.Errorf("expected count 300, got %d %v", count, err)
+		}
+		if ratio, err := l.Float("ratio"); err != nil || ratio != 0.25 {
+			t.Errorf("expected ratio 0.25, got %v %v", ratio, err)
+		}
+		if active, err := l.Bool("active"); err != nil || !active {
+			t.Errorf("expected active, got %v %v", active, err)
+		}
+		if blob, err := l.Bytes("blob"); err != nil || !bytes.Equal(blob, row.Blob) {
+			t.Errorf("expected blob %v, got %v %v", row.Blob, blob, err)
+		}
+		if w, err := l.Time("when"); err != nil || !w.Equal(when) {
+			t.Errorf("expected when %v, got %v %v", when, w, err)
+		}
+		if present, err := l.Int("present"); err != nil || present != 7 {
+			t.Errorf("expected present 7, got %d %v", present, err)
+		}
+		if missing, err := l.String("missing"); err != nil || missing != "" {
+			t.Errorf("expected an empty string for a nil pointer, got %q %v", missing, err)
+		}
+
+		inner, err := l.Struct("inner")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if score, err := inner.Float("score"); err != nil || score != 1.5 {
+			t.Errorf("expected inner score 1.5, got %v %v", score, err)
+		}
+		if label, err := inner.String("label"); err != nil || label != "inner" {
+			t.Errorf("expected inner label %q, got %q %v", "inner", label, err)
+		}
+
+		if _, err := l.Struct("parent"); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("expected ErrFieldNotFound for a nil struct pointer, got %v", err)
+		}
+
+		wire, raw, err := l.Raw("tags")
+		if err != nil || wire&^WirePtrFlag != WireSliceFlag|WireString || len(raw) == 0 {
+			t.Errorf("expected raw string slice, got %v %v %v", wire, raw, err)
+		}
+
+		names, err := l.Fields()
+		if err != nil || len(names) != 16 || names[0] != "id" || names[15] != "extra" {
+			t.Errorf("unexpected field names %v %v", names, err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		l, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.String("nope"); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("expected ErrFieldNotFound, got %v", err)
+		}
+		if l.Has("nope") || !l.Has("attrs") {
+			t.Error("unexpected Has results")
+		}
+		if _, err := l.Int("name"); err == nil {
+			t.Error("expected an error reading a string as an int")
+		}
+
+		truncated, err := NewLazyDocument(b.Bytes[:len(b.Bytes)-20])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := truncated.Fields(); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for a truncated body, got %v", err)
+		}
+
+		trusted := &Buffer{TrustedSchema: true}
+		NewEncoder[lazyRow]().Marshal(&row, trusted)
+		if _, err := NewLazyDocument(trusted.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected ErrSchemaNotFound for a trusted document, got %v", err)
+		}
+	})
+}
+
+func TestDecoderWithFields(t *testing.T) {
+
+	row := lazyRow{ID: 42, Tags: []string{"a"}, Inner: lazyInner{Label: "inner", Score: 2}, Name: "name", Extra: map[string]string{"k": "v"}}
+	b := &Buffer{}
+	NewEncoder[lazyRow]().Marshal(&row, b)
+
+	t.Run("Selected", func(t *testing.T) {
+		dec := NewDecoderWithFields[lazyRow]("id", "inner", "name", "name")
+
+		for i := 0; i < 2; i++ { // the second decode runs from cached instructions
+			out := lazyRow{Ratio: 0.5}
+			if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			want := lazyRow{ID: 42, Inner: row.Inner, Name: "name", Ratio: 0.5}
+			if !reflect.DeepEqual(out, want) {
+				t.Errorf("expected only the selected fields decoded\n got: %+v\nwant: %+v", out, want)
+			}
+		}
+	})
+
+	t.Run("None", func(t *testing.T) {
+		var out lazyRow
+		if err := NewDecoderWithFields[lazyRow]().Unmarshal(b.Bytes, &out); err != nil || !reflect.DeepEqual(out, lazyRow{}) {
+			t.Errorf("expected nothing decoded, got %+v %v", out, err)
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxSchemaDepth = 1
+		var out lazyRow
+		if err := NewDecoderWithFieldsAndLimits[lazyRow](limits, "id").Unmarshal(b.Bytes, &out); err == nil {
+			t.Error("expected the schema limits to apply to the whole schema")
+		}
+		if err := NewDecoderWithFieldsAndLimits[lazyRow](DefaultLimits, "id").Unmarshal(b.Bytes, &out); err != nil || out.ID != 42 {
+			t.Errorf("expected id decoded, got %+v %v", out, err)
+		}
+	})
+
+	t.Run("UnknownField", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a field the type doesn't have")
+			}
+		}()
+		NewDecoderWithFields[lazyRow]("id", "nope")
+	})
+}
+
+type constrainedAddress struct {
+	Zip string `glint:"zip,pattern=^[0-9]{5}$"`
+}
+
+type constrainedUser struct {
+	Name   string              `glint:"name,pattern=^\\w+$"`
+	Age    int                 `glint:"age,min=0,max=150"`
+	Scores []float64           `glint:"scores,min=0,max=1"`
+	Home   constrainedAddress  `glint:"home"`
+	Work   *constrainedAddress `glint:"work"`
+	Counts map[string]uint8    `glint:"counts,max=10"`
+}
+
+// plainUser reads constrainedUser documents without declaring any constraints of its own
+type plainUser struct {
+	Name   string    `glint:"name"`
+	Age    int       `glint:"age"`
+	Scores []float64 `glint:"scores"`
+	Home   struct {
+		Zip string `glint:"zip"`
+	} `glint:"home"`
+	Work *struct {
+		Zip string `glint:"zip"`
+	} `glint:"work"`
+	Counts map[string]uint8 `glint:"counts"`
+}
+
+func TestConstraints(t *testing.T) {
+
+	valid := constrainedUser{
+		Name: "sam", Age: 30, Scores: []float64{0, 0.5, 1}, Home: constrainedAddress{"12345"},
+		Work: &constrainedAddress{"54321"}, Counts: map[string]uint8{"a": 10},
+	}
+
+	encode := func(u constrainedUser) []byte {
+		b := &Buffer{}
+		NewEncoder[constrainedUser]().Marshal(&u, b)
+		return b.Bytes
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		doc := encode(valid)
+		if v := doc[0] & flagVersionMask; v != formatVersionConstraint {
+			t.Errorf("expected version %d, got %d", formatVersionConstraint, v)
+		}
+
+		var out plainUser
+		if err := NewValidatingDecoder[plainUser]().Unmarshal(doc, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Name != "sam" || out.Work == nil || out.Work.Zip != "54321" || out.Counts["a"] != 10 {
+			t.Errorf("unexpected decode: %+v", out)
+		}
+
+		if mustSPrint(t, doc) == "" {
+			t.Error("expected a printable document")
+		}
+		if err := Document(doc).Validate(DefaultLimits); err != nil {
+			t.Errorf("expected a valid document, got %v", err)
+		}
+	})
+
+	t.Run("Violations", func(t *testing.T) {
+		dec := NewValidatingDecoder[plainUser]()
+
+		for path, u := range map[string]constrainedUser{
+			"name":      {Name: "not a word"},
+			"age":       {Age: 151},
+			"scores[1]": {Scores: []float64{0.5, -0.1}},
+			"home.zip":  {Home: constrainedAddress{"1234"}},
+			"work.zip":  {Home: valid.Home, Work: &constrainedAddress{"abcde"}},
+			"counts[b]": {Counts: map[string]uint8{"b": 11}},
+		} {
+			if path != "name" {
+				u.Name = "sam"
+			}
+			if path != "home.zip" && path != "work.zip" {
+				u.Home = valid.Home
+			}
+
+			var out plainUser
+			err := dec.Unmarshal(encode(u), &out)
+			if !errors.Is(err, ErrConstraintViolation) || !strings.Contains(err.Error(), path+" ") {
+				t.Errorf("%s: expected a constraint violation, got %v", path, err)
+			}
+
+			// decoders that don't validate ignore the constraints
+			if err := NewDecoder[plainUser]().Unmarshal(encode(u), &out); err != nil {
+				t.Errorf("%s: expected a plain decode to succeed, got %v", path, err)
+			}
+		}
+	})
+
+	t.Run("TrustedSchema", func(t *testing.T) {
+		dec := NewValidatingDecoder[constrainedUser]()
+		var out constrainedUser
+		if err := dec.Unmarshal(encode(valid), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		b := &Buffer{TrustedSchema: true}
+		NewEncoder[constrainedUser]().Marshal(&constrainedUser{Name: "sam", Age: -1, Home: valid.Home}, b)
+		if err := dec.Unmarshal(b.Bytes, &out); !errors.Is(err, ErrConstraintViolation) {
+			t.Errorf("expected the constraints of the earlier schema to apply, got %v", err)
+		}
+	})
+
+	t.Run("InvalidTags", func(t *testing.T) {
+		for name, fn := range map[string]func(){
+			"pattern on int": func() {
+				NewEncoder[struct {
+					A int `glint:"a,pattern=x"`
+				}]()
+			},
+			"min on string": func() {
+				NewEncoder[struct {
+					A string `glint:"a,min=1"`
+				}]()
+			},
+			"bad pattern": func() {
+				NewEncoder[struct {
+					A string `glint:"a,pattern=("`
+				}]()
+			},
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s: expected a panic", name)
+					}
+				}()
+				fn()
+			}()
+		}
+	})
+}
+
+type embeddedBase struct {
+	ID      int    `glint:"id"`
+	Created string `glint:"created"`
+}
+
+type embeddedAudit struct {
+	Created string `glint:"created"`
+	By      string `glint:"by"`
+}
+
+type embeddedUser struct {
+	embeddedBase                 // promoted
+	Audit          embeddedAudit `glint:"audit"` // tagged, so nested
+	*embeddedAudit               // pointers aren't promoted
+	Name           string        `glint:"name"`
+}
+
+type embeddedShadow struct {
+	embeddedBase
+	embeddedAudit        // created is repeated at the same depth as embeddedBase's, so neither is encoded
+	ID            string `glint:"id"` // hides embeddedBase.ID
+}
+
+type flatUser struct {
+	ID      int           `glint:"id"`
+	Created string        `glint:"created"`
+	Audit   embeddedAudit `glint:"audit"`
+	Name    string        `glint:"name"`
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+
+	t.Run("Promoted", func(t *testing.T) {
+		in := embeddedUser{embeddedBase: embeddedBase{ID: 1, Created: "today"}, Audit: embeddedAudit{"yesterday", "sam"}, Name: "alex"}
+
+		b := &Buffer{}
+		NewEncoder[embeddedUser]().Marshal(&in, b)
+
+		var out embeddedUser
+		if err := NewDecoder[embeddedUser]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, in)
+		}
+
+		// the promoted fields are written as if they were declared on the struct itself
+		var flat flatUser
+		if err := NewDecoder[flatUser]().Unmarshal(b.Bytes, &flat); err != nil {
+			t.Fatal(err)
+		}
+		if want := (flatUser{1, "today", embeddedAudit{"yesterday", "sam"}, "alex"}); flat != want {
+			t.Errorf("expected a flat document\n got: %+v\nwant: %+v", flat, want)
+		}
+
+		canonical := &Buffer{}
+		NewEncoder[embeddedUser](WithCanonicalEncoding()).Marshal(&in, canonical)
+		flatCanonical := &Buffer{}
+		NewEncoder[flatUser](WithCanonicalEncoding()).Marshal(&flat, flatCanonical)
+		if !bytes.Equal(canonical.Bytes, flatCanonical.Bytes) {
+			t.Error("expected the canonical form of embedded and flat structs to match")
+		}
+	})
+
+	t.Run("Shadowed", func(t *testing.T) {
+		in := embeddedShadow{embeddedBase{ID: 1, Created: "a"}, embeddedAudit{Created: "b", By: "sam"}, "outer"}
+
+		b := &Buffer{}
+		NewEncoder[embeddedShadow]().Marshal(&in, b)
+
+		schema, err := Document(b.Bytes).Schema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range schema.Fields {
+			names = append(names, f.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"by", "id"}) {
+			t.Errorf("expected only the unambiguous fields, got %v", names)
+		}
+
+		var out embeddedShadow
+		if err := NewDecoder[embeddedShadow]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.ID != "outer" || out.By != "sam" || out.embeddedBase.ID != 0 || out.embeddedBase.Created != "" {
+			t.Errorf("unexpected decode: %+v", out)
+		}
+	})
+}
+
+func TestInternMapKeys(t *testing.T) {
+	type prefs struct {
+		Strings map[string]string  `glint:"strings"`
+		Ints    map[string]int     `glint:"ints"`
+		Floats  map[string]float64 `glint:"floats"`
+	}
+
+	in := prefs{
+		Strings: map[string]string{"theme": "dark"},
+		Ints:    map[string]int{"theme": 1},
+		Floats:  map[string]float64{"theme": 1.5},
+	}
+
+	limits := DefaultLimits
+	limits.InternMapKeys = NewStringInterner(0)
+	dec := NewDecoderWithLimits[prefs](limits)
+
+	keyData := func(m any) *byte {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			return unsafe.StringData(k.String())
+		}
+		return nil
+	}
+
+	var first *byte
+	for i := 0; i < 2; i++ {
+		b := &Buffer{}
+		NewEncoder[prefs]().Marshal(&in, b)
+
+		var out prefs
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		// reusing the document's bytes leaves interned keys alone
+		for i := range b.Bytes {
+			b.Bytes[i] = 'x'
+		}
+		if _, ok := out.Strings["theme"]; !ok || out.Ints["theme"] != 1 || out.Floats["theme"] != 1.5 {
+			t.Fatalf("expected interned keys to survive the document changing, got %+v", out)
+		}
+
+		if first == nil {
+			first = keyData(out.Strings)
+		}
+		for _, m := range []any{out.Strings, out.Ints, out.Floats} {
+			if keyData(m) != first {
+				t.Errorf("expected every map to share the interned key")
+			}
+		}
+	}
+
+	if n := limits.InternMapKeys.Len(); n != 1 {
+		t.Errorf("expected 1 interned string, got %d", n)
+	}
+
+	full := NewStringInterner(1)
+	full.Intern("a")
+	if s := full.Intern("b"); s != "b" || full.Len() != 1 {
+		t.Errorf("expected a full interner to copy without holding, got %q with %d held", s, full.Len())
+	}
+}
+
+func TestDefaultValues(t *testing.T) {
+
+	type configV1 struct {
+		Name    string `glint:"name"`
+		Retries int    `glint:"retries"`
+	}
+
+	type configV2 struct {
+		Name    string        `glint:"name"`
+		Retries int           `glint:"retries,default=3"`
+		Timeout time.Duration `glint:"timeout,default=1m30s"`
+		Ratio   *float64      `glint:"ratio,default=0.5"`
+		Region  string        `glint:"region,default=eu-west-1"`
+		Debug   bool          `glint:"debug,default=true"`
+		Limit   uint16        `glint:"limit,default=512"`
+	}
+
+	dec := NewDecoder[configV2]()
+
+	t.Run("MissingFields", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[configV1]().Marshal(&configV1{Name: "svc", Retries: 7}, b)
+
+		var ratios []*float64
+		for i := 0; i < 2; i++ { // the second decode uses the cached instructions
+			var out configV2
+			if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			// retries is in the document, so its default isn't used
+			if out.Name != "svc" || out.Retries != 7 || out.Timeout != 90*time.Second || out.Region != "eu-west-1" || !out.Debug || out.Limit != 512 {
+				t.Errorf("unexpected defaults, got %+v", out)
+			}
+			if out.Ratio == nil || *out.Ratio != 0.5 {
+				t.Fatalf("expected a ratio of 0.5, got %v", out.Ratio)
+			}
+			ratios = append(ratios, out.Ratio)
+		}
+
+		if ratios[0] == ratios[1] {
+			t.Errorf("expected each decode to have its own default pointer")
+		}
+	})
+
+	t.Run("PresentFields", func(t *testing.T) {
+		in := configV2{Name: "svc", Region: "", Limit: 0}
+		b := &Buffer{}
+		NewEncoder[configV2]().Marshal(&in, b)
+
+		var out configV2
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected zero values in the document to be kept, got %+v", out)
+		}
+	})
+
+	t.Run("InvalidDefault", func(t *testing.T) {
+		type bad struct {
+			Retries int `glint:"retries,default=many"`
+		}
+
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected an invalid default to panic")
+			}
+		}()
+		NewDecoder[bad]()
+	})
+}
+
+type unexportedInner struct {
+	Label  string `glint:"label"`
+	cached int
+}
+
+type unexportedMirror struct {
+	flag    bool
+	ID      uint8  `glint:"id"`
+	mirror  string // an untagged copy of Name, never encoded
+	Name    string `glint:"name"`
+	pad     [3]uint16
+	Count   int64             `glint:"count"`
+	hidden  int32             `glint:"hidden"` // tagged, so encoded despite being unexported
+	Skipped string            `glint:"-"`
+	Inner   unexportedInner   `glint:"inner"`
+	Items   []unexportedInner `glint:"items"`
+	last    byte
+}
+
+func TestUnexportedFields(t *testing.T) {
+
+	in := unexportedMirror{
+		flag: true, ID: 7, mirror: "copy", Name: "sam", pad: [3]uint16{1, 2, 3}, Count: -42, hidden: 99,
+		Skipped: "gone", Inner: unexportedInner{"in", 1}, Items: []unexportedInner{{"a", 2}, {"b", 3}}, last: 5,
+	}
+
+	t.Run("OffsetsAroundUnexported", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[unexportedMirror]().Marshal(&in, b)
+
+		doc, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := doc.Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"id", "name", "count", "hidden", "inner", "items"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("expected fields %v, got %v", want, names)
+		}
+
+		out := unexportedMirror{mirror: "stale", last: 1}
+		if err := NewDecoder[unexportedMirror]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		// untagged and skipped fields are left alone, the rest land at their own offsets
+		want := unexportedMirror{
+			ID: 7, mirror: "stale", Name: "sam", Count: -42, hidden: 99,
+			Inner: unexportedInner{Label: "in"}, Items: []unexportedInner{{Label: "a"}, {Label: "b"}}, last: 1,
+		}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("expected %+v, got %+v", want, out)
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		type clean struct {
+			Name   string `glint:"name"`
+			secret string `glint:"-"`
+			_      int
+		}
+		NewEncoder[clean](WithStrictUnexportedFields())
+
+		type nested struct {
+			Inner unexportedInner `glint:"inner"`
+		}
+		type embedded struct {
+			unexportedInner
+		}
+
+		for name, build := range map[string]func(){
+			"field":    func() { NewEncoder[unexportedMirror](WithStrictUnexportedFields()) },
+			"nested":   func() { NewEncoder[nested](WithStrictUnexportedFields()) },
+			"embedded": func() { NewEncoder[embedded](WithStrictUnexportedFields()) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s: expected an untagged unexported field to panic", name)
+					}
+				}()
+				build()
+			}()
+		}
+
+		NewEncoder[unexportedMirror]() // without the option, untagged fields are quietly left out
+	})
+}
+
+func TestRequiredFields(t *testing.T) {
+
+	type accountV1 struct {
+		ID string `glint:"id"`
+	}
+
+	type owner struct {
+		Email string `glint:"email,required"`
+	}
+
+	type accountV2 struct {
+		ID      string  `glint:"id,required"`
+		Balance *int64  `glint:"balance,required"`
+		Owner   owner   `glint:"owner"`
+		Notes   string  `glint:"notes"`
+		Owners  []owner `glint:"owners"`
+	}
+
+	dec := NewDecoder[accountV2]()
+
+	t.Run("Present", func(t *testing.T) {
+		b := &Buffer{}
+		in := accountV2{ID: "a1", Owners: []owner{{Email: "x@y"}}} // a nil pointer is still in the schema
+		NewEncoder[accountV2]().Marshal(&in, b)
+
+		var out accountV2
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[accountV1]().Marshal(&accountV1{ID: "a1"}, b)
+
+		for i := 0; i < 2; i++ { // a failed schema isn't cached, so fails every time
+			var out accountV2
+			err := dec.Unmarshal(b.Bytes, &out)
+			if !errors.Is(err, ErrMissingRequiredField) || !strings.Contains(err.Error(), `"balance"`) {
+				t.Fatalf("expected a missing balance error, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		type ownerV1 struct {
+			Name string `glint:"name"`
+		}
+		type accountNested struct {
+			ID      string  `glint:"id"`
+			Balance *int64  `glint:"balance"`
+			Owner   ownerV1 `glint:"owner"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[accountNested]().Marshal(&accountNested{ID: "a1"}, b)
+
+		var out accountV2
+		if err := dec.Unmarshal(b.Bytes, &out); !errors.Is(err, ErrMissingRequiredField) || !strings.Contains(err.Error(), `"email"`) {
+			t.Fatalf("expected a missing email error, got %v", err)
+		}
+	})
+
+	t.Run("NotSelected", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[accountV1]().Marshal(&accountV1{ID: "a1"}, b)
+
+		var out accountV2
+		if err := NewDecoderWithFields[accountV2]("id").Unmarshal(b.Bytes, &out); err != nil || out.ID != "a1" {
+			t.Errorf("expected required fields that weren't selected to be ignored, got %v, %+v", err, out)
+		}
+	})
+}
+
+// glintUUID writes itself as its canonical string form
+type glintUUID [16]byte
+
+func (u *glintUUID) GlintWireType() WireType { return WireString }
+
+func (u *glintUUID) MarshalGlint(b *Buffer) {
+	b.AppendString(fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]))
+}
+
+func (u *glintUUID) UnmarshalGlint(r *Reader) error {
+	s := strings.ReplaceAll(r.ReadString(), "-", "")
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(u) {
+		return fmt.Errorf("invalid uuid %q", s)
+	}
+	copy(u[:], b)
+	return nil
+}
+
+// glintMoney writes itself as a whole number of cents
+type glintMoney struct {
+	units int64
+	cents int64
+}
+
+func (m *glintMoney) GlintWireType() WireType { return WireInt64 }
+func (m *glintMoney) MarshalGlint(b *Buffer)  { b.AppendInt64(m.units*100 + m.cents) }
+
+func (m *glintMoney) UnmarshalGlint(r *Reader) error {
+	v := r.ReadInt64()
+	m.units, m.cents = v/100, v%100
+	return nil
+}
+
+type glintBadWire struct{}
+
+func (glintBadWire) GlintWireType() WireType { return WireStruct }
+func (glintBadWire) MarshalGlint(b *Buffer)  {}
+
+func TestMarshalerInterfaces(t *testing.T) {
+
+	type order struct {
+		ID     glintUUID  `glint:"id"`
+		Parent *glintUUID `glint:"parent"`
+		Total  glintMoney `glint:"total"`
+		Note   string     `glint:"note"`
+	}
+
+	id := glintUUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	in := order{ID: id, Total: glintMoney{12, 50}, Note: "after"}
+
+	b := &Buffer{}
+	NewEncoder[order]().Marshal(&in, b)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, parent := range []*glintUUID{nil, &id} {
+			in := in
+			in.Parent = parent
+
+			b := &Buffer{}
+			NewEncoder[order]().Marshal(&in, b)
+
+			var out order
+			if err := NewDecoder[order]().Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, in) {
+				t.Errorf("expected %+v, got %+v", in, out)
+			}
+		}
+	})
+
+	t.Run("WireTypes", func(t *testing.T) {
+		// the schema carries the types' own wire types, so plain fields decode them too
+		type plainOrder struct {
+			ID    string `glint:"id"`
+			Total int64  `glint:"total"`
+			Note  string `glint:"note"`
+		}
+
+		var out plainOrder
+		if err := NewDecoder[plainOrder]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.ID != "123e4567-e89b-12d3-a456-426614174000" || out.Total != 1250 || out.Note != "after" {
+			t.Errorf("unexpected plain decode %+v", out)
+		}
+
+		doc, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wire, _, _ := doc.Raw("parent"); wire != WireString|WirePtrFlag {
+			t.Errorf("expected parent to be written as a string pointer, got %v", wire)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		type badUUID struct {
+			ID string `glint:"id"`
+		}
+		bb := &Buffer{}
+		NewEncoder[badUUID]().Marshal(&badUUID{ID: "not a uuid"}, bb)
+
+		var out order
+		if err := NewDecoder[order]().Unmarshal(bb.Bytes, &out); err == nil || !strings.Contains(err.Error(), "invalid uuid") {
+			t.Errorf("expected UnmarshalGlint's error to stop decoding, got %v", err)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a composite wire type to panic")
+				}
+			}()
+			type bad struct {
+				V glintBadWire `glint:"v"`
+			}
+			NewEncoder[bad]()
+		}()
+	})
+}
+
+type batchEvent struct {
+	Kind    string `glint:"kind"`
+	Payload []byte `glint:"payload"`
+	Seq     int    `glint:"seq,min=0"`
+}
+
+func TestMapRoots(t *testing.T) {
+
+	batch := map[string][]batchEvent{
+		"tenant-a": {{Kind: "click", Payload: []byte{1}, Seq: 1}, {Kind: "view", Seq: 2}},
+		"tenant-b": {{Kind: "click", Payload: []byte{2, 3}, Seq: 3}},
+		"tenant-c": {},
+	}
+
+	b := &Buffer{}
+	NewEncoder[map[string][]batchEvent](WithDistinctNilSlices()).Marshal(&batch, b)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var out map[string][]batchEvent
+		if err := NewDecoder[map[string][]batchEvent]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, batch) {
+			t.Errorf("expected %+v, got %+v", batch, out)
+		}
+	})
+
+	t.Run("SharedElementSchema", func(t *testing.T) {
+		// the element schema is written once, however many keys there are
+		if n := bytes.Count(b.Bytes, []byte("payload")); n != 1 {
+			t.Errorf("expected the element schema once, found it %d times", n)
+		}
+	})
+
+	t.Run("WrapperStruct", func(t *testing.T) {
+		// a map root is a document with the map as its only field
+		type wrapped struct {
+			Value map[string][]batchEvent `glint:"value"`
+		}
+
+		var out wrapped
+		if err := NewDecoder[wrapped]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out.Value, batch) {
+			t.Errorf("expected %+v, got %+v", batch, out.Value)
+		}
+		if s := mustSPrint(t, b.Bytes); !strings.Contains(s, "tenant-b") || !strings.Contains(s, "value") {
+			t.Errorf("expected the printed document to show the map, got %s", s)
+		}
+	})
+
+	t.Run("StructValues", func(t *testing.T) {
+		in := map[string]batchEvent{"latest": {Kind: "view", Seq: 9}}
+		b := &Buffer{}
+		NewEncoder[map[string]batchEvent](WithDistinctNilSlices()).Marshal(&in, b)
+
+		var out map[string]batchEvent
+		if err := NewValidatingDecoder[map[string]batchEvent]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+
+		in["latest"] = batchEvent{Seq: -1}
+		b.Reset()
+		NewEncoder[map[string]batchEvent]().Marshal(&in, b)
+		if err := NewValidatingDecoder[map[string]batchEvent]().Unmarshal(b.Bytes, &out); !errors.Is(err, ErrConstraintViolation) {
+			t.Errorf("expected a constraint violation, got %v", err)
+		}
+	})
+}
+
+func TestDurations(t *testing.T) {
+
+	type timings struct {
+		Timeout  time.Duration            `glint:"timeout"`
+		Backoff  *time.Duration           `glint:"backoff"`
+		Samples  []time.Duration          `glint:"samples"`
+		Series   []time.Duration          `glint:"series,delta"`
+		PerRoute map[string]time.Duration `glint:"per_route"`
+	}
+
+	backoff := 250 * time.Millisecond
+	in := timings{
+		Timeout:  30 * time.Second,
+		Backoff:  &backoff,
+		Samples:  []time.Duration{time.Millisecond, -time.Second, 0},
+		Series:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		PerRoute: map[string]time.Duration{"/api": time.Minute},
+	}
+
+	b := &Buffer{}
+	NewEncoder[timings]().Marshal(&in, b)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var out timings
+		if err := NewDecoder[timings]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		doc, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d, err := doc.Duration("timeout"); err != nil || d != 30*time.Second {
+			t.Errorf("expected a 30s timeout, got %v, %v", d, err)
+		}
+		if wire, _, _ := doc.Raw("samples"); wire&^WirePtrFlag != WireSliceFlag|WireDuration {
+			t.Errorf("expected samples to be a duration slice, got %v", wire)
+		}
+
+		s := mustSPrint(t, b.Bytes)
+		for _, want := range []string{"Duration: timeout", "timeout: 30s", "backoff: 250ms", "[2]: 4s"} {
+			if !strings.Contains(s, want) {
+				t.Errorf("expected the printed document to contain %q, got\n%s", want, s)
+			}
+		}
+	})
+
+	t.Run("Int64Compatible", func(t *testing.T) {
+		// documents written with durations as int64 nanoseconds decode into durations, and the other way around
+		type legacy struct {
+			Timeout  int64            `glint:"timeout"`
+			Backoff  *int64           `glint:"backoff"`
+			Samples  []int64          `glint:"samples"`
+			Series   []int64          `glint:"series,delta"`
+			PerRoute map[string]int64 `glint:"per_route"`
+		}
+
+		var old legacy
+		if err := NewDecoder[legacy]().Unmarshal(b.Bytes, &old); err != nil {
+			t.Fatal(err)
+		}
+		if old.Timeout != int64(30*time.Second) || *old.Backoff != int64(backoff) || old.Series[2] != int64(4*time.Second) {
+			t.Errorf("unexpected int64 decode %+v", old)
+		}
+
+		lb := &Buffer{}
+		NewEncoder[legacy]().Marshal(&old, lb)
+
+		var out timings
+		if err := NewDecoder[timings]().Unmarshal(lb.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+	})
+
+	t.Run("Dynamic", func(t *testing.T) {
+		for _, v := range []any{time.Hour, []time.Duration{time.Second, time.Minute}} {
+			db := &Buffer{}
+			AppendDynamicValue(v, db)
+			if got := ReadDynamicValue(db.Bytes); !reflect.DeepEqual(got, v) {
+				t.Errorf("expected %v, got %v", v, got)
+			}
+		}
+	})
+}
+
+type traceInner struct {
+	City string `glint:"city"`
+	Zip  int    `glint:"zip"`
+}
+
+type traceOuter struct {
+	Name    string     `glint:"name"`
+	Address traceInner `glint:"address"`
+	Tags    []string   `glint:"tags"`
+	Extra   int        `glint:"extra"`
+}
+
+func TestDecodeTrace(t *testing.T) {
+	in := traceOuter{Name: "sam", Address: traceInner{City: "leeds", Zip: 42}, Tags: []string{"a", "b"}, Extra: 7}
+	b := &Buffer{}
+	NewEncoder[traceOuter]().Marshal(&in, b)
+
+	t.Run("Steps", func(t *testing.T) {
+		type partial struct {
+			Name    string     `glint:"name"`
+			Address traceInner `glint:"address"`
+			Tags    []string   `glint:"tags"`
+		}
+
+		var out partial
+		trace, err := NewDecoder[partial]().UnmarshalWithTrace(b.Bytes, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Name != "sam" || out.Address.City != "leeds" || len(out.Tags) != 2 {
+			t.Errorf("unexpected decode %+v", out)
+		}
+
+		want := []struct {
+			name, path string
+			wire       WireType
+		}{
+			{"name", "name", WireString},
+			{"city", "address.city", WireString},
+			{"zip", "address.zip", WireInt},
+			{"tags", "tags", WireSliceFlag | WireString},
+			{"extra", "", WireInt},
+		}
+		if len(trace) != len(want) {
+			t.Fatalf("expected %d steps, got\n%v", len(want), trace)
+		}
+
+		offset := trace[0].Offset
+		for i, w := range want {
+			s := trace[i]
+			if s.Name != w.name || s.Path != w.path || s.Wire != w.wire || s.Offset != offset || s.Length <= 0 {
+				t.Errorf("step %d: expected %+v at offset %d, got %+v", i, w, offset, s)
+			}
+			offset += s.Length
+		}
+		if offset != len(b.Bytes) {
+			t.Errorf("expected the trace to cover the body, ending at %d, got %d", len(b.Bytes), offset)
+		}
+		if s := trace.String(); !strings.Contains(s, "address.city") || !strings.Contains(s, "(skipped)") {
+			t.Errorf("unexpected trace table\n%s", s)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		var out traceOuter
+		trace, err := NewDecoder[traceOuter]().UnmarshalWithTrace(b.Bytes, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// claim the city is longer than the rest of the document
+		doc := append([]byte{}, b.Bytes...)
+		doc[trace[1].Offset] = 0x7f
+
+		trace, err = NewDecoder[traceOuter]().UnmarshalWithTrace(doc, &out)
+		if !errors.Is(err, ErrInvalidDocument) || !strings.Contains(err.Error(), `"city"`) {
+			t.Fatalf("expected ErrInvalidDocument naming the city, got %v", err)
+		}
+		if len(trace) != 1 || trace[0].Path != "name" {
+			t.Errorf("expected a trace up to the city, got\n%v", trace)
+		}
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		type withDefault struct {
+			Name  string `glint:"name"`
+			Level int    `glint:"level,default=3"`
+		}
+
+		var out withDefault
+		trace, err := NewDecoder[withDefault]().UnmarshalWithTrace(b.Bytes, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := trace[len(trace)-1]
+		if out.Level != 3 || last.Path != "level" || last.Wire != 0 || last.Length != 0 {
+			t.Errorf("expected the default to be traced last, got %+v\n%v", out, trace)
+		}
+	})
+}
+
+type flowRecord struct {
+	Source  net.IP        `glint:"source"`
+	Dest    netip.Addr    `glint:"dest"`
+	Subnet  net.IPNet     `glint:"subnet"`
+	Route   netip.Prefix  `glint:"route"`
+	Gateway *netip.Addr   `glint:"gateway"`
+	Peer    net.IP        `glint:"peer"`
+	Block   *netip.Prefix `glint:"block"`
+}
+
+func TestNetworkAddresses(t *testing.T) {
+	gateway := netip.MustParseAddr("fe80::1")
+	_, subnet, _ := net.ParseCIDR("10.1.0.0/16")
+
+	in := flowRecord{
+		Source:  net.ParseIP("192.168.1.10"), // held in its 16 byte form
+		Dest:    netip.MustParseAddr("2001:db8::8a2e:370:7334"),
+		Subnet:  *subnet,
+		Route:   netip.MustParsePrefix("2001:db8::/32"),
+		Gateway: &gateway,
+	}
+
+	b := &Buffer{}
+	NewEncoder[flowRecord]().Marshal(&in, b)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var out flowRecord
+		if err := NewDecoder[flowRecord]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !out.Source.Equal(in.Source) || out.Dest != in.Dest || out.Route != in.Route || *out.Gateway != gateway {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+		if out.Subnet.String() != "10.1.0.0/16" || out.Peer != nil || out.Block != nil {
+			t.Errorf("unexpected subnet %v, peer %v or block %v", out.Subnet, out.Peer, out.Block)
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		doc, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string]int{"source": 4, "dest": 16, "subnet": 5, "route": 17, "peer": 0} {
+			wire, value, _ := doc.Raw(name)
+			r := NewReader(value)
+			if l := int(r.ReadVarint()); l != want {
+				t.Errorf("expected %s (%v) to be written as %d bytes, got %d", name, wire, want, l)
+			}
+		}
+		if addr, err := doc.Addr("source"); err != nil || addr != netip.MustParseAddr("192.168.1.10") {
+			t.Errorf("expected the source address, got %v, %v", addr, err)
+		}
+		if p, err := doc.Prefix("route"); err != nil || p != in.Route {
+			t.Errorf("expected the route prefix, got %v, %v", p, err)
+		}
+	})
+
+	t.Run("Print", func(t *testing.T) {
+		s := mustSPrint(t, b.Bytes)
+		for _, want := range []string{"IP: source", "source: 192.168.1.10", "dest: 2001:db8::8a2e:370:7334", "IPPrefix: subnet", "subnet: 10.1.0.0/16", "gateway: fe80::1"} {
+			if !strings.Contains(s, want) {
+				t.Errorf("expected the printed document to contain %q, got\n%s", want, s)
+			}
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		type routeOnly struct {
+			Route netip.Prefix `glint:"route"`
+		}
+		var out routeOnly
+		if err := NewDecoder[routeOnly]().Unmarshal(b.Bytes, &out); err != nil || out.Route != in.Route {
+			t.Errorf("expected %v, got %v, %v", in.Route, out.Route, err)
+		}
+	})
+}
+
+type wideRecord struct {
+	CustomerIdentifier string  `glint:"customer_identifier"`
+	BillingPostalCode  string  `glint:"billing_postal_code"`
+	LifetimeOrderCount int     `glint:"lifetime_order_count"`
+	AverageBasketValue float64 `glint:"average_basket_value"`
+	Region             string  `glint:"region"`
+}
+
+func TestNameHashes(t *testing.T) {
+	in := wideRecord{CustomerIdentifier: "c-1", BillingPostalCode: "LS1", LifetimeOrderCount: 12, AverageBasketValue: 31.5, Region: "north"}
+
+	plain, hashed := &Buffer{}, &Buffer{}
+	NewEncoder[wideRecord]().Marshal(&in, plain)
+	enc := NewEncoder[wideRecord](WithNameHashes())
+	enc.Marshal(&in, hashed)
+
+	t.Run("Layout", func(t *testing.T) {
+		if v := hashed.Bytes[0] & flagVersionMask; v != formatVersionNameHashes {
+			t.Errorf("expected format version %d, got %d", formatVersionNameHashes, v)
+		}
+		if extra := len(hashed.Bytes) - len(plain.Bytes); extra != 1+5*8 {
+			t.Errorf("expected a length and 5 hashes to be added, got %d extra bytes", extra)
+		}
+		if !bytes.Equal(enc.Schema().Bytes, NewEncoder[wideRecord]().Schema().Bytes) {
+			t.Error("expected the name hashes to be left out of Schema")
+		}
+
+		p, err := splitDocument(hashed.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := binary.LittleEndian.Uint64(p.names.Remaining()); h != nameHash("customer_identifier") {
+			t.Errorf("expected the first hash to be of the first field, got %x", h)
+		}
+		if !bytes.Equal(p.body.Remaining(), plain.Bytes[len(plain.Bytes)-int(p.body.BytesLeft()):]) {
+			t.Error("expected the body to be unchanged")
+		}
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		var out wideRecord
+		if err := NewDecoder[wideRecord]().Unmarshal(hashed.Bytes, &out); err != nil || out != in {
+			t.Errorf("expected %+v, got %+v, %v", in, out, err)
+		}
+
+		type partial struct {
+			AverageBasketValue float64 `glint:"average_basket_value"`
+			Region             string  `glint:"region"`
+		}
+		var p partial
+		if err := NewDecoder[partial]().Unmarshal(hashed.Bytes, &p); err != nil || p.AverageBasketValue != 31.5 || p.Region != "north" {
+			t.Errorf("unexpected partial decode %+v, %v", p, err)
+		}
+
+		if s := mustSPrint(t, hashed.Bytes); !strings.Contains(s, "lifetime_order_count: 12") {
+			t.Errorf("expected the document to print, got\n%s", s)
+		}
+		if n, err := NewLazyDocument(hashed.Bytes); err != nil || !n.Has("region") {
+			t.Errorf("expected a lazy document with a region, got %v", err)
+		}
+	})
+
+	t.Run("Collision", func(t *testing.T) {
+		// swap the first two hashes, so each one leads to the other field. Names are always confirmed.
+		doc := append([]byte{}, hashed.Bytes...)
+		p, _ := splitDocument(doc)
+		names := p.names.Remaining()
+		var first [8]byte
+		copy(first[:], names[:8])
+		copy(names[:8], names[8:16])
+		copy(names[8:16], first[:])
+
+		var out wideRecord
+		if err := NewDecoder[wideRecord]().Unmarshal(doc, &out); err != nil || out != in {
+			t.Errorf("expected %+v, got %+v, %v", in, out, err)
+		}
+	})
+
+	t.Run("TrustedSchema", func(t *testing.T) {
+		dec := NewDecoder[wideRecord]()
+		var out wideRecord
+		if err := dec.Unmarshal(hashed.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		b := &Buffer{TrustedSchema: true}
+		enc.Marshal(&in, b)
+		out = wideRecord{}
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil || out != in {
+			t.Errorf("expected %+v, got %+v, %v", in, out, err)
+		}
+	})
+}
+
+type ledgerEntry struct {
+	Balance big.Int  `glint:"balance"`
+	Limit   *big.Int `glint:"limit"`
+	Amount  Decimal  `glint:"amount"`
+	Fee     *Decimal `glint:"fee"`
+	Rebate  *big.Int `glint:"rebate"`
+}
+
+func TestBigNumbers(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	amount, err := ParseDecimal("1234567890123456789.0125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := NewDecimal(-5, -3)
+
+	in := ledgerEntry{Limit: big.NewInt(1 << 40), Amount: amount, Fee: &fee}
+	in.Balance.Set(huge)
+
+	b := &Buffer{}
+	NewEncoder[ledgerEntry]().Marshal(&in, b)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var out ledgerEntry
+		if err := NewDecoder[ledgerEntry]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Balance.Cmp(huge) != 0 || out.Limit.Int64() != 1<<40 || out.Rebate != nil {
+			t.Errorf("unexpected integers %v, %v, %v", &out.Balance, out.Limit, out.Rebate)
+		}
+		if out.Amount.String() != "1234567890123456789.0125" || out.Fee.String() != "-0.005" {
+			t.Errorf("unexpected decimals %v, %v", out.Amount, out.Fee)
+		}
+	})
+
+	t.Run("Print", func(t *testing.T) {
+		s := mustSPrint(t, b.Bytes)
+		for _, want := range []string{"BigInt: balance", "balance: -123456789012345678901234567890", "Decimal: amount", "amount: 1234567890123456789.0125", "fee: -0.005"} {
+			if !strings.Contains(s, want) {
+				t.Errorf("expected the printed document to contain %q, got\n%s", want, s)
+			}
+		}
+	})
+
+	t.Run("Decimal", func(t *testing.T) {
+		for s, want := range map[string]string{"12.50": "12.50", "-0.001": "-0.001", "1.5e-3": "0.0015", "42e2": "4200", "+7": "7", ".5": "0.5"} {
+			d, err := ParseDecimal(s)
+			if err != nil || d.String() != want {
+				t.Errorf("%q: expected %s, got %v, %v", s, want, d, err)
+			}
+		}
+		for _, s := range []string{"", "abc", "1.2.3", "1.-5", "1e", "1e99999999999"} {
+			if _, err := ParseDecimal(s); err == nil {
+				t.Errorf("%q: expected an error", s)
+			}
+		}
+
+		a, _ := ParseDecimal("1.50")
+		if a.Cmp(NewDecimal(15, -1)) != 0 || a.Cmp(NewDecimal(2, 0)) != -1 || (Decimal{}).String() != "0" {
+			t.Error("unexpected decimal comparison")
+		}
+	})
+
+	t.Run("Zero", func(t *testing.T) {
+		doc := (&DocumentBuilder{}).AppendBigInt("n", new(big.Int)).AppendDecimal("d", Decimal{}).Bytes()
+		lazy, err := NewLazyDocument(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := lazy.BigInt("n"); err != nil || n.Sign() != 0 {
+			t.Errorf("expected zero, got %v, %v", n, err)
+		}
+		if d, err := lazy.Decimal("d"); err != nil || d.String() != "0" {
+			t.Errorf("expected zero, got %v, %v", d, err)
+		}
+
+		zb := &Buffer{}
+		AppendDynamicValue(new(big.Int), zb)
+		if got := ReadDynamicValue(zb.Bytes).(*big.Int); got.Sign() != 0 {
+			t.Errorf("expected zero, got %v", got)
+		}
+	})
+}
+
+// inventory has maps keyed by each kind of primitive, and maps holding pointers, byte slices and other maps
+type inventory struct {
+	ByID     map[uint64]string            `glint:"by_id"`
+	ByLevel  map[int8]int                 `glint:"by_level"`
+	ByFlag   map[bool]string              `glint:"by_flag"`
+	Children map[string]*mapChild         `glint:"children"`
+	Counts   map[uint16]*int              `glint:"counts"`
+	Blobs    map[int32][]byte             `glint:"blobs"`
+	Nested   map[bool]map[int8]*mapChild  `glint:"nested"`
+	Tags     map[float64]map[string]int64 `glint:"tags"`
+}
+
+type mapChild struct {
+	Name string `glint:"name"`
+	Qty  int    `glint:"qty"`
+}
+
+func TestMapKeyAndValueTypes(t *testing.T) {
+
+	qty := 7
+	v := inventory{
+		ByID:     map[uint64]string{1 << 62: "big", 0: "zero"},
+		ByLevel:  map[int8]int{-128: 1, 127: -1},
+		ByFlag:   map[bool]string{true: "yes", false: "no"},
+		Children: map[string]*mapChild{"a": {Name: "bolt", Qty: 3}, "gone": nil},
+		Counts:   map[uint16]*int{1: &qty, 2: nil},
+		Blobs:    map[int32][]byte{-1: {1, 2, 3}, 2: {}},
+		Nested:   map[bool]map[int8]*mapChild{true: {4: {Name: "nut"}, 5: nil}},
+		Tags:     map[float64]map[string]int64{1.5: {"x": -9}},
+	}
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		for name, opts := range map[string][]EncoderOption{"default": nil, "canonical": {WithCanonicalEncoding()}} {
+			b := &Buffer{}
+			NewEncoder[inventory](opts...).Marshal(&v, b)
+
+			var out inventory
+			if err := NewDecoder[inventory]().Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !reflect.DeepEqual(out, v) {
+				t.Errorf("%s: roundtrip mismatch\n got: %+v\nwant: %+v", name, out, v)
+			}
+			if p, ok := out.Children["gone"]; !ok || p != nil {
+				t.Errorf("%s: expected the nil value to keep its key", name)
+			}
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		type later struct {
+			After string `glint:"after"`
+		}
+		type withLater struct {
+			inventory
+			After string `glint:"after"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[withLater]().Marshal(&withLater{v, "done"}, b)
+
+		// every map is skipped, leaving the body aligned for the field after them
+		var out later
+		if err := NewDecoder[later]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.After != "done" {
+			t.Errorf("expected the field after the maps to decode, got %+v", out)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		type wrongKey struct {
+			ByID map[int64]string `glint:"by_id"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[inventory]().Marshal(&v, b)
+
+		var out wrongKey
+		if err := NewDecoder[wrongKey]().Unmarshal(b.Bytes, &out); err == nil {
+			t.Error("expected an error decoding uint64 keys into int64 keys")
+		}
+	})
+}
+
+func TestMaxSliceElements(t *testing.T) {
+
+	type cell struct {
+		Values []int32 `glint:"values"`
+	}
+	type grid struct {
+		Rows  [][]int32 `glint:"rows"`
+		Cells []cell    `glint:"cells"`
+		Names []string  `glint:"names"`
+	}
+	type other struct {
+		Names []string `glint:"names"`
+	}
+
+	encode := func(v grid) []byte {
+		b := &Buffer{}
+		NewEncoder[grid]().Marshal(&v, b)
+		return b.Bytes
+	}
+	rejected := func(f func() error) bool {
+		err := f()
+		return errors.Is(err, ErrInvalidDocument) && strings.Contains(err.Error(), "slice length")
+	}
+
+	limits := DefaultLimits
+	limits.MaxSliceElements = 3
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		doc := encode(grid{Rows: [][]int32{{1, 2, 3}}, Cells: []cell{{Values: []int32{1}}}, Names: []string{"a"}})
+
+		var out grid
+		if err := NewDecoderWithLimits[grid](limits).Unmarshal(doc, &out); err != nil {
+			t.Fatalf("expected slices within the limit to decode: %v", err)
+		}
+	})
+
+	t.Run("OverLimit", func(t *testing.T) {
+		for name, v := range map[string]grid{
+			"outer":       {Rows: [][]int32{{}, {}, {}, {}}},
+			"nested":      {Rows: [][]int32{{1, 2, 3, 4}}},
+			"struct elem": {Cells: []cell{{Values: []int32{1, 2, 3, 4}}}},
+			"strings":     {Names: []string{"a", "b", "c", "d"}},
+		} {
+			doc := encode(v)
+
+			if !rejected(func() error { return NewDecoderWithLimits[grid](limits).Unmarshal(doc, &grid{}) }) {
+				t.Errorf("%s: expected a slice over the limit to be rejected", name)
+			}
+
+			// skipped fields are held to the limit too, as they're still read past
+			if name != "strings" && !rejected(func() error { return NewDecoderWithLimits[other](limits).Unmarshal(doc, &other{}) }) {
+				t.Errorf("%s: expected a skipped slice over the limit to be rejected", name)
+			}
+		}
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		b := &Buffer{Bytes: encode(grid{Names: []string{}})}
+		b.Bytes = b.Bytes[:len(b.Bytes)-1] // drop the length
+		b.AppendUint(1 << 40)              // a tiny document claiming a huge slice
+
+		if !rejected(func() error { return NewDecoder[grid]().Unmarshal(b.Bytes, &grid{}) }) {
+			t.Error("expected the default limits to reject the slice")
+		}
+	})
+}
+
+// metricsBatch holds samples by label set, and label sets by sample, the shapes metrics payloads take naturally
+type metricsBatch struct {
+	Series map[string][]float64            `glint:"series"`
+	Labels []map[string]int                `glint:"labels"`
+	Groups map[string][]map[string]float64 `glint:"groups"`
+	Grid   [][]map[uint8]string            `glint:"grid"`
+	Host   string                          `glint:"host"`
+}
+
+func TestMapsOfSlicesAndSlicesOfMaps(t *testing.T) {
+
+	v := metricsBatch{
+		Series: map[string][]float64{"cpu": {0.5, 0.75}, "mem": {}},
+		Labels: []map[string]int{{"a": 1}, {}, {"b": 2, "c": 3}},
+		Groups: map[string][]map[string]float64{"eu": {{"p99": 12.5}}},
+		Grid:   [][]map[uint8]string{{{1: "x"}}, {}},
+		Host:   "web-1",
+	}
+
+	b := &Buffer{}
+	NewEncoder[metricsBatch]().Marshal(&v, b)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		var out metricsBatch
+		if err := NewDecoder[metricsBatch]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, v) {
+			t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, v)
+		}
+
+		// decoding again into the same value replaces the maps in the slice rather than adding to them
+		out.Labels[0]["stale"] = 9
+		if err := NewDecoder[metricsBatch]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := out.Labels[0]["stale"]; ok {
+			t.Error("expected the slice's maps to be replaced")
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		type hostOnly struct {
+			Host string `glint:"host"`
+		}
+
+		var out hostOnly
+		if err := NewDecoder[hostOnly]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Host != "web-1" {
+			t.Errorf("expected the field after the skipped ones to decode, got %+v", out)
+		}
+
+		lazy, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host, err := lazy.String("host"); err != nil || host != "web-1" {
+			t.Errorf("expected the lazy document to skip to the host, got %q, %v", host, err)
+		}
+	})
+
+	t.Run("Print", func(t *testing.T) {
+		output := mustSPrint(t, b.Bytes)
+		for _, want := range []string{"[]Map[String]Int: labels", "{c}: 3", "{p99}: 12.5", "{1}: x", "host: web-1"} {
+			if !strings.Contains(output, want) {
+				t.Errorf("expected %q in the output\n%s", want, output)
+			}
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		schema := SchemaBytes(metricsBatch{})
+		for seed := int64(0); seed < 50; seed++ {
+			doc, err := GenerateDocument(schema, seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out metricsBatch
+			if err := NewDecoder[metricsBatch]().Unmarshal(doc, &out); err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+		}
+	})
+}
+
+type pointerLine struct {
+	SKU string `glint:"sku"`
+	Qty int    `glint:"qty"`
+}
+
+type pointerOrder struct {
+	Lines   []*pointerLine   `glint:"lines"`
+	Batches [][]*pointerLine `glint:"batches"`
+	Note    string           `glint:"note"`
+}
+
+func TestSliceOfStructPointers(t *testing.T) {
+
+	order := pointerOrder{
+		Lines:   []*pointerLine{{SKU: "a", Qty: 1}, nil, {SKU: "c", Qty: 3}},
+		Batches: [][]*pointerLine{{{SKU: "d", Qty: 4}}, {nil}, {}},
+		Note:    "fragile",
+	}
+
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+	NewEncoder[pointerOrder]().Marshal(&order, b)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		var out pointerOrder
+		if err := NewDecoder[pointerOrder]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, order) {
+			t.Errorf("expected %+v, got %+v", order, out)
+		}
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		existing := &pointerLine{SKU: "old", Qty: 9}
+		out := pointerOrder{Lines: []*pointerLine{existing, {SKU: "gone"}, {SKU: "gone"}, {SKU: "extra"}}}
+
+		if err := NewDecoder[pointerOrder]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Lines) != 3 || out.Lines[0] != existing || *existing != *order.Lines[0] {
+			t.Errorf("expected the first element to be decoded into, got %+v", out.Lines)
+		}
+		if out.Lines[1] != nil {
+			t.Errorf("expected the nil element to be nil, got %+v", out.Lines[1])
+		}
+	})
+
+	t.Run("NestedStructs", func(t *testing.T) {
+		type grid struct {
+			Rows [][]pointerLine `glint:"rows"`
+		}
+
+		in := grid{Rows: [][]pointerLine{{{SKU: "a", Qty: 1}, {SKU: "b", Qty: 2}, {SKU: "c", Qty: 3}}, {{SKU: "d", Qty: 4}}}}
+		b := NewBufferFromPool()
+		defer b.ReturnToPool()
+		NewEncoder[grid]().Marshal(&in, b)
+
+		var out grid
+		if err := NewDecoder[grid]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		type values struct {
+			Lines   []pointerLine   `glint:"lines"`
+			Batches [][]pointerLine `glint:"batches"`
+		}
+
+		var out values
+		if err := NewDecoder[values]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		want := values{
+			Lines:   []pointerLine{{SKU: "a", Qty: 1}, {}, {SKU: "c", Qty: 3}},
+			Batches: [][]pointerLine{{{SKU: "d", Qty: 4}}, {{}}, {}},
+		}
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("expected nil elements to decode as zero values, got %+v", out)
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		var out struct {
+			Note string `glint:"note"`
+		}
+		if err := NewDecoder[struct {
+			Note string `glint:"note"`
+		}]().Unmarshal(b.Bytes, &out); err != nil || out.Note != "fragile" {
+			t.Errorf("expected the slices to be skipped, got %+v, %v", out, err)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		if version, _ := FormatVersion(b.Bytes); version != formatVersionPointers {
+			t.Errorf("expected format version %d, got %d", formatVersionPointers, version)
+		}
+
+		v := &testVisitor{fields: map[string]any{}}
+		if err := Walk(b.Bytes, DecodingVisitor(v)); err != nil || v.fields["note"] != "fragile" || v.fields["sku"] != "d" {
+			t.Errorf("expected Walk to read past the nil elements, got %v %v", v.fields, err)
+		}
+		if s := mustSPrint(t, b.Bytes); !strings.Contains(s, "fragile") || !strings.Contains(s, "nil") {
+			t.Errorf("expected SPrint to print every element, got %s", s)
+		}
+
+		data, err := DocumentTemplateData(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := data.(map[string]any)["lines"].([]any); len(lines) != 3 || lines[1] != nil {
+			t.Errorf("expected the nil element in the template data, got %v", lines)
+		}
+
+		merged, err := Merge(b.Bytes, b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out pointerOrder
+		if err := NewDecoder[pointerOrder]().Unmarshal(merged, &out); err != nil || !reflect.DeepEqual(out, order) {
+			t.Errorf("expected the merged document to decode, got %+v, %v", out, err)
+		}
+
+		generated, err := GenerateDocument(b.Bytes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewDecoder[pointerOrder]().Unmarshal(generated, &out); err != nil {
+			t.Errorf("expected the generated document to decode, got %v", err)
+		}
+	})
+
+	t.Run("Version3", func(t *testing.T) {
+		// written as a slice of structs, with nothing marking the presence bytes, which only slices of pointers read
+		doc := NewEncoder[pointerOrder](WithFormatVersion(formatVersionNameHashes)).MarshalBytes(&order)
+
+		var out pointerOrder
+		if err := NewDecoder[pointerOrder]().Unmarshal(doc, &out); err != nil || !reflect.DeepEqual(out, order) {
+			t.Errorf("expected %+v, got %+v, %v", order, out, err)
+		}
+	})
+}
+
+type containerSource struct {
+	File string `glint:"file"`
+	Line int    `glint:"line"`
+}
+
+type containerEntry struct {
+	Service string            `glint:"service"`
+	Level   string            `glint:"level"`
+	Message string            `glint:"message"`
+	Tags    []string          `glint:"tags"`
+	Attrs   map[string]string `glint:"attrs"`
+	Host    *string           `glint:"host"`
+	Payload []byte            `glint:"payload"`
+	Samples []int             `glint:"samples,delta"`
+	Counts  []uint32          `glint:"counts,sparse"`
+	Source  containerSource   `glint:"source"`
+	Callers []containerSource `glint:"callers"`
+}
+
+type containerMetric struct {
+	Name  string  `glint:"name"`
+	Value float64 `glint:"value"`
+}
+
+func TestContainer(t *testing.T) {
+
+	host := "web-1"
+	var docs [][]byte
+	for i := 0; i < 200; i++ {
+		b := NewBufferFromPool()
+		if i%10 == 9 {
+			NewEncoder[containerMetric]().Marshal(&containerMetric{Name: "requests", Value: float64(i)}, b)
+		} else {
+			entry := containerEntry{
+				Service: "checkout",
+				Level:   []string{"info", "warn", "error"}[i%3],
+				Message: fmt.Sprintf("request %d served", i),
+				Tags:    []string{"http", "v2"},
+				Attrs:   map[string]string{"region": "eu-west-1", "method": "GET"},
+				Payload: []byte{byte(i), 1, 2},
+				Samples: []int{i, i + 1, i + 3},
+				Counts:  make([]uint32, 16),
+				Source:  containerSource{File: "handler.go", Line: 42},
+				Callers: []containerSource{{File: "router.go", Line: i}},
+			}
+			entry.Counts[i%16] = uint32(i)
+			if i%2 == 0 {
+				entry.Host = &host
+			}
+			NewEncoder[containerEntry]().Marshal(&entry, b)
+		}
+		docs = append(docs, append([]byte{}, b.Bytes...))
+		b.ReturnToPool()
+	}
+
+	write := func(t *testing.T, w *ContainerWriter, docs [][]byte) {
+		t.Helper()
+		for _, doc := range docs {
+			if err := w.Write(doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var shared, full bytes.Buffer
+	write(t, NewContainerWriter(&shared), docs)
+	write(t, NewContainerWriterWithDictionaryLimit(&full, 0), docs)
+
+	t.Run("RandomAccess", func(t *testing.T) {
+		for _, buf := range []*bytes.Buffer{&shared, &full} {
+			c, err := OpenContainer(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Len() != len(docs) {
+				t.Fatalf("expected %d documents, got %d", len(docs), c.Len())
+			}
+
+			for _, i := range []int{150, 0, 199, 9, 73} {
+				doc, err := c.Document(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(doc, docs[i]) {
+					t.Errorf("document %d doesn't match the one written\n%s", i, mustSPrint(t, doc))
+				}
+			}
+
+			var out containerEntry
+			doc, _ := c.Document(4)
+			if err := NewDecoder[containerEntry]().Unmarshal(doc, &out); err != nil || out.Message != "request 4 served" || *out.Host != host {
+				t.Errorf("expected the document to decode, got %+v, %v", out, err)
+			}
+		}
+	})
+
+	t.Run("Dictionary", func(t *testing.T) {
+		var records bytes.Buffer
+		rw := NewRecordWriter(&records)
+		for _, doc := range docs {
+			rw.WriteRecord(doc)
+		}
+
+		if shared.Len() >= full.Len() || full.Len() >= records.Len() {
+			t.Errorf("expected shared strings and schemas to shrink the container, got %d bytes shared, %d without a dictionary and %d as records",
+				shared.Len(), full.Len(), records.Len())
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := NewContainerWriter(&buf).Close(); err != nil {
+			t.Fatal(err)
+		}
+		c, err := OpenContainer(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil || c.Len() != 0 {
+			t.Fatalf("expected an empty container, got %v", err)
+		}
+		if _, err := c.Document(0); err == nil {
+			t.Error("expected an error reading past the end")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		b := NewBufferFromPool()
+		defer b.ReturnToPool()
+		NewEncoder[containerMetric]().Marshal(&containerMetric{Name: "x"}, b)
+
+		if err := NewContainerWriter(io.Discard).Write(b.Bytes[:len(b.Bytes)-2]); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected truncated documents to be rejected, got %v", err)
+		}
+
+		b.Reset()
+		b.TrustedSchema = true
+		NewEncoder[containerMetric]().Marshal(&containerMetric{Name: "x"}, b)
+		if err := NewContainerWriter(io.Discard).Write(b.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected documents without a schema to be rejected, got %v", err)
+		}
+
+		data := shared.Bytes()
+		for _, cut := range []int{1, 8, 20, len(data) / 2} {
+			if _, err := OpenContainer(bytes.NewReader(data[:len(data)-cut]), int64(len(data)-cut)); err == nil {
+				t.Errorf("expected a container cut by %d bytes to be rejected", cut)
+			}
+		}
+
+		c, _ := OpenContainer(bytes.NewReader(data), int64(len(data)))
+		corrupt := append([]byte{}, data...)
+		corrupt[c.offsets[3]] = 0x7f // a header that doesn't exist
+		c, _ = OpenContainer(bytes.NewReader(corrupt), int64(len(corrupt)))
+		if _, err := c.Document(3); !errors.Is(err, ErrInvalidContainer) {
+			t.Errorf("expected a reference outside the dictionary to be rejected, got %v", err)
+		}
+	})
+}
+
+type planAddress struct {
+	Street string `glint:"street"`
+	Zip    uint16 `glint:"zip"`
+}
+
+type planUser struct {
+	ID                       int64         `glint:"id"`
+	Name                     string        `glint:"name,required"`
+	Active                   bool          `glint:"active"`
+	Score                    float64       `glint:"score"`
+	Level                    uint8         `glint:"level"`
+	Avatar                   []byte        `glint:"avatar"`
+	Joined                   time.Time     `glint:"joined"`
+	Timeout                  time.Duration `glint:"timeout"`
+	Home                     planAddress   `glint:"home"`
+	LongerFieldNameForLookup int32         `glint:"a_much_longer_field_name_for_the_lookup_map"`
+}
+
+func TestPlans(t *testing.T) {
+
+	in := planUser{
+		ID: 7, Name: "ada", Active: true, Score: 99.5, Level: 3, Avatar: []byte{1, 2, 3},
+		Joined: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Timeout: time.Minute,
+		Home: planAddress{Street: "1 Loop", Zip: 9021}, LongerFieldNameForLookup: -4,
+	}
+
+	reflected := NewBufferFromPool()
+	defer reflected.ReturnToPool()
+	NewEncoder[planUser]().Marshal(&in, reflected)
+
+	t.Run("Encode", func(t *testing.T) {
+		plan, err := NewEncodePlan[planUser]()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := plan.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var loaded EncodePlan
+		if err := loaded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&loaded, plan) {
+			t.Fatalf("expected the plan to survive a roundtrip\n got: %+v\nwant: %+v", loaded, plan)
+		}
+
+		enc, err := NewEncoderFromPlan[planUser](&loaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := NewBufferFromPool()
+		defer b.ReturnToPool()
+		enc.Marshal(&in, b)
+		if !bytes.Equal(b.Bytes, reflected.Bytes) {
+			t.Errorf("expected the same document as the reflected encoder\n got: %v\nwant: %v", b.Bytes, reflected.Bytes)
+		}
+
+		// byte slices written with a presence byte are planned as well
+		plan, err = NewEncodePlan[planUser](WithDistinctNilSlices())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc, err = NewEncoderFromPlan[planUser](plan); err != nil {
+			t.Fatal(err)
+		}
+
+		var nilAvatar planUser
+		b.Reset()
+		enc.Marshal(&nilAvatar, b)
+		var out planUser
+		if err := NewDecoder[planUser]().Unmarshal(b.Bytes, &out); err != nil || out.Avatar != nil {
+			t.Errorf("expected a nil byte slice to stay nil, got %v, %v", out.Avatar, err)
+		}
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		plan, err := NewDecodePlan[planUser]()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := plan.MarshalBinary()
+
+		var loaded DecodePlan
+		if err := loaded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		dec, err := NewDecoderFromPlan[planUser](&loaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out planUser
+		if err := dec.Unmarshal(reflected.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+
+		type withoutName struct {
+			ID int64 `glint:"id"`
+		}
+		b := NewBufferFromPool()
+		defer b.ReturnToPool()
+		NewEncoder[withoutName]().Marshal(&withoutName{ID: 1}, b)
+		if err := dec.Unmarshal(b.Bytes, &out); err == nil {
+			t.Error("expected the required field to be enforced")
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		type tags struct {
+			Tags []string `glint:"tags"`
+		}
+		if _, err := NewEncodePlan[tags](); err == nil {
+			t.Error("expected an encode plan for a slice of strings to be refused")
+		}
+		if _, err := NewDecodePlan[tags](); err == nil {
+			t.Error("expected a decode plan for a slice of strings to be refused")
+		}
+
+		type defaulted struct {
+			Port int `glint:"port,default=80"`
+		}
+		if _, err := NewDecodePlan[defaulted](); err == nil {
+			t.Error("expected a decode plan for a field with a default to be refused")
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		plan, _ := NewEncodePlan[planUser]()
+		if _, err := NewEncoderFromPlan[planAddress](plan); !errors.Is(err, ErrInvalidPlan) {
+			t.Errorf("expected a plan for another type to be refused, got %v", err)
+		}
+
+		moved := *plan
+		moved.Fields = append([]PlanField{}, plan.Fields...)
+		moved.Fields[0].Offset = plan.Size
+		if _, err := NewEncoderFromPlan[planUser](&moved); !errors.Is(err, ErrInvalidPlan) {
+			t.Errorf("expected a field outside the type to be refused, got %v", err)
+		}
+
+		resized := *plan
+		resized.Fields = append([]PlanField{}, plan.Fields...)
+		resized.Fields[1].Wire = WireInt64
+		if _, err := NewEncoderFromPlan[planUser](&resized); !errors.Is(err, ErrInvalidPlan) {
+			t.Errorf("expected a string planned as an int64 to be refused, got %v", err)
+		}
+
+		renamed := *plan
+		renamed.Fields = append([]PlanField{}, plan.Fields...)
+		renamed.Fields[0].Name = "identifier"
+		if _, err := NewEncoderFromPlan[planUser](&renamed); !errors.Is(err, ErrInvalidPlan) {
+			t.Errorf("expected fields that don't match the schema to be refused, got %v", err)
+		}
+
+		data, _ := plan.MarshalBinary()
+		for _, cut := range []int{1, 10, len(data) / 2} {
+			var p EncodePlan
+			if err := p.UnmarshalBinary(data[:len(data)-cut]); !errors.Is(err, ErrInvalidPlan) {
+				t.Errorf("expected a plan cut by %d bytes to be refused, got %v", cut, err)
+			}
+		}
+	})
+}
+
+type arrayPoint struct {
+	X int16 `glint:"x"`
+	Y int16 `glint:"y"`
+}
+
+type arrayFrame struct {
+	ID      [16]byte      `glint:"id"`
+	Samples [1024]float32 `glint:"samples"`
+	Levels  [8]int8       `glint:"levels"`
+	Flags   [3]bool       `glint:"flags"`
+	Names   [2]string     `glint:"names"`
+	Points  [2]arrayPoint `glint:"points"`
+	Offset  *[3]int       `glint:"offset"`
+	Tail    string        `glint:"tail"`
+}
+
+func TestArrays(t *testing.T) {
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		in := arrayFrame{
+			Levels: [8]int8{-128, -1, 0, 1, 127},
+			Flags:  [3]bool{true, false, true},
+			Names:  [2]string{"left", "right"},
+			Points: [2]arrayPoint{{1, 2}, {-3, 4}},
+			Offset: &[3]int{7, -8, 9},
+			Tail:   "end",
+		}
+		for i := range in.ID {
+			in.ID[i] = byte(i * 3)
+		}
+		for i := range in.Samples {
+			in.Samples[i] = float32(i) / 8
+		}
+
+		enc := NewEncoder[arrayFrame]()
+		b := &Buffer{}
+		enc.Marshal(&in, b)
+
+		out := arrayFrame{Names: [2]string{"stale", "stale"}}
+		if err := NewDecoder[arrayFrame]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("expected %+v, got %+v", in, out)
+		}
+
+		in.Offset = nil
+		b.Reset()
+		enc.Marshal(&in, b)
+		out = arrayFrame{}
+		if err := NewDecoder[arrayFrame]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Offset != nil {
+			t.Errorf("expected a nil array pointer, got %v", out.Offset)
+		}
+	})
+
+	t.Run("Lengths", func(t *testing.T) {
+		type short struct {
+			Values [2]int32  `glint:"values"`
+			Names  [1]string `glint:"names"`
+			Tail   string    `glint:"tail"`
+		}
+		type long struct {
+			Values [4]int32  `glint:"values"`
+			Names  [3]string `glint:"names"`
+			Tail   string    `glint:"tail"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[long]().Marshal(&long{Values: [4]int32{1, 2, 3, 4}, Names: [3]string{"a", "b", "c"}, Tail: "x"}, b)
+
+		var s short
+		if err := NewDecoder[short]().Unmarshal(b.Bytes, &s); err != nil {
+			t.Fatal(err)
+		}
+		if want := (short{Values: [2]int32{1, 2}, Names: [1]string{"a"}, Tail: "x"}); s != want {
+			t.Errorf("expected extra elements to be dropped, got %+v", s)
+		}
+
+		b.Reset()
+		NewEncoder[short]().Marshal(&short{Values: [2]int32{5, 6}, Names: [1]string{"d"}, Tail: "y"}, b)
+
+		l := long{Values: [4]int32{9, 9, 9, 9}, Names: [3]string{"z", "z", "z"}}
+		if err := NewDecoder[long]().Unmarshal(b.Bytes, &l); err != nil {
+			t.Fatal(err)
+		}
+		if want := (long{Values: [4]int32{5, 6}, Names: [3]string{"d"}, Tail: "y"}); l != want {
+			t.Errorf("expected missing elements to be zeroed, got %+v", l)
+		}
+	})
+
+	t.Run("Slices", func(t *testing.T) {
+		type asSlice struct {
+			ID     []byte    `glint:"id"`
+			Values []float64 `glint:"values"`
+		}
+		type asArray struct {
+			ID     [4]byte    `glint:"id"`
+			Values [3]float64 `glint:"values"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[asArray]().Marshal(&asArray{ID: [4]byte{1, 2, 3, 4}, Values: [3]float64{0.5, 1.5, 2.5}}, b)
+
+		var sl asSlice
+		if err := NewDecoder[asSlice]().Unmarshal(b.Bytes, &sl); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sl, asSlice{ID: []byte{1, 2, 3, 4}, Values: []float64{0.5, 1.5, 2.5}}) {
+			t.Errorf("expected an array to decode into a slice, got %+v", sl)
+		}
+
+		b.Reset()
+		NewEncoder[asSlice]().Marshal(&asSlice{ID: []byte{5, 6}, Values: []float64{3.5}}, b)
+
+		ar := asArray{ID: [4]byte{9, 9, 9, 9}}
+		if err := NewDecoder[asArray]().Unmarshal(b.Bytes, &ar); err != nil {
+			t.Fatal(err)
+		}
+		if ar != (asArray{ID: [4]byte{5, 6}, Values: [3]float64{3.5}}) {
+			t.Errorf("expected a slice to decode into an array, got %+v", ar)
+		}
+
+		b.Reset()
+		NewEncoder[asSlice]().Marshal(&asSlice{}, b)
+		if err := NewDecoder[asArray]().Unmarshal(b.Bytes, &ar); err != nil {
+			t.Fatal(err)
+		}
+		if ar != (asArray{}) {
+			t.Errorf("expected nil slices to zero the arrays, got %+v", ar)
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		type tailOnly struct {
+			Tail string `glint:"tail"`
+		}
+
+		b := &Buffer{}
+		NewEncoder[arrayFrame]().Marshal(&arrayFrame{Tail: "kept"}, b)
+
+		var out tailOnly
+		if err := NewDecoder[tailOnly]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Tail != "kept" {
+			t.Errorf("expected arrays to be skipped, got %+v", out)
+		}
+	})
+}
+
+type convertOrderV1 struct {
+	ID       string   `glint:"id"`
+	Quantity int      `glint:"quantity"`
+	Price    float64  `glint:"price"`
+	Notes    []string `glint:"notes"`
+	Legacy   bool     `glint:"legacy"`
+}
+
+type convertOrderV2 struct {
+	Currency string   `glint:"currency,default=GBP"`
+	Price    float64  `glint:"price"`
+	ID       string   `glint:"id"`
+	Notes    []string `glint:"notes"`
+	Quantity int      `glint:"quantity"`
+	Shipped  bool     `glint:"shipped"`
+}
+
+func TestConvert(t *testing.T) {
+
+	t.Run("Evolved", func(t *testing.T) {
+		in := convertOrderV1{ID: "o-1", Quantity: 3, Price: 9.99, Notes: []string{"gift"}, Legacy: true}
+
+		out, err := Convert[convertOrderV1, convertOrderV2](&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := convertOrderV2{Currency: "GBP", Price: 9.99, ID: "o-1", Notes: []string{"gift"}, Quantity: 3}
+		if !reflect.DeepEqual(*out, want) {
+			t.Errorf("expected %+v, got %+v", want, *out)
+		}
+
+		in.Notes[0] = "changed"
+		if out.Notes[0] != "gift" {
+			t.Error("expected the converted value not to share memory with the source")
+		}
+
+		back, err := Convert[convertOrderV2, convertOrderV1](out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*back, convertOrderV1{ID: "o-1", Quantity: 3, Price: 9.99, Notes: []string{"gift"}}) {
+			t.Errorf("expected the conversion back to drop the fields v1 doesn't have, got %+v", *back)
+		}
+	})
+
+	t.Run("Converter", func(t *testing.T) {
+		c := NewConverter[convertOrderV1, convertOrderV2]()
+
+		var out convertOrderV2
+		for i := 0; i < 3; i++ {
+			if err := c.Convert(&convertOrderV1{ID: fmt.Sprint("o-", i), Quantity: i}, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.ID != fmt.Sprint("o-", i) || out.Quantity != i {
+				t.Errorf("conversion %d: got %+v", i, out)
+			}
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		out, err := Convert[convertOrderV1, convertOrderV2](nil)
+		if out != nil || err != nil {
+			t.Errorf("expected nil to convert to nil, got %v, %v", out, err)
+		}
+	})
+
+	t.Run("Incompatible", func(t *testing.T) {
+		type quantityText struct {
+			Quantity string `glint:"quantity"`
+		}
+		if _, err := Convert[convertOrderV1, quantityText](&convertOrderV1{Quantity: 1}); err == nil {
+			t.Error("expected an int field to be refused by a string field of the same name")
+		}
+	})
+}
+
+type shadowAccount struct {
+	ID      string            `glint:"id" json:"id"`
+	Balance float64           `glint:"balance" json:"balance"`
+	Opened  time.Time         `glint:"opened" json:"opened"`
+	Tags    []string          `glint:"tags" json:"tags"`
+	Limits  map[string]uint32 `glint:"limits" json:"limits"`
+	Owner   *shadowOwner      `glint:"owner" json:"owner"`
+}
+
+type shadowOwner struct {
+	Name string `glint:"name" json:"name"`
+}
+
+func TestShadowCodec(t *testing.T) {
+	account := shadowAccount{
+		ID:      "acc-1",
+		Balance: 120.5,
+		Opened:  time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600)),
+		Tags:    []string{"gold"},
+		Limits:  map[string]uint32{"daily": 500},
+		Owner:   &shadowOwner{Name: "Sam"},
+	}
+
+	for _, primary := range []ShadowPrimary{PrimaryJSON, PrimaryGlint} {
+		var divergences []ShadowDivergence[shadowAccount]
+		codec := NewShadowCodec(primary, func(d ShadowDivergence[shadowAccount]) {
+			divergences = append(divergences, d)
+		})
+
+		data, err := codec.Marshal(&account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if json.Valid(data) != (primary == PrimaryJSON) {
+			t.Errorf("primary %v: expected to be served by the primary codec, got %q", primary, data)
+		}
+
+		var out shadowAccount
+		if err := codec.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out.Tags, account.Tags) || out.Owner.Name != "Sam" || !out.Opened.Equal(account.Opened) {
+			t.Errorf("primary %v: expected the value back, got %+v", primary, out)
+		}
+		if len(divergences) > 0 {
+			t.Errorf("primary %v: expected the codecs to agree, got %+v", primary, divergences)
+		}
+
+		if err := codec.Unmarshal([]byte("not a document"), &out); err == nil {
+			t.Errorf("primary %v: expected the primary's error to be returned", primary)
+		}
+		if len(divergences) > 0 {
+			t.Errorf("primary %v: expected no shadowing of a value the primary couldn't read", primary)
+		}
+	}
+
+	t.Run("Fields", func(t *testing.T) {
+		type partial struct {
+			ID       string `glint:"id" json:"id"`
+			Nickname string `json:"nickname"` // not tagged for glint
+		}
+
+		var got []ShadowDivergence[partial]
+		codec := NewShadowCodec(PrimaryJSON, func(d ShadowDivergence[partial]) { got = append(got, d) })
+		if _, err := codec.Marshal(&partial{ID: "p", Nickname: "pip"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 1 || got[0].Op != "marshal" || !reflect.DeepEqual(got[0].Fields, []string{"Nickname"}) {
+			t.Fatalf("expected a divergence on the untagged field, got %+v", got)
+		}
+		if got[0].JSON.Nickname != "pip" || got[0].Glint.Nickname != "" || got[0].Glint.ID != "p" {
+			t.Errorf("expected the values from both codecs, got %+v and %+v", *got[0].JSON, *got[0].Glint)
+		}
+	})
+
+	t.Run("ShadowError", func(t *testing.T) {
+		var got []ShadowDivergence[shadowAccount]
+		codec := NewShadowCodec(PrimaryGlint, func(d ShadowDivergence[shadowAccount]) { got = append(got, d) })
+
+		nan := shadowAccount{Balance: math.NaN()} // encoding/json refuses NaNs, glint doesn't
+		if _, err := codec.Marshal(&nan); err != nil {
+			t.Fatalf("expected the shadow's failure not to reach the caller, got %v", err)
+		}
+		if len(got) != 1 || got[0].Err == nil || got[0].JSON != nil || got[0].Glint == nil {
+			t.Errorf("expected the shadow's error to be reported, got %+v", got)
+		}
+	})
+}
+
+type stepScene struct {
+	Name     string          `glint:"name"`
+	Frame    uint32          `glint:"frame"`
+	Camera   stepCamera      `glint:"camera"`
+	Entities []stepCamera    `glint:"entities"`
+	Sounds   map[string]bool `glint:"sounds"`
+	Level    int             `glint:"level"`
+}
+
+type stepCamera struct {
+	X    float32 `glint:"x"`
+	Y    float32 `glint:"y"`
+	Zoom float64 `glint:"zoom"`
+}
+
+func TestStepDecoder(t *testing.T) {
+	scene := stepScene{
+		Name:     "intro",
+		Frame:    42,
+		Camera:   stepCamera{X: 1, Y: 2, Zoom: 1.5},
+		Entities: []stepCamera{{X: 3}, {Y: 4}},
+		Sounds:   map[string]bool{"wind": true},
+	}
+	b := &Buffer{}
+	NewEncoder[stepScene]().Marshal(&scene, b)
+	dec := NewDecoder[stepScene]()
+
+	t.Run("Fields", func(t *testing.T) {
+		var out stepScene
+		s, err := dec.Steps(b.Bytes, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		steps := 0
+		for done := false; !done; steps++ {
+			if done, err = s.Step(StepBudget{Fields: 2}); err != nil {
+				t.Fatal(err)
+			}
+			if steps == 0 && (out.Name != "intro" || out.Frame != 42 || out.Camera.X != 0) {
+				t.Errorf("expected only the first two fields after one step, got %+v", out)
+			}
+		}
+
+		// name, frame, the camera's three fields, entities, sounds and level
+		if s.Fields() != 8 || steps != 4 {
+			t.Errorf("expected 8 fields in 4 steps, got %d in %d", s.Fields(), steps)
+		}
+		if !reflect.DeepEqual(out, scene) {
+			t.Errorf("expected %+v, got %+v", scene, out)
+		}
+		if done, err := s.Step(StepBudget{}); !done || err != nil {
+			t.Errorf("expected a finished decode to stay finished, got %v, %v", done, err)
+		}
+	})
+
+	t.Run("Duration", func(t *testing.T) {
+		var out stepScene
+		s, err := dec.Steps(b.Bytes, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		steps := 0
+		for done := false; !done; steps++ {
+			if done, err = s.Step(StepBudget{Duration: time.Nanosecond}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if steps != s.Fields() || out.Sounds["wind"] != true {
+			t.Errorf("expected a spent budget to decode one field per step, got %d fields in %d steps", s.Fields(), steps)
+		}
+	})
+
+	t.Run("Whole", func(t *testing.T) {
+		var out stepScene
+		s, _ := dec.Steps(b.Bytes, &out)
+		if done, err := s.Step(StepBudget{}); !done || err != nil || out.Camera.Zoom != 1.5 {
+			t.Errorf("expected an unlimited budget to finish in one step, got %v, %v, %+v", done, err, out)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		var out stepScene
+		s, err := dec.Steps(b.Bytes[:len(b.Bytes)-4], &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var done bool
+		for err == nil && !done {
+			done, err = s.Step(StepBudget{Fields: 1})
+		}
+		if !errors.Is(err, ErrInvalidDocument) {
+			t.Fatalf("expected a truncated body to fail, got %v", err)
+		}
+		if _, again := s.Step(StepBudget{Fields: 1}); again != err {
+			t.Errorf("expected the same error again, got %v", again)
+		}
+	})
+}
+
+type dictLog struct {
+	Level   string       `glint:"level,dict"`
+	Tags    []string     `glint:"tags,dict"`
+	Zones   [2]string    `glint:"zones,dict"`
+	Source  dictSource   `glint:"source"`
+	Events  []dictSource `glint:"events"`
+	Message string       `glint:"message"`
+}
+
+type dictSource struct {
+	Host string `glint:"host,dict"`
+	Line int    `glint:"line"`
+}
+
+type dictLogPlain struct {
+	Level   string       `glint:"level"`
+	Tags    []string     `glint:"tags"`
+	Zones   [2]string    `glint:"zones"`
+	Source  dictSource   `glint:"source"`
+	Events  []dictSource `glint:"events"`
+	Message string       `glint:"message"`
+}
+
+func TestDictStrings(t *testing.T) {
+	log := dictLog{
+		Level:   "warn",
+		Tags:    []string{"db", "warn", "db"},
+		Zones:   [2]string{"eu-west", "eu-west"},
+		Source:  dictSource{Host: "db-1", Line: 10},
+		Events:  []dictSource{{Host: "db-1", Line: 11}, {Host: "db-2"}, {Host: "db-1"}},
+		Message: "slow query",
+	}
+	enc := NewEncoder[dictLog]()
+	b := &Buffer{}
+	enc.Marshal(&log, b)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		if b.Bytes[0]&flagStringTable == 0 {
+			t.Fatal("expected the document to carry a string table")
+		}
+
+		var out dictLog
+		if err := NewDecoder[dictLog]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, log) {
+			t.Errorf("expected %+v, got %+v", log, out)
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		plain := dictLogPlain(log)
+		for i := 0; i < 50; i++ {
+			plain.Tags = append(plain.Tags, "replication")
+		}
+		log := dictLog(plain)
+
+		pb, db := &Buffer{}, &Buffer{}
+		NewEncoder[dictLogPlain]().Marshal(&plain, pb)
+		enc.Marshal(&log, db)
+		if len(db.Bytes) >= len(pb.Bytes)/2 {
+			t.Errorf("expected repeated strings to be written once, got %d bytes against %d", len(db.Bytes), len(pb.Bytes))
+		}
+	})
+
+	t.Run("Untagged", func(t *testing.T) {
+		var plain dictLogPlain
+		if err := NewDecoder[dictLogPlain]().Unmarshal(b.Bytes, &plain); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dictLog(plain), log) {
+			t.Errorf("expected %+v, got %+v", log, plain)
+		}
+
+		// and inline strings read into tagged fields
+		pb := &Buffer{}
+		NewEncoder[dictLogPlain]().Marshal(&plain, pb)
+		var out dictLog
+		if err := NewDecoder[dictLog]().Unmarshal(pb.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, log) {
+			t.Errorf("expected %+v from inline strings, got %+v", log, out)
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		type partial struct {
+			Events  []dictSource `glint:"events"`
+			Message string       `glint:"message"`
+		}
+
+		var out partial
+		if err := NewDecoder[partial]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Message != log.Message || !reflect.DeepEqual(out.Events, log.Events) {
+			t.Errorf("expected the fields after skipped dict fields, got %+v", out)
+		}
+		if err := Document(b.Bytes).Validate(DefaultLimits); err != nil {
+			t.Errorf("expected the document to validate, got %v", err)
+		}
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		other := dictLog{Level: "info", Source: dictSource{Host: "web-1"}}
+
+		b := &Buffer{}
+		enc.Marshal(&log, b)
+		b.Reset()
+		enc.Marshal(&other, b)
+
+		var out dictLog
+		if err := NewDecoder[dictLog]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Level != "info" || out.Source.Host != "web-1" || len(out.Tags) != 0 {
+			t.Errorf("expected the second document's strings only, got %+v", out)
+		}
+
+		var w bytes.Buffer
+		if _, err := enc.MarshalTo(&w, &other); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.Bytes(), b.Bytes) {
+			t.Errorf("expected MarshalTo to write the same document as Marshal")
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		lazy, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level, err := lazy.String("level"); err != nil || level != "warn" {
+			t.Errorf("expected a lazy level of warn, got %q, %v", level, err)
+		}
+		source, err := lazy.Struct("source")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host, err := source.String("host"); err != nil || host != "db-1" {
+			t.Errorf("expected a lazy host of db-1, got %q, %v", host, err)
+		}
+
+		if s := mustSPrint(t, b.Bytes); !strings.Contains(s, "(dict)String") || !strings.Contains(s, "eu-west") {
+			t.Errorf("expected the printer to show dict strings, got\n%s", s)
+		}
+
+		var buf bytes.Buffer
+		cw := NewContainerWriter(&buf)
+		if err := cw.Write(b.Bytes); err != nil {
+			t.Fatal(err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		c, err := OpenContainer(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc, err := c.Document(0); err != nil || !bytes.Equal(doc, b.Bytes) {
+			t.Errorf("expected the container to return the document unchanged, got %v", err)
+		}
+
+		doc, err := GenerateDocument(enc.impl.schema.Bytes, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out dictLog
+		if err := NewDecoder[dictLog]().Unmarshal(doc, &out); err != nil || out.Level == "" {
+			t.Errorf("expected a generated document to decode, got %+v, %v", out, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		doc := append([]byte{}, b.Bytes...)
+		doc[len(doc)-4] = 0xff // table size
+		if err := NewDecoder[dictLog]().Unmarshal(doc, new(dictLog)); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected an oversized table to fail, got %v", err)
+		}
+
+		type bad struct {
+			Count *string `glint:"count,dict"`
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("expected dict on a pointer to panic")
+			}
+		}()
+		NewEncoder[bad]()
+	})
+}
+
+type compressedReport struct {
+	Title  string           `glint:"title"`
+	Lines  []compressedLine `glint:"lines"`
+	Region string           `glint:"region,dict"`
+	Source dictSource       `glint:"source"`
+}
+
+type compressedLine struct {
+	Text string `glint:"text"`
+}
+
+// renamedCodec is deflate recorded under a codec of its own, standing in for a plugged in library
+type renamedCodec struct {
+	*DeflateCompressor
+}
+
+func (renamedCodec) Codec() Codec { return 15 }
+
+func TestCompression(t *testing.T) {
+	report := compressedReport{Title: "nightly", Region: "eu-west", Source: dictSource{Host: "eu-west", Line: 3}}
+	for i := 0; i < 100; i++ {
+		report.Lines = append(report.Lines, compressedLine{fmt.Sprintf("job %d finished without errors", i%10)})
+	}
+
+	plain, compressed := &Buffer{}, &Buffer{}
+	NewEncoder[compressedReport]().Marshal(&report, plain)
+	enc := NewEncoder[compressedReport](WithCompression(NewDeflateCompressor(1)))
+	enc.Marshal(&report, compressed)
+
+	t.Run("Deflate", func(t *testing.T) {
+		if Codec(compressed.Bytes[0]>>4) != CodecDeflate || compressed.Bytes[0]&flagStringTable == 0 {
+			t.Fatalf("expected a deflated document with a string table, got flags %08b", compressed.Bytes[0])
+		}
+		if len(compressed.Bytes) >= len(plain.Bytes)/4 {
+			t.Errorf("expected the body to compress, got %d bytes against %d", len(compressed.Bytes), len(plain.Bytes))
+		}
+
+		var out compressedReport
+		if err := NewDecoder[compressedReport]().Unmarshal(compressed.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, report) {
+			t.Errorf("expected %+v, got %+v", report, out)
+		}
+
+		// the schema is left uncompressed
+		if !bytes.Contains(compressed.Bytes, []byte("lines")) {
+			t.Error("expected the schema to stay readable")
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		var count CountingVisitor
+		if err := Walk(compressed.Bytes, &count); err != nil || count.Fields != 104 {
+			t.Errorf("expected to walk the decompressed body, got %+v, %v", count, err)
+		}
+		if s := mustSPrint(t, compressed.Bytes); !strings.Contains(s, "job 9 finished") {
+			t.Errorf("expected the printer to show the decompressed body, got\n%s", s)
+		}
+		if err := Document(compressed.Bytes).Validate(DefaultLimits); err != nil {
+			t.Errorf("expected the document to validate, got %v", err)
+		}
+
+		var w bytes.Buffer
+		if _, err := enc.MarshalTo(&w, &report); err != nil || !bytes.Equal(w.Bytes(), compressed.Bytes) {
+			t.Errorf("expected MarshalTo to write the same document as Marshal, got %v", err)
+		}
+
+		var buf bytes.Buffer
+		cw := NewContainerWriter(&buf)
+		cw.Write(plain.Bytes)
+		cw.Write(compressed.Bytes)
+		if err := cw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		c, err := OpenContainer(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc, err := c.Document(1); err != nil || !bytes.Equal(doc, compressed.Bytes) {
+			t.Errorf("expected the container to return the compressed document unchanged, got %v", err)
+		}
+	})
+
+	t.Run("Small", func(t *testing.T) {
+		b := &Buffer{}
+		enc.Marshal(&compressedReport{Title: "x"}, b)
+		if b.Bytes[0]&flagCodecMask != 0 {
+			t.Errorf("expected a body that doesn't shrink to be left uncompressed, got flags %08b", b.Bytes[0])
+		}
+	})
+
+	t.Run("Plugged", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[compressedReport](WithCompression(renamedCodec{NewDeflateCompressor(1)})).Marshal(&report, b)
+		if Codec(b.Bytes[0]>>4) != 15 {
+			t.Fatalf("expected codec 15, got flags %08b", b.Bytes[0])
+		}
+
+		var out compressedReport
+		if err := NewDecoder[compressedReport]().Unmarshal(b.Bytes, &out); err != nil || out.Lines[99] != report.Lines[99] {
+			t.Errorf("expected a registered codec to decode, got %v", err)
+		}
+
+		b.Bytes[0] = b.Bytes[0]&^flagCodecMask | 14<<4
+		if err := NewDecoder[compressedReport]().Unmarshal(b.Bytes, &out); !errors.Is(err, ErrUnknownCodec) {
+			t.Errorf("expected an unregistered codec to fail, got %v", err)
+		}
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		defer func(limit uint) { DefaultLimits.MaxDocumentSize = limit }(DefaultLimits.MaxDocumentSize)
+		DefaultLimits.MaxDocumentSize = 1024
+
+		if err := NewDecoder[compressedReport]().Unmarshal(compressed.Bytes, new(compressedReport)); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a body decompressing past the limit to fail, got %v", err)
+		}
+	})
+}
+
+type mutatedRecord struct {
+	Comprehensive Comprehensive    `glint:"c"`
+	Ptr           *Child           `glint:"ptr"`
+	Deltas        []int            `glint:"deltas,delta"`
+	Readings      []float64        `glint:"readings,delta"`
+	Sparse        []uint32         `glint:"sparse,sparse"`
+	Region        string           `glint:"region,dict"`
+	Regions       []string         `glint:"regions,dict"`
+	Children      []Child          `glint:"children"`
+	ByName        map[string]Child `glint:"by_name"`
+	Tail          bool             `glint:"tail"`
+}
+
+func TestMutateDocument(t *testing.T) {
+	schema := SchemaBytes(mutatedRecord{})
+	dec := NewDecoder[mutatedRecord]()
+
+	t.Run("Rejected", func(t *testing.T) {
+		kinds := map[MutationKind]int{}
+
+		for seed := int64(0); seed < 50; seed++ {
+			doc, err := GenerateDocument(schema, seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mutations, err := MutateDocument(doc, seed)
+			if err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+
+			for _, m := range mutations {
+				kinds[m.Kind]++
+
+				err := func() (err error) {
+					defer func() {
+						if rc := recover(); rc != nil {
+							t.Errorf("seed %d: %s mutation of %s panicked: %v", seed, m.Kind, m.Field, rc)
+						}
+					}()
+					return dec.Unmarshal(m.Doc, &mutatedRecord{})
+				}()
+				if err == nil {
+					t.Errorf("seed %d: %s mutation of %s decoded without an error", seed, m.Kind, m.Field)
+				}
+			}
+		}
+
+		for _, k := range []MutationKind{MutationWireType, MutationLength, MutationTruncate, MutationDuplicate} {
+			if kinds[k] == 0 {
+				t.Errorf("expected %s mutations", k)
+			}
+		}
+	})
+
+	t.Run("Targeted", func(t *testing.T) {
+		doc, _ := GenerateDocument(schema, 7)
+		mutations, _ := MutateDocument(doc, 7)
+
+		fields := map[string]bool{}
+		for _, m := range mutations {
+			fields[m.Kind.String()+" "+m.Field] = true
+
+			if err := Document(m.Doc).Validate(DefaultLimits); m.Kind != MutationWireType && err == nil {
+				t.Errorf("expected a %s mutation of %s to fail validation", m.Kind, m.Field)
+			}
+		}
+		for _, want := range []string{"wire type c.item1.a", "length c.string", "length regions", "truncate c.item1", "duplicate tail"} {
+			if !fields[want] {
+				t.Errorf("expected a %q mutation, got %v", want, fields)
+			}
+		}
+		if fields["length sparse"] || fields["truncate tail"] {
+			t.Error("expected only the mutations that apply to each field")
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		doc, _ := GenerateDocument(schema, 3)
+		a, _ := MutateDocument(doc, 3)
+		b, _ := MutateDocument(doc, 3)
+		if !reflect.DeepEqual(a, b) {
+			t.Error("expected the same mutations for the same document and seed")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := MutateDocument([]byte{0, 1, 2}, 1); err == nil {
+			t.Error("expected a malformed document to be rejected")
+		}
+
+		doc, _ := GenerateDocument(schema, 1)
+		if _, err := MutateDocument(doc[:len(doc)-1], 1); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a truncated document to be rejected, got %v", err)
+		}
+
+		trusted := append(append([]byte{}, NewEncoder[Child]().impl.header.Bytes...), 2, 0) // {A: 1}, without its schema
+		if _, err := MutateDocument(trusted, 1); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected a document without a schema to be rejected, got %v", err)
+		}
+	})
+}
+
+type statsReading struct {
+	Sensor  string       `glint:"sensor"`
+	Seq     int          `glint:"seq"`
+	Times   []int64      `glint:"times"`
+	Levels  []int32      `glint:"levels"`
+	Samples []statsPoint `glint:"samples"`
+	Note    string       `glint:"note"`
+}
+
+type statsPoint struct {
+	Region string `glint:"region"`
+	Value  uint32 `glint:"value"`
+}
+
+func TestFieldStats(t *testing.T) {
+
+	reading := func(i int) statsReading {
+		r := statsReading{Sensor: []string{"north", "south"}[i%2], Seq: i, Levels: make([]int32, 40), Note: fmt.Sprint("note-", i)}
+		for j := 0; j < 20; j++ {
+			r.Times = append(r.Times, int64(1700000000000+i*1000+j*10))
+			r.Samples = append(r.Samples, statsPoint{Region: "eu-west-1", Value: uint32(i*j) % 7000})
+		}
+		r.Levels[i%40] = int32(i)
+		return r
+	}
+
+	t.Run("Suggestions", func(t *testing.T) {
+		stats := NewFieldStats()
+		enc := NewEncoder[statsReading]()
+		for i := 0; i < 40; i++ {
+			if i == minSuggestionDocs-1 && stats.Suggestions() != nil {
+				t.Fatal("expected no suggestions before enough documents are observed")
+			}
+			v := reading(i)
+			b := &Buffer{}
+			enc.Marshal(&v, b)
+			if err := stats.Observe(b.Bytes); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fields := map[string]FieldStat{}
+		for _, f := range stats.Fields() {
+			fields[f.Path] = f
+		}
+		if f := fields["seq"]; f.Monotonicity() != 1 || f.Distinct != 40 {
+			t.Errorf("expected seq to ascend through 40 values, got %+v", f)
+		}
+		if f := fields["sensor"]; f.Distinct != 2 || f.Values != 40 {
+			t.Errorf("expected 2 distinct sensors in 40 values, got %+v", f)
+		}
+		if f := fields["levels"]; f.ZeroRatio() < 0.9 {
+			t.Errorf("expected levels to be mostly zeros, got %v", f.ZeroRatio())
+		}
+		if f := fields["samples.region"]; f.Values != 800 || f.Distinct != 1 {
+			t.Errorf("expected the fields of slice elements to be observed, got %+v", f)
+		}
+
+		var suggested []string
+		for _, s := range stats.Suggestions() {
+			suggested = append(suggested, s.Path+":"+s.Option)
+		}
+		if want := []string{"levels:sparse", "samples.region:dict", "times:delta"}; !reflect.DeepEqual(suggested, want) {
+			t.Errorf("suggestions mismatch\n got: %v\nwant: %v", suggested, want)
+		}
+
+		want := map[string]string{"levels": "sparse", "samples.region": "dict"}
+		if got := stats.Profile(); !reflect.DeepEqual(got, want) {
+			t.Errorf("profile mismatch\n got: %v\nwant: %v\nsuggestions: %+v", got, want, stats.Suggestions())
+		}
+	})
+
+	t.Run("Profile", func(t *testing.T) {
+		profile := map[string]string{"levels": "sparse", "samples.region": "dict"}
+		plain, tuned := NewEncoder[statsReading](), NewEncoder[statsReading](WithFieldOptions(profile))
+
+		v := reading(7)
+		pb, tb := &Buffer{}, &Buffer{}
+		plain.Marshal(&v, pb)
+		tuned.Marshal(&v, tb)
+		if len(tb.Bytes) >= len(pb.Bytes)*3/4 {
+			t.Errorf("expected the profile to shrink the document by a quarter, got %d vs %d bytes", len(tb.Bytes), len(pb.Bytes))
+		}
+
+		var out statsReading
+		if err := NewDecoder[statsReading]().Unmarshal(tb.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, v) {
+			t.Errorf("roundtrip mismatch\n got: %+v\nwant: %+v", out, v)
+		}
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		sender, receiver := NewSchemaSession(), NewSchemaSession()
+		stats := NewFieldStats()
+		sender.CollectFieldStats(stats, 2)
+
+		enc, dec := NewAdaptiveEncoder[statsReading](stats), NewDecoder[statsReading]()
+		var sizes []int
+		for i := 0; i < 200; i++ {
+			v := reading(i)
+			b := &Buffer{}
+			enc.MarshalWithSession(&v, b, sender)
+
+			var out statsReading
+			if err := dec.UnmarshalWithSession(b.Bytes, &out, receiver); err != nil {
+				t.Fatalf("document %d: %v", i, err)
+			}
+			if !reflect.DeepEqual(out, v) {
+				t.Fatalf("document %d: roundtrip mismatch\n got: %+v\nwant: %+v", i, out, v)
+			}
+			sender.Acknowledge(receiver.Acknowledgements()...)
+			sizes = append(sizes, len(b.Bytes))
+		}
+
+		if stats.Documents() != 100 {
+			t.Errorf("expected every other document to be observed, got %d", stats.Documents())
+		}
+		if len(enc.Profile()) != 2 {
+			t.Errorf("expected the encoder to adapt to the suggestions, got %v", enc.Profile())
+		}
+		if sizes[len(sizes)-1] >= sizes[1]*3/4 {
+			t.Errorf("expected adapted trusted documents to shrink, got %d vs %d bytes", sizes[len(sizes)-1], sizes[1])
+		}
+		if enc.Adapt() {
+			t.Error("expected no change without new suggestions")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		sender := NewSchemaSession()
+		enc := NewEncoder[statsReading]()
+		sender.Acknowledge(binary.LittleEndian.Uint32(enc.impl.header.Bytes[1:5]))
+
+		v := reading(1)
+		b := &Buffer{}
+		enc.MarshalWithSession(&v, b, sender)
+		if err := NewFieldStats().Observe(b.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected ErrSchemaNotFound for a trusted document with an unknown schema, got %v", err)
+		}
+		if err := NewFieldStats().Observe(b.Bytes[:3]); err == nil {
+			t.Error("expected an error for a malformed document")
+		}
+	})
+}
+
+type bitmapFlags struct {
+	Name    string       `glint:"name"`
+	Flags   []bool       `glint:"flags,bitmap"`
+	Fixed   [10]bool     `glint:"fixed,bitmap"`
+	Nested  bitmapSwitch `glint:"nested"`
+	Enabled bool         `glint:"enabled"`
+}
+
+type bitmapSwitch struct {
+	On []bool `glint:"on,bitmap"`
+}
+
+type bitmapFlagsPlain struct {
+	Name    string            `glint:"name"`
+	Flags   []bool            `glint:"flags"`
+	Fixed   [10]bool          `glint:"fixed"`
+	Nested  bitmapSwitchPlain `glint:"nested"`
+	Enabled bool              `glint:"enabled"`
+}
+
+type bitmapSwitchPlain struct {
+	On []bool `glint:"on"`
+}
+
+func TestBoolBitmaps(t *testing.T) {
+	flags := bitmapFlags{Name: "rollout", Enabled: true, Nested: bitmapSwitch{On: []bool{true}}}
+	for i := 0; i < 1000; i++ {
+		flags.Flags = append(flags.Flags, i%3 == 0)
+	}
+	flags.Fixed[0], flags.Fixed[9] = true, true
+
+	enc := NewEncoder[bitmapFlags]()
+	b := &Buffer{}
+	enc.Marshal(&flags, b)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		for _, n := range []int{0, 1, 7, 8, 9, 1000} {
+			v := flags
+			v.Flags = flags.Flags[:n]
+
+			b := &Buffer{}
+			enc.Marshal(&v, b)
+			var out bitmapFlags
+			if err := NewDecoder[bitmapFlags]().Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, v) {
+				t.Errorf("%d flags: expected %+v, got %+v", n, v, out)
+			}
+		}
+
+		v := flags
+		v.Flags = nil
+		b := &Buffer{}
+		NewEncoder[bitmapFlags](WithDistinctNilSlices()).Marshal(&v, b)
+		var out bitmapFlags
+		if err := NewDecoder[bitmapFlags]().Unmarshal(b.Bytes, &out); err != nil || out.Flags != nil {
+			t.Errorf("expected nil flags to survive, got %v, %v", out.Flags, err)
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		plain := bitmapFlagsPlain{Flags: flags.Flags}
+		packed := bitmapFlags{Flags: flags.Flags}
+
+		pb, bb := &Buffer{}, &Buffer{}
+		NewEncoder[bitmapFlagsPlain]().Marshal(&plain, pb)
+		enc.Marshal(&packed, bb)
+		if saved := len(pb.Bytes) - len(bb.Bytes); saved < 1000-125 {
+			t.Errorf("expected 1000 flags to pack into 125 bytes, saved %d bytes", saved)
+		}
+	})
+
+	t.Run("Untagged", func(t *testing.T) {
+		var plain bitmapFlagsPlain
+		if err := NewDecoder[bitmapFlagsPlain]().Unmarshal(b.Bytes, &plain); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(plain.Flags, flags.Flags) || plain.Fixed != flags.Fixed || !plain.Nested.On[0] || !plain.Enabled {
+			t.Errorf("expected bitmaps to read into untagged fields, got %+v", plain)
+		}
+
+		type partial struct {
+			Enabled bool `glint:"enabled"`
+		}
+		var out partial
+		if err := NewDecoder[partial]().Unmarshal(b.Bytes, &out); err != nil || !out.Enabled {
+			t.Errorf("expected the fields after skipped bitmaps, got %+v, %v", out, err)
+		}
+	})
+
+	t.Run("Threshold", func(t *testing.T) {
+		enc := NewEncoder[bitmapFlagsPlain](WithBoolBitmaps(16))
+		for _, n := range []int{0, 15, 16, 1000} {
+			v := bitmapFlagsPlain{Flags: flags.Flags[:n], Fixed: flags.Fixed}
+
+			b := &Buffer{}
+			enc.Marshal(&v, b)
+			var out bitmapFlagsPlain
+			if err := NewDecoder[bitmapFlagsPlain]().Unmarshal(b.Bytes, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out.Flags, v.Flags) || out.Fixed != v.Fixed {
+				t.Errorf("%d flags: expected %+v, got %+v", n, v, out)
+			}
+
+			pb := &Buffer{}
+			NewEncoder[bitmapFlagsPlain]().Marshal(&v, pb)
+			if packed := len(b.Bytes) < len(pb.Bytes); packed != (n >= 16) {
+				t.Errorf("%d flags: expected packing only from 16 flags, got %d bytes against %d", n, len(b.Bytes), len(pb.Bytes))
+			}
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		if s := mustSPrint(t, b.Bytes); !strings.Contains(s, "(bitmap)") || !strings.Contains(s, "rollout") {
+			t.Errorf("expected the printer to show bitmaps, got\n%s", s)
+		}
+
+		doc, err := GenerateDocument(enc.impl.schema.Bytes, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bitmapFlags
+		if err := NewDecoder[bitmapFlags]().Unmarshal(doc, &out); err != nil {
+			t.Errorf("expected a generated document to decode, got %v", err)
+		}
+
+		mutations, err := MutateDocument(b.Bytes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range mutations {
+			if err := NewDecoder[bitmapFlags]().Unmarshal(m.Doc, new(bitmapFlags)); err == nil {
+				t.Errorf("expected the %v mutation of %q to fail", m.Kind, m.Field)
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		short := b.Bytes[:len(b.Bytes)-30]
+		if err := NewDecoder[bitmapFlags]().Unmarshal(short, new(bitmapFlags)); err == nil {
+			t.Error("expected a truncated bitmap to fail")
+		}
+
+		type bad struct {
+			Counts []int `glint:"counts,bitmap"`
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("expected bitmap on a slice of ints to panic")
+			}
+		}()
+		NewEncoder[bad]()
+	})
+}
+
+type templateOrder struct {
+	ID       int64             `glint:"id"`
+	Customer templateCustomer  `glint:"customer"`
+	Lines    []templateLine    `glint:"lines"`
+	Totals   map[string]uint32 `glint:"totals"`
+	Region   string            `glint:"region,dict"`
+	Stamps   []int             `glint:"stamps,delta"`
+	Counts   []uint16          `glint:"counts,sparse"`
+	Gifts    []bool            `glint:"gifts,bitmap"`
+	Coupon   *string           `glint:"coupon"`
+	Placed   time.Time         `glint:"placed"`
+}
+
+type templateCustomer struct {
+	Name  string `glint:"name"`
+	Email string `glint:"email"`
+}
+
+type templateLine struct {
+	SKU      string  `glint:"sku"`
+	Quantity uint8   `glint:"quantity"`
+	Price    float64 `glint:"price"`
+}
+
+func TestDocumentTemplateData(t *testing.T) {
+	order := templateOrder{
+		ID:       42,
+		Customer: templateCustomer{Name: "Ada", Email: "ada@example.com"},
+		Lines:    []templateLine{{SKU: "tea", Quantity: 2, Price: 3.5}, {SKU: "cake", Quantity: 1, Price: 4}},
+		Totals:   map[string]uint32{"net": 11, "tax": 2},
+		Region:   "eu",
+		Stamps:   []int{100, 90, 250},
+		Counts:   []uint16{0, 0, 0, 7, 0, 0, 0, 0},
+		Gifts:    []bool{false, true},
+		Placed:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	b := &Buffer{}
+	NewEncoder[templateOrder]().Marshal(&order, b)
+
+	t.Run("Values", func(t *testing.T) {
+		data, err := DocumentTemplateData(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]any{
+			"id":       int64(42),
+			"customer": map[string]any{"name": "Ada", "email": "ada@example.com"},
+			"lines": []any{
+				map[string]any{"sku": "tea", "quantity": uint(2), "price": 3.5},
+				map[string]any{"sku": "cake", "quantity": uint(1), "price": 4.0},
+			},
+			"totals": map[string]any{"net": uint(11), "tax": uint(2)},
+			"region": "eu",
+			"stamps": []any{100, 90, 250},
+			"counts": []any{uint(0), uint(0), uint(0), uint(7), uint(0), uint(0), uint(0), uint(0)},
+			"gifts":  []any{false, true},
+			"coupon": nil,
+			"placed": order.Placed,
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("expected\n%#v\ngot\n%#v", want, data)
+		}
+	})
+
+	t.Run("Template", func(t *testing.T) {
+		data, err := DocumentTemplateData(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tmpl := template.Must(template.New("order").Parse(
+			`{{.customer.name}}: {{range .lines}}{{.quantity}} {{.sku}} {{end}}({{.totals.net}}){{with .coupon}} {{.}}{{end}}`))
+		var out strings.Builder
+		if err := tmpl.Execute(&out, data); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Ada: 2 tea 1 cake (11)"; out.String() != want {
+			t.Errorf("expected %q, got %q", want, out.String())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := DocumentTemplateData(b.Bytes[:len(b.Bytes)-3]); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a truncated document to fail, got %v", err)
+		}
+
+		trusted := &Buffer{}
+		enc := NewEncoder[templateOrder]()
+		session := NewSchemaSession()
+		session.Acknowledge(binary.LittleEndian.Uint32(enc.impl.header.Bytes[1:5]))
+		enc.MarshalWithSession(&order, trusted, session)
+		if _, err := DocumentTemplateData(trusted.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected a document without a schema to fail, got %v", err)
+		}
+	})
+}
+
+type zigzagReading struct {
+	Offset int64            `glint:"offset"`
+	Drift  time.Duration    `glint:"drift"`
+	Last   *int64           `glint:"last"`
+	Deltas []int64          `glint:"deltas"`
+	Window [3]time.Duration `glint:"window"`
+	ID     int64            `glint:"id,nozigzag"`
+	Name   string           `glint:"name"`
+}
+
+type zigzagTagged struct {
+	Offset int64   `glint:"offset,zigzag"`
+	Deltas []int64 `glint:"deltas,zigzag"`
+	Name   string  `glint:"name"`
+}
+
+func TestZigzagInts(t *testing.T) {
+	last := int64(-1)
+	reading := zigzagReading{
+		Offset: -5,
+		Drift:  -3 * time.Millisecond,
+		Last:   &last,
+		Deltas: []int64{-1, 2, -300, math.MinInt64, math.MaxInt64},
+		Window: [3]time.Duration{-time.Second, 0, time.Second},
+		ID:     -7,
+		Name:   "sensor",
+	}
+
+	enc := NewEncoder[zigzagReading](WithZigzagInts())
+	b := &Buffer{}
+	enc.Marshal(&reading, b)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		var out zigzagReading
+		if err := NewDecoder[zigzagReading]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, reading) {
+			t.Errorf("expected %+v, got %+v", reading, out)
+		}
+
+		v := reading
+		v.Last, v.Deltas = nil, []int64{}
+		b := &Buffer{}
+		enc.Marshal(&v, b)
+		out = zigzagReading{}
+		if err := NewDecoder[zigzagReading]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, v) {
+			t.Errorf("expected %+v, got %+v", v, out)
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		pb := &Buffer{}
+		NewEncoder[zigzagReading]().Marshal(&reading, pb)
+
+		// the small negative numbers shrink from ten bytes each to a few, less the two bytes each zigzag field adds
+		// to the schema
+		if saved := len(pb.Bytes) - len(b.Bytes); saved < 30 {
+			t.Errorf("expected zigzag varints to save at least 30 bytes, saved %d", saved)
+		}
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		lazy, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string]WireType{
+			"offset": WireInt64 | WireZigzagFlag,
+			"drift":  WireDuration | WireZigzagFlag,
+			"id":     WireInt64,
+		} {
+			if wire, _, err := lazy.Raw(name); err != nil || wire != want {
+				t.Errorf("expected %q to be written as %v, got %v, %v", name, want, wire, err)
+			}
+		}
+
+		tagged := zigzagTagged{Offset: -5, Deltas: []int64{-1, -2}, Name: "tagged"}
+		b := &Buffer{}
+		NewEncoder[zigzagTagged]().Marshal(&tagged, b)
+
+		var out zigzagReading
+		if err := NewDecoder[zigzagReading]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Offset != -5 || !reflect.DeepEqual(out.Deltas, tagged.Deltas) || out.Name != "tagged" {
+			t.Errorf("expected tagged fields to read into untagged ones, got %+v", out)
+		}
+	})
+
+	t.Run("Untagged", func(t *testing.T) {
+		type plain struct {
+			Offset int64   `glint:"offset"`
+			Deltas []int64 `glint:"deltas"`
+		}
+		var out plain
+		if err := NewDecoder[plain]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Offset != reading.Offset || !reflect.DeepEqual(out.Deltas, reading.Deltas) {
+			t.Errorf("expected zigzag fields to read into a decoder without the option, got %+v", out)
+		}
+
+		type partial struct {
+			Name string `glint:"name"`
+		}
+		var named partial
+		if err := NewDecoder[partial]().Unmarshal(b.Bytes, &named); err != nil || named.Name != "sensor" {
+			t.Errorf("expected the fields after skipped zigzag fields, got %+v, %v", named, err)
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		if s := mustSPrint(t, b.Bytes); !strings.Contains(s, "(zigzag)") || !strings.Contains(s, "-300") || !strings.Contains(s, "-3ms") {
+			t.Errorf("expected the printer to show zigzag fields, got\n%s", s)
+		}
+
+		data, err := DocumentTemplateData(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := data.(map[string]any); m["offset"] != int64(-5) || m["drift"] != -3*time.Millisecond || m["last"] != int64(-1) {
+			t.Errorf("expected zigzag values in template data, got %v", m)
+		}
+
+		lazy, err := NewLazyDocument(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := lazy.Int("offset"); err != nil || v != -5 {
+			t.Errorf("expected a lazy offset of -5, got %v, %v", v, err)
+		}
+		if v, err := lazy.Duration("drift"); err != nil || v != -3*time.Millisecond {
+			t.Errorf("expected a lazy drift of -3ms, got %v, %v", v, err)
+		}
+
+		doc, err := GenerateDocument(enc.impl.schema.Bytes, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewDecoder[zigzagReading]().Unmarshal(doc, new(zigzagReading)); err != nil {
+			t.Errorf("expected a generated document to decode, got %v", err)
+		}
+
+		mutations, err := MutateDocument(b.Bytes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range mutations {
+			if err := NewDecoder[zigzagReading]().Unmarshal(m.Doc, new(zigzagReading)); err == nil {
+				t.Errorf("expected the %v mutation of %q to fail", m.Kind, m.Field)
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		type mismatch struct {
+			Offset uint64 `glint:"offset"`
+		}
+		if err := NewDecoder[mismatch]().Unmarshal(b.Bytes, new(mismatch)); err == nil {
+			t.Error("expected a zigzag int64 not to read into a uint64")
+		}
+
+		// the other integer types are zigzag varints already, and delta slices have zigzag deltas of their own
+		type unchanged struct {
+			Counts []int32 `glint:"counts,zigzag"`
+			Stamps []int64 `glint:"stamps,delta,zigzag"`
+		}
+		u := &Buffer{}
+		NewEncoder[unchanged](WithZigzagInts()).Marshal(&unchanged{Counts: []int32{-1}, Stamps: []int64{-1, -2}}, u)
+		lazy, err := NewLazyDocument(u.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"counts", "stamps"} {
+			if wire, _, err := lazy.Raw(name); err != nil || wire&WireZigzagFlag > 0 {
+				t.Errorf("expected %q to be written without zigzag varints, got %v, %v", name, wire, err)
+			}
+		}
+	})
+}
+
+func TestDocumentBuilderSchemaHash(t *testing.T) {
+	t.Run("Incremental", func(t *testing.T) {
+		doc := &DocumentBuilder{}
+		nested := (&DocumentBuilder{}).AppendString("street", "High St").AppendUint16("number", 12)
+
+		appends := []func(){
+			func() { doc.AppendString("name", "sensor") },
+			func() { doc.AppendInt64("offset", -5) },
+			func() { doc.AppendNestedDocument("address", nested) },
+			func() {
+				tags := SliceBuilder{}
+				tags.AppendStringSlice([]string{"a", "b"})
+				doc.AppendSlice("tags", tags)
+			},
+			func() { doc.AppendString(strings.Repeat("x", 200), "long names move the schema length to two bytes") },
+		}
+		for i, add := range appends {
+			add()
+			b := doc.Bytes()
+			if got, want := doc.SchemaHash(), crc32.ChecksumIEEE(b[5:len(b)-len(doc.body.Bytes)]); got != want {
+				t.Fatalf("after %d fields: expected a schema hash of %d, got %d", i+1, want, got)
+			}
+			if err := Document(b).Validate(DefaultLimits); err != nil {
+				t.Errorf("after %d fields: expected a valid document, got %v", i+1, err)
+			}
+		}
+
+		if (&DocumentBuilder{}).SchemaHash() != crc32.ChecksumIEEE([]byte{0}) {
+			t.Error("expected an empty builder to hash its zero length schema")
+		}
+	})
+
+	t.Run("Combine", func(t *testing.T) {
+		data := make([]byte, 1000)
+		rand.New(rand.NewSource(1)).Read(data)
+		for _, at := range []int{0, 1, 7, 64, 500, 999, 1000} {
+			a, b := data[:at], data[at:]
+			if got := crc32Combine(crc32.ChecksumIEEE(a), crc32.ChecksumIEEE(b), len(b)); got != crc32.ChecksumIEEE(data) {
+				t.Errorf("split at %d: expected %d, got %d", at, crc32.ChecksumIEEE(data), got)
+			}
+		}
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		type reading struct {
+			Name   string `glint:"name"`
+			Offset int64  `glint:"offset"`
+		}
+		build := func(name string) *DocumentBuilder {
+			return (&DocumentBuilder{}).AppendString("name", name).AppendInt64("offset", -5)
+		}
+
+		sender, receiver := NewSchemaSession(), NewSchemaSession()
+		dec := NewDecoder[reading]()
+
+		for i, name := range []string{"first", "second"} {
+			b := &Buffer{}
+			build(name).WriteToWithSession(b, sender)
+			if trusted := b.Bytes[5] == 0; trusted != (i > 0) {
+				t.Fatalf("document %d: expected only documents after the acknowledgement to leave the schema out", i)
+			}
+			if b.TrustedSchema {
+				t.Error("expected the buffer's trust mode to be restored")
+			}
+
+			var out reading
+			if err := dec.UnmarshalWithSession(b.Bytes, &out, receiver); err != nil {
+				t.Fatal(err)
+			}
+			if out.Name != name || out.Offset != -5 {
+				t.Errorf("document %d: expected %q, got %+v", i, name, out)
+			}
+			sender.Acknowledge(receiver.Acknowledgements()...)
+		}
+	})
+}
+
+type chunkedRow struct {
+	ID     int       `glint:"id"`
+	Name   string    `glint:"name"`
+	Region string    `glint:"region,dict"`
+	Values []float64 `glint:"values"`
+}
+
+type chunkedTable struct {
+	Title string       `glint:"title"`
+	Rows  []chunkedRow `glint:"rows"`
+	Tags  []string     `glint:"tags"`
+}
+
+func TestChunkedBuffer(t *testing.T) {
+	table := chunkedTable{Title: "readings", Tags: []string{"a", "b"}}
+	for i := 0; i < 2000; i++ {
+		table.Rows = append(table.Rows, chunkedRow{ID: i, Name: fmt.Sprint("row ", i), Region: []string{"eu", "us"}[i%2], Values: []float64{float64(i), 0.5}})
+	}
+
+	enc := NewEncoder[chunkedTable]()
+	want := &Buffer{}
+	enc.Marshal(&table, want)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		c := NewChunkedBuffer(1024)
+		enc.MarshalChunked(&table, c)
+
+		if n := len(c.Chunks()); n < len(want.Bytes)/1024/2 {
+			t.Errorf("expected a %d byte document to take many 1KiB chunks, got %d", len(want.Bytes), n)
+		}
+		for i, chunk := range c.Chunks()[:len(c.Chunks())-1] {
+			if len(chunk) < 1024 || len(chunk) > 1024+100 {
+				t.Errorf("expected chunk %d to fill to just past 1KiB, got %d bytes", i, len(chunk))
+			}
+		}
+		if c.Len() != len(want.Bytes) || !bytes.Equal(c.Bytes(), want.Bytes) {
+			t.Fatal("expected the same document as a Buffer holds")
+		}
+
+		var out chunkedTable
+		if err := NewDecoder[chunkedTable]().Unmarshal(c.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, table) {
+			t.Error("expected the table to survive a roundtrip")
+		}
+	})
+
+	t.Run("WriteTo", func(t *testing.T) {
+		c := NewChunkedBuffer(4096)
+		enc.MarshalChunked(&table, c)
+		enc.MarshalChunked(&table, c)
+
+		var w bytes.Buffer
+		if n, err := c.WriteTo(&w); err != nil || n != int64(2*len(want.Bytes)) {
+			t.Fatalf("expected to write two documents, wrote %d bytes, %v", n, err)
+		}
+		if !bytes.Equal(w.Bytes(), append(append([]byte{}, want.Bytes...), want.Bytes...)) {
+			t.Error("expected the written documents to match")
+		}
+		if n, _ := c.WriteTo(io.Discard); n != int64(2*len(want.Bytes)) {
+			t.Error("expected writing not to consume the buffer")
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		var c ChunkedBuffer // the zero value uses the default chunk size
+		enc.MarshalChunked(&table, &c)
+		chunks := len(c.Chunks())
+
+		c.Reset()
+		if c.Len() != 0 {
+			t.Fatalf("expected an empty buffer, got %d bytes", c.Len())
+		}
+
+		allocs := testing.AllocsPerRun(10, func() {
+			c.Reset()
+			enc.MarshalChunked(&table, &c)
+		})
+		if allocs > 0 {
+			t.Errorf("expected chunks to be reused, got %v allocations", allocs)
+		}
+		if !bytes.Equal(c.Bytes(), want.Bytes) || len(c.Chunks()) != chunks {
+			t.Error("expected the same document after a reset")
+		}
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		enc := NewEncoder[chunkedTable](WithCompression(NewDeflateCompressor(flate.BestSpeed)))
+		want := &Buffer{}
+		enc.Marshal(&table, want)
+
+		c := NewChunkedBuffer(1024)
+		enc.MarshalChunked(&table, c)
+		if len(c.Chunks()) != 1 || !bytes.Equal(c.Bytes(), want.Bytes) {
+			t.Errorf("expected a compressed document in a single chunk, got %d chunks", len(c.Chunks()))
+		}
+	})
+}
+
+type columnarPoint struct {
+	X int32 `glint:"x"`
+	Y int32 `glint:"y"`
+}
+
+type columnarRow struct {
+	ID     int64         `glint:"id,delta"`
+	Region string        `glint:"region,dict"`
+	Name   string        `glint:"name"`
+	Score  float64       `glint:"score"`
+	Active bool          `glint:"active,bitmap"`
+	At     columnarPoint `glint:"at"`
+	Tags   []string      `glint:"tags"`
+	hidden int
+}
+
+func TestColumnarEncoder(t *testing.T) {
+	rows := make([]columnarRow, 500)
+	for i := range rows {
+		rows[i] = columnarRow{
+			ID:     int64(1000000 + i),
+			Region: []string{"eu-west", "us-east", "ap-south"}[i%3],
+			Name:   fmt.Sprint("row ", i),
+			Score:  float64(i) / 4,
+			Active: i%5 == 0,
+			At:     columnarPoint{X: int32(i), Y: -int32(i)},
+			Tags:   []string{"t", fmt.Sprint(i % 7)},
+		}
+	}
+
+	enc := NewColumnarEncoder[columnarRow]()
+	b := &Buffer{}
+	enc.Marshal(rows, b)
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		var out []columnarRow
+		if err := NewColumnarDecoder[columnarRow]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, rows) {
+			t.Error("expected the rows to survive a roundtrip")
+		}
+
+		out = append(out[:0], columnarRow{Name: "stale", hidden: 1}, columnarRow{}) // capacity is reused, contents aren't
+		if err := NewColumnarDecoder[columnarRow]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, rows) {
+			t.Error("expected the rows to survive a roundtrip into a used slice")
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		e := &Buffer{}
+		enc.Marshal(nil, e)
+
+		out := []columnarRow{{Name: "stale"}}
+		if err := NewColumnarDecoder[columnarRow]().Unmarshal(e.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 0 {
+			t.Errorf("expected no rows, got %d", len(out))
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		type plainRows struct {
+			Rows []columnarRow `glint:"rows"`
+		}
+		r := &Buffer{}
+		NewEncoder[plainRows]().Marshal(&plainRows{Rows: rows}, r)
+		if len(b.Bytes) >= len(r.Bytes) {
+			t.Errorf("expected columns to be smaller than rows, got %d bytes against %d", len(b.Bytes), len(r.Bytes))
+		}
+	})
+
+	t.Run("SingleColumn", func(t *testing.T) {
+		type ids struct {
+			IDs []int64 `glint:"id,delta"`
+		}
+		var out ids
+		if err := NewDecoder[ids]().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.IDs) != len(rows) || out.IDs[0] != rows[0].ID || out.IDs[499] != rows[499].ID {
+			t.Error("expected the id column to decode on its own")
+		}
+	})
+
+	t.Run("FieldOptions", func(t *testing.T) {
+		enc := NewColumnarEncoder[columnarRow](WithFieldOptions(map[string]string{"name": "dict"}))
+		d := &Buffer{}
+		enc.Marshal(rows, d)
+		if bytes.Equal(enc.Schema().Bytes, NewColumnarEncoder[columnarRow]().Schema().Bytes) {
+			t.Error("expected field options to change the columns written")
+		}
+
+		var out []columnarRow
+		if err := NewColumnarDecoder[columnarRow]().Unmarshal(d.Bytes, &out); err != nil || !reflect.DeepEqual(out, rows) {
+			t.Errorf("expected the rows to survive a roundtrip, %v", err)
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		s := mustSPrint(t, b.Bytes)
+		for _, want := range []string{"id", "region", "eu-west", "row 499"} {
+			if !strings.Contains(s, want) {
+				t.Errorf("expected the printed document to contain %q", want)
+			}
+		}
+	})
+
+	t.Run("Mismatched", func(t *testing.T) {
+		type uneven struct {
+			IDs   []int64  `glint:"id,delta"`
+			Names []string `glint:"name"`
+		}
+		u := &Buffer{}
+		NewEncoder[uneven]().Marshal(&uneven{IDs: []int64{1, 2, 3}, Names: []string{"a"}}, u)
+
+		var out []columnarRow
+		if err := NewColumnarDecoder[columnarRow]().Unmarshal(u.Bytes, &out); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected columns of differing lengths to be invalid, got %v", err)
+		}
+	})
+
+	t.Run("NotStruct", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for rows that aren't structs")
+			}
+		}()
+		NewColumnarEncoder[int]()
+	})
+}
+
+type appendedRecord struct {
+	ID     int      `glint:"id"`
+	Region string   `glint:"region,dict"`
+	Tags   []string `glint:"tags"`
+}
+
+type appendedTraced struct {
+	ID      int         `glint:"id"`
+	Region  string      `glint:"region"`
+	Tags    []string    `glint:"tags"`
+	TraceID string      `glint:"trace_id"`
+	Span    *appendSpan `glint:"span"`
+}
+
+type appendSpan struct {
+	ID    uint64 `glint:"id"`
+	Layer string `glint:"layer,dict"`
+}
+
+func TestAppendField(t *testing.T) {
+	record := appendedRecord{ID: 7, Region: "eu-west", Tags: []string{"a", "b"}}
+
+	for name, enc := range map[string]*Encoder[appendedRecord]{
+		"Plain":      NewEncoder[appendedRecord](),
+		"Compressed": NewEncoder[appendedRecord](WithCompression(NewDeflateCompressor(flate.BestSpeed))),
+		"NameHashes": NewEncoder[appendedRecord](WithNameHashes()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := &Buffer{}
+			enc.Marshal(&record, b)
+			original := append([]byte{}, b.Bytes...)
+
+			doc, err := AppendField(b.Bytes, "trace_id", "4bf92f3577b34da6")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc, err = AppendField(doc, "span", &appendSpan{ID: 42, Layer: "eu-west"}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes, original) {
+				t.Error("expected the original document to be left as it was")
+			}
+			if err := Document(doc).Validate(DefaultLimits); err != nil {
+				t.Fatal(err)
+			}
+
+			var traced appendedTraced
+			if err := NewDecoder[appendedTraced]().Unmarshal(doc, &traced); err != nil {
+				t.Fatal(err)
+			}
+			want := appendedTraced{ID: 7, Region: "eu-west", Tags: []string{"a", "b"}, TraceID: "4bf92f3577b34da6", Span: &appendSpan{ID: 42, Layer: "eu-west"}}
+			if !reflect.DeepEqual(traced, want) {
+				t.Errorf("expected %+v, got %+v", want, traced)
+			}
+
+			var plain appendedRecord
+			if err := NewDecoder[appendedRecord]().Unmarshal(doc, &plain); err != nil || !reflect.DeepEqual(plain, record) {
+				t.Errorf("expected decoders without the field to skip it, got %+v, %v", plain, err)
+			}
+		})
+	}
+
+	t.Run("Verbatim", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[struct {
+			ID int `glint:"id"`
+		}]().Marshal(&struct {
+			ID int `glint:"id"`
+		}{ID: 7}, b)
+
+		doc, err := AppendField(b.Bytes, "n", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &Buffer{}
+		NewEncoder[struct {
+			ID int `glint:"id"`
+			N  int `glint:"n"`
+		}]().Marshal(&struct {
+			ID int `glint:"id"`
+			N  int `glint:"n"`
+		}{ID: 7, N: 1}, want)
+		if !bytes.Equal(doc, want.Bytes) {
+			t.Errorf("expected the document an encoder would write\n got %x\nwant %x", doc, want.Bytes)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[appendedRecord]().Marshal(&record, b)
+
+		if _, err := AppendField(b.Bytes, "id", 1); !errors.Is(err, ErrFieldExists) {
+			t.Errorf("expected an existing field to be refused, got %v", err)
+		}
+		if _, err := AppendField(b.Bytes, "extra", nil); err == nil {
+			t.Error("expected a nil value to be refused")
+		}
+		if _, err := AppendField(b.Bytes, "", 1); err == nil {
+			t.Error("expected an empty name to be refused")
+		}
+		if _, err := AppendField(b.Bytes, "extra", make(chan int)); err == nil {
+			t.Error("expected an unsupported type to be refused")
+		}
+		if _, err := AppendField(b.Bytes[:8], "extra", 1); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a truncated document to be invalid, got %v", err)
+		}
+
+		trusted := &Buffer{TrustedSchema: true}
+		NewEncoder[appendedRecord]().Marshal(&record, trusted)
+		if _, err := AppendField(trusted.Bytes, "extra", 1); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected a document without a schema to be refused, got %v", err)
+		}
+	})
+}
+
+type collectedSignup struct {
+	Name   string   `glint:"name"`
+	Age    string   `glint:"age"`    // written as an int
+	Scores []string `glint:"scores"` // written as []float64
+	Home   struct {
+		Zip int `glint:"zip"` // written as a string
+	} `glint:"home"`
+	Counts map[string]uint8 `glint:"counts"`
+	Email  string           `glint:"email,required"`
+	Phone  string           `glint:"phone,required"`
+}
+
+func TestCollectErrors(t *testing.T) {
+	user := constrainedUser{
+		Name: "sam", Age: 30, Scores: []float64{0, 0.5, 1}, Home: constrainedAddress{"12345"},
+		Counts: map[string]uint8{"a": 10},
+	}
+	b := &Buffer{}
+	NewEncoder[constrainedUser]().Marshal(&user, b)
+
+	t.Run("Fields", func(t *testing.T) {
+		if err := NewDecoder[collectedSignup]().Unmarshal(b.Bytes, &collectedSignup{}); err == nil || strings.Count(err.Error(), "\n") > 0 {
+			t.Fatalf("expected a single error without collecting, got %v", err)
+		}
+
+		dec := NewDecoder[collectedSignup]().CollectErrors()
+		for i := 0; i < 2; i++ { // a schema with errors isn't cached, so they're reported every time
+			var out collectedSignup
+			err := dec.Unmarshal(b.Bytes, &out)
+			if err == nil {
+				t.Fatal("expected errors")
+			}
+
+			errs := err.(interface{ Unwrap() []error }).Unwrap()
+			if len(errs) != 5 {
+				t.Fatalf("expected 5 errors, got %d: %v", len(errs), err)
+			}
+			for _, field := range []string{`"age"`, `"scores"`, `"zip"`, `"email"`, `"phone"`} {
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("expected an error naming %s, got %v", field, err)
+				}
+			}
+			if !errors.Is(err, ErrMissingRequiredField) {
+				t.Error("expected errors.Is to find the missing fields")
+			}
+			if out.Name != "sam" || !reflect.DeepEqual(out.Counts, user.Counts) {
+				t.Errorf("expected the fields that match to be decoded, got %+v", out)
+			}
+		}
+	})
+
+	t.Run("Constraints", func(t *testing.T) {
+		invalid := user
+		invalid.Name, invalid.Age, invalid.Scores = "sam!", 200, []float64{-1, 0.5, 2}
+		b := &Buffer{}
+		NewEncoder[constrainedUser]().Marshal(&invalid, b)
+
+		if err := NewValidatingDecoder[plainUser]().Unmarshal(b.Bytes, &plainUser{}); strings.Count(err.Error(), "\n") > 0 {
+			t.Errorf("expected a single violation without collecting, got %v", err)
+		}
+
+		err := NewValidatingDecoder[plainUser]().CollectErrors().Unmarshal(b.Bytes, &plainUser{})
+		if !errors.Is(err, ErrConstraintViolation) {
+			t.Fatalf("expected constraint violations, got %v", err)
+		}
+		for _, path := range []string{"name", "age", "scores[0]", "scores[2]"} {
+			if !strings.Contains(err.Error(), path+" ") {
+				t.Errorf("expected a violation for %s, got %v", path, err)
+			}
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		var out collectedSignup
+		err := NewDecoder[collectedSignup]().CollectErrors().Unmarshal(b.Bytes[:len(b.Bytes)-3], &out)
+		if !errors.Is(err, ErrInvalidDocument) || !errors.Is(err, ErrMissingRequiredField) {
+			t.Errorf("expected the truncation alongside the field errors, got %v", err)
+		}
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		var out constrainedUser
+		if err := NewValidatingDecoder[constrainedUser]().CollectErrors().Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, user) {
+			t.Error("expected a valid document to decode as usual")
+		}
+	})
+
+	t.Run("NoAllocsByDefault", func(t *testing.T) {
+		// no strings, which the purego build copies out of the document
+		type flat struct {
+			ID     int     `glint:"id"`
+			Active bool    `glint:"active"`
+			Rate   float64 `glint:"rate"`
+		}
+		doc := NewEncoder[flat]().MarshalBytes(&flat{ID: 1, Active: true, Rate: 0.5})
+
+		// collecting errors mustn't cost decoders that don't, which read cached schemas without allocating
+		dec := NewDecoder[flat]()
+		var out flat
+		allocs := testing.AllocsPerRun(100, func() {
+			if err := dec.Unmarshal(doc, &out); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("expected decoding without collecting errors not to allocate, got %v allocs", allocs)
+		}
+	})
+}
+
+type binaryUser struct {
+	Name  string   `glint:"name"`
+	Age   int      `glint:"age"`
+	Roles []string `glint:"roles"`
+}
+
+func TestBinary(t *testing.T) {
+	var _ encoding.BinaryMarshaler = Binary[binaryUser]{}
+	var _ encoding.BinaryUnmarshaler = &Binary[binaryUser]{}
+
+	user := binaryUser{Name: "ada", Age: 36, Roles: []string{"admin"}}
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		data, err := Binary[binaryUser]{Value: user}.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var direct binaryUser
+		if err := NewDecoder[binaryUser]().Unmarshal(data, &direct); err != nil || !reflect.DeepEqual(direct, user) {
+			t.Errorf("expected a glint document, got %+v, %v", direct, err)
+		}
+
+		var out Binary[binaryUser]
+		if err := out.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		copy(data, make([]byte, len(data)))
+		if !reflect.DeepEqual(out.Value, user) {
+			t.Errorf("expected the value not to share memory with the document, got %+v", out.Value)
+		}
+	})
+
+	t.Run("Gob", func(t *testing.T) {
+		type envelope struct {
+			ID   int
+			User Binary[binaryUser]
+		}
+
+		var w bytes.Buffer
+		if err := gob.NewEncoder(&w).Encode(envelope{ID: 1, User: Binary[binaryUser]{Value: user}}); err != nil {
+			t.Fatal(err)
+		}
+		var out envelope
+		if err := gob.NewDecoder(&w).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.ID != 1 || !reflect.DeepEqual(out.User.Value, user) {
+			t.Errorf("expected the value to survive gob, got %+v", out)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		var out Binary[binaryUser]
+		if err := out.UnmarshalBinary([]byte{1, 2, 3}); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected an invalid document, got %v", err)
+		}
+	})
+}
+
+type mergeAddress struct {
+	Street string `glint:"street"`
+	City   string `glint:"city,dict"`
+}
+
+type mergeProfile struct {
+	Name    string         `glint:"name"`
+	Age     int            `glint:"age"`
+	Home    mergeAddress   `glint:"home"`
+	Work    *mergeAddress  `glint:"work"`
+	Tags    []string       `glint:"tags,dict"`
+	Scores  map[string]int `glint:"scores"`
+	Updated time.Time      `glint:"updated"`
+}
+
+// mergeUpdate holds some of mergeProfile's fields, and one of its own
+type mergeUpdate struct {
+	Age  int `glint:"age"`
+	Home struct {
+		City string `glint:"city,dict"`
+	} `glint:"home"`
+	Work  *mergeAddress `glint:"work"`
+	Tags  []string      `glint:"tags,dict"`
+	Email string        `glint:"email"`
+}
+
+type mergedProfile struct {
+	mergeProfile
+	Email string `glint:"email"`
+}
+
+func TestMerge(t *testing.T) {
+	profile := mergeProfile{
+		Name: strings.Repeat("ada ", 50), Age: 36, Home: mergeAddress{Street: "1 Main St", City: "London"},
+		Work: &mergeAddress{Street: "2 Side St", City: "London"}, Tags: []string{"admin", "ops"},
+		Scores: map[string]int{"go": 10}, Updated: time.Unix(1700000000, 0).UTC(),
+	}
+	update := mergeUpdate{Age: 37, Work: &mergeAddress{Street: "3 New St", City: "Paris"}, Tags: []string{"ops", "eng"}, Email: "ada@example.com"}
+	update.Home.City = "Paris"
+
+	for name, enc := range map[string]*Encoder[mergeProfile]{
+		"Plain":      NewEncoder[mergeProfile](),
+		"Compressed": NewEncoder[mergeProfile](WithCompression(NewDeflateCompressor(flate.BestSpeed))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst, src := &Buffer{}, &Buffer{}
+			enc.Marshal(&profile, dst)
+			NewEncoder[mergeUpdate]().Marshal(&update, src)
+
+			doc, err := Merge(dst.Bytes, src.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Document(doc).Validate(DefaultLimits); err != nil {
+				t.Fatal(err)
+			}
+			if name == "Compressed" && Codec(dst.Bytes[0]>>4) != CodecDeflate {
+				t.Fatal("expected dst to be compressed")
+			}
+			if Codec(dst.Bytes[0]>>4) != Codec(doc[0]>>4) {
+				t.Error("expected the result to be compressed as dst was")
+			}
+
+			var out mergedProfile
+			if err := NewDecoder[mergedProfile]().Unmarshal(doc, &out); err != nil {
+				t.Fatal(err)
+			}
+			want := mergedProfile{mergeProfile: profile, Email: "ada@example.com"}
+			want.Age, want.Home.City, want.Work, want.Tags = 37, "Paris", update.Work, update.Tags
+			if !reflect.DeepEqual(out, want) {
+				t.Errorf("expected\n%+v, got\n%+v", want, out)
+			}
+		})
+	}
+
+	t.Run("Patch", func(t *testing.T) {
+		dst, src := &Buffer{}, &Buffer{}
+		NewEncoder[mergeProfile]().Marshal(&profile, dst)
+		NewEncoder[mergeUpdate]().Marshal(&mergeUpdate{Age: 40}, src) // a nil work address
+
+		merged, err := Merge(dst.Bytes, src.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out mergeProfile
+		if err := NewDecoder[mergeProfile]().Unmarshal(merged, &out); err != nil || out.Work != nil {
+			t.Errorf("expected a nil work address to replace dst's, got %+v, %v", out.Work, err)
+		}
+
+		patched, err := Merge(dst.Bytes, src.Bytes, WithMergePatch())
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := Document(patched).Schema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range schema.Fields {
+			if f.Name == "work" {
+				t.Error("expected a nil work address to remove the field")
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		dst := &Buffer{}
+		NewEncoder[mergeProfile]().Marshal(&profile, dst)
+
+		trusted := &Buffer{TrustedSchema: true}
+		NewEncoder[mergeProfile]().Marshal(&profile, trusted)
+		if _, err := Merge(dst.Bytes, trusted.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+			t.Errorf("expected a document without a schema to be refused, got %v", err)
+		}
+		if _, err := Merge(dst.Bytes, dst.Bytes[:len(dst.Bytes)-8]); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a truncated document to be invalid, got %v", err)
+		}
+	})
+}
+
+type policyOrder struct {
+	ID    string `glint:"id"`
+	Total int    `glint:"total"`
+	Ship  struct {
+		City string `glint:"city"`
+	} `glint:"ship"`
+}
+
+type policyOrderV2 struct {
+	ID    string  `glint:"id"`
+	Total float64 `glint:"total"` // changed type
+	Ship  struct {
+		City string `glint:"city"`
+		Zip  string `glint:"zip"` // added
+	} `glint:"ship"`
+	Note string `glint:"note"` // added
+}
+
+type policyOrderV3 struct {
+	ID string `glint:"id"` // total and ship removed
+}
+
+func TestSchemaPolicy(t *testing.T) {
+	v2, v3 := &Buffer{}, &Buffer{}
+	NewEncoder[policyOrderV2]().Marshal(&policyOrderV2{ID: "a"}, v2)
+	NewEncoder[policyOrderV3]().Marshal(&policyOrderV3{ID: "a"}, v3)
+
+	t.Run("Rules", func(t *testing.T) {
+		var mu sync.Mutex
+		var seen []PolicyViolation
+		policy := NewSchemaPolicy(PolicyRules{TypeChanges: PolicyReject, AddedFields: PolicyFlag})
+		policy.OnViolation = func(v PolicyViolation) {
+			mu.Lock()
+			seen = append(seen, v)
+			mu.Unlock()
+		}
+		dec := NewDecoder[policyOrder]().EnforcePolicy(policy)
+
+		for i := 0; i < 2; i++ { // each schema is checked once
+			if err := dec.Unmarshal(v2.Bytes, &policyOrder{}); !errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), `"total"`) {
+				t.Errorf("expected the type change to be rejected, got %v", err)
+			}
+		}
+		if len(seen) != 3 {
+			t.Fatalf("expected 3 violations, got %v", seen)
+		}
+		want := map[string]PolicyAction{"total": PolicyReject, "ship.zip": PolicyFlag, "note": PolicyFlag}
+		for _, v := range seen {
+			if want[v.Field] != v.Action || v.Hash != Document(v2.Bytes).Hash() {
+				t.Errorf("unexpected violation %+v", v)
+			}
+		}
+
+		var out policyOrder
+		if err := dec.Unmarshal(v3.Bytes, &out); err != nil || out.ID != "a" {
+			t.Errorf("expected removed fields to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		policy := NewSchemaPolicy(PolicyRules{})
+		dec := NewDecoder[policyOrder]().EnforcePolicy(policy)
+		if err := dec.Unmarshal(v3.Bytes, &policyOrder{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := policy.Reload([]byte(`{"removed_fields": "reject"}`)); err != nil {
+			t.Fatal(err)
+		}
+		err := dec.Unmarshal(v3.Bytes, &policyOrder{})
+		if !errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), `"ship"`) {
+			t.Errorf("expected reloaded rules to apply to a schema already checked, got %v", err)
+		}
+
+		trusted := &Buffer{TrustedSchema: true}
+		NewEncoder[policyOrderV3]().Marshal(&policyOrderV3{ID: "a"}, trusted)
+		if err := dec.Unmarshal(trusted.Bytes, &policyOrder{}); !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("expected a trusted document to get the verdict of its schema, got %v", err)
+		}
+
+		if err := policy.Reload([]byte(`{"removed_fields": "refuse"}`)); err == nil {
+			t.Error("expected an unknown action to be refused")
+		}
+		if err := policy.Reload([]byte(`{"renamed_fields": "reject"}`)); err == nil {
+			t.Error("expected an unknown rule to be refused")
+		}
+		if policy.Rules().RemovedFields != PolicyReject {
+			t.Error("expected the rules to be left as they were")
+		}
+	})
+
+	t.Run("Compatible", func(t *testing.T) {
+		type flexible struct {
+			ID    string        `glint:"id,dict"`
+			Total time.Duration `glint:"total"`
+			Tags  []int64       `glint:"tags,zigzag"`
+		}
+		type plain struct {
+			ID    string  `glint:"id"`
+			Total int64   `glint:"total"`
+			Tags  []int64 `glint:"tags"`
+		}
+		b := &Buffer{}
+		NewEncoder[flexible]().Marshal(&flexible{ID: "a", Tags: []int64{1, 2}}, b)
+
+		policy := NewSchemaPolicy(PolicyRules{TypeChanges: PolicyReject, AddedFields: PolicyReject, RemovedFields: PolicyReject})
+		if err := NewDecoder[plain]().EnforcePolicy(policy).Unmarshal(b.Bytes, &plain{}); err != nil {
+			t.Errorf("expected encodings decoders read either way not to count as changes, got %v", err)
+		}
+	})
+}
+
+func TestStrictSchemaHash(t *testing.T) {
+	type record struct {
+		Name  string `glint:"name"`
+		Count int    `glint:"count"`
+	}
+	b := &Buffer{}
+	NewEncoder[record]().Marshal(&record{Name: "a", Count: 3}, b)
+
+	// rename a field without touching the hash, as corruption or tampering might
+	tampered := append([]byte{}, b.Bytes...)
+	i := bytes.Index(tampered, []byte("count"))
+	tampered[i] = 'm'
+
+	t.Run("Lenient", func(t *testing.T) {
+		dec := NewDecoder[record]()
+		var out record
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Unmarshal(tampered, &out); err != nil || out.Count != 3 {
+			t.Errorf("expected a tampered schema behind a known hash to decode with cached instructions, got %v", err)
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		dec := NewDecoder[record](WithStrictSchemaHash())
+		var out record
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil || out.Count != 3 {
+			t.Fatalf("expected an intact document to decode, got %v", err)
+		}
+
+		err := dec.Unmarshal(tampered, &out)
+		var hashErr *SchemaHashError
+		if !errors.As(err, &hashErr) || !errors.Is(err, ErrSchemaHashMismatch) || !errors.Is(err, ErrInvalidDocument) {
+			t.Fatalf("expected a schema hash error, got %v", err)
+		}
+		if hashErr.Header != Document(b.Bytes).Hash() || hashErr.Schema == hashErr.Header {
+			t.Errorf("unexpected hashes %+v", hashErr)
+		}
+
+		if err := Document(tampered).Validate(DefaultLimits); !errors.As(err, &hashErr) {
+			t.Errorf("expected Validate to report the same error, got %v", err)
+		}
+	})
+
+	t.Run("Trusted", func(t *testing.T) {
+		dec := NewDecoder[record](WithStrictSchemaHash())
+		trusted := &Buffer{TrustedSchema: true}
+		NewEncoder[record]().Marshal(&record{Name: "b"}, trusted)
+
+		var out record
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Unmarshal(trusted.Bytes, &out); err != nil || out.Name != "b" {
+			t.Errorf("expected a document without a schema to decode as usual, got %v", err)
+		}
+	})
+}
+
+type typedErrorsRecord struct {
+	Name string `glint:"name"`
+	Age  int    `glint:"age"`
+}
+
+type typedErrorsRetyped struct {
+	Name string `glint:"name"`
+	Age  string `glint:"age"`
+}
+
+func TestTypedErrors(t *testing.T) {
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+	NewEncoder[typedErrorsRecord]().Marshal(&typedErrorsRecord{Name: "a long enough name", Age: 30}, b)
+
+	t.Run("FieldTypeMismatch", func(t *testing.T) {
+		var out typedErrorsRetyped
+		err := NewDecoder[typedErrorsRetyped]().Unmarshal(b.Bytes, &out)
+
+		var fieldErr *FieldTypeError
+		if !errors.As(err, &fieldErr) || !errors.Is(err, ErrFieldTypeMismatch) || !errors.Is(err, ErrSchemaMismatch) {
+			t.Fatalf("expected a field type error, got %v", err)
+		}
+		if fieldErr.Field != "age" || fieldErr.Expected != WireString || fieldErr.Got != WireInt {
+			t.Errorf("unexpected field error %+v", fieldErr)
+		}
+		if !strings.Contains(err.Error(), "schema mismatch") {
+			t.Errorf("expected the message to keep its wording, got %v", err)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		var out typedErrorsRecord
+		err := NewDecoder[typedErrorsRecord]().Unmarshal(b.Bytes[:len(b.Bytes)-4], &out)
+		if !errors.Is(err, ErrTruncatedDocument) || !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a truncated document error, got %v", err)
+		}
+		if errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("didn't expect a limit error, got %v", err)
+		}
+	})
+
+	t.Run("LimitExceeded", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxSchemaSize = 4
+
+		var out typedErrorsRecord
+		err := NewDecoderWithLimits[typedErrorsRecord](limits).Unmarshal(b.Bytes, &out)
+		if !errors.Is(err, ErrLimitExceeded) || !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected a limit error, got %v", err)
+		}
+
+		if err := Document(b.Bytes).Validate(limits); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected Validate to report a limit error, got %v", err)
+		}
+	})
+}
+
+type pathPreferences struct {
+	Theme string            `glint:"theme"`
+	Tags  map[string]string `glint:"tags"`
+}
+
+type pathUser struct {
+	Name        string          `glint:"name"`
+	Preferences pathPreferences `glint:"preferences"`
+}
+
+type pathAccount struct {
+	Users []pathUser `glint:"users"`
+}
+
+type pathRetypedPreferences struct {
+	Theme int `glint:"theme"`
+}
+
+type pathRetypedUser struct {
+	Preferences pathRetypedPreferences `glint:"preferences"`
+}
+
+type pathRetypedAccount struct {
+	Users []pathRetypedUser `glint:"users"`
+}
+
+func TestErrorPaths(t *testing.T) {
+	account := pathAccount{}
+	for i := 0; i < 4; i++ {
+		account.Users = append(account.Users, pathUser{
+			Name:        fmt.Sprintf("user %d", i),
+			Preferences: pathPreferences{Theme: "dark", Tags: map[string]string{"colour": "blue"}},
+		})
+	}
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+	NewEncoder[pathAccount]().Marshal(&account, b)
+
+	t.Run("Schema", func(t *testing.T) {
+		var out pathRetypedAccount
+		err := NewDecoder[pathRetypedAccount]().Unmarshal(b.Bytes, &out)
+
+		var pathErr *PathError
+		if !errors.As(err, &pathErr) || pathErr.Path != "users.preferences.theme" {
+			t.Fatalf("expected an error at users.preferences.theme, got %v", err)
+		}
+		if !errors.Is(err, ErrFieldTypeMismatch) || !strings.HasPrefix(err.Error(), "users.preferences.theme: ") {
+			t.Errorf("expected the path to lead a field type error, got %v", err)
+		}
+	})
+
+	t.Run("Body", func(t *testing.T) {
+		// the last user's tag value is the final value in the body, cutting into it fails inside users[3]
+		var out pathAccount
+		err := NewDecoder[pathAccount]().Unmarshal(b.Bytes[:len(b.Bytes)-2], &out)
+
+		var pathErr *PathError
+		if !errors.As(err, &pathErr) || pathErr.Path != `users[3].preferences.tags["colour"]` {
+			t.Fatalf("expected an error at users[3].preferences.tags[\"colour\"], got %v", err)
+		}
+		if !errors.Is(err, ErrTruncatedDocument) || !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected the path to wrap the truncation, got %v", err)
+		}
+	})
+
+	t.Run("WellFormed", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxSliceElements = 2
+
+		var out pathAccount
+		err := NewDecoderWithLimits[pathAccount](limits).Unmarshal(b.Bytes, &out)
+		var pathErr *PathError
+		if !errors.Is(err, ErrLimitExceeded) || errors.As(err, &pathErr) {
+			t.Errorf("expected a limit error without a path, got %v", err)
+		}
+	})
+}
+
+// mustSPrint prints a document, failing the test when it can't be printed
+func mustSPrint(t testing.TB, doc []byte) string {
+	t.Helper()
+	s, err := SPrint(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMalformedDocumentErrors(t *testing.T) {
+	doc, err := os.ReadFile("testdata/versions/v0/populated.glint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{6, len(doc) / 2, len(doc) - 1} {
+		truncated := doc[:n]
+
+		if err := Walk(truncated, DecodingVisitor(&testVisitor{fields: map[string]any{}})); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected Walk to report a document truncated to %d bytes, got %v", n, err)
+		}
+		if s, err := SPrint(truncated); !errors.Is(err, ErrInvalidDocument) || s != "" {
+			t.Errorf("expected SPrint to report a document truncated to %d bytes, got %v", n, err)
+		}
+		if s := Document(truncated).String(); !strings.Contains(s, ErrInvalidDocument.Error()) {
+			t.Errorf("expected String to give the error, got %q", s)
+		}
+		var v versionFixture
+		if err := NewDecoder[versionFixture]().Unmarshal(truncated, &v); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected Unmarshal to report a document truncated to %d bytes, got %v", n, err)
+		}
+	}
+
+	r := NewReader(doc[:6])
+	if _, err := NewPrinterDocument(&r); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("expected NewPrinterDocument to report a truncated schema, got %v", err)
+	}
+}
+
+func TestRecoverErr(t *testing.T) {
+	recovered := func(fn func()) (err error) {
+		defer recoverErr(&err)
+		fn()
+		return nil
+	}
+
+	// the Reader's own bounds checks come back as errors, however long a length a document claims
+	r := NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	if err := recovered(func() { r.ReadStringSlice() }); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("expected a hostile slice length to be reported, got %v", err)
+	}
+
+	// any other runtime error is a bug, and panics as it would have without the recover
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			t.Error("expected a runtime error to panic through recoverErr")
+		}
+	}()
+	recovered(func() {
+		var m map[string]int
+		m["a"] = 1
+	})
+}
+
+func TestLongFieldNames(t *testing.T) {
+	name := strings.Repeat("n", 200) // names are written with a single length byte
+	doc := DocumentBuilder{}
+	doc.AppendBool(name, true)
+
+	v := testVisitor{fields: map[string]any{}}
+	if err := Walk(doc.Bytes(), DecodingVisitor(&v)); err != nil || v.fields[name] != true {
+		t.Errorf("expected Walk to read the field, got %v %v", err, v.fields)
+	}
+	if s, err := SPrint(doc.Bytes()); err != nil || !strings.Contains(s, name) {
+		t.Errorf("expected SPrint to print the field, got %v", err)
+	}
+}
+
+type nestingRecord struct {
+	Matrix [][]int `glint:"matrix"`
+}
+
+// nestedDocument writes a document holding a single field of slices nested depth deep, each holding the next and
+// the innermost empty
+func nestedDocument(depth int) []byte {
+	node := schemaNode{name: "deep", wire: WireSliceFlag | WireInt, elem: &schemaNode{wire: WireInt}}
+	for i := 1; i < depth; i++ {
+		elem := node
+		elem.name = ""
+		node = schemaNode{name: "deep", wire: WireSliceFlag, elem: &elem}
+	}
+	body := append(bytes.Repeat([]byte{1}, depth-1), 0)
+	return writeDocument([]schemaNode{node}, 0, body, nil)
+}
+
+func TestMaxNestingDepth(t *testing.T) {
+	t.Run("Hostile", func(t *testing.T) {
+		doc := nestedDocument(1000)
+
+		if err := Walk(doc, DecodingVisitor(&testVisitor{fields: map[string]any{}})); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected Walk to refuse the document, got %v", err)
+		}
+		if _, err := SPrint(doc); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected SPrint to refuse the document, got %v", err)
+		}
+		r := NewReader(doc)
+		if _, err := NewPrinterDocument(&r); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected NewPrinterDocument to refuse the document, got %v", err)
+		}
+
+		limits := DefaultLimits
+		limits.MaxSchemaDepth = 0 // the nesting limit holds on its own
+		var out nestingRecord
+		if err := NewDecoderWithLimits[nestingRecord](limits).Unmarshal(doc, &out); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected the decoder to refuse the document, got %v", err)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		b := &Buffer{}
+		NewEncoder[nestingRecord]().Marshal(&nestingRecord{Matrix: [][]int{{1, 2}, {3}}}, b)
+
+		limits := DefaultLimits
+		limits.MaxNestingDepth = 3 // the field, its rows and their values
+
+		var out nestingRecord
+		if err := NewDecoderWithLimits[nestingRecord](limits).Unmarshal(b.Bytes, &out); err != nil || len(out.Matrix) != 2 {
+			t.Fatalf("expected the document to decode within the limit, got %v", err)
+		}
+
+		limits.MaxNestingDepth = 2
+		err := NewDecoderWithLimits[nestingRecord](limits).Unmarshal(b.Bytes, &out)
+		if !errors.Is(err, ErrLimitExceeded) || !strings.Contains(err.Error(), "nesting depth") {
+			t.Errorf("expected the nesting limit to be exceeded, got %v", err)
+		}
+	})
+
+	t.Run("Shallow", func(t *testing.T) {
+		doc := nestedDocument(10)
+		if err := Walk(doc, DecodingVisitor(&testVisitor{fields: map[string]any{}})); err != nil {
+			t.Errorf("expected Walk to accept the document, got %v", err)
+		}
+		if _, err := SPrint(doc); err != nil {
+			t.Errorf("expected SPrint to accept the document, got %v", err)
+		}
+	})
+}
+
+type totalsItem struct {
+	ID    int    `glint:"id"`
+	Label string `glint:"label"`
+}
+
+type totalsRecord struct {
+	Name  string         `glint:"name"`
+	Tags  map[string]int `glint:"tags"`
+	Items []totalsItem   `glint:"items"`
+}
+
+func TestDecodeTotals(t *testing.T) {
+	record := totalsRecord{
+		Name:  "totals",
+		Tags:  map[string]int{"a": 1, "b": 2, "c": 3},
+		Items: []totalsItem{{1, "one"}, {2, "two"}, {3, "three"}},
+	}
+	b := &Buffer{}
+	NewEncoder[totalsRecord]().Marshal(&record, b)
+
+	decode := func(limits DecodeLimits) error {
+		var out totalsRecord
+		return NewDecoderWithLimits[totalsRecord](limits).Unmarshal(b.Bytes, &out)
+	}
+
+	t.Run("MapEntries", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxMapEntries = 3
+		if err := decode(limits); err != nil {
+			t.Fatalf("expected the map to decode within the limit, got %v", err)
+		}
+		limits.MaxMapEntries = 2
+		if err := decode(limits); !errors.Is(err, ErrLimitExceeded) || !strings.Contains(err.Error(), "map length") {
+			t.Errorf("expected the map limit to be exceeded, got %v", err)
+		}
+	})
+
+	t.Run("TotalFields", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxTotalFields = 9 // the record's own and two for each item
+		if err := decode(limits); err != nil {
+			t.Fatalf("expected the document to decode within the limit, got %v", err)
+		}
+		limits.MaxTotalFields = 8
+		if err := decode(limits); !errors.Is(err, ErrLimitExceeded) || !strings.Contains(err.Error(), "total fields") {
+			t.Errorf("expected the total fields limit to be exceeded, got %v", err)
+		}
+	})
+
+	t.Run("DecodedSize", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxDecodedSize = 1024
+		if err := decode(limits); err != nil {
+			t.Fatalf("expected the document to decode within the limit, got %v", err)
+		}
+
+		// a sparse slice is a few bytes on the wire however long it is
+		type sparse struct {
+			Values []int `glint:"values,sparse"`
+		}
+		sb := &Buffer{}
+		NewEncoder[sparse]().Marshal(&sparse{Values: make([]int, 1000)}, sb)
+
+		limits.MaxDecodedSize = 4096
+		var out sparse
+		err := NewDecoderWithLimits[sparse](limits).Unmarshal(sb.Bytes, &out)
+		if !errors.Is(err, ErrLimitExceeded) || !strings.Contains(err.Error(), "decoded size") {
+			t.Errorf("expected the decoded size limit to be exceeded, got %v", err)
+		}
+		if out.Values != nil {
+			t.Errorf("expected nothing to be decoded, got %d values", len(out.Values))
+		}
+	})
+
+	t.Run("TrustedSchema", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxTotalFields = 8
+		dec := NewDecoderWithLimits[totalsRecord](limits)
+
+		trusted := &Buffer{TrustedSchema: true}
+		NewEncoder[totalsRecord]().Marshal(&record, trusted)
+
+		var out totalsRecord
+		if err := dec.Unmarshal(b.Bytes, &out); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("expected the total fields limit to be exceeded, got %v", err)
+		}
+		if err := dec.Unmarshal(trusted.Bytes, &out); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected the document without a schema to be counted too, got %v", err)
+		}
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		dec := NewDecoder[totalsRecord]()
+
+		var out totalsRecord
+		if err := dec.UnmarshalContext(context.Background(), b.Bytes, &out); err != nil || !reflect.DeepEqual(out, record) {
+			t.Fatalf("expected the document to decode, got %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		out = totalsRecord{}
+		if err := dec.UnmarshalContext(ctx, b.Bytes, &out); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the decode to be cancelled, got %v", err)
+		}
+		if err := dec.UnmarshalWithContext(b.Bytes, &out, DecoderContext{Context: ctx}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the decode to be cancelled, got %v", err)
+		}
+		if out.Name != "" {
+			t.Errorf("expected nothing to be decoded, got %q", out.Name)
+		}
+	})
+}
+
+type arenaChild struct {
+	Label string `glint:"label"`
+	Score int32  `glint:"score"`
+}
+
+type arenaRecord struct {
+	Name     string       `glint:"name"`
+	Tags     []string     `glint:"tags"`
+	Counts   []int        `glint:"counts"`
+	Data     []byte       `glint:"data"`
+	Children []arenaChild `glint:"children"`
+	Parent   *arenaChild  `glint:"parent"`
+	Sizes    []float64    `glint:"sizes"`
+}
+
+func TestArena(t *testing.T) {
+	record := arenaRecord{
+		Name:     "arena",
+		Tags:     []string{"a", "bb", "ccc"},
+		Counts:   []int{1, 2, 3, 4},
+		Data:     []byte{9, 8, 7},
+		Children: []arenaChild{{"one", 1}, {"two", 2}},
+		Parent:   &arenaChild{"parent", 3},
+		Sizes:    []float64{1.5, 2.5},
+	}
+	b := &Buffer{}
+	NewEncoder[arenaRecord]().Marshal(&record, b)
+
+	dec := NewDecoder[arenaRecord]()
+	arena := NewArena(4096)
+
+	for i := 0; i < 3; i++ { // memory handed out again after a Reset decodes the same
+		doc := append([]byte{}, b.Bytes...)
+
+		var out arenaRecord
+		if err := dec.UnmarshalWithContext(doc, &out, DecoderContext{Arena: arena}); err != nil {
+			t.Fatal(err)
+		}
+		for j := range doc { // arena values are copies, so they don't change with the document
+			doc[j] = 0
+		}
+		if !reflect.DeepEqual(out, record) {
+			t.Fatalf("pass %d: got %+v, want %+v", i, out, record)
+		}
+		arena.Reset()
+	}
+
+	decode := func(ctx DecoderContext) float64 {
+		return testing.AllocsPerRun(100, func() {
+			var out arenaRecord
+			if err := dec.UnmarshalWithContext(b.Bytes, &out, ctx); err != nil {
+				t.Fatal(err)
+			}
+			if ctx.Arena != nil {
+				ctx.Arena.Reset()
+			}
+		})
+	}
+	with, without := decode(DecoderContext{Arena: NewArena(64 * 1024)}), decode(DecoderContext{})
+	if with >= without {
+		t.Errorf("expected fewer allocations with an arena, got %v with and %v without", with, without)
+	}
+}
+
+type copiesChild struct {
+	Label string `glint:"label"`
+}
+
+type copiesRecord struct {
+	Name  string      `glint:"name"`
+	Note  string      `glint:"note,copy"`
+	Data  []byte      `glint:"data,copy"`
+	Child copiesChild `glint:"child,copy"`
+	Tags  []string    `glint:"tags,copy"`
+	Raw   []byte      `glint:"raw"`
+}
+
+func TestStringCopies(t *testing.T) {
+	record := copiesRecord{
+		Name:  "name",
+		Note:  "note",
+		Data:  []byte{1, 2, 3},
+		Child: copiesChild{"label"},
+		Tags:  []string{"a", "b"},
+		Raw:   []byte{4, 5, 6},
+	}
+	b := &Buffer{}
+	NewEncoder[copiesRecord]().Marshal(&record, b)
+
+	// decode clobbers the document once it's decoded, so only what was copied out of it survives
+	decode := func(opts ...DecoderOption) copiesRecord {
+		doc := append([]byte{}, b.Bytes...)
+		var out copiesRecord
+		if err := NewDecoder[copiesRecord](opts...).Unmarshal(doc, &out); err != nil {
+			t.Fatal(err)
+		}
+		for i := range doc {
+			doc[i] = 'x'
+		}
+		return out
+	}
+
+	t.Run("Tagged", func(t *testing.T) {
+		out := decode()
+		if out.Note != record.Note || !bytes.Equal(out.Data, record.Data) || out.Child != record.Child || !reflect.DeepEqual(out.Tags, record.Tags) {
+			t.Errorf("expected fields tagged copy to be copied, got %+v", out)
+		}
+		if bytes.Equal(out.Raw, record.Raw) {
+			t.Errorf("expected byte slices without the tag to share the document's memory, got %v", out.Raw)
+		}
+	})
+
+	t.Run("Copied", func(t *testing.T) {
+		out := decode(WithCopiedStrings())
+		if !reflect.DeepEqual(out, record) {
+			t.Errorf("expected every field to be copied, got %+v", out)
+		}
+	})
+
+	t.Run("ZeroCopy", func(t *testing.T) {
+		out := decode(WithZeroCopyStrings())
+		if bytes.Equal(out.Data, record.Data) || bytes.Equal(out.Raw, record.Raw) {
+			t.Errorf("expected byte slices to share the document's memory, got %v and %v", out.Data, out.Raw)
+		}
+	})
+}
+
+// recordingSink is a MetricsSink that keeps everything reported to it
+type recordingSink struct {
+	mu      sync.Mutex
+	encoded []int
+	decoded []error
+	sizes   []int
+	hits    []bool
+	pooled  int
+
+	duplicates []string
+}
+
+func (s *recordingSink) Encoded(typeName string, bytes int, took time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoded = append(s.encoded, bytes)
+}
+
+func (s *recordingSink) Decoded(typeName string, bytes int, took time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decoded = append(s.decoded, err)
+	s.sizes = append(s.sizes, bytes)
+}
+
+func (s *recordingSink) SchemaCache(typeName string, hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits = append(s.hits, hit)
+}
+
+func (s *recordingSink) PooledBuffer(typeName string, reused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pooled++
+}
+
+func (s *recordingSink) DuplicateMapKey(typeName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.duplicates = append(s.duplicates, typeName)
+}
+
+func TestMetrics(t *testing.T) {
+	sink := &recordingSink{}
+	enc := NewEncoder[totalsRecord](WithMetrics(sink))
+	dec := NewDecoder[totalsRecord](WithDecoderMetrics(sink))
+
+	record := totalsRecord{Name: "metrics", Items: []totalsItem{{1, "one"}}}
+	b := &Buffer{}
+	enc.Marshal(&record, b)
+
+	var w bytes.Buffer
+	if _, err := enc.MarshalTo(&w, &record); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{len(b.Bytes), w.Len()}; !reflect.DeepEqual(sink.encoded, want) {
+		t.Errorf("expected encoded sizes %v, got %v", want, sink.encoded)
+	}
+	if sink.pooled != 1 {
+		t.Errorf("expected MarshalTo to report its pooled buffer, got %d", sink.pooled)
+	}
+
+	var out totalsRecord
+	for i := 0; i < 2; i++ {
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dec.Unmarshal(b.Bytes[:len(b.Bytes)-2], &out); err == nil {
+		t.Fatal("expected the truncated document to fail")
+	}
+
+	if len(sink.decoded) != 3 || sink.decoded[0] != nil || sink.decoded[1] != nil || !errors.Is(sink.decoded[2], ErrTruncatedDocument) {
+		t.Errorf("expected two decodes and a failure, got %v", sink.decoded)
+	}
+	if sink.sizes[0] != len(b.Bytes) {
+		t.Errorf("expected decoded size %d, got %d", len(b.Bytes), sink.sizes[0])
+	}
+	if want := []bool{false, true, true}; !reflect.DeepEqual(sink.hits, want) {
+		t.Errorf("expected schema cache hits %v, got %v", want, sink.hits)
+	}
+}
+
+func TestBufferPoolClasses(t *testing.T) {
+	before := ReadBufferPoolStats()
+
+	huge := &Buffer{Bytes: make([]byte, 0, 2<<20)}
+	huge.ReturnToPool()
+	medium := &Buffer{Bytes: make([]byte, 0, 10<<10)}
+	medium.ReturnToPool()
+
+	after := ReadBufferPoolStats()
+	if after.Dropped != before.Dropped+1 {
+		t.Errorf("expected the huge buffer to be dropped, got %d dropped before and %d after", before.Dropped, after.Dropped)
+	}
+	if len(after.Classes) != 3 || after.Classes[1].Returned != before.Classes[1].Returned+1 {
+		t.Errorf("expected the medium buffer to be returned to the medium class, got %+v", after.Classes)
+	}
+
+	b := NewBufferFromPoolWithCap(5 << 10) // never the small class, whose buffers can't hold it
+	if cap(b.Bytes) < 5<<10 || len(b.Bytes) != 0 {
+		t.Errorf("expected an empty buffer of at least the capacity asked for, got len %d cap %d", len(b.Bytes), cap(b.Bytes))
+	}
+	b.ReturnToPool()
+
+	t.Run("Grow", func(t *testing.T) {
+		b := &Buffer{Bytes: []byte{1, 2}}
+		b.Grow(100)
+		if len(b.Bytes) != 2 || cap(b.Bytes) < 102 || b.Bytes[1] != 2 {
+			t.Fatalf("expected room for 100 more bytes, got len %d cap %d", len(b.Bytes), cap(b.Bytes))
+		}
+
+		grown := cap(b.Bytes)
+		b.Grow(10) // already has room
+		if cap(b.Bytes) != grown {
+			t.Errorf("expected the buffer to be left alone, got cap %d from %d", cap(b.Bytes), grown)
+		}
+	})
+}
+
+// countingWriter records the size of every write made to it
+type countingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestSpillBuffer(t *testing.T) {
+	type row struct {
+		ID     int    `glint:"id"`
+		Region string `glint:"region,dict"`
+	}
+	type batch struct {
+		Name string `glint:"name"`
+		Rows []row  `glint:"rows"`
+	}
+
+	v := batch{Name: "batch"}
+	for i := 0; i < 2000; i++ {
+		v.Rows = append(v.Rows, row{ID: i, Region: []string{"eu", "us"}[i%2]})
+	}
+
+	for _, name := range []string{"Plain", "Dict"} {
+		t.Run(name, func(t *testing.T) {
+			in := v
+			if name == "Plain" {
+				in.Rows = append([]row{}, v.Rows...)
+				for i := range in.Rows {
+					in.Rows[i].Region = ""
+				}
+			}
+
+			w := &countingWriter{}
+			s := NewSpillBuffer(w, 1024)
+			enc := NewEncoder[batch]()
+			if err := enc.MarshalSpill(&in, s); err != nil {
+				t.Fatal(err)
+			}
+			if s.Buffered() > 1024 {
+				t.Errorf("expected no more than the threshold to be held, got %d", s.Buffered())
+			}
+			if err := s.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if len(w.writes) < 2 || s.Written() != int64(w.Len()) {
+				t.Errorf("expected the document to be written as it was encoded, got writes %v", w.writes)
+			}
+
+			var out batch
+			if err := NewDecoder[batch]().Unmarshal(w.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, in) {
+				t.Errorf("expected the spilled document to decode to what was encoded")
+			}
+		})
+	}
+
+	t.Run("Compressed", func(t *testing.T) {
+		w := &countingWriter{}
+		s := NewSpillBuffer(w, 1024)
+		if err := NewEncoder[batch](WithCompression(NewDeflateCompressor(flate.BestSpeed))).MarshalSpill(&v, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		var out batch
+		if err := NewDecoder[batch]().Unmarshal(w.Bytes(), &out); err != nil || !reflect.DeepEqual(out, v) {
+			t.Errorf("expected the compressed document to decode, got %v", err)
+		}
+	})
+
+	t.Run("WriteError", func(t *testing.T) {
+		s := NewSpillBuffer(failingWriter{}, 1024)
+		if err := NewEncoder[batch]().MarshalSpill(&v, s); !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("expected the writer's error, got %v", err)
+		}
+		if s.Written() != 0 || s.Buffered() > 1024 {
+			t.Errorf("expected nothing more to be written or held once writing failed")
+		}
+	})
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestPackageMarshal(t *testing.T) {
+	type item struct {
+		Name string `glint:"name"`
+		Qty  int    `glint:"qty"`
+	}
+	type order struct {
+		ID    uint64 `glint:"id"`
+		Items []item `glint:"items"`
+	}
+	in := order{ID: 7, Items: []item{{"pen", 2}, {"ink", 1}}}
+
+	byValue, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPointer, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(byValue, byPointer) {
+		t.Errorf("expected values and pointers to them to marshal the same")
+	}
+
+	var out order
+	if err := Unmarshal(byValue, &out); err != nil {
+		t.Fatal(err)
+	}
+	for i := range byValue {
+		byValue[i] = 0 // strings are copied out of the document
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	// documents written by an Encoder of the same type decode, and the other way round
+	doc := NewEncoder[order]()
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+	doc.Marshal(&in, b)
+	out = order{}
+	if err := Unmarshal(b.Bytes, &out); err != nil || !reflect.DeepEqual(out, in) {
+		t.Errorf("expected an Encoder's document to decode, got %+v, %v", out, err)
+	}
+
+	m := map[string]item{"a": {"pen", 2}}
+	mdoc, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mout map[string]item
+	if err := Unmarshal(mdoc, &mout); err != nil || !reflect.DeepEqual(mout, m) {
+		t.Errorf("expected map roots to round trip, got %v, %v", mout, err)
+	}
+
+	for _, v := range []any{nil, (*order)(nil), 42, []order{}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("expected marshalling %T to fail", v)
+		}
+	}
+	for _, v := range []any{nil, out, (*order)(nil), new(int)} {
+		if err := Unmarshal(byPointer, v); err == nil {
+			t.Errorf("expected unmarshalling into %T to fail", v)
+		}
+	}
+	if err := Unmarshal(byPointer[:3], &out); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("expected ErrInvalidDocument for a truncated document, got %v", err)
+	}
+}
+
+func TestMarshalBytes(t *testing.T) {
+	type user struct {
+		Name string `glint:"name"`
+		Age  int    `glint:"age"`
+	}
+	enc := NewEncoder[user]()
+	dec := NewDecoder[user]()
+
+	alice, bob := user{"alice", 30}, user{"bob", 40}
+	a := enc.MarshalBytes(&alice)
+	b := enc.MarshalBytes(&bob)
+
+	var out user
+	if err := dec.Unmarshal(a, &out); err != nil || out != alice {
+		t.Errorf("expected the first document to be left as it was by the second, got %+v, %v", out, err)
+	}
+	if err := dec.Unmarshal(b, &out); err != nil || out != bob {
+		t.Errorf("expected %+v, got %+v, %v", bob, out, err)
+	}
+
+	prefix := []byte("hdr")
+	buf := enc.MarshalAppend(prefix, &alice)
+	if string(buf[:3]) != "hdr" || !bytes.Equal(buf[3:], a) {
+		t.Errorf("expected the document to be appended after the existing bytes")
+	}
+
+	buf = make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = enc.MarshalAppend(buf[:0], &alice)
+	})
+	if allocs != 0 {
+		t.Errorf("expected appending into a slice with room not to allocate, got %v allocs", allocs)
+	}
+}
+
+func TestTagFallback(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+	}
+	type customer struct {
+		ID       int      `json:"id"`
+		Name     string   `glint:"full_name" json:"name"`
+		Email    string   `json:",omitempty"`
+		Password string   `json:"-"`
+		Notes    string   // untagged fields are left out
+		Tags     []string `glint:"tags,dict" json:"labels"`
+		Address  address  `json:"address"`
+	}
+
+	in := customer{ID: 1, Name: "Ann", Email: "ann@example.com", Password: "secret", Notes: "n", Tags: []string{"a", "a"}, Address: address{"Leeds"}}
+	doc := NewEncoderUsingTagFallback[customer]("glint", "json").MarshalBytes(&in)
+
+	p, err := splitDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range parseSchemaNodes(p.schema) {
+		names = append(names, f.name)
+	}
+	if want := []string{"id", "full_name", "Email", "tags", "address"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected fields %v, got %v", want, names)
+	}
+
+	var out customer
+	if err := NewDecoderUsingTagFallback[customer]("glint", "json").Unmarshal(doc, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := in
+	want.Password, want.Notes = "", ""
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("expected %+v, got %+v", want, out)
+	}
+}
+
+func TestNameMatching(t *testing.T) {
+	type producerAddress struct {
+		PostCode string `glint:"postCode"`
+	}
+	type producer struct {
+		FirstName string                     `glint:"firstName"`
+		LastName  string                     `glint:"last_name"`
+		ID        int                        `glint:"ID"`
+		Homes     []producerAddress          `glint:"Homes"`
+		Work      map[string]producerAddress `glint:"work"`
+	}
+	type address struct {
+		PostCode string `glint:"postcode"`
+	}
+	type consumer struct {
+		FirstName string             `glint:"firstname"`
+		LastName  string             `glint:"lastName"`
+		ID        int                `glint:"id"`
+		Homes     []address          `glint:"homes"`
+		Work      map[string]address `glint:"work"`
+	}
+
+	in := producer{"Ann", "Lee", 7, []producerAddress{{"LS1"}}, map[string]producerAddress{"hq": {"M1"}}}
+	doc := NewEncoder[producer]().MarshalBytes(&in)
+
+	var exact consumer
+	if err := NewDecoder[consumer]().Unmarshal(doc, &exact); err != nil {
+		t.Fatal(err)
+	}
+	if exact.FirstName != "" || exact.ID != 0 || exact.Work["hq"].PostCode != "" {
+		t.Errorf("expected names differing in case not to match by default, got %+v", exact)
+	}
+
+	var folded consumer
+	if err := NewDecoder[consumer](WithCaseInsensitiveNames()).Unmarshal(doc, &folded); err != nil {
+		t.Fatal(err)
+	}
+	want := consumer{"Ann", "", 7, []address{{"LS1"}}, map[string]address{"hq": {"M1"}}}
+	if !reflect.DeepEqual(folded, want) {
+		t.Errorf("expected %+v, got %+v", want, folded)
+	}
+
+	var styled consumer
+	if err := NewDecoder[consumer](WithStyleInsensitiveNames()).Unmarshal(doc, &styled); err != nil {
+		t.Fatal(err)
+	}
+	want.LastName = "Lee"
+	if !reflect.DeepEqual(styled, want) {
+		t.Errorf("expected %+v, got %+v", want, styled)
+	}
+
+	// tags that fold to the same name only match exactly
+	type ambiguous struct {
+		Lower string `glint:"name"`
+		Upper string `glint:"NAME"`
+	}
+	type named struct {
+		Name string `glint:"Name"`
+	}
+	var amb ambiguous
+	if err := NewDecoder[ambiguous](WithCaseInsensitiveNames()).Unmarshal(NewEncoder[named]().MarshalBytes(&named{"x"}), &amb); err != nil || amb != (ambiguous{}) {
+		t.Errorf("expected an ambiguous name to be skipped, got %+v, %v", amb, err)
+	}
+}
+
+func TestFieldAliases(t *testing.T) {
+	type v1 struct {
+		Name  string `glint:"name"`
+		Email string `glint:"email_address_primary"`
+	}
+	type v2 struct {
+		FullName string `glint:"full_name,alias=name,alias=display_name,required"`
+		Email    string `glint:"email,alias=email_address_primary"`
+	}
+
+	var out v2
+	if err := NewDecoder[v2]().Unmarshal(NewEncoder[v1]().MarshalBytes(&v1{"Ann", "ann@example.com"}), &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := (v2{"Ann", "ann@example.com"}); out != want {
+		t.Errorf("expected %+v, got %+v", want, out)
+	}
+
+	type display struct {
+		Name string `glint:"display_name"`
+	}
+	out = v2{}
+	if err := NewDecoder[v2]().Unmarshal(NewEncoder[display]().MarshalBytes(&display{"Bo"}), &out); err != nil || out.FullName != "Bo" {
+		t.Errorf("expected the second alias to decode too, got %+v, %v", out, err)
+	}
+
+	out = v2{}
+	if err := NewDecoder[v2]().Unmarshal(NewEncoder[v2]().MarshalBytes(&v2{"Cy", "c"}), &out); err != nil || out.FullName != "Cy" {
+		t.Errorf("expected the current name to decode, got %+v, %v", out, err)
+	}
+
+	// aliases fold like tags do
+	type camel struct {
+		Name string `glint:"displayName"`
+	}
+	out = v2{}
+	if err := NewDecoder[v2](WithStyleInsensitiveNames()).Unmarshal(NewEncoder[camel]().MarshalBytes(&camel{"Di"}), &out); err != nil || out.FullName != "Di" {
+		t.Errorf("expected a folded alias to decode, got %+v, %v", out, err)
+	}
+
+	type clash struct {
+		A string `glint:"a,alias=b"`
+		B string `glint:"b"`
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected an alias naming another field to panic")
+		}
+	}()
+	NewDecoder[clash]()
+}
+
+func TestNumericCoercion(t *testing.T) {
+	type inner struct {
+		Count int16 `glint:"count"`
+	}
+	type before struct {
+		Small  int8    `glint:"small"`
+		Medium int32   `glint:"medium"`
+		Size   uint32  `glint:"size"`
+		Ratio  float32 `glint:"ratio"`
+		Ptr    *int32  `glint:"ptr"`
+		Inner  inner   `glint:"inner"`
+		Items  []inner `glint:"items"`
+	}
+	type innerAfter struct {
+		Count int64 `glint:"count"`
+	}
+	type after struct {
+		Small  float32      `glint:"small"`
+		Medium int64        `glint:"medium"`
+		Size   int64        `glint:"size"`
+		Ratio  float64      `glint:"ratio"`
+		Ptr    *int64       `glint:"ptr"`
+		Inner  innerAfter   `glint:"inner"`
+		Items  []innerAfter `glint:"items"`
+	}
+
+	p := int32(-9)
+	doc := NewEncoder[before]().MarshalBytes(&before{-3, -70000, 4000000000, 1.5, &p, inner{-2}, []inner{{5}}})
+
+	var out after
+	var typeErr *FieldTypeError
+	if err := NewDecoder[after]().Unmarshal(doc, &out); !errors.As(err, &typeErr) {
+		t.Errorf("expected a *FieldTypeError without coercion, got %v", err)
+	}
+
+	out = after{}
+	if err := NewDecoder[after](WithNumericCoercion()).Unmarshal(doc, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Small != -3 || out.Medium != -70000 || out.Size != 4000000000 || out.Ratio != 1.5 || out.Ptr == nil || *out.Ptr != -9 ||
+		out.Inner.Count != -2 || len(out.Items) != 1 || out.Items[0].Count != 5 {
+		t.Errorf("expected every field to be widened, got %+v", out)
+	}
+
+	// narrowing and signed to unsigned can lose values, so remain errors
+	type narrow struct {
+		Medium int16 `glint:"medium"`
+	}
+	type unsigned struct {
+		Small uint64 `glint:"small"`
+	}
+	if err := NewDecoder[narrow](WithNumericCoercion()).Unmarshal(doc, &narrow{}); !errors.As(err, &typeErr) {
+		t.Errorf("expected narrowing to fail, got %v", err)
+	}
+	if err := NewDecoder[unsigned](WithNumericCoercion()).Unmarshal(doc, &unsigned{}); !errors.As(err, &typeErr) {
+		t.Errorf("expected signed to unsigned to fail, got %v", err)
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	type innerV2 struct {
+		City    string `glint:"city"`
+		Country string `glint:"country"`
+	}
+	type v2 struct {
+		Name    string    `glint:"name"`
+		Extra   []int     `glint:"extra"`
+		Address innerV2   `glint:"address"`
+		Homes   []innerV2 `glint:"homes"`
+	}
+	type inner struct {
+		City string `glint:"city"`
+	}
+	type v1 struct {
+		Name    string  `glint:"name"`
+		Address inner   `glint:"address"`
+		Homes   []inner `glint:"homes"`
+	}
+	type v1Extra struct {
+		Name    string  `glint:"name"`
+		Extra   []int   `glint:"extra"`
+		Address inner   `glint:"address"`
+		Homes   []inner `glint:"homes"`
+	}
+
+	doc := NewEncoder[v2]().MarshalBytes(&v2{Name: "a", Extra: []int{1}, Address: innerV2{"x", "y"}, Homes: []innerV2{{"p", "q"}}})
+
+	if err := NewDecoder[v1]().Unmarshal(doc, &v1{}); err != nil {
+		t.Errorf("expected unknown fields to be skipped by default, got %v", err)
+	}
+
+	err := NewDecoder[v1](WithDisallowUnknownFields()).Unmarshal(doc, &v1{})
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), `"extra"`) {
+		t.Errorf("expected the unknown top level field to be refused, got %v", err)
+	}
+
+	// nested structs, and structs in slices, are held to the same
+	err = NewDecoder[v1Extra](WithDisallowUnknownFields()).Unmarshal(doc, &v1Extra{})
+	var pathErr *PathError
+	if !errors.Is(err, ErrUnknownField) || !errors.As(err, &pathErr) || pathErr.Path != "address" {
+		t.Errorf("expected the nested unknown field to be refused at its path, got %v", err)
+	}
+
+	type v1Address struct {
+		Name    string  `glint:"name"`
+		Extra   []int   `glint:"extra"`
+		Address innerV2 `glint:"address"`
+		Homes   []inner `glint:"homes"`
+	}
+	if err := NewDecoder[v1Address](WithDisallowUnknownFields()).Unmarshal(doc, &v1Address{}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("expected an unknown field of slice elements to be refused, got %v", err)
+	}
+
+	var all v2
+	if err := NewDecoder[v2](WithDisallowUnknownFields()).Unmarshal(doc, &all); err != nil || all.Address.Country != "y" {
+		t.Errorf("expected a document without unknown fields to decode, got %+v, %v", all, err)
+	}
+
+	err = NewDecoder[v1](WithDisallowUnknownFields()).CollectErrors().Unmarshal(doc, &v1{})
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 3 {
+		t.Errorf("expected every unknown field to be collected, got %v", err)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	type address struct {
+		City string `glint:"city,pattern=^[A-Z]"`
+		Zone int    `glint:"zone,min=1,max=9"`
+	}
+	type record struct {
+		ID       int64             `glint:"id"`
+		Tags     []string          `glint:"tags,dict"`
+		Readings []int32           `glint:"readings,delta"`
+		Address  address           `glint:"address"`
+		Homes    []address         `glint:"homes"`
+		Counts   map[string]*int32 `glint:"counts"`
+		Offset   int64             `glint:"offset,zigzag"`
+		Grid     [][]float64       `glint:"grid"`
+	}
+
+	enc := NewEncoder[record]()
+	s, err := ParseSchema(enc.MarshalBytes(&record{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id int64\n" +
+		"tags []string dict\n" +
+		"readings []int32 delta\n" +
+		"address struct {\n\tcity string pattern=^[A-Z]\n\tzone int min=1,max=9\n}\n" +
+		"homes []struct {\n\tcity string pattern=^[A-Z]\n\tzone int min=1,max=9\n}\n" +
+		"counts map[string]*int32\n" +
+		"offset int64 zigzag\n" +
+		"grid [][]float64\n"
+	if s.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, s)
+	}
+
+	if f := s.Field("readings"); f == nil || f.Wire&WireDeltaFlag == 0 || f.Wire&WireSliceFlag == 0 || f.Elem.Wire != WireInt32 {
+		t.Errorf("expected readings to be a delta encoded slice of int32s, got %+v", f)
+	}
+	if f := s.Field("homes"); f == nil || len(f.Fields) != 2 || f.Fields[1].Min != "1" || f.Fields[1].Max != "9" {
+		t.Errorf("expected the fields and constraints of slice elements, got %+v", f)
+	}
+	if f := s.Field("counts"); f == nil || f.Key.Wire != WireString || f.Value.Wire != WireInt32|WirePtrFlag {
+		t.Errorf("expected the key and value types of the map, got %+v", f)
+	}
+	if s.Field("missing") != nil {
+		t.Errorf("expected no field for a name the schema doesn't have")
+	}
+
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+	enc.Marshal(&record{}, b)
+	if s.Hash != Document(b.Bytes).Hash() {
+		t.Errorf("expected the schema hash from the header")
+	}
+
+	b.Reset()
+	b.TrustedSchema = true
+	enc.Marshal(&record{}, b)
+	if _, err := ParseSchema(b.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("expected ErrSchemaNotFound for a document without its schema, got %v", err)
+	}
+	if _, err := ParseSchema([]byte{0, 1, 2}); err == nil {
+		t.Errorf("expected an error for a malformed document")
+	}
+}
+
+func TestParseSchemaBytes(t *testing.T) {
+	type inner struct {
+		A int `glint:"a"`
+	}
+	type doc struct {
+		ID    int64   `glint:"id,min=1"`
+		Items []inner `glint:"items"`
+		More  []inner `glint:"more"`
+	}
+
+	enc := NewEncoder[doc]()
+	fromDoc, err := ParseSchema(enc.MarshalBytes(&doc{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromSchema, err := ParseSchemaBytes(enc.Schema().Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromDoc.String() != fromSchema.String() {
+		t.Errorf("schema bytes parsed as\n%s\nwant\n%s", fromSchema, fromDoc)
+	}
+
+	if _, err := ParseSchemaBytes([]byte{5, 1}); err == nil {
+		t.Error("expected an error for malformed schema bytes")
+	}
+
+	empty, err := ParseSchema((&DocumentBuilder{}).Bytes())
+	if err != nil || len(empty.Fields) != 0 {
+		t.Errorf("empty document parsed as %v, %v", empty, err)
+	}
+
+	trusted := &Buffer{TrustedSchema: true}
+	enc.Marshal(&doc{}, trusted)
+	if _, err := ParseSchema(trusted.Bytes); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("expected ErrSchemaNotFound for a trusted schema document, got %v", err)
+	}
+}
+
+var (
+	updateConformance = flag.Bool("update-conformance", false, "rewrite the conformance corpus in testdata/conformance")
+	conformanceClient = flag.String("conformance-client", "", "run the conformance corpus against this command instead of the Go decoder, see testdata/conformance/README.md")
+	conformanceSkip   = flag.String("conformance-skip", "", "comma separated features of the conformance corpus the client doesn't support")
+)
+
+// conformanceVersion is the version of the corpus in testdata/conformance. It goes up whenever cases are changed
+// or removed, rather than added, so clients can tell when expectations they pass have moved.
+const conformanceVersion = 1
+
+// conformanceManifest is the layout of testdata/conformance/manifest.json
+type conformanceManifest struct {
+	Version int               `json:"version"`
+	Cases   []conformanceCase `json:"cases"`
+}
+
+// conformanceCase is a document of the conformance corpus and what a client must make of it. A client either
+// reads Document as the JSON in Expect, or fails to read it when Error describes why it can't be read.
+type conformanceCase struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Document    string   `json:"document"`
+	Expect      string   `json:"expect,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	Features    []string `json:"features"`
+
+	write func() []byte // writes the document, or nil for documents owned by other tests
+}
+
+type conformanceScalars struct {
+	Bool     bool          `glint:"bool"`
+	Int      int           `glint:"int"`
+	Int8     int8          `glint:"int8"`
+	Int16    int16         `glint:"int16"`
+	Int32    int32         `glint:"int32"`
+	Int64    int64         `glint:"int64"`
+	Uint     uint          `glint:"uint"`
+	Uint8    uint8         `glint:"uint8"`
+	Uint16   uint16        `glint:"uint16"`
+	Uint32   uint32        `glint:"uint32"`
+	Uint64   uint64        `glint:"uint64"`
+	Float32  float32       `glint:"float32"`
+	Float64  float64       `glint:"float64"`
+	String   string        `glint:"string"`
+	Bytes    []byte        `glint:"bytes"`
+	Time     time.Time     `glint:"time"`
+	Zone     time.Time     `glint:"zone"`
+	Duration time.Duration `glint:"duration"`
+	IP4      netip.Addr    `glint:"ip4"`
+	IP6      netip.Addr    `glint:"ip6"`
+	Prefix   netip.Prefix  `glint:"prefix"`
+	BigInt   big.Int       `glint:"bigint"`
+	Decimal  Decimal       `glint:"decimal"`
+}
+
+type conformanceCollections struct {
+	Ints     []int             `glint:"ints"`
+	Strings  []string          `glint:"strings"`
+	Floats   []float64         `glint:"floats"`
+	Bools    []bool            `glint:"bools"`
+	Times    []time.Time       `glint:"times"`
+	Matrix   [][]int           `glint:"matrix"`
+	Items    []Child           `glint:"items"`
+	Map      map[string]int    `glint:"map"`
+	Nested   map[string]Child  `glint:"nested"`
+	Lists    map[int32][]int64 `glint:"lists"`
+	Array    [3]uint8          `glint:"array"`
+	Children []map[string]int  `glint:"children"`
+}
+
+type conformancePointers struct {
+	Nil      *Child   `glint:"nil"`
+	Child    *Child   `glint:"child"`
+	Int      *int     `glint:"int"`
+	String   *string  `glint:"string"`
+	NilSlice []int    `glint:"nil_slice"`
+	Empty    []int    `glint:"empty"`
+	NilBytes []byte   `glint:"nil_bytes"`
+	Time     *float64 `glint:"float"`
+}
+
+type conformanceEncodings struct {
+	Deltas  []int64   `glint:"deltas,delta"`
+	Uints   []uint32  `glint:"uints,delta"`
+	Floats  []float64 `glint:"floats,delta"`
+	Singles []float32 `glint:"singles,delta"`
+	Sparse  []int32   `glint:"sparse,sparse"`
+	Dense   []uint64  `glint:"dense,sparse"`
+	Bitmap  []bool    `glint:"bitmap,bitmap"`
+	Word    string    `glint:"word,dict"`
+	Words   []string  `glint:"words,dict"`
+	Zigzag  int64     `glint:"zigzag,zigzag"`
+	Zigzags []int64   `glint:"zigzags,zigzag"`
+}
+
+type conformanceShared struct {
+	ID    int64   `glint:"id,min=1,max=1000"`
+	Name  string  `glint:"name,pattern=^[a-z]+$"`
+	Home  Child   `glint:"home"`
+	Work  Child   `glint:"work"`
+	Other []Child `glint:"other"`
+}
+
+// conformanceCases is the conformance corpus, in the order of the manifest
+var conformanceCases = []conformanceCase{{
+	Name:        "scalars",
+	Description: "every scalar type at a value away from its zero",
+	Features:    []string{"time", "duration", "address", "big-int", "decimal"},
+	write: func() []byte {
+		v := conformanceScalars{
+			Bool: true, Int: -42, Int8: -8, Int16: -1600, Int32: 320000, Int64: -6400000000,
+			Uint: 42, Uint8: 255, Uint16: 1600, Uint32: 4000000000, Uint64: math.MaxUint64,
+			Float32: 3.25, Float64: -1234.5678, String: "héllo, wörld", Bytes: []byte{0, 1, 2, 254, 255},
+			Time:     time.Date(2021, 3, 4, 5, 6, 7, 891000, time.UTC),
+			Zone:     time.Date(1999, 12, 31, 23, 59, 59, 0, time.FixedZone("", -(5*3600+30*60))),
+			Duration: -90 * time.Second,
+			IP4:      netip.MustParseAddr("192.168.0.1"),
+			IP6:      netip.MustParseAddr("2001:db8::1"),
+			Prefix:   netip.MustParsePrefix("10.1.2.3/8"),
+			Decimal:  Decimal{Coefficient: big.NewInt(-12345), Exponent: -2},
+		}
+		v.BigInt.SetString("-123456789012345678901234567890", 10)
+		return NewEncoder[conformanceScalars]().MarshalBytes(&v)
+	},
+}, {
+	Name:        "zero",
+	Description: "every scalar type at its zero value",
+	Features:    []string{"time", "duration", "address", "big-int", "decimal"},
+	write: func() []byte {
+		return NewEncoder[conformanceScalars]().MarshalBytes(&conformanceScalars{})
+	},
+}, {
+	Name:        "collections",
+	Description: "slices, arrays and maps of scalars, structs and each other",
+	Features:    []string{"time"},
+	write: func() []byte {
+		return NewEncoder[conformanceCollections]().MarshalBytes(&conformanceCollections{
+			Ints:     []int{1, -2, 3},
+			Strings:  []string{"a", "", "c"},
+			Floats:   []float64{0.5, -1.25},
+			Bools:    []bool{true, false, true},
+			Times:    []time.Time{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+			Matrix:   [][]int{{1, 2}, {}, {3}},
+			Items:    []Child{{A: 3, B: "three"}, {A: 4, B: "four"}},
+			Map:      map[string]int{"x": 1},
+			Nested:   map[string]Child{"k": {A: 5, B: "five"}},
+			Lists:    map[int32][]int64{-7: {1, 2}},
+			Array:    [3]uint8{7, 8, 9},
+			Children: []map[string]int{{"y": 2}, {}},
+		})
+	},
+}, {
+	Name:        "pointers",
+	Description: "nil and present pointers, and nil and empty slices",
+	Features:    []string{},
+	write: func() []byte {
+		i, s := -5, "pointed"
+		return NewEncoder[conformancePointers](WithDistinctNilSlices()).MarshalBytes(&conformancePointers{
+			Child: &Child{A: 1, B: "one"}, Int: &i, String: &s, Empty: []int{},
+		})
+	},
+}, {
+	Name:        "encodings",
+	Description: "delta, sparse, bitmap, dictionary and zigzag encoded fields",
+	Features:    []string{"delta", "sparse", "bitmap", "string-table", "zigzag"},
+	write: func() []byte {
+		return NewEncoder[conformanceEncodings]().MarshalBytes(&conformanceEncodings{
+			Deltas:  []int64{1000, 1001, 999, -5, math.MaxInt64, math.MinInt64},
+			Uints:   []uint32{10, 5, 4000000000},
+			Floats:  []float64{20.5, 20.5, 20.75, -3, 1e300},
+			Singles: []float32{1.5, 1.5, -2.25},
+			Sparse:  []int32{0, 0, 7, 0, 0, 0, -9, 0},
+			Dense:   []uint64{1, 2, 0},
+			Bitmap:  []bool{true, false, false, true, true, false, true, false, true, true},
+			Word:    "repeated",
+			Words:   []string{"repeated", "other", "repeated"},
+			Zigzag:  -3,
+			Zigzags: []int64{-1, 1, math.MinInt64},
+		})
+	},
+}, {
+	Name:        "shared",
+	Description: "a repeated struct schema, field constraints and field name hashes",
+	Features:    []string{"schema-refs", "constraints", "name-hashes"},
+	write: func() []byte {
+		return NewEncoder[conformanceShared](WithNameHashes()).MarshalBytes(&conformanceShared{
+			ID: 7, Name: "abc", Home: Child{A: 1, B: "home"}, Work: Child{A: 2, B: "work"}, Other: []Child{{A: 3}},
+		})
+	},
+}, {
+	Name:        "compressed",
+	Description: "a body compressed with deflate",
+	Features:    []string{"deflate", "time"},
+	write: func() []byte {
+		v := conformanceCollections{Strings: []string{"compressed", "compressed", "compressed"}, Items: []Child{{A: 1}}}
+		return NewEncoder[conformanceCollections](WithCompression(NewDeflateCompressor(flate.BestCompression))).MarshalBytes(&v)
+	},
+}, {
+	Name:        "map_root",
+	Description: "a map at the root of the document",
+	Features:    []string{"map-root"},
+	write: func() []byte {
+		doc, err := Marshal(map[string]float64{"pi": 3.5})
+		if err != nil {
+			panic(err)
+		}
+		return doc
+	},
+}, {
+	Name:        "dynamic",
+	Description: "a slice whose elements each have their own wire type, nil and pointers among them",
+	Features:    []string{"dynamic", "duration", "decimal"},
+	write: func() []byte {
+		i, s := int32(-9), (*string)(nil)
+		var values SliceBuilder
+		values.AppendDynamicSlice([]any{
+			1, "a", true, nil, 2.5, float32(-0.75), int64(math.MinInt64), uint8(200), &i, s, 90 * time.Second,
+			Decimal{Coefficient: big.NewInt(314), Exponent: -2}, []string{"x", ""}, []uint8{3, 4}, []int16{-1, 1},
+			[]bool{true, false}, "last",
+		})
+		var empty SliceBuilder
+		empty.AppendDynamicSlice(nil)
+
+		var doc DocumentBuilder
+		doc.AppendString("name", "mixed").AppendSlice("values", values).AppendSlice("empty", empty)
+		b, err := doc.Build()
+		if err != nil {
+			panic(err)
+		}
+		return b
+	},
+}, {
+	Name:        "v0",
+	Description: "a version 0 document, kept in testdata/versions",
+	Document:    "../versions/v0/populated.glint",
+	Features:    []string{"delta", "time", "nanoseconds"},
+}, {
+	Name:        "v0_zero",
+	Description: "a version 0 document of zero values, whose slices and maps read as empty rather than nil",
+	Document:    "../versions/v0/zero.glint",
+	Features:    []string{"delta", "time"},
+}, {
+	Name:        "v1",
+	Description: "a version 1 document, with a repeated struct schema",
+	Document:    "../versions/v1/populated.glint",
+	Features:    []string{"delta", "time", "nanoseconds", "schema-refs"},
+}, {
+	Name:        "v2",
+	Description: "a version 2 document, with field constraints",
+	Document:    "../versions/v2/populated.glint",
+	Features:    []string{"delta", "time", "nanoseconds", "schema-refs", "constraints"},
+}, {
+	Name:        "v3",
+	Description: "a version 3 document, with field name hashes",
+	Document:    "../versions/v3/populated.glint",
+	Features:    []string{"delta", "time", "nanoseconds", "schema-refs", "constraints", "name-hashes"},
+}, {
+	Name:        "v4",
+	Description: "a version 4 document, with a slice of pointers to structs, one of them nil",
+	Document:    "../versions/v4/populated.glint",
+	Features:    []string{"delta", "time", "nanoseconds", "schema-refs", "constraints", "name-hashes", "pointer-slices"},
+}, {
+	Name:        "empty",
+	Description: "no bytes at all",
+	Error:       "too short",
+	write:       func() []byte { return []byte{} },
+}, {
+	Name:        "truncated_header",
+	Description: "a header cut off before its schema length",
+	Error:       "too short",
+	write:       func() []byte { return invalidConformanceDocument()[:3] },
+}, {
+	Name:        "truncated_schema",
+	Description: "a schema length running past the end of the document",
+	Error:       "schema length exceeds document",
+	write:       func() []byte { return invalidConformanceDocument()[:7] },
+}, {
+	Name:        "truncated_body",
+	Description: "a string in the body running past the end of the document",
+	Error:       "truncated body",
+	write: func() []byte {
+		doc := invalidConformanceDocument()
+		return doc[:len(doc)-2]
+	},
+}, {
+	Name:        "trailing_bytes",
+	Description: "a byte left over after the body",
+	Error:       "body bytes remaining",
+	write:       func() []byte { return append(invalidConformanceDocument(), 0) },
+}, {
+	Name:        "unknown_version",
+	Description: "a format version no decoder knows of",
+	Error:       "unsupported version",
+	write: func() []byte {
+		doc := invalidConformanceDocument()
+		doc[0] = 7
+		return doc
+	},
+}, {
+	Name:        "unknown_codec",
+	Description: "a body compressed with a codec no decoder knows of",
+	Error:       "unsupported codec",
+	write: func() []byte {
+		doc := invalidConformanceDocument()
+		doc[0] = 0xF0
+		return doc
+	},
+}}
+
+// invalidConformanceDocument returns the valid document the invalid cases of the corpus are broken from
+func invalidConformanceDocument() []byte {
+	return NewEncoder[Child]().MarshalBytes(&Child{A: 1, B: "one"})
+}
+
+// conformanceJSON returns the values of doc in the JSON form clients compare their own decoding with. Decimals
+// are their decimal strings, and addresses written without any bytes are null.
+func conformanceJSON(doc []byte) ([]byte, error) {
+	data, err := DocumentTemplateData(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalise func(v any) any
+	normalise = func(v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, e := range v {
+				v[k] = normalise(e)
+			}
+		case []any:
+			for i, e := range v {
+				v[i] = normalise(e)
+			}
+		case Decimal:
+			return v.String()
+		case netip.Addr:
+			if !v.IsValid() {
+				return nil
+			}
+		case netip.Prefix:
+			if !v.IsValid() {
+				return nil
+			}
+		}
+		return v
+	}
+
+	return json.MarshalIndent(normalise(data), "", "  ")
+}
+
+// canonicalJSON unmarshals b with its numbers made comparable however they were written, whole numbers exactly
+// and anything else as a float64
+func canonicalJSON(b []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var canonical func(v any) any
+	canonical = func(v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, e := range v {
+				v[k] = canonical(e)
+			}
+		case []any:
+			for i, e := range v {
+				v[i] = canonical(e)
+			}
+		case json.Number:
+			// whole numbers compare exactly however they're written, so 0, 0.0 and -0 are all the same
+			if r, ok := new(big.Rat).SetString(v.String()); ok && r.IsInt() {
+				return r.Num().String()
+			}
+			f, _ := v.Float64()
+			return f
+		}
+		return v
+	}
+	return canonical(v), nil
+}
+
+// TestConformance checks the decoder, or the client named by -conformance-client, against the conformance corpus
+// in testdata/conformance. With -update-conformance it first rewrites the corpus from conformanceCases.
+func TestConformance(t *testing.T) {
+	dir := filepath.Join("testdata", "conformance")
+
+	if *updateConformance {
+		manifest := conformanceManifest{Version: conformanceVersion}
+		for _, c := range conformanceCases {
+			if c.write != nil {
+				c.Document = c.Name + ".glint"
+				if err := os.WriteFile(filepath.Join(dir, c.Document), c.write(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.Error == "" {
+				doc, err := os.ReadFile(filepath.Join(dir, c.Document))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := conformanceJSON(doc)
+				if err != nil {
+					t.Fatalf("%s: %v", c.Name, err)
+				}
+				c.Expect = c.Name + ".json"
+				if err := os.WriteFile(filepath.Join(dir, c.Expect), append(b, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.Features == nil {
+				c.Features = []string{}
+			}
+			manifest.Cases = append(manifest.Cases, c)
+		}
+
+		b, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "manifest.json"), append(b, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest conformanceManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != conformanceVersion || len(manifest.Cases) != len(conformanceCases) {
+		t.Fatalf("manifest is version %d with %d cases, expected version %d with %d, run with -update-conformance",
+			manifest.Version, len(manifest.Cases), conformanceVersion, len(conformanceCases))
+	}
+
+	read := conformanceJSON
+	if *conformanceClient != "" {
+		read = func(doc []byte) ([]byte, error) {
+			args := strings.Fields(*conformanceClient)
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdin = bytes.NewReader(doc)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+			}
+			return out, nil
+		}
+	}
+
+	skip := map[string]bool{}
+	for _, feature := range strings.Split(*conformanceSkip, ",") {
+		skip[strings.TrimSpace(feature)] = true
+	}
+
+	for _, c := range manifest.Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			for _, feature := range c.Features {
+				if skip[feature] {
+					t.Skipf("client doesn't support %s", feature)
+				}
+			}
+
+			doc, err := os.ReadFile(filepath.Join(dir, c.Document))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			written, err := read(doc)
+			if c.Error != "" {
+				if err == nil {
+					t.Errorf("%s read without error, expected it to fail with %s", c.Document, c.Error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := os.ReadFile(filepath.Join(dir, c.Expect))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := canonicalJSON(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := canonicalJSON(written)
+			if err != nil {
+				t.Fatalf("reading the output for %s: %v", c.Document, err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s reads as\n%v\nwant\n%v", c.Document, got, expected)
+			}
+		})
+	}
+}
+
+// TestClientFixtures checks the documents written by the clients read as the JSON they were written from
+func TestClientFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("clients", "*", "tests", "fixtures", "*.glint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("found no client fixtures")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.ToSlash(file), func(t *testing.T) {
+			doc, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(strings.TrimSuffix(file, ".glint") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected, err := canonicalJSON(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written, err := conformanceJSON(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := canonicalJSON(written)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s reads as\n%v\nwant\n%v", file, got, expected)
+			}
+		})
+	}
+}
+
+func TestZigzagExtremes(t *testing.T) {
+	type extremes struct {
+		Int    int     `glint:"int"`
+		Ints   []int   `glint:"ints"`
+		Deltas []int64 `glint:"deltas,delta"`
+	}
+	in := extremes{
+		Int:    math.MinInt64,
+		Ints:   []int{math.MinInt64, math.MaxInt64, -1, 0},
+		Deltas: []int64{-5, math.MaxInt64, math.MinInt64, 0},
+	}
+	doc := NewEncoder[extremes]().MarshalBytes(&in)
+
+	var out extremes
+	if err := NewDecoder[extremes]().Unmarshal(doc, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("decoded %+v, want %+v", out, in)
+	}
+
+	data, err := DocumentTemplateData(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"int":    math.MinInt64,
+		"ints":   []any{math.MinInt64, math.MaxInt64, -1, 0},
+		"deltas": []any{int64(-5), int64(math.MaxInt64), int64(math.MinInt64), int64(0)},
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("template data %v, want %v", data, want)
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	type versioned struct {
+		conformanceShared
+		Refs []*Child `glint:"refs"`
+	}
+	v := versioned{
+		conformanceShared: conformanceShared{ID: 7, Name: "abc", Home: Child{A: 1, B: "home"}, Work: Child{A: 2, B: "work"}},
+		Refs:              []*Child{{A: 3, B: "ref"}, nil},
+	}
+
+	for max := 0; max <= MaxFormatVersion; max++ {
+		doc := NewEncoder[versioned](WithNameHashes(), WithDistinctNilSlices(), WithFormatVersion(max)).MarshalBytes(&v)
+
+		// the type repeats Child, has constraints and a slice of pointers, so each version writes everything it can
+		if version, err := FormatVersion(doc); version != max || err != nil {
+			t.Errorf("WithFormatVersion(%d) wrote version %d, %v", max, version, err)
+		}
+
+		// nil slices are kept apart from empty ones by versions with the presence byte, and written as empty below it
+		want := v
+		if max < formatVersionNilSlices {
+			want.Other = []Child{}
+		}
+
+		var got versioned
+		if err := NewDecoder[versioned]().Unmarshal(doc, &got); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("WithFormatVersion(%d) decoded as %+v, %v", max, got, err)
+		}
+
+		schema, err := ParseSchema(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if constrained := schema.Field("id").Min != ""; constrained != (max >= formatVersionConstraint) {
+			t.Errorf("WithFormatVersion(%d) wrote constraints: %v", max, constrained)
+		}
+
+		// the presence byte before each element of a slice of pointers is marked in the schema from version 4
+		refs := schema.Field("refs")
+		if marked := refs.Elem != nil && refs.Elem.Wire == WirePtrFlag|WireStruct; marked != (max >= formatVersionPointers) {
+			t.Errorf("WithFormatVersion(%d) marked the elements of a slice of pointers: %v", max, marked)
+		}
+	}
+
+	// encoders built without options write the newest version they need, as NewEncoder's do
+	if doc, err := Marshal(&v); err != nil || !bytes.Equal(doc, NewEncoder[versioned]().MarshalBytes(&v)) {
+		t.Errorf("expected Marshal to write what NewEncoder does, got %v", err)
+	}
+
+	plain := NewEncoder[Child]().MarshalBytes(&Child{A: 1})
+	if version, err := FormatVersion(plain); version != 0 || err != nil {
+		t.Errorf("expected version 0 for a plain document, got %d, %v", version, err)
+	}
+
+	future := append([]byte{7}, plain[1:]...)
+	if version, err := FormatVersion(future); version != 7 || !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected version 7 and ErrUnsupportedVersion, got %d, %v", version, err)
+	}
+	if _, err := FormatVersion(plain[:3]); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("expected ErrInvalidDocument for a short document, got %v", err)
+	}
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("expected a panic with ErrUnsupportedVersion for a version newer than MaxFormatVersion, got %v", err)
+		}
+	}()
+	NewEncoder[Child](WithFormatVersion(MaxFormatVersion + 1))
+}
+
+// typedRecorder records the typed callbacks of a walk, each value with its type
+type typedRecorder struct {
+	NopTypedVisitor
+	events []string
+}
+
+func (v *typedRecorder) add(name string, value any) error {
+	v.events = append(v.events, fmt.Sprintf("%s=%T(%v)", name, value, value))
+	return nil
+}
+func (v *typedRecorder) VisitArrayStart(name string, wire WireType, length int) error {
+	v.events = append(v.events, "["+name)
+	return nil
+}
+func (v *typedRecorder) VisitArrayEnd(name string) error {
+	v.events = append(v.events, "]")
+	return nil
+}
+func (v *typedRecorder) VisitStructStart(name string) error {
+	v.events = append(v.events, "{"+name)
+	return nil
+}
+func (v *typedRecorder) VisitStructEnd(name string) error {
+	v.events = append(v.events, "}")
+	return nil
+}
+func (v *typedRecorder) VisitNil(name string, wire WireType) error   { return v.add(name, nil) }
+func (v *typedRecorder) VisitBool(name string, value bool) error     { return v.add(name, value) }
+func (v *typedRecorder) VisitInt(name string, value int64) error     { return v.add(name, value) }
+func (v *typedRecorder) VisitUint(name string, value uint64) error   { return v.add(name, value) }
+func (v *typedRecorder) VisitFloat(name string, value float64) error { return v.add(name, value) }
+func (v *typedRecorder) VisitString(name, value string) error        { return v.add(name, value) }
+func (v *typedRecorder) VisitBytes(name string, value []byte) error  { return v.add(name, value) }
+func (v *typedRecorder) VisitTime(name string, value time.Time) error {
+	return v.add(name, value.UTC().Format(time.RFC3339))
+}
+func (v *typedRecorder) VisitDuration(name string, value time.Duration) error {
+	return v.add(name, value)
+}
+func (v *typedRecorder) VisitAddr(name string, value netip.Addr) error { return v.add(name, value) }
+
+func TestTypedVisitor(t *testing.T) {
+
+	type point struct {
+		X int8    `glint:"x"`
+		Y float32 `glint:"y"`
+	}
+
+	type record struct {
+		Name     string        `glint:"name,dict"`
+		Small    int16         `glint:"small"`
+		Big      int64         `glint:"big,zigzag"`
+		Count    uint32        `glint:"count"`
+		Ratio    float64       `glint:"ratio"`
+		On       bool          `glint:"on"`
+		Raw      []byte        `glint:"raw"`
+		When     time.Time     `glint:"when"`
+		Took     time.Duration `glint:"took"`
+		Addr     netip.Addr    `glint:"addr"`
+		Missing  *int          `glint:"missing"`
+		Present  *uint8        `glint:"present"`
+		Tags     []string      `glint:"tags"`
+		Readings []int64       `glint:"readings,delta"`
+		Flags    []bool        `glint:"flags,bitmap"`
+		Origin   point         `glint:"origin"`
+		Path     []point       `glint:"path"`
+	}
+
+	seven := uint8(7)
+	doc, err := Marshal(&record{
+		Name: "probe", Small: -3, Big: -1 << 40, Count: 9, Ratio: 0.5, On: true, Raw: []byte{1, 2},
+		When: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Took: -time.Second, Addr: netip.MustParseAddr("10.0.0.1"),
+		Present: &seven, Tags: []string{"a", "b"}, Readings: []int64{100, 101, 99}, Flags: []bool{true, false},
+		Origin: point{X: 1, Y: 1.5}, Path: []point{{X: -2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &typedRecorder{}
+	if err := Walk(doc, DecodingVisitor(v)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"name=string(probe)", "small=int64(-3)", "big=int64(-1099511627776)", "count=uint64(9)", "ratio=float64(0.5)",
+		"on=bool(true)", "raw=[]uint8([1 2])", "when=string(2024-05-01T12:00:00Z)", "took=time.Duration(-1s)",
+		"addr=netip.Addr(10.0.0.1)", "missing=<nil>(<nil>)", "present=uint64(7)",
+		"[tags", "=string(a)", "=string(b)", "]",
+		"[readings", "=int64(100)", "=int64(101)", "=int64(99)", "]",
+		"[flags", "=bool(true)", "=bool(false)", "]",
+		"{origin", "x=int64(1)", "y=float64(1.5)", "}",
+		"[path", "{", "x=int64(-2)", "y=float64(0)", "}", "]",
+	}
+	if !reflect.DeepEqual(v.events, want) {
+		t.Errorf("unexpected callbacks\n got: %v\nwant: %v", v.events, want)
+	}
+
+	t.Run("Skipped", func(t *testing.T) {
+		// every field is read past when skipped, including slices written as a whole
+		skipping := &recordingVisitor{skip: true}
+		if err := Walk(doc, skipping); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(skipping.events, " "); got != "name small big count ratio on raw when took addr missing present [tags   ] readings flags {origin x y } [path { x y } ]" {
+			t.Errorf("expected a callback for every field, got %v", got)
+		}
+	})
+}
+
+// pathVisitor records the path of every field its walker visits
+type pathVisitor struct {
+	NopTypedVisitor
+	w     *Walker
+	paths []string
+}
+
+func (v *pathVisitor) add() error {
+	v.paths = append(v.paths, v.w.Path())
+	return nil
+}
+func (v *pathVisitor) VisitStructStart(name string) error { return v.add() }
+func (v *pathVisitor) VisitArrayStart(name string, wire WireType, length int) error {
+	return v.add()
+}
+func (v *pathVisitor) VisitString(name, value string) error    { return v.add() }
+func (v *pathVisitor) VisitInt(name string, value int64) error { return v.add() }
+
+func TestWalkerPaths(t *testing.T) {
+
+	type address struct {
+		City string `glint:"city"`
+	}
+
+	type user struct {
+		Name    string  `glint:"name"`
+		Email   string  `glint:"email"`
+		Address address `glint:"address"`
+	}
+
+	type team struct {
+		Name     string   `glint:"name"`
+		Users    []user   `glint:"users"`
+		Readings []int64  `glint:"readings,delta"`
+		Tags     []string `glint:"tags"`
+		Size     int      `glint:"size"`
+	}
+
+	doc, err := Marshal(&team{
+		Name: "blue",
+		Users: []user{
+			{Name: "ann", Email: "ann@example.com", Address: address{City: "Leeds"}},
+			{Name: "bob", Email: "bob@example.com", Address: address{City: "York"}},
+		},
+		Readings: []int64{3, 4},
+		Tags:     []string{"x", "y"},
+		Size:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(selector string) ([]string, error) {
+		w := NewWalker(doc)
+		v := &pathVisitor{w: &w}
+		if selector == "" {
+			return v.paths, w.Walk(DecodingVisitor(v))
+		}
+		err := w.WalkSelect(DecodingVisitor(v), selector)
+		return v.paths, err
+	}
+
+	t.Run("Path", func(t *testing.T) {
+		paths, err := walk("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the elements of the delta slice are decoded within its field, so share its path
+		want := "name users users[0] users[0].name users[0].email users[0].address users[0].address.city " +
+			"users[1] users[1].name users[1].email users[1].address users[1].address.city readings readings readings tags tags[0] tags[1] size"
+		if got := strings.Join(paths, " "); got != want {
+			t.Errorf("unexpected paths\n got: %s\nwant: %s", got, want)
+		}
+	})
+
+	for _, tt := range []struct {
+		selector string
+		want     string
+	}{
+		{"users.*.email", "users users[0] users[0].email users[1] users[1].email"},
+		{"users[*].email", "users users[0] users[0].email users[1] users[1].email"},
+		{"users[1].address", "users users[1] users[1].address users[1].address.city"},
+		{"users[1].address.city", "users users[1] users[1].address users[1].address.city"},
+		{"*", "name users users[0] users[0].name users[0].email users[0].address users[0].address.city " +
+			"users[1] users[1].name users[1].email users[1].address users[1].address.city readings readings readings tags tags[0] tags[1] size"},
+		{"tags[1]", "tags tags[1]"},
+		{"size", "size"},
+		{"missing", ""},
+	} {
+		t.Run("Select "+tt.selector, func(t *testing.T) {
+			paths, err := walk(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(paths, " "); got != tt.want {
+				t.Errorf("unexpected paths\n got: %s\nwant: %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("InvalidSelector", func(t *testing.T) {
+		for _, selector := range []string{"", "users.", ".users", "users[", `users["a]`, `users["a"]b`, "users..name", "users.[0]", "users[0]name"} {
+			if err := WalkSelect(doc, &recordingVisitor{}, selector); err == nil {
+				t.Errorf("expected an error for selector %q", selector)
+			}
+		}
+	})
+}
+
+func TestWalkerMaps(t *testing.T) {
+
+	type point struct {
+		X int `glint:"x"`
+	}
+
+	type board struct {
+		Scores map[string]int        `glint:"scores"`
+		Places map[int32]point       `glint:"places"`
+		Labels map[string][]string   `glint:"labels"`
+		Dotted map[string]string     `glint:"dotted"`
+		Nested map[uint8]map[int]int `glint:"nested"`
+		After  string                `glint:"after"`
+	}
+
+	doc, err := Marshal(&board{
+		Scores: map[string]int{"ann": 3},
+		Places: map[int32]point{-1: {X: 4}},
+		Labels: map[string][]string{"k": {"a", "b"}},
+		Dotted: map[string]string{"a.b": "c"},
+		Nested: map[uint8]map[int]int{7: {8: 9}},
+		After:  "end",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Visitor", func(t *testing.T) {
+		v, count := &recordingVisitor{}, &CountingVisitor{}
+		if err := Walk(doc, ChainVisitors(v, count)); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			"<scores", "key=ann", "=3", ">",
+			"<places", "key=-1", "{", "x=4", "}", ">",
+			"<labels", "key=k", "[", "=a", "=b", "]", ">",
+			"<dotted", "key=a.b", "=c", ">",
+			"<nested", "key=7", "<", "key=8", "=9", ">", ">",
+			"after=end",
+		}
+		if !reflect.DeepEqual(v.events, want) {
+			t.Errorf("unexpected callbacks\n got: %v\nwant: %v", v.events, want)
+		}
+		if count.Maps != 6 {
+			t.Errorf("expected 6 maps to be counted, got %d", count.Maps)
+		}
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		v := &recordingVisitor{skip: true}
+		if err := Walk(doc, v); err != nil {
+			t.Fatal(err)
+		}
+		if got := v.events[len(v.events)-1]; got != "after" {
+			t.Errorf("expected the walk to stay aligned when keys and values are skipped, got %v", v.events)
+		}
+	})
+
+	t.Run("WithoutMapVisitor", func(t *testing.T) {
+		v := &recordingVisitor{}
+		plain := struct{ Visitor }{v} // a visitor without the map callbacks, maps are skipped over
+		for _, visitor := range []Visitor{plain, ChainVisitors(plain, &CountingVisitor{}), FilterVisitor(func(string, WireType) bool { return true }, plain)} {
+			v.events = nil
+			if _, ok := visitor.(MapVisitor); ok {
+				t.Errorf("expected %T not to be a MapVisitor", visitor)
+			}
+			if err := Walk(doc, visitor); err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"after=end"}; !reflect.DeepEqual(v.events, want) {
+				t.Errorf("expected maps to be skipped by %T, got %v", visitor, v.events)
+			}
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		v := &recordingVisitor{}
+		onlyAfter := func(name string, wire WireType) bool { return name == "after" }
+		if err := Walk(doc, FilterVisitor(onlyAfter, v)); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"after=end"}; !reflect.DeepEqual(v.events, want) {
+			t.Errorf("expected maps to be hidden with their contents, got %v", v.events)
+		}
+	})
+
+	t.Run("Typed", func(t *testing.T) {
+		v := &typedRecorder{}
+		if err := Walk(doc, DecodingVisitor(&mapKeyRecorder{v})); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"key=string(ann)", "=int64(3)", "key=int64(-1)", "{", "x=int64(4)", "}",
+			"key=string(k)", "[", "=string(a)", "=string(b)", "]", "key=string(a.b)", "=string(c)",
+			"key=uint64(7)", "key=int64(8)", "=int64(9)", "after=string(end)",
+		}
+		if !reflect.DeepEqual(v.events, want) {
+			t.Errorf("unexpected typed keys and values\n got: %q\nwant: %q", v.events, want)
+		}
+	})
+
+	t.Run("Paths", func(t *testing.T) {
+		w := NewWalker(doc)
+		v := &pathVisitor{w: &w}
+		if err := w.Walk(DecodingVisitor(v)); err != nil {
+			t.Fatal(err)
+		}
+		want := `scores[ann] places[-1] places[-1].x labels[k] labels[k][0] labels[k][1] dotted["a.b"] nested[7][8] after`
+		if got := strings.Join(v.paths, " "); got != want {
+			t.Errorf("unexpected paths\n got: %s\nwant: %s", got, want)
+		}
+	})
+
+	for _, tt := range []struct {
+		selector string
+		want     string
+	}{
+		{"scores[ann]", "scores[ann]"},
+		{"scores[bob]", ""},
+		{"places[-1].x", "places[-1] places[-1].x"},
+		{`dotted["a.b"]`, `dotted["a.b"]`},
+		{"nested[*][8]", "nested[7][8]"},
+		{"labels.*[1]", "labels[k] labels[k][1]"},
+	} {
+		t.Run("Select "+tt.selector, func(t *testing.T) {
+			w := NewWalker(doc)
+			v := &pathVisitor{w: &w}
+			if err := w.WalkSelect(DecodingVisitor(v), tt.selector); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(v.paths, " "); got != tt.want {
+				t.Errorf("unexpected paths\n got: %s\nwant: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// mapKeyRecorder records map keys, as well as everything typedRecorder does
+type mapKeyRecorder struct {
+	*typedRecorder
+}
+
+func (v *mapKeyRecorder) VisitMapKey(key any) error { return v.add("key", key) }
+
+type rewrittenUser struct {
+	Name     string    `glint:"name"`
+	Email    string    `glint:"email"`
+	Age      int8      `glint:"age"`
+	Seen     time.Time `glint:"seen"`
+	Internal string    `glint:"internal"`
+}
+
+type rewrittenTeam struct {
+	Region   string          `glint:"region,dict"`
+	Users    []rewrittenUser `glint:"users"`
+	Internal int             `glint:"internal"`
+	Lead     *rewrittenUser  `glint:"lead"`
+}
+
+// publicTeam is rewrittenTeam without its internal fields
+type publicTeam struct {
+	Region string `glint:"region,dict"`
+	Users  []struct {
+		Name  string    `glint:"name"`
+		Email string    `glint:"email"`
+		Age   int8      `glint:"age"`
+		Seen  time.Time `glint:"seen"`
+	} `glint:"users"`
+	Lead *struct {
+		Name     string `glint:"name"`
+		Email    string `glint:"email"`
+		Internal string `glint:"internal"`
+	} `glint:"lead"`
+}
+
+func TestRewriter(t *testing.T) {
+	seen := time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)
+	team := rewrittenTeam{
+		Region: "eu-west",
+		Users: []rewrittenUser{
+			{Name: "ann", Email: "ann@example.com", Age: 30, Seen: seen, Internal: "a"},
+			{Name: "bob", Email: "bob@example.com", Age: 40, Seen: seen.Add(time.Hour), Internal: "b"},
+		},
+		Internal: 7,
+		Lead:     &rewrittenUser{Name: "cat", Email: "cat@example.com", Internal: "c"},
+	}
+	doc, err := Marshal(&team)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redact := func(path string, value any) (any, error) { return "redacted", nil }
+
+	t.Run("Replace", func(t *testing.T) {
+		var rw Rewriter
+		var paths []string
+		rw.Replace("users[*].email", func(path string, value any) (any, error) {
+			paths = append(paths, path)
+			return "redacted", nil
+		})
+		rw.Replace("users[*].seen", func(path string, value any) (any, error) {
+			return value.(time.Time).Truncate(24 * time.Hour), nil
+		})
+
+		out, err := rw.Rewrite(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"users[0].email", "users[1].email"}; !reflect.DeepEqual(paths, want) {
+			t.Errorf("expected paths %v, got %v", want, paths)
+		}
+
+		var got rewrittenTeam
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := team
+		want.Users = []rewrittenUser{team.Users[0], team.Users[1]}
+		for i := range want.Users {
+			want.Users[i].Email, want.Users[i].Seen = "redacted", seen.Truncate(24*time.Hour)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+		if !bytes.Equal(out[:5], doc[:5]) {
+			t.Error("expected the header and schema to be kept")
+		}
+	})
+
+	t.Run("InPlace", func(t *testing.T) {
+		var rw Rewriter
+		rw.Replace("users[*].age", func(path string, value any) (any, error) { return value.(int64) + 1, nil })
+		rw.Replace("users[1].name", func(path string, value any) (any, error) { return "bub", nil })
+
+		in := append([]byte(nil), doc...)
+		if err := rw.RewriteInPlace(in); err != nil {
+			t.Fatal(err)
+		}
+		var got rewrittenTeam
+		if err := Unmarshal(in, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Users[0].Age != 31 || got.Users[1].Age != 41 || got.Users[1].Name != "bub" || got.Users[0].Name != "ann" {
+			t.Errorf("unexpected users after rewriting in place: %+v", got.Users)
+		}
+
+		rw.Replace("users[0].email", redact)
+		before := append([]byte(nil), in...)
+		if err := rw.RewriteInPlace(in); !errors.Is(err, ErrNotInPlace) {
+			t.Errorf("expected ErrNotInPlace for a replacement of a different size, got %v", err)
+		}
+		if !bytes.Equal(in, before) {
+			t.Error("expected the document to be left as it was")
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		var rw Rewriter
+		rw.Remove("internal")
+		rw.Remove("users[*].internal")
+		rw.Replace("lead.email", redact)
+
+		out, err := rw.Rewrite(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := ParseSchema(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(schema.Fields); got != 3 {
+			t.Errorf("expected 3 top level fields, got %d", got)
+		}
+
+		var got publicTeam
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Region != "eu-west" || len(got.Users) != 2 || got.Users[1].Email != "bob@example.com" || !got.Users[1].Seen.Equal(seen.Add(time.Hour)) {
+			t.Errorf("unexpected document after removing fields: %+v", got)
+		}
+		if got.Lead == nil || got.Lead.Name != "cat" || got.Lead.Email != "redacted" || got.Lead.Internal != "c" {
+			t.Errorf("expected only the selected internal fields to be removed, got lead %+v", got.Lead)
+		}
+		if err := rw.RewriteInPlace(append([]byte(nil), doc...)); !errors.Is(err, ErrNotInPlace) {
+			t.Errorf("expected ErrNotInPlace when removing fields, got %v", err)
+		}
+	})
+
+	t.Run("Dictionary", func(t *testing.T) {
+		var rw Rewriter
+		rw.Replace("region", func(path string, value any) (any, error) { return "us-east", nil })
+
+		out, err := rw.Rewrite(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got rewrittenTeam
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Region != "us-east" || got.Users[0].Name != "ann" {
+			t.Errorf("unexpected document after replacing a dictionary string: %+v", got)
+		}
+		if err := rw.RewriteInPlace(append([]byte(nil), doc...)); !errors.Is(err, ErrNotInPlace) {
+			t.Errorf("expected ErrNotInPlace when the string table grows, got %v", err)
+		}
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		report := compressedReport{Title: "nightly", Region: "eu-west"}
+		for i := 0; i < 50; i++ {
+			report.Lines = append(report.Lines, compressedLine{fmt.Sprintf("job %d finished", i%10)})
+		}
+		var b Buffer
+		NewEncoder[compressedReport](WithCompression(NewDeflateCompressor(1))).Marshal(&report, &b)
+
+		var rw Rewriter
+		rw.Replace("lines[*].text", func(path string, value any) (any, error) { return strings.ToUpper(value.(string)), nil })
+		out, err := rw.Rewrite(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Codec(out[0]>>4) != CodecDeflate {
+			t.Errorf("expected the document to stay deflated, got flags %08b", out[0])
+		}
+		var got compressedReport
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Lines[3].Text != "JOB 3 FINISHED" || got.Region != "eu-west" {
+			t.Errorf("unexpected document after rewriting: %+v", got)
+		}
+		if err := rw.RewriteInPlace(b.Bytes); !errors.Is(err, ErrNotInPlace) {
+			t.Errorf("expected ErrNotInPlace for a compressed body, got %v", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		var rw Rewriter
+		if err := rw.Remove("users[0].internal"); err == nil {
+			t.Error("expected an error removing a field from one element")
+		}
+		if err := rw.Replace("users[", redact); err == nil {
+			t.Error("expected an error for an invalid selector")
+		}
+
+		for selector, fn := range map[string]func(string, any) (any, error){
+			"users[*].age":  func(string, any) (any, error) { return int64(200), nil },
+			"users[1].name": func(string, any) (any, error) { return 3, nil },
+			"users[1].seen": func(string, any) (any, error) { return nil, io.ErrUnexpectedEOF },
+		} {
+			var rw Rewriter
+			rw.Replace(selector, fn)
+			_, err := rw.Rewrite(doc)
+			var pathErr *PathError
+			if !errors.As(err, &pathErr) || !strings.HasPrefix(pathErr.Path, "users[") {
+				t.Errorf("%s: expected a *PathError, got %v", selector, err)
+			}
+		}
+
+		var none Rewriter
+		if _, err := none.Rewrite(doc[:len(doc)-3]); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("expected ErrInvalidDocument for a truncated document, got %v", err)
+		}
+	})
+}
+
+type builtUser struct {
+	Name    string     `glint:"name"`
+	Country string     `glint:"country,dict"`
+	Home    *builtAddr `glint:"home"`
+	Work    builtAddr  `glint:"work"`
+	Age     int        `glint:"age,min=0"`
+}
+
+type builtAddr struct {
+	City string `glint:"city,dict"`
+}
+
+func TestDocumentBuilderAppendStruct(t *testing.T) {
+	enc := NewEncoder[builtUser]()
+	ann := builtUser{Name: "ann", Country: "GB", Home: &builtAddr{City: "York"}, Work: builtAddr{City: "Leeds"}, Age: 30}
+	bob := builtUser{Name: "bob", Country: "FR", Work: builtAddr{City: "York"}, Age: 40}
+
+	type team struct {
+		Lead    builtUser `glint:"lead"`
+		Source  string    `glint:"source"`
+		Members []struct {
+			User builtUser `glint:"user"`
+			Role string    `glint:"role"`
+		} `glint:"members"`
+		Deputy struct {
+			User builtUser `glint:"user"`
+		} `glint:"deputy"`
+	}
+
+	members := make([]DocumentBuilder, 2)
+	members[0].AppendStruct("user", enc, &bob).AppendString("role", "dev")
+	members[1].AppendStruct("user", enc, &ann).AppendString("role", "ops")
+	var slice SliceBuilder
+	slice.AppendNestedDocumentSlice(members)
+
+	var deputy DocumentBuilder
+	deputy.AppendStruct("user", enc, &bob)
+
+	var doc DocumentBuilder
+	doc.AppendStruct("lead", enc, &ann).
+		AppendString("source", "import").
+		AppendSlice("members", slice).
+		AppendNestedDocument("deputy", &deputy)
+
+	out := doc.Bytes()
+	if v, _ := FormatVersion(out); v != 0 || out[0]&flagStringTable == 0 {
+		t.Errorf("expected a version 0 document with a string table, got flags %08b", out[0])
+	}
+
+	var got team
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Lead, ann) || got.Source != "import" || !reflect.DeepEqual(got.Deputy.User, bob) {
+		t.Errorf("unexpected document: %+v", got)
+	}
+	if len(got.Members) != 2 || !reflect.DeepEqual(got.Members[0].User, bob) || !reflect.DeepEqual(got.Members[1].User, ann) || got.Members[1].Role != "ops" {
+		t.Errorf("unexpected members: %+v", got.Members)
+	}
+	if !bytes.Equal(doc.Bytes(), out) {
+		t.Error("expected writing the document again to give the same bytes")
+	}
+
+	t.Run("WrongType", func(t *testing.T) {
+		for _, v := range []any{ann, &bob.Work, (*builtUser)(nil)} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("expected a panic appending a %T", v)
+					}
+				}()
+				var doc DocumentBuilder
+				doc.AppendStruct("user", enc, v)
+			}()
+		}
+	})
+
+	t.Run("DistinctNilSlices", func(t *testing.T) {
+		type tagged struct {
+			Tags []string `glint:"tags"`
+		}
+		type outer struct {
+			Items []struct {
+				Value tagged `glint:"value"`
+			} `glint:"items"`
+		}
+
+		items := make([]DocumentBuilder, 1)
+		items[0].AppendStruct("value", NewEncoder[tagged](WithDistinctNilSlices()), &tagged{})
+		var slice SliceBuilder
+		slice.AppendNestedDocumentSlice(items)
+
+		var doc DocumentBuilder
+		out := doc.AppendSlice("items", slice).Bytes()
+
+		// the presence byte of the nested struct's slice moves the whole document to the version that reads it
+		if v, _ := FormatVersion(out); v != formatVersionNilSlices {
+			t.Errorf("expected format version %d, got %d", formatVersionNilSlices, v)
+		}
+
+		var got outer
+		if err := Unmarshal(out, &got); err != nil || len(got.Items) != 1 || got.Items[0].Value.Tags != nil {
+			t.Errorf("expected a nil slice to stay nil, got %+v, %v", got, err)
+		}
+	})
+}
+
+func TestDocumentBuilderMaps(t *testing.T) {
+	type point struct {
+		X int `glint:"x"`
+	}
+	type maps struct {
+		Labels map[string]string     `glint:"labels"`
+		Counts map[string]int        `glint:"counts"`
+		Places map[int32]point       `glint:"places"`
+		Tags   map[string][]string   `glint:"tags"`
+		Nested map[uint8]map[int]int `glint:"nested"`
+		Empty  map[string]string     `glint:"empty"`
+		After  string                `glint:"after"`
+	}
+	want := maps{
+		Labels: map[string]string{"env": "prod", "team": "core"},
+		Counts: map[string]int{"a": -1, "b": 2},
+		Places: map[int32]point{-3: {X: 4}, 5: {X: 6}},
+		Tags:   map[string][]string{"k": {"x", "y"}},
+		Nested: map[uint8]map[int]int{7: {8: 9}},
+		Empty:  map[string]string{},
+		After:  "end",
+	}
+
+	var doc DocumentBuilder
+	doc.AppendStringMap("labels", want.Labels).
+		AppendIntMap("counts", want.Counts).
+		AppendMap("places", want.Places).
+		AppendMap("tags", want.Tags).
+		AppendMap("nested", want.Nested).
+		AppendStringMap("empty", nil).
+		AppendString("after", "end")
+
+	var got maps
+	if err := Unmarshal(doc.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	encoded, err := Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, _ := ParseSchema(doc.Bytes())
+	typed, _ := ParseSchema(encoded)
+	if !reflect.DeepEqual(built.Fields, typed.Fields) {
+		t.Errorf("expected the schema an encoder writes\n got: %+v\nwant: %+v", built.Fields, typed.Fields)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected AppendMap to panic for a value that isn't a map")
+		}
+	}()
+	doc.AppendMap("slice", []string{"a"})
+}
+
+func TestDocumentBuilderBuild(t *testing.T) {
+	enc := NewEncoder[builtUser]()
+	user := builtUser{Name: "ann", Work: builtAddr{City: "York"}}
+
+	t.Run("Valid", func(t *testing.T) {
+		var item DocumentBuilder
+		item.AppendString("name", "a").AppendInt("qty", 2)
+		var items SliceBuilder
+		items.AppendNestedDocumentSlice([]DocumentBuilder{item})
+
+		var doc DocumentBuilder
+		doc.AppendStruct("user", enc, &user).
+			AppendSlice("items", items).
+			AppendMap("scores", map[string]builtAddr{"a": {City: "Hull"}}).
+			AppendNestedDocument("meta", (&DocumentBuilder{}).AppendString("name", "m"))
+
+		out, err := doc.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, doc.Bytes()) {
+			t.Error("expected Build to return the same document as Bytes")
+		}
+	})
+
+	long := strings.Repeat("x", 256)
+	nested := func(name string) *DocumentBuilder {
+		return (&DocumentBuilder{}).AppendString("id", "1").AppendString(name, "2")
+	}
+	sliced := func(name string) SliceBuilder {
+		var s SliceBuilder
+		s.AppendNestedDocumentSlice([]DocumentBuilder{*nested(name)})
+		return s
+	}
+
+	for name, tc := range map[string]struct {
+		doc  *DocumentBuilder
+		err  error
+		path string
+	}{
+		"Duplicate":       {doc: (&DocumentBuilder{}).AppendString("a", "1").AppendInt("a", 2), err: ErrFieldExists, path: "a"},
+		"NestedDuplicate": {doc: (&DocumentBuilder{}).AppendNestedDocument("meta", nested("id")), err: ErrFieldExists, path: "meta.id"},
+		"SliceDuplicate":  {doc: (&DocumentBuilder{}).AppendSlice("items", sliced("id")), err: ErrFieldExists, path: "items.id"},
+		"StructDuplicate": {doc: (&DocumentBuilder{}).AppendStruct("user", enc, &user).AppendBool("user", true), err: ErrFieldExists, path: "user"},
+		"Empty":           {doc: (&DocumentBuilder{}).AppendString("", "1")},
+		"NestedEmpty":     {doc: (&DocumentBuilder{}).AppendNestedDocument("meta", nested("")), path: "meta"},
+		"Long":            {doc: (&DocumentBuilder{}).AppendString(long, "1")},
+		"NestedLong":      {doc: (&DocumentBuilder{}).AppendNestedDocument("meta", nested(long))},
+		"SliceLong":       {doc: (&DocumentBuilder{}).AppendSlice("items", sliced(long))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := tc.doc.Build()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+			var pathErr *PathError
+			if errors.As(err, &pathErr) != (tc.path != "") || (tc.path != "" && pathErr.Path != tc.path) {
+				t.Errorf("expected the error at path %q, got %v", tc.path, err)
+			}
+		})
+	}
+}
+
+func TestSliceBuilderDynamic(t *testing.T) {
+	var values SliceBuilder
+	values.AppendDynamicSlice([]any{1, "a", true, nil, 2.5, []string{"x", "y"}, []uint8{3, 4}})
+
+	var doc DocumentBuilder
+	doc.AppendString("name", "mixed").AppendSlice("values", values).AppendInt("size", 7)
+	out, err := doc.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Document(out).Validate(DefaultLimits); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Walk", func(t *testing.T) {
+		v := &typedRecorder{}
+		if err := Walk(out, DecodingVisitor(v)); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"name=string(mixed)",
+			"[values", "=int64(1)", "=string(a)", "=bool(true)", "=<nil>(<nil>)", "=float64(2.5)",
+			"[", "=string(x)", "=string(y)", "]", "[", "=uint64(3)", "=uint64(4)", "]", "]",
+			"size=int64(7)",
+		}
+		if !reflect.DeepEqual(v.events, want) {
+			t.Errorf("unexpected callbacks\n got: %v\nwant: %v", v.events, want)
+		}
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		// decoders skip the slice as they do any field they don't have
+		type sized struct {
+			Name string `glint:"name"`
+			Size int    `glint:"size"`
+		}
+		var s sized
+		if err := NewDecoder[sized]().Unmarshal(out, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s != (sized{Name: "mixed", Size: 7}) {
+			t.Errorf("unexpected value %+v", s)
+		}
+	})
+
+	t.Run("DecodeAny", func(t *testing.T) {
+		type mixed struct {
+			Name   string `glint:"name"`
+			Values []any  `glint:"values"`
+			Size   int    `glint:"size"`
+		}
+		var m mixed
+		if err := NewDecoder[mixed]().Unmarshal(out, &m); err != nil {
+			t.Fatal(err)
+		}
+		want := []any{1, "a", true, nil, 2.5, []any{"x", "y"}, []any{uint(3), uint(4)}}
+		if m.Name != "mixed" || m.Size != 7 || !reflect.DeepEqual(m.Values, want) {
+			t.Errorf("unexpected value %#v", m)
+		}
+
+		// slices of a single type are still their own type, which []any doesn't take
+		var b DocumentBuilder
+		var ints SliceBuilder
+		ints.AppendIntSlice([]int{1})
+		if err := NewDecoder[mixed]().Unmarshal(b.AppendSlice("values", ints).Bytes(), &m); !errors.Is(err, ErrFieldTypeMismatch) {
+			t.Errorf("expected ErrFieldTypeMismatch for a slice of ints, got %v", err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		var values SliceBuilder
+		values.AppendDynamicSlice([]any{1, []any{2}, map[string]any{"a": 3}, "z"})
+
+		var doc DocumentBuilder
+		doc.AppendSlice("values", values)
+		if _, err := doc.Build(); err == nil || !strings.Contains(err.Error(), "[]interface {} at index 1") {
+			t.Errorf("expected Build to report the nested slice, got %v", err)
+		}
+
+		// the elements that can't be written are nil, so the rest of the document still reads
+		data, err := DocumentTemplateData(doc.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(data); got != "map[values:[1 <nil> <nil> z]]" {
+			t.Errorf("unexpected document %v", got)
+		}
+	})
+
+	t.Run("TemplateData", func(t *testing.T) {
+		data, err := DocumentTemplateData(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := fmt.Sprint(data.(map[string]any)["values"])
+		if want := "[1 a true <nil> 2.5 [x y] [3 4]]"; got != want {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Print", func(t *testing.T) {
+		s, err := SPrint(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(s, "size") {
+			t.Errorf("expected the fields after the slice to be printed, got %v", s)
+		}
+	})
+
+	t.Run("Generate", func(t *testing.T) {
+		generated, err := GenerateDocument(out, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Document(generated).Validate(DefaultLimits); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Rewrite", func(t *testing.T) {
+		var rw Rewriter
+		if err := rw.Remove("name"); err != nil {
+			t.Fatal(err)
+		}
+		rewritten, err := rw.Rewrite(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := DocumentTemplateData(rewritten)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(data); got != "map[size:7 values:[1 a true <nil> 2.5 [x y] [3 4]]]" {
+			t.Errorf("unexpected document %v", got)
+		}
+	})
+}
+
+func TestMarshalBytesMatchesMarshal(t *testing.T) {
+	type order struct {
+		ID    int64    `glint:"id"`
+		Items []string `glint:"items"`
+		Note  *string  `glint:"note"`
+	}
+	enc := NewEncoder[order]()
+
+	note := "leave at the door"
+	for _, v := range []order{{}, {ID: 1, Items: []string{"a", "b"}}, {ID: -7, Note: &note}} {
+		buf := NewBufferFromPool()
+		enc.Marshal(&v, buf)
+		want := append([]byte(nil), buf.Bytes...)
+		buf.ReturnToPool()
+
+		got := enc.MarshalBytes(&v)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%+v: expected MarshalBytes to write %v as Marshal does, got %v", v, want, got)
+		}
+
+		// the pooled buffer MarshalBytes wrote through is free to be reused, which mustn't reach the document
+		for i := 0; i < 4; i++ {
+			other := order{ID: 99, Items: []string{"overwritten"}}
+			b := NewBufferFromPool()
+			enc.Marshal(&other, b)
+			b.ReturnToPool()
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%+v: expected the document to be unchanged by later encodes, got %v", v, got)
+		}
+	}
+}
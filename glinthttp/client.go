@@ -0,0 +1,80 @@
+package glinthttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kungfusheep/glint"
+)
+
+// Client calls glint endpoints served by Handler, or anything else speaking the same headers. It keeps a
+// glint.SchemaSession with the server, so request schemas are left out once the server has acknowledged them,
+// and the server is told which response schemas the client holds so it can leave those out in turn.
+//
+// The session relies on each response schema being decoded by the same Decoder every time, so a Client should be
+// used with a single Decoder per response type. A Client is safe for concurrent use.
+type Client struct {
+	HTTP    *http.Client         // client requests are sent with, http.DefaultClient when nil
+	Session *glint.SchemaSession // schemas exchanged with the server
+}
+
+// NewClient creates a Client that sends requests with c, which may be nil for http.DefaultClient
+func NewClient(c *http.Client) *Client {
+	return &Client{HTTP: c, Session: glint.NewSchemaSession()}
+}
+
+// Call posts req to url as glint, and decodes the response into resp. A 204 No Content response leaves resp
+// untouched, and responses with any other status outside of 2xx are returned as a *StatusError.
+//
+// When the server no longer holds a request schema it had acknowledged, e.g. after a restart, it replies with
+// 412 Precondition Failed. The acknowledgement is revoked and the request sent again with its schema.
+func Call[Req, Resp any](ctx context.Context, c *Client, url string, enc *glint.Encoder[Req], dec *glint.Decoder[Resp], req *Req, resp *Resp) error {
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	for retried := false; ; retried = true {
+		b := &glint.Buffer{} // not pooled, the transport may still be reading the body after Do returns
+		enc.MarshalWithSession(req, b, c.Session)
+		hash := glint.Document(b.Bytes).Hash()
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b.Bytes))
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Content-Type", ContentType)
+		r.Header.Set("Accept", ContentType)
+		if h, ok := c.Session.ReceivedHeader(); ok {
+			r.Header.Set(h.Key(), h.Value())
+		}
+
+		res, err := httpClient.Do(r)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		c.Session.AcknowledgeHeader(res.Header)
+
+		switch {
+		case res.StatusCode == http.StatusPreconditionFailed && !retried && c.Session.Trusts(hash):
+			c.Session.Revoke(hash)
+			continue
+		case res.StatusCode == http.StatusNoContent:
+			return nil
+		case res.StatusCode < 200 || res.StatusCode > 299:
+			return &StatusError{res.StatusCode, errors.New(strings.TrimSpace(string(body)))}
+		}
+
+		return dec.UnmarshalWithSession(body, resp, c.Session)
+	}
+}
@@ -0,0 +1,251 @@
+// Package glinthttp serves and consumes glint documents over net/http.
+//
+// Requests are decoded according to their Content-Type, and responses are written as glint or JSON according to
+// the request's Accept header, so that clients that don't speak glint can still use the same endpoints.
+//
+//	http.Handle("/users", glinthttp.Handler(func(r *http.Request, req *UserQuery) (*UserList, error) {
+//		return users.Find(req)
+//	}))
+//
+// Schemas are negotiated with the X-Glint-Trust header, which lists the hashes of schemas its sender holds.
+// Handler acknowledges every request schema it decodes in full, and leaves the schema out of responses the
+// request says the client holds. Client keeps a glint.SchemaSession per server to do the same from the other
+// end, so after the first exchange neither side sends its schemas.
+package glinthttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kungfusheep/glint"
+)
+
+// Media types understood by this package
+const (
+	ContentType     = "application/x-glint"
+	JSONContentType = "application/json"
+)
+
+// DefaultMaxBodyBytes is the largest request body Handler reads, unless changed with WithMaxBodyBytes
+const DefaultMaxBodyBytes = 32 << 20
+
+// StatusError is an error carrying the HTTP status it should be reported with. Handler functions return it to
+// reply with something other than 500 Internal Server Error.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.Status, http.StatusText(e.Status), e.Err)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerOption configures optional Handler behaviour
+type HandlerOption func(*handlerConfig)
+
+// handlerConfig holds the options a Handler was built with
+type handlerConfig struct {
+	maxBodyBytes int64
+	limits       glint.DecodeLimits
+}
+
+// WithMaxBodyBytes limits the size of request bodies, larger requests are rejected with 413
+func WithMaxBodyBytes(n int64) HandlerOption {
+	return func(c *handlerConfig) {
+		c.maxBodyBytes = n
+	}
+}
+
+// WithDecodeLimits sets the limits glint request bodies are decoded with, see glint.NewDecoderWithLimits
+func WithDecodeLimits(limits glint.DecodeLimits) HandlerOption {
+	return func(c *handlerConfig) {
+		c.limits = limits
+	}
+}
+
+// Handler adapts a typed function into an http.Handler. The request body is decoded into a Req, which is left
+// as its zero value when the body is empty, and the Resp returned is written back in the format the client
+// accepts. Decoding failures are reported as 400 Bad Request, and unsupported request formats as 415.
+func Handler[Req, Resp any](fn func(r *http.Request, req *Req) (*Resp, error), opts ...HandlerOption) http.Handler {
+	c := handlerConfig{maxBodyBytes: DefaultMaxBodyBytes, limits: glint.DefaultLimits}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	dec := glint.NewDecoderWithLimits[Req](c.limits)
+	enc := glint.NewEncoder[Resp]()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, c.maxBodyBytes)
+
+		session := glint.NewSchemaSession()
+
+		var req Req
+		if err := decode(r, dec, &req, session); err != nil {
+			writeError(w, err)
+			return
+		}
+		if h, ok := session.TrustHeader(); ok {
+			w.Header().Set(h.Key(), h.Value())
+		}
+
+		resp, err := fn(r, &req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		Write(w, r, enc, resp, http.StatusOK)
+	})
+}
+
+// Decode reads a request body into v, as glint or JSON according to its Content-Type. Requests without a body
+// leave v untouched. Errors are *StatusError, glint documents written without a schema the decoder hasn't seen
+// are reported as 412 Precondition Failed.
+func Decode[T any](r *http.Request, dec *glint.Decoder[T], v *T) error {
+	return decode(r, dec, v, nil)
+}
+
+// decode implements Decode, queuing the schemas of glint requests for acknowledgement when session isn't nil
+func decode[T any](r *http.Request, dec *glint.Decoder[T], v *T, session *glint.SchemaSession) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &StatusError{http.StatusRequestEntityTooLarge, err}
+		}
+		return &StatusError{http.StatusBadRequest, err}
+	}
+	if len(body) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case ContentType:
+		if session != nil {
+			err = dec.UnmarshalWithSession(body, v, session)
+		} else {
+			err = dec.Unmarshal(body, v)
+		}
+	case JSONContentType:
+		err = json.Unmarshal(body, v)
+	default:
+		return &StatusError{http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", mediaType)}
+	}
+
+	if errors.Is(err, glint.ErrSchemaNotFound) {
+		return &StatusError{http.StatusPreconditionFailed, err}
+	}
+	if err != nil {
+		return &StatusError{http.StatusBadRequest, err}
+	}
+	return nil
+}
+
+// Write writes v as the response to r, as glint or JSON according to the request's Accept header. The schema is
+// left out of glint responses when the request's X-Glint-Trust header says the client holds it.
+func Write[T any](w http.ResponseWriter, r *http.Request, enc *glint.Encoder[T], v *T, status int) error {
+	if !AcceptsGlint(r) {
+		w.Header().Set("Content-Type", JSONContentType)
+		w.WriteHeader(status)
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	b := glint.NewBufferFromPool()
+	defer b.ReturnToPool()
+	if len(r.Header.Values("X-Glint-Trust")) > 0 {
+		session := glint.NewSchemaSession()
+		session.AcknowledgeHeader(r.Header)
+		enc.MarshalWithSession(v, b, session)
+	} else {
+		enc.Marshal(v, b)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes)))
+	w.WriteHeader(status)
+	_, err := w.Write(b.Bytes)
+	return err
+}
+
+// AcceptsGlint reports whether glint is the preferred response format for r. Glint is preferred unless the Accept
+// header rules it out, or ranks JSON above it. Requests without an Accept header accept glint.
+func AcceptsGlint(r *http.Request) bool {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return true
+	}
+
+	g, j := acceptQuality(accept, ContentType), acceptQuality(accept, JSONContentType)
+	return g > 0 && g >= j
+}
+
+// acceptQuality returns the quality the Accept header assigns to a media type, taken from its most specific
+// matching range. The result is 0 when the media type isn't acceptable.
+func acceptQuality(accept []string, mediaType string) float64 {
+	best, specificity := 0.0, -1
+
+	for _, header := range accept {
+		for _, part := range strings.Split(header, ",") {
+			rng, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+
+			s := -1
+			switch {
+			case rng == mediaType:
+				s = 2
+			case rng == "*/*":
+				s = 0
+			case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+				s = 1
+			}
+			if s <= specificity {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			best, specificity = q, s
+		}
+	}
+
+	return best
+}
+
+// writeError reports an error as plain text, with the status from a *StatusError or 500
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		status = se.Status
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,194 @@
+package glinthttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kungfusheep/glint"
+)
+
+type greetRequest struct {
+	Name string `glint:"name" json:"name"`
+}
+
+type greetResponse struct {
+	Greeting string `glint:"greeting" json:"greeting"`
+}
+
+func greetHandler(opts ...HandlerOption) http.Handler {
+	return Handler(func(r *http.Request, req *greetRequest) (*greetResponse, error) {
+		if req.Name == "" {
+			return nil, &StatusError{http.StatusUnprocessableEntity, errors.New("name required")}
+		}
+		if req.Name == "nobody" {
+			return nil, nil
+		}
+		return &greetResponse{Greeting: "hello " + req.Name}, nil
+	}, opts...)
+}
+
+func glintBody(t *testing.T, v greetRequest) io.Reader {
+	t.Helper()
+	b := &glint.Buffer{}
+	glint.NewEncoder[greetRequest]().Marshal(&v, b)
+	return bytes.NewReader(b.Bytes)
+}
+
+func TestHandler(t *testing.T) {
+
+	serve := func(h http.Handler, body io.Reader, contentType, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/greet", body)
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Glint", func(t *testing.T) {
+		w := serve(greetHandler(), glintBody(t, greetRequest{Name: "sam"}), ContentType, "")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ContentType {
+			t.Fatalf("unexpected response %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+
+		var resp greetResponse
+		if err := glint.NewDecoder[greetResponse]().Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Greeting != "hello sam" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	})
+
+	t.Run("JSONFallback", func(t *testing.T) {
+		w := serve(greetHandler(), strings.NewReader(`{"name":"sam"}`), "application/json; charset=utf-8", "application/json")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != JSONContentType {
+			t.Fatalf("unexpected response %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+
+		var resp greetResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Greeting != "hello sam" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			handler     http.Handler
+			body        io.Reader
+			contentType string
+			status      int
+		}{
+			"unsupported": {greetHandler(), strings.NewReader("name=sam"), "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+			"malformed":   {greetHandler(), strings.NewReader("not glint"), ContentType, http.StatusBadRequest},
+			"too large":   {greetHandler(WithMaxBodyBytes(4)), glintBody(t, greetRequest{Name: "sam"}), ContentType, http.StatusRequestEntityTooLarge},
+			"status":      {greetHandler(), glintBody(t, greetRequest{}), ContentType, http.StatusUnprocessableEntity},
+			"no content":  {greetHandler(), glintBody(t, greetRequest{Name: "nobody"}), ContentType, http.StatusNoContent},
+		} {
+			if w := serve(tc.handler, tc.body, tc.contentType, ""); w.Code != tc.status {
+				t.Errorf("%s: expected status %d, got %d: %s", name, tc.status, w.Code, w.Body)
+			}
+		}
+	})
+}
+
+func TestAcceptsGlint(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                      true,
+		"*/*":                                   true,
+		"application/x-glint":                   true,
+		"application/json":                      false,
+		"text/html, application/*;q=0.5":        true,
+		"application/json, application/x-glint": true,
+		"application/x-glint;q=0.5, application/json": false,
+		"application/x-glint;q=0, */*":                false,
+		"application/json;q=0.2, */*;q=0.8":           true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if got := AcceptsGlint(r); got != want {
+			t.Errorf("%q: expected %v, got %v", accept, want, got)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+
+	// records the size of each request and response body, the handler can be replaced to simulate a restart
+	var requests, responses []int
+	handler := greetHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, len(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		responses = append(responses, rec.Body.Len())
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer server.Close()
+
+	c := NewClient(server.Client())
+	enc, dec := glint.NewEncoder[greetRequest](), glint.NewDecoder[greetResponse]()
+
+	call := func(name string) {
+		t.Helper()
+		var resp greetResponse
+		if err := Call(context.Background(), c, server.URL, enc, dec, &greetRequest{Name: name}, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Greeting != "hello "+name {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	}
+
+	t.Run("Trust", func(t *testing.T) {
+		call("sam")
+		call("sam")
+		if len(requests) != 2 || requests[1] >= requests[0] || responses[1] >= responses[0] {
+			t.Errorf("expected schemas to be left out after the first call, got requests %v responses %v", requests, responses)
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		requests, responses = nil, nil
+		handler = greetHandler() // a new decoder, which hasn't seen the request schema
+
+		call("kim")
+		if len(requests) != 2 || requests[1] <= requests[0] {
+			t.Errorf("expected the request to be sent again with its schema, got requests %v", requests)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		var resp greetResponse
+		err := Call(context.Background(), c, server.URL, enc, dec, &greetRequest{}, &resp)
+
+		var se *StatusError
+		if !errors.As(err, &se) || se.Status != http.StatusUnprocessableEntity {
+			t.Errorf("expected a 422 status error, got %v", err)
+		}
+	})
+}
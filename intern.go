@@ -0,0 +1,56 @@
+package glint
+
+import (
+	"sync"
+)
+
+// StringInterner holds a single copy of each string it's given, so that equal strings decoded from many documents
+// share their memory. Set DecodeLimits.InternMapKeys to intern the string keys of decoded maps, which are usually
+// drawn from a small set that repeats across entries and documents.
+//
+// Strings decoded without interning refer directly into the document they came from, keeping all of it alive for
+// as long as any of them are, and changing if the document's bytes are reused. Interned strings are copies,
+// allocated only the first time each is seen. An interner is safe for concurrent use and may be shared between
+// decoders.
+type StringInterner struct {
+	mu      sync.RWMutex
+	strings map[string]string
+	max     int
+}
+
+// NewStringInterner creates an interner that holds up to max strings, or any number when max is 0. Strings seen
+// once it's full are copied without being held.
+func NewStringInterner(max int) *StringInterner {
+	return &StringInterner{strings: map[string]string{}, max: max}
+}
+
+// Intern returns the held copy of s, copying and holding s first when there isn't one. s may refer to memory that
+// changes later, the result never does.
+func (in *StringInterner) Intern(s string) string {
+	in.mu.RLock()
+	held, ok := in.strings[s]
+	in.mu.RUnlock()
+	if ok {
+		return held
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if held, ok := in.strings[s]; ok { // interned by another goroutine in the meantime
+		return held
+	}
+
+	held = string([]byte(s))
+	if in.max == 0 || len(in.strings) < in.max {
+		in.strings[held] = held
+	}
+	return held
+}
+
+// Len returns the number of strings held
+func (in *StringInterner) Len() int {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return len(in.strings)
+}
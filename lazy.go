@@ -0,0 +1,365 @@
+package glint
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"time"
+)
+
+// ErrFieldNotFound is returned when a LazyDocument has no field with the requested name
+var ErrFieldNotFound = errors.New("field not found in document")
+
+// LazyDocument reads individual fields from a document by name, without decoding the rest of it. It suits
+// documents that are much larger than the handful of fields a reader needs, such as rows of a large file that's
+// been memory mapped.
+//
+// Fields are indexed as they're asked for. Finding a field walks the schema and body up to it once, recording
+// where every field along the way starts and ends, so later lookups of those fields don't read the document
+// again. Fields are never decoded until they're asked for.
+//
+// Values returned by Bytes and Raw share memory with the document. A LazyDocument isn't safe for concurrent use.
+type LazyDocument struct {
+	schema Reader // schema entries not yet indexed
+	body   Reader // body, positioned after the last indexed field
+	fields []lazyField
+
+	skip *decoderImpl // skips structs, slices and maps while indexing
+}
+
+// lazyField is the location of a single indexed field
+type lazyField struct {
+	name   string
+	wire   WireType
+	schema []byte // sub-schema of structs, slices and maps
+	value  []byte // body bytes, including the presence byte of pointers
+}
+
+// NewLazyDocument prepares a document for reading fields by name, with DefaultLimits
+func NewLazyDocument(doc []byte) (*LazyDocument, error) {
+	return NewLazyDocumentWithLimits(doc, DefaultLimits)
+}
+
+// NewLazyDocumentWithLimits prepares a document for reading fields by name. The schema is checked against the
+// limits up front. Documents written without their schema, as in trusted schema mode, return ErrSchemaNotFound.
+func NewLazyDocumentWithLimits(doc []byte, limits DecodeLimits) (*LazyDocument, error) {
+	p, err := Document(doc).parts(limits)
+	if err != nil {
+		return nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return nil, ErrSchemaNotFound
+	}
+
+	return &LazyDocument{schema: p.schema, body: p.body, skip: newDecoderWithLimits(struct{}{}, limits)}, nil
+}
+
+// Has reports whether the document has a field with the supplied name
+func (l *LazyDocument) Has(name string) bool {
+	_, err := l.field(name)
+	return err == nil
+}
+
+// Fields returns the names of every field in the document, in the order they were written. The whole document
+// is indexed as a result.
+func (l *LazyDocument) Fields() ([]string, error) {
+	if _, err := l.indexUntil(func(*lazyField) bool { return false }); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(l.fields))
+	for i := range l.fields {
+		names[i] = l.fields[i].name
+	}
+	return names, nil
+}
+
+// Raw returns the wire type and encoded value of a field. The value of a nil pointer is nil, otherwise pointers
+// are returned without their presence byte, and the wire type keeps its WirePtrFlag.
+func (l *LazyDocument) Raw(name string) (wire WireType, value []byte, err error) {
+	f, err := l.field(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	value, _ = f.present()
+	return f.wire, value, nil
+}
+
+// String returns the value of a string field. Nil pointers return an empty string.
+func (l *LazyDocument) String(name string) (string, error) {
+	r, ok, err := l.primitive(name, "a string", WireString, WireString|WireDictFlag)
+	if !ok {
+		return "", err
+	}
+	if r.wire&WireDictFlag > 0 {
+		i := r.ReadVarint()
+		s, ok := r.tableString(i)
+		if !ok {
+			return "", fmt.Errorf("%w: string table entry %d out of range", ErrInvalidDocument, i)
+		}
+		return string([]byte(s)), nil
+	}
+	return string(r.Read(r.ReadVarint())), nil
+}
+
+// Bytes returns the value of a []byte field, sharing memory with the document. Nil pointers return nil.
+func (l *LazyDocument) Bytes(name string) ([]byte, error) {
+	r, ok, err := l.primitive(name, "a byte slice", WireBytes)
+	if !ok {
+		return nil, err
+	}
+	return r.Read(r.ReadVarint()), nil
+}
+
+// Int returns the value of any signed integer field, widened to int64. Nil pointers return 0.
+func (l *LazyDocument) Int(name string) (int64, error) {
+	r, ok, err := l.primitive(name, "a signed integer", WireInt, WireInt8, WireInt16, WireInt32, WireInt64,
+		WireInt64|WireZigzagFlag)
+	if !ok {
+		return 0, err
+	}
+
+	switch r.wire {
+	case WireInt8:
+		return int64(r.ReadInt8()), nil
+	case WireInt16:
+		return int64(r.ReadInt16()), nil
+	case WireInt32:
+		return int64(r.ReadInt32()), nil
+	case WireInt64:
+		return r.ReadInt64(), nil
+	case WireInt64 | WireZigzagFlag:
+		return r.ReadZigzagInt64(), nil
+	}
+	return int64(r.ReadInt()), nil
+}
+
+// Uint returns the value of any unsigned integer field, widened to uint64. Nil pointers return 0.
+func (l *LazyDocument) Uint(name string) (uint64, error) {
+	r, ok, err := l.primitive(name, "an unsigned integer", WireUint, WireUint8, WireUint16, WireUint32, WireUint64)
+	if !ok {
+		return 0, err
+	}
+
+	switch r.wire {
+	case WireUint8:
+		return uint64(r.ReadUint8()), nil
+	case WireUint16:
+		return uint64(r.ReadUint16()), nil
+	case WireUint32:
+		return uint64(r.ReadUint32()), nil
+	case WireUint64:
+		return r.ReadUint64(), nil
+	}
+	return uint64(r.ReadUint()), nil
+}
+
+// Float returns the value of a float32 or float64 field, widened to float64. Nil pointers return 0.
+func (l *LazyDocument) Float(name string) (float64, error) {
+	r, ok, err := l.primitive(name, "a float", WireFloat32, WireFloat64)
+	if !ok {
+		return 0, err
+	}
+
+	if r.wire == WireFloat32 {
+		return float64(r.ReadFloat32()), nil
+	}
+	return r.ReadFloat64(), nil
+}
+
+// Bool returns the value of a bool field. Nil pointers return false.
+func (l *LazyDocument) Bool(name string) (bool, error) {
+	r, ok, err := l.primitive(name, "a bool", WireBool)
+	if !ok {
+		return false, err
+	}
+	return r.ReadBool(), nil
+}
+
+// Time returns the value of a time.Time field. Nil pointers return the zero time.
+func (l *LazyDocument) Time(name string) (time.Time, error) {
+	r, ok, err := l.primitive(name, "a time", WireTime)
+	if !ok {
+		return time.Time{}, err
+	}
+	return r.ReadTime(), nil
+}
+
+// Duration returns the value of a time.Duration field. Nil pointers return 0.
+func (l *LazyDocument) Duration(name string) (time.Duration, error) {
+	r, ok, err := l.primitive(name, "a duration", WireDuration, WireDuration|WireZigzagFlag)
+	if !ok {
+		return 0, err
+	}
+	if r.wire&WireZigzagFlag > 0 {
+		return time.Duration(r.ReadZigzagInt64()), nil
+	}
+	return r.ReadDuration(), nil
+}
+
+// Addr returns the value of a net.IP or netip.Addr field. Nil pointers and nil IPs return the zero Addr.
+func (l *LazyDocument) Addr(name string) (netip.Addr, error) {
+	r, ok, err := l.primitive(name, "an IP address", WireIP)
+	if !ok {
+		return netip.Addr{}, err
+	}
+	return r.ReadAddr(), nil
+}
+
+// Prefix returns the value of a net.IPNet or netip.Prefix field. Nil pointers return the zero Prefix.
+func (l *LazyDocument) Prefix(name string) (netip.Prefix, error) {
+	r, ok, err := l.primitive(name, "an IP prefix", WireIPPrefix)
+	if !ok {
+		return netip.Prefix{}, err
+	}
+	return r.ReadPrefix(), nil
+}
+
+// BigInt returns the value of a big.Int field. Nil pointers return nil.
+func (l *LazyDocument) BigInt(name string) (*big.Int, error) {
+	r, ok, err := l.primitive(name, "a big integer", WireBigInt)
+	if !ok {
+		return nil, err
+	}
+	return r.ReadBigInt(), nil
+}
+
+// Decimal returns the value of a Decimal field. Nil pointers return the zero Decimal.
+func (l *LazyDocument) Decimal(name string) (Decimal, error) {
+	r, ok, err := l.primitive(name, "a decimal", WireDecimal)
+	if !ok {
+		return Decimal{}, err
+	}
+	return r.ReadDecimal(), nil
+}
+
+// Struct returns a nested struct field as a LazyDocument of its own, sharing memory with the document. Nil
+// pointers return ErrFieldNotFound.
+func (l *LazyDocument) Struct(name string) (*LazyDocument, error) {
+	f, err := l.field(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.wire&^WirePtrFlag != WireStruct {
+		return nil, fmt.Errorf("field %q is %v, not a struct", name, f.wire)
+	}
+
+	value, ok := f.present()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q is nil", ErrFieldNotFound, name)
+	}
+
+	schema := NewReader(f.schema)
+	body := Reader{bytes: value, strings: l.body.strings} // nested fields share the document's string table
+	return &LazyDocument{schema: NewReader(schema.Read(schema.ReadVarint())), body: body, skip: l.skip}, nil
+}
+
+// lazyReader is a Reader over a single field's value, along with the field's wire type without its pointer flag
+type lazyReader struct {
+	Reader
+	wire WireType
+}
+
+// primitive finds a field that must be one of the supplied wire types, returning a reader over its value. ok is
+// false when there's nothing to read, either because of an error or because the field is a nil pointer.
+func (l *LazyDocument) primitive(name, kind string, wires ...WireType) (r lazyReader, ok bool, err error) {
+	f, err := l.field(name)
+	if err != nil {
+		return r, false, err
+	}
+
+	base := f.wire &^ WirePtrFlag
+	for _, w := range wires {
+		if base != w {
+			continue
+		}
+
+		value, present := f.present()
+		return lazyReader{Reader{bytes: value, strings: l.body.strings}, base}, present, nil
+	}
+
+	return r, false, fmt.Errorf("field %q is %v, not %s", name, f.wire, kind)
+}
+
+// field returns the named field, indexing the document up to it if it hasn't been already
+func (l *LazyDocument) field(name string) (*lazyField, error) {
+	for i := range l.fields {
+		if l.fields[i].name == name {
+			return &l.fields[i], nil
+		}
+	}
+
+	f, err := l.indexUntil(func(f *lazyField) bool { return f.name == name })
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, fmt.Errorf("%w: %q", ErrFieldNotFound, name)
+	}
+	return f, nil
+}
+
+// indexUntil indexes fields until one matches, returning nil when the end of the document is reached first
+func (l *LazyDocument) indexUntil(match func(*lazyField) bool) (f *lazyField, err error) {
+
+	defer func() {
+		if err != nil { // the rest of the document can't be trusted
+			l.schema, l.body = Reader{}, Reader{strings: l.body.strings}
+		}
+	}()
+	defer recoverErr(&err)
+
+	for l.schema.BytesLeft() > 0 {
+		if err := l.index(); err != nil {
+			return nil, err
+		}
+		if f := &l.fields[len(l.fields)-1]; match(f) {
+			return f, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// index reads the next schema entry and moves the body past its value
+func (l *LazyDocument) index() error {
+	l.schema.SetMark()
+	wire := WireType(l.schema.ReadVarint())
+	name := string(l.schema.Read(uint(l.schema.ReadByte())))
+
+	sub := l.schema.position - l.schema.Mark()
+	parseSchemaNode(wire, &l.schema)
+	entry := l.schema.BytesFromMark()
+
+	f := lazyField{name: name, wire: wire, schema: entry[sub:]}
+	value := l.body.Remaining()
+
+	switch {
+	case isCompositeWire(wire):
+		// the skip decoder has no fields, so builds an instruction that reads past this one
+		instructions, _, err := l.skip.parseSchema(NewReader(entry), nil)
+		if err != nil {
+			return err
+		}
+		l.body = l.skip.unmarshal(l.body, instructions, struct{}{})
+
+	case wire&WirePtrFlag == 0 || l.body.ReadByte() != 0:
+		fieldBytes(&l.body, wire&^WirePtrFlag)
+	}
+
+	f.value = value[:len(value)-int(l.body.BytesLeft())]
+	l.fields = append(l.fields, f)
+	return nil
+}
+
+// present returns the field's value without any presence byte, and false when the field is a nil pointer
+func (f *lazyField) present() ([]byte, bool) {
+	if f.wire&WirePtrFlag == 0 {
+		return f.value, true
+	}
+	if f.value[0] == 0 {
+		return nil, false
+	}
+	return f.value[1:], true
+}
@@ -17,6 +17,10 @@ type mapDecoder struct {
 	keyWire     WireType     // the wire type of the map keys
 	valueWire   WireType     // the wire type of the map values
 	limits      DecodeLimits // bounds checking configuration
+
+	duplicates DuplicateKeyPolicy // what to do with keys already decoded into the map, see WithDuplicateMapKeys
+	metrics    MetricsSink        // when set, duplicate keys are reported to it, see WithDecoderMetrics
+	name       string             // the name of the root decoder's type, for reporting metrics
 }
 
 func newMapDecoderUsingTagAndOpts(t any, usingTagName string, opts tagOptions) *mapDecoder {
@@ -27,6 +31,7 @@ func newMapDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts tag
 
 	m := &mapDecoder{}
 	m.limits = limits
+	md := m // the instructions below shadow m
 
 	tt := reflect.TypeOf(t)
 
@@ -43,17 +48,26 @@ func newMapDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts tag
 
 			m := reflect.NewAt(tt, t).Elem()
 
-			ml := r.ReadUint() // number of items we expect to decode from the document
+			ml := readMapLength(&r, limits.MaxMapEntries) // number of items we expect to decode from the document
 
 			if *(*unsafe.Pointer)(t) == unsafe.Pointer(nil) {
 				m.Set(reflect.MakeMapWithSize(tt, int(ml)))
 			}
 			mapp := *(*map[string]string)(t)
 
+			detect := md.detectDuplicates()
+			var seen map[string]struct{}
+			if detect && len(mapp) > 0 {
+				seen = map[string]struct{}{}
+			}
+
 			for i := uint(0); i < ml; i++ {
-				key := r.ReadString()
+				key := md.internKey(r.ReadString())
 				value := r.ReadString()
 
+				if detect && seenKey(mapp, seen, key) && md.duplicateKey(key) {
+					continue
+				}
 				mapp[key] = value
 			}
 			return r
@@ -65,17 +79,26 @@ func newMapDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts tag
 
 			m := reflect.NewAt(tt, t).Elem()
 
-			ml := r.ReadUint() // number of items we expect to decode from the document
+			ml := readMapLength(&r, limits.MaxMapEntries) // number of items we expect to decode from the document
 
 			if *(*unsafe.Pointer)(t) == unsafe.Pointer(nil) {
 				m.Set(reflect.MakeMapWithSize(tt, int(ml)))
 			}
 			mapp := *(*map[string]int)(t)
 
+			detect := md.detectDuplicates()
+			var seen map[string]struct{}
+			if detect && len(mapp) > 0 {
+				seen = map[string]struct{}{}
+			}
+
 			for i := uint(0); i < ml; i++ {
-				key := r.ReadString()
+				key := md.internKey(r.ReadString())
 				value := r.ReadInt()
 
+				if detect && seenKey(mapp, seen, key) && md.duplicateKey(key) {
+					continue
+				}
 				mapp[key] = value
 			}
 			return r
@@ -93,17 +116,40 @@ func newMapDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts tag
 
 			m := reflect.NewAt(tt, t).Elem()
 
-			ml := r.ReadUint() // number of items we expect to decode from the document
+			ml := readMapLength(&r, limits.MaxMapEntries) // number of items we expect to decode from the document
 
 			if *(*unsafe.Pointer)(t) == unsafe.Pointer(nil) {
 				m.Set(reflect.MakeMapWithSize(tt, int(ml)))
 			}
 
+			detect := md.detectDuplicates()
+			var seen map[any]struct{}
+			if detect && m.Len() > 0 {
+				seen = map[any]struct{}{}
+			}
+
 			for i := uint(0); i < ml; i++ {
 				var key, value reflect.Value
 				key, r = k.fun(r)
 				value, r = v.fun(r)
 
+				if key.Kind() == reflect.String && md.limits.InternMapKeys != nil {
+					key = reflect.ValueOf(md.limits.InternMapKeys.Intern(key.String())).Convert(key.Type())
+				}
+
+				if detect {
+					dup := false
+					if seen == nil {
+						dup = m.MapIndex(key).IsValid()
+					} else {
+						_, dup = seen[key.Interface()]
+						seen[key.Interface()] = struct{}{}
+					}
+					if dup && md.duplicateKey(key.Interface()) {
+						continue
+					}
+				}
+
 				if v.assigner.pointer {
 					value = toPointer(value)
 				}
@@ -118,6 +164,60 @@ func newMapDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts tag
 	return m
 }
 
+// readMapLength reads the number of entries in a map. Every entry takes at least a byte for its key, so a count
+// beyond the bytes remaining can't be genuine, and is rejected before it sizes the map, as is one beyond limit.
+func readMapLength(r *Reader, limit uint) uint {
+	ml := r.ReadUint()
+	checkLimit(ml, limit, "map")
+	if ml > r.BytesLeft() {
+		panic(fmt.Errorf("%w: map length %d exceeds remaining bytes %d", ErrTruncatedDocument, ml, r.BytesLeft()))
+	}
+	return ml
+}
+
+// internKey returns the interned copy of a string key, or the key itself when keys aren't interned
+func (m *mapDecoder) internKey(key string) string {
+	if m.limits.InternMapKeys == nil {
+		return key
+	}
+	return m.limits.InternMapKeys.Intern(key)
+}
+
+// detectDuplicates reports whether duplicate keys need finding at all, which costs a lookup per entry
+func (m *mapDecoder) detectDuplicates() bool {
+	return m.duplicates != DuplicateKeysLastWins || m.metrics != nil
+}
+
+// duplicateKey applies the duplicate key policy to a key that was already decoded into the map, returning true
+// when the entry should be discarded
+func (m *mapDecoder) duplicateKey(key any) bool {
+	if m.metrics != nil {
+		m.metrics.DuplicateMapKey(m.name)
+	}
+
+	switch m.duplicates {
+	case DuplicateKeysError:
+		panic(fmt.Errorf("%w: %v", ErrDuplicateMapKey, key))
+	case DuplicateKeysFirstWins:
+		return true
+	}
+	return false
+}
+
+// seenKey reports whether key was already decoded into target. Maps decoded from empty are checked against
+// themselves, maps that held entries beforehand keep a separate record of the keys decoded into them in seen.
+func seenKey[K comparable, V any](target map[K]V, seen map[K]struct{}, key K) bool {
+	if seen == nil {
+		_, ok := target[key]
+		return ok
+	}
+	if _, ok := seen[key]; ok {
+		return true
+	}
+	seen[key] = struct{}{}
+	return false
+}
+
 func toPointer(value reflect.Value) reflect.Value {
 	if value.IsValid() {
 		// Check if the value is addressable
@@ -167,26 +267,44 @@ func (m *mapDecoder) parseSchema(r Reader, instructions []decodeInstruction) ([]
 		m.keyKind = m.keyWire
 		m.valueKind = m.valueWire
 
-		tt := mapWireTypesToReflectKind(m.keyWire, m.valueWire)
-
-		k := reflectKindToReflectValue(tt.Key(), "", "", m.limits)
-		v := reflectKindToReflectValue(tt.Elem(), "", "", m.limits)
-		if v.subDecoder != nil {
-			m.subdec = v.subDecoder
-		}
-
-		m.instruction = func(t unsafe.Pointer, r Reader) Reader {
+		k := reflectKindToReflectValue(WireTypeToReflectType(m.keyWire), "", "", m.limits)
+
+		var skipValue func(Reader) Reader
+		switch {
+		case m.valueWire == WireMap:
+			// the key and value types of nested maps follow in the schema, so they're skipped by a map decoder
+			// of their own, which builds its instruction when the schema is passed down to it below
+			sub := &mapDecoder{limits: m.limits}
+			m.subdec = sub
+			skipValue = func(r Reader) Reader {
+				return sub.instruction(nil, r)
+			}
 
-			m := reflect.NewAt(tt, t).Elem()
+		case m.valueWire&WireSliceFlag > 0:
+			// likewise for slices, which may hold maps of their own
+			sub := &sliceDecoder{limits: m.limits}
+			m.subdec = sub
+			skipValue = func(r Reader) Reader {
+				return sub.instruction(nil, r)
+			}
 
-			if *(*unsafe.Pointer)(t) == unsafe.Pointer(nil) {
-				m.Set(reflect.MakeMap(tt))
+		default:
+			tt := mapWireTypesToReflectKind(m.keyWire, m.valueWire)
+			v := reflectKindToReflectValue(tt.Elem(), "", "", m.limits)
+			if v.subDecoder != nil {
+				m.subdec = v.subDecoder
 			}
+			skipValue = func(r Reader) Reader {
+				_, r = v.fun(r)
+				return r
+			}
+		}
 
-			ml := r.ReadUint()
+		m.instruction = func(t unsafe.Pointer, r Reader) Reader {
+			ml := readMapLength(&r, m.limits.MaxMapEntries)
 			for i := uint(0); i < ml; i++ {
 				_, r = k.fun(r)
-				_, r = v.fun(r)
+				r = skipValue(r)
 				// we're not interested in storing the values, just making sure they're read past by the
 			}
 
@@ -194,8 +312,8 @@ func (m *mapDecoder) parseSchema(r Reader, instructions []decodeInstruction) ([]
 		}
 	}
 
-	if m.keyKind != m.keyWire || m.valueKind != m.valueWire {
-		return nil, r, fmt.Errorf("schema mismatch for map, expected id %v[%v] got %v[%v]", m.keyKind, m.valueKind, m.keyWire, m.valueWire)
+	if m.keyKind != m.keyWire || (m.valueKind != m.valueWire && !durationCompatible(m.valueKind, m.valueWire)) {
+		return nil, r, fmt.Errorf("%w for map, expected id %v[%v] got %v[%v]", ErrSchemaMismatch, m.keyKind, m.valueKind, m.keyWire, m.valueWire)
 	}
 
 	if m.subdec != nil {
@@ -1,7 +1,9 @@
 package glint
 
 import (
+	"bytes"
 	"reflect"
+	"sort"
 	"time"
 	"unsafe"
 )
@@ -13,7 +15,7 @@ type mapEncoder struct {
 	schema      *Buffer                           // if our data requires us to define more schema data it will be written here
 }
 
-func newMapEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buffer, opts tagOptions) *mapEncoder {
+func newMapEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buffer, opts tagOptions, config encoderConfig) *mapEncoder {
 
 	m := &mapEncoder{}
 	m.schema = sc
@@ -36,6 +38,10 @@ func newMapEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buff
 	m.schema.AppendUint(uint(valueType))
 
 	switch {
+	case config.canonical:
+		k, v := m.appenders(key, value, usingTagName, opts, config)
+		m.instruction = canonicalMapInstruction(m.tt, k, v)
+
 	case key.Kind() == reflect.String && value.Kind() == reflect.String:
 
 		m.instruction = func(t unsafe.Pointer, w *Buffer) {
@@ -72,16 +78,7 @@ func newMapEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buff
 
 	default:
 
-		k := reflectKindToAppender(key, usingTagName, opts)
-		if k.subenc != nil {
-			m.schema.Bytes = append(m.schema.Bytes, k.subenc.Schema().Bytes...)
-			k.subenc.ClearSchema()
-		}
-		v := reflectKindToAppender(value, usingTagName, opts)
-		if v.subenc != nil {
-			m.schema.Bytes = append(m.schema.Bytes, v.subenc.Schema().Bytes...)
-			v.subenc.ClearSchema()
-		}
+		k, v := m.appenders(key, value, usingTagName, opts, config)
 
 		m.instruction = func(t unsafe.Pointer, w *Buffer) {
 			m := reflect.NewAt(m.tt, t).Elem()
@@ -98,27 +95,8 @@ func newMapEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buff
 				key := iter.Key()
 				value := iter.Value()
 
-				var keyPtr, valuePtr unsafe.Pointer
-				if key.CanAddr() {
-					keyPtr = unsafe.Pointer(key.Addr().Pointer())
-				} else {
-					// Handle the case when key cannot be addressed
-					tempVal := reflect.New(key.Type()).Elem()
-					tempVal.Set(key)
-					keyPtr = unsafe.Pointer(tempVal.Addr().Pointer())
-				}
-
-				if value.CanAddr() {
-					valuePtr = unsafe.Pointer(value.Addr().Pointer())
-				} else {
-					// Handle the case when value cannot be addressed
-					tempVal := reflect.New(value.Type()).Elem()
-					tempVal.Set(value)
-					valuePtr = unsafe.Pointer(tempVal.Addr().Pointer())
-				}
-
-				k.fun(keyPtr, w)
-				v.fun(valuePtr, w)
+				k.fun(addressOf(key), w)
+				v.fun(addressOf(value), w)
 			}
 		}
 	}
@@ -126,6 +104,74 @@ func newMapEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buff
 	return m
 }
 
+// appenders builds the appenders for the keys and values of a map, adding any schema they need to the map's own
+func (m *mapEncoder) appenders(key, value reflect.Type, usingTagName string, opts tagOptions, config encoderConfig) (appender, appender) {
+	k := reflectKindToAppender(key, usingTagName, opts, config)
+	if k.subenc != nil {
+		m.schema.Bytes = append(m.schema.Bytes, k.subenc.Schema().Bytes...)
+		k.subenc.ClearSchema()
+	}
+	v := reflectKindToAppender(value, usingTagName, opts, config)
+	if v.subenc != nil {
+		m.schema.Bytes = append(m.schema.Bytes, v.subenc.Schema().Bytes...)
+		v.subenc.ClearSchema()
+	}
+	return k, v
+}
+
+// canonicalMapInstruction writes map entries in order of their encoded keys, so that the output doesn't depend on
+// Go's randomised map iteration order
+func canonicalMapInstruction(tt reflect.Type, k, v appender) func(t unsafe.Pointer, w *Buffer) {
+
+	type entry struct {
+		start, value, end int // offsets of the key and value within the scratch buffer
+	}
+
+	return func(t unsafe.Pointer, w *Buffer) {
+		if *(*unsafe.Pointer)(t) == unsafe.Pointer(nil) {
+			w.AppendUint(0) // zero length
+			return
+		}
+
+		m := reflect.NewAt(tt, t).Elem()
+
+		// entries are encoded up front so they can be sorted by the bytes of their keys
+		var scratch Buffer
+		entries := make([]entry, 0, m.Len())
+
+		iter := m.MapRange()
+		for iter.Next() {
+			e := entry{start: len(scratch.Bytes)}
+			k.fun(addressOf(iter.Key()), &scratch)
+			e.value = len(scratch.Bytes)
+			v.fun(addressOf(iter.Value()), &scratch)
+			e.end = len(scratch.Bytes)
+			entries = append(entries, e)
+		}
+
+		sort.Slice(entries, func(a, b int) bool {
+			ea, eb := entries[a], entries[b]
+			return bytes.Compare(scratch.Bytes[ea.start:ea.value], scratch.Bytes[eb.start:eb.value]) < 0
+		})
+
+		w.AppendUint(uint(len(entries))) // length
+		for _, e := range entries {
+			w.Bytes = append(w.Bytes, scratch.Bytes[e.start:e.end]...)
+		}
+	}
+}
+
+// addressOf returns a pointer to the data held by v, copying it somewhere addressable first if needed
+func addressOf(v reflect.Value) unsafe.Pointer {
+	if v.CanAddr() {
+		return unsafe.Pointer(v.Addr().Pointer())
+	}
+
+	tmp := reflect.New(v.Type()).Elem()
+	tmp.Set(v)
+	return unsafe.Pointer(tmp.Addr().Pointer())
+}
+
 type appender struct {
 	fun     func(unsafe.Pointer, *Buffer)
 	pointer bool
@@ -133,7 +179,7 @@ type appender struct {
 }
 
 // reflectKindToAppender returns a function that can be used to append the data
-func reflectKindToAppender(k reflect.Type, usingTagName string, opts tagOptions) appender {
+func reflectKindToAppender(k reflect.Type, usingTagName string, opts tagOptions, config encoderConfig) appender {
 
 	pointerWrap := false
 	var fun func(unsafe.Pointer, *Buffer)
@@ -217,7 +263,7 @@ func reflectKindToAppender(k reflect.Type, usingTagName string, opts tagOptions)
 
 	case reflect.Map:
 
-		mpEnc := newMapEncoderUsingTagWithSchemaAndOpts(reflect.New(k).Elem().Interface(), usingTagName, &Buffer{}, opts)
+		mpEnc := newMapEncoderUsingTagWithSchemaAndOpts(reflect.New(k).Elem().Interface(), usingTagName, &Buffer{}, opts, config)
 		fun = func(p unsafe.Pointer, b *Buffer) {
 			var em = p
 			mpEnc.Marshal(em, b)
@@ -227,7 +273,7 @@ func reflectKindToAppender(k reflect.Type, usingTagName string, opts tagOptions)
 	case reflect.Slice:
 
 		// create a slice encoder to handle the slice type then hand off to it in the fun
-		slEnc := newSliceEncoderUsingTagWithSchemaAndOpts(reflect.New(k).Elem().Interface(), usingTagName, &Buffer{}, opts)
+		slEnc := newSliceEncoderUsingTagWithSchemaAndOpts(reflect.New(k).Elem().Interface(), usingTagName, &Buffer{}, opts, config)
 		fun = func(p unsafe.Pointer, b *Buffer) {
 			var em = p
 			slEnc.Marshal(em, b)
@@ -239,7 +285,7 @@ func reflectKindToAppender(k reflect.Type, usingTagName string, opts tagOptions)
 		// check first if we're a time field because we have a bespoke method for encoding time
 		if k == timeType {
 			fun = func(p unsafe.Pointer, b *Buffer) {
-				b.AppendTime(*(*time.Time)(p))
+				config.appendTime(b, *(*time.Time)(p))
 			}
 			break
 		}
@@ -252,7 +298,7 @@ func reflectKindToAppender(k reflect.Type, usingTagName string, opts tagOptions)
 			inf = reflect.New(k).Elem().Interface()
 		}
 
-		se := newEncoderUsingTag(inf, usingTagName)
+		se := newEncoderUsingTagWithConfig(inf, usingTagName, config)
 
 		fun = func(p unsafe.Pointer, b *Buffer) {
 			var em any = p
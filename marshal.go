@@ -0,0 +1,95 @@
+package glint
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// rootEncoders and rootDecoders hold the encoder and decoder built for each type passed to Marshal and Unmarshal
+var (
+	rootEncoders sync.Map // reflect.Type -> *encoderImpl
+	rootDecoders sync.Map // reflect.Type -> *decoderImpl
+)
+
+// Marshal returns v written as a glint document, in the manner of encoding/json. v is a struct or a map, or a
+// pointer to one, tagged as it would be for NewEncoder.
+//
+// The encoder for each type is built on its first use and kept for later calls, so Marshal suits scripts and tests
+// that don't want to hold onto an Encoder. The document is copied out of a pooled buffer, which costs an allocation
+// an Encoder writing into a buffer of its caller's wouldn't.
+func Marshal(v any) (doc []byte, err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer {
+		if !rv.IsValid() {
+			return nil, fmt.Errorf("glint: cannot marshal nil")
+		}
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		rv = p
+	} else if rv.IsNil() {
+		return nil, fmt.Errorf("glint: cannot marshal a nil %s", rv.Type())
+	}
+
+	t := rv.Type().Elem()
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+		return nil, fmt.Errorf("glint: cannot marshal %s, documents are structs or maps", t)
+	}
+
+	defer func() {
+		if rc := recover(); rc != nil { // encoders panic on types they can't be built for
+			doc, err = nil, packageError(rc)
+		}
+	}()
+
+	e, ok := rootEncoders.Load(t)
+	if !ok {
+		e, _ = rootEncoders.LoadOrStore(t, newRootEncoder(reflect.Zero(t).Interface(), "glint", newEncoderConfig(nil)))
+	}
+
+	b := NewBufferFromPool()
+	defer b.ReturnToPool()
+
+	e.(*encoderImpl).marshalDocument(rv.UnsafePointer(), b, 0)
+	return append([]byte(nil), b.Bytes...), nil
+}
+
+// Unmarshal decodes the glint document in data into v, which must be a non-nil pointer to a struct or a map, in
+// the manner of encoding/json. The decoder for each type is built on its first use and kept for later calls, with
+// DefaultLimits.
+//
+// Strings and byte slices are copied out of data, so data can be reused as soon as Unmarshal returns. A Decoder
+// can leave them sharing its memory, which is cheaper, see WithZeroCopyStrings.
+func Unmarshal(data []byte, v any) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("glint: cannot unmarshal into %T, a non-nil pointer is needed", v)
+	}
+
+	t := rv.Type().Elem()
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+		return fmt.Errorf("glint: cannot unmarshal into %s, documents are structs or maps", t)
+	}
+
+	d, ok := rootDecoders.Load(t)
+	if !ok {
+		defer func() {
+			if rc := recover(); rc != nil { // decoders panic on types they can't be built for
+				err = packageError(rc)
+			}
+		}()
+
+		dec := newDecoderWithLimits(reflect.Zero(t).Interface(), DefaultLimits)
+		dec.copies = copyAll
+		d, _ = rootDecoders.LoadOrStore(t, dec)
+	}
+	return d.(*decoderImpl).Unmarshal(data, v)
+}
+
+// packageError returns the value recovered from a panic building or running an encoder or decoder as an error
+func packageError(rc any) error {
+	if e, ok := rc.(error); ok {
+		return fmt.Errorf("glint: %w", e)
+	}
+	return fmt.Errorf("glint: %v", rc)
+}
@@ -0,0 +1,79 @@
+package glint
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Marshaler is implemented by types that write themselves as one of glint's primitive wire types, such as a UUID
+// written as WireBytes or a decimal written as WireString. Unlike the encoder tag option, which writes opaque
+// bytes, the field's schema carries the wire type the type chooses, so tools and other languages read it as that
+// type.
+//
+// GlintWireType is called once, on the zero value, when an encoder is built. It must return a primitive wire type,
+// that is WireBool through WireBytes, or WireTime through WireDecimal, without any flags. MarshalGlint then writes
+// exactly one value of that type with the matching Buffer method, e.g. AppendString for WireString. Pointers to the type are handled
+// with a presence byte, as with any other pointer field.
+//
+// Marshaler and Unmarshaler apply to struct fields, not to elements of slices or maps.
+type Marshaler interface {
+	GlintWireType() WireType
+	MarshalGlint(b *Buffer)
+}
+
+// Unmarshaler is the decoding counterpart of Marshaler. UnmarshalGlint reads a single value of the type's wire type
+// from r, which holds only that value. Returning an error stops decoding, in the same way as a malformed body.
+type Unmarshaler interface {
+	GlintWireType() WireType
+	UnmarshalGlint(r *Reader) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// customWire returns the wire type chosen by a Marshaler or Unmarshaler of type t, panicking if it isn't primitive
+func customWire(t reflect.Type, v interface{ GlintWireType() WireType }) WireType {
+	wire := v.GlintWireType()
+	if wire < WireBool || (wire > WireBytes && wire < WireTime) || wire > WireDecimal {
+		panic(fmt.Sprintf("GlintWireType of %v returned %v, it must be a primitive wire type", t, wire))
+	}
+	return wire
+}
+
+// marshalerInstruction returns the wire type and encoding function for a field of type t, when t implements
+// Marshaler through a pointer to it
+func marshalerInstruction(t reflect.Type) (WireType, func(unsafe.Pointer, *Buffer), bool) {
+	if !reflect.PointerTo(t).Implements(marshalerType) {
+		return 0, nil, false
+	}
+
+	wire := customWire(t, reflect.New(t).Interface().(Marshaler))
+	return wire, func(p unsafe.Pointer, b *Buffer) {
+		reflect.NewAt(t, p).Interface().(Marshaler).MarshalGlint(b)
+	}, true
+}
+
+// unmarshalerInstruction returns the wire type and decoding function for a field of type t, when t implements
+// Unmarshaler through a pointer to it. Each value is handed over in a Reader of its own, so a type that reads too
+// little or too much can't throw the rest of the document out of step.
+func unmarshalerInstruction(t reflect.Type) (WireType, func(unsafe.Pointer, Reader) Reader, bool) {
+	if !reflect.PointerTo(t).Implements(unmarshalerType) {
+		return 0, nil, false
+	}
+
+	wire := customWire(t, reflect.New(t).Interface().(Unmarshaler))
+	return wire, func(p unsafe.Pointer, r Reader) Reader {
+		value := r.Remaining()
+		fieldBytes(&r, wire)
+		value = value[:len(value)-int(r.BytesLeft())]
+
+		vr := NewReader(value)
+		if err := reflect.NewAt(t, p).Interface().(Unmarshaler).UnmarshalGlint(&vr); err != nil {
+			panic(fmt.Sprintf("UnmarshalGlint of %v: %v", t, err))
+		}
+		return r
+	}, true
+}
@@ -0,0 +1,227 @@
+package glint
+
+import (
+	"fmt"
+)
+
+// MergeOption configures Merge
+type MergeOption func(*mergeConfig)
+
+// mergeConfig holds the options Merge was called with
+type mergeConfig struct {
+	patch bool // nil fields in src remove the field, see WithMergePatch
+}
+
+// WithMergePatch merges src as a patch, in the way RFC 7386 merges JSON documents. A field of src holding nil, that
+// is a nil pointer, or a nil slice or map written with a presence byte, removes the field from the result rather
+// than setting it to nil.
+func WithMergePatch() MergeOption {
+	return func(c *mergeConfig) {
+		c.patch = true
+	}
+}
+
+// Merge combines two documents field by field, without decoding either. The result has every field of dst, in the
+// same order, followed by the fields only src has. Fields both documents have take their value from src, other
+// than nested structs present in both, which are merged in the same way, so src only needs the fields it changes:
+//
+//	doc, err := glint.Merge(stored, update)
+//
+// A field of src with a different type from the same field of dst replaces it, type and all. Slices and maps are
+// replaced as a whole rather than merged.
+//
+// The result is written as format version 0, with any shared struct schemas expanded and field constraints left
+// out, and compressed with the codec dst was written with. String tables of both documents are combined. Documents
+// written without their schema return ErrSchemaNotFound.
+func Merge(dst, src []byte, opts ...MergeOption) (out []byte, err error) {
+	var c mergeConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	defer recoverErr(&err)
+
+	d, err := Document(dst).parts(DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Document(src).parts(DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	if d.schema.BytesLeft() == 0 || s.schema.BytesLeft() == 0 {
+		return nil, ErrSchemaNotFound
+	}
+
+	// src's strings follow dst's in the combined table, so its references to them move along by as many
+	var table Buffer
+	if d.body.strings != nil {
+		table.strings.values = append(table.strings.values, *d.body.strings...)
+	}
+	srcFields, srcBody := parseSchemaNodes(s.schema), s.body
+	if s.body.strings != nil {
+		if offset := uint(len(table.strings.values)); offset > 0 {
+			var shifted Buffer
+			for i := range srcFields {
+				shiftDictRefs(srcFields[i].wire, &srcFields[i], &srcBody, &shifted, offset)
+			}
+			srcBody = NewReader(shifted.Bytes)
+		}
+		table.strings.values = append(table.strings.values, *s.body.strings...)
+	}
+
+	merged := c.merge(splitFields(parseSchemaNodes(d.schema), &d.body), splitFields(srcFields, &srcBody))
+	if d.body.BytesLeft() > 0 || srcBody.BytesLeft() > 0 {
+		return nil, fmt.Errorf("%w: body bytes remaining > 0", ErrInvalidDocument)
+	}
+
+	fields := make([]schemaNode, len(merged))
+	var body []byte
+	for i := range merged {
+		fields[i] = merged[i].node
+		body = append(body, merged[i].value...)
+	}
+
+	var flags byte
+	if table.appendStringTable() {
+		flags = flagStringTable
+	}
+	b := Buffer{Bytes: writeDocument(fields, flags, body, table.Bytes)}
+	if codec := Codec(d.flags >> 4); codec != CodecNone {
+		compressed, err := compressBodyWith(codec, &b, len(b.Bytes)-len(body)-len(table.Bytes))
+		if err != nil {
+			return nil, err
+		}
+		if compressed {
+			b.Bytes[0] |= byte(codec) << 4
+		}
+	}
+	return b.Bytes, nil
+}
+
+// mergedField is a field of a document being merged, along with its value as written in the body
+type mergedField struct {
+	node  schemaNode
+	value []byte // from any presence byte to the end of the value
+}
+
+// splitFields reads the value of each of fields from r
+func splitFields(fields []schemaNode, r *Reader) []mergedField {
+	split := make([]mergedField, len(fields))
+	for i := range fields {
+		value := r.Remaining()
+		skipValue(fields[i].wire, &fields[i], r)
+		split[i] = mergedField{node: fields[i], value: value[:len(value)-int(r.BytesLeft())]}
+	}
+	return split
+}
+
+// merge combines the fields of dst and src, see Merge
+func (c mergeConfig) merge(dst, src []mergedField) []mergedField {
+	merged := make([]mergedField, 0, len(dst)+len(src))
+	used := make([]bool, len(src))
+
+next:
+	for _, d := range dst {
+		for i, s := range src {
+			if s.node.name != d.node.name {
+				continue
+			}
+			used[i] = true
+			if f, ok := c.mergeField(d, s); ok {
+				merged = append(merged, f)
+			}
+			continue next
+		}
+		merged = append(merged, d)
+	}
+
+	for i, s := range src {
+		if !used[i] && !(c.patch && s.isNil()) {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+// mergeField combines a field both documents have, reporting false when the field is removed
+func (c mergeConfig) mergeField(d, s mergedField) (mergedField, bool) {
+	if c.patch && s.isNil() {
+		return mergedField{}, false
+	}
+	if d.node.wire&^WirePtrFlag != WireStruct || s.node.wire&^WirePtrFlag != WireStruct || d.isNil() || s.isNil() {
+		return s, true
+	}
+
+	dv, sv := d.value, s.value
+	if d.node.wire&WirePtrFlag > 0 {
+		dv = dv[1:]
+	}
+	var value []byte
+	if s.node.wire&WirePtrFlag > 0 {
+		sv, value = sv[1:], []byte{1}
+	}
+
+	dr, sr := NewReader(dv), NewReader(sv)
+	fields := c.merge(splitFields(d.node.fields, &dr), splitFields(s.node.fields, &sr))
+
+	node := schemaNode{name: s.node.name, wire: s.node.wire, fields: make([]schemaNode, len(fields))}
+	for i := range fields {
+		node.fields[i] = fields[i].node
+		value = append(value, fields[i].value...)
+	}
+	return mergedField{node: node, value: value}, true
+}
+
+// isNil reports whether the field holds a nil pointer, slice or map
+func (f mergedField) isNil() bool {
+	return f.node.wire&WirePtrFlag > 0 && f.value[0] == 0
+}
+
+// shiftDictRefs copies a value of the supplied wire type, described by t, from r to b, adding offset to every
+// reference it holds to the string table
+func shiftDictRefs(wire WireType, t *schemaNode, r *Reader, b *Buffer, offset uint) {
+	switch {
+	case wire&WirePtrFlag > 0:
+		present := r.ReadByte()
+		b.AppendUint8(present)
+		if present != 0 {
+			shiftDictRefs(wire&^WirePtrFlag, t, r, b, offset)
+		}
+
+	case wire&WireDictFlag > 0 && wire&WireSliceFlag > 0:
+		l := r.ReadVarint()
+		b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			b.AppendUint(r.ReadVarint() + offset)
+		}
+
+	case wire&WireDictFlag > 0:
+		b.AppendUint(r.ReadVarint() + offset)
+
+	case wire&WireSliceFlag > 0 && wire&(WireSparseFlag|WireBitmapFlag|WireDeltaFlag) == 0:
+		l := r.ReadVarint()
+		b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			shiftDictRefs(t.elem.wire, t.elem, r, b, offset)
+		}
+
+	case wire == WireStruct:
+		for i := range t.fields {
+			shiftDictRefs(t.fields[i].wire, &t.fields[i], r, b, offset)
+		}
+
+	case wire == WireMap:
+		l := r.ReadVarint()
+		b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			shiftDictRefs(t.key.wire, t.key, r, b, offset)
+			shiftDictRefs(t.value.wire, t.value, r, b, offset)
+		}
+
+	default: // values that can't hold references are copied as they are
+		value := r.Remaining()
+		skipValue(wire, t, r)
+		b.Bytes = append(b.Bytes, value[:len(value)-int(r.BytesLeft())]...)
+	}
+}
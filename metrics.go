@@ -0,0 +1,53 @@
+package glint
+
+import "time"
+
+// MetricsSink receives measurements from encoders built WithMetrics and decoders built WithDecoderMetrics, so that
+// the cost of each message type can be exported, to Prometheus for example, without wrapping every call site.
+// Types are named as reflect prints them, such as "orders.Order".
+//
+// A sink is called on every goroutine that encodes or decodes, so it must be safe for concurrent use, and should
+// be cheap, it's called once or twice for every document.
+type MetricsSink interface {
+	Encoded(typeName string, bytes int, took time.Duration)            // a document was encoded
+	Decoded(typeName string, bytes int, took time.Duration, err error) // a document was decoded, or failed to be
+	SchemaCache(typeName string, hit bool)                             // a decoder looked for the instructions of a document's schema
+	PooledBuffer(typeName string, reused bool)                         // a buffer was taken from the pool, reused rather than made
+	DuplicateMapKey(typeName string)                                   // a decoder read a map key already decoded into the same map
+}
+
+// WithMetrics reports the size and encoding time of every document encoded, along with how often the buffers it's
+// written through are reused from the pool, to m
+func WithMetrics(m MetricsSink) EncoderOption {
+	return func(c *encoderConfig) {
+		c.metrics = m
+	}
+}
+
+// WithDecoderMetrics reports the size and decoding time of every document decoded, whether its schema's
+// instructions were cached, and every map key decoded more than once, to m
+func WithDecoderMetrics(m MetricsSink) DecoderOption {
+	return func(d *decoderImpl) {
+		d.metrics = m
+	}
+}
+
+// encoded reports a document of the bytes written to b since from, encoded since start
+func (e *encoderImpl) encoded(b *Buffer, from int, start time.Time) {
+	e.config.metrics.Encoded(e.name, len(b.Bytes)-from, time.Since(start))
+}
+
+// pooledBuffer takes a buffer from the pool, reporting whether it was reused when there are metrics to report to
+func (e *encoderImpl) pooledBuffer() *Buffer {
+	b, reused := getBuffer(0)
+	b.Reset()
+	if e.config.metrics != nil {
+		e.config.metrics.PooledBuffer(e.name, reused)
+	}
+	return b
+}
+
+// decoded reports a document of n bytes, decoded since start with the error at err
+func (d *decoderImpl) decoded(n int, start time.Time, err *error) {
+	d.metrics.Decoded(d.name, n, time.Since(start), *err)
+}
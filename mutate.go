@@ -0,0 +1,286 @@
+package glint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// MutationKind is the class of change a Mutation makes to a document
+type MutationKind uint8
+
+const (
+	MutationWireType  MutationKind = iota // a field's wire type is changed in the schema, its body left as it is
+	MutationLength                        // a string, bytes, slice or map length is one away from its contents
+	MutationTruncate                      // a nested struct's body loses its last bytes, with everything after it kept
+	MutationDuplicate                     // a field appears twice, in both the schema and the body
+)
+
+// String implements fmt.Stringer
+func (k MutationKind) String() string {
+	switch k {
+	case MutationWireType:
+		return "wire type"
+	case MutationLength:
+		return "length"
+	case MutationTruncate:
+		return "truncate"
+	case MutationDuplicate:
+		return "duplicate"
+	}
+	return fmt.Sprintf("MutationKind(%d)", uint8(k))
+}
+
+// Mutation is a document with a single targeted change made to it, see MutateDocument
+type Mutation struct {
+	Kind  MutationKind
+	Field string // the mutated field, with the fields of nested structs following their parent's name after a dot
+	Doc   []byte
+}
+
+// MutateDocument produces mutations of a valid document for testing how decoders cope with malformed input.
+// Rather than flipping random bytes, which mostly produce documents rejected by their header, each mutation
+// makes one change a buggy encoder or a damaged document could plausibly contain, and keeps the rest of the
+// document well formed, schema checksum included.
+//
+// Every field, and the fields of nested structs, is mutated in each way that applies to it. Slice elements and
+// map entries aren't mutated individually, and neither are the lengths of sparse slices and bitmaps, whose lengths
+// are free to differ from the values they hold. Lengths and truncations that leave a body which still reads as valid, as
+// varints can realign after a shifted byte, are indistinguishable from a document written with other values and
+// are left out. Every mutation returned leaves the document undecodable into the type it was written from, so
+// decoders should return an error for each one - never panic, and never decode it without complaint.
+//
+// Mutations are deterministic for a given document and seed, which picks the replacement wire types, lengths
+// and truncations. Mutated documents are written as format version 0, uncompressed, with any shared struct
+// schemas expanded.
+func MutateDocument(doc []byte, seed int64) (mutations []Mutation, err error) {
+
+	defer recoverErr(&err)
+
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return nil, ErrSchemaNotFound
+	}
+
+	m := mutator{fields: parseSchemaNodes(p.schema), body: p.body.Remaining(), g: generator{state: uint64(seed)}}
+	if p.body.strings != nil {
+		var table Buffer
+		table.strings.values = append(table.strings.values, *p.body.strings...)
+		if table.appendStringTable() {
+			m.flags, m.table = flagStringTable, table.Bytes
+		}
+	}
+
+	body := NewReader(m.body)
+	m.index(&m.fields, "", &body)
+	if body.BytesLeft() > 0 {
+		return nil, fmt.Errorf("%w: body bytes remaining > 0: %v", ErrInvalidDocument, body.BytesLeft())
+	}
+
+	for i := range m.sites {
+		mutations = m.mutate(mutations, &m.sites[i])
+	}
+	return mutations, nil
+}
+
+// mutator holds a document taken apart for mutating, along with a site for each field it can mutate
+type mutator struct {
+	fields []schemaNode
+	body   []byte
+	flags  byte
+	table  []byte // the string table, as written after the body
+	sites  []mutationSite
+	g      generator
+}
+
+// mutationSite locates a field in both the schema and the body
+type mutationSite struct {
+	path   string
+	parent *[]schemaNode // the fields the field belongs to, at index
+	index  int
+	start  int // the field's value in the body, from any presence byte to end
+	value  int // the value past any presence byte, -1 when the field is nil
+	end    int
+}
+
+// index records a site for each of fields, nested struct fields included, reading past their values in r
+func (m *mutator) index(fields *[]schemaNode, path string, r *Reader) {
+	for i := range *fields {
+		t := &(*fields)[i]
+
+		n := len(m.sites)
+		m.sites = append(m.sites, mutationSite{path: path + t.name, parent: fields, index: i, value: -1})
+		m.sites[n].start = int(r.position)
+
+		if t.wire&WirePtrFlag == 0 || r.ReadByte() != 0 {
+			m.sites[n].value = int(r.position)
+			if t.wire&^WirePtrFlag == WireStruct {
+				m.index(&t.fields, m.sites[n].path+".", r)
+			} else {
+				skipValue(t.wire&^WirePtrFlag, t, r)
+			}
+		}
+		m.sites[n].end = int(r.position)
+	}
+}
+
+// skipValue reads past a value of the supplied wire type, described by t, in the layout the generator writes
+func skipValue(wire WireType, t *schemaNode, r *Reader) {
+	switch {
+	case wire&WirePtrFlag > 0:
+		if r.ReadByte() != 0 {
+			skipValue(wire&^WirePtrFlag, t, r)
+		}
+
+	case wire&WireSparseFlag > 0:
+		*r = skipSparseSlice(nil, *r)
+
+	case wire&WireBitmapFlag > 0:
+		*r = skipBitmapSlice(nil, *r)
+
+	case wire&WireDictFlag > 0 && wire&WireSliceFlag > 0:
+		*r = skipDictSlice(nil, *r)
+
+	case wire&WireDeltaFlag > 0:
+		l := r.ReadVarint()
+		if l == 0 {
+			return
+		}
+		fieldBytes(r, t.elem.wire)
+		for i := uint(1); i < l; i++ {
+			if e := t.elem.wire; e == WireFloat32 || e == WireFloat64 {
+				r.readFloatXor()
+				continue
+			}
+			r.SkipVarint()
+		}
+
+	case wire&WireSliceFlag > 0:
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			skipValue(t.elem.wire, t.elem, r)
+		}
+
+	case wire == WireStruct:
+		for i := range t.fields {
+			skipValue(t.fields[i].wire, &t.fields[i], r)
+		}
+
+	case wire == WireMap:
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			skipValue(t.key.wire, t.key, r)
+			skipValue(t.value.wire, t.value, r)
+		}
+
+	default:
+		fieldBytes(r, wire)
+	}
+}
+
+// mutationWires are the wire types fields are changed to, each of which needs nothing more in the schema
+var mutationWires = []WireType{
+	WireBool, WireInt, WireInt8, WireInt16, WireInt32, WireInt64, WireUint, WireUint8, WireUint16, WireUint32,
+	WireUint64, WireFloat32, WireFloat64, WireString, WireBytes, WireTime, WireDuration, WireIP, WireIPPrefix,
+	WireBigInt, WireDecimal,
+}
+
+// mutate appends each mutation that applies to the field at s
+func (m *mutator) mutate(mutations []Mutation, s *mutationSite) []Mutation {
+	t := &(*s.parent)[s.index]
+	wire := t.wire &^ WirePtrFlag
+
+	// a wire type the field's own can't be read as, with the sub-schema of any composite dropped along with it
+	replacement := mutationWires[m.g.intn(len(mutationWires))]
+	for plain := wire &^ (WireDictFlag | WireZigzagFlag); replacement == plain || durationCompatible(replacement, plain); {
+		replacement = mutationWires[m.g.intn(len(mutationWires))]
+	}
+	saved := *t
+	*t = schemaNode{name: t.name, wire: replacement}
+	mutations = append(mutations, Mutation{Kind: MutationWireType, Field: s.path, Doc: m.document(m.body)})
+	*t = saved
+
+	lengthed := wire == WireString || wire == WireBytes || wire == WireMap ||
+		(wire&WireSliceFlag > 0 && wire&(WireSparseFlag|WireBitmapFlag) == 0)
+	if lengthed && s.value >= 0 {
+		r := NewReader(m.body[s.value:])
+		l := r.ReadVarint()
+		if l == 0 || m.g.intn(2) == 0 {
+			l++
+		} else {
+			l--
+		}
+
+		var b Buffer
+		b.Bytes = append(b.Bytes, m.body[:s.value]...)
+		b.AppendUint(l)
+		b.Bytes = append(b.Bytes, r.Remaining()...)
+		if !m.readable(b.Bytes) {
+			mutations = append(mutations, Mutation{Kind: MutationLength, Field: s.path, Doc: m.document(b.Bytes)})
+		}
+	}
+
+	if wire == WireStruct && s.end > s.value && s.value >= 0 {
+		n := s.end - s.value
+		if n > 4 {
+			n = 4
+		}
+		cut := s.end - 1 - m.g.intn(n)
+
+		body := append(append([]byte{}, m.body[:cut]...), m.body[s.end:]...)
+		if !m.readable(body) {
+			mutations = append(mutations, Mutation{Kind: MutationTruncate, Field: s.path, Doc: m.document(body)})
+		}
+	}
+
+	fields := *s.parent
+	*s.parent = append(append(append([]schemaNode{}, fields[:s.index+1]...), fields[s.index]), fields[s.index+1:]...)
+	body := append(append([]byte{}, m.body[:s.end]...), m.body[s.start:]...)
+	mutations = append(mutations, Mutation{Kind: MutationDuplicate, Field: s.path, Doc: m.document(body)})
+	*s.parent = fields
+
+	return mutations
+}
+
+// readable reports whether body reads through to its end against the mutator's schema. Varints realign after a
+// shifted byte, so a mutated body can turn out to be a valid one holding different values.
+func (m *mutator) readable(body []byte) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	r := NewReader(body)
+	for i := range m.fields {
+		skipValue(m.fields[i].wire, &m.fields[i], &r)
+	}
+	return r.BytesLeft() == 0
+}
+
+// document writes a version 0 document holding the mutator's schema, as it is now, along with body
+func (m *mutator) document(body []byte) []byte {
+	return writeDocument(m.fields, m.flags, body, m.table)
+}
+
+// writeDocument writes a version 0 document holding fields as its schema, followed by body and any string table.
+// Schemas with a slice of pointers are written as version 4, which marks their elements.
+func writeDocument(fields []schemaNode, flags byte, body, table []byte) []byte {
+	var schema Buffer
+	appendSchemaNodes(&schema, fields)
+
+	b := Buffer{Bytes: make([]byte, 5, 6+binary.MaxVarintLen64+len(schema.Bytes)+len(body)+len(table))}
+	b.Bytes[0] = flags
+	b.AppendUint(uint(len(schema.Bytes)))
+	b.Bytes = append(b.Bytes, schema.Bytes...)
+	if markedPointers(schema.Bytes) {
+		b.Bytes[0] |= formatVersionPointers
+		b.AppendBytes(nil) // an empty list of name hashes, which the layout of version 4 has after the schema
+	}
+	binary.LittleEndian.PutUint32(b.Bytes[1:5], crc32.ChecksumIEEE(b.Bytes[5:]))
+
+	b.Bytes = append(b.Bytes, body...)
+	b.Bytes = append(b.Bytes, table...)
+	return b.Bytes
+}
@@ -0,0 +1,51 @@
+package glint
+
+import "encoding/binary"
+
+// Decoders match the fields in a document's schema to their own by name, each time they meet a schema they don't
+// have cached instructions for. Services that see many distinct schemas, each with many long field names, spend a
+// good part of their decoding doing this. Encoders created WithNameHashes help by writing a hash of every top
+// level field name after the schema, in the same order as the fields
+//
+//	[names length (varint)][FNV-1a hash of field name (8 bytes, little endian)]...
+//
+// Decoders look long names up by their hash, and compare the names themselves to confirm a match. A hash that
+// matches none of a decoder's fields, or matches a field with a different name, falls back to looking the name up
+// as usual, so hashes only ever speed up matching and never change its result. Names short enough for the trie
+// are always looked up directly, it's faster than hashing.
+//
+// Documents carrying name hashes are written as format version 3, or 4 when they hold a slice of pointers to structs.
+
+// WithNameHashes writes the hash of each top level field name alongside the schema, which speeds up decoding
+// documents with wide schemas and long field names when their instructions aren't cached, at the cost of 8 bytes
+// per field in every document that carries its schema. Documents written this way need a decoder from a release
+// that understands format version 3. It has no effect on encoders using WithSchemaRegistry, whose documents don't
+// carry their schema.
+func WithNameHashes() EncoderOption {
+	return func(c *encoderConfig) {
+		c.nameHashes = true
+	}
+}
+
+// nameHash returns the 64-bit FNV-1a hash of a field name
+func nameHash(name string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// appendNameHashes writes the hash of each top level field name after the schema, moving the document to the
+// format version that carries them
+func (e *encoderImpl) appendNameHashes() {
+	names := make([]byte, 0, 8*len(e.instructions))
+	for i := range e.instructions {
+		names = binary.LittleEndian.AppendUint64(names, nameHash(e.instructions[i].tag))
+	}
+
+	e.schema.Bytes[0] = e.schema.Bytes[0]&^flagVersionMask | formatVersionNameHashes
+	e.schema.AppendBytes(names)
+	e.seal()
+}
@@ -0,0 +1,66 @@
+package glint
+
+import "strings"
+
+// nameMatching selects how the field names of a document's schema are matched to tags that aren't spelt the same
+type nameMatching uint8
+
+const (
+	matchExact nameMatching = iota // names must be the same, the default
+	matchCase                      // names may differ in case, see WithCaseInsensitiveNames
+	matchStyle                     // names may differ in case and separators, see WithStyleInsensitiveNames
+)
+
+// WithCaseInsensitiveNames matches fields to the names in a document's schema regardless of case, so a field
+// tagged firstname decodes firstName and FIRSTNAME alike. Names that are the same still match first, and tags that
+// differ only in case are left to exact matches, as it can't be told which of them a name is for.
+func WithCaseInsensitiveNames() DecoderOption {
+	return func(d *decoderImpl) {
+		d.eachDecoder(func(d *decoderImpl) {
+			d.matchNames(matchCase)
+		})
+	}
+}
+
+// WithStyleInsensitiveNames is like WithCaseInsensitiveNames, but also ignores underscores and hyphens, so that
+// first_name, firstName, FirstName and first-name all match a field tagged with any of them. It suits documents from
+// producers in other languages that follow their own naming conventions.
+func WithStyleInsensitiveNames() DecoderOption {
+	return func(d *decoderImpl) {
+		d.eachDecoder(func(d *decoderImpl) {
+			d.matchNames(matchStyle)
+		})
+	}
+}
+
+// matchNames sets how d matches names that aren't the same as its tags
+func (d *decoderImpl) matchNames(m nameMatching) {
+	d.folded = map[string]decodeInstruction{}
+	ambiguous := map[string]bool{}
+
+	d.eachField(func(name string, di decodeInstruction) {
+		key := foldName(name, m)
+		if other, ok := d.folded[key]; ok && other.tag != di.tag {
+			ambiguous[key] = true
+		}
+		d.folded[key] = di
+	})
+	for key := range ambiguous {
+		delete(d.folded, key)
+	}
+	d.matching = m
+}
+
+// foldedField looks up the field a schema name matches once it's folded as d matches names, see matchNames
+func (d *decoderImpl) foldedField(name []byte) (decodeInstruction, bool) {
+	di, ok := d.folded[foldName(string(name), d.matching)]
+	return di, ok
+}
+
+// foldName returns name as it's compared when names are matched by m
+func foldName(name string, m nameMatching) string {
+	if m == matchStyle {
+		name = strings.NewReplacer("_", "", "-", "").Replace(name)
+	}
+	return strings.ToLower(name)
+}
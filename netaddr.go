@@ -0,0 +1,116 @@
+package glint
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"unsafe"
+)
+
+// Network addresses have wire types of their own, so they're printed and generated as addresses rather than bytes
+//
+//	WireIP        net.IP, netip.Addr       [length (varint)][4 or 16 address bytes]
+//	WireIPPrefix  net.IPNet, netip.Prefix  [length (varint)][4 or 16 address bytes][prefix bits (byte)]
+//
+// Both have the same layout as WireBytes. Nil and invalid values are written with a length of 0. IPv4 addresses
+// are written as 4 bytes, including net.IP values holding them in their 16 byte form. Zones are dropped, and
+// net.IPNet masks that aren't a prefix length, which CIDR notation can't express either, are written as /0.
+//
+// As with Marshaler, these apply to struct fields and pointers to them, not to elements of slices or maps.
+
+var (
+	ipType     = reflect.TypeOf(net.IP{})
+	ipNetType  = reflect.TypeOf(net.IPNet{})
+	addrType   = reflect.TypeOf(netip.Addr{})
+	prefixType = reflect.TypeOf(netip.Prefix{})
+)
+
+// netAddrEncoder returns the wire type and encoding function for a field of type t, when t is a network address
+func netAddrEncoder(t reflect.Type) (WireType, func(unsafe.Pointer, *Buffer), bool) {
+	switch t {
+	case ipType:
+		return WireIP, func(p unsafe.Pointer, b *Buffer) {
+			b.AppendAddr(ipToAddr(*(*net.IP)(p)))
+		}, true
+	case addrType:
+		return WireIP, func(p unsafe.Pointer, b *Buffer) {
+			b.AppendAddr(*(*netip.Addr)(p))
+		}, true
+	case ipNetType:
+		return WireIPPrefix, func(p unsafe.Pointer, b *Buffer) {
+			b.AppendPrefix(ipNetToPrefix(*(*net.IPNet)(p)))
+		}, true
+	case prefixType:
+		return WireIPPrefix, func(p unsafe.Pointer, b *Buffer) {
+			b.AppendPrefix(*(*netip.Prefix)(p))
+		}, true
+	}
+	return 0, nil, false
+}
+
+// netAddrDecoder returns the wire type and decoding function for a field of type t, when t is a network address
+func netAddrDecoder(t reflect.Type) (WireType, func(unsafe.Pointer, Reader) Reader, bool) {
+	switch t {
+	case ipType:
+		return WireIP, func(p unsafe.Pointer, r Reader) Reader {
+			*(*net.IP)(p) = addrToIP(r.ReadAddr())
+			return r
+		}, true
+	case addrType:
+		return WireIP, func(p unsafe.Pointer, r Reader) Reader {
+			*(*netip.Addr)(p) = r.ReadAddr()
+			return r
+		}, true
+	case ipNetType:
+		return WireIPPrefix, func(p unsafe.Pointer, r Reader) Reader {
+			*(*net.IPNet)(p) = prefixToIPNet(r.ReadPrefix())
+			return r
+		}, true
+	case prefixType:
+		return WireIPPrefix, func(p unsafe.Pointer, r Reader) Reader {
+			*(*netip.Prefix)(p) = r.ReadPrefix()
+			return r
+		}, true
+	}
+	return 0, nil, false
+}
+
+// ipToAddr converts a net.IP, keeping IPv4 addresses as IPv4 whichever form they're held in
+func ipToAddr(ip net.IP) netip.Addr {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
+
+// addrToIP converts a netip.Addr, returning nil for the zero Addr
+func addrToIP(addr netip.Addr) net.IP {
+	if !addr.IsValid() {
+		return nil
+	}
+	return net.IP(addr.AsSlice())
+}
+
+// ipNetToPrefix converts a net.IPNet, using the address family of its mask
+func ipNetToPrefix(n net.IPNet) netip.Prefix {
+	ones, bits := n.Mask.Size()
+	ip := n.IP
+	if v4 := ip.To4(); v4 != nil && bits != 8*net.IPv6len {
+		ip = v4
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}
+	}
+	return netip.PrefixFrom(addr, ones)
+}
+
+// prefixToIPNet converts a netip.Prefix, returning the zero IPNet for the zero Prefix
+func prefixToIPNet(p netip.Prefix) net.IPNet {
+	if !p.IsValid() {
+		return net.IPNet{}
+	}
+	return net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())}
+}
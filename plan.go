@@ -0,0 +1,482 @@
+package glint
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// Encoders and decoders are compiled from their types by reflection when they're built. Plans hold the result of
+// that compilation, the name, wire type and offset of every field, so it can be made ahead of time, stored, and
+// loaded where walking types at startup isn't possible or wanted, such as plugins and sandboxed runtimes
+//
+//	plan, err := glint.NewEncodePlan[User]()
+//	b, err := plan.MarshalBinary()
+//
+//	var plan glint.EncodePlan
+//	err := plan.UnmarshalBinary(b)
+//	encoder, err := glint.NewEncoderFromPlan[User](&plan)
+//
+// Plans cover structs of numbers, bools, strings, byte slices, times and durations, along with structs nested
+// within them. Types with other fields, or fields with custom encodings, can't be planned.
+//
+// A plan is only valid for the type it was made from, in a build with the same memory layout. Loading one checks
+// the type's name and size, and that every field lies within the type and has the size its wire type calls for,
+// but can't see a type whose fields were reordered without changing their sizes. Plans are best made by the same
+// build that loads them, e.g. with go generate.
+//
+// Plans are written as
+//
+//	[version (byte)][type (string)][size (varint)][schema (bytes)][field count (varint)][fields]
+//
+// where each field is [name (string)][wire (varint)][offset (varint)][size (varint)][fields (varint)][required (bool)],
+// and the fields of a nested struct follow it directly. Decode plans have an empty schema.
+
+// planVersion is the version of the layout plans are written in
+const planVersion = 1
+
+// ErrInvalidPlan is returned when a plan is malformed, or doesn't match the type it's loaded for
+var ErrInvalidPlan = errors.New("invalid glint plan")
+
+// PlanField is a single field of a plan. The fields of a nested struct follow it directly.
+type PlanField struct {
+	Name     string   // name written in the schema
+	Wire     WireType // wire type of the field
+	Offset   uintptr  // offset of the field within its struct
+	Size     uintptr  // size of the field's type
+	Fields   int      // for nested structs, the number of fields of the struct, which follow this one
+	Required bool     // decode plans only, the field has the required option
+}
+
+// EncodePlan is the compiled form of an Encoder, see NewEncodePlan
+type EncodePlan struct {
+	Type   string      // type the plan was made from, as formatted by %T
+	Size   uintptr     // size of the type
+	Schema []byte      // flags, hash and schema written ahead of every document's body
+	Fields []PlanField // fields, in the order they're written
+}
+
+// DecodePlan is the compiled form of a Decoder, see NewDecodePlan
+type DecodePlan struct {
+	Type   string      // type the plan was made from, as formatted by %T
+	Size   uintptr     // size of the type
+	Fields []PlanField // fields the decoder looks for
+}
+
+// NewEncodePlan compiles the plan an encoder for T built with the supplied options would follow. Encoders using a
+// schema registry can't be planned.
+func NewEncodePlan[T any](opts ...EncoderOption) (*EncodePlan, error) {
+	var zero T
+	t := reflect.TypeOf(zero)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("glint: can't plan %v, only structs can be planned", t)
+	}
+
+	config := newEncoderConfig(opts)
+	if config.registry != nil {
+		return nil, errors.New("glint: encoders using a schema registry can't be planned")
+	}
+
+	e := newRootEncoder(zero, "glint", config)
+	fields, err := e.planFields(t, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncodePlan{Type: t.String(), Size: t.Size(), Schema: append([]byte{}, e.schema.Bytes...), Fields: fields}, nil
+}
+
+// planFields appends a plan field for each of the encoder's instructions, where t is the struct it encodes
+func (e *encoderImpl) planFields(t reflect.Type, fields []PlanField) ([]PlanField, error) {
+	byName := map[string]taggedField{}
+	for _, f := range taggedFields(t, "glint") {
+		byName[f.name] = f
+	}
+
+	for _, in := range e.instructions {
+		f := byName[in.tag]
+		pf := PlanField{Name: in.tag, Wire: in.wire, Offset: in.offset, Size: f.Type.Size()}
+
+		switch {
+		case in.wire == WireStruct:
+			pf.Fields = len(in.subenc.instructions)
+			fields = append(fields, pf)
+
+			var err error
+			if fields, err = in.subenc.planFields(f.Type, fields); err != nil {
+				return nil, err
+			}
+			continue
+
+		case in.wire == WirePtrFlag|WireBytes && f.Type.Kind() == reflect.Slice: // with a presence byte for nil
+		case in.wire == WireBytes && f.Type.Kind() == reflect.Slice:
+		case in.wire != WireBytes && planWireSize(in.wire) > 0:
+		default:
+			return nil, fmt.Errorf("glint: can't plan field %q of type %v", in.tag, f.Type)
+		}
+
+		fields = append(fields, pf)
+	}
+
+	return fields, nil
+}
+
+// NewEncoderFromPlan builds an encoder for T from a plan, without walking T. Plans that don't match T return an
+// error wrapping ErrInvalidPlan.
+func NewEncoderFromPlan[T any](plan *EncodePlan) (*Encoder[T], error) {
+	var zero T
+	if err := checkPlanType(plan.Type, plan.Size, reflect.TypeOf(zero)); err != nil {
+		return nil, err
+	}
+
+	e, rest, err := planEncoder(plan.Fields, -1, plan.Size)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: %d fields left over", ErrInvalidPlan, len(rest))
+	}
+
+	if err := checkPlanSchema(plan.Schema, plan.Fields); err != nil {
+		return nil, err
+	}
+
+	e.schema.Bytes = append([]byte{}, plan.Schema...)
+	e.header.Bytes = append(e.schema.Bytes[:5:5], 0)
+	return &Encoder[T]{impl: e}, nil
+}
+
+// planEncoder builds an encoder for a struct of size bytes, from the first count fields of a plan along with any
+// nested fields, returning the fields that follow them. A count of -1 takes every field.
+func planEncoder(fields []PlanField, count int, size uintptr) (*encoderImpl, []PlanField, error) {
+	e := &encoderImpl{}
+
+	for i := 0; i != count && (count >= 0 || len(fields) > 0); i++ {
+		if len(fields) == 0 {
+			return nil, nil, fmt.Errorf("%w: missing fields", ErrInvalidPlan)
+		}
+		f := fields[0]
+		fields = fields[1:]
+
+		if err := checkPlanField(f, size); err != nil {
+			return nil, nil, err
+		}
+
+		in := encodeInstruction{wire: f.Wire, offset: f.Offset, tag: f.Name}
+
+		switch {
+		case f.Wire == WireStruct:
+			var err error
+			if in.subenc, fields, err = planEncoder(fields, f.Fields, f.Size); err != nil {
+				return nil, nil, err
+			}
+
+		case f.Wire == WirePtrFlag|WireBytes:
+			in.fun = nilSliceAppend(func(p unsafe.Pointer, b *Buffer) {
+				b.AppendBytes(*(*[]byte)(p))
+			})
+		}
+
+		e.instructions = append(e.instructions, in)
+	}
+
+	return e, fields, nil
+}
+
+// NewDecodePlan compiles the plan a decoder for T would follow. Fields with a default= option can't be planned.
+func NewDecodePlan[T any]() (*DecodePlan, error) {
+	var zero T
+	t := reflect.TypeOf(zero)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("glint: can't plan %v, only structs can be planned", t)
+	}
+
+	fields, err := decodePlanFields(t, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecodePlan{Type: t.String(), Size: t.Size(), Fields: fields}, nil
+}
+
+// decodePlanFields appends a plan field for each tagged field of the struct t
+func decodePlanFields(t reflect.Type, fields []PlanField) ([]PlanField, error) {
+	for _, f := range taggedFields(t, "glint") {
+		if _, ok := f.opts.Value("default"); ok {
+			return nil, fmt.Errorf("glint: can't plan field %q, it has a default", f.name)
+		}
+
+		wire := reflectKindToAssigner(f.Type, "glint", f.opts, DefaultLimits).wire
+		pf := PlanField{Name: f.name, Wire: wire, Offset: f.Offset, Size: f.Type.Size(), Required: f.opts.Contains("required")}
+
+		switch {
+		case wire == WireStruct && f.Type.Kind() == reflect.Struct:
+			tagged := taggedFields(f.Type, "glint")
+			pf.Fields = len(tagged)
+			fields = append(fields, pf)
+
+			var err error
+			if fields, err = decodePlanFields(f.Type, fields); err != nil {
+				return nil, err
+			}
+			continue
+
+		case wire == WireBytes && f.Type.Kind() == reflect.Slice:
+		case wire != WireBytes && planWireSize(wire) > 0:
+		default:
+			return nil, fmt.Errorf("glint: can't plan field %q of type %v", f.name, f.Type)
+		}
+
+		fields = append(fields, pf)
+	}
+
+	return fields, nil
+}
+
+// NewDecoderFromPlan builds a decoder for T from a plan, with DefaultLimits, without walking T. Plans that don't
+// match T return an error wrapping ErrInvalidPlan.
+func NewDecoderFromPlan[T any](plan *DecodePlan) (*Decoder[T], error) {
+	return NewDecoderFromPlanWithLimits[T](plan, DefaultLimits)
+}
+
+// NewDecoderFromPlanWithLimits builds a decoder for T from a plan, with custom limits, without walking T
+func NewDecoderFromPlanWithLimits[T any](plan *DecodePlan, limits DecodeLimits) (*Decoder[T], error) {
+	var zero T
+	if err := checkPlanType(plan.Type, plan.Size, reflect.TypeOf(zero)); err != nil {
+		return nil, err
+	}
+
+	d, rest, err := planDecoder(plan.Fields, -1, plan.Size, limits)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: %d fields left over", ErrInvalidPlan, len(rest))
+	}
+
+	return &Decoder[T]{impl: d}, nil
+}
+
+// planDecoder builds a decoder for a struct of size bytes, from the first count fields of a plan along with any
+// nested fields, returning the fields that follow them. A count of -1 takes every field.
+func planDecoder(fields []PlanField, count int, size uintptr, limits DecodeLimits) (*decoderImpl, []PlanField, error) {
+	d := &decoderImpl{lookup: map[string]decodeInstruction{}, hashed: map[uint64]decodeInstruction{}, limits: limits}
+
+	for i := 0; i != count && (count >= 0 || len(fields) > 0); i++ {
+		if len(fields) == 0 {
+			return nil, nil, fmt.Errorf("%w: missing fields", ErrInvalidPlan)
+		}
+		f := fields[0]
+		fields = fields[1:]
+
+		if err := checkPlanField(f, size); err != nil {
+			return nil, nil, err
+		}
+		if f.Wire == WirePtrFlag|WireBytes {
+			return nil, nil, fmt.Errorf("%w: field %q is %v", ErrInvalidPlan, f.Name, f.Wire)
+		}
+
+		di := decodeInstruction{kind: f.Wire, offset: f.Offset, tag: f.Name}
+
+		switch f.Wire {
+		case WireStruct:
+			var err error
+			if di.subdec, fields, err = planDecoder(fields, f.Fields, f.Size, limits); err != nil {
+				return nil, nil, err
+			}
+
+		case WireBytes:
+			di.fun = func(p unsafe.Pointer, r Reader) Reader {
+				return decodeBytes(p, r, limits)
+			}
+		}
+
+		if len(f.Name) < smallKeys {
+			d.trie.Add(f.Name, di)
+		} else {
+			d.lookup[f.Name] = di
+			d.hashed[nameHash(f.Name)] = di
+		}
+		d.numfield++
+
+		if f.Required {
+			d.required = append(d.required, f.Name)
+		}
+	}
+
+	return d, fields, nil
+}
+
+// checkPlanType checks that a plan was made from the type t
+func checkPlanType(name string, size uintptr, t reflect.Type) error {
+	if t == nil || t.String() != name || t.Size() != size {
+		return fmt.Errorf("%w: plan is for %s of %d bytes, not %v", ErrInvalidPlan, name, size, t)
+	}
+	return nil
+}
+
+// checkPlanField checks that a field lies within a struct of size bytes, and has the size its wire type calls for
+func checkPlanField(f PlanField, size uintptr) error {
+	if f.Offset > size || f.Size > size-f.Offset {
+		return fmt.Errorf("%w: field %q at offset %d doesn't fit within %d bytes", ErrInvalidPlan, f.Name, f.Offset, size)
+	}
+
+	if f.Wire == WireStruct {
+		if f.Fields < 0 {
+			return fmt.Errorf("%w: field %q has %d fields", ErrInvalidPlan, f.Name, f.Fields)
+		}
+		return nil
+	}
+	if want := planWireSize(f.Wire); want == 0 || f.Size != want {
+		return fmt.Errorf("%w: field %q of %d bytes can't be %v", ErrInvalidPlan, f.Name, f.Size, f.Wire)
+	}
+	return nil
+}
+
+// planWireSize returns the size of the Go types planned for a wire type, or 0 for wire types plans don't cover
+func planWireSize(wire WireType) uintptr {
+	switch wire {
+	case WireBool, WireInt8, WireUint8:
+		return 1
+	case WireInt16, WireUint16:
+		return 2
+	case WireInt32, WireUint32, WireFloat32:
+		return 4
+	case WireInt64, WireUint64, WireFloat64, WireDuration:
+		return 8
+	case WireInt:
+		return unsafe.Sizeof(int(0))
+	case WireUint:
+		return unsafe.Sizeof(uint(0))
+	case WireString:
+		return unsafe.Sizeof("")
+	case WireBytes, WirePtrFlag | WireBytes:
+		return unsafe.Sizeof([]byte{})
+	case WireTime:
+		return unsafe.Sizeof(time.Time{})
+	}
+	return 0
+}
+
+// checkPlanSchema checks that an encode plan's schema is intact, and describes the plan's fields
+func checkPlanSchema(schema []byte, fields []PlanField) error {
+	if len(schema) < 5 || crc32.ChecksumIEEE(schema[5:]) != Document(schema).Hash() {
+		return fmt.Errorf("%w: schema doesn't match its hash", ErrInvalidPlan)
+	}
+
+	p, err := Document(schema).parts(DefaultLimits)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPlan, err)
+	}
+
+	want, _ := appendPlanSchema(nil, fields, -1)
+	if string(p.schema.Remaining()) != string(want) {
+		return fmt.Errorf("%w: schema doesn't describe the plan's fields", ErrInvalidPlan)
+	}
+	return nil
+}
+
+// appendPlanSchema writes the schema entries of the first count fields, returning the fields that follow them. A
+// count of -1 takes every field.
+func appendPlanSchema(b []byte, fields []PlanField, count int) ([]byte, []PlanField) {
+	for i := 0; i != count && len(fields) > 0; i++ {
+		f := fields[0]
+		fields = fields[1:]
+
+		b = appendField(b, f.Name, f.Wire)
+		if f.Wire == WireStruct {
+			var sub []byte
+			sub, fields = appendPlanSchema(nil, fields, f.Fields)
+			b = appendVarintb(b, uint64(len(sub)))
+			b = append(b, sub...)
+		}
+	}
+	return b, fields
+}
+
+// MarshalBinary writes the plan so it can be stored and loaded later
+func (p *EncodePlan) MarshalBinary() ([]byte, error) {
+	return appendPlan(p.Type, p.Size, p.Schema, p.Fields), nil
+}
+
+// UnmarshalBinary reads a plan written by MarshalBinary
+func (p *EncodePlan) UnmarshalBinary(data []byte) error {
+	var err error
+	p.Type, p.Size, p.Schema, p.Fields, err = readPlan(data)
+	return err
+}
+
+// MarshalBinary writes the plan so it can be stored and loaded later
+func (p *DecodePlan) MarshalBinary() ([]byte, error) {
+	return appendPlan(p.Type, p.Size, nil, p.Fields), nil
+}
+
+// UnmarshalBinary reads a plan written by MarshalBinary
+func (p *DecodePlan) UnmarshalBinary(data []byte) error {
+	var err error
+	p.Type, p.Size, _, p.Fields, err = readPlan(data)
+	return err
+}
+
+// appendPlan writes a plan in the layout described above
+func appendPlan(typ string, size uintptr, schema []byte, fields []PlanField) []byte {
+	var b Buffer
+	b.AppendUint8(planVersion)
+	b.AppendString(typ)
+	b.AppendUint(uint(size))
+	b.AppendBytes(schema)
+
+	b.AppendUint(uint(len(fields)))
+	for _, f := range fields {
+		b.AppendString(f.Name)
+		b.AppendUint(uint(f.Wire))
+		b.AppendUint(uint(f.Offset))
+		b.AppendUint(uint(f.Size))
+		b.AppendUint(uint(f.Fields))
+		b.AppendBool(f.Required)
+	}
+
+	return b.Bytes
+}
+
+// readPlan reads a plan written by appendPlan
+func readPlan(data []byte) (typ string, size uintptr, schema []byte, fields []PlanField, err error) {
+
+	defer recoverErrAs(&err, ErrInvalidPlan) // the Reader panics on truncated plans
+
+	r := NewReader(data)
+	if v := r.ReadByte(); v != planVersion {
+		return "", 0, nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidPlan, v)
+	}
+
+	typ = string(r.Read(r.ReadVarint()))
+	size = uintptr(r.ReadVarint())
+	if s := r.Read(r.ReadVarint()); len(s) > 0 {
+		schema = append([]byte{}, s...)
+	}
+
+	n := r.ReadVarint()
+	if n > r.BytesLeft() { // every field takes several bytes, bounding the allocation
+		return "", 0, nil, nil, fmt.Errorf("%w: %d fields", ErrInvalidPlan, n)
+	}
+
+	fields = make([]PlanField, n)
+	for i := range fields {
+		fields[i] = PlanField{
+			Name:     string(r.Read(r.ReadVarint())),
+			Wire:     WireType(r.ReadVarint()),
+			Offset:   uintptr(r.ReadVarint()),
+			Size:     uintptr(r.ReadVarint()),
+			Fields:   int(r.ReadVarint()),
+			Required: r.ReadBool(),
+		}
+	}
+
+	if r.BytesLeft() > 0 {
+		return "", 0, nil, nil, fmt.Errorf("%w: bytes remaining > 0: %v", ErrInvalidPlan, r.BytesLeft())
+	}
+	return typ, size, schema, fields, nil
+}
@@ -0,0 +1,288 @@
+package glint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPolicyViolation is returned by decoders enforcing a SchemaPolicy when a document's schema breaks a rule set to
+// PolicyReject
+var ErrPolicyViolation = errors.New("schema policy violation")
+
+// PolicyAction is what a SchemaPolicy does about a schema that breaks one of its rules
+type PolicyAction uint8
+
+const (
+	PolicyAllow  PolicyAction = iota // the change is accepted without comment
+	PolicyFlag                       // the change is reported to OnViolation, and the document decoded as usual
+	PolicyReject                     // the change is reported to OnViolation, and the document refused
+)
+
+// String implements fmt.Stringer
+func (a PolicyAction) String() string {
+	switch a {
+	case PolicyAllow:
+		return "allow"
+	case PolicyFlag:
+		return "flag"
+	case PolicyReject:
+		return "reject"
+	}
+	return fmt.Sprintf("PolicyAction(%d)", uint8(a))
+}
+
+// MarshalText implements encoding.TextMarshaler, writing the action as it's written in policy configs
+func (a PolicyAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, reading "allow", "flag" or "reject"
+func (a *PolicyAction) UnmarshalText(text []byte) error {
+	for _, action := range []PolicyAction{PolicyAllow, PolicyFlag, PolicyReject} {
+		if string(text) == action.String() {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("glint: unknown policy action %q, expected allow, flag or reject", text)
+}
+
+// PolicyRules are the ways a document's schema may differ from the schema of the type it's decoded into, along
+// with what to do about each. The zero value allows every change, as decoders without a policy do. Type changes
+// fail to decode whatever the rules say, setting TypeChanges reports them before any of the document is read.
+type PolicyRules struct {
+	TypeChanges   PolicyAction `json:"type_changes"`   // a field has a different type to the decoder's
+	AddedFields   PolicyAction `json:"added_fields"`   // a field the decoder's type doesn't have
+	RemovedFields PolicyAction `json:"removed_fields"` // a field of the decoder's type is missing
+}
+
+// ParsePolicyRules reads rules from a JSON config, such as
+//
+//	{"type_changes": "reject", "added_fields": "allow", "removed_fields": "flag"}
+//
+// Rules missing from the config allow the change. Unknown rules and actions are an error, so that a typo can't
+// quietly loosen a policy.
+func ParsePolicyRules(config []byte) (PolicyRules, error) {
+	var rules PolicyRules
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return PolicyRules{}, fmt.Errorf("glint: parsing policy: %w", err)
+	}
+	return rules, nil
+}
+
+// PolicyViolation describes a single way a document's schema breaks a SchemaPolicy
+type PolicyViolation struct {
+	Rule     string       // the rule broken, as named in policy configs, e.g. "type_changes"
+	Field    string       // the field, with the fields of nested structs following their parent's name after a dot
+	Expected WireType     // the decoder's type for the field, 0 for added fields
+	Got      WireType     // the document's type for the field, 0 for removed fields
+	Action   PolicyAction // what the policy did about it
+	Hash     uint32       // the hash of the document's schema
+}
+
+// String implements fmt.Stringer
+func (v PolicyViolation) String() string {
+	switch v.Rule {
+	case "type_changes":
+		return fmt.Sprintf("field %q changed type from %v to %v", v.Field, v.Expected, v.Got)
+	case "added_fields":
+		return fmt.Sprintf("field %q of type %v was added", v.Field, v.Got)
+	}
+	return fmt.Sprintf("field %q of type %v was removed", v.Field, v.Expected)
+}
+
+// SchemaPolicy governs how the schemas of the documents a decoder receives may differ from the schema of the type
+// it decodes into, see Decoder.EnforcePolicy. One policy can be shared by every decoder in a process, and its rules
+// replaced at any time with SetRules or Reload, such as when an operator edits its config. Decoders pick up new
+// rules with the next document they decode.
+//
+// Each schema is checked once for each set of rules, so flagged violations are reported once rather than for
+// every document.
+type SchemaPolicy struct {
+	rules atomic.Pointer[PolicyRules]
+
+	// OnViolation, when set, is called with every flagged or rejected violation. It may be called concurrently
+	// by decoders sharing the policy.
+	OnViolation func(PolicyViolation)
+}
+
+// NewSchemaPolicy creates a policy enforcing the supplied rules
+func NewSchemaPolicy(rules PolicyRules) *SchemaPolicy {
+	p := &SchemaPolicy{}
+	p.SetRules(rules)
+	return p
+}
+
+// Rules returns the rules the policy currently enforces
+func (p *SchemaPolicy) Rules() PolicyRules {
+	if r := p.rules.Load(); r != nil {
+		return *r
+	}
+	return PolicyRules{}
+}
+
+// SetRules replaces the rules the policy enforces
+func (p *SchemaPolicy) SetRules(rules PolicyRules) {
+	p.rules.Store(&rules)
+}
+
+// Reload replaces the rules the policy enforces with those of a JSON config, see ParsePolicyRules. The rules are
+// left as they were when the config can't be parsed.
+func (p *SchemaPolicy) Reload(config []byte) error {
+	rules, err := ParsePolicyRules(config)
+	if err != nil {
+		return err
+	}
+	p.SetRules(rules)
+	return nil
+}
+
+// policyCheck enforces a policy for a single decoder, remembering the outcome for each schema it has checked
+type policyCheck struct {
+	policy   *SchemaPolicy
+	expected []schemaNode // the schema of the decoder's type
+	results  sync.Map     // uint32 -> policyResult
+}
+
+// policyResult is the outcome of checking a schema against a set of rules
+type policyResult struct {
+	rules *PolicyRules
+	err   error
+}
+
+// EnforcePolicy checks the schema of each document d decodes against p, comparing it with the schema of T. Broken
+// rules set to PolicyFlag are reported to p.OnViolation and the document decoded as usual. Those set to
+// PolicyReject are reported too, and the document refused with an error wrapping ErrPolicyViolation, before any
+// of it is decoded. Documents written without their schema, in trusted schema mode, get the verdict of an earlier
+// document with the same schema, or one resolved through the decoder's registry, and aren't checked otherwise. It
+// returns d, and should be called before the decoder is first used.
+//
+//	policy := glint.NewSchemaPolicy(glint.PolicyRules{AddedFields: glint.PolicyFlag, RemovedFields: glint.PolicyReject})
+//	dec := glint.NewDecoder[Order]().EnforcePolicy(policy)
+func (d *Decoder[T]) EnforcePolicy(p *SchemaPolicy) *Decoder[T] {
+	var zero T
+	parts, err := Document(SchemaBytes(zero)).parts(DefaultLimits)
+	if err != nil {
+		panic(fmt.Sprintf("glint: schema of %T: %v", zero, err))
+	}
+	d.impl.policy = &policyCheck{policy: p, expected: parseSchemaNodes(parts.schema)}
+	return d
+}
+
+// check returns the policy's verdict on the schema of a document, checking it when it hasn't been checked against
+// the current rules
+func (c *policyCheck) check(parts documentParts, registry *SchemaRegistry, limits DecodeLimits) error {
+	rules := c.policy.rules.Load()
+	if rules == nil {
+		return nil
+	}
+
+	hash := binary.LittleEndian.Uint32(parts.hash)
+	if r, ok := c.results.Load(hash); ok && r.(policyResult).rules == rules {
+		return r.(policyResult).err
+	}
+
+	if parts.schema.BytesLeft() == 0 {
+		if registry == nil {
+			return nil // nothing to check until a document with the same schema carries it
+		}
+		schema, err := registry.resolve(parts)
+		if err != nil {
+			return err
+		}
+		parts.schema = schema
+	}
+	if err := parts.inlineSchema(limits); err != nil {
+		return err
+	}
+	if err := checkSchema(parts.schema, limits); err != nil {
+		return err
+	}
+
+	var violations []PolicyViolation
+	compareSchemas(c.expected, parseSchemaNodes(parts.schema), "", rules, &violations)
+
+	var rejected []error
+	for i := range violations {
+		violations[i].Hash = hash
+		if c.policy.OnViolation != nil {
+			c.policy.OnViolation(violations[i])
+		}
+		if violations[i].Action == PolicyReject {
+			rejected = append(rejected, fmt.Errorf("%w: %v", ErrPolicyViolation, violations[i]))
+		}
+	}
+
+	err := errors.Join(rejected...)
+	c.results.Store(hash, policyResult{rules: rules, err: err})
+	return err
+}
+
+// compareSchemas appends a violation for every difference between the fields of the decoder's schema and those of
+// a document that the rules don't allow, nested structs included
+func compareSchemas(expected, got []schemaNode, path string, rules *PolicyRules, violations *[]PolicyViolation) {
+	report := func(rule string, action PolicyAction, field string, expected, got WireType) {
+		if action != PolicyAllow {
+			*violations = append(*violations, PolicyViolation{Rule: rule, Field: field, Expected: expected, Got: got, Action: action})
+		}
+	}
+
+next:
+	for i := range expected {
+		e := &expected[i]
+		for j := range got {
+			if g := &got[j]; g.name == e.name {
+				compareNodes(e, g, path+e.name, rules, violations)
+				continue next
+			}
+		}
+		report("removed_fields", rules.RemovedFields, path+e.name, e.wire, 0)
+	}
+
+	for j := range got {
+		found := false
+		for i := range expected {
+			found = found || expected[i].name == got[j].name
+		}
+		if !found {
+			report("added_fields", rules.AddedFields, path+got[j].name, 0, got[j].wire)
+		}
+	}
+}
+
+// compareNodes compares a field both schemas have, looking inside structs, and the structs held by slices and maps
+func compareNodes(e, g *schemaNode, path string, rules *PolicyRules, violations *[]PolicyViolation) {
+	ew, gw := policyWire(e.wire), policyWire(g.wire)
+	if ew != gw && !durationCompatible(ew, gw) {
+		if rules.TypeChanges != PolicyAllow {
+			*violations = append(*violations, PolicyViolation{Rule: "type_changes", Field: path, Expected: e.wire, Got: g.wire, Action: rules.TypeChanges})
+		}
+		return
+	}
+
+	switch {
+	case e.fields != nil || g.fields != nil:
+		compareSchemas(e.fields, g.fields, path+".", rules, violations)
+	case e.elem != nil && g.elem != nil:
+		compareNodes(e.elem, g.elem, path, rules, violations)
+	case e.value != nil && g.value != nil:
+		compareNodes(e.value, g.value, path, rules, violations)
+	}
+}
+
+// policyWire strips the flags that change how a value is written but not what it is, which decoders read either
+// way. Slices may be written with or without a presence byte.
+func policyWire(wire WireType) WireType {
+	wire &^= WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag
+	if isSliceWire(wire) {
+		wire &^= WirePtrFlag
+	}
+	return wire
+}
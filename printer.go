@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // The code for Print and its supporting parts are not written with the same strict performance
@@ -19,14 +20,28 @@ type PrinterDocument struct {
 	Body   Reader
 }
 
-// NewPrinterDocument reads a document from a Reader and returns a PrinterDocument
-func NewPrinterDocument(r *Reader) PrinterDocument {
-	return PrinterDocument{
-		Flags:  r.ReadByte(),
-		CRC32:  r.Read(4),
-		Schema: NewReader(r.Read(r.ReadVarint())),
-		Body:   NewReader(r.Remaining()),
+// NewPrinterDocument reads a document from a Reader and returns a PrinterDocument. Malformed and truncated
+// documents return an error wrapping ErrInvalidDocument.
+func NewPrinterDocument(r *Reader) (doc PrinterDocument, err error) {
+	defer recoverErr(&err)
+
+	p, err := splitDocument(r.Remaining())
+	if err == nil {
+		err = p.inlineSchema(DefaultLimits)
+	}
+	if err == nil {
+		err = checkNesting(p.schema, DefaultLimits)
 	}
+	if err != nil {
+		return PrinterDocument{}, err
+	}
+
+	return PrinterDocument{
+		Flags:  p.flags,
+		CRC32:  p.hash,
+		Schema: p.schema,
+		Body:   p.body,
+	}, nil
 }
 
 // PrinterSchema represents the schema of a glint document, intended for tooling purposes.
@@ -81,11 +96,11 @@ func NewRawPrinterSchemaField(r *Reader) PrinterSchemaField {
 func NewPrinterSchemaField(r *Reader) PrinterSchemaField {
 	f := PrinterSchemaField{
 		TypeID: WireType(r.ReadVarint()),
-		Name:   string(r.Read(r.ReadVarint())),
+		Name:   string(r.Read(uint(r.ReadByte()))),
 	}
 
 	f.IsSlice = f.TypeID&WireSliceFlag > 0
-	f.IsPointer = f.TypeID&WirePtrFlag > 0
+	f.IsPointer = f.TypeID&WirePtrFlag > 0 && !isSliceWire(f.TypeID) // on slices the flag only marks them as nil-able
 
 	f.ReadSubSchema(r)
 
@@ -102,27 +117,7 @@ func (f *PrinterSchemaField) ReadSubSchema(r *Reader) {
 		f.NestedSchema = &ns
 
 	case f.TypeID == WireMap:
-		f.MapType = [2]WireType{WireType(r.ReadVarint()), WireType(r.ReadVarint())}
-
-		switch {
-		case f.MapType[1]&WireSliceFlag > 0:
-			r.Unread(1)
-
-			ns := NewRawPrinterSchemaField(r)
-			f.NestedSlice = &ns
-
-		case f.MapType[1]&WireTypeMask == WireStruct:
-
-			nr := NewReader(r.Read(r.ReadVarint()))
-			ns := NewPrinterSchema(&nr)
-
-			f.NestedSchema = &ns // drop the schema on the last level of the slice we parsed
-
-		case f.MapType[1] == WireMap:
-
-			r.Unread(1)
-			f.NestedSchema = &PrinterSchema{Fields: []PrinterSchemaField{NewRawPrinterSchemaField(r)}}
-		}
+		f.readMapSchema(r)
 
 	case f.TypeID&WireSliceFlag > 0:
 		switch {
@@ -132,9 +127,12 @@ func (f *PrinterSchemaField) ReadSubSchema(r *Reader) {
 			ns := NewPrinterSchema(&nr)
 			f.NestedSchema = &ns
 
-		case f.TypeID == WireSliceFlag:
+		case WireType(f.TypeID&WireTypeMask) == WireMap:
+			f.readMapSchema(r)
+
+		case f.TypeID&^WirePtrFlag == WireSliceFlag:
 
-			ct := f.TypeID
+			ct := f.TypeID &^ WirePtrFlag
 
 			for {
 				if ct == WireSliceFlag {
@@ -155,12 +153,41 @@ func (f *PrinterSchemaField) ReadSubSchema(r *Reader) {
 
 				f.NestedSchema = &ns // drop the schema on the last level of the slice we parsed
 			}
+			if ct&WireSliceFlag > 0 && ct&WireTypeMask == WireMap {
+				f.readMapSchema(r)
+			}
 
 		default: // just simple slice types
 		}
 	}
 }
 
+// readMapSchema reads the key and value types of a map, along with the schema of its values, for map fields and
+// slices of maps
+func (f *PrinterSchemaField) readMapSchema(r *Reader) {
+	f.MapType = [2]WireType{WireType(r.ReadVarint()), WireType(r.ReadVarint())}
+
+	switch {
+	case f.MapType[1]&WireSliceFlag > 0:
+		r.Unread(1)
+
+		ns := NewRawPrinterSchemaField(r)
+		f.NestedSlice = &ns
+
+	case f.MapType[1]&WireTypeMask == WireStruct:
+
+		nr := NewReader(r.Read(r.ReadVarint()))
+		ns := NewPrinterSchema(&nr)
+
+		f.NestedSchema = &ns // drop the schema on the last level of the slice we parsed
+
+	case f.MapType[1] == WireMap:
+
+		r.Unread(1)
+		f.NestedSchema = &PrinterSchema{Fields: []PrinterSchemaField{NewRawPrinterSchemaField(r)}}
+	}
+}
+
 // typeIDString returns a string representation of the typeID of a PrinterSchemaField
 func typeIDString(field PrinterSchemaField) string {
 
@@ -173,9 +200,23 @@ func typeIDString(field PrinterSchemaField) string {
 		if id&WireDeltaFlag > 0 {
 			t += "(delta)"
 		}
+		if id&WireSparseFlag > 0 {
+			t += "(sparse)"
+		}
+		if id&WireBitmapFlag > 0 {
+			t += "(bitmap)"
+		}
+	}
+
+	if id&WireDictFlag > 0 {
+		t += "(dict)"
 	}
 
-	if id&WirePtrFlag > 0 {
+	if id&WireZigzagFlag > 0 {
+		t += "(zigzag)"
+	}
+
+	if id&WirePtrFlag > 0 && !isSliceWire(id) {
 		t += "*"
 	}
 
@@ -220,6 +261,18 @@ func typeIDString(field PrinterSchemaField) string {
 		t += "Map[" + keyType + "]" + valueType
 	case WireTime:
 		t += "Time"
+	case WireDuration:
+		t += "Duration"
+	case WireIP:
+		t += "IP"
+	case WireIPPrefix:
+		t += "IPPrefix"
+	case WireBigInt:
+		t += "BigInt"
+	case WireDecimal:
+		t += "Decimal"
+	case WireDynamic:
+		t += "Any"
 	case 0:
 		if field.NestedSlice != nil {
 			t += typeIDString(*field.NestedSlice)
@@ -257,6 +310,8 @@ func fieldValueString(r *Reader, schemaField *PrinterSchemaField) string {
 		return strconv.Itoa(int(r.ReadInt32()))
 	case WireInt64:
 		return strconv.Itoa(int(r.ReadInt64()))
+	case WireInt64 | WireZigzagFlag:
+		return strconv.FormatInt(r.ReadZigzagInt64(), 10)
 	case WireUint:
 		return strconv.FormatUint(uint64(r.ReadUint()), 10)
 	case WireUint8:
@@ -273,10 +328,30 @@ func fieldValueString(r *Reader, schemaField *PrinterSchemaField) string {
 		return strconv.FormatFloat(r.ReadFloat64(), 'f', -1, 64)
 	case WireString:
 		return r.ReadString()
+	case WireString | WireDictFlag:
+		return r.ReadDictString()
 	case WireBytes:
 		return fmt.Sprintf("%v", r.Read(r.ReadVarint()))
 	case WireTime:
 		return fmt.Sprintf("%v", r.ReadTime())
+	case WireDuration:
+		return r.ReadDuration().String()
+	case WireDuration | WireZigzagFlag:
+		return time.Duration(r.ReadZigzagInt64()).String()
+	case WireIP:
+		return r.ReadAddr().String()
+	case WireIPPrefix:
+		return r.ReadPrefix().String()
+	case WireBigInt:
+		return r.ReadBigInt().String()
+	case WireDecimal:
+		return r.ReadDecimal().String()
+	case WireDynamic:
+		_, value := readDynamic(r)
+		if value == nil {
+			return "nil"
+		}
+		return fmt.Sprintf("%v", value)
 	case WireBool:
 		if r.ReadBool() {
 			return "true"
@@ -292,6 +367,28 @@ func SPrintSlice(r *Reader, field *PrinterSchemaField, nestLevel int) string {
 
 	var buf strings.Builder
 
+	// checked first, as maps of slices keep the schema of their values in NestedSlice
+	if field.TypeID&WireSliceFlag > 0 && field.TypeID&WireTypeMask == WireMap {
+		for i, l := 0, r.ReadVarint(); i < int(l); i++ {
+			fmt.Fprintf(&buf, "   %v└─┐ [%v]:\n", strings.Repeat("  ", nestLevel), i)
+			fmt.Fprintf(&buf, "%v", SPrintMap(r, field, nestLevel+1))
+		}
+		return buf.String()
+	}
+
+	// slices of pointers to structs keep the schema of their elements in NestedSlice, as slices of slices do
+	if field.NestedSlice != nil && field.NestedSlice.TypeID&WireSliceFlag == 0 {
+		for i, l := 0, r.ReadVarint(); i < int(l); i++ {
+			if r.ReadByte() == 0 {
+				fmt.Fprintf(&buf, "   %v├  [%v]: nil \n", strings.Repeat("  ", nestLevel), i)
+				continue
+			}
+			fmt.Fprintf(&buf, "   %v└─┐ [%v]:\n", strings.Repeat("  ", nestLevel), i)
+			fmt.Fprintf(&buf, "%v", SPrintStruct(r, field.NestedSlice.NestedSchema, nestLevel+1))
+		}
+		return buf.String()
+	}
+
 	if field.NestedSlice != nil {
 		for i, l := 0, r.ReadVarint(); i < int(l); i++ {
 			fmt.Fprintf(&buf, "   %v└─  [%v]:\n", strings.Repeat("  ", nestLevel), i)
@@ -313,6 +410,29 @@ func SPrintSlice(r *Reader, field *PrinterSchemaField, nestLevel int) string {
 		return buf.String()
 	}
 
+	// bitmaps print every element, whichever layout they were sent in
+	if field.TypeID&WireBitmapFlag != 0 {
+		for i, v := range r.ReadBitmap() {
+			fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, v)
+		}
+		return buf.String()
+	}
+
+	// sparse slices only print their non-zero elements, unless they were sent dense
+	if field.TypeID&WireSparseFlag != 0 {
+		elem := *field
+		elem.TypeID &= WireTypeMask
+
+		length, count := r.ReadVarint(), r.ReadVarint()
+		for i, next := uint(0), uint(0); i < count; i, next = i+1, next+1 {
+			if count != length {
+				next += r.ReadVarint() // skip the zeros
+			}
+			fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), next, fieldValueString(r, &elem))
+		}
+		return buf.String()
+	}
+
 	// Check if this is a delta-encoded slice
 	if field.TypeID&WireDeltaFlag != 0 {
 		length := r.ReadVarint()
@@ -363,6 +483,16 @@ func SPrintSlice(r *Reader, field *PrinterSchemaField, nestLevel int) string {
 				prev += delta
 				fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, prev)
 			}
+		case WireDuration:
+			// First value
+			prev := r.ReadDuration()
+			fmt.Fprintf(&buf, "   %v├  [0]: %v \n", strings.Repeat("  ", nestLevel), prev)
+			// Subsequent values are deltas
+			for i := uint(1); i < length; i++ {
+				delta := time.Duration(r.ReadZigzagVarint())
+				prev += delta
+				fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, prev)
+			}
 		case WireUint:
 			// First value
 			prev := r.ReadUint()
@@ -403,6 +533,24 @@ func SPrintSlice(r *Reader, field *PrinterSchemaField, nestLevel int) string {
 				prev += delta
 				fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, prev)
 			}
+		case WireFloat32:
+			// First value
+			prev := r.ReadFloat32()
+			fmt.Fprintf(&buf, "   %v├  [0]: %v \n", strings.Repeat("  ", nestLevel), prev)
+			// Subsequent values are the bits that changed
+			for i := uint(1); i < length; i++ {
+				prev = r.ReadFloat32Delta(prev)
+				fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, prev)
+			}
+		case WireFloat64:
+			// First value
+			prev := r.ReadFloat64()
+			fmt.Fprintf(&buf, "   %v├  [0]: %v \n", strings.Repeat("  ", nestLevel), prev)
+			// Subsequent values are the bits that changed
+			for i := uint(1); i < length; i++ {
+				prev = r.ReadFloat64Delta(prev)
+				fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, prev)
+			}
 		default:
 			// For unsupported types, just show a message
 			fmt.Fprintf(&buf, "   %v├  <delta encoding not supported for this type> \n", strings.Repeat("  ", nestLevel))
@@ -410,7 +558,7 @@ func SPrintSlice(r *Reader, field *PrinterSchemaField, nestLevel int) string {
 		return buf.String()
 	}
 
-	field.TypeID &= WireTypeMask
+	field.TypeID &= WireTypeMask | WireDictFlag | WireZigzagFlag
 	for i, l := 0, r.ReadVarint(); i < int(l); i++ {
 		fmt.Fprintf(&buf, "   %v├  [%v]: %v \n", strings.Repeat("  ", nestLevel), i, fieldValueString(r, field))
 	}
@@ -575,12 +723,15 @@ func PrintSchema(schema *PrinterSchema, nestLevel int) {
 	}
 }
 
-// Print prints a document
+// Print prints a document. Malformed and truncated documents panic, SPrint returns them as an error instead.
 func Print(bytes []byte) []byte {
 
 	r := NewReader(bytes) // used to traverse the doc easily
 
-	doc := NewPrinterDocument(&r)
+	doc, err := NewPrinterDocument(&r)
+	if err != nil {
+		panic(err)
+	}
 
 	defer func() {
 		if rc := recover(); rc != nil {
@@ -604,16 +755,22 @@ func Print(bytes []byte) []byte {
 	return doc.Body.Remaining()
 }
 
-// SPrint returns a string representation of a glint document, similar to Print but returns the string instead of printing to stdout
-func SPrint(bytes []byte) string {
+// SPrint returns a string representation of a glint document, similar to Print but returns the string instead of printing to stdout.
+// Malformed and truncated documents return an error wrapping ErrInvalidDocument rather than panicking.
+func SPrint(bytes []byte) (s string, err error) {
 	if len(bytes) == 0 {
-		return ""
+		return "", nil
 	}
 	var buf strings.Builder
 
+	defer recoverErr(&err)
+
 	r := NewReader(bytes) // used to traverse the doc easily
 
-	doc := NewPrinterDocument(&r)
+	doc, err := NewPrinterDocument(&r)
+	if err != nil {
+		return "", err
+	}
 
 	schema := NewPrinterSchema(&doc.Schema)
 
@@ -659,7 +816,7 @@ func SPrint(bytes []byte) string {
 	structStr := SPrintStructWithColors(&doc.Body, &schema, 0, false)
 	buf.WriteString(structStr)
 
-	return buf.String()
+	return buf.String(), nil
 }
 
 var terminaloutput = func() bool {
@@ -686,43 +843,3 @@ func colorTextWithFlag(text, color string, useColors bool) string {
 	}
 	return color + text + Reset
 }
-
-// Document is a type alias for []byte that implements fmt.Formatter for pretty printing glint documents
-type Document []byte
-
-// String implements fmt.Stringer interface
-func (d Document) String() string {
-	if len(d) == 0 {
-		return ""
-	}
-	return SPrint([]byte(d))
-}
-
-// Format implements fmt.Formatter interface for custom formatting verbs
-func (d Document) Format(f fmt.State, verb rune) {
-	switch verb {
-	case 's':
-		// %s - pretty printed document
-		f.Write([]byte(d.String()))
-	case 'v':
-		if f.Flag('+') {
-			// %+v - verbose format showing both tree and hex
-			f.Write([]byte(fmt.Sprintf("Glint Document (hex: %x)\n%s", []byte(d), d.String())))
-		} else {
-			// %v - same as %s
-			f.Write([]byte(d.String()))
-		}
-	case 'x':
-		// %x - lowercase hex representation
-		fmt.Fprintf(f, "%x", []byte(d))
-	case 'X':
-		// %X - uppercase hex representation
-		fmt.Fprintf(f, "%X", []byte(d))
-	case 'q':
-		// %q - quoted hex representation
-		fmt.Fprintf(f, "%q", []byte(d))
-	default:
-		// fallback to default []byte formatting
-		fmt.Fprintf(f, "%%!%c(glint.Document=%x)", verb, []byte(d))
-	}
-}
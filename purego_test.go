@@ -0,0 +1,36 @@
+//go:build purego
+
+package glint
+
+import "testing"
+
+func TestPureGoStringsAreCopied(t *testing.T) {
+
+	type row struct {
+		Name  string   `glint:"name"`
+		Tags  []string `glint:"tags"`
+		Items []struct {
+			Label string `glint:"label"`
+		} `glint:"items"`
+	}
+
+	in := row{Name: "name", Tags: []string{"tag"}}
+	in.Items = append(in.Items, struct {
+		Label string `glint:"label"`
+	}{"label"})
+
+	b := &Buffer{}
+	NewEncoder[row]().Marshal(&in, b)
+
+	var out row
+	if err := NewDecoder[row]().Unmarshal(b.Bytes, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range b.Bytes {
+		b.Bytes[i] = 'x'
+	}
+	if out.Name != "name" || out.Tags[0] != "tag" || out.Items[0].Label != "label" {
+		t.Errorf("expected decoded strings to be copies of the document, got %+v", out)
+	}
+}
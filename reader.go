@@ -1,17 +1,27 @@
 package glint
 
 import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"strings"
 	"time"
-	"unsafe"
 )
 
 // Reader provides sequential access to encoded data with position tracking.
 type Reader struct {
 	position uint // current read position (first for alignment)
 	bytes    []byte
-	mark     uint // saved position for later reference
+	mark     uint         // saved position for later reference
+	strings  *[]string    // the document's string table, when it has one, see dict.go
+	arena    *Arena       // what decoded values are allocated from, when set, see arena.go
+	copies   stringCopies // which decoded strings are copied out of the document, see WithCopiedStrings
 }
 
+// errReadOutOfBounds is what the Reader panics with when a read runs past the end of its bytes
+var errReadOutOfBounds = fmt.Errorf("%w: read out of bounds", ErrTruncatedDocument)
+
 func NewReader(b []byte) Reader {
 	return Reader{bytes: b}
 }
@@ -33,13 +43,13 @@ func (r *Reader) ReadVarint() uint {
 		sf += 7
 	}
 
-	return 0
+	panic(errReadOutOfBounds) // the varint runs past the end, a truncated document
 }
 
 // ReadZigzagVarint decodes a zigzag-encoded variable integer.
 func (r *Reader) ReadZigzagVarint() int {
 	i := r.ReadVarint()
-	return (int(i) >> 1) ^ -(int(i) & 1)
+	return int(i>>1) ^ -int(i&1) // shifted unsigned, so values with the top bit set don't carry the sign down
 }
 
 // ReadUint8 extracts a single byte
@@ -87,16 +97,57 @@ func (r *Reader) ReadInt64() int64 {
 	return int64(r.ReadVarint())
 }
 
+// ReadZigzagInt64 decodes an int64 written as a zigzag varint, see WireZigzagFlag
+func (r *Reader) ReadZigzagInt64() int64 {
+	u := uint64(r.ReadVarint())
+	return int64(u>>1) ^ -int64(u&1)
+}
+
 // ReadFloat32 decodes a float32 from its uint32 bit representation
 func (r *Reader) ReadFloat32() float32 {
 	v := uint32(r.ReadVarint())
-	return *(*float32)(unsafe.Pointer(&v))
+	return math.Float32frombits(v)
 }
 
 // ReadFloat64 decodes a float64 from its uint64 bit representation
 func (r *Reader) ReadFloat64() float64 {
 	v := r.ReadVarint()
-	return *(*float64)(unsafe.Pointer(&v))
+	return math.Float64frombits(uint64(v))
+}
+
+// ReadFloat32Delta decodes a float32 from a delta encoded slice, written as the bits that differ from prev
+func (r *Reader) ReadFloat32Delta(prev float32) float32 {
+	return math.Float32frombits(math.Float32bits(prev) ^ uint32(r.readFloatXor()))
+}
+
+// ReadFloat64Delta decodes a float64 from a delta encoded slice, written as the bits that differ from prev
+func (r *Reader) ReadFloat64Delta(prev float64) float64 {
+	return math.Float64frombits(math.Float64bits(prev) ^ r.readFloatXor())
+}
+
+// readFloatXor reads the differing bits written by appendFloatXor
+func (r *Reader) readFloatXor() uint64 {
+	var lo, hi uint64
+	for sf := uint(0); ; sf += 7 {
+		d := r.ReadByte()
+		v := uint64(d & 0b01111111)
+		if sf < 64 {
+			lo |= v << sf
+			hi |= v >> (64 - sf)
+		} else {
+			hi |= v << (sf - 64)
+		}
+
+		if d&0b10000000 == 0 {
+			break
+		}
+		if sf >= 63 {
+			panic("float delta longer than 10 bytes")
+		}
+	}
+
+	tz := lo & 0b111111
+	return (lo>>6 | hi<<58) << tz
 }
 
 // ReadInt decodes a zigzag-encoded int
@@ -106,13 +157,34 @@ func (r *Reader) ReadInt() int {
 
 // ReadString decodes a length-prefixed string
 func (r *Reader) ReadString() string {
-	l := r.ReadVarint()
-	if r.position+l > uint(len(r.bytes)) {
-		panic("read out of bounds")
+	return r.str(r.Read(r.ReadVarint()))
+}
+
+// ReadDictString decodes a string written to the document's string table, see dict.go
+func (r *Reader) ReadDictString() string {
+	i := r.ReadVarint()
+	s, ok := r.tableString(i)
+	if !ok {
+		panic(fmt.Sprintf("string table entry %d out of range", i))
 	}
 
-	b := r.Read(l)
-	return *(*string)(unsafe.Pointer(&b))
+	switch { // the table is read once for the whole document, so its strings are copied as they're used
+	case r.arena != nil:
+		b := r.arena.bytes(len(s), 1)
+		copy(b, s)
+		return bytesToString(b)
+	case r.copies == copyAll:
+		return strings.Clone(s)
+	}
+	return s
+}
+
+// tableString returns entry i of the document's string table, and false when there's no such entry
+func (r *Reader) tableString(i uint) (string, bool) {
+	if r.strings == nil || i >= uint(len(*r.strings)) {
+		return "", false
+	}
+	return (*r.strings)[i], true
 }
 
 // ReadBool interprets a byte as boolean: 1 = true, 0 = false.
@@ -128,9 +200,43 @@ func (r *Reader) ReadTime() time.Time {
 	return t
 }
 
+// ReadDuration decodes a time.Duration, written as a variable-length int64
+func (r *Reader) ReadDuration() time.Duration {
+	return time.Duration(r.ReadInt64())
+}
+
+// ReadAddr decodes an IP address, returning the zero Addr when it was written without any bytes
+func (r *Reader) ReadAddr() netip.Addr {
+	addr, _ := netip.AddrFromSlice(r.Read(r.ReadVarint()))
+	return addr
+}
+
+// ReadPrefix decodes an IP prefix, returning the zero Prefix when it was written without any bytes
+func (r *Reader) ReadPrefix() netip.Prefix {
+	b := r.Read(r.ReadVarint())
+	if len(b) == 0 {
+		return netip.Prefix{}
+	}
+
+	addr, _ := netip.AddrFromSlice(b[:len(b)-1])
+	return netip.PrefixFrom(addr, int(b[len(b)-1]))
+}
+
+// ReadBigInt decodes an arbitrary precision integer
+func (r *Reader) ReadBigInt() *big.Int {
+	return setBigInt(new(big.Int), r.Read(r.ReadVarint()))
+}
+
+// ReadDecimal decodes an exact decimal number
+func (r *Reader) ReadDecimal() Decimal {
+	vr := NewReader(r.Read(r.ReadVarint()))
+	exponent := vr.ReadInt32()
+	return Decimal{Coefficient: setBigInt(new(big.Int), vr.Remaining()), Exponent: exponent}
+}
+
 // ReadStringSlice decodes a length-prefixed array of strings
 func (r *Reader) ReadStringSlice() []string {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]string, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadString()
@@ -141,7 +247,7 @@ func (r *Reader) ReadStringSlice() []string {
 
 // ReadUintSlice extracts an array of variable-length uints
 func (r *Reader) ReadUintSlice() []uint {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]uint, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadUint()
@@ -152,7 +258,7 @@ func (r *Reader) ReadUintSlice() []uint {
 
 // ReadIntSlice decodes an array of zigzag-encoded ints
 func (r *Reader) ReadIntSlice() []int {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]int, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadInt()
@@ -161,6 +267,16 @@ func (r *Reader) ReadIntSlice() []int {
 	return s
 }
 
+// readSliceLength reads the length of a slice whose elements each take at least a byte, so that a length beyond
+// the bytes left panics as a truncated document rather than making a slice of it
+func (r *Reader) readSliceLength() uint {
+	length := r.ReadUint()
+	if length > r.BytesLeft() {
+		panic(errReadOutOfBounds)
+	}
+	return length
+}
+
 // ReadUint8Slice returns a byte slice of the specified length
 func (r *Reader) ReadUint8Slice() []uint8 {
 	length := r.ReadUint()
@@ -169,7 +285,7 @@ func (r *Reader) ReadUint8Slice() []uint8 {
 
 // ReadUint16Slice decodes an array of variable-length uint16s
 func (r *Reader) ReadUint16Slice() []uint16 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]uint16, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadUint16()
@@ -180,7 +296,7 @@ func (r *Reader) ReadUint16Slice() []uint16 {
 
 // ReadUint32Slice extracts multiple uint32 values
 func (r *Reader) ReadUint32Slice() []uint32 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]uint32, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadUint32()
@@ -191,7 +307,7 @@ func (r *Reader) ReadUint32Slice() []uint32 {
 
 // ReadUint64Slice decodes a sequence of uint64 values
 func (r *Reader) ReadUint64Slice() []uint64 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]uint64, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadUint64()
@@ -202,7 +318,7 @@ func (r *Reader) ReadUint64Slice() []uint64 {
 
 // ReadInt8Slice extracts an array of signed bytes
 func (r *Reader) ReadInt8Slice() []int8 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]int8, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadInt8()
@@ -213,7 +329,7 @@ func (r *Reader) ReadInt8Slice() []int8 {
 
 // ReadInt16Slice retrieves multiple zigzag-encoded int16s
 func (r *Reader) ReadInt16Slice() []int16 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]int16, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadInt16()
@@ -224,7 +340,7 @@ func (r *Reader) ReadInt16Slice() []int16 {
 
 // ReadInt32Slice decodes a collection of zigzag-encoded int32s
 func (r *Reader) ReadInt32Slice() []int32 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]int32, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadInt32()
@@ -235,7 +351,7 @@ func (r *Reader) ReadInt32Slice() []int32 {
 
 // ReadInt64Slice extracts an array of variable-length int64s
 func (r *Reader) ReadInt64Slice() []int64 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]int64, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadInt64()
@@ -246,7 +362,7 @@ func (r *Reader) ReadInt64Slice() []int64 {
 
 // ReadFloat32Slice decodes multiple float32 values
 func (r *Reader) ReadFloat32Slice() []float32 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]float32, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadFloat32()
@@ -257,7 +373,7 @@ func (r *Reader) ReadFloat32Slice() []float32 {
 
 // ReadFloat64Slice retrieves an array of float64 values
 func (r *Reader) ReadFloat64Slice() []float64 {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]float64, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadFloat64()
@@ -268,7 +384,7 @@ func (r *Reader) ReadFloat64Slice() []float64 {
 
 // ReadBoolSlice decodes an array of boolean values
 func (r *Reader) ReadBoolSlice() []bool {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]bool, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadBool()
@@ -277,9 +393,25 @@ func (r *Reader) ReadBoolSlice() []bool {
 	return s
 }
 
+// ReadBitmap decodes a slice of bools written with the bitmap option, in either of its layouts, see bitmap.go
+func (r *Reader) ReadBitmap() []bool {
+	header := r.ReadVarint()
+	data := r.Read(bitmapSize(header))
+
+	s := make([]bool, header>>1)
+	for i := range s {
+		if header&1 == 1 {
+			s[i] = data[i/8]&(1<<(i%8)) != 0
+		} else {
+			s[i] = data[i] != 0
+		}
+	}
+	return s
+}
+
 // ReadTimeSlice extracts multiple binary-encoded time values
 func (r *Reader) ReadTimeSlice() []time.Time {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([]time.Time, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.ReadTime()
@@ -288,9 +420,20 @@ func (r *Reader) ReadTimeSlice() []time.Time {
 	return s
 }
 
+// ReadDurationSlice decodes multiple time.Duration values
+func (r *Reader) ReadDurationSlice() []time.Duration {
+	length := r.readSliceLength()
+	s := make([]time.Duration, length)
+	for i := uint(0); i < length; i++ {
+		s[i] = r.ReadDuration()
+	}
+
+	return s
+}
+
 // ReadBytesSlice decodes a collection of length-prefixed byte arrays
 func (r *Reader) ReadBytesSlice() [][]byte {
-	length := r.ReadUint()
+	length := r.readSliceLength()
 	s := make([][]byte, length)
 	for i := uint(0); i < length; i++ {
 		s[i] = r.Read(r.ReadVarint())
@@ -306,6 +449,9 @@ func (r *Reader) SkipVarint() {
 	b := r.bytes
 
 loop:
+	if index >= uint(len(b)) {
+		panic(errReadOutOfBounds)
+	}
 	if b[index]&0b10000000 == 0 {
 		r.move(index + 1)
 		return
@@ -338,14 +484,17 @@ func (r *Reader) BytesFromMark() []byte {
 // ReadByte extracts the next byte
 func (r *Reader) ReadByte() byte {
 	p := r.position
+	if p >= uint(len(r.bytes)) {
+		panic(errReadOutOfBounds)
+	}
 	r.advance(1)
 	return r.bytes[p]
 }
 
 // Read extracts the specified number of bytes
 func (r *Reader) Read(l uint) []byte {
-	if r.position+l > uint(len(r.bytes)) {
-		panic("read out of bounds")
+	if l > r.BytesLeft() { // rather than the position plus l, which a hostile length can overflow
+		panic(errReadOutOfBounds)
 	}
 
 	p := r.position
@@ -360,6 +509,9 @@ func (r *Reader) Unread(l uint) {
 
 // Skip moves forward without extracting data
 func (r *Reader) Skip(l uint) {
+	if l > r.BytesLeft() {
+		panic(errReadOutOfBounds)
+	}
 	r.advance(l)
 }
 
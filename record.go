@@ -0,0 +1,122 @@
+package glint
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Many documents can be written to a single file or stream as a sequence of records, each made up of the length
+// of a document followed by the document itself
+//
+//	[length (varint)][document]
+//
+// Records are independent of each other, so a file of them can be appended to at any time and read back one
+// document at a time without holding the whole file in memory.
+
+// DefaultMaxRecordSize is the largest record a RecordReader reads, unless created with NewRecordReaderWithLimit
+const DefaultMaxRecordSize = 64 * 1024 * 1024
+
+// ErrRecordTooLarge is returned when a record is larger than its RecordReader allows
+var ErrRecordTooLarge = errors.New("glint record exceeds size limit")
+
+// RecordWriter writes documents as records to an underlying writer. It doesn't buffer, wrap the writer in a
+// bufio.Writer when writing many small records.
+type RecordWriter struct {
+	w      io.Writer
+	header []byte
+}
+
+// NewRecordWriter creates a RecordWriter that writes records to w
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	return &RecordWriter{w: w, header: make([]byte, 0, binary.MaxVarintLen64)}
+}
+
+// WriteRecord writes a single document as a record
+func (rw *RecordWriter) WriteRecord(doc []byte) error {
+	rw.header = appendVarintb(rw.header[:0], uint64(len(doc)))
+	if _, err := rw.w.Write(rw.header); err != nil {
+		return err
+	}
+	_, err := rw.w.Write(doc)
+	return err
+}
+
+// RecordReader reads records written by a RecordWriter, one at a time, in the manner of a bufio.Scanner
+//
+//	rr := glint.NewRecordReader(f)
+//	for rr.Next() {
+//		err := decoder.Unmarshal(rr.Record(), &v)
+//	}
+//	if err := rr.Err(); err != nil {
+type RecordReader struct {
+	r      *bufio.Reader
+	max    uint
+	record []byte
+	err    error
+}
+
+// NewRecordReader creates a RecordReader that reads records from r, up to DefaultMaxRecordSize in size
+func NewRecordReader(r io.Reader) *RecordReader {
+	return NewRecordReaderWithLimit(r, DefaultMaxRecordSize)
+}
+
+// NewRecordReaderWithLimit creates a RecordReader that reads records from r, up to maxRecordSize in size
+func NewRecordReaderWithLimit(r io.Reader, maxRecordSize uint) *RecordReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &RecordReader{r: br, max: maxRecordSize}
+}
+
+// Next advances to the next record, returning false when there are no more records or an error occurred
+func (rr *RecordReader) Next() bool {
+	if rr.err != nil {
+		return false
+	}
+
+	l, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w: truncated record length", err)
+		}
+		rr.err = err
+		return false
+	}
+
+	if l > uint64(rr.max) {
+		rr.err = fmt.Errorf("%w: %d bytes, limit is %d", ErrRecordTooLarge, l, rr.max)
+		return false
+	}
+
+	if uint64(cap(rr.record)) < l {
+		rr.record = make([]byte, l)
+	}
+	rr.record = rr.record[:l]
+
+	if _, err := io.ReadFull(rr.r, rr.record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		rr.err = fmt.Errorf("%w: truncated record", err)
+		return false
+	}
+
+	return true
+}
+
+// Record returns the current record's document. The bytes are only valid until the next call to Next.
+func (rr *RecordReader) Record() []byte {
+	return rr.record
+}
+
+// Err returns the first error encountered by Next, other than reaching the end of the records
+func (rr *RecordReader) Err() error {
+	if rr.err == io.EOF {
+		return nil
+	}
+	return rr.err
+}
@@ -0,0 +1,410 @@
+package glint
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"time"
+)
+
+// ErrNotInPlace is returned by Rewriter.RewriteInPlace for rewrites that change the size or schema of a document
+var ErrNotInPlace = errors.New("rewrite can't be made in place")
+
+// Rewriter replaces the values of fields in documents, and strips fields out of them, without the types they were
+// written from, e.g. to redact emails or drop internal fields before documents leave a service:
+//
+//	var rw glint.Rewriter
+//	rw.Replace("users[*].email", func(path string, value any) (any, error) { return "redacted", nil })
+//	rw.Remove("internal")
+//	out, err := rw.Rewrite(doc)
+//
+// Fields are chosen with selectors as WalkSelect takes them. The zero value is ready to use, and a Rewriter that's
+// been set up is safe for concurrent use.
+type Rewriter struct {
+	rules []rewriteRule
+}
+
+// rewriteRule is a selector along with what to do with the fields it matches
+type rewriteRule struct {
+	selector []selectorStep
+	replace  func(path string, value any) (any, error) // nil to remove the fields
+}
+
+// Replace passes the value of each field the selector matches, and of everything within them, to fn along with
+// its path, and writes the value fn returns in its place. Values are passed as a TypedVisitor is passed them, and
+// replacements must be of the same type and fit the field: an int64 for any signed integer, within the range of
+// an int8 for an int8 field, a float64 for either size of float, and so on. Errors returned by fn are returned by
+// the rewrite as a *PathError.
+//
+// Nil pointers, map keys, the elements of slices written with the delta, sparse or bitmap options and those of
+// slices of any type, see WireDynamic, aren't passed to fn. When several selectors match a field, each fn is passed the value the one before it returned.
+func (rw *Rewriter) Replace(selector string, fn func(path string, value any) (any, error)) error {
+	steps, err := parseSelector(selector)
+	if err != nil {
+		return err
+	}
+	rw.rules = append(rw.rules, rewriteRule{selector: steps, replace: fn})
+	return nil
+}
+
+// Remove strips the fields the selector matches out of documents, schema and all. Every element of a slice, and
+// every value of a map, shares one schema, so selectors can only name fields, with * or [*] standing for any
+// element or value, e.g. users[*].password.
+func (rw *Rewriter) Remove(selector string) error {
+	steps, err := parseSelector(selector)
+	if err != nil {
+		return err
+	}
+	for _, s := range steps {
+		if s.name == "" && !s.any {
+			return fmt.Errorf("invalid selector %q, fields are removed from every element of a slice or map", selector)
+		}
+	}
+	rw.rules = append(rw.rules, rewriteRule{selector: steps})
+	return nil
+}
+
+// Rewrite returns a copy of doc with every replacement and removal made.
+//
+// Documents that only have values replaced keep their header, format version and schema included, and are
+// compressed with the codec they were written with. Removing fields writes the document out again as Merge does,
+// as format version 0 with any shared struct schemas expanded and field constraints left out. Documents written
+// without their schema return ErrSchemaNotFound.
+func (rw *Rewriter) Rewrite(doc []byte) (out []byte, err error) {
+	defer recoverErr(&err)
+
+	p, w, err := rw.rewrite(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Buffer
+	table.strings.values = w.strings
+	var flags byte
+	if table.appendStringTable() {
+		flags = flagStringTable
+	}
+
+	var b Buffer
+	if w.fields != nil {
+		b.Bytes = writeDocument(w.fields, flags, w.b.Bytes, table.Bytes)
+	} else {
+		header := doc[:len(doc)-len(p.written)]
+		b.Bytes = make([]byte, 0, len(header)+len(w.b.Bytes)+len(table.Bytes))
+		b.Bytes = append(append(append(b.Bytes, header...), w.b.Bytes...), table.Bytes...)
+		b.Bytes[0] = p.flags&^(flagStringTable|flagCodecMask) | flags
+	}
+
+	if codec := Codec(p.flags >> 4); codec != CodecNone {
+		compressed, err := compressBodyWith(codec, &b, len(b.Bytes)-len(w.b.Bytes)-len(table.Bytes))
+		if err != nil {
+			return nil, err
+		}
+		if compressed {
+			b.Bytes[0] |= byte(codec) << 4
+		}
+	}
+	return b.Bytes, nil
+}
+
+// RewriteInPlace makes the replacements Rewrite would by overwriting the values in doc. It returns ErrNotInPlace,
+// leaving doc as it was, when a replacement is a different size from the value it replaces, a field would be
+// removed, a replacement adds a string to the string table or the body is compressed.
+func (rw *Rewriter) RewriteInPlace(doc []byte) (err error) {
+	defer recoverErr(&err)
+
+	p, w, err := rw.rewrite(doc)
+	if err != nil {
+		return err
+	}
+	if w.fields != nil || len(w.strings) != w.tableSize || Codec(p.flags>>4) != CodecNone || len(w.b.Bytes) != w.bodySize {
+		return ErrNotInPlace
+	}
+
+	copy(doc[len(doc)-len(p.written):], w.b.Bytes)
+	return nil
+}
+
+// rewrite is a document being rewritten
+type rewrite struct {
+	rules []rewriteRule
+	at    []walkStep // the value being rewritten and those it's within
+
+	b        Buffer       // the rewritten body
+	bodySize int          // the size of the original body
+	fields   []schemaNode // the schema with fields removed, nil when none were
+
+	strings   []string        // the string table, with any strings replacements added
+	dict      map[string]uint // the index of each string in the table, built once a string is replaced
+	tableSize int             // the size of the original string table
+}
+
+// rewrite makes the replacements and removals of rw to the body of doc, and its schema
+func (rw *Rewriter) rewrite(doc []byte) (documentParts, *rewrite, error) {
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil {
+		return p, nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return p, nil, ErrSchemaNotFound
+	}
+
+	w := &rewrite{rules: rw.rules, bodySize: int(p.body.BytesLeft())}
+	if p.body.strings != nil {
+		w.strings = append(w.strings, *p.body.strings...)
+		w.tableSize = len(w.strings)
+	}
+
+	fields := parseSchemaNodes(p.schema)
+	if pruned, removed := w.prune(fields); removed {
+		w.fields = pruned
+	}
+
+	body := p.body
+	w.structFields(fields, &body)
+	if body.BytesLeft() > 0 {
+		return p, nil, fmt.Errorf("%w: body bytes remaining > 0: %v", ErrInvalidDocument, body.BytesLeft())
+	}
+	return p, w, nil
+}
+
+// removes reports whether the field being rewritten is one a Remove selector matches
+func (w *rewrite) removes() bool {
+	if last := w.at[len(w.at)-1]; last.index >= 0 || last.entry {
+		return false // only fields are removed
+	}
+	for _, rule := range w.rules {
+		if rule.replace == nil && len(rule.selector) == len(w.at) && selects(rule.selector, w.at) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune returns fields without those Remove selectors match, reporting whether there were any
+func (w *rewrite) prune(fields []schemaNode) ([]schemaNode, bool) {
+	pruned, removed := make([]schemaNode, 0, len(fields)), false
+	for _, f := range fields {
+		w.at = append(w.at, walkStep{name: f.name, index: -1})
+		if w.removes() {
+			removed = true
+		} else {
+			var r bool
+			f, r = w.pruneNode(f)
+			pruned, removed = append(pruned, f), removed || r
+		}
+		w.at = w.at[:len(w.at)-1]
+	}
+	return pruned, removed
+}
+
+// pruneNode returns t without the fields Remove selectors match within it, reporting whether there were any. The
+// elements of slices and values of maps stand in for every one of them.
+func (w *rewrite) pruneNode(t schemaNode) (schemaNode, bool) {
+	var removed bool
+	switch {
+	case t.elem != nil:
+		w.at = append(w.at, walkStep{index: 0})
+		elem, r := w.pruneNode(*t.elem)
+		t.elem, removed = &elem, r
+		w.at = w.at[:len(w.at)-1]
+
+	case t.value != nil:
+		w.at = append(w.at, walkStep{index: -1, entry: true})
+		value, r := w.pruneNode(*t.value)
+		t.value, removed = &value, r
+		w.at = w.at[:len(w.at)-1]
+
+	case len(t.fields) > 0:
+		t.fields, removed = w.prune(t.fields)
+	}
+	return t, removed
+}
+
+// structFields copies the values of fields from r to the rewritten body, leaving out the fields that are removed
+func (w *rewrite) structFields(fields []schemaNode, r *Reader) {
+	for i := range fields {
+		w.at = append(w.at, walkStep{name: fields[i].name, index: -1})
+		if w.removes() {
+			skipValue(fields[i].wire, &fields[i], r)
+		} else {
+			w.value(fields[i].wire, &fields[i], r)
+		}
+		w.at = w.at[:len(w.at)-1]
+	}
+}
+
+// value copies a value of the supplied wire type, described by t, from r to the rewritten body, making the
+// replacements and removals within it
+func (w *rewrite) value(wire WireType, t *schemaNode, r *Reader) {
+	switch {
+	case wire&WirePtrFlag > 0:
+		present := r.ReadByte()
+		w.b.AppendUint8(present)
+		if present != 0 {
+			w.value(wire&^WirePtrFlag, t, r)
+		}
+
+	case wire&WireSliceFlag > 0 && !isEncodedSlice(wire):
+		elem := t.elem.wire | wire&WireDictFlag // dictionary slices mark their elements in the slice's wire type
+		l := r.ReadVarint()
+		checkWalkLength(l, *r, elem != WireStruct)
+		w.b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			w.at = append(w.at, walkStep{index: int(i)})
+			w.value(elem, t.elem, r)
+			w.at = w.at[:len(w.at)-1]
+		}
+
+	case wire == WireStruct:
+		w.structFields(t.fields, r)
+
+	case wire == WireMap:
+		l := readMapLength(r, DefaultLimits.MaxMapEntries)
+		w.b.AppendUint(l)
+		for i := uint(0); i < l; i++ {
+			w.at = append(w.at, walkStep{index: -1, entry: true, keyWire: t.key.wire, key: *r})
+			w.copy(t.key.wire, t.key, r)
+			w.value(t.value.wire, t.value, r)
+			w.at = w.at[:len(w.at)-1]
+		}
+
+	case isEncodedSlice(wire), wire == WireDynamic:
+		w.copy(wire, t, r)
+
+	default:
+		w.scalar(wire, t, r)
+	}
+}
+
+// copy copies a value of the supplied wire type, described by t, from r to the rewritten body as it is
+func (w *rewrite) copy(wire WireType, t *schemaNode, r *Reader) {
+	value := r.Remaining()
+	skipValue(wire, t, r)
+	w.b.Bytes = append(w.b.Bytes, value[:len(value)-int(r.BytesLeft())]...)
+}
+
+// scalar copies a value that isn't a struct, slice or map from r to the rewritten body, passing it through the
+// function of each Replace selector that matches it
+func (w *rewrite) scalar(wire WireType, t *schemaNode, r *Reader) {
+	var value any
+	var path string
+	for _, rule := range w.rules {
+		if rule.replace == nil || len(rule.selector) > len(w.at) || !selects(rule.selector, w.at) {
+			continue
+		}
+		if value == nil {
+			value, path = readVisitValue(wire, r), formatPath(w.at)
+		}
+
+		replaced, err := rule.replace(path, value)
+		if err == nil && reflect.TypeOf(replaced) != reflect.TypeOf(value) {
+			err = fmt.Errorf("replacement %T for a %T value", replaced, value)
+		}
+		if err != nil {
+			panic(&PathError{Path: path, Err: err})
+		}
+		value = replaced
+	}
+
+	if value == nil {
+		w.copy(wire, t, r)
+		return
+	}
+	if err := w.append(wire, value); err != nil {
+		panic(&PathError{Path: path, Err: err})
+	}
+}
+
+// append writes a replacement value as a field of the supplied wire type, of a type readVisitValue reads it as
+func (w *rewrite) append(wire WireType, value any) error {
+	b := &w.b
+
+	switch v := value.(type) {
+	case bool:
+		b.AppendBool(v)
+
+	case int64:
+		if bits := numericWires[wire&^WireZigzagFlag].bits; bits < 64 && (v < -1<<(bits-1) || v >= 1<<(bits-1)) {
+			return fmt.Errorf("replacement %d overflows %v", v, wire)
+		}
+		switch wire {
+		case WireInt:
+			b.AppendInt(int(v))
+		case WireInt8:
+			b.AppendInt8(int8(v))
+		case WireInt16:
+			b.AppendInt16(int16(v))
+		case WireInt32:
+			b.AppendInt32(int32(v))
+		case WireInt64:
+			b.AppendInt64(v)
+		case WireInt64 | WireZigzagFlag:
+			b.AppendZigzagInt64(v)
+		}
+
+	case uint64:
+		if bits := numericWires[wire].bits; bits < 64 && v >= 1<<bits {
+			return fmt.Errorf("replacement %d overflows %v", v, wire)
+		}
+		switch wire {
+		case WireUint:
+			b.AppendUint(uint(v))
+		case WireUint8:
+			b.AppendUint8(uint8(v))
+		case WireUint16:
+			b.AppendUint16(uint16(v))
+		case WireUint32:
+			b.AppendUint32(uint32(v))
+		case WireUint64:
+			b.AppendUint64(v)
+		}
+
+	case float64:
+		if wire == WireFloat32 {
+			b.AppendFloat32(float32(v))
+		} else {
+			b.AppendFloat64(v)
+		}
+
+	case string:
+		if wire&WireDictFlag == 0 {
+			b.AppendString(v)
+			break
+		}
+		if w.dict == nil {
+			w.dict = make(map[string]uint, len(w.strings))
+			for i, s := range w.strings {
+				w.dict[s] = uint(i)
+			}
+		}
+		i, ok := w.dict[v]
+		if !ok {
+			i = uint(len(w.strings))
+			w.dict[v], w.strings = i, append(w.strings, v)
+		}
+		b.AppendUint(i)
+
+	case []byte:
+		b.AppendBytes(v)
+	case time.Time:
+		b.AppendTime(v)
+	case time.Duration:
+		if wire&WireZigzagFlag > 0 {
+			b.AppendZigzagInt64(int64(v))
+		} else {
+			b.AppendDuration(v)
+		}
+	case netip.Addr:
+		b.AppendAddr(v)
+	case netip.Prefix:
+		b.AppendPrefix(v)
+	case *big.Int:
+		b.AppendBigInt(v)
+	case Decimal:
+		b.AppendDecimal(v)
+	}
+	return nil
+}
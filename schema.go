@@ -0,0 +1,224 @@
+package glint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// Schema is a typed model of a document's schema, for tools that work with documents of any type, such as
+// validators, viewers and code generators. See ParseSchema.
+type Schema struct {
+	Hash   uint32        // the schema hash from the document's header
+	Fields []SchemaField // the top level fields, in the order their values are written
+}
+
+// SchemaField describes a field of a schema, or the elements of a slice or the keys and values of a map, which
+// have no name. Wire is the wire type as written, with its flags, so a slice of int64s written with delta encoding
+// has a Wire of WireSliceFlag|WireDeltaFlag|WireInt64, and WirePtrFlag as well when nil slices are kept apart from
+// empty ones. Slices of slices have a Wire of WireSliceFlag alone, with the inner slice as their Elem.
+type SchemaField struct {
+	Name string
+	Wire WireType
+
+	Fields     []SchemaField // the fields of structs, and of slices of structs or pointers to them
+	Elem       *SchemaField  // the elements of slices
+	Key, Value *SchemaField  // the keys and values of maps
+
+	Min, Max, Pattern string // constraints written by the encoder, see Field Constraints, empty when absent
+}
+
+// ParseSchema reads the schema of doc into a Schema, with any shared struct schemas expanded. The schema is
+// checked against DefaultLimits before it's read. Documents written without their schema, as in trusted schema mode
+// or with a schema registry, return ErrSchemaNotFound, see ParseSchemaBytes for schemas held apart from documents.
+func ParseSchema(doc []byte) (schema *Schema, err error) {
+	defer recoverErr(&err)
+
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		if Document(doc).Hash() == crc32.ChecksumIEEE([]byte{0}) { // a type without fields, not a missing schema
+			return &Schema{Hash: Document(doc).Hash()}, nil
+		}
+		return nil, ErrSchemaNotFound
+	}
+
+	// the schema's been checked, but expanded without its constraints, which are wanted here
+	split, _ := splitDocument(doc)
+	fields, err := expandSchema(split.schema.Remaining(), DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{Hash: Document(doc).Hash(), Fields: schemaFields(NewReader(fields))}, nil
+}
+
+// ParseSchemaBytes is ParseSchema for a schema held apart from any document, formatted as returned by
+// Encoder.Schema and held by a SchemaRegistry
+func ParseSchemaBytes(schema []byte) (*Schema, error) {
+	doc := make([]byte, 5, 5+len(schema))
+	doc[0] = formatVersionConstraint // the newest layout without name hashes, which Encoder.Schema leaves out
+	binary.LittleEndian.PutUint32(doc[1:], crc32.ChecksumIEEE(schema))
+	return ParseSchema(append(doc, schema...))
+}
+
+// schemaFields reads a list of schema fields
+func schemaFields(r Reader) []SchemaField {
+	var fields []SchemaField
+
+	for r.BytesLeft() > 0 {
+		wire := WireType(r.ReadVarint())
+		f := SchemaField{Name: string(r.Read(uint(r.ReadByte())))}
+
+		if wire&WireConstraintFlag > 0 {
+			var c constraint
+			c.read(NewReader(r.Read(r.ReadVarint())))
+			f.Min, f.Max = c.min, c.max
+			if c.pattern != nil {
+				f.Pattern = c.pattern.String()
+			}
+			wire ^= WireConstraintFlag
+		}
+
+		f.read(wire, &r)
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// read reads any sub-schema belonging to wire into f, following the same layout as parseSchemaNode
+func (f *SchemaField) read(wire WireType, r *Reader) {
+	f.Wire = wire
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+
+	switch {
+	case base == WireSliceFlag:
+		f.Elem = &SchemaField{}
+		f.Elem.read(WireType(r.ReadVarint()), r)
+		if f.Elem.Wire == WirePtrFlag|WireStruct {
+			f.Fields = f.Elem.Fields // slices of pointers to structs, see formatVersionPointers
+		}
+
+	case base&WireSliceFlag > 0:
+		f.Elem = &SchemaField{}
+		f.Elem.read(wire&(WireTypeMask|WireZigzagFlag), r)
+		f.Fields = f.Elem.Fields
+
+	case base == WireStruct:
+		f.Fields = schemaFields(NewReader(r.Read(r.ReadVarint())))
+
+	case base == WireMap:
+		keyWire, valueWire := WireType(r.ReadVarint()), WireType(r.ReadVarint())
+		f.Key, f.Value = &SchemaField{}, &SchemaField{}
+		f.Key.read(keyWire, r)
+		f.Value.read(valueWire, r)
+
+	case base < WireBool || base > WireDynamic:
+		panic(fmt.Sprintf("unknown wire type %v", wire))
+	}
+}
+
+// Field returns the top level field called name, or nil when the schema doesn't have one
+func (s *Schema) Field(name string) *SchemaField {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// String returns the schema in a stable text form, one field to a line, with its type written like a Go type and
+// its encoding and constraints following it as they'd be written in a struct tag. Nested structs are indented by a
+// tab. Schemas with the same fields, written the same way, have the same string.
+//
+//	id int64
+//	tags []string dict
+//	address struct {
+//		city string
+//	}
+func (s *Schema) String() string {
+	var b strings.Builder
+	writeSchemaFields(&b, s.Fields, 0)
+	return b.String()
+}
+
+// writeSchemaFields writes fields to b one to a line, indented by depth tabs
+func writeSchemaFields(b *strings.Builder, fields []SchemaField, depth int) {
+	for i := range fields {
+		b.WriteString(strings.Repeat("\t", depth))
+		b.WriteString(fields[i].Name)
+		b.WriteByte(' ')
+		b.WriteString(fields[i].typeString(depth))
+		if opts := fields[i].Options(); len(opts) > 0 {
+			b.WriteByte(' ')
+			b.WriteString(strings.Join(opts, ","))
+		}
+		b.WriteByte('\n')
+	}
+}
+
+// TypeString returns the field's type written like a Go type, e.g. []*int32 or map[string]time, with the fields of
+// structs on lines of their own
+func (f *SchemaField) TypeString() string {
+	return f.typeString(0)
+}
+
+// typeString is TypeString for a field indented by depth tabs
+func (f *SchemaField) typeString(depth int) string {
+	wire := f.Wire
+	switch {
+	case wire&WireSliceFlag > 0: // WirePtrFlag marks slices written with a presence byte, which are still slices
+		return "[]" + f.Elem.typeString(depth)
+	case wire&WirePtrFlag > 0:
+		return "*" + (&SchemaField{Wire: wire &^ WirePtrFlag, Fields: f.Fields, Key: f.Key, Value: f.Value}).typeString(depth)
+	}
+
+	switch wire & WireTypeMask {
+	case WireStruct:
+		var b strings.Builder
+		b.WriteString("struct {\n")
+		writeSchemaFields(&b, f.Fields, depth+1)
+		b.WriteString(strings.Repeat("\t", depth) + "}")
+		return b.String()
+	case WireMap:
+		return "map[" + f.Key.typeString(depth) + "]" + f.Value.typeString(depth)
+	}
+	return wireTypeNames[wire&WireTypeMask]
+}
+
+// Options returns the encodings and constraints of the field, as they'd be written in a struct tag, e.g. delta or
+// min=0
+func (f *SchemaField) Options() []string {
+	var opts []string
+	for _, o := range []struct {
+		flag WireType
+		name string
+	}{{WireDeltaFlag, "delta"}, {WireSparseFlag, "sparse"}, {WireDictFlag, "dict"}, {WireBitmapFlag, "bitmap"}, {WireZigzagFlag, "zigzag"}} {
+		if f.Wire&o.flag > 0 {
+			opts = append(opts, o.name)
+		}
+	}
+	if f.Min != "" {
+		opts = append(opts, "min="+f.Min)
+	}
+	if f.Max != "" {
+		opts = append(opts, "max="+f.Max)
+	}
+	if f.Pattern != "" {
+		opts = append(opts, "pattern="+f.Pattern)
+	}
+	return opts
+}
+
+// wireTypeNames are the names the base wire types are written with in a Schema's string form
+var wireTypeNames = map[WireType]string{
+	WireBool: "bool", WireInt: "int", WireInt8: "int8", WireInt16: "int16", WireInt32: "int32", WireInt64: "int64",
+	WireUint: "uint", WireUint8: "uint8", WireUint16: "uint16", WireUint32: "uint32", WireUint64: "uint64",
+	WireFloat32: "float32", WireFloat64: "float64", WireString: "string", WireBytes: "bytes", WireTime: "time",
+	WireDuration: "duration", WireIP: "ip", WireIPPrefix: "ipprefix", WireBigInt: "bigint", WireDecimal: "decimal",
+	WireDynamic: "any",
+}
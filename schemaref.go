@@ -0,0 +1,164 @@
+package glint
+
+import (
+	"fmt"
+)
+
+// Struct schemas that appear more than once in a document, e.g. an Address under both a User and a Company, are
+// written in full the first time only. Every later use sets WireSchemaRefFlag on the wire type that precedes the
+// struct schema, and writes the index of the original in place of it
+//
+//	[wire | WireSchemaRefFlag][index (varint)]
+//
+// Struct schemas are indexed from 0 in the order they are written in full, depth first, with a struct numbered
+// before any nested within it. Documents containing references are written as format version 1. Parsers expand
+// the references again before reading the schema, see documentParts.inlineSchema.
+
+// minSharedSchema is the smallest struct schema worth replacing with a reference, smaller ones are cheaper inline
+const minSharedSchema = 4
+
+// schemaRefs rewrites struct schemas to and from their shared form
+type schemaRefs struct {
+	share bool            // replace repeated struct schemas with references when true, expand references when false
+	defs  [][]byte        // struct schemas written in full, as [length][fields], in the order they were written
+	index map[string]uint // sharing only, the index of each struct schema written so far by its fields
+	found bool            // sharing only, set once a reference has been written
+
+	limits   DecodeLimits // expanding only, bounds the nesting and size of the schema references expand into
+	depth    uint         // nesting of the node being rewritten
+	expanded uint         // expanding only, bytes of struct schema copied in place of references so far
+
+	keepConstraints bool // expanding only, copy field constraints through rather than dropping them
+	constrained     bool // set once a field with constraints has been read
+	pointers        bool // set once a slice of pointers has been read, see formatVersionPointers
+}
+
+// shareSchemas replaces repeated struct schemas in a list of schema fields with references to their first use.
+// ok is false when nothing was repeated, in which case the schema should be left as it was.
+func shareSchemas(fields []byte) (shared []byte, ok bool) {
+	s := schemaRefs{share: true, index: map[string]uint{}}
+	shared = s.fields(NewReader(fields), nil)
+	return shared, s.found
+}
+
+// inlineSchemaRefs expands every reference in a list of schema fields back into the struct schema it refers to.
+// Expanding is bounded by the MaxSchemaDepth and MaxSchemaSize limits, as references to references can otherwise
+// grow a small schema exponentially.
+func inlineSchemaRefs(fields []byte, limits DecodeLimits) (inlined []byte, err error) {
+
+	defer recoverErr(&err)
+
+	s := schemaRefs{limits: limits}
+	return s.fields(NewReader(fields), nil), nil
+}
+
+// expandSchema is like inlineSchemaRefs but keeps any field constraints in the schema, see constraint.go
+func expandSchema(fields []byte, limits DecodeLimits) (expanded []byte, err error) {
+
+	defer recoverErr(&err)
+
+	s := schemaRefs{limits: limits, keepConstraints: true}
+	return s.fields(NewReader(fields), nil), nil
+}
+
+// fields rewrites every named entry in r onto out
+func (s *schemaRefs) fields(r Reader, out []byte) []byte {
+	for r.BytesLeft() > 0 {
+		wire := WireType(r.ReadVarint())
+		name := r.Read(uint(r.ReadByte()))
+
+		var constraints []byte
+		if wire&WireConstraintFlag > 0 {
+			s.constrained = true
+			constraints = r.Read(r.ReadVarint())
+			wire ^= WireConstraintFlag
+		}
+
+		wire, sub := s.node(wire, &r)
+
+		keep := constraints != nil && (s.share || s.keepConstraints)
+		if keep {
+			wire |= WireConstraintFlag
+		}
+
+		out = appendVarintb(out, uint64(wire))
+		out = append(out, byte(len(name)))
+		out = append(out, name...)
+		if keep {
+			out = appendVarintb(out, uint64(len(constraints)))
+			out = append(out, constraints...)
+		}
+		out = append(out, sub...)
+	}
+	return out
+}
+
+// node rewrites any sub-schema belonging to wire, following the same layout as parseSchemaNode. It returns the
+// wire type to write in place of the original, which gains or loses WireSchemaRefFlag, along with the sub-schema.
+func (s *schemaRefs) node(wire WireType, r *Reader) (WireType, []byte) {
+
+	s.depth++
+	defer func() { s.depth-- }()
+	checkSchemaDepth(s.depth, s.limits)
+
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireSchemaRefFlag)
+
+	switch {
+	case base == WireSliceFlag: // slice of slices or of pointers, the element type follows
+		elem, sub := s.node(WireType(r.ReadVarint()), r)
+		s.pointers = s.pointers || elem&WirePtrFlag > 0
+		return wire, append(appendVarintb(nil, uint64(elem)), sub...)
+
+	case base&WireSliceFlag > 0: // the slice's wire type stands in for its element's
+		elem, sub := s.node(base&WireTypeMask|wire&WireSchemaRefFlag, r)
+		return wire&^WireSchemaRefFlag | elem&WireSchemaRefFlag, sub
+
+	case base == WireStruct:
+		return s.structSchema(wire, r)
+
+	case base == WireMap:
+		key, value := WireType(r.ReadVarint()), WireType(r.ReadVarint())
+		key, keySub := s.node(key, r)
+		value, valueSub := s.node(value, r)
+
+		sub := appendVarintb(nil, uint64(key))
+		sub = appendVarintb(sub, uint64(value))
+		sub = append(sub, keySub...)
+		return wire, append(sub, valueSub...)
+	}
+
+	return wire, nil
+}
+
+// structSchema rewrites a single struct schema, or the reference standing in for one
+func (s *schemaRefs) structSchema(wire WireType, r *Reader) (WireType, []byte) {
+
+	if wire&WireSchemaRefFlag > 0 {
+		i := r.ReadVarint()
+		if i >= uint(len(s.defs)) || s.defs[i] == nil { // references may only point back to complete schemas
+			panic(fmt.Sprintf("reference to undefined struct schema %d", i))
+		}
+		s.expanded += uint(len(s.defs[i]))
+		checkLimit(s.expanded, s.limits.MaxSchemaSize, "expanded schema")
+		return wire &^ WireSchemaRefFlag, s.defs[i]
+	}
+
+	fields := r.Read(r.ReadVarint())
+
+	if s.share && len(fields) >= minSharedSchema {
+		if i, ok := s.index[string(fields)]; ok {
+			s.found = true
+			return wire | WireSchemaRefFlag, appendVarintb(nil, uint64(i))
+		}
+		s.index[string(fields)] = uint(len(s.defs))
+	}
+
+	// numbered before rewriting the fields, so the struct comes before those nested within it
+	i := len(s.defs)
+	s.defs = append(s.defs, nil)
+
+	inner := s.fields(NewReader(fields), nil)
+	s.defs[i] = append(appendVarintb(nil, uint64(len(inner))), inner...)
+
+	return wire, s.defs[i]
+}
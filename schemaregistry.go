@@ -0,0 +1,165 @@
+package glint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+// Documents written by an encoder using a SchemaRegistry carry only the ID of their schema, laid out exactly as
+// trusted schema mode documents are
+//
+//	[flags][schema ID (crc32)][0]
+//
+// The schema ID is the same CRC32 every document carries over its schema, so any decoder that has already seen
+// the schema, through a registry or otherwise, can read them.
+
+// ErrSchemaNotRegistered is returned when a document refers to a schema ID that can't be resolved by the registry
+var ErrSchemaNotRegistered = errors.New("schema ID not found in registry")
+
+// SchemaStore is a backend shared between registries, e.g. a service or database that encoding and decoding
+// processes can both reach. Implementations must be safe for concurrent use.
+type SchemaStore interface {
+	// PutSchema stores a schema against its ID. Storing the same schema more than once must not fail.
+	PutSchema(id uint32, schema []byte) error
+
+	// GetSchema retrieves the schema stored against an ID, returning ErrSchemaNotRegistered when there isn't one
+	GetSchema(id uint32) ([]byte, error)
+}
+
+// SchemaRegistry holds document schemas by their ID, so that documents can be written without their schema and
+// resolved again when they're read. High rates of small documents benefit the most, as the schema can be many
+// times larger than the values it describes.
+//
+// Pass it to encoders with WithSchemaRegistry, and to decoders with NewDecoderWithRegistry. A registry is safe
+// for concurrent use.
+type SchemaRegistry struct {
+	store   SchemaStore
+	mu      sync.RWMutex
+	schemas map[uint32][]byte
+}
+
+// NewSchemaRegistry creates a registry backed by the supplied store. When store is nil, schemas are only known
+// to the current process.
+func NewSchemaRegistry(store SchemaStore) *SchemaRegistry {
+	return &SchemaRegistry{store: store, schemas: map[uint32][]byte{}}
+}
+
+// Register adds a schema to the registry, and its store if it has one, returning its ID. The schema is
+// formatted as returned by Encoder.Schema.
+func (r *SchemaRegistry) Register(schema []byte) (uint32, error) {
+	if err := checkRegistrySchema(schema); err != nil {
+		return 0, err
+	}
+
+	id := crc32.ChecksumIEEE(schema)
+
+	r.mu.RLock()
+	_, ok := r.schemas[id]
+	r.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	schema = append([]byte(nil), schema...) // the registry outlives whatever buffer the caller is holding
+	if r.store != nil {
+		if err := r.store.PutSchema(id, schema); err != nil {
+			return 0, fmt.Errorf("registering schema %d: %w", id, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+
+	return id, nil
+}
+
+// Lookup returns the schema registered against an ID, asking the store when it isn't known locally
+func (r *SchemaRegistry) Lookup(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	schema, ok := r.schemas[id]
+	r.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	if r.store == nil {
+		return nil, fmt.Errorf("%w: %d", ErrSchemaNotRegistered, id)
+	}
+
+	schema, err := r.store.GetSchema(id)
+	if err != nil {
+		return nil, fmt.Errorf("resolving schema %d: %w", id, err)
+	}
+
+	// the store is outside of our control, so make sure it handed back the schema that was asked for
+	if err := checkRegistrySchema(schema); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(schema) != id {
+		return nil, fmt.Errorf("%w: store returned a different schema for ID %d", ErrInvalidDocument, id)
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+
+	return schema, nil
+}
+
+// Expand rewrites a document that carries only its schema ID into a document that carries its full schema, for
+// tools such as the Printer and Walker that read the schema themselves. Documents that already include their
+// schema are returned as they are.
+func (r *SchemaRegistry) Expand(doc []byte) ([]byte, error) {
+	parts, err := splitDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if parts.schema.BytesLeft() > 0 {
+		return doc, nil
+	}
+
+	schema, err := r.Lookup(documentSchemaID(parts))
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := make([]byte, 0, 6+len(schema)+len(parts.written))
+	expanded = append(expanded, doc[:5]...)
+	expanded = append(expanded, schema...)
+	if parts.flags&flagVersionMask >= formatVersionNameHashes {
+		// an empty list of name hashes, which the layout has after the schema and the hash covers, see
+		// formatVersionPointers
+		expanded = append(expanded, 0)
+		binary.LittleEndian.PutUint32(expanded[1:], crc32.ChecksumIEEE(expanded[5:]))
+	}
+	return append(expanded, parts.written...), nil
+}
+
+// resolve finds the schema for a document written without one, as a reader over its fields
+func (r *SchemaRegistry) resolve(parts documentParts) (Reader, error) {
+	schema, err := r.Lookup(documentSchemaID(parts))
+	if err != nil {
+		return Reader{}, err
+	}
+
+	sr := NewReader(schema)
+	return NewReader(sr.Read(sr.ReadVarint())), nil
+}
+
+// documentSchemaID reads the schema ID, i.e. the schema hash, from a document's header
+func documentSchemaID(parts documentParts) uint32 {
+	return binary.LittleEndian.Uint32(parts.hash)
+}
+
+// checkRegistrySchema makes sure a schema is a single, complete, length prefixed list of fields
+func checkRegistrySchema(schema []byte) error {
+	l, n := binary.Uvarint(schema)
+	if n <= 0 || l == 0 || l != uint64(len(schema)-n) {
+		return fmt.Errorf("%w: malformed schema", ErrInvalidDocument)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package glint
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// SchemaSession negotiates schema trust over a long lived connection, generalising NewTrustHeader and
+// HTTPTrustee to any number of schemas, and to connections that aren't HTTP.
+//
+// Each end of a connection keeps a session. The receiving end decodes with UnmarshalWithSession, and sends the
+// hashes returned by Acknowledgements back to its peer. The sending end records them with Acknowledge, after
+// which MarshalWithSession leaves the schema out of documents with those hashes. A changed schema has a new
+// hash, so it's sent in full until that hash is acknowledged in turn. MarshalToWithSession and
+// UnmarshalFromWithSession do the same for documents written to and read from streams.
+//
+// Acknowledgements rely on the receiving end holding on to the instructions it built for a schema, so a schema
+// should be read by a single Decoder per connection. When the receiving end loses them, e.g. after a restart,
+// the sending end should Revoke or Reset. A session is safe for concurrent use.
+type SchemaSession struct {
+	mu       sync.Mutex
+	trusted  map[uint32]struct{} // sending, schemas the peer has acknowledged
+	received map[uint32]struct{} // receiving, schemas decoded from a full document
+	pending  []uint32            // receiving, schemas decoded but not yet handed back by Acknowledgements
+	sampler  atomic.Pointer[statsSampler]
+}
+
+// NewSchemaSession creates a session in which no schemas have been exchanged yet
+func NewSchemaSession() *SchemaSession {
+	return &SchemaSession{trusted: map[uint32]struct{}{}, received: map[uint32]struct{}{}}
+}
+
+// Acknowledge records that the peer holds the schemas with the supplied hashes, so they no longer need sending
+func (s *SchemaSession) Acknowledge(hashes ...uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range hashes {
+		s.trusted[h] = struct{}{}
+	}
+}
+
+// Revoke withdraws earlier acknowledgements, sending the schemas with the supplied hashes in full again
+func (s *SchemaSession) Revoke(hashes ...uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range hashes {
+		delete(s.trusted, h)
+	}
+}
+
+// Reset forgets everything exchanged over the session, as when the connection is re-established
+func (s *SchemaSession) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trusted = map[uint32]struct{}{}
+	s.received = map[uint32]struct{}{}
+	s.pending = nil
+}
+
+// Trusts reports whether the peer has acknowledged the schema with the supplied hash
+func (s *SchemaSession) Trusts(hash uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.trusted[hash]
+	return ok
+}
+
+// Acknowledgements returns the hashes of schemas received since the last call, for sending to the peer
+func (s *SchemaSession) Acknowledgements() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	s.pending = nil
+	return pending
+}
+
+// TrustHeader returns the pending acknowledgements as an X-Glint-Trust header, comma separated. ok is false when
+// there is nothing to acknowledge.
+func (s *SchemaSession) TrustHeader() (header TrustHeader, ok bool) {
+	pending := s.Acknowledgements()
+	if len(pending) == 0 {
+		return TrustHeader{}, false
+	}
+
+	values := make([]string, len(pending))
+	for i, h := range pending {
+		values[i] = strconv.FormatUint(uint64(h), 10)
+	}
+	return TrustHeader{"X-Glint-Trust", strings.Join(values, ",")}, true
+}
+
+// ReceivedHeader returns every schema received over the session as an X-Glint-Trust header, rather than only
+// those not yet acknowledged. It suits peers that keep no state between messages, such as HTTP servers, which
+// need telling which schemas are held on every request. ok is false when nothing has been received.
+func (s *SchemaSession) ReceivedHeader() (header TrustHeader, ok bool) {
+	s.mu.Lock()
+	values := make([]string, 0, len(s.received))
+	for h := range s.received {
+		values = append(values, strconv.FormatUint(uint64(h), 10))
+	}
+	s.mu.Unlock()
+
+	if len(values) == 0 {
+		return TrustHeader{}, false
+	}
+	return TrustHeader{"X-Glint-Trust", strings.Join(values, ",")}, true
+}
+
+// AcknowledgeHeader records the acknowledgements carried by an X-Glint-Trust header, as written by TrustHeader
+// or NewTrustHeader. Values that aren't hashes are ignored.
+func (s *SchemaSession) AcknowledgeHeader(h http.Header) {
+	for _, value := range h.Values("X-Glint-Trust") {
+		for _, v := range strings.Split(value, ",") {
+			if hash, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32); err == nil {
+				s.Acknowledge(uint32(hash))
+			}
+		}
+	}
+}
+
+// CollectFieldStats has stats observe every nth document encoded with MarshalWithSession or MarshalToWithSession,
+// or decoded with UnmarshalWithSession, or every document when every is 1 or less. Documents encoded with
+// MarshalToWithSession are only observed once the peer trusts their schema. A nil stats stops collecting.
+func (s *SchemaSession) CollectFieldStats(stats *FieldStats, every int) {
+	if stats == nil {
+		s.sampler.Store(nil)
+		return
+	}
+	if every < 1 {
+		every = 1
+	}
+	s.sampler.Store(&statsSampler{stats: stats, every: uint64(every)})
+}
+
+// receive records a successfully decoded document, queuing its schema for acknowledgement the first time it's
+// seen in full
+func (s *SchemaSession) receive(doc []byte) {
+	if len(doc) < 6 || doc[5] == 0 { // no schema, the peer already trusts us with it
+		return
+	}
+
+	hash := binary.LittleEndian.Uint32(doc[1:5])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.received[hash]; ok {
+		return
+	}
+	s.received[hash] = struct{}{}
+	s.pending = append(s.pending, hash)
+}
+
+// MarshalWithSession encodes a value like Marshal, leaving the schema out when the session's peer has
+// acknowledged it
+func (e *Encoder[T]) MarshalWithSession(v *T, b *Buffer, s *SchemaSession) {
+	trusted := b.TrustedSchema
+	defer func() { b.TrustedSchema = trusted }()
+
+	start := len(b.Bytes)
+	if e.impl.config.metrics != nil {
+		defer e.impl.encoded(b, start, time.Now())
+	}
+
+	// canonical and registry encoders decide for themselves what to write in place of the schema
+	b.TrustedSchema = !e.impl.config.canonical && !e.impl.idOnly && s.Trusts(binary.LittleEndian.Uint32(e.impl.header.Bytes[1:5]))
+	if b.TrustedSchema {
+		// written here rather than by Marshal, which only writes the header into empty buffers
+		b.Bytes = append(b.Bytes, e.impl.header.Bytes...)
+	}
+	e.impl.marshalDocument(unsafe.Pointer(v), b, start)
+
+	if c := s.sampler.Load(); c != nil && c.sample() {
+		c.stats.learn(e.impl.schema.Bytes)
+		_ = c.stats.Observe(b.Bytes[start:])
+	}
+}
+
+// MarshalToWithSession encodes a value straight to w like MarshalTo, leaving the schema out when the session's
+// peer has acknowledged it
+func (e *Encoder[T]) MarshalToWithSession(w io.Writer, v *T, s *SchemaSession) (int64, error) {
+	if e.impl.config.canonical || e.impl.idOnly || !s.Trusts(binary.LittleEndian.Uint32(e.impl.header.Bytes[1:5])) {
+		return e.MarshalTo(w, v)
+	}
+
+	var start time.Time
+	if e.impl.config.metrics != nil {
+		start = time.Now()
+	}
+	b := e.impl.pooledBuffer()
+	defer b.ReturnToPool()
+	head := e.impl.header.Bytes
+	e.impl.marshalBody(unsafe.Pointer(v), b)
+	if flags := e.impl.finishBody(b, 0); flags != 0 {
+		head = append([]byte{head[0] | flags}, head[1:]...)
+	}
+	if e.impl.config.metrics != nil {
+		e.impl.config.metrics.Encoded(e.impl.name, len(head)+len(b.Bytes), time.Since(start))
+	}
+
+	if c := s.sampler.Load(); c != nil && c.sample() {
+		c.stats.learn(e.impl.schema.Bytes)
+		_ = c.stats.Observe(append(append([]byte{}, head...), b.Bytes...))
+	}
+
+	segments := net.Buffers{head, b.Bytes}
+	return segments.WriteTo(w)
+}
+
+// UnmarshalWithSession decodes a document like Unmarshal, queuing its schema for acknowledgement to the
+// session's peer
+func (d *Decoder[T]) UnmarshalWithSession(bytes []byte, v *T, s *SchemaSession) error {
+	if err := d.impl.Unmarshal(bytes, v); err != nil {
+		return err
+	}
+	s.receive(bytes)
+
+	if c := s.sampler.Load(); c != nil {
+		if c.sample() {
+			_ = c.stats.Observe(bytes)
+		} else {
+			c.stats.learn(bytes) // trusted documents that are sampled later need the schema
+		}
+	}
+	return nil
+}
+
+// UnmarshalFromWithSession reads a single document from r like UnmarshalFrom, queuing its schema for
+// acknowledgement to the session's peer
+func (d *Decoder[T]) UnmarshalFromWithSession(r io.Reader, v *T, s *SchemaSession) error {
+	doc, err := readDocument(r, d.impl.limits)
+	if err != nil {
+		return err
+	}
+	return d.UnmarshalWithSession(doc, v, s)
+}
@@ -0,0 +1,191 @@
+package glint
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// ShadowPrimary picks which format a ShadowCodec serves
+type ShadowPrimary int
+
+const (
+	PrimaryJSON  ShadowPrimary = iota // serve encoding/json, shadow with glint, while migrating to glint
+	PrimaryGlint                      // serve glint, shadow with encoding/json, while confirming the migration
+)
+
+// ShadowDivergence describes a value that came out of the two codecs differently
+type ShadowDivergence[T any] struct {
+	Op     string   // "marshal" or "unmarshal"
+	Fields []string // top level fields that differ, by their Go names, when T is a struct
+	Glint  *T       // the value as glint sees it, nil when glint failed
+	JSON   *T       // the value as encoding/json sees it, nil when encoding/json failed
+	Err    error    // the error from whichever codec failed, when one did rather than returning a different value
+}
+
+// ShadowCodec serves values of T in one format while checking that the other agrees, to de-risk moving a service
+// from encoding/json to glint. Every value is passed through both codecs and the results compared, divergences
+// are passed to a callback, and callers only ever see the primary's bytes, values and errors.
+//
+// Marshal compares the value read back from the primary's bytes with the value read back from the shadow's.
+// Unmarshal compares the value the primary decoded with that value written and read back by the shadow. Times
+// are compared with time.Time.Equal and NaNs are equal to each other, since neither says anything about the
+// codecs. Fields tagged for one codec but not the other show up as divergences, which is usually the point.
+//
+// Shadowing costs several times more than the primary alone, so it's typically only used on a sample of calls.
+// A ShadowCodec is safe for concurrent use when the callback is.
+type ShadowCodec[T any] struct {
+	enc          *Encoder[T]
+	dec          *Decoder[T]
+	primary      ShadowPrimary
+	onDivergence func(ShadowDivergence[T])
+}
+
+// NewShadowCodec builds a ShadowCodec serving primary, calling onDivergence each time the codecs disagree
+func NewShadowCodec[T any](primary ShadowPrimary, onDivergence func(ShadowDivergence[T])) *ShadowCodec[T] {
+	return &ShadowCodec[T]{enc: NewEncoder[T](), dec: NewDecoder[T](), primary: primary, onDivergence: onDivergence}
+}
+
+// Marshal encodes v with the primary codec, shadowing it with the other
+func (s *ShadowCodec[T]) Marshal(v *T) ([]byte, error) {
+	data, err := s.marshal(s.primary, v)
+	if err != nil {
+		return nil, err
+	}
+
+	served := new(T)
+	if err := s.unmarshal(s.primary, data, served); err != nil { // the primary can't read its own bytes back
+		s.report("marshal", nil, nil, nil, err)
+		return data, nil
+	}
+
+	s.compare("marshal", served, v)
+	return data, nil
+}
+
+// Unmarshal decodes data with the primary codec into v, shadowing the result with the other
+func (s *ShadowCodec[T]) Unmarshal(data []byte, v *T) error {
+	if err := s.unmarshal(s.primary, data, v); err != nil {
+		return err
+	}
+
+	s.compare("unmarshal", v, v)
+	return nil
+}
+
+// compare writes and reads source back with the shadow codec, reporting any difference from served
+func (s *ShadowCodec[T]) compare(op string, served, source *T) {
+	other := PrimaryGlint
+	if s.primary == PrimaryGlint {
+		other = PrimaryJSON
+	}
+
+	shadowed := new(T)
+	data, err := s.marshal(other, source)
+	if err == nil {
+		err = s.unmarshal(other, data, shadowed)
+	}
+	if err != nil {
+		s.report(op, served, nil, nil, err)
+		return
+	}
+
+	if fields, equal := shadowDiff(reflect.ValueOf(served).Elem(), reflect.ValueOf(shadowed).Elem()); !equal {
+		s.report(op, served, shadowed, fields, nil)
+	}
+}
+
+// report passes a divergence to the callback, given the values from the primary and shadow codecs
+func (s *ShadowCodec[T]) report(op string, served, shadowed *T, fields []string, err error) {
+	d := ShadowDivergence[T]{Op: op, Fields: fields, Glint: served, JSON: shadowed, Err: err}
+	if s.primary == PrimaryJSON {
+		d.Glint, d.JSON = shadowed, served
+	}
+	s.onDivergence(d)
+}
+
+func (s *ShadowCodec[T]) marshal(format ShadowPrimary, v *T) ([]byte, error) {
+	if format == PrimaryJSON {
+		return json.Marshal(v)
+	}
+
+	b := &Buffer{}
+	s.enc.Marshal(v, b)
+	return b.Bytes, nil
+}
+
+func (s *ShadowCodec[T]) unmarshal(format ShadowPrimary, data []byte, v *T) error {
+	if format == PrimaryJSON {
+		return json.Unmarshal(data, v)
+	}
+	return s.dec.Unmarshal(data, v)
+}
+
+// shadowDiff compares two values, returning the names of the top level fields that differ when they're structs
+func shadowDiff(a, b reflect.Value) ([]string, bool) {
+	if a.Kind() != reflect.Struct || a.Type() == timeType {
+		return nil, shadowEqual(a, b)
+	}
+
+	var fields []string
+	for i := 0; i < a.NumField(); i++ {
+		if !shadowEqual(a.Field(i), b.Field(i)) {
+			fields = append(fields, a.Type().Field(i).Name)
+		}
+	}
+	return fields, len(fields) == 0
+}
+
+// shadowEqual is reflect.DeepEqual, except that times are compared by the instant they describe and NaNs are
+// equal to each other
+func shadowEqual(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Struct:
+		if a.Type() == timeType && a.CanAddr() && b.CanAddr() {
+			return (*time.Time)(unsafe.Pointer(a.UnsafeAddr())).Equal(*(*time.Time)(unsafe.Pointer(b.UnsafeAddr())))
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if !shadowEqual(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() || (a.Kind() == reflect.Slice && a.IsNil() != b.IsNil()) {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !shadowEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Map:
+		if a.Len() != b.Len() || a.IsNil() != b.IsNil() {
+			return false
+		}
+		for it := a.MapRange(); it.Next(); {
+			v := b.MapIndex(it.Key())
+			if !v.IsValid() || !shadowEqual(it.Value(), v) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Pointer, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return shadowEqual(a.Elem(), b.Elem())
+
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		return x == y || (math.IsNaN(x) && math.IsNaN(y))
+	}
+
+	return a.Equal(b)
+}
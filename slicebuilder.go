@@ -1,12 +1,19 @@
 package glint
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"time"
+)
 
 // SliceBuilder can build various types of glint slices and be appended into a DocumentBuilder
 type SliceBuilder struct {
 	schema Buffer
 	body   Buffer
 	wire   WireType
+	err    error // the first problem with a field name of a nested document or a dynamic element, see DocumentBuilder.Build
+
+	version byte // the newest format version of a nested document, see DocumentBuilder.AppendStruct
 }
 
 // AppendNestedDocumentSlice appends a nesteddocument slice
@@ -20,7 +27,13 @@ func (s *SliceBuilder) AppendNestedDocumentSlice(value []DocumentBuilder) {
 
 	s.body.AppendUint(uint(len(value)))
 	for i := 0; i < len(value); i++ {
-		s.body.Bytes = append(s.body.Bytes, value[i].body.Bytes...)
+		appendBuilt(&s.body, &value[i].body, WireStruct, value[i].schema.Bytes)
+		if s.err == nil {
+			s.err = value[i].err
+		}
+		if value[i].version > s.version {
+			s.version = value[i].version
+		}
 	}
 }
 
@@ -38,7 +51,13 @@ func (s *SliceBuilder) AppendSlice(value []SliceBuilder) {
 
 	s.body.AppendUint(uint(len(value)))
 	for i := 0; i < len(value); i++ {
-		s.body.Bytes = append(s.body.Bytes, value[i].body.Bytes...)
+		appendBuilt(&s.body, &value[i].body, WireSliceFlag|value[i].wire, value[i].schema.Bytes)
+		if s.err == nil {
+			s.err = value[i].err
+		}
+		if value[i].version > s.version {
+			s.version = value[i].version
+		}
 	}
 }
 
@@ -328,6 +347,46 @@ func (s *SliceBuilder) AppendFloat64Slice(value []float64) {
 	}
 }
 
+// AppendFloat32SliceDelta appends a float32 slice using delta encoding
+func (s *SliceBuilder) AppendFloat32SliceDelta(value []float32) {
+	s.wire = WireFloat32 | WireDeltaFlag
+	s.body.AppendUint(uint(len(value)))
+	if len(value) == 0 {
+		return
+	}
+
+	// First value as-is
+	s.body.AppendFloat32(value[0])
+
+	// Subsequent values as the bits that changed from the previous value
+	prev := math.Float32bits(value[0])
+	for i := 1; i < len(value); i++ {
+		curr := math.Float32bits(value[i])
+		appendFloatXor(&s.body, uint64(curr^prev))
+		prev = curr
+	}
+}
+
+// AppendFloat64SliceDelta appends a float64 slice using delta encoding
+func (s *SliceBuilder) AppendFloat64SliceDelta(value []float64) {
+	s.wire = WireFloat64 | WireDeltaFlag
+	s.body.AppendUint(uint(len(value)))
+	if len(value) == 0 {
+		return
+	}
+
+	// First value as-is
+	s.body.AppendFloat64(value[0])
+
+	// Subsequent values as the bits that changed from the previous value
+	prev := math.Float64bits(value[0])
+	for i := 1; i < len(value); i++ {
+		curr := math.Float64bits(value[i])
+		appendFloatXor(&s.body, curr^prev)
+		prev = curr
+	}
+}
+
 // AppendTimeSlice appends a time slice to this slice builder
 func (s *SliceBuilder) AppendTimeSlice(value []time.Time) {
 	s.wire = WireTime
@@ -345,3 +404,22 @@ func (s *SliceBuilder) AppendBoolSlice(value []bool) {
 		s.body.AppendBool(value[i])
 	}
 }
+
+// AppendDynamicSlice appends a slice whose elements can each have a different type, such as a JSON array, to this
+// slice builder. Elements are written as AppendDynamicValue writes them, and nil as a nil *string. Elements of types
+// it doesn't support, such as nested []any slices and maps, are written as nil too, and left for
+// DocumentBuilder.Build to report.
+func (s *SliceBuilder) AppendDynamicSlice(value []any) {
+	s.wire = WireDynamic
+	s.body.AppendUint(uint(len(value)))
+	for i := 0; i < len(value); i++ {
+		if value[i] != nil && appendDynamicValue(value[i], &s.body) {
+			continue
+		}
+		if value[i] != nil && s.err == nil {
+			s.err = fmt.Errorf("glint: unsupported type %T at index %d of a dynamic slice", value[i], i)
+		}
+		appendVarint(&s.body, uint64(WireString|WirePtrFlag))
+		s.body.Bytes = append(s.body.Bytes, 0)
+	}
+}
@@ -15,6 +15,12 @@ type sliceDecoder struct {
 	kind        WireType     // this is the wire type we were created to parse
 	wireType    WireType     // this is the wire type that was actually sent
 	limits      DecodeLimits // bounds checking configuration
+	pointers    bool         // elements are pointers to structs, each written after a presence byte
+
+	sparse func(t unsafe.Pointer, r Reader) Reader // reads the slice when a struct field was sent sparse encoded
+	dict   func(t unsafe.Pointer, r Reader) Reader // reads the slice when a struct field was sent in the string table
+	bitmap func(t unsafe.Pointer, r Reader) Reader // reads the slice when a struct field was sent as a bitmap
+	zigzag func(t unsafe.Pointer, r Reader) Reader // reads the slice when a struct field was sent as zigzag varints
 }
 
 // setWireType allows this instance to have its wireType set, which is the type information pulled from the schema
@@ -33,9 +39,23 @@ func (s *sliceDecoder) unmarshal(r Reader, instructions []decodeInstruction, v a
 	return s.instruction(p, r)
 }
 
+// pointerElements reports whether a slice sent as wire is a slice of pointers to structs, with the element type
+// that follows in r marking the presence byte before each element, see formatVersionPointers
+func pointerElements(wire WireType, r Reader) bool {
+	return wire == WireSliceFlag && WireType(r.ReadVarint()) == WirePtrFlag|WireStruct
+}
+
 // parseSchema reads the schema information sent and creates an instruction
 func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) ([]decodeInstruction, Reader, error) {
 
+	// slices of pointers were written as slices of structs before version 4, with nothing marking the presence
+	// byte before each element, so slices of structs are read with one when decoding into slices of pointers
+	present := s.pointers
+	if s.kind == WireSliceFlag|WireStruct && pointerElements(s.wireType, r) {
+		r.ReadVarint()
+		s.wireType, present = s.kind, true
+	}
+
 	// there are slightly different ways we need to parse the schema depending on the type of data we've been sent.
 
 	if s.subdec == nil && s.instruction == nil {
@@ -45,9 +65,21 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 		// expecting.
 
 		switch {
+		case pointerElements(s.wireType, r):
+			t := parseSchemaNode(s.wireType, &r)
+
+			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
+				l := r.ReadVarint()
+				checkLimit(l, s.limits.MaxSliceElements, "slice")
+				for i := uint(0); i < l; i++ {
+					skipValue(t.elem.wire, t.elem, &r)
+				}
+				return r
+			}
+
 		case s.wireType == WireSliceFlag:
 
-			dec := sliceDecoder{wireType: WireType(r.ReadVarint())}
+			dec := sliceDecoder{wireType: WireType(r.ReadVarint()), limits: s.limits}
 			var err error
 			_, r, err = dec.parseSchema(r, nil)
 			if err != nil {
@@ -56,7 +88,9 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 			slic := []struct{}{}
 
 			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
-				for i, sl := uint(0), r.ReadVarint(); i < sl; i++ {
+				sl := r.ReadVarint()
+				checkLimit(sl, s.limits.MaxSliceElements, "slice")
+				for i := uint(0); i < sl; i++ {
 					r = dec.unmarshal(r, nil, slic)
 				}
 				return r
@@ -71,7 +105,7 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 			sl := r.ReadVarint()
 			sb := r.Read(sl)
 
-			dec := newDecoder(struct{}{})
+			dec := newDecoderWithLimits(struct{}{}, s.limits)
 
 			var err error
 			instructions, _, err = dec.parseSchema(NewReader(sb), nil) // parse the schema using the sub-decoder we set up in the decodeInstruction
@@ -80,7 +114,9 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 			}
 			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
-				sl := int(r.ReadVarint()) // array length
+				n := r.ReadVarint() // array length
+				checkLimit(n, s.limits.MaxSliceElements, "slice")
+				sl := int(n)
 
 				for i := uintptr(0); i < uintptr(sl); i++ {
 					r = dec.unmarshal(r, instructions, nil)
@@ -89,6 +125,34 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 				return r
 			}
 
+		case s.wireType&WireTypeMask == WireMap:
+
+			dec := &mapDecoder{limits: s.limits}
+
+			var err error
+			_, r, err = dec.parseSchema(r, nil)
+			if err != nil {
+				return nil, r, err
+			}
+			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
+				sl := r.ReadVarint() // array length
+				checkLimit(sl, s.limits.MaxSliceElements, "slice")
+
+				for i := uint(0); i < sl; i++ {
+					r = dec.instruction(nil, r)
+				}
+				return r
+			}
+
+		case s.wireType&WireSparseFlag > 0:
+			s.instruction = skipSparseSlice
+
+		case s.wireType&WireDictFlag > 0:
+			s.instruction = skipDictSlice
+
+		case s.wireType&WireBitmapFlag > 0:
+			s.instruction = skipBitmapSlice
+
 		default:
 			// skip past slices of basic types
 
@@ -96,18 +160,22 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 
 			case WireInt, WireInt16, WireInt32, WireInt64,
 				WireUint, WireUint16, WireUint32, WireUint64,
-				WireFloat32, WireFloat64:
+				WireFloat32, WireFloat64, WireDuration:
 
 				s.instruction = func(t unsafe.Pointer, r Reader) Reader {
-					for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+					l := r.ReadVarint()
+					checkLimit(l, s.limits.MaxSliceElements, "slice")
+					for i := uint(0); i < l; i++ {
 						r.SkipVarint()
 					}
 					return r
 				}
 
-			case WireString, WireTime:
+			case WireString, WireTime, WireIP, WireIPPrefix, WireBigInt, WireDecimal:
 				s.instruction = func(t unsafe.Pointer, r Reader) Reader {
-					for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+					l := r.ReadVarint()
+					checkLimit(l, s.limits.MaxSliceElements, "slice")
+					for i := uint(0); i < l; i++ {
 						r.Skip(r.ReadVarint())
 					}
 					return r
@@ -118,13 +186,26 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 					r.Skip(r.ReadVarint())
 					return r
 				}
+
+			case WireDynamic:
+				s.instruction = func(t unsafe.Pointer, r Reader) Reader {
+					l := r.ReadVarint()
+					checkLimit(l, s.limits.MaxSliceElements, "slice")
+					for i := uint(0); i < l; i++ {
+						readDynamic(&r)
+					}
+					return r
+				}
+
+			default:
+				return nil, r, fmt.Errorf("%w: slice of unknown wire type %v", ErrInvalidDocument, s.wireType)
 			}
 
 		}
 
-	} else if s.wireType != s.kind {
+	} else if s.wireType != s.kind && !durationCompatible(s.wireType, s.kind) {
 		// if the wire type we were sent does not match the kind we were created for we fail here.
-		return nil, r, fmt.Errorf("slice wire type mismatch: %v != %v", s.wireType, s.kind)
+		return nil, r, fmt.Errorf("%w: slice wire type mismatch: %v != %v", ErrSchemaMismatch, s.wireType, s.kind)
 	}
 
 	switch d := s.subdec.(type) {
@@ -145,13 +226,12 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 		}
 
 	case *mapDecoder:
-		if d.wireType != s.kind {
+		if s.wireType != s.kind {
 			break
 		}
 
-		d.setWireType(WireType(r.ReadVarint()))
 		var err error
-		_, r, err = d.parseSchema(r, instructions) // we need to pass the schema down and back up here to read the type byte
+		_, r, err = d.parseSchema(r, instructions) // the key and value types follow the slice's own wire type
 		if err != nil {
 			return nil, r, err
 		}
@@ -170,6 +250,87 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 			return nil, r, err
 		}
 
+		if s.pointers {
+			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
+
+				n := r.ReadVarint() // array length
+				checkLimit(n, s.limits.MaxSliceElements, "slice")
+				sl := int(n)
+
+				sheader := (*sliceHeader)(unsafe.Pointer(uintptr(p)))
+				if sheader.Cap < sl || sl == 0 {
+					c := sl
+					if c == 0 {
+						c = 1
+					}
+					sli := r.newSlice(s.subType, sl, c)
+					*sheader = sliceHeader{
+						Data: sli,
+						Len:  sl,
+						Cap:  c,
+					}
+				} else {
+					sheader.Len = sl // we're reusing the slice, so we need to reset the length
+				}
+
+				elemType := s.subType.Elem().Elem()
+				for i := uintptr(0); i < uintptr(sl); i++ {
+					em := (*unsafe.Pointer)(unsafe.Add(sheader.Data, i*unsafe.Sizeof(uintptr(0))))
+					if r.ReadByte() == 0 {
+						*em = nil
+						continue
+					}
+
+					// elements already in the slice are decoded into, as with pointer fields
+					if *em == nil {
+						*em = r.newValue(elemType)
+					}
+					r = d.unmarshal(r, instructions, *em)
+				}
+
+				return r
+			}
+			break
+		}
+
+		if present {
+			// slices of pointers decoded into slices of structs, with nil elements left as zero values
+			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
+
+				n := r.ReadVarint() // array length
+				checkLimit(n, s.limits.MaxSliceElements, "slice")
+				sl := int(n)
+
+				sheader := (*sliceHeader)(unsafe.Pointer(uintptr(p)))
+				if sheader.Cap < sl || sl == 0 {
+					c := sl
+					if c == 0 {
+						c = 1
+					}
+					*sheader = sliceHeader{
+						Data: r.newSlice(s.subType, sl, c),
+						Len:  sl,
+						Cap:  c,
+					}
+				} else {
+					sheader.Len = sl // we're reusing the slice, so we need to reset the length
+				}
+
+				elemType := s.subType.Elem()
+				for i := uintptr(0); i < uintptr(sl); i++ {
+					em := unsafe.Add(sheader.Data, i*elemType.Size())
+					if r.ReadByte() == 0 {
+						reflect.NewAt(elemType, em).Elem().SetZero()
+						continue
+					}
+					r = d.unmarshal(r, instructions, em)
+				}
+
+				return r
+			}
+			break
+		}
+
 		// Check if this slice-of-structs can be optimized (small structs with only basic types)
 		canOptimize := len(instructions) <= 4 && len(instructions) > 0
 		if canOptimize {
@@ -184,21 +345,23 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
-			sl := int(r.ReadVarint()) // array length
+			n := r.ReadVarint() // array length
+			checkLimit(n, s.limits.MaxSliceElements, "slice")
+			sl := int(n)
 
 			if sl == 0 {
-				sli := reflect.MakeSlice(s.subType, 0, 1)
+				sli := r.newSlice(s.subType, 0, 1)
 				*(*sliceHeader)(unsafe.Pointer(uintptr(p))) = sliceHeader{
-					Data: unsafe.Pointer(sli.Pointer()),
+					Data: sli,
 					Len:  0,
 					Cap:  1,
 				}
 			}
 
 			if sheader := (*sliceHeader)(unsafe.Pointer(uintptr(p))); sheader == nil || sheader.Cap < sl {
-				sli := reflect.MakeSlice(s.subType, sl, sl)
+				sli := r.newSlice(s.subType, sl, sl)
 				*(*sliceHeader)(unsafe.Pointer(uintptr(p))) = sliceHeader{
-					Data: unsafe.Pointer(sli.Pointer()),
+					Data: sli,
 					Len:  sl,
 					Cap:  sl,
 				}
@@ -221,10 +384,9 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 						case WireString:
 							l := r.ReadVarint()
 							if l > r.BytesLeft() {
-								panic(fmt.Sprintf("string length %d exceeds remaining bytes %d", l, r.BytesLeft()))
+								panic(fmt.Errorf("%w: string length %d exceeds remaining bytes %d", ErrTruncatedDocument, l, r.BytesLeft()))
 							}
-							b := r.Read(l)
-							*(*string)(unsafe.Add(structPtr, instructions[j].offset)) = *(*string)(unsafe.Pointer(&b))
+							*(*string)(unsafe.Add(structPtr, instructions[j].offset)) = r.str(r.Read(l))
 						case WireInt:
 							*(*int)(unsafe.Add(structPtr, instructions[j].offset)) = r.ReadInt()
 						case WireInt32:
@@ -253,6 +415,32 @@ func (s *sliceDecoder) parseSchema(r Reader, instructions []decodeInstruction) (
 	return instructions, r, nil
 }
 
+// decodeBytes reads a byte slice into the []byte at p, sharing memory with the document unless there's an arena to
+// copy it into or strings are being copied
+func decodeBytes(p unsafe.Pointer, r Reader, limits DecodeLimits) Reader {
+	sl := r.ReadVarint()
+
+	// Bounds checking for byte slice length
+	checkLimit(sl, limits.MaxByteSliceLen, "byte slice")
+	if sl > r.BytesLeft() {
+		panic(fmt.Errorf("%w: byte slice length %d exceeds remaining bytes %d", ErrTruncatedDocument, sl, r.BytesLeft()))
+	}
+
+	if sl == 0 {
+		*(*[]byte)(p) = make([]byte, 0, 1)
+		return r
+	}
+	b := r.Read(sl)
+	switch {
+	case r.arena != nil:
+		b = append(r.arena.bytes(int(sl), 1)[:0], b...)
+	case r.copies == copyAll:
+		b = append(make([]byte, 0, sl), b...)
+	}
+	*(*[]byte)(p) = b
+	return r
+}
+
 func newSliceDecoderUsingTagAndOpts(t any, usingTagName string, opts tagOptions) *sliceDecoder {
 	return newSliceDecoderUsingTagAndOptsWithLimits(t, usingTagName, opts, DefaultLimits)
 }
@@ -263,21 +451,24 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 	s.limits = limits
 
 	tt := reflect.TypeOf(t)
+	s.sparse = sparseSliceDecoder(tt.Elem().Kind(), limits)
 
 	switch tt.Elem().Kind() {
 	case reflect.String:
 		s.kind = WireSliceFlag | WireString
+		s.dict = dictSliceReader(limits)
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 
 			var slice []string
 			// Cap initial allocation to prevent memory bombs
 			initialCap := min(sl, s.limits.MaxSliceInitCap)
 			if cap(*(*[]string)(unsafe.Pointer(uintptr(p)))) < int(initialCap) {
-				slice = make([]string, 0, initialCap)
+				slice = makeSlice[string](&r, initialCap)
 			} else if sl == 0 {
-				slice = make([]string, 0, 1)
+				slice = makeSlice[string](&r, 1)
 			} else {
 				slice = (*(*[]string)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -287,12 +478,10 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 				// Bounds checking for individual strings
 				checkLimit(l, s.limits.MaxStringLen, "string")
 				if l > r.BytesLeft() {
-					panic(fmt.Sprintf("string length %d exceeds remaining bytes %d", l, r.BytesLeft()))
+					panic(fmt.Errorf("%w: string length %d exceeds remaining bytes %d", ErrTruncatedDocument, l, r.BytesLeft()))
 				}
 
-				v := r.Read(l)
-
-				slice = append(slice, *(*string)(unsafe.Pointer(&v)))
+				slice = append(slice, r.str(r.Read(l)))
 			}
 			*(*[]string)(unsafe.Pointer(uintptr(p))) = slice
 
@@ -308,11 +497,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []int
 			if cap(*(*[]int)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]int, 0, sl)
+				slice = makeSlice[int](&r, sl)
 			} else if sl == 0 {
-				slice = make([]int, 0, 1)
+				slice = makeSlice[int](&r, 1)
 			} else {
 				slice = (*(*[]int)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -344,16 +534,18 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []int8
 			if cap(*(*[]int8)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]int8, 0, sl)
+				slice = makeSlice[int8](&r, sl)
 			} else if sl == 0 {
-				slice = make([]int8, 0, 1)
+				slice = makeSlice[int8](&r, 1)
 			} else {
 				slice = (*(*[]int8)(unsafe.Pointer(uintptr(p))))[:0]
 			}
-			for i := uint(0); i < sl; i++ {
-				slice = append(slice, r.ReadInt8())
+			if sl > 0 { // one byte per element, copied in bulk
+				slice = slice[:sl]
+				copy(unsafe.Slice((*byte)(unsafe.Pointer(&slice[0])), sl), r.Read(sl))
 			}
 			*(*[]int8)(unsafe.Pointer(uintptr(p))) = slice
 
@@ -368,11 +560,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []int16
 			if cap(*(*[]int16)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]int16, 0, sl)
+				slice = makeSlice[int16](&r, sl)
 			} else if sl == 0 {
-				slice = make([]int16, 0, 1)
+				slice = makeSlice[int16](&r, 1)
 			} else {
 				slice = (*(*[]int16)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -407,11 +600,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []int32
 			if cap(*(*[]int32)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]int32, 0, sl)
+				slice = makeSlice[int32](&r, sl)
 			} else if sl == 0 {
-				slice = make([]int32, 0, 1)
+				slice = makeSlice[int32](&r, 1)
 			} else {
 				slice = (*(*[]int32)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -440,17 +634,22 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 
 	case reflect.Int64:
 		s.kind = WireSliceFlag | WireInt64
+		if tt.Elem() == durationType {
+			s.kind = WireSliceFlag | WireDuration
+		}
 		if opts.Contains("delta") {
 			s.kind |= WireDeltaFlag
 		}
+		s.zigzag = zigzagSliceReader(limits)
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []int64
 			if cap(*(*[]int64)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]int64, 0, sl)
+				slice = makeSlice[int64](&r, sl)
 			} else if sl == 0 {
-				slice = make([]int64, 0, 1)
+				slice = makeSlice[int64](&r, 1)
 			} else {
 				slice = (*(*[]int64)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -489,11 +688,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []uint
 			if cap(*(*[]uint)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]uint, 0, sl)
+				slice = makeSlice[uint](&r, sl)
 			} else if sl == 0 {
-				slice = make([]uint, 0, 1)
+				slice = makeSlice[uint](&r, 1)
 			} else {
 				slice = (*(*[]uint)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -523,20 +723,7 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 	case reflect.Uint8:
 		s.kind = WireBytes
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
-			sl := r.ReadVarint()
-
-			// Bounds checking for byte slice length
-			checkLimit(sl, s.limits.MaxByteSliceLen, "byte slice")
-			if sl > r.BytesLeft() {
-				panic(fmt.Sprintf("byte slice length %d exceeds remaining bytes %d", sl, r.BytesLeft()))
-			}
-
-			if sl == 0 {
-				*(*[]byte)(unsafe.Pointer(uintptr(p))) = make([]byte, 0, 1)
-				return r
-			}
-			*(*[]byte)(unsafe.Pointer(uintptr(p))) = r.Read(sl)
-			return r
+			return decodeBytes(p, r, s.limits)
 		}
 
 	case reflect.Uint16:
@@ -547,11 +734,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []uint16
 			if cap(*(*[]uint16)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]uint16, 0, sl)
+				slice = makeSlice[uint16](&r, sl)
 			} else if sl == 0 {
-				slice = make([]uint16, 0, 1)
+				slice = makeSlice[uint16](&r, 1)
 			} else {
 				slice = (*(*[]uint16)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -586,11 +774,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []uint32
 			if cap(*(*[]uint32)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]uint32, 0, sl)
+				slice = makeSlice[uint32](&r, sl)
 			} else if sl == 0 {
-				slice = make([]uint32, 0, 1)
+				slice = makeSlice[uint32](&r, 1)
 			} else {
 				slice = (*(*[]uint32)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -625,11 +814,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []uint64
 			if cap(*(*[]uint64)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]uint64, 0, sl)
+				slice = makeSlice[uint64](&r, sl)
 			} else if sl == 0 {
-				slice = make([]uint64, 0, 1)
+				slice = makeSlice[uint64](&r, 1)
 			} else {
 				slice = (*(*[]uint64)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -658,19 +848,33 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 
 	case reflect.Float32:
 		s.kind = WireSliceFlag | WireFloat32
+		if opts.Contains("delta") {
+			s.kind |= WireDeltaFlag
+		}
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []float32
 			if cap(*(*[]float32)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]float32, 0, sl)
+				slice = makeSlice[float32](&r, sl)
 			} else if sl == 0 {
-				slice = make([]float32, 0, 1)
+				slice = makeSlice[float32](&r, 1)
 			} else {
 				slice = (*(*[]float32)(unsafe.Pointer(uintptr(p))))[:0]
 			}
-			for i := uint(0); i < sl; i++ {
-				slice = append(slice, r.ReadFloat32())
+			if s.wireType&WireDeltaFlag != 0 && sl > 0 {
+				// XOR decoding: first value + the bits that changed from each value to the next
+				prev := r.ReadFloat32()
+				slice = append(slice, prev)
+				for i := uint(1); i < sl; i++ {
+					prev = r.ReadFloat32Delta(prev)
+					slice = append(slice, prev)
+				}
+			} else {
+				for i := uint(0); i < sl; i++ {
+					slice = append(slice, r.ReadFloat32())
+				}
 			}
 			*(*[]float32)(unsafe.Pointer(uintptr(p))) = slice
 
@@ -679,19 +883,33 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 
 	case reflect.Float64:
 		s.kind = WireSliceFlag | WireFloat64
+		if opts.Contains("delta") {
+			s.kind |= WireDeltaFlag
+		}
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 			var slice []float64
 			if cap(*(*[]float64)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]float64, 0, sl)
+				slice = makeSlice[float64](&r, sl)
 			} else if sl == 0 {
-				slice = make([]float64, 0, 1)
+				slice = makeSlice[float64](&r, 1)
 			} else {
 				slice = (*(*[]float64)(unsafe.Pointer(uintptr(p))))[:0]
 			}
-			for i := uint(0); i < sl; i++ {
-				slice = append(slice, r.ReadFloat64())
+			if s.wireType&WireDeltaFlag != 0 && sl > 0 {
+				// XOR decoding: first value + the bits that changed from each value to the next
+				prev := r.ReadFloat64()
+				slice = append(slice, prev)
+				for i := uint(1); i < sl; i++ {
+					prev = r.ReadFloat64Delta(prev)
+					slice = append(slice, prev)
+				}
+			} else {
+				for i := uint(0); i < sl; i++ {
+					slice = append(slice, r.ReadFloat64())
+				}
 			}
 			*(*[]float64)(unsafe.Pointer(uintptr(p))) = slice
 
@@ -700,15 +918,17 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 
 	case reflect.Bool:
 		s.kind = WireSliceFlag | WireBool
+		s.bitmap = bitmapSliceReader(limits)
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
 
 			var slice []bool
 			if cap(*(*[]bool)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-				slice = make([]bool, 0, sl)
+				slice = makeSlice[bool](&r, sl)
 			} else if sl == 0 {
-				slice = make([]bool, 0, 1)
+				slice = makeSlice[bool](&r, 1)
 			} else {
 				slice = (*(*[]bool)(unsafe.Pointer(uintptr(p))))[:0]
 			}
@@ -728,11 +948,12 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 			s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
 				sl := r.ReadVarint() // array length
+				checkLimit(sl, s.limits.MaxSliceElements, "slice")
 				var slice []time.Time
 				if cap(*(*[]time.Time)(unsafe.Pointer(uintptr(p)))) < int(sl) {
-					slice = make([]time.Time, 0, sl)
+					slice = makeSlice[time.Time](&r, sl)
 				} else if sl == 0 {
-					slice = make([]time.Time, 0, 1)
+					slice = makeSlice[time.Time](&r, 1)
 				} else {
 					slice = (*(*[]time.Time)(unsafe.Pointer(uintptr(p))))[:0]
 				}
@@ -750,29 +971,72 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		s.kind = WireSliceFlag | WireStruct
 		var inf = reflect.New(tt.Elem()).Elem().Interface()
 
-		dec := newDecoderUsingTag(inf, usingTagName)
+		dec := newDecoderUsingTagWithLimits(inf, usingTagName, s.limits)
+		s.subdec = dec
+
+	case reflect.Pointer:
+
+		// slices of pointers to structs, any other pointers aren't supported by the encoder
+		if tt.Elem().Elem().Kind() != reflect.Struct || tt.Elem().Elem() == timeType {
+			panic(fmt.Sprintf("slicedecoder unsupported type %v", tt))
+		}
+
+		s.kind = WireSliceFlag | WireStruct
+		s.pointers = true
+		var inf = reflect.New(tt.Elem().Elem()).Elem().Interface()
+
+		dec := newDecoderUsingTagWithLimits(inf, usingTagName, s.limits)
 		s.subdec = dec
 
 	case reflect.Map:
 
-		panic("not yet")
+		s.kind = WireSliceFlag | WireMap
+		var inf = reflect.New(tt.Elem()).Elem().Interface()
+
+		dec := newMapDecoderUsingTagAndOptsWithLimits(inf, usingTagName, opts, s.limits)
+		dec.subType = tt.Elem()
+		s.subdec = dec
+		size := tt.Elem().Size()
+
+		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
+
+			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
+
+			// a new slice every time, map decoders add to maps they're given rather than replacing them
+			c := sl
+			if c == 0 {
+				c = 1
+			}
+			slice := reflect.MakeSlice(tt, int(sl), int(c))
+			elem := slice.UnsafePointer()
+
+			for i := uintptr(0); i < uintptr(sl); i++ {
+				r = dec.instruction(unsafe.Add(elem, i*size), r)
+			}
+			reflect.NewAt(tt, p).Elem().Set(slice)
+
+			return r
+		}
 
 	case reflect.Slice:
 
 		s.kind = WireSliceFlag
 		var inf = reflect.New(tt.Elem()).Elem().Interface()
 
-		dec := newSliceDecoderUsingTagAndOpts(inf, usingTagName, opts)
-		dec.subType = tt
+		dec := newSliceDecoderUsingTagAndOptsWithLimits(inf, usingTagName, opts, s.limits)
+		dec.subType = tt.Elem()
 
 		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
 
-			sl := int(r.ReadVarint()) // array length
+			n := r.ReadVarint() // array length
+			checkLimit(n, s.limits.MaxSliceElements, "slice")
+			sl := int(n)
 
 			if sl == 0 {
-				sli := reflect.MakeSlice(s.subType, 0, 1)
+				sli := r.newSlice(s.subType, 0, 1)
 				*(*sliceHeader)(unsafe.Pointer(uintptr(p))) = sliceHeader{
-					Data: unsafe.Pointer(sli.Pointer()),
+					Data: sli,
 					Len:  sl,
 					Cap:  sl,
 				}
@@ -780,9 +1044,9 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 			}
 
 			if sheader := (*sliceHeader)(unsafe.Pointer(uintptr(p))); sheader == nil || sheader.Cap < sl {
-				sli := reflect.MakeSlice(s.subType, sl, sl)
+				sli := r.newSlice(s.subType, sl, sl)
 				*(*sliceHeader)(unsafe.Pointer(uintptr(p))) = sliceHeader{
-					Data: unsafe.Pointer(sli.Pointer()),
+					Data: sli,
 					Len:  sl,
 					Cap:  sl,
 				}
@@ -801,6 +1065,29 @@ func newSliceDecoderUsingTagAndOptsWithLimits(t any, usingTagName string, opts t
 		}
 		s.subdec = dec
 
+	case reflect.Interface:
+
+		// slices of any type, see dynamic.go, with their elements read as DocumentTemplateData reads them
+		if tt.Elem().NumMethod() > 0 {
+			panic(fmt.Sprintf("slicedecoder unsupported type %v", tt))
+		}
+
+		s.kind = WireSliceFlag | WireDynamic
+		s.instruction = func(p unsafe.Pointer, r Reader) Reader {
+
+			sl := r.ReadVarint() // array length
+			checkLimit(sl, s.limits.MaxSliceElements, "slice")
+
+			slice := make([]any, 0, min(sl, r.BytesLeft())) // every element takes at least a byte for its wire type
+			for i := uint(0); i < sl; i++ {
+				_, v := readDynamic(&r)
+				slice = append(slice, v)
+			}
+			*(*[]any)(unsafe.Pointer(uintptr(p))) = slice
+
+			return r
+		}
+
 	default:
 
 		panic(fmt.Sprintf("slicedecoder unsupported type %v", tt))
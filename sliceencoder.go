@@ -2,6 +2,7 @@ package glint
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"time"
 	"unsafe"
@@ -42,11 +43,11 @@ func NewSliceEncoderUsingTag(t any, usingTagName string) *SliceEncoder {
 
 // NewSliceEncoderUsingTagWithSchema allows us to create an instruction which can iterate over a slice of different data types at runtime
 func NewSliceEncoderUsingTagWithSchema(t any, usingTagName string, sc *Buffer) *SliceEncoder {
-	return newSliceEncoderUsingTagWithSchemaAndOpts(t, usingTagName, sc, tagOptions(""))
+	return newSliceEncoderUsingTagWithSchemaAndOpts(t, usingTagName, sc, tagOptions(""), newEncoderConfig(nil))
 }
 
 // NewSliceEncoderUsingTagWithSchemaAndOpts allows us to create an instruction which can iterate over a slice of different data types at runtime
-func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buffer, opts tagOptions) *SliceEncoder {
+func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Buffer, opts tagOptions, config encoderConfig) *SliceEncoder {
 
 	s := &SliceEncoder{}
 	s.schema = sc
@@ -119,9 +120,7 @@ func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Bu
 		s.instruction = func(p unsafe.Pointer, b *Buffer) {
 			sl := *(*sliceHeader)(p)
 			b.AppendUint(uint(sl.Len))
-			for i := uintptr(0); i < uintptr(sl.Len); i++ {
-				b.AppendInt8(*(*int8)(unsafe.Add(sl.Data, (i * eoffset))))
-			}
+			b.Bytes = append(b.Bytes, unsafe.Slice((*byte)(sl.Data), sl.Len)...) // one byte per element, copied in bulk
 		}
 
 	case reflect.Int16:
@@ -186,6 +185,9 @@ func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Bu
 
 	case reflect.Int64:
 		s.wire = WireSliceFlag | WireInt64
+		if tt.Elem() == durationType {
+			s.wire = WireSliceFlag | WireDuration
+		}
 		if opts.Contains("delta") {
 			s.wire |= WireDeltaFlag
 		}
@@ -343,9 +345,25 @@ func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Bu
 
 	case reflect.Float32:
 		s.wire = WireSliceFlag | WireFloat32
+		if opts.Contains("delta") {
+			s.wire |= WireDeltaFlag
+		}
 		s.instruction = func(p unsafe.Pointer, b *Buffer) {
 			sl := *(*sliceHeader)(p)
 			b.AppendUint(uint(sl.Len))
+
+			if s.wire&WireDeltaFlag != 0 && sl.Len > 0 {
+				// XOR encoding: first value + the bits that changed from each value to the next
+				prev := math.Float32bits(*(*float32)(sl.Data))
+				b.AppendFloat32(*(*float32)(sl.Data))
+				for i := uintptr(1); i < uintptr(sl.Len); i++ {
+					curr := math.Float32bits(*(*float32)(unsafe.Add(sl.Data, (i * eoffset))))
+					appendFloatXor(b, uint64(curr^prev))
+					prev = curr
+				}
+				return
+			}
+
 			for i := uintptr(0); i < uintptr(sl.Len); i++ {
 				b.AppendFloat32(*(*float32)(unsafe.Add(sl.Data, (i * eoffset))))
 			}
@@ -353,9 +371,25 @@ func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Bu
 
 	case reflect.Float64:
 		s.wire = WireSliceFlag | WireFloat64
+		if opts.Contains("delta") {
+			s.wire |= WireDeltaFlag
+		}
 		s.instruction = func(p unsafe.Pointer, b *Buffer) {
 			sl := *(*sliceHeader)(p)
 			b.AppendUint(uint(sl.Len))
+
+			if s.wire&WireDeltaFlag != 0 && sl.Len > 0 {
+				// XOR encoding: first value + the bits that changed from each value to the next
+				prev := math.Float64bits(*(*float64)(sl.Data))
+				b.AppendFloat64(*(*float64)(sl.Data))
+				for i := uintptr(1); i < uintptr(sl.Len); i++ {
+					curr := math.Float64bits(*(*float64)(unsafe.Add(sl.Data, (i * eoffset))))
+					appendFloatXor(b, (curr ^ prev))
+					prev = curr
+				}
+				return
+			}
+
 			for i := uintptr(0); i < uintptr(sl.Len); i++ {
 				b.AppendFloat64(*(*float64)(unsafe.Add(sl.Data, (i * eoffset))))
 			}
@@ -366,20 +400,33 @@ func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Bu
 		s.instruction = func(p unsafe.Pointer, b *Buffer) {
 			sl := *(*sliceHeader)(p)
 			b.AppendUint(uint(sl.Len))
-			for i := uintptr(0); i < uintptr(sl.Len); i++ {
-				b.AppendBool(*(*bool)(unsafe.Add(sl.Data, (i * eoffset))))
-			}
+			b.Bytes = append(b.Bytes, unsafe.Slice((*byte)(sl.Data), sl.Len)...) // bools are always 0 or 1 in memory
 		}
 
 	case reflect.Map:
+		if pointerWrapped {
+			panic(fmt.Sprintf("unsupported type %v", tt))
+		}
+
+		s.wire = WireSliceFlag | WireMap
 
-		panic("not yet")
+		var inf = reflect.New(k).Elem().Interface()
+		enc := newMapEncoderUsingTagWithSchemaAndOpts(inf, usingTagName, &Buffer{}, opts, config)
+		s.schema.Bytes = append(s.schema.Bytes, enc.Schema().Bytes...)
+
+		s.instruction = func(p unsafe.Pointer, b *Buffer) {
+			sl := *(*sliceHeader)(p)
+			b.AppendUint(uint(sl.Len))
+			for i := uintptr(0); i < uintptr(sl.Len); i++ {
+				enc.instruction(unsafe.Add(sl.Data, (i*eoffset)), b)
+			}
+		}
 
 	case reflect.Slice:
 		s.wire = WireSliceFlag // slice on its own denotes slice of slice
 
 		var inf = reflect.New(tt.Elem()).Elem().Interface()
-		enc := newSliceEncoderUsingTagWithSchemaAndOpts(inf, usingTagName, &Buffer{}, opts)
+		enc := newSliceEncoderUsingTagWithSchemaAndOpts(inf, usingTagName, &Buffer{}, opts, config)
 		s.instruction = func(p unsafe.Pointer, b *Buffer) {
 			sl := *(*sliceHeader)(p)
 			b.AppendUint(uint(sl.Len))
@@ -404,16 +451,21 @@ func newSliceEncoderUsingTagWithSchemaAndOpts(t any, usingTagName string, sc *Bu
 				sl := *(*sliceHeader)(p)
 				b.AppendUint(uint(sl.Len))
 				for i := uintptr(0); i < uintptr(sl.Len); i++ {
-					b.AppendTime(*(*time.Time)(unsafe.Add(sl.Data, (i * eoffset))))
+					config.appendTime(b, *(*time.Time)(unsafe.Add(sl.Data, (i * eoffset))))
 				}
 			}
 			break
 		}
 
 		s.wire = WireSliceFlag | WireStruct
+		if pointerWrapped && config.maxFormatVersion >= formatVersionPointers {
+			// the element's own wire type follows, marking the presence byte written before each of them
+			s.wire = WireSliceFlag
+			s.schema.AppendUint(uint(WirePtrFlag | WireStruct))
+		}
 
 		var inf = reflect.New(k).Elem().Interface()
-		s.subenc = newEncoderUsingTag(inf, usingTagName)
+		s.subenc = newEncoderUsingTagWithConfig(inf, usingTagName, config)
 		enc := s.subenc
 
 		s.schema.Bytes = append(s.schema.Bytes, s.subenc.Schema().Bytes...)
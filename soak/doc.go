@@ -0,0 +1,10 @@
+// Package soak holds long-running regression tests for glint's performance claims. They encode and decode a
+// large dataset continuously for a set duration, sampling allocations, heap and RSS, and pooled buffer reuse as
+// they go, and fail when any of those cross a threshold.
+//
+// The tests only run when given a duration, see the Makefile's soak target
+//
+//	go test ./soak -run Soak -soak.duration 5m -soak.memprofile soak.mem.pprof
+//
+// The package has no API of its own.
+package soak
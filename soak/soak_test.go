@@ -0,0 +1,223 @@
+package soak
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kungfusheep/glint"
+)
+
+var (
+	duration   = flag.Duration("soak.duration", 0, "How long to run the soak test for, it's skipped when zero")
+	memprofile = flag.String("soak.memprofile", "", "Write a heap profile to this file once the soak test finishes")
+	maxAllocs  = flag.Float64("soak.maxallocs", 1, "Most allocations allowed per encode and decode round trip, on average")
+	maxGrowth  = flag.Float64("soak.maxgrowth", 1.5, "Most the heap or RSS may grow by between the first and last samples, as a factor")
+	maxMisses  = flag.Float64("soak.maxpoolmisses", 0.1, "Highest fraction of pooled buffers allowed to arrive too small for a document")
+)
+
+type child struct {
+	Name  string   `glint:"name"`
+	Age   int      `glint:"age"`
+	Tags  []string `glint:"tags"`
+	Score float64  `glint:"score"`
+}
+
+type parent struct {
+	Name     string         `glint:"name"`
+	Age8     int8           `glint:"age8"`
+	Age      int            `glint:"age"`
+	Age32    int32          `glint:"age32"`
+	Age64    int64          `glint:"age64"`
+	Created  time.Time      `glint:"created"`
+	Children []child        `glint:"children"`
+	Counts   map[string]int `glint:"counts"`
+	Labels   []string       `glint:"labels"`
+	Extra    *child         `glint:"extra"`
+	Series   []int64        `glint:"series,delta"`
+	Blob     []byte         `glint:"blob"`
+}
+
+// hugeDataset builds the value the soak test round trips, around 150KB once encoded. Once warmed up, round trips
+// of it into the same value shouldn't allocate at all.
+func hugeDataset() parent {
+	v := parent{
+		Name: "soak test value", Age8: 127, Age: 21239871235, Age32: 31923987, Age64: 41263,
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Counts:  map[string]int{},
+		Extra:   &child{Name: "extra", Age: 1},
+		Blob:    bytes.Repeat([]byte{0xAB}, 4096),
+	}
+
+	for i := 0; i < 5000; i++ {
+		v.Children = append(v.Children, child{Name: "child " + strconv.Itoa(i), Age: i, Tags: []string{"a", "b"}, Score: float64(i) / 3})
+		v.Series = append(v.Series, int64(1_700_000_000+i*10))
+	}
+	for i := 0; i < 200; i++ {
+		k := "key" + strconv.Itoa(i)
+		v.Counts[k] = i
+		v.Labels = append(v.Labels, k)
+	}
+	return v
+}
+
+// sample is a snapshot of the process' memory, and of the work done, part way through the soak test
+type sample struct {
+	at        time.Duration
+	ops       uint64
+	mallocs   uint64
+	heapInUse uint64
+	rss       uint64
+}
+
+func takeSample(start time.Time, ops uint64) sample {
+	runtime.GC() // so the heap reflects what's live rather than what's awaiting collection
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return sample{at: time.Since(start), ops: ops, mallocs: m.Mallocs, heapInUse: m.HeapInuse, rss: rss()}
+}
+
+// rss returns the resident set size of the process, or zero where it isn't available from /proc
+func rss() uint64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if rest, ok := strings.CutPrefix(s.Text(), "VmRSS:"); ok {
+			kb, _ := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(rest, "kB")), 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// TestSoak round trips the huge dataset through pooled buffers until the duration runs out, then checks the
+// samples taken along the way against the thresholds
+func TestSoak(t *testing.T) {
+	if *duration == 0 {
+		t.Skip("soak test skipped, set -soak.duration to run it")
+	}
+
+	v := hugeDataset()
+	enc := glint.NewEncoder[parent]()
+	dec := glint.NewDecoder[parent]()
+
+	var out parent
+	var ops, misses uint64
+	roundTrip := func(size int) int {
+		b := glint.NewBufferFromPool()
+		if cap(b.Bytes) < size {
+			misses++
+		}
+		enc.Marshal(&v, b)
+		if err := dec.Unmarshal(b.Bytes, &out); err != nil {
+			t.Fatal(err)
+		}
+		n := len(b.Bytes)
+		b.ReturnToPool()
+		ops++
+		return n
+	}
+
+	size := roundTrip(0)
+	t.Logf("document is %d bytes", size)
+
+	// warm up so that caches, pools and the decoded value reach their steady state before the first sample
+	start := time.Now()
+	for time.Since(start) < *duration/10 {
+		roundTrip(size)
+	}
+
+	interval := *duration / 20
+	samples := []sample{takeSample(start, ops)}
+	warmOps, warmMisses := ops, misses
+
+	next := time.Now().Add(interval)
+	for time.Since(start) < *duration {
+		roundTrip(size)
+		if time.Now().After(next) {
+			samples = append(samples, takeSample(start, ops))
+			next = time.Now().Add(interval)
+		}
+	}
+	samples = append(samples, takeSample(start, ops))
+	runtime.KeepAlive(&v) // both values are live throughout, as they would be in a real workload
+	runtime.KeepAlive(&out)
+
+	for _, s := range samples {
+		t.Logf("%8v  ops %-10d heap %-10d rss %d", s.at.Round(time.Second), s.ops, s.heapInUse, s.rss)
+	}
+
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	checkSoak(t, first, last, float64(misses-warmMisses)/float64(ops-warmOps))
+}
+
+// checkSoak fails the test when the samples either side of the soak, or its pool misses, cross a threshold. The
+// samples themselves allocate a little, which is small enough next to the work between them to ignore.
+func checkSoak(t *testing.T, first, last sample, missRate float64) {
+	t.Helper()
+
+	ops := last.ops - first.ops
+	if ops == 0 {
+		t.Fatalf("no round trips between the first and last samples, increase -soak.duration")
+	}
+
+	allocs := float64(last.mallocs-first.mallocs) / float64(ops)
+	t.Logf("%d round trips, %.2f allocations each, %.1f%% pool misses", ops, allocs, missRate*100)
+
+	if allocs > *maxAllocs {
+		t.Errorf("%.2f allocations per round trip, more than the %.2f allowed", allocs, *maxAllocs)
+	}
+	if growth := float64(last.heapInUse) / float64(first.heapInUse); growth > *maxGrowth {
+		t.Errorf("heap grew by %.2fx from %d to %d bytes, more than the %.2fx allowed", growth, first.heapInUse, last.heapInUse, *maxGrowth)
+	}
+	if first.rss > 0 {
+		if growth := float64(last.rss) / float64(first.rss); growth > *maxGrowth {
+			t.Errorf("RSS grew by %.2fx from %d to %d bytes, more than the %.2fx allowed", growth, first.rss, last.rss, *maxGrowth)
+		}
+	}
+	if missRate > *maxMisses {
+		t.Errorf("%.1f%% of pooled buffers were too small for the document, more than the %.1f%% allowed", missRate*100, *maxMisses*100)
+	}
+}
+
+// BenchmarkSoakRoundTrip is a single round trip of the soak test's dataset, for comparing against over time
+func BenchmarkSoakRoundTrip(b *testing.B) {
+	v := hugeDataset()
+	enc := glint.NewEncoder[parent]()
+	dec := glint.NewDecoder[parent]()
+
+	var out parent
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := glint.NewBufferFromPool()
+		enc.Marshal(&v, buf)
+		if err := dec.Unmarshal(buf.Bytes, &out); err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(len(buf.Bytes)))
+		buf.ReturnToPool()
+	}
+}
@@ -0,0 +1,204 @@
+package glint
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// Slices of numbers tagged `sparse` set WireSparseFlag in their schema, and their bodies are written as
+//
+//	[length][count]
+//
+// followed by either `count` values when count == length (dense), or `count` pairs of [index gap][value] holding
+// only the non-zero elements. The index gap is the number of zero elements since the previous non-zero element.
+//
+// The encoder picks between the two for every value it writes, so slices that are only occasionally sparse
+// don't pay for indexes. Decoders always reconstruct the dense slice, and don't need the tag themselves.
+
+// sparseNumber lists the element types that can be sparse encoded. Byte sized elements are left out as they
+// are already written a byte per element.
+type sparseNumber interface {
+	int | int16 | int32 | int64 | uint | uint16 | uint32 | uint64 | float32 | float64
+}
+
+// sparseZero reports whether v can be left out of a sparse slice. Negative zero is kept so it survives the
+// roundtrip.
+func sparseZero[T sparseNumber](v T) bool {
+	return v == 0 && !math.Signbit(float64(v))
+}
+
+// sparseSliceEncoder returns an instruction that sparse encodes a slice of the given kind, or nil when the kind
+// isn't supported
+func sparseSliceEncoder(k reflect.Kind) func(unsafe.Pointer, *Buffer) {
+	switch k {
+	case reflect.Int:
+		return sparseSliceInstruction((*Buffer).AppendInt)
+	case reflect.Int16:
+		return sparseSliceInstruction((*Buffer).AppendInt16)
+	case reflect.Int32:
+		return sparseSliceInstruction((*Buffer).AppendInt32)
+	case reflect.Int64:
+		return sparseSliceInstruction((*Buffer).AppendInt64)
+	case reflect.Uint:
+		return sparseSliceInstruction((*Buffer).AppendUint)
+	case reflect.Uint16:
+		return sparseSliceInstruction((*Buffer).AppendUint16)
+	case reflect.Uint32:
+		return sparseSliceInstruction((*Buffer).AppendUint32)
+	case reflect.Uint64:
+		return sparseSliceInstruction((*Buffer).AppendUint64)
+	case reflect.Float32:
+		return sparseSliceInstruction((*Buffer).AppendFloat32)
+	case reflect.Float64:
+		return sparseSliceInstruction((*Buffer).AppendFloat64)
+	}
+	return nil
+}
+
+// sparseSlice switches a slice encoder over to sparse encoding. Only numeric slices held directly by a struct
+// field can be sparse, so that decoders can settle on the layout per field as they parse the schema.
+func sparseSlice(s *SliceEncoder, t reflect.Type) {
+	if s.wire&WireDeltaFlag > 0 {
+		panic("sparse and delta options can't be combined")
+	}
+
+	instruction := sparseSliceEncoder(t.Elem().Kind())
+	if instruction == nil {
+		panic("sparse option requires a slice of integers or floats wider than a byte")
+	}
+
+	s.wire |= WireSparseFlag
+	s.instruction = instruction
+}
+
+// sparseSliceInstruction writes a slice sparsely when more than half of its elements are zero, densely otherwise
+func sparseSliceInstruction[T sparseNumber](appendValue func(*Buffer, T)) func(unsafe.Pointer, *Buffer) {
+	return func(p unsafe.Pointer, b *Buffer) {
+		s := *(*[]T)(p)
+		b.AppendUint(uint(len(s)))
+
+		count := 0
+		for _, v := range s {
+			if !sparseZero(v) {
+				count++
+			}
+		}
+
+		if count*2 >= len(s) { // the indexes would cost more than the zeros they save
+			b.AppendUint(uint(len(s)))
+			for _, v := range s {
+				appendValue(b, v)
+			}
+			return
+		}
+
+		b.AppendUint(uint(count))
+		next := 0
+		for i, v := range s {
+			if sparseZero(v) {
+				continue
+			}
+			b.AppendUint(uint(i - next)) // zeros since the previous value
+			appendValue(b, v)
+			next = i + 1
+		}
+	}
+}
+
+// sparseSliceDecoder returns an instruction that reads a sparse encoded slice of the given kind into its dense
+// form, or nil when the kind isn't supported
+func sparseSliceDecoder(k reflect.Kind, limits DecodeLimits) func(unsafe.Pointer, Reader) Reader {
+	switch k {
+	case reflect.Int:
+		return sparseSliceReader((*Reader).ReadInt, limits)
+	case reflect.Int16:
+		return sparseSliceReader((*Reader).ReadInt16, limits)
+	case reflect.Int32:
+		return sparseSliceReader((*Reader).ReadInt32, limits)
+	case reflect.Int64:
+		return sparseSliceReader((*Reader).ReadInt64, limits)
+	case reflect.Uint:
+		return sparseSliceReader((*Reader).ReadUint, limits)
+	case reflect.Uint16:
+		return sparseSliceReader((*Reader).ReadUint16, limits)
+	case reflect.Uint32:
+		return sparseSliceReader((*Reader).ReadUint32, limits)
+	case reflect.Uint64:
+		return sparseSliceReader((*Reader).ReadUint64, limits)
+	case reflect.Float32:
+		return sparseSliceReader((*Reader).ReadFloat32, limits)
+	case reflect.Float64:
+		return sparseSliceReader((*Reader).ReadFloat64, limits)
+	}
+	return nil
+}
+
+// sparseSliceReader reads either layout of a sparse slice, see sparseSliceInstruction
+func sparseSliceReader[T sparseNumber](readValue func(*Reader) T, limits DecodeLimits) func(unsafe.Pointer, Reader) Reader {
+	size := uint(unsafe.Sizeof(T(0)))
+
+	return func(p unsafe.Pointer, r Reader) Reader {
+		length, count := r.ReadVarint(), r.ReadVarint()
+
+		// unlike other slices the length isn't backed by bytes in the document, so bound the allocation instead
+		if length > math.MaxInt/size {
+			panic(fmt.Sprintf("sparse slice length %d too large", length))
+		}
+		checkLimit(length*size, limits.MaxByteSliceLen, "sparse slice")
+		checkLimit(length, limits.MaxSliceElements, "sparse slice")
+
+		if count > length {
+			panic(fmt.Sprintf("sparse slice holds %d values but has a length of %d", count, length))
+		}
+
+		s := *(*[]T)(p)
+		if s == nil || uint(cap(s)) < length {
+			c := length
+			if c == 0 {
+				c = 1 // empty slices decode as non-nil, as they do for dense slices
+			}
+			s = make([]T, length, c)
+		} else {
+			s = s[:length]
+			for i := range s {
+				s[i] = 0
+			}
+		}
+
+		if count == length {
+			for i := range s {
+				s[i] = readValue(&r)
+			}
+		} else {
+			next := uint(0)
+			for i := uint(0); i < count; i++ {
+				next += r.ReadVarint()
+				if next >= length {
+					panic(fmt.Sprintf("sparse slice index %d out of range for length %d", next, length))
+				}
+				s[next] = readValue(&r)
+				next++
+			}
+		}
+
+		*(*[]T)(p) = s
+		return r
+	}
+}
+
+// skipSparseSlice reads past a sparse slice without decoding it. All sparse element types are varints.
+func skipSparseSlice(p unsafe.Pointer, r Reader) Reader {
+	length, count := r.ReadVarint(), r.ReadVarint()
+
+	varints := count
+	if count != length {
+		varints *= 2 // each value is preceded by its index gap
+	}
+
+	for i := uint(0); i < varints; i++ {
+		r.SkipVarint()
+	}
+	return r
+}
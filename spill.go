@@ -0,0 +1,64 @@
+package glint
+
+import (
+	"io"
+	"unsafe"
+)
+
+// SpillBuffer writes documents to an io.Writer as they're encoded, holding no more than about threshold bytes of
+// them in memory, so that encoding very large values doesn't need the whole document in one contiguous slice. It
+// fills and spills at the same points a ChunkedBuffer starts new chunks, between structs, so it can hold more than
+// threshold by as much as a single struct writes.
+//
+// Documents written through a SpillBuffer always carry a string table, empty when nothing was written to it, as
+// their header may be written out before the body is finished. Compressed and canonical documents are held in
+// memory until they're finished, and written out whole.
+type SpillBuffer struct {
+	c ChunkedBuffer
+}
+
+// NewSpillBuffer creates a SpillBuffer that writes to w whenever more than threshold bytes are held. A threshold
+// of 0 or less uses a default of 64KiB.
+func NewSpillBuffer(w io.Writer, threshold int) *SpillBuffer {
+	if threshold <= 0 {
+		threshold = defaultChunkSize
+	}
+	return &SpillBuffer{c: ChunkedBuffer{size: threshold, w: w}}
+}
+
+// MarshalSpill encodes a value of type T through the supplied spill buffer, after any documents already written to
+// it. The end of the document may still be held by the buffer afterwards, Flush writes it out. It returns the first
+// error writing to the buffer's writer, after which nothing more is written.
+func (e *Encoder[T]) MarshalSpill(v *T, s *SpillBuffer) error {
+	e.impl.marshalChunked(unsafe.Pointer(v), &s.c)
+	if len(s.c.tail.Bytes) >= s.c.size {
+		s.c.flush()
+	}
+	return s.c.err
+}
+
+// Flush writes everything the buffer holds to its writer, returning the first error writing to it
+func (s *SpillBuffer) Flush() error {
+	s.c.flush()
+	return s.c.err
+}
+
+// Buffered returns the number of bytes held, waiting to be written
+func (s *SpillBuffer) Buffered() int {
+	return len(s.c.tail.Bytes)
+}
+
+// Written returns the number of bytes written to the buffer's writer
+func (s *SpillBuffer) Written() int64 {
+	return s.c.written
+}
+
+// flush writes the current chunk to c's writer and empties it, discarding it once writing has failed
+func (c *ChunkedBuffer) flush() {
+	if c.err == nil && len(c.tail.Bytes) > 0 {
+		n, err := c.w.Write(c.tail.Bytes)
+		c.written += int64(n)
+		c.err = err
+	}
+	c.tail.Bytes = c.tail.Bytes[:0]
+}
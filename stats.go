@@ -0,0 +1,512 @@
+package glint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// FieldStats collects statistics about the values of each field over many documents, so that how fields are
+// encoded can be tuned to the data they actually hold. Alongside counts of values, zeros and distinct values, it
+// estimates how large each field would be written with the `delta`, `sparse` and `dict` tag options, which
+// Suggestions turns into advice and Profile into options for WithFieldOptions.
+//
+// Statistics are collected from the fields of the top level struct, of nested structs and of the structs held by
+// slices. Estimates are only made from values written without the option in question, so a field already written
+// with it keeps the estimates made before it was.
+//
+// Documents without a schema, as written to a peer that trusts it, are read with the schema of an earlier
+// document with the same hash, so a FieldStats suits long lived sessions, see SchemaSession.CollectFieldStats.
+// A FieldStats is safe for concurrent use.
+type FieldStats struct {
+	mu      sync.Mutex
+	schemas map[uint32][]schemaNode
+	fields  map[string]*fieldStats
+	docs    int
+}
+
+// FieldStat holds the statistics collected for a single field, see FieldStats
+type FieldStat struct {
+	Path string   // the field's name, with the fields of nested structs following their parent's name after a dot
+	Wire WireType // the field's wire type, as last seen
+
+	Values    int // values seen, counting each element of a slice
+	Zeros     int // values that were zero, or empty strings
+	Distinct  int // distinct values seen, counting stops at maxDistinctValues
+	Pairs     int // consecutive values compared for ordering, within a slice or between documents
+	Ascending int // pairs in which the second value was no smaller than the first
+
+	Bytes       int // bytes taken by values written without any option
+	DeltaBytes  int // estimated bytes had those values been written with the `delta` option, 0 when it doesn't apply
+	SparseBytes int // as DeltaBytes, for the `sparse` option
+	DictBytes   int // as DeltaBytes, for the `dict` option, including the field's share of each string table
+}
+
+// ZeroRatio returns the fraction of values that were zero
+func (f FieldStat) ZeroRatio() float64 {
+	if f.Values == 0 {
+		return 0
+	}
+	return float64(f.Zeros) / float64(f.Values)
+}
+
+// Monotonicity returns the fraction of consecutive values that didn't decrease, 1 for a field that only ever
+// ascends
+func (f FieldStat) Monotonicity() float64 {
+	if f.Pairs == 0 {
+		return 0
+	}
+	return float64(f.Ascending) / float64(f.Pairs)
+}
+
+// TagSuggestion advises writing a field with a tag option, see FieldStats.Suggestions
+type TagSuggestion struct {
+	Path   string  // the field, as in FieldStat
+	Option string  // the tag option, "delta", "sparse" or "dict"
+	Saving float64 // the estimated fraction of the field's bytes saved by the option
+}
+
+const (
+	maxDistinctValues  = 1024 // distinct values tracked per field, beyond which a field is plainly not an enum
+	minSuggestionDocs  = 32   // documents observed before any suggestions are made
+	minSuggestedSaving = 0.2  // the smallest saving worth suggesting an option for
+)
+
+// fieldStats is the running state behind a FieldStat
+type fieldStats struct {
+	FieldStat
+	distinct map[string]struct{}
+	last     int64 // the previous value of a scalar integer, for ordering across documents
+	hasLast  bool
+	doc      int                 // the document seen belongs to, for dict estimates
+	seen     map[string]struct{} // strings seen in that document
+}
+
+// NewFieldStats creates a FieldStats that has observed nothing yet
+func NewFieldStats() *FieldStats {
+	return &FieldStats{schemas: map[uint32][]schemaNode{}, fields: map[string]*fieldStats{}}
+}
+
+// Observe adds the values of a document to the statistics. Documents without a schema need an earlier document
+// with the same schema to have been observed, and fail with ErrSchemaNotFound otherwise.
+func (s *FieldStats) Observe(doc []byte) (err error) {
+
+	defer recoverErr(&err)
+
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := binary.LittleEndian.Uint32(p.hash)
+	fields, ok := s.schemas[hash]
+	if !ok {
+		if p.schema.BytesLeft() == 0 {
+			return ErrSchemaNotFound
+		}
+		fields = parseSchemaNodes(p.schema)
+		s.schemas[hash] = fields
+	}
+
+	s.docs++
+	body := p.body
+	s.observeFields(fields, "", &body)
+	return nil
+}
+
+// learn records the schema of a document, or of a header, without observing any values
+func (s *FieldStats) learn(doc []byte) {
+	if len(doc) < 5 {
+		return
+	}
+
+	s.mu.Lock()
+	_, ok := s.schemas[binary.LittleEndian.Uint32(doc[1:5])]
+	s.mu.Unlock()
+	if ok {
+		return
+	}
+
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil || p.schema.BytesLeft() == 0 {
+		return
+	}
+	fields := parseSchemaNodes(p.schema)
+
+	s.mu.Lock()
+	s.schemas[binary.LittleEndian.Uint32(p.hash)] = fields
+	s.mu.Unlock()
+}
+
+// Documents returns the number of documents observed
+func (s *FieldStats) Documents() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.docs
+}
+
+// Fields returns the statistics of every field observed, ordered by path
+func (s *FieldStats) Fields() []FieldStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields := make([]FieldStat, 0, len(s.fields))
+	for _, f := range s.fields {
+		fields = append(fields, f.FieldStat)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields
+}
+
+// Suggestions returns the tag option expected to shrink each field the most, for the fields with an option
+// estimated to save at least a fifth of their bytes, ordered by path. Nothing is suggested until enough
+// documents have been observed for the estimates to mean something.
+func (s *FieldStats) Suggestions() []TagSuggestion {
+	if s.Documents() < minSuggestionDocs {
+		return nil
+	}
+
+	var suggestions []TagSuggestion
+	for _, f := range s.Fields() {
+		if f.Bytes == 0 {
+			continue
+		}
+
+		best := TagSuggestion{Path: f.Path, Saving: minSuggestedSaving}
+		for _, o := range []struct {
+			option string
+			bytes  int
+		}{{"delta", f.DeltaBytes}, {"sparse", f.SparseBytes}, {"dict", f.DictBytes}} {
+			if saving := 1 - float64(o.bytes)/float64(f.Bytes); o.bytes > 0 && saving >= best.Saving {
+				best.Option, best.Saving = o.option, saving
+			}
+		}
+		if best.Option != "" {
+			suggestions = append(suggestions, best)
+		}
+	}
+	return suggestions
+}
+
+// Profile returns the suggested options as a profile for WithFieldOptions. Only options that decoders read
+// without tags of their own, `sparse` and `dict`, are included - a `delta` field must be tagged at both ends, so
+// it's left to Suggestions for the developer to act on.
+func (s *FieldStats) Profile() map[string]string {
+	profile := map[string]string{}
+	for _, t := range s.Suggestions() {
+		if t.Option != "delta" {
+			profile[t.Path] = t.Option
+		}
+	}
+	return profile
+}
+
+// field returns the running state for path, creating it the first time the field is seen
+func (s *FieldStats) field(path string, wire WireType) *fieldStats {
+	f, ok := s.fields[path]
+	if !ok {
+		f = &fieldStats{FieldStat: FieldStat{Path: path}, distinct: map[string]struct{}{}}
+		s.fields[path] = f
+	}
+	f.Wire = wire
+	return f
+}
+
+// observeFields reads the values of fields from r, adding them to the statistics
+func (s *FieldStats) observeFields(fields []schemaNode, path string, r *Reader) {
+	for i := range fields {
+		s.observeValue(&fields[i], path+fields[i].name, r)
+	}
+}
+
+// observeValue reads a value described by t from r, adding it to the statistics of the field at path. Values the
+// statistics have nothing to say about are read past.
+func (s *FieldStats) observeValue(t *schemaNode, path string, r *Reader) {
+	wire := t.wire &^ WirePtrFlag
+	if t.wire&WirePtrFlag > 0 && r.ReadByte() == 0 {
+		return
+	}
+
+	switch {
+	case wire == WireStruct:
+		s.observeFields(t.fields, path+".", r)
+
+	case wire == WireSliceFlag|WireStruct:
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			s.observeFields(t.elem.fields, path+".", r)
+		}
+
+	case wire == WireSliceFlag && t.elem.wire&WirePtrFlag > 0: // slices of pointers, see formatVersionPointers
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			s.observeValue(t.elem, path, r)
+		}
+
+	case wire&WireSliceFlag > 0 && sparseWire(wire&^WireSliceFlag):
+		s.observeNumbers(s.field(path, t.wire), wire&^WireSliceFlag, r)
+
+	case wire == WireSliceFlag|WireString, wire == WireSliceFlag|WireString|WireDictFlag:
+		f := s.field(path, t.wire)
+		for i, l := uint(0), r.ReadVarint(); i < l; i++ {
+			s.observeString(f, wire&WireDictFlag > 0, true, r)
+		}
+
+	case wire == WireString, wire == WireString|WireDictFlag:
+		s.observeString(s.field(path, t.wire), wire&WireDictFlag > 0, t.wire&WirePtrFlag == 0, r)
+
+	case sparseWire(wire), wire == WireInt8, wire == WireUint8, wire == WireBool:
+		start := r.position
+		raw := fieldBytes(r, wire)
+		f := s.field(path, t.wire)
+		f.Bytes += int(r.position - start)
+		f.value(raw, isZero(raw))
+
+		if wire != WireFloat32 && wire != WireFloat64 {
+			v := numberValue(wire, raw)
+			if f.hasLast {
+				f.order(f.last, v)
+			}
+			f.last, f.hasLast = v, true
+		}
+
+	default:
+		skipValue(wire, t, r)
+	}
+}
+
+// observeNumbers reads a slice of numbers from r, estimating its size written with the `delta` and `sparse`
+// options as it goes
+func (s *FieldStats) observeNumbers(f *fieldStats, elem WireType, r *Reader) {
+	start := r.position
+	l := r.ReadVarint()
+	head := int(r.position - start)
+
+	count, dense, pairs, delta := 0, 0, 0, head
+	var prev int64
+	for i, next := uint(0), uint(0); i < l; i++ {
+		vstart := r.position
+		raw := fieldBytes(r, elem)
+		size := int(r.position - vstart)
+		dense += size
+
+		zero := isZero(raw)
+		f.value(raw, zero)
+		if !zero {
+			count++
+			pairs += varintSize(uint64(i-next)) + size
+			next = i + 1
+		}
+
+		v := numberValue(elem, raw)
+		if i == 0 {
+			delta += size
+		} else {
+			d := v - prev
+			delta += varintSize(uint64((d >> 63) ^ (d << 1)))
+			f.order(prev, v)
+		}
+		prev = v
+	}
+
+	f.Bytes += head + dense
+	f.SparseBytes += head + varintSize(uint64(count))
+	if count*2 >= int(l) {
+		f.SparseBytes += dense
+	} else {
+		f.SparseBytes += pairs
+	}
+	if elem != WireFloat32 && elem != WireFloat64 {
+		f.DeltaBytes += delta
+	}
+}
+
+// observeString reads a string from r, either inline or as a reference to the string table, estimating its size
+// written with the `dict` option when it was written inline and dict can apply
+func (s *FieldStats) observeString(f *fieldStats, dict, estimate bool, r *Reader) {
+	if dict {
+		i := r.ReadVarint()
+		v, ok := r.tableString(i)
+		if !ok {
+			panic(fmt.Sprintf("string table entry %d out of range", i))
+		}
+		f.value([]byte(v), v == "")
+		return
+	}
+
+	start := r.position
+	v := r.Read(r.ReadVarint())
+	f.Bytes += int(r.position - start)
+	f.value(v, len(v) == 0)
+	if !estimate {
+		return
+	}
+
+	if f.doc != s.docs || f.seen == nil { // the table is per document, with a count and trailing size of its own
+		f.doc, f.seen = s.docs, map[string]struct{}{}
+		f.DictBytes += 5
+	}
+	f.DictBytes += varintSize(uint64(len(f.seen)))
+	if _, ok := f.seen[string(v)]; !ok {
+		f.seen[string(v)] = struct{}{}
+		f.DictBytes += int(r.position - start)
+	}
+}
+
+// value counts a value, raw being its bytes as written
+func (f *fieldStats) value(raw []byte, zero bool) {
+	f.Values++
+	if zero {
+		f.Zeros++
+	}
+	if len(f.distinct) < maxDistinctValues {
+		f.distinct[string(raw)] = struct{}{}
+		f.Distinct = len(f.distinct)
+	}
+}
+
+// order counts a pair of consecutive values
+func (f *fieldStats) order(prev, v int64) {
+	f.Pairs++
+	if v >= prev {
+		f.Ascending++
+	}
+}
+
+// sparseWire reports whether wire is one of the numbers that slices can be sparse, and delta, encoded with
+func sparseWire(wire WireType) bool {
+	switch wire {
+	case WireInt, WireInt16, WireInt32, WireInt64, WireUint, WireUint16, WireUint32, WireUint64, WireFloat32, WireFloat64:
+		return true
+	}
+	return false
+}
+
+// numberValue returns the value of a number written as raw, as the encoders compute deltas from it
+func numberValue(wire WireType, raw []byte) int64 {
+	switch wire {
+	case WireInt8, WireUint8, WireBool:
+		if wire == WireInt8 {
+			return int64(int8(raw[0]))
+		}
+		return int64(raw[0])
+	}
+
+	r := NewReader(raw)
+	u := r.ReadVarint()
+	switch wire {
+	case WireInt, WireInt16, WireInt32:
+		return int64(u>>1) ^ -int64(u&1)
+	}
+	return int64(u)
+}
+
+// isZero reports whether a number written as raw is zero, which for both varints and single bytes is a 0 byte
+func isZero(raw []byte) bool {
+	return len(raw) == 1 && raw[0] == 0
+}
+
+// varintSize returns the number of bytes v takes written as a varint
+func varintSize(v uint64) int {
+	n := 1
+	for ; v >= 0b10000000; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// statsSampler hands every nth document of a session to a FieldStats, see SchemaSession.CollectFieldStats
+type statsSampler struct {
+	stats *FieldStats
+	every uint64
+	n     atomic.Uint64
+}
+
+// sample reports whether the next document should be observed
+func (c *statsSampler) sample() bool {
+	return c.n.Add(1)%c.every == 0
+}
+
+// adaptiveEncoding is an encoder built with a profile, see AdaptiveEncoder
+type adaptiveEncoding[T any] struct {
+	encoder *Encoder[T]
+	profile map[string]string
+}
+
+// AdaptiveEncoder keeps an Encoder built with the profile a FieldStats suggests, rebuilding it as the suggestions
+// change. A new profile gives the encoder's documents a new schema hash, so a SchemaSession sends the schema in
+// full again until the peer acknowledges it - the peer's decoders read the options from the schema, without any
+// of their own, so the switch needs nothing more from either end.
+//
+// An AdaptiveEncoder is safe for concurrent use.
+type AdaptiveEncoder[T any] struct {
+	stats   *FieldStats
+	opts    []EncoderOption
+	current atomic.Pointer[adaptiveEncoding[T]]
+	mu      sync.Mutex   // held while adapting
+	checked atomic.Int64 // the documents stats had observed when suggestions were last checked
+}
+
+// NewAdaptiveEncoder creates an AdaptiveEncoder for T, building its encoders with opts as well as the profile
+// suggested by stats
+func NewAdaptiveEncoder[T any](stats *FieldStats, opts ...EncoderOption) *AdaptiveEncoder[T] {
+	a := &AdaptiveEncoder[T]{stats: stats, opts: opts}
+	a.current.Store(&adaptiveEncoding[T]{encoder: NewEncoder[T](opts...), profile: map[string]string{}})
+	a.Adapt()
+	return a
+}
+
+// Encoder returns the encoder built with the current profile
+func (a *AdaptiveEncoder[T]) Encoder() *Encoder[T] {
+	return a.current.Load().encoder
+}
+
+// Profile returns the profile the current encoder was built with
+func (a *AdaptiveEncoder[T]) Profile() map[string]string {
+	profile := map[string]string{}
+	for path, opts := range a.current.Load().profile {
+		profile[path] = opts
+	}
+	return profile
+}
+
+// Adapt rebuilds the encoder when the profile suggested by the FieldStats has changed, reporting whether it did
+func (a *AdaptiveEncoder[T]) Adapt() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.checked.Store(int64(a.stats.Documents()))
+	profile := a.stats.Profile()
+	if sameProfile(profile, a.current.Load().profile) {
+		return false
+	}
+
+	opts := append(append([]EncoderOption{}, a.opts...), WithFieldOptions(profile))
+	a.current.Store(&adaptiveEncoding[T]{encoder: NewEncoder[T](opts...), profile: profile})
+	return true
+}
+
+// MarshalWithSession encodes a value like Encoder.MarshalWithSession, first adapting the encoder once enough
+// documents have been observed since suggestions were last checked
+func (a *AdaptiveEncoder[T]) MarshalWithSession(v *T, b *Buffer, s *SchemaSession) {
+	if int64(a.stats.Documents()) >= a.checked.Load()+minSuggestionDocs {
+		a.Adapt()
+	}
+	a.Encoder().MarshalWithSession(v, b, s)
+}
+
+// sameProfile reports whether two profiles hold the same options
+func sameProfile(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, opts := range a {
+		if o, ok := b[path]; !ok || o != opts {
+			return false
+		}
+	}
+	return true
+}
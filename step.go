@@ -0,0 +1,160 @@
+package glint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// StepBudget bounds the work done by a single call to StepDecoder.Step. A zero value in either field means no
+// limit on it, and a zero StepBudget decodes the rest of the document in one step. Every step decodes at least one
+// field, so that a budget spent before the step began still makes progress.
+type StepBudget struct {
+	Fields   int           // most fields to decode, counting the fields of nested structs rather than the structs
+	Duration time.Duration // time after which the step stops, checked after each field
+}
+
+// StepDecoder decodes a single document a few fields at a time, for loops that can't afford to stall on a large
+// document, such as games or audio callbacks. Each call to Step picks up where the last one left off.
+//
+// Work is divided between fields, descending into nested structs, so a step can overrun its Duration by the time
+// it takes to decode one field. Slices and maps are single fields however large they are, so a document with one
+// huge slice gains nothing from being decoded in steps.
+//
+// The value being decoded into is only complete once Step reports that it's done, and neither it nor the document
+// may be used for anything else until then. A StepDecoder isn't safe for concurrent use.
+type StepDecoder[T any] struct {
+	impl   *decoderImpl
+	doc    []byte
+	v      *T
+	body   Reader
+	frames []stepFrame // the struct being decoded, innermost last
+	fields int
+	done   bool
+	err    error
+}
+
+// stepFrame is a struct part way through being decoded
+type stepFrame struct {
+	d            *decoderImpl
+	instructions []decodeInstruction
+	next         int
+	p            unsafe.Pointer
+}
+
+// Steps prepares doc to be decoded into v a step at a time, see StepDecoder. The document's header and schema are
+// read up front, the body is left for Step.
+func (d *Decoder[T]) Steps(doc []byte, v *T) (*StepDecoder[T], error) {
+	s := &StepDecoder[T]{impl: d.impl, doc: doc, v: v}
+
+	if len(doc) < 5 {
+		return nil, ErrInvalidDocument
+	}
+	if d.impl.numfield == 0 {
+		s.done = true
+		return s, nil
+	}
+
+	instructions, body, err := d.impl.stepInstructions(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	body.copies = d.impl.copies
+	s.body = body
+	s.frames = []stepFrame{{d: d.impl, instructions: instructions, p: unsafe.Pointer(v)}}
+	return s, nil
+}
+
+// Step decodes fields until the budget runs out or the document is finished, reporting whether it's finished.
+// Once Step has returned an error it returns the same error from then on.
+func (s *StepDecoder[T]) Step(budget StepBudget) (done bool, err error) {
+	if s.done || s.err != nil {
+		return s.done, s.err
+	}
+
+	defer func() {
+		if err != nil && s.err == nil { // a malformed body panicked part way through, which every later Step returns
+			done, s.err = false, err
+		}
+	}()
+	defer recoverErr(&err)
+
+	var deadline time.Time
+	if budget.Duration > 0 {
+		deadline = time.Now().Add(budget.Duration)
+	}
+
+	for fields := 0; len(s.frames) > 0; {
+		f := &s.frames[len(s.frames)-1]
+		if f.next == len(f.instructions) {
+			s.frames = s.frames[:len(s.frames)-1]
+			continue
+		}
+
+		if fields > 0 && ((budget.Fields > 0 && fields == budget.Fields) || (!deadline.IsZero() && !time.Now().Before(deadline))) {
+			return false, nil
+		}
+
+		di := &f.instructions[f.next]
+		f.next++
+
+		if sd, ok := di.subdec.(*decoderImpl); ok && di.kind == WireStruct && di.subType != nil {
+			s.frames = append(s.frames, stepFrame{d: sd, instructions: di.subinstr, p: unsafe.Add(f.p, di.offset)})
+			continue
+		}
+
+		s.body = f.d.unmarshal(s.body, f.instructions[f.next-1:f.next], f.p)
+		s.fields++
+		fields++
+	}
+
+	s.done = true
+	if len(s.body.Remaining()) > 0 {
+		s.err = fmt.Errorf("body bytes remaining > 0: %v", len(s.body.Remaining()))
+	} else if s.impl.validate != nil {
+		s.err = s.impl.checkConstraints(s.doc, s.v)
+	}
+	return true, s.err
+}
+
+// Fields returns the number of fields decoded so far
+func (s *StepDecoder[T]) Fields() int {
+	return s.fields
+}
+
+// stepInstructions returns the instructions for a document and its body, as UnmarshalWithContext finds them
+func (d *decoderImpl) stepInstructions(doc []byte) ([]decodeInstruction, Reader, error) {
+	parts, err := splitDocument(doc)
+	if err != nil {
+		return nil, Reader{}, err
+	}
+	d.lastHash = binary.LittleEndian.Uint32(parts.hash)
+
+	if instructions, ok := d.cache.get(parts.hash); ok {
+		return instructions, parts.body, nil
+	}
+
+	if parts.schema.BytesLeft() == 0 {
+		if d.registry == nil {
+			return nil, Reader{}, ErrSchemaNotFound
+		}
+		if parts.schema, err = d.registry.resolve(parts); err != nil {
+			return nil, Reader{}, err
+		}
+	}
+	if err := parts.inlineSchema(d.limits); err != nil {
+		return nil, Reader{}, err
+	}
+	if err := checkSchema(parts.schema, d.limits); err != nil {
+		return nil, Reader{}, err
+	}
+
+	instructions, _, err := d.parseNamedSchema(parts.schema, parts.names, nil)
+	if err != nil {
+		return nil, Reader{}, err
+	}
+	d.cache.add(parts.hash, instructions, nil, 0)
+	return instructions, parts.body, nil
+}
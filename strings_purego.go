@@ -0,0 +1,9 @@
+//go:build purego
+
+package glint
+
+// bytesToString returns a copy of b as a string. Built without the purego tag, strings share memory with the
+// document instead.
+func bytesToString(b []byte) string {
+	return string(b)
+}
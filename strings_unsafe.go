@@ -0,0 +1,11 @@
+//go:build !purego
+
+package glint
+
+import "unsafe"
+
+// bytesToString returns a string sharing memory with b, so decoding a string doesn't copy it out of the document.
+// The string is only valid for as long as b isn't modified. Building with the purego tag copies instead.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
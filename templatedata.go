@@ -0,0 +1,240 @@
+package glint
+
+import (
+	"fmt"
+	"time"
+)
+
+// DocumentTemplateData reads a document into plain Go values for use as the data of a text/template or
+// html/template, without needing the type it was written from. Structs become map[string]any keyed by field name,
+// maps become map[string]any keyed by their keys formatted with fmt, and slices become []any, so templates
+// reach into documents as they would into decoded JSON, e.g. {{.user.name}} or {{index .tags 0}}.
+//
+// Signed integers are read as int, other than int64 which stays int64, and unsigned integers likewise as uint
+// and uint64. Strings, bools, floats, bytes, times, durations, addresses and big numbers are read as their own
+// types. Nil pointers and slices are nil.
+//
+// Documents without a schema, as written to a peer that trusts it, fail with ErrSchemaNotFound.
+func DocumentTemplateData(doc []byte) (data any, err error) {
+
+	defer recoverErr(&err)
+
+	p, err := Document(doc).parts(DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	if p.schema.BytesLeft() == 0 {
+		return nil, ErrSchemaNotFound
+	}
+
+	body := p.body
+	fields := templateFields(parseSchemaNodes(p.schema), &body)
+	if body.BytesLeft() > 0 {
+		return nil, fmt.Errorf("%w: body bytes remaining > 0: %v", ErrInvalidDocument, body.BytesLeft())
+	}
+	return fields, nil
+}
+
+// templateFields reads the values of fields from r into a map keyed by field name
+func templateFields(fields []schemaNode, r *Reader) map[string]any {
+	m := make(map[string]any, len(fields))
+	for i := range fields {
+		m[fields[i].name] = templateValue(&fields[i], r)
+	}
+	return m
+}
+
+// templateValue reads a value described by t from r, see DocumentTemplateData
+func templateValue(t *schemaNode, r *Reader) any {
+	wire := t.wire &^ WirePtrFlag
+	if t.wire&WirePtrFlag > 0 && r.ReadByte() == 0 {
+		return nil
+	}
+
+	switch {
+	case wire&WireBitmapFlag > 0:
+		values := r.ReadBitmap()
+		s := make([]any, len(values))
+		for i, v := range values {
+			s[i] = v
+		}
+		return s
+
+	case wire&WireSparseFlag > 0:
+		return templateSparse(t.elem.wire, r)
+
+	case wire&WireDeltaFlag > 0:
+		return templateDelta(t.elem.wire, r)
+
+	case wire&WireSliceFlag > 0:
+		l := r.ReadVarint()
+		checkLimit(l, DefaultLimits.MaxSliceElements, "slice")
+
+		s := make([]any, 0, min(l, r.BytesLeft())) // elements can take no bytes at all, so grow into longer slices
+		for i := uint(0); i < l; i++ {
+			if wire&WireDictFlag > 0 {
+				s = append(s, r.ReadDictString())
+				continue
+			}
+			s = append(s, templateValue(t.elem, r))
+		}
+		return s
+
+	case wire == WireStruct:
+		return templateFields(t.fields, r)
+
+	case wire == WireMap:
+		m := map[string]any{}
+		for i, l := uint(0), readMapLength(r, DefaultLimits.MaxMapEntries); i < l; i++ {
+			key := templateValue(t.key, r)
+			m[fmt.Sprint(key)] = templateValue(t.value, r)
+		}
+		return m
+
+	case wire == WireString|WireDictFlag:
+		return r.ReadDictString()
+
+	case wire == WireInt64|WireZigzagFlag:
+		return r.ReadZigzagInt64()
+
+	case wire == WireDuration|WireZigzagFlag:
+		return time.Duration(r.ReadZigzagInt64())
+	}
+
+	return templatePrimitive(wire, r)
+}
+
+// templatePrimitive reads a single value of a primitive wire type from r
+func templatePrimitive(wire WireType, r *Reader) any {
+	switch wire {
+	case WireBool:
+		return r.ReadBool()
+	case WireInt:
+		return r.ReadInt()
+	case WireInt8:
+		return int(r.ReadInt8())
+	case WireInt16:
+		return int(r.ReadInt16())
+	case WireInt32:
+		return int(r.ReadInt32())
+	case WireInt64:
+		return r.ReadInt64()
+	case WireUint:
+		return r.ReadUint()
+	case WireUint8:
+		return uint(r.ReadUint8())
+	case WireUint16:
+		return uint(r.ReadUint16())
+	case WireUint32:
+		return uint(r.ReadUint32())
+	case WireUint64:
+		return r.ReadUint64()
+	case WireFloat32:
+		return r.ReadFloat32()
+	case WireFloat64:
+		return r.ReadFloat64()
+	case WireString:
+		return r.ReadString()
+	case WireBytes:
+		return r.Read(r.ReadVarint())
+	case WireTime:
+		return r.ReadTime()
+	case WireDuration:
+		return r.ReadDuration()
+	case WireIP:
+		return r.ReadAddr()
+	case WireIPPrefix:
+		return r.ReadPrefix()
+	case WireBigInt:
+		return r.ReadBigInt()
+	case WireDecimal:
+		return r.ReadDecimal()
+	case WireDynamic:
+		_, value := readDynamic(r)
+		return value
+	}
+	panic(fmt.Sprintf("unsupported wire type %v", wire))
+}
+
+// templateSparse reads a sparse slice of elem from r, zeros included
+func templateSparse(elem WireType, r *Reader) []any {
+	length, count := r.ReadVarint(), r.ReadVarint()
+	checkLimit(length, DefaultLimits.MaxSliceElements, "sparse slice")
+	if count > length {
+		panic(fmt.Sprintf("sparse slice holds %d values but has a length of %d", count, length))
+	}
+
+	// every sparse element type encodes its zero value as a single zero byte
+	s := make([]any, length)
+	for i := range s {
+		zero := NewReader([]byte{0})
+		s[i] = templatePrimitive(elem, &zero)
+	}
+
+	for i, next := uint(0), uint(0); i < count; i, next = i+1, next+1 {
+		if count != length {
+			next += r.ReadVarint()
+		}
+		if next >= length {
+			panic(fmt.Sprintf("sparse slice index %d out of range for length %d", next, length))
+		}
+		s[next] = templatePrimitive(elem, r)
+	}
+	return s
+}
+
+// templateDelta reads a delta encoded slice of elem from r
+func templateDelta(elem WireType, r *Reader) []any {
+	l := r.ReadVarint()
+	if l > r.BytesLeft() { // every element takes at least a byte
+		panic(fmt.Errorf("%w: delta slice length %d exceeds remaining bytes %d", ErrTruncatedDocument, l, r.BytesLeft()))
+	}
+
+	s := make([]any, l)
+	if l == 0 {
+		return s
+	}
+
+	switch elem {
+	case WireFloat32:
+		prev := r.ReadFloat32()
+		s[0] = prev
+		for i := 1; i < len(s); i++ {
+			prev = r.ReadFloat32Delta(prev)
+			s[i] = prev
+		}
+		return s
+
+	case WireFloat64:
+		prev := r.ReadFloat64()
+		s[0] = prev
+		for i := 1; i < len(s); i++ {
+			prev = r.ReadFloat64Delta(prev)
+			s[i] = prev
+		}
+		return s
+	}
+
+	v := numberValue(elem, fieldBytes(r, elem))
+	for i := range s {
+		if i > 0 {
+			v += int64(r.ReadZigzagVarint())
+		}
+
+		switch elem {
+		case WireInt, WireInt16, WireInt32:
+			s[i] = int(v)
+		case WireInt64:
+			s[i] = v
+		case WireUint, WireUint16, WireUint32:
+			s[i] = uint(v)
+		case WireUint64:
+			s[i] = uint64(v)
+		case WireDuration:
+			s[i] = time.Duration(v)
+		default:
+			panic(fmt.Sprintf("delta encoding not supported for %v", elem))
+		}
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+package glint
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"unsafe"
+)
+
+// TraceStep records a single value read while decoding a document
+type TraceStep struct {
+	Name   string   // field name, as written in the document's schema
+	Wire   WireType // wire type from the schema
+	Offset int      // offset of the value from the start of the document
+	Length int      // bytes consumed reading the value
+	Path   string   // field the value was decoded into, e.g. "user.name", empty when the value was skipped
+}
+
+// DecodeTrace is the sequence of values read while decoding a document, in the order they were read. Fields of
+// nested structs are traced one by one, while slices, maps and pointers to structs are traced as a single value.
+// Fields set from a default= option appear with no wire type and no length.
+type DecodeTrace []TraceStep
+
+// String formats the trace as a table, one step per line
+func (t DecodeTrace) String() string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "OFFSET\tLENGTH\tNAME\tWIRE\tPATH")
+	for _, s := range t {
+		path, wire := s.Path, s.Wire.String()
+		if path == "" {
+			path = "(skipped)"
+		}
+		if s.Wire == 0 {
+			wire = "(default)"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", s.Offset, s.Length, s.Name, wire, path)
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// UnmarshalWithTrace decodes a document into v as Unmarshal does, recording every value read along the way. It's
+// much slower than Unmarshal and is meant for debugging, to see exactly where a document and the decoder parted
+// ways. Malformed bodies return an error wrapping ErrInvalidDocument rather than panicking, along with the trace
+// up to the value that couldn't be read.
+func (d *Decoder[T]) UnmarshalWithTrace(bytes []byte, v *T) (DecodeTrace, error) {
+	return d.impl.unmarshalWithTrace(bytes, v)
+}
+
+// unmarshalWithTrace follows the same steps as UnmarshalWithContext, but runs the instructions one at a time.
+// The schema is always parsed afresh when the document has one, so the trace can report the wire type written for
+// each field, documents without a schema fall back to the instruction cache.
+func (d *decoderImpl) unmarshalWithTrace(doc []byte, s any) (trace DecodeTrace, err error) {
+	if len(doc) < 5 {
+		return nil, ErrInvalidDocument
+	}
+	if d.numfield == 0 {
+		return nil, nil
+	}
+
+	parts, err := splitDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var instructions []decodeInstruction
+	if parts.schema.BytesLeft() == 0 && d.registry == nil {
+		var ok bool
+		if instructions, ok = d.cache.get(parts.hash); !ok {
+			return nil, ErrSchemaNotFound
+		}
+	} else {
+		if parts.schema.BytesLeft() == 0 {
+			if parts.schema, err = d.registry.resolve(parts); err != nil {
+				return nil, err
+			}
+		}
+		if err := parts.inlineSchema(d.limits); err != nil {
+			return nil, err
+		}
+		if err := checkSchema(parts.schema, d.limits); err != nil {
+			return nil, err
+		}
+		if instructions, _, err = d.parseSchema(parts.schema, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	t := tracer{doc: doc}
+	body, err := t.traceBody(d, parts.body, parts.schema, instructions, (*iface)(unsafe.Pointer(&s)).Data)
+	if err != nil { // report where the body was malformed alongside the trace so far
+		return t.steps, fmt.Errorf("reading %q at offset %d: %w", t.current.Name, t.current.Offset, err)
+	}
+	if len(body.Remaining()) > 0 {
+		return t.steps, fmt.Errorf("body bytes remaining > 0: %v", len(body.Remaining()))
+	}
+
+	if d.validate != nil {
+		return t.steps, d.checkConstraints(doc, s)
+	}
+
+	return t.steps, nil
+}
+
+// tracer records the steps taken while decoding a single document
+type tracer struct {
+	doc     []byte
+	steps   DecodeTrace
+	current TraceStep // the step being read, reported when reading it fails
+}
+
+// traceBody traces the body of a document, returning the panic of a malformed body as its error
+func (t *tracer) traceBody(d *decoderImpl, body Reader, schema Reader, instructions []decodeInstruction, p unsafe.Pointer) (_ Reader, err error) {
+	defer recoverErr(&err)
+	return t.trace(d, body, schema, instructions, p, ""), nil
+}
+
+// trace runs a list of instructions against the body one at a time, recording a step for each. schema holds the
+// schema fields the instructions were built from, and may be empty when the document didn't carry one.
+func (t *tracer) trace(d *decoderImpl, body Reader, schema Reader, instructions []decodeInstruction, p unsafe.Pointer, prefix string) Reader {
+	for i := range instructions {
+		di := &instructions[i]
+
+		t.current = TraceStep{Name: di.tag, Wire: di.kind &^ (wireSkip | wireCustom), Offset: len(t.doc) - int(body.BytesLeft())}
+		if di.subType != nil {
+			t.current.Path = prefix + di.tag
+		}
+
+		// defaults follow the fields in the schema, and have no entry of their own
+		var sub Reader // the sub-schema of a struct field, for tracing its fields
+		if schema.BytesLeft() > 0 {
+			t.current.Wire = WireType(schema.ReadVarint())
+			schema.Skip(uint(schema.ReadByte()))
+			if t.current.Wire == WireStruct {
+				sub = NewReader(schema.Read(schema.ReadVarint()))
+			} else {
+				parseSchemaNode(t.current.Wire, &schema)
+			}
+		}
+
+		if sd, ok := di.subdec.(*decoderImpl); ok && di.kind == WireStruct && di.subType != nil {
+			body = t.trace(sd, body, sub, di.subinstr, unsafe.Add(p, di.offset), t.current.Path+".")
+			continue
+		}
+
+		body = d.unmarshal(body, instructions[i:i+1], p)
+		t.current.Length = len(t.doc) - int(body.BytesLeft()) - t.current.Offset
+		t.steps = append(t.steps, t.current)
+	}
+
+	return body
+}
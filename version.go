@@ -0,0 +1,223 @@
+package glint
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The flags byte at the start of every document is split in two. The lowest bits carry the version of the
+// document layout, the remaining bits are feature flags. Every bit is in use, so changes that can't be described
+// by a new codec or wire type take a new version, which decoders that predate it refuse with ErrUnsupportedVersion
+// rather than misreading.
+const (
+	flagVersionMask byte = 0b00000111 // format version of the document layout
+	flagStringTable byte = 0b00001000 // the body is followed by a string table, see dict.go
+	flagCodecMask   byte = 0b11110000 // codec the body is compressed with, see compress.go
+
+	formatVersionOriginal   = 0 // the original layout
+	formatVersionSchemaRefs = 1 // the original layout, with repeated struct schemas written as references
+	formatVersionConstraint = 2 // version 1, with field constraints carried in the schema
+	formatVersionNameHashes = 3 // version 2, with a hash of each top level field name following the schema
+	formatVersionPointers   = 4 // version 3, with the presence byte of each element of a slice of pointers in the schema
+
+	// decoders reading version 1 also read slices written with a presence byte, which they learned first, so
+	// documents written with WithDistinctNilSlices are marked as version 1 when they don't need anything newer
+	formatVersionNilSlices = formatVersionSchemaRefs
+
+	// the newest version written by the encoders in this package. Documents are still written as version 0
+	// when they don't need anything newer, so they stay readable by older decoders.
+	currentFormatVersion = formatVersionPointers
+)
+
+// MaxFormatVersion is the newest format version this package reads and writes. Encoders writing to decoders from an
+// older release can hold their documents to that release's MaxFormatVersion with WithFormatVersion.
+const MaxFormatVersion = currentFormatVersion
+
+// ErrUnsupportedVersion is returned when a document declares a format version this package doesn't know how to read
+var ErrUnsupportedVersion = errors.New("unsupported glint document version")
+
+// FormatVersion returns the format version of a document's layout, which decoders need to support in order to read
+// it. Documents with a version newer than MaxFormatVersion return it along with ErrUnsupportedVersion.
+func FormatVersion(doc []byte) (int, error) {
+	if len(doc) < 5 {
+		return 0, ErrInvalidDocument
+	}
+
+	version := doc[0] & flagVersionMask
+	if formatVersions[version] == nil {
+		return int(version), fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	return int(version), nil
+}
+
+// WithFormatVersion holds the documents an encoder writes to format version v or older, so that decoders from a
+// release whose MaxFormatVersion is v can read them. Anything needing a newer version is left out of the documents
+//   - below version 1, repeated struct schemas are written in full, as with WithInlineSchemas, and nil slices are
+//     written as empty ones, as if without WithDistinctNilSlices
+//   - below version 2, field constraints are left out of the schema, so validating decoders don't check them
+//   - below version 3, WithNameHashes has no effect
+//   - below version 4, slices of pointers to structs are written as slices of structs, with nothing in the schema
+//     marking the presence byte before each element, so they only decode into slices of pointers
+//
+// Versions outside 0 to MaxFormatVersion panic when the encoder is built.
+func WithFormatVersion(v int) EncoderOption {
+	return func(c *encoderConfig) {
+		if v < 0 || v > MaxFormatVersion {
+			panic(fmt.Errorf("%w: %d", ErrUnsupportedVersion, v))
+		}
+		c.maxFormatVersion = byte(v)
+	}
+}
+
+// documentParts holds the top level sections of a document once its layout has been resolved.
+type documentParts struct {
+	flags   byte
+	hash    []byte
+	schema  Reader
+	names   Reader // hashes of the top level field names, only present in version 3 documents, see namehash.go
+	body    Reader
+	written []byte // the body as it's written in the document, before it's decompressed or its string table removed
+}
+
+// formatVersion describes how to read the documents written by one version of the format.
+//
+// When the layout of a document changes the version is bumped and a new entry is added to `formatVersions`.
+// Entries for old versions must never be removed or changed, archived documents rely on them to stay readable.
+type formatVersion struct {
+	// split separates a document into its sections
+	split func(r Reader) (documentParts, error)
+
+	// upgrade, when set, rewrites the schema and body of an older document into the current layout. This
+	// keeps the hot decode paths specialised on the current layout, with older documents paying for the
+	// conversion instead.
+	upgrade func(documentParts) (documentParts, error)
+}
+
+// formatVersions is indexed by the version bits of the flags byte.
+var formatVersions = [flagVersionMask + 1]*formatVersion{
+	formatVersionOriginal:   {split: splitV0},
+	formatVersionSchemaRefs: {split: splitV0}, // references are expanded by inlineSchema, once a schema is parsed
+	formatVersionConstraint: {split: splitV0}, // constraints are dropped by inlineSchema along with the references
+	formatVersionNameHashes: {split: splitV3},
+	formatVersionPointers:   {split: splitV3}, // documents without name hashes carry an empty list of them
+}
+
+// markedPointers reports whether a list of schema fields has a slice of pointers, whose elements are marked in the
+// schema from version 4
+func markedPointers(fields []byte) bool {
+	s := schemaRefs{keepConstraints: true}
+	s.fields(NewReader(fields), nil)
+	return s.pointers
+}
+
+// splitV0 reads the original layout: [flags][crc32][schema length][schema][body]
+func splitV0(r Reader) (documentParts, error) {
+	var p documentParts
+
+	if r.BytesLeft() < 6 {
+		return p, ErrInvalidDocument
+	}
+
+	p.flags = r.ReadByte()
+	p.hash = r.Read(4)
+
+	l := r.ReadVarint()
+	if l > r.BytesLeft() {
+		return p, fmt.Errorf("%w: schema length %d exceeds document length", ErrInvalidDocument, l)
+	}
+
+	p.schema = NewReader(r.Read(l))
+	p.body = NewReader(r.Remaining())
+
+	return p, nil
+}
+
+// splitV3 reads the version 3 layout, which follows the schema with the hashes of its field names:
+// [flags][crc32][schema length][schema][names length][names][body]. Documents without a schema, in trusted schema
+// mode or carrying only a schema ID, have no names either.
+func splitV3(r Reader) (documentParts, error) {
+	p, err := splitV0(r)
+	if err != nil || p.schema.BytesLeft() == 0 {
+		return p, err
+	}
+
+	l := p.body.ReadVarint()
+	if l > p.body.BytesLeft() {
+		return p, fmt.Errorf("%w: name hashes length %d exceeds document length", ErrInvalidDocument, l)
+	}
+
+	p.names = NewReader(p.body.Read(l))
+	p.body = NewReader(p.body.Remaining())
+	return p, nil
+}
+
+// splitDocument resolves the format version of a document and separates it into its sections, upgrading
+// older layouts as required.
+func splitDocument(doc []byte) (documentParts, error) {
+	if len(doc) < 5 {
+		return documentParts{}, ErrInvalidDocument
+	}
+
+	version := doc[0] & flagVersionMask
+	if version <= formatVersionConstraint {
+		p, err := splitV0(NewReader(doc)) // the common case, called directly to keep it off the indirect path
+		if err != nil {
+			return p, err
+		}
+		return p, p.splitBody()
+	}
+
+	v := formatVersions[version]
+	if v == nil {
+		return documentParts{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	p, err := v.split(NewReader(doc))
+	if err == nil {
+		err = p.splitBody()
+	}
+	if err != nil || v.upgrade == nil {
+		return p, err
+	}
+
+	return v.upgrade(p)
+}
+
+// splitBody decompresses the body, and separates any string table from the end of it. A document that is only a
+// header, as containers and registries keep them, has no body to do either to.
+func (p *documentParts) splitBody() error {
+	p.written = p.body.Remaining()
+	if p.flags&(flagStringTable|flagCodecMask) == 0 || len(p.written) == 0 {
+		return nil
+	}
+
+	if codec := Codec(p.flags >> 4); codec != CodecNone {
+		body, err := decompressBody(codec, p.written)
+		if err != nil {
+			return err
+		}
+		p.body = NewReader(body)
+	}
+
+	if p.flags&flagStringTable > 0 {
+		return p.splitStringTable()
+	}
+	return nil
+}
+
+// inlineSchema expands any struct schemas written as references, and drops any field constraints, leaving a
+// schema that can be parsed without knowing about either. It's only needed when a schema is actually parsed, so
+// it's left out of splitDocument to keep decodes that hit the instruction cache from paying for it.
+func (p *documentParts) inlineSchema(limits DecodeLimits) error {
+	if v := p.flags & flagVersionMask; v == formatVersionOriginal {
+		return nil
+	}
+
+	schema, err := inlineSchemaRefs(p.schema.Remaining(), limits)
+	if err != nil {
+		return err
+	}
+
+	p.schema = NewReader(schema)
+	return nil
+}
@@ -0,0 +1,495 @@
+package glint
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"time"
+)
+
+// The visitors in this file wrap other visitors, so that a single walk over a document can drive several
+// consumers at once, e.g. collecting metrics while transcoding.
+
+// ChainVisitors returns a visitor that passes every callback to each of the supplied visitors in turn.
+//
+// Each visitor is handed the same body for a field. The body is advanced by the first visitor that reads the
+// field, the others are expected to read the same number of bytes or return ErrSkipVisit. The field is only
+// skipped when every visitor skips it. The first error returned by any visitor is returned by the chain. The chain
+// is a MapVisitor when every visitor is one, and skips maps otherwise.
+func ChainVisitors(visitors ...Visitor) Visitor {
+	for _, v := range visitors {
+		if _, ok := v.(MapVisitor); !ok {
+			return chainVisitor(visitors)
+		}
+	}
+	return chainMapVisitor{visitors}
+}
+
+// chainVisitor is the Visitor returned by ChainVisitors
+type chainVisitor []Visitor
+
+// each calls fn for every visitor in the chain, returning the first error
+func (c chainVisitor) each(fn func(Visitor) error) error {
+	var first error
+	for _, v := range c {
+		if err := fn(v); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (c chainVisitor) VisitFlags(flags byte) error {
+	return c.each(func(v Visitor) error { return v.VisitFlags(flags) })
+}
+
+func (c chainVisitor) VisitSchemaHash(hash []byte) error {
+	return c.each(func(v Visitor) error { return v.VisitSchemaHash(hash) })
+}
+
+func (c chainVisitor) VisitField(name string, wire WireType, body Reader) (Reader, error) {
+	return c.read(body, func(v Visitor) (Reader, error) { return v.VisitField(name, wire, body) })
+}
+
+// read passes a field or map key to every visitor in the chain, see ChainVisitors
+func (c chainVisitor) read(body Reader, fn func(Visitor) (Reader, error)) (Reader, error) {
+	next, read := body, false
+
+	for _, v := range c {
+		r, err := fn(v)
+		switch err {
+		case ErrSkipVisit:
+		case nil:
+			if !read {
+				next, read = r, true
+			}
+		default:
+			return r, err
+		}
+	}
+
+	if !read {
+		return body, ErrSkipVisit
+	}
+	return next, nil
+}
+
+func (c chainVisitor) VisitArrayStart(name string, wire WireType, length int) error {
+	return c.each(func(v Visitor) error { return v.VisitArrayStart(name, wire, length) })
+}
+
+func (c chainVisitor) VisitArrayEnd(name string) error {
+	return c.each(func(v Visitor) error { return v.VisitArrayEnd(name) })
+}
+
+func (c chainVisitor) VisitStructStart(name string) error {
+	return c.each(func(v Visitor) error { return v.VisitStructStart(name) })
+}
+
+func (c chainVisitor) VisitStructEnd(name string) error {
+	return c.each(func(v Visitor) error { return v.VisitStructEnd(name) })
+}
+
+// chainMapVisitor is the Visitor returned by ChainVisitors when every visitor is a MapVisitor
+type chainMapVisitor struct {
+	chainVisitor
+}
+
+func (c chainMapVisitor) VisitMapStart(name string, key, value WireType, length int) error {
+	return c.each(func(v Visitor) error { return v.(MapVisitor).VisitMapStart(name, key, value, length) })
+}
+
+func (c chainMapVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	return c.read(body, func(v Visitor) (Reader, error) { return v.(MapVisitor).VisitMapKey(wire, body) })
+}
+
+func (c chainMapVisitor) VisitMapEnd(name string) error {
+	return c.each(func(v Visitor) error { return v.(MapVisitor).VisitMapEnd(name) })
+}
+
+// FilterVisitor returns a visitor that only passes on the fields, structs, slices and maps that pred accepts.
+// Rejected structs, slices and maps are hidden along with everything nested within them. Slice elements and map
+// keys and values have no name of their own, and are passed on whenever their slice or map is.
+//
+// Structs are offered to pred with a wire type of WireStruct, and maps with WireMap. The filter is a MapVisitor
+// when v is one, and skips maps otherwise.
+func FilterVisitor(pred func(name string, wire WireType) bool, v Visitor) Visitor {
+	if _, ok := v.(MapVisitor); ok {
+		return filterMapVisitor{&filterVisitor{pred: pred, v: v}}
+	}
+	return &filterVisitor{pred: pred, v: v}
+}
+
+// filterVisitor is the Visitor returned by FilterVisitor
+type filterVisitor struct {
+	pred func(name string, wire WireType) bool
+	v    Visitor
+
+	hidden int // depth within a rejected struct, slice or map, 0 when outside of one
+}
+
+// hide reports whether a struct, slice or map should be hidden, tracking the depth of any that are
+func (f *filterVisitor) hide(name string, wire WireType) bool {
+	if f.hidden > 0 || (name != "" && !f.pred(name, wire)) {
+		f.hidden++
+		return true
+	}
+	return false
+}
+
+// unhide reports whether the end of a struct, slice or map was hidden, leaving its container when it was
+func (f *filterVisitor) unhide() bool {
+	if f.hidden > 0 {
+		f.hidden--
+		return true
+	}
+	return false
+}
+
+func (f *filterVisitor) VisitFlags(flags byte) error {
+	return f.v.VisitFlags(flags)
+}
+
+func (f *filterVisitor) VisitSchemaHash(hash []byte) error {
+	return f.v.VisitSchemaHash(hash)
+}
+
+func (f *filterVisitor) VisitField(name string, wire WireType, body Reader) (Reader, error) {
+	if f.hidden > 0 || (name != "" && !f.pred(name, wire)) {
+		return body, ErrSkipVisit
+	}
+	return f.v.VisitField(name, wire, body)
+}
+
+func (f *filterVisitor) VisitArrayStart(name string, wire WireType, length int) error {
+	if f.hide(name, wire) {
+		return nil
+	}
+	return f.v.VisitArrayStart(name, wire, length)
+}
+
+func (f *filterVisitor) VisitArrayEnd(name string) error {
+	if f.unhide() {
+		return nil
+	}
+	return f.v.VisitArrayEnd(name)
+}
+
+func (f *filterVisitor) VisitStructStart(name string) error {
+	if f.hide(name, WireStruct) {
+		return nil
+	}
+	return f.v.VisitStructStart(name)
+}
+
+func (f *filterVisitor) VisitStructEnd(name string) error {
+	if f.unhide() {
+		return nil
+	}
+	return f.v.VisitStructEnd(name)
+}
+
+// filterMapVisitor is the Visitor returned by FilterVisitor when the visitor it filters is a MapVisitor
+type filterMapVisitor struct {
+	*filterVisitor
+}
+
+func (f filterMapVisitor) VisitMapStart(name string, key, value WireType, length int) error {
+	if f.hide(name, WireMap) {
+		return nil
+	}
+	return f.v.(MapVisitor).VisitMapStart(name, key, value, length)
+}
+
+func (f filterMapVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	if f.hidden > 0 {
+		return body, ErrSkipVisit
+	}
+	return f.v.(MapVisitor).VisitMapKey(wire, body)
+}
+
+func (f filterMapVisitor) VisitMapEnd(name string) error {
+	if f.unhide() {
+		return nil
+	}
+	return f.v.(MapVisitor).VisitMapEnd(name)
+}
+
+// CountingVisitor tallies what a walk passes through, e.g. for metrics. It reads past every field it's given, so
+// it can be used on its own or in a chain.
+type CountingVisitor struct {
+	Fields  int // fields visited, not including structs, slices and maps
+	Structs int // structs visited, including slice elements
+	Slices  int // slices visited, including nested slices
+	Maps    int // maps visited
+	Bytes   int // bytes the field values and map keys visited take in the body, with their lengths and encodings
+}
+
+func (c *CountingVisitor) VisitFlags(flags byte) error { return nil }
+
+func (c *CountingVisitor) VisitSchemaHash(hash []byte) error { return nil }
+
+func (c *CountingVisitor) VisitField(name string, wire WireType, body Reader) (Reader, error) {
+	c.Fields++
+	c.count(&body, wire)
+	return body, nil
+}
+
+// count reads past a field or map key as the walker would skip it, adding the bytes it takes to Bytes
+func (c *CountingVisitor) count(body *Reader, wire WireType) {
+	left := body.BytesLeft()
+	skipField(body, wire)
+	c.Bytes += int(left - body.BytesLeft())
+}
+
+func (c *CountingVisitor) VisitArrayStart(name string, wire WireType, length int) error {
+	c.Slices++
+	return nil
+}
+
+func (c *CountingVisitor) VisitArrayEnd(name string) error { return nil }
+
+func (c *CountingVisitor) VisitStructStart(name string) error {
+	c.Structs++
+	return nil
+}
+
+func (c *CountingVisitor) VisitStructEnd(name string) error { return nil }
+
+func (c *CountingVisitor) VisitMapStart(name string, key, value WireType, length int) error {
+	c.Maps++
+	return nil
+}
+
+func (c *CountingVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	c.count(&body, wire)
+	return body, nil
+}
+
+func (c *CountingVisitor) VisitMapEnd(name string) error { return nil }
+
+// TypedVisitor walks a document as a Visitor does, but is handed the value of each field rather than the body to
+// read it from, so it needs no switch on wire types of its own. Walk with one by wrapping it in DecodingVisitor, and
+// embed NopTypedVisitor to implement only the callbacks of interest.
+//
+// Values go to the callback for their kind: every signed integer to VisitInt, every unsigned integer to VisitUint
+// and both float sizes to VisitFloat, whatever width and encoding they were written with. Nil pointers go to
+// VisitNil. Slices are passed element by element, with no name, between VisitArrayStart and VisitArrayEnd. Maps are
+// passed likewise to visitors that are also a TypedMapVisitor, and skipped otherwise.
+//
+// Strings and bytes may refer to the document, so they're only valid for the duration of the call.
+type TypedVisitor interface {
+	VisitFlags(flags byte) error
+	VisitSchemaHash(hash []byte) error
+	VisitArrayStart(name string, wire WireType, length int) error
+	VisitArrayEnd(name string) error
+	VisitStructStart(name string) error
+	VisitStructEnd(name string) error
+
+	VisitNil(name string, wire WireType) error
+	VisitBool(name string, value bool) error
+	VisitInt(name string, value int64) error
+	VisitUint(name string, value uint64) error
+	VisitFloat(name string, value float64) error
+	VisitString(name, value string) error
+	VisitBytes(name string, value []byte) error
+	VisitTime(name string, value time.Time) error
+	VisitDuration(name string, value time.Duration) error
+	VisitAddr(name string, value netip.Addr) error
+	VisitPrefix(name string, value netip.Prefix) error
+	VisitBigInt(name string, value *big.Int) error
+	VisitDecimal(name string, value Decimal) error
+}
+
+// TypedMapVisitor is implemented by TypedVisitors that walk into map fields, as a MapVisitor does. Each value
+// follows its key, which is passed to VisitMapKey as its callback would be passed it: an int64, uint64, float64,
+// string and so on.
+type TypedMapVisitor interface {
+	VisitMapStart(name string, key, value WireType, length int) error
+	VisitMapKey(key any) error
+	VisitMapEnd(name string) error
+}
+
+// DecodingVisitor returns a visitor that reads every field and passes its value to the matching callback of v.
+// Returning ErrSkipVisit from a value callback has no effect, as the value has already been read.
+func DecodingVisitor(v TypedVisitor) Visitor {
+	if _, ok := v.(TypedMapVisitor); ok {
+		return decodingMapVisitor{decodingVisitor{v}}
+	}
+	return decodingVisitor{v}
+}
+
+// decodingVisitor is the Visitor returned by DecodingVisitor
+type decodingVisitor struct {
+	TypedVisitor
+}
+
+// decodingMapVisitor is the Visitor returned by DecodingVisitor when v is a TypedMapVisitor
+type decodingMapVisitor struct {
+	decodingVisitor
+}
+
+func (d decodingMapVisitor) VisitMapStart(name string, key, value WireType, length int) error {
+	return d.TypedVisitor.(TypedMapVisitor).VisitMapStart(name, key, value, length)
+}
+
+func (d decodingMapVisitor) VisitMapKey(wire WireType, body Reader) (Reader, error) {
+	return body, d.skipped(d.TypedVisitor.(TypedMapVisitor).VisitMapKey(readVisitValue(wire, &body)))
+}
+
+func (d decodingMapVisitor) VisitMapEnd(name string) error {
+	return d.TypedVisitor.(TypedMapVisitor).VisitMapEnd(name)
+}
+
+func (d decodingVisitor) VisitField(name string, wire WireType, body Reader) (Reader, error) {
+	if wire&WirePtrFlag > 0 && body.ReadByte() == 0 {
+		return body, d.skipped(d.VisitNil(name, wire&^WirePtrFlag))
+	}
+	wire &^= WirePtrFlag
+
+	if wire == WireDynamic {
+		return body, d.skipped(d.dynamic(name, &body))
+	}
+
+	// the walker passes slices that are written as a whole in one piece, see isEncodedSlice
+	if isEncodedSlice(wire) {
+		var values []any
+		switch elem := wire & WireTypeMask; {
+		case wire&WireBitmapFlag > 0:
+			for _, b := range body.ReadBitmap() {
+				values = append(values, b)
+			}
+		case wire&WireSparseFlag > 0:
+			values = templateSparse(elem, &body)
+		default:
+			values = templateDelta(elem, &body)
+		}
+
+		if err := d.VisitArrayStart(name, wire, len(values)); err != nil {
+			return body, d.skipped(err)
+		}
+		for _, value := range values {
+			if err := d.value("", visitValue(value)); err != nil {
+				return body, d.skipped(err)
+			}
+		}
+		return body, d.skipped(d.VisitArrayEnd(name))
+	}
+
+	return body, d.skipped(d.value(name, readVisitValue(wire, &body)))
+}
+
+// dynamic reads an element of a slice of any type, see dynamic.go, and passes it to the callback for its type, as
+// an array when it's a slice itself
+func (d decodingVisitor) dynamic(name string, r *Reader) error {
+	wire, value := readDynamic(r)
+	switch v := value.(type) {
+	case nil:
+		return d.VisitNil(name, wire)
+
+	case []any:
+		if err := d.VisitArrayStart(name, wire, len(v)); err != nil {
+			return err
+		}
+		for _, elem := range v {
+			if err := d.value("", visitValue(elem)); err != nil {
+				return err
+			}
+		}
+		return d.VisitArrayEnd(name)
+	}
+	return d.value(name, visitValue(value))
+}
+
+// readVisitValue reads a field the walker visits, other than a slice of isEncodedSlice, as the value a TypedVisitor
+// is passed
+func readVisitValue(wire WireType, r *Reader) any {
+	switch wire {
+	case WireString | WireDictFlag:
+		return r.ReadDictString()
+	case WireInt64 | WireZigzagFlag:
+		return r.ReadZigzagInt64()
+	case WireDuration | WireZigzagFlag:
+		return time.Duration(r.ReadZigzagInt64())
+	}
+	return visitValue(templatePrimitive(wire, r))
+}
+
+// visitValue widens a value read by templatePrimitive to the type a TypedVisitor is passed
+func visitValue(value any) any {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case uint:
+		return uint64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
+
+// value passes a value from visitValue to the callback for its type
+func (d decodingVisitor) value(name string, value any) error {
+	switch v := value.(type) {
+	case bool:
+		return d.VisitBool(name, v)
+	case int64:
+		return d.VisitInt(name, v)
+	case uint64:
+		return d.VisitUint(name, v)
+	case float64:
+		return d.VisitFloat(name, v)
+	case string:
+		return d.VisitString(name, v)
+	case []byte:
+		return d.VisitBytes(name, v)
+	case time.Time:
+		return d.VisitTime(name, v)
+	case time.Duration:
+		return d.VisitDuration(name, v)
+	case netip.Addr:
+		return d.VisitAddr(name, v)
+	case netip.Prefix:
+		return d.VisitPrefix(name, v)
+	case *big.Int:
+		return d.VisitBigInt(name, v)
+	case Decimal:
+		return d.VisitDecimal(name, v)
+	}
+	panic(fmt.Sprintf("unsupported value %T", value))
+}
+
+// skipped drops ErrSkipVisit, as the walker would otherwise skip a field that's already been read
+func (d decodingVisitor) skipped(err error) error {
+	if err == ErrSkipVisit {
+		return nil
+	}
+	return err
+}
+
+// NopTypedVisitor implements every callback of TypedVisitor by doing nothing, for embedding in visitors that only
+// need some of them.
+type NopTypedVisitor struct{}
+
+func (NopTypedVisitor) VisitFlags(flags byte) error                                      { return nil }
+func (NopTypedVisitor) VisitSchemaHash(hash []byte) error                                { return nil }
+func (NopTypedVisitor) VisitArrayStart(name string, wire WireType, length int) error     { return nil }
+func (NopTypedVisitor) VisitArrayEnd(name string) error                                  { return nil }
+func (NopTypedVisitor) VisitStructStart(name string) error                               { return nil }
+func (NopTypedVisitor) VisitStructEnd(name string) error                                 { return nil }
+func (NopTypedVisitor) VisitMapStart(name string, key, value WireType, length int) error { return nil }
+func (NopTypedVisitor) VisitMapKey(key any) error                                        { return nil }
+func (NopTypedVisitor) VisitMapEnd(name string) error                                    { return nil }
+func (NopTypedVisitor) VisitNil(name string, wire WireType) error                        { return nil }
+func (NopTypedVisitor) VisitBool(name string, value bool) error                          { return nil }
+func (NopTypedVisitor) VisitInt(name string, value int64) error                          { return nil }
+func (NopTypedVisitor) VisitUint(name string, value uint64) error                        { return nil }
+func (NopTypedVisitor) VisitFloat(name string, value float64) error                      { return nil }
+func (NopTypedVisitor) VisitString(name, value string) error                             { return nil }
+func (NopTypedVisitor) VisitBytes(name string, value []byte) error                       { return nil }
+func (NopTypedVisitor) VisitTime(name string, value time.Time) error                     { return nil }
+func (NopTypedVisitor) VisitDuration(name string, value time.Duration) error             { return nil }
+func (NopTypedVisitor) VisitAddr(name string, value netip.Addr) error                    { return nil }
+func (NopTypedVisitor) VisitPrefix(name string, value netip.Prefix) error                { return nil }
+func (NopTypedVisitor) VisitBigInt(name string, value *big.Int) error                    { return nil }
+func (NopTypedVisitor) VisitDecimal(name string, value Decimal) error                    { return nil }
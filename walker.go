@@ -2,7 +2,9 @@ package glint
 
 import (
 	"errors"
-	"unsafe"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 // Visitor is an interface that can be implemented to walk a document
@@ -16,6 +18,15 @@ type Visitor interface {
 	VisitStructEnd(name string) error
 }
 
+// MapVisitor is implemented by visitors that walk into map fields. Each key is passed to VisitMapKey, then its value
+// is walked as a slice element would be, with no name, all between VisitMapStart and VisitMapEnd. Maps are skipped
+// over without being visited when the visitor doesn't implement it.
+type MapVisitor interface {
+	VisitMapStart(name string, key, value WireType, length int) error
+	VisitMapKey(wire WireType, body Reader) (Reader, error)
+	VisitMapEnd(name string) error
+}
+
 func Walk(doc []byte, visitor Visitor) error {
 	w := NewWalker(doc)
 	return w.Walk(visitor)
@@ -24,6 +35,27 @@ func Walk(doc []byte, visitor Visitor) error {
 // Walker walks a document
 type Walker struct {
 	r Reader
+
+	at       []walkStep     // the field being visited and those it's within, see Path
+	selector []selectorStep // the fields to visit, nil for all of them, see WalkSelect
+	exact    bool           // the selector has no wildcards, so can only match one field
+	done     bool           // an exact selector has been matched, so there's nothing left to visit
+}
+
+// walkStep is a field, an element of a slice or an entry of a map on the path to the field being visited
+type walkStep struct {
+	name  string
+	index int // the index of a slice element, -1 for a field or map entry
+
+	entry   bool     // a map entry, whose key is read from key when needed
+	keyWire WireType // wire type of the key
+	key     Reader   // body positioned at the key
+}
+
+// keyString returns the key of a map entry as Path writes it
+func (s walkStep) keyString() string {
+	r := s.key
+	return fmt.Sprint(readVisitValue(s.keyWire, &r))
 }
 
 // NewWalker creates a new walker
@@ -31,58 +63,260 @@ func NewWalker(doc []byte) Walker {
 	return Walker{r: NewReader(doc)}
 }
 
-// Walk walks the document
-func (w *Walker) Walk(visitor Visitor) error {
+// Walk walks the document. Malformed and truncated documents return an error wrapping ErrInvalidDocument rather
+// than panicking, along with anything the visitor panics with.
+func (w *Walker) Walk(visitor Visitor) (err error) {
+	defer recoverErr(&err)
+
+	p, err := splitDocument(w.r.Remaining())
+	if err != nil {
+		return err
+	}
+	if err := p.inlineSchema(DefaultLimits); err != nil {
+		return err
+	}
+	if err := checkNesting(p.schema, DefaultLimits); err != nil {
+		return err
+	}
 
-	visitor.VisitFlags(w.r.ReadByte())
-	visitor.VisitSchemaHash(w.r.Read(4))
+	visitor.VisitFlags(p.flags)
+	visitor.VisitSchemaHash(p.hash)
 
-	schema := NewReader(w.r.Read(w.r.ReadVarint()))
-	body := NewReader(w.r.Remaining())
-	_, body = w.walk(visitor, schema, body)
+	_, body := w.walk(visitor, p.schema, p.body)
 
-	if body.BytesLeft() > 0 {
-		panic("underparse")
+	if body.BytesLeft() > 0 && !w.done {
+		return fmt.Errorf("%w: body bytes remaining > 0: %v", ErrInvalidDocument, body.BytesLeft())
 	}
 
 	return nil
 }
 
+// WalkSelect walks the fields of a document that a selector matches, see Walker.WalkSelect
+func WalkSelect(doc []byte, visitor Visitor, selector string) error {
+	w := NewWalker(doc)
+	return w.WalkSelect(visitor, selector)
+}
+
+// WalkSelect walks the document as Walk does, but only visits the fields a selector matches, along with everything
+// within them. Selectors are paths as Path returns them, in which * matches any field, slice element or map value,
+// e.g. users.*.email, users[*].email, users[2].* or scores[level1]. The structs and slices leading to a match are visited too, so the
+// visitor sees where it is, while everything else is skipped over without being read.
+//
+// A selector without wildcards matches a single field at most, and the walk ends as soon as it's been visited.
+func (w *Walker) WalkSelect(visitor Visitor, selector string) error {
+	steps, err := parseSelector(selector)
+	if err != nil {
+		return err
+	}
+
+	w.selector, w.exact = steps, true
+	for _, s := range steps {
+		w.exact = w.exact && !s.any
+	}
+	return w.Walk(visitor)
+}
+
+// Path returns the path of the field being visited, within the visitor's callbacks, e.g. users[2].address.city.
+// Slice elements are written as their index and map values as their key, quoted when it holds a dot, bracket or
+// quote, e.g. scores[level1] or scores["a.b"]. During VisitStructStart, VisitArrayStart, VisitMapStart and their
+// ends it's the path of the struct, slice or map itself, and during VisitMapKey that of the key's value. Slices written with the delta, sparse or bitmap options are a single field, so
+// anything decoding their elements sees the slice's path for each.
+func (w *Walker) Path() string {
+	return formatPath(w.at)
+}
+
+// formatPath writes the steps of a path as Path returns them
+func formatPath(at []walkStep) string {
+	var b []byte
+	for _, s := range at {
+		if s.entry {
+			key := s.keyString()
+			if key == "" || strings.ContainsAny(key, `.[]"`) {
+				key = strconv.Quote(key)
+			}
+			b = append(b, '[')
+			b = append(b, key...)
+			b = append(b, ']')
+			continue
+		}
+		if s.index >= 0 {
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(s.index), 10)
+			b = append(b, ']')
+			continue
+		}
+		if len(b) > 0 {
+			b = append(b, '.')
+		}
+		b = append(b, s.name...)
+	}
+	return string(b)
+}
+
+// selectorStep is one step of a selector, matching a field by name, a slice element by index, a map value by key
+// or, for *, any of them
+type selectorStep struct {
+	name  string // empty for a bracketed step
+	index int    // -1 for a field, or a key that isn't a number
+	key   string // the contents of a bracketed step
+	any   bool
+}
+
+// matches reports whether a step of the path being walked matches the selector
+func (s selectorStep) matches(at walkStep) bool {
+	switch {
+	case s.any:
+		return true
+	case at.entry:
+		return s.name == "" && s.key == at.keyString()
+	case at.index >= 0:
+		return s.index == at.index
+	}
+	return s.name == at.name
+}
+
+// parseSelector splits a selector into its steps, see WalkSelect
+func parseSelector(selector string) ([]selectorStep, error) {
+	invalid := fmt.Errorf("invalid selector %q", selector)
+
+	var steps []selectorStep
+	for s := selector; ; {
+		if strings.HasPrefix(s, "[") {
+			key, wild := s[1:], false
+			if strings.HasPrefix(key, `"`) {
+				quoted, err := strconv.QuotedPrefix(key)
+				if err != nil || !strings.HasPrefix(key[len(quoted):], "]") {
+					return nil, invalid
+				}
+				s = key[len(quoted)+1:]
+				key, _ = strconv.Unquote(quoted)
+			} else {
+				end := strings.IndexByte(key, ']')
+				if end < 0 {
+					return nil, invalid
+				}
+				key, s = key[:end], key[end+1:]
+				wild = key == "*"
+			}
+
+			step := selectorStep{index: -1, key: key, any: wild}
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 {
+				step.index = i
+			}
+			steps = append(steps, step)
+		} else {
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, invalid
+			}
+			steps = append(steps, selectorStep{name: s[:end], index: -1, any: s[:end] == "*"})
+			s = s[end:]
+		}
+
+		switch {
+		case s == "":
+			return steps, nil
+		case s[0] == '.':
+			if s = s[1:]; s == "" || s[0] == '[' {
+				return nil, invalid
+			}
+		case s[0] != '[':
+			return nil, invalid
+		}
+	}
+}
+
+// selects reports whether the field being visited leads to the selector, or is within a field it matched
+func (w *Walker) selects() bool {
+	return selects(w.selector, w.at)
+}
+
+// selects reports whether the path at leads to the selector, or is within a field it matched
+func selects(selector []selectorStep, at []walkStep) bool {
+	for i := range at {
+		if i == len(selector) {
+			return true
+		}
+		if !selector[i].matches(at[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // ErrSkipVisit is returned by a visitor to indicate that the walker should skip visiting the current field
 var ErrSkipVisit = errors.New("skip visit")
 
 // walk walks the schema and body in parallel, calling the visitor as it goes.
 func (w *Walker) walk(visitor Visitor, schema, body Reader) (Reader, Reader) {
-	for schema.BytesLeft() > 0 {
+	for schema.BytesLeft() > 0 && !w.done {
 
 		typeID := WireType(schema.ReadVarint())
-		nameb := schema.Read(schema.ReadVarint())
-		name := *(*string)(unsafe.Pointer(&nameb)) //avoids allocation of a new string for each field
+		nameb := schema.Read(uint(schema.ReadByte()))
+		name := bytesToString(nameb) //avoids allocation of a new string for each field
 
-		// do we need to do something more specific here?
-		// sigh
+		w.at = append(w.at, walkStep{name: name, index: -1})
 		var ok bool
-		schema, body, ok = w.walkSubschema(typeID, schema, body, visitor, name)
-		if ok {
-			continue
+		schema, body, ok = w.walkValue(visitor, name, typeID, schema, body)
+		w.at = w.at[:len(w.at)-1]
+		if !ok {
+			return schema, body
 		}
+	}
 
-		var err error
-		body, err = visitor.VisitField(name, typeID, body) // no, just a normal field
-		switch err {
-		case ErrSkipVisit:
-			fieldBytes(&body, typeID) // allows the visitor to return an error an we skip over the field
+	return schema, body
+}
 
-			// if we don't read the field here, everything that comes after this will break, including reading
-			// array lengths etc.
+// walkValue walks a field, slice element or map value, or skips over it when it's outside the selector. It returns
+// false when the visitor returned an error for a field.
+func (w *Walker) walkValue(visitor Visitor, name string, typeID WireType, schema, body Reader) (Reader, Reader, bool) {
+	if w.selector != nil && !w.selects() {
+		t := parseSchemaNode(typeID, &schema)
+		skipValue(typeID, &t, &body)
+		return schema, body, true
+	}
 
-		case nil:
-		default:
-			return schema, body
+	// composite fields that can be nil carry a presence byte, nil values aren't visited - their schema is
+	// just stepped over so the rest of the document stays aligned
+	if typeID&WirePtrFlag > 0 && (isCompositeWire(typeID) || isSliceWire(typeID)) {
+		if body.ReadByte() == 0 {
+			parseSchemaNode(typeID, &schema)
+			return schema, body, true
 		}
+		typeID ^= WirePtrFlag
 	}
 
-	return schema, body
+	// do we need to do something more specific here?
+	// sigh
+	var ok bool
+	if schema, body, ok = w.walkSubschema(typeID, schema, body, visitor, name); !ok {
+		body, ok = w.visitField(visitor, name, typeID, body) // no, just a normal field
+	}
+
+	if w.exact && len(w.at) == len(w.selector) {
+		w.done = true
+	}
+	return schema, body, ok
+}
+
+// visitField passes a field to the visitor, returning false when the visitor returned an error
+func (w *Walker) visitField(visitor Visitor, name string, typeID WireType, body Reader) (Reader, bool) {
+	body, err := visitor.VisitField(name, typeID, body)
+	switch err {
+	case ErrSkipVisit:
+		skipField(&body, typeID) // allows the visitor to return an error an we skip over the field
+
+		// if we don't read the field here, everything that comes after this will break, including reading
+		// array lengths etc.
+
+	case nil:
+	default:
+		return body, false
+	}
+	return body, true
 }
 
 // walkSubschema walks a subschema, calling the visitor as it goes.
@@ -92,56 +326,102 @@ func (w *Walker) walkSubschema(typeID WireType, schema, body Reader, visitor Vis
 		schema, body = w.walkStruct(visitor, name, schema, body)
 		return schema, body, true
 
-	case typeID&WireSliceFlag > 0:
+	case typeID&WireSliceFlag > 0 && !isEncodedSlice(typeID):
 		schema, body = w.walkArray(visitor, name, typeID, schema, body)
 		return schema, body, true
 
 	case typeID == WireMap:
+		if mv, ok := visitor.(MapVisitor); ok {
+			schema, body = w.walkMap(visitor, mv, name, schema, body)
+		} else {
+			t := parseSchemaNode(typeID, &schema)
+			skipValue(typeID, &t, &body)
+		}
 		return schema, body, true
 	}
 	return schema, body, false
 }
 
-// walkArray walks an array, calling the visitor as it goes.
-func (w *Walker) walkArray(visitor Visitor, name string, typeID WireType, schema, body Reader) (Reader, Reader) {
-	visitor.VisitArrayStart(name, WireType(typeID), 0) // start of a slice
-	name = ""
-
-	switch typeID {
-	case WireSliceFlag:
-		nextLevelType := WireType(schema.ReadVarint())
+// walkMap walks a map, calling the visitor as it goes, see MapVisitor. mv is the visitor's map callbacks.
+func (w *Walker) walkMap(visitor Visitor, mv MapVisitor, name string, schema, body Reader) (Reader, Reader) {
+	keyWire, valueWire := WireType(schema.ReadVarint()), WireType(schema.ReadVarint())
+	key := parseSchemaNode(keyWire, &schema)
+	valueSchema := schema // we need to reset the schema for each value in the map
+	parseSchemaNode(valueWire, &schema)
 
-		// read the length of the slice
-		length := body.ReadVarint()
+	length := readMapLength(&body, DefaultLimits.MaxMapEntries)
+	mv.VisitMapStart(name, keyWire, valueWire, int(length)) // start of a map
 
-		first := schema
+	for i, ok := uint(0), true; i < length && ok && !w.done; i++ {
+		w.at = append(w.at, walkStep{index: -1, entry: true, keyWire: keyWire, key: body})
 
-		schema, body = w.walkArray(visitor, name, nextLevelType, schema, body)
-		for i := uint(1); i < length; i++ {
-			_, body = w.walkArray(visitor, name, nextLevelType, first, body)
+		if w.selector != nil && !w.selects() {
+			skipValue(keyWire, &key, &body)
+			_, body, _ = w.walkValue(visitor, "", valueWire, valueSchema, body)
+		} else if body, ok = w.visitMapKey(mv, &key, body); ok {
+			_, body, ok = w.walkValue(visitor, "", valueWire, valueSchema, body)
 		}
 
+		w.at = w.at[:len(w.at)-1]
+	}
+
+	mv.VisitMapEnd(name) // end of a map
+	return schema, body
+}
+
+// visitMapKey passes a map key to the visitor, returning false when the visitor returned an error
+func (w *Walker) visitMapKey(visitor MapVisitor, key *schemaNode, body Reader) (Reader, bool) {
+	body, err := visitor.VisitMapKey(key.wire, body)
+	switch err {
+	case ErrSkipVisit:
+		skipValue(key.wire, key, &body)
+	case nil:
 	default:
-		typeID = WireType(typeID & WireTypeMask)
+		return body, false
+	}
+	return body, true
+}
 
-		// read the length of the slice
-		length := body.ReadVarint()
+// walkArray walks an array, calling the visitor as it goes.
+func (w *Walker) walkArray(visitor Visitor, name string, typeID WireType, schema, body Reader) (Reader, Reader) {
+	// elements of structs, maps and slices are walked as they would be as fields, others are visited as fields,
+	// with no name
+	elem := typeID &^ WireSliceFlag
+	switch {
+	case typeID == WireSliceFlag:
+		elem = WireType(schema.ReadVarint())
+	case isCompositeWire(elem):
+		elem &= WireTypeMask
+	}
 
-		// if length == 0 {
-		// 	break
-		// }
+	// read the length of the slice
+	length := body.ReadVarint()
+	checkWalkLength(length, body, elem != WireStruct)
 
-		first := schema // we need to reset the schema for each element in the array
-		schema, body, _ = w.walkSubschema(typeID, schema, body, visitor, name)
-		for i := uint(1); i < length; i++ {
-			_, body, _ = w.walkSubschema(typeID, first, body, visitor, name)
-		}
+	visitor.VisitArrayStart(name, WireType(typeID), int(length)) // start of a slice
+	name = ""
+
+	first := schema // we need to reset the schema for each element in the array
+	parseSchemaNode(elem, &schema)
+	for i, ok := uint(0), true; i < length && ok && !w.done; i++ {
+		w.at = append(w.at, walkStep{index: int(i)})
+		_, body, ok = w.walkValue(visitor, name, elem, first, body)
+		w.at = w.at[:len(w.at)-1]
 	}
 
 	visitor.VisitArrayEnd(name) // end of a slice
 	return schema, body
 }
 
+// checkWalkLength bounds the length of a slice being walked, so that a corrupt length can't keep the walker busy.
+// Only structs with no fields take no bytes, every other element takes at least one.
+func checkWalkLength(length uint, body Reader, sized bool) {
+	checkLimit(length, DefaultLimits.MaxSliceElements, "slice")
+	if sized && length > body.BytesLeft() {
+		panic(fmt.Errorf("%w: slice length %d exceeds remaining bytes %d", ErrTruncatedDocument, length, body.BytesLeft()))
+	}
+}
+
 // walkStruct walks a struct, calling the visitor as it goes.
 func (w *Walker) walkStruct(visitor Visitor, name string, schema, body Reader) (Reader, Reader) {
 	visitor.VisitStructStart(name) // start of a struct
@@ -154,6 +434,27 @@ func (w *Walker) walkStruct(visitor Visitor, name string, schema, body Reader) (
 	return schema, body
 }
 
+// isCompositeWire reports whether a wire type is walked as a struct, slice or map rather than visited as a field
+func isCompositeWire(wire WireType) bool {
+	base := wire &^ (WirePtrFlag | WireDeltaFlag | WireSparseFlag | WireDictFlag | WireBitmapFlag | WireZigzagFlag)
+	return base&WireSliceFlag > 0 || base == WireStruct || base == WireMap
+}
+
+// isEncodedSlice reports whether wire is a slice written as a whole rather than element by element, which the walker
+// visits as a single field
+func isEncodedSlice(wire WireType) bool {
+	return wire&WireSliceFlag > 0 && wire&(WireDeltaFlag|WireSparseFlag|WireBitmapFlag) > 0
+}
+
+// skipField reads past a field the walker visits, including pointers and the slices of isEncodedSlice
+func skipField(body *Reader, typeID WireType) {
+	if isEncodedSlice(typeID) || typeID&WirePtrFlag > 0 {
+		skipValue(typeID, &schemaNode{wire: typeID, elem: &schemaNode{wire: typeID & WireTypeMask}}, body)
+		return
+	}
+	fieldBytes(body, typeID)
+}
+
 // fieldBytes returns the raw bytes that represent a field of a given wire type
 func fieldBytes(body *Reader, typeID WireType) []byte {
 
@@ -163,14 +464,20 @@ func fieldBytes(body *Reader, typeID WireType) []byte {
 
 	case WireInt, WireInt16, WireInt32, WireInt64,
 		WireUint, WireUint16, WireUint32, WireUint64,
-		WireFloat32, WireFloat64:
+		WireFloat32, WireFloat64, WireDuration, WireString | WireDictFlag,
+		WireInt64 | WireZigzagFlag, WireDuration | WireZigzagFlag:
 
 		body.SetMark()
 		body.SkipVarint()
 		return body.BytesFromMark()
 
-	case WireString, WireBytes, WireTime:
+	case WireString, WireBytes, WireTime, WireIP, WireIPPrefix, WireBigInt, WireDecimal:
 		return body.Read(body.ReadVarint())
+
+	case WireDynamic:
+		body.SetMark()
+		readDynamic(body)
+		return body.BytesFromMark()
 	}
 
 	length := body.ReadVarint()
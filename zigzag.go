@@ -0,0 +1,98 @@
+package glint
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// int, int16 and int32 values are always written as zigzag varints, so small negative numbers take as few bytes as
+// small positive ones. int64 and time.Duration values are written as plain varints of their two's complement bits
+// instead, which takes ten bytes for any negative number. Fields of those types, and slices and arrays of them,
+// tagged `zigzag` set WireZigzagFlag in their schema and are written as zigzag varints too. The tag changes nothing
+// for the other integer types, and for slices written with the `delta` or `sparse` options, whose layouts have
+// their own.
+//
+// Encoders built WithZigzagInts write every one of those fields this way, other than fields tagged `nozigzag`.
+// Decoders read either form into any int64 or time.Duration field, and don't need the tag themselves.
+
+// WithZigzagInts writes every int64 and time.Duration field, and every slice and array of them, as zigzag varints,
+// as though they were tagged `zigzag`. Fields tagged `nozigzag` keep the plain varints.
+func WithZigzagInts() EncoderOption {
+	return func(c *encoderConfig) {
+		c.zigzagInts = true
+	}
+}
+
+// zigzagField reports whether a field with the supplied options should be written as zigzag varints
+func (c encoderConfig) zigzagField(opts tagOptions) bool {
+	return opts.Contains("zigzag") || (c.zigzagInts && !opts.Contains("nozigzag"))
+}
+
+// zigzagSliceField reports whether a slice or array field with the supplied options should be written as zigzag
+// varints. Only slices of int64s and durations held directly by the field are, and only when they weren't given a
+// layout of their own.
+func (c encoderConfig) zigzagSliceField(opts tagOptions, pointerWrap bool, t reflect.Type, s *SliceEncoder) bool {
+	return c.zigzagField(opts) && !pointerWrap && t.Elem().Kind() == reflect.Int64 &&
+		s.wire&(WireDeltaFlag|WireSparseFlag) == 0
+}
+
+// zigzagInt64Append writes an int64 as a zigzag varint
+func zigzagInt64Append(p unsafe.Pointer, b *Buffer) {
+	b.AppendZigzagInt64(*(*int64)(p))
+}
+
+// zigzagInt64Read reads an int64 written as a zigzag varint
+func zigzagInt64Read(p unsafe.Pointer, r Reader) Reader {
+	*(*int64)(p) = r.ReadZigzagInt64()
+	return r
+}
+
+// zigzagSlice switches a slice encoder over to zigzag varints. Only slices of int64s and durations held directly by
+// a struct field can be written this way, as with sparse slices.
+func zigzagSlice(s *SliceEncoder, t reflect.Type) {
+	if t.Elem().Kind() != reflect.Int64 {
+		panic("zigzag option requires an int64 or time.Duration, or a slice or array of them")
+	}
+	if s.wire&(WireDeltaFlag|WireSparseFlag) > 0 {
+		panic("zigzag option can't be combined with delta or sparse")
+	}
+
+	s.wire |= WireZigzagFlag
+	s.instruction = func(p unsafe.Pointer, b *Buffer) {
+		v := *(*[]int64)(p)
+		b.AppendUint(uint(len(v)))
+		for i := range v {
+			b.AppendZigzagInt64(v[i])
+		}
+	}
+}
+
+// zigzagSliceReader reads a slice of int64s written as zigzag varints
+func zigzagSliceReader(limits DecodeLimits) func(unsafe.Pointer, Reader) Reader {
+	return func(p unsafe.Pointer, r Reader) Reader {
+		l := r.ReadVarint()
+		checkLimit(l, limits.MaxSliceElements, "slice")
+		if l > r.BytesLeft() { // every varint takes at least a byte
+			panic(fmt.Errorf("%w: slice length %d exceeds remaining bytes %d", ErrTruncatedDocument, l, r.BytesLeft()))
+		}
+
+		s := *(*[]int64)(p)
+		if s == nil || uint(cap(s)) < l {
+			c := l
+			if c == 0 {
+				c = 1 // empty slices decode as non-nil, as they do for other slices
+			}
+			s = make([]int64, l, c)
+		} else {
+			s = s[:l]
+		}
+
+		for i := range s {
+			s[i] = r.ReadZigzagInt64()
+		}
+
+		*(*[]int64)(p) = s
+		return r
+	}
+}